@@ -3,7 +3,7 @@ package handlers
 import (
 	"io"
 	"net/http"
-	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/jmpsec/osctrl/cmd/admin/sessions"
@@ -134,12 +134,14 @@ func (h *HandlersAdmin) CarvesDownloadHandler(w http.ResponseWriter, r *http.Req
 			log.Err(err).Msgf("error archiving carve")
 		}
 	}
-	archived = &carves.CarveResult{
-		Size: int64(carve.CarveSize),
-		File: carve.ArchivePath,
+	if archived != nil {
+		carve.Archived = true
+		carve.ArchivePath = archived.File
 	}
 	log.Debug().Msg("Initiating carve download")
-	if h.Carves.Carver == config.CarverS3 {
+	// Encrypted carves are never redirected to S3, the sealed archive is
+	// decrypted while streaming it back
+	if h.Carves.Carver == config.CarverS3 && !carve.Encrypted {
 		downloadURL, err := h.Carves.S3.GetDownloadLink(carve)
 		if err != nil {
 			log.Err(err).Msg("error getting carve link")
@@ -147,11 +149,15 @@ func (h *HandlersAdmin) CarvesDownloadHandler(w http.ResponseWriter, r *http.Req
 		}
 		http.Redirect(w, r, downloadURL, http.StatusFound)
 	} else {
+		fileReader, size, err := h.Carves.OpenArchive(carve, carve.ArchivePath)
+		if err != nil {
+			log.Err(err).Msg("error opening carve archive")
+			return
+		}
+		defer fileReader.Close()
 		// Send response
-		utils.HTTPDownload(w, "File Carve Download", archived.File, archived.Size)
+		utils.HTTPDownload(w, "File Carve Download", filepath.Base(carve.ArchivePath), size)
 		w.WriteHeader(http.StatusOK)
-		var fileReader io.Reader
-		fileReader, _ = os.Open(archived.File)
 		_, _ = io.Copy(w, fileReader)
 	}
 	// Audit log visit
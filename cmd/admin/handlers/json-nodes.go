@@ -115,7 +115,7 @@ func (h *HandlersAdmin) JSONEnvironmentPagingHandler(w http.ResponseWriter, r *h
 	if length > 0 {
 		page = (start / length) + 1
 	}
-	pageData, err := h.Nodes.GetByEnvPaged(env.Name, target, hours, searchValue, page, length, colName, desc, "")
+	pageData, err := h.Nodes.GetByEnvPaged(env.Name, target, hours, searchValue, page, length, colName, desc, "", nil)
 	if err != nil {
 		log.Err(err).Msg("error getting nodes page")
 		return
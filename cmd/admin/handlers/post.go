@@ -467,9 +467,13 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 		adminErrorResponse(w, "configuration is read-only", http.StatusForbidden, nil)
 		return
 	}
+	// Changes wait for the pending rollout, whose revisions nodes are served
+	if err := h.Envs.CheckNoPendingRollout(env.ID); err != nil {
+		adminErrorResponse(w, err.Error(), http.StatusConflict, err)
+		return
+	}
 	if c.ConfigurationB64 != "" {
 		// Base64 decode received configuration
-		// TODO verify configuration
 		configuration, err := base64.StdEncoding.DecodeString(c.ConfigurationB64)
 		if err != nil {
 			adminErrorResponse(w, "error decoding configuration", http.StatusInternalServerError, err)
@@ -481,8 +485,19 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 			adminErrorResponse(w, "error parsing configuration", http.StatusInternalServerError, err)
 			return
 		}
+		var parts map[string]json.RawMessage
+		if err := json.Unmarshal(configuration, &parts); err != nil {
+			adminErrorResponse(w, "error parsing configuration", http.StatusBadRequest, err)
+			return
+		}
+		msg, err := h.validateConfigParts(env, "configuration saved successfully", string(parts["options"]), string(parts["packs"]), string(parts["auto_table_construction"]))
+		if err != nil {
+			adminErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+			return
+		}
 		// Update configuration
-		if err := h.Envs.UpdateConfiguration(env.UUID, cnf); err != nil {
+		rev, err := h.Envs.UpdateConfigurationBy(env.UUID, cnf, ctx[sessions.CtxUser], "")
+		if err != nil {
 			adminErrorResponse(w, "error saving configuration", http.StatusInternalServerError, err)
 			return
 		}
@@ -491,32 +506,37 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 			adminErrorResponse(w, "error saving configuration parts", http.StatusInternalServerError, err)
 			return
 		}
-		h.AuditLog.ConfAction(ctx[sessions.CtxUser], "update configuration", strings.Split(r.RemoteAddr, ":")[0], env.ID)
+		h.AuditLog.ConfAction(ctx[sessions.CtxUser], fmt.Sprintf("update configuration (revision %d)", rev.Revision), strings.Split(r.RemoteAddr, ":")[0], env.ID)
 		// Send response
-		adminOKResponse(w, "configuration saved successfully")
+		adminOKResponse(w, msg)
 		return
 	}
 	if c.OptionsB64 != "" {
 		// Base64 decode received options
-		// TODO verify options
 		options, err := base64.StdEncoding.DecodeString(c.OptionsB64)
 		if err != nil {
 			adminErrorResponse(w, "error decoding options", http.StatusInternalServerError, err)
 			return
 		}
+		msg, err := h.validateConfigParts(env, "options saved successfully", string(options), "", "")
+		if err != nil {
+			adminErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+			return
+		}
 		// Update options
 		if err := h.Envs.UpdateOptions(env.UUID, string(options)); err != nil {
 			adminErrorResponse(w, "error saving options", http.StatusInternalServerError, err)
 			return
 		}
 		// Update full configuration
-		if err := h.Envs.RefreshConfiguration(env.UUID); err != nil {
+		rev, err := h.Envs.RefreshConfigurationBy(env.UUID, ctx[sessions.CtxUser], "")
+		if err != nil {
 			adminErrorResponse(w, "error updating configuration", http.StatusInternalServerError, err)
 			return
 		}
-		h.AuditLog.ConfAction(ctx[sessions.CtxUser], "update options", strings.Split(r.RemoteAddr, ":")[0], env.ID)
+		h.AuditLog.ConfAction(ctx[sessions.CtxUser], fmt.Sprintf("update options (revision %d)", rev.Revision), strings.Split(r.RemoteAddr, ":")[0], env.ID)
 		// Send response
-		adminOKResponse(w, "options saved successfully")
+		adminOKResponse(w, msg)
 		return
 	}
 	if c.ScheduleB64 != "" {
@@ -533,36 +553,42 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 			return
 		}
 		// Update full configuration
-		if err := h.Envs.RefreshConfiguration(env.UUID); err != nil {
+		rev, err := h.Envs.RefreshConfigurationBy(env.UUID, ctx[sessions.CtxUser], "")
+		if err != nil {
 			adminErrorResponse(w, "error updating configuration", http.StatusInternalServerError, err)
 			return
 		}
-		h.AuditLog.ConfAction(ctx[sessions.CtxUser], "update schedule", strings.Split(r.RemoteAddr, ":")[0], env.ID)
+		h.AuditLog.ConfAction(ctx[sessions.CtxUser], fmt.Sprintf("update schedule (revision %d)", rev.Revision), strings.Split(r.RemoteAddr, ":")[0], env.ID)
 		// Send response
 		adminOKResponse(w, "schedule saved successfully")
 		return
 	}
 	if c.PacksB64 != "" {
-		// TODO verify packs
 		// Base64 decode received packs
 		packs, err := base64.StdEncoding.DecodeString(c.PacksB64)
 		if err != nil {
 			adminErrorResponse(w, "error decoding packs", http.StatusInternalServerError, err)
 			return
 		}
+		msg, err := h.validateConfigParts(env, "packs saved successfully", "", string(packs), "")
+		if err != nil {
+			adminErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+			return
+		}
 		// Update packs
 		if err := h.Envs.UpdatePacks(env.UUID, string(packs)); err != nil {
 			adminErrorResponse(w, "error saving packs", http.StatusInternalServerError, err)
 			return
 		}
 		// Update full configuration
-		if err := h.Envs.RefreshConfiguration(env.UUID); err != nil {
+		rev, err := h.Envs.RefreshConfigurationBy(env.UUID, ctx[sessions.CtxUser], "")
+		if err != nil {
 			adminErrorResponse(w, "error updating configuration", http.StatusInternalServerError, err)
 			return
 		}
-		h.AuditLog.ConfAction(ctx[sessions.CtxUser], "update packs", strings.Split(r.RemoteAddr, ":")[0], env.ID)
+		h.AuditLog.ConfAction(ctx[sessions.CtxUser], fmt.Sprintf("update packs (revision %d)", rev.Revision), strings.Split(r.RemoteAddr, ":")[0], env.ID)
 		// Send response
-		adminOKResponse(w, "packs saved successfully")
+		adminOKResponse(w, msg)
 		return
 	}
 	if c.DecoratorsB64 != "" {
@@ -579,36 +605,42 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 			return
 		}
 		// Update full configuration
-		if err := h.Envs.RefreshConfiguration(env.UUID); err != nil {
+		rev, err := h.Envs.RefreshConfigurationBy(env.UUID, ctx[sessions.CtxUser], "")
+		if err != nil {
 			adminErrorResponse(w, "error updating configuration", http.StatusInternalServerError, err)
 			return
 		}
-		h.AuditLog.ConfAction(ctx[sessions.CtxUser], "update decorators", strings.Split(r.RemoteAddr, ":")[0], env.ID)
+		h.AuditLog.ConfAction(ctx[sessions.CtxUser], fmt.Sprintf("update decorators (revision %d)", rev.Revision), strings.Split(r.RemoteAddr, ":")[0], env.ID)
 		// Send response
 		adminOKResponse(w, "decorators saved successfully")
 		return
 	}
 	if c.ATCB64 != "" {
-		// TODO verify ATC
 		// Base64 decode received ATC
 		schedule, err := base64.StdEncoding.DecodeString(c.ATCB64)
 		if err != nil {
 			adminErrorResponse(w, "error decoding ATC", http.StatusInternalServerError, err)
 			return
 		}
+		msg, err := h.validateConfigParts(env, "ATC saved successfully", "", "", string(schedule))
+		if err != nil {
+			adminErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+			return
+		}
 		// Update ATC
 		if err := h.Envs.UpdateATC(env.UUID, string(schedule)); err != nil {
 			adminErrorResponse(w, "error saving ATC", http.StatusInternalServerError, err)
 			return
 		}
 		// Update full configuration
-		if err := h.Envs.RefreshConfiguration(env.UUID); err != nil {
+		rev, err := h.Envs.RefreshConfigurationBy(env.UUID, ctx[sessions.CtxUser], "")
+		if err != nil {
 			adminErrorResponse(w, "error updating configuration", http.StatusInternalServerError, err)
 			return
 		}
-		h.AuditLog.ConfAction(ctx[sessions.CtxUser], "update ATC", strings.Split(r.RemoteAddr, ":")[0], env.ID)
+		h.AuditLog.ConfAction(ctx[sessions.CtxUser], fmt.Sprintf("update ATC (revision %d)", rev.Revision), strings.Split(r.RemoteAddr, ":")[0], env.ID)
 		// Send response
-		adminOKResponse(w, "ATC saved successfully")
+		adminOKResponse(w, msg)
 		return
 	}
 	// If we are here, means that the request received was empty
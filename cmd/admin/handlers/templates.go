@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -876,8 +877,14 @@ func (h *HandlersAdmin) EnrollGETHandler(w http.ResponseWriter, r *http.Request)
 		OsqueryValues: h.OsqueryValues,
 	}
 	// Prepare template data
-	shellQuickAdd, _ := environments.QuickAddOneLinerShell((env.Certificate != ""), env)
-	powershellQuickAdd, _ := environments.QuickAddOneLinerPowershell((env.Certificate != ""), env)
+	shellQuickAdd, err := environments.QuickAddOneLinerShell((env.Certificate != ""), env)
+	if errors.Is(err, environments.ErrClientCertScript) {
+		shellQuickAdd = "# " + err.Error()
+	}
+	powershellQuickAdd, err := environments.QuickAddOneLinerPowershell((env.Certificate != ""), env)
+	if errors.Is(err, environments.ErrClientCertScript) {
+		powershellQuickAdd = "# " + err.Error()
+	}
 	shellQuickRemove, _ := environments.QuickRemoveOneLinerShell((env.Certificate != ""), env)
 	powershellQuickRemove, _ := environments.QuickRemoveOneLinerPowershell((env.Certificate != ""), env)
 	templateData := EnrollTemplateData{
@@ -969,22 +976,22 @@ func (h *HandlersAdmin) EnrollDownloadHandler(w http.ResponseWriter, r *http.Req
 		fName = "osctrl-" + env.Name + ".flags"
 	case settings.DownloadFlagsMac:
 		osxPath := "/private/var/osquery"
-		toDownload = []byte(h.generateFlags(env.Flags, osxPath+"/osctrl-"+env.Name+".secret", osxPath+"/osctrl-"+env.Name+".crt"))
+		toDownload = []byte(environments.PlatformFlags(env.Flags, env.Name, osxPath, "/"))
 		description = "osctrl flags for " + env.Name + " (macOS)"
 		fName = "osctrl-" + env.Name + ".flags"
 	case settings.DownloadFlagsWin:
 		winPath := "C:\\Program Files\\osquery"
-		toDownload = []byte(h.generateFlags(env.Flags, winPath+"\\osctrl-"+env.Name+".secret", winPath+"\\osctrl-"+env.Name+".crt"))
+		toDownload = []byte(environments.PlatformFlags(env.Flags, env.Name, winPath, "\\"))
 		description = "osctrl flags for " + env.Name + " (Windows)"
 		fName = "osctrl-" + env.Name + ".flags"
 	case settings.DownloadFlagsLinux:
 		lnxPath := "/etc/osquery"
-		toDownload = []byte(h.generateFlags(env.Flags, lnxPath+"/osctrl-"+env.Name+".secret", lnxPath+"/osctrl-"+env.Name+".crt"))
+		toDownload = []byte(environments.PlatformFlags(env.Flags, env.Name, lnxPath, "/"))
 		description = "osctrl flags for " + env.Name + " (Linux)"
 		fName = "osctrl-" + env.Name + ".flags"
 	case settings.DownloadFlagsFreeBSD:
 		bsdPath := "/usr/local/etc"
-		toDownload = []byte(h.generateFlags(env.Flags, bsdPath+"/osctrl-"+env.Name+".secret", bsdPath+"/osctrl-"+env.Name+".crt"))
+		toDownload = []byte(environments.PlatformFlags(env.Flags, env.Name, bsdPath, "/"))
 		description = "osctrl flags for " + env.Name + " (FreeBSD)"
 		fName = "osctrl-" + env.Name + ".flags"
 	}
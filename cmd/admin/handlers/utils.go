@@ -38,6 +38,26 @@ func adminOKResponse(w http.ResponseWriter, msg string) {
 	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, AdminResponse{Message: msg})
 }
 
+// Helper to validate options, packs and ATC against the known osquery flags
+// before saving them, returning the success message with any warnings
+func (h *HandlersAdmin) validateConfigParts(env environments.TLSEnvironment, msg, options, packs, atc string) (string, error) {
+	var versions []string
+	if h.Nodes != nil {
+		var err error
+		if versions, err = h.Nodes.GetEnvOsqueryVersions(env.ID); err != nil {
+			log.Err(err).Msgf("error getting osquery versions for env %s", env.Name)
+		}
+	}
+	validation := environments.ValidateConfigParts(options, packs, atc, versions)
+	if err := validation.Err(); err != nil {
+		return "", err
+	}
+	if warnings := validation.Messages(false); len(warnings) > 0 {
+		msg += " with warnings: " + strings.Join(warnings, "; ")
+	}
+	return msg, nil
+}
+
 // Helper to check if the CSRF token is valid
 func checkCSRFToken(ctxToken, receivedToken string) bool {
 	return (strings.TrimSpace(ctxToken) == strings.TrimSpace(receivedToken))
@@ -186,12 +206,6 @@ func (h *HandlersAdmin) allowedEnvironments(username string, allEnvs []environme
 	return envs
 }
 
-// Helper to generate flags with the correct paths for secret file and certificate
-func (h *HandlersAdmin) generateFlags(flagsRaw, secretFile, certFile string) string {
-	replaced := strings.Replace(flagsRaw, "__SECRET_FILE__", secretFile, 1)
-	return strings.Replace(replaced, "__CERT_FILE__", certFile, 1)
-}
-
 // Helper to generate the target string for on-demand queries and carves
 func genTargetString(envs, uuids, hosts, tags, platforms []string) string {
 	var target string
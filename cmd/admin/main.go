@@ -272,6 +272,14 @@ func osctrlAdminService() {
 	queriesmgr = queries.CreateQueries(db.Conn)
 	log.Info().Msg("Initialize carves")
 	carvesmgr = carves.CreateFileCarves(db.Conn, flagParams.Carver.Type, carvers3)
+	if flagParams.Carver.KeyFile != "" {
+		carveKeys, err := carves.LoadKeyRing(flagParams.Carver.KeyFile)
+		if err != nil {
+			log.Fatal().Msgf("Error loading carves keyfile - %v", err)
+		}
+		carvesmgr.WithEncryption(carveKeys)
+		log.Info().Msg("Carves encryption at rest enabled")
+	}
 	log.Info().Msg("Initialize sessions")
 	sessionsmgr = sessions.CreateSessionManager(db.Conn, authCookieName, flagParams.Admin.SessionKey)
 	log.Info().Msg("Loading service settings")
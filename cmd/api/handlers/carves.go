@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"strconv"
@@ -72,7 +71,8 @@ func carveFileView(c carves.CarvedFile) types.CarveFileView {
 // CarveShowHandler - GET /api/v1/carves/{env}/{name}
 //
 // Returns the carve query metadata plus the array of per-node CarvedFile rows
-// produced by the carve. Returns 404 when the carve query name does not exist
+// produced by the carve, and for directory carves the files that were skipped
+// and why. Returns 404 when the carve query name does not exist
 // in the environment.
 // @Summary Get file carve
 // @Description Returns a file carve and the files produced by it.
@@ -151,12 +151,23 @@ func (h *HandlersApi) CarveShowHandler(w http.ResponseWriter, r *http.Request) {
 		log.Debug().Err(terr).Msgf("carve targets fetch failed for %s", name)
 	}
 
+	skippedFiles, err := h.Carves.GetSkipped(name, env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting skipped carve files", http.StatusInternalServerError, err)
+		return
+	}
+	skipped := make([]types.CarveSkippedView, 0, len(skippedFiles))
+	for _, f := range skippedFiles {
+		skipped = append(skipped, types.CarveSkippedView{UUID: f.UUID, Path: f.Path, Size: f.Size, Reason: f.Reason})
+	}
+
 	resp := types.CarveDetailResponse{
 		Query: types.DistributedQueryView{
 			DistributedQuery: q,
 			Targets:          targets,
 		},
-		Files: views,
+		Files:   views,
+		Skipped: skipped,
 	}
 	log.Debug().Msgf("Returned carve %s (%d files)", name, len(views))
 	h.AuditLog.Visit(ctx[ctxUser], r.URL.Path, strings.Split(r.RemoteAddr, ":")[0], env.ID)
@@ -326,8 +337,12 @@ func (h *HandlersApi) CarveListHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // CarvesRunHandler - POST /api/v1/carves/{env}
+//
+// Starts a carve of a single path, or a recursive carve of a directory tree
+// when the request carries a directory block. Files a directory carve leaves
+// out are reported by the nodes and listed in the carve detail.
 // @Summary Run file carve
-// @Description Starts a new file carve.
+// @Description Starts a new file carve, or a recursive directory carve with include/exclude globs, depth and size limits.
 // @Tags carves
 // @Accept json
 // @Produce json
@@ -368,6 +383,16 @@ func (h *HandlersApi) CarvesRunHandler(w http.ResponseWriter, r *http.Request) {
 		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
 		return
 	}
+	carveQuery := carves.GenCarveQuery(c.Path, false)
+	if c.Directory != nil {
+		dirQuery, err := carves.GenDirectoryCarveQuery(*c.Directory)
+		if err != nil {
+			apiErrorResponse(w, "invalid directory carve", http.StatusBadRequest, err)
+			return
+		}
+		carveQuery = dirQuery
+		c.Path = c.Directory.Root
+	}
 	if c.Path == "" {
 		apiErrorResponse(w, "path can not be empty", http.StatusBadRequest, nil)
 		return
@@ -387,7 +412,7 @@ func (h *HandlersApi) CarvesRunHandler(w http.ResponseWriter, r *http.Request) {
 		expTime = time.Time{}
 	}
 	newQuery := queries.DistributedQuery{
-		Query:         carves.GenCarveQuery(c.Path, false),
+		Query:         carveQuery,
 		Name:          carves.GenCarveName(),
 		Creator:       ctx[ctxUser],
 		Active:        true,
@@ -406,6 +431,7 @@ func (h *HandlersApi) CarvesRunHandler(w http.ResponseWriter, r *http.Request) {
 		UUIDs:         c.UUIDs,
 		Hosts:         c.Hosts,
 		Tags:          c.Tags,
+		Attributes:    c.Attributes,
 		EnvID:         env.ID,
 		InactiveHours: h.Settings.InactiveHours(settings.NoEnvironmentID),
 	}
@@ -528,6 +554,61 @@ func (h *HandlersApi) CarvesActionHandler(w http.ResponseWriter, r *http.Request
 	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: msgReturn})
 }
 
+// CarveKeysRotateHandler - POST /api/v1/carves/{env}/keys/rotate
+//
+// Re-wraps the data keys of every encrypted carve in the environment with the
+// active environment key from the carves keyfile. Carved data is not
+// re-encrypted, so old keys can be dropped from the keyfile afterwards.
+// @Summary Rotate carve encryption keys
+// @Description Re-wraps carve data keys with the active environment key.
+// @Tags carves
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Success 200 {object} types.ApiGenericResponse
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 409 {object} types.ApiErrorResponse "Conflict"
+// @Failure 429 {object} types.ApiErrorResponse "Too many requests"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Failure 503 {object} types.ApiErrorResponse "Service unavailable"
+// @Security ApiKeyAuth
+// @Router /api/v1/carves/{env}/keys/rotate [post]
+func (h *HandlersApi) CarveKeysRotateHandler(w http.ResponseWriter, r *http.Request) {
+	if h.DebugHTTPConfig.EnableHTTP {
+		utils.DebugHTTPDump(h.DebugHTTP, r, h.DebugHTTPConfig.ShowBody)
+	}
+	envVar := r.PathValue("env")
+	if envVar == "" {
+		apiErrorResponse(w, "error with environment", http.StatusBadRequest, nil)
+		return
+	}
+	env, err := h.Envs.Get(envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		return
+	}
+	ctx := r.Context().Value(ContextKey(contextAPI)).(ContextValue)
+	if !h.Users.CheckPermissions(ctx[ctxUser], users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		return
+	}
+	if !h.Carves.Keys.Enabled(env.Name) {
+		apiErrorResponse(w, "carve encryption not configured for environment", http.StatusConflict, nil)
+		return
+	}
+	rotated, err := h.Carves.RotateKeys(env.Name)
+	if err != nil {
+		apiErrorResponse(w, "error rotating carve keys", http.StatusInternalServerError, err)
+		return
+	}
+	msgReturn := fmt.Sprintf("%d carve keys rotated to %s", rotated, h.Carves.Keys.ActiveKeyID(env.Name))
+	log.Debug().Msgf("%s", msgReturn)
+	h.AuditLog.CarveAction(ctx[ctxUser], "rotate carve keys", strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: msgReturn})
+}
+
 // CarveArchiveHandler - GET /api/v1/carves/{env}/archive/{name}
 //
 // (The literal `archive` lives in segment 2 — not as a `/{name}/archive` suffix —
@@ -542,22 +623,29 @@ func (h *HandlersApi) CarvesActionHandler(w http.ResponseWriter, r *http.Request
 //   - If multiple exist, an explicit ?session=<session-id> must select one.
 //     A missing/ambiguous session selector returns 409 Conflict.
 //   - If the underlying file is not yet archived, it is archived on demand
-//     (local or DB carver: written to a temp dir, then served; S3: a presigned
-//     download URL is returned via 302 redirect).
+//     (local or DB carver: written to a temp dir, then served; S3: the
+//     object is streamed with ranged GETs, never buffered in memory).
 //
 // Content-Disposition is set to attachment with the carve archive filename.
+// The ETag is the SHA-256 of the archive content, and Range / If-Range
+// requests are honored so clients can resume interrupted downloads.
 // @Summary Download carve archive
 // @Description Downloads the archive for a completed file carve.
 // @Tags carves
 // @Produce application/octet-stream
 // @Param env path string true "Environment name or UUID"
 // @Param name path string true "Carve query name"
+// @Param session query string false "Carve session, required when the carve has several files"
+// @Param Range header string false "Byte range to resume a download"
+// @Param If-Range header string false "ETag the range is valid for"
 // @Success 200 {file} file
+// @Success 206 {file} file "Partial content"
 // @Failure 400 {object} types.ApiErrorResponse "Bad request"
 // @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
 // @Failure 403 {object} types.ApiErrorResponse "Forbidden"
 // @Failure 404 {object} types.ApiErrorResponse "Not found"
 // @Failure 409 {object} types.ApiErrorResponse "Conflict"
+// @Failure 416 {object} types.ApiErrorResponse "Range not satisfiable"
 // @Failure 429 {object} types.ApiErrorResponse "Too many requests"
 // @Failure 500 {object} types.ApiErrorResponse "Internal server error"
 // @Failure 503 {object} types.ApiErrorResponse "Service unavailable"
@@ -642,8 +730,8 @@ func (h *HandlersApi) CarveArchiveHandler(w http.ResponseWriter, r *http.Request
 	// strategy differs by carver:
 	//
 	//   - S3:        Archive() multipart-uploads the file to a persistent S3
-	//                key; we mark the row archived with that key and serve
-	//                a presigned download URL.
+	//                key; we mark the row archived with that key and stream
+	//                the object with ranged GETs.
 	//   - Local/DB:  Archive() reconstructs the file in a workspace dir. The
 	//                API process owns no canonical "carves folder" — the
 	//                legacy admin owns one — so we stage in a per-request
@@ -651,55 +739,32 @@ func (h *HandlersApi) CarveArchiveHandler(w http.ResponseWriter, r *http.Request
 	//                would point future requests at a tmpdir we've already
 	//                removed.) The trade-off is re-archiving on each request
 	//                for local/DB carvers, which is correctness over cache.
-	carve := *selected
-
-	if h.Carves.Carver == config.CarverS3 {
-		if !carve.Archived {
-			// Pass empty destPath — Archive() ignores it for the S3 path.
-			result, aerr := h.Carves.Archive(carve.SessionID, "")
-			if aerr != nil {
-				apiErrorResponse(w, "error archiving carve", http.StatusInternalServerError, aerr)
-				return
-			}
-			if result == nil {
-				apiErrorResponse(w, "empty carve archive", http.StatusInternalServerError, nil)
-				return
-			}
-			if aerr := h.Carves.ArchiveCarve(carve.SessionID, result.File); aerr != nil {
-				log.Err(aerr).Msgf("error marking carve %s archived", carve.SessionID)
-			}
-			carve.Archived = true
-			carve.ArchivePath = result.File
-		}
-		link, lerr := h.Carves.S3.GetDownloadLink(carve)
-		if lerr != nil {
-			apiErrorResponse(w, "error generating download link", http.StatusInternalServerError, lerr)
-			return
-		}
-		h.AuditLog.CarveAction(ctx[ctxUser], "download "+name, strings.Split(r.RemoteAddr, ":")[0], env.ID)
-		http.Redirect(w, r, link, http.StatusFound)
-		return
-	}
-
-	// Local / DB carver: stage the archive in a per-request tmpdir and stream
-	// it back. RemoveAll runs after f.Close (defers are LIFO), so the file is
-	// readable for the duration of the response.
+	//
+	// Archive() also records the SHA-256 of the archive content the first
+	// time, which is served as ETag so resumed downloads can use If-Range.
 	//
 	// os.MkdirTemp creates the directory mode 0700, but the file written
 	// inside by Carves.Archive may end up world-readable depending on
 	// the platform umask. We chmod it to 0600 explicitly so on a
 	// multi-tenant container host another tenant on the same node can't
 	// read the carved bytes during the brief window before RemoveAll.
-	//
+	carve := *selected
 	archivePath := carve.ArchivePath
-	if !carve.Archived {
-		tmpDir, terr := os.MkdirTemp("", "osctrl-carve-archive-")
-		if terr != nil {
-			apiErrorResponse(w, "error preparing archive workspace", http.StatusInternalServerError, terr)
-			return
+	etag := carve.ArchiveHash
+	if !carve.Archived || etag == "" {
+		// Empty destPath for S3 or already archived carves, Archive() ignores it
+		destPath := ""
+		if !carve.Archived && h.Carves.Carver != config.CarverS3 {
+			tmpDir, terr := os.MkdirTemp("", "osctrl-carve-archive-")
+			if terr != nil {
+				apiErrorResponse(w, "error preparing archive workspace", http.StatusInternalServerError, terr)
+				return
+			}
+			// RemoveAll runs after the archive is closed (defers are LIFO)
+			defer os.RemoveAll(tmpDir)
+			destPath = tmpDir
 		}
-		defer os.RemoveAll(tmpDir)
-		result, aerr := h.Carves.Archive(carve.SessionID, tmpDir)
+		result, aerr := h.Carves.Archive(carve.SessionID, destPath)
 		if aerr != nil {
 			apiErrorResponse(w, "error archiving carve", http.StatusInternalServerError, aerr)
 			return
@@ -708,36 +773,49 @@ func (h *HandlersApi) CarveArchiveHandler(w http.ResponseWriter, r *http.Request
 			apiErrorResponse(w, "empty carve archive", http.StatusInternalServerError, nil)
 			return
 		}
-		archivePath = result.File
-		if err := os.Chmod(archivePath, 0600); err != nil {
-			log.Err(err).Msgf("failed to chmod 0600 on carve archive %s — proceeding but file may be wider-readable", archivePath)
+		if destPath != "" {
+			if err := os.Chmod(result.File, 0600); err != nil {
+				log.Err(err).Msgf("failed to chmod 0600 on carve archive %s — proceeding but file may be wider-readable", result.File)
+			}
 		}
+		if h.Carves.Carver == config.CarverS3 && !carve.Archived {
+			if aerr := h.Carves.ArchiveCarve(carve.SessionID, result.File); aerr != nil {
+				log.Err(aerr).Msgf("error marking carve %s archived", carve.SessionID)
+			}
+			carve.Archived = true
+			carve.ArchivePath = result.File
+		}
+		archivePath = result.File
+		etag = result.Hash
 	}
+	h.streamCarveArchive(w, r, carve, archivePath, etag, name, env.ID, ctx[ctxUser])
+}
 
-	f, ferr := os.Open(archivePath)
+// streamCarveArchive sends the archive of a carve as an attachment, without
+// buffering it. Encrypted archives are decrypted transparently by
+// Carves.OpenArchive. http.ServeContent takes care of Range, If-Range and
+// conditional requests against the archive hash ETag, so interrupted
+// downloads can be resumed.
+func (h *HandlersApi) streamCarveArchive(w http.ResponseWriter, r *http.Request, carve carves.CarvedFile, archivePath, etag, name string, envID uint, user string) {
+	f, _, ferr := h.Carves.OpenArchive(carve, archivePath)
 	if ferr != nil {
 		apiErrorResponse(w, "error opening archive", http.StatusInternalServerError, ferr)
 		return
 	}
 	defer f.Close()
-	stat, serr := f.Stat()
-	if serr != nil {
-		apiErrorResponse(w, "error stat archive", http.StatusInternalServerError, serr)
-		return
-	}
 	filename := carves.GenerateArchiveName(carve)
-	// If the on-disk file picked up the zst suffix during archive, preserve it.
+	// If the archive picked up the zst suffix during archive, preserve it.
 	if strings.HasSuffix(archivePath, carves.ZstFileExtension) &&
 		!strings.HasSuffix(filename, carves.ZstFileExtension) {
 		filename += carves.ZstFileExtension
 	}
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Length", strconv.FormatInt(stat.Size(), 10))
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
-	w.WriteHeader(http.StatusOK)
-	if _, err := io.Copy(w, f); err != nil {
-		log.Err(err).Msgf("error streaming carve archive %s", archivePath)
-		return
+	if etag != "" {
+		w.Header().Set("ETag", strconv.Quote(etag))
+	}
+	if r.Method != http.MethodHead {
+		h.AuditLog.CarveAction(user, "download "+name, strings.Split(r.RemoteAddr, ":")[0], envID)
 	}
-	h.AuditLog.CarveAction(ctx[ctxUser], "download "+name, strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	http.ServeContent(w, r, filename, carve.CompletedAt, f)
 }
@@ -14,6 +14,7 @@ import (
 	"github.com/jmpsec/osctrl/pkg/config"
 	"github.com/jmpsec/osctrl/pkg/console"
 	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/handlers"
 	"github.com/jmpsec/osctrl/pkg/nodes"
 	"github.com/jmpsec/osctrl/pkg/queries"
 	"github.com/jmpsec/osctrl/pkg/settings"
@@ -47,6 +48,19 @@ type consoleSessionResponse struct {
 	NodeInfo consoleNodeInfo        `json:"node_info"`
 }
 
+type consoleGroupSessionRequest struct {
+	UUIDs     []string `json:"uuid_list"`
+	Hosts     []string `json:"host_list"`
+	Platforms []string `json:"platform_list"`
+	Tags      []string `json:"tag_list"`
+	Label     string   `json:"label"`
+}
+
+type consoleGroupSessionResponse struct {
+	Session console.Session       `json:"session"`
+	Nodes   []console.SessionNode `json:"nodes"`
+}
+
 const defaultConsoleQueryReadSeconds = 5
 
 func (h *HandlersApi) ConsoleSessionCreateHandler(w http.ResponseWriter, r *http.Request) {
@@ -86,6 +100,57 @@ func (h *HandlersApi) ConsoleSessionCreateHandler(w http.ResponseWriter, r *http
 	})
 }
 
+// ConsoleGroupSessionCreateHandler opens a console session on a group of
+// nodes selected by UUID, hostname, platform or tag. Commands sent to the
+// session run on every node as one hidden distributed query.
+func (h *HandlersApi) ConsoleGroupSessionCreateHandler(w http.ResponseWriter, r *http.Request) {
+	env, ctx, ok := h.consoleEnvContext(w, r)
+	if !ok {
+		return
+	}
+	var body consoleGroupSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		return
+	}
+	if len(body.UUIDs) == 0 && len(body.Hosts) == 0 && len(body.Platforms) == 0 && len(body.Tags) == 0 {
+		apiErrorResponse(w, "group session requires nodes, hosts, platforms or tags", http.StatusBadRequest, nil)
+		return
+	}
+	data := handlers.ProcessingQuery{
+		Platforms:     body.Platforms,
+		UUIDs:         body.UUIDs,
+		Hosts:         body.Hosts,
+		Tags:          body.Tags,
+		EnvID:         env.ID,
+		InactiveHours: h.Settings.InactiveHours(settings.NoEnvironmentID),
+	}
+	manager := handlers.Managers{
+		Nodes: h.Nodes,
+		Envs:  h.Envs,
+		Tags:  h.Tags,
+	}
+	nodeIDs, err := handlers.CreateQueryCarve(data, manager, queries.DistributedQuery{})
+	if err != nil {
+		apiErrorResponse(w, "error getting group nodes", http.StatusBadRequest, err)
+		return
+	}
+	if len(nodeIDs) > console.MaxGroupNodes {
+		apiErrorResponse(w, fmt.Sprintf("group targets %d nodes, maximum is %d", len(nodeIDs), console.MaxGroupNodes), http.StatusBadRequest, nil)
+		return
+	}
+	session, members, err := h.Console.CreateGroupSession(env, nodeIDs, ctx[ctxUser], body.Label)
+	if err != nil {
+		apiErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+		return
+	}
+	h.auditConsoleVisit(ctx[ctxUser], r, env.ID)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusCreated, consoleGroupSessionResponse{
+		Session: session,
+		Nodes:   members,
+	})
+}
+
 func (h *HandlersApi) ConsoleSessionShowHandler(w http.ResponseWriter, r *http.Request) {
 	env, ctx, session, ok := h.consoleSessionContext(w, r)
 	if !ok {
@@ -138,7 +203,7 @@ func (h *HandlersApi) ConsoleCommandCreateHandler(w http.ResponseWriter, r *http
 		return
 	}
 	if parsed.Kind == console.CommandCarve {
-		carveName, err := h.createConsoleCarve(env, session, ctx[ctxUser], parsed.Path)
+		carveName, err := h.createConsoleCarve(env, session, ctx[ctxUser], parsed)
 		if err != nil {
 			apiErrorResponse(w, "error creating carve", http.StatusInternalServerError, err)
 			return
@@ -150,30 +215,55 @@ func (h *HandlersApi) ConsoleCommandCreateHandler(w http.ResponseWriter, r *http
 	if h.AuditLog != nil {
 		h.AuditLog.QueryAction(ctx[ctxUser], "console command "+parsed.Command, strings.Split(r.RemoteAddr, ":")[0], env.ID)
 	}
+	if h.ConsoleStream != nil {
+		h.ConsoleStream.Publish(r.Context(), console.StreamNotice{SessionID: session.ID, CommandID: command.ID})
+	}
 	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusCreated, consoleCommandResponse{Command: command, Parsed: parsed})
 }
 
-func (h *HandlersApi) createConsoleCarve(env environments.TLSEnvironment, session console.Session, creator, path string) (string, error) {
+func (h *HandlersApi) createConsoleCarve(env environments.TLSEnvironment, session console.Session, creator string, parsed console.ParsedCommand) (string, error) {
+	path := parsed.Path
+	members, err := h.Console.SessionNodes(session)
+	if err != nil {
+		return "", err
+	}
+	carveQuery := carves.GenCarveQuery(path, false)
+	if parsed.Command == "head" {
+		carveQuery = carves.GenSizedCarveQuery(path, console.HeadMaxSize)
+	}
+	if parsed.Directory != nil {
+		dirQuery, err := carves.GenDirectoryCarveQuery(*parsed.Directory)
+		if err != nil {
+			return "", err
+		}
+		carveQuery = dirQuery
+	}
 	newQuery := queries.DistributedQuery{
-		Query:         carves.GenCarveQuery(path, false),
+		Query:         carveQuery,
 		Name:          carves.GenCarveName(),
 		Creator:       "console:" + creator,
 		Active:        true,
 		Type:          queries.CarveQueryType,
 		Path:          path,
 		EnvironmentID: env.ID,
-		Expected:      1,
+		Expected:      len(members),
 	}
 	if err := h.Queries.Create(&newQuery); err != nil {
 		return "", err
 	}
-	if err := h.Queries.CreateNodeQueries([]uint{session.NodeID}, newQuery.ID); err != nil {
-		return "", err
+	nodeIDs := make([]uint, 0, len(members))
+	for _, member := range members {
+		nodeIDs = append(nodeIDs, member.NodeID)
 	}
-	if err := h.Queries.CreateTarget(newQuery.Name, "uuid", session.NodeUUID); err != nil {
+	if err := h.Queries.CreateNodeQueries(nodeIDs, newQuery.ID); err != nil {
 		return "", err
 	}
-	if err := h.Queries.SetExpected(newQuery.Name, 1, env.ID); err != nil {
+	for _, member := range members {
+		if err := h.Queries.CreateTarget(newQuery.Name, "uuid", member.NodeUUID); err != nil {
+			return "", err
+		}
+	}
+	if err := h.Queries.SetExpected(newQuery.Name, len(members), env.ID); err != nil {
 		return "", err
 	}
 	if h.AuditLog != nil {
@@ -220,7 +310,8 @@ func (h *HandlersApi) consoleCommandTimeout(parsed console.ParsedCommand) time.D
 			seconds = configured
 		}
 	}
-	if parsed.Kind == console.CommandRemote && parsed.Command == "sql" {
+	// Raw SQL and recursive file walks can take a while to answer
+	if parsed.Kind == console.CommandRemote && (parsed.Command == "sql" || parsed.Command == "find") {
 		timeout := time.Duration(seconds*12) * time.Second
 		if timeout < time.Minute {
 			return time.Minute
@@ -290,6 +381,30 @@ func (h *HandlersApi) ConsoleCommandResultsHandler(w http.ResponseWriter, r *htt
 	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, results)
 }
 
+// ConsoleCommandNodesHandler returns the status and results of a command on
+// every node of its session, grouped by host.
+func (h *HandlersApi) ConsoleCommandNodesHandler(w http.ResponseWriter, r *http.Request) {
+	_, _, session, ok := h.consoleSessionContext(w, r)
+	if !ok {
+		return
+	}
+	commandID, ok := consolePathUint(w, r, "command_id")
+	if !ok {
+		return
+	}
+	command, err := h.Console.GetCommand(session.ID, commandID)
+	if err != nil {
+		consoleNotFoundOrError(w, "command not found", "error getting command", err)
+		return
+	}
+	results, err := h.Console.GroupResults(command.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting command results", http.StatusInternalServerError, err)
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, results)
+}
+
 func (h *HandlersApi) consoleEnvContext(w http.ResponseWriter, r *http.Request) (environments.TLSEnvironment, ContextValue, bool) {
 	envVar := r.PathValue("env")
 	if envVar == "" {
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/console"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/jmpsec/osctrl/pkg/utils"
+)
+
+const (
+	// consoleCompleteWait bounds how long a path completion waits for the
+	// nodes to list a directory before answering that it is pending
+	consoleCompleteWait = 2 * time.Second
+	// consoleCompletePoll is how often a pending listing is checked
+	consoleCompletePoll = 250 * time.Millisecond
+)
+
+// ConsoleCompleteHandler - GET /api/v1/console/{env}/sessions/{session_id}/complete
+//
+// Tab completion for the console, where `input` is the line up to the cursor
+// and `osquery_mode=true` completes it as SQL. Verbs, tables and columns are
+// completed right away, tables filtered by the session platform. Paths need
+// a directory listing from the nodes, so the request waits briefly for it
+// and otherwise returns a pending completion that the client asks again.
+func (h *HandlersApi) ConsoleCompleteHandler(w http.ResponseWriter, r *http.Request) {
+	_, _, session, ok := h.consoleSessionContext(w, r)
+	if !ok {
+		return
+	}
+	input := r.URL.Query().Get("input")
+	osqueryMode, _ := strconv.ParseBool(r.URL.Query().Get("osquery_mode"))
+	tables := make([]types.OsqueryTable, 0, len(h.OsqueryTables))
+	for _, table := range h.OsqueryTables {
+		if osqueryTableSupportsPlatform(table, session.Platform) {
+			tables = append(tables, table)
+		}
+	}
+	timeout := h.consoleCommandTimeout(console.ParsedCommand{Kind: console.CommandRemote, Command: "ls"})
+	deadline := time.Now().Add(consoleCompleteWait)
+	for {
+		completion, err := h.Console.Complete(session, input, osqueryMode, tables, timeout)
+		if err != nil {
+			apiErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+			return
+		}
+		if completion.Status != console.CompletionPending || !time.Now().Before(deadline) {
+			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, completion)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(consoleCompletePoll):
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/console"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// consoleStreamPollInterval re-checks tracked commands even without
+	// notices, covering results stored before their notice was published
+	consoleStreamPollInterval = 5 * time.Second
+	// consoleStreamKeepAlive is how often an idle stream sends a comment
+	consoleStreamKeepAlive = 15 * time.Second
+)
+
+type consoleStreamResults struct {
+	CommandID uint             `json:"command_id"`
+	NodeID    uint             `json:"node_id"`
+	NodeUUID  string           `json:"node_uuid"`
+	Hostname  string           `json:"hostname"`
+	Rows      []map[string]any `json:"rows"`
+}
+
+// consoleStreamState tracks what a stream already sent for each command
+type consoleStreamState struct {
+	lastID  uint
+	status  map[uint]string
+	sent    map[uint]map[string]int
+	pending map[uint]bool
+}
+
+// ConsoleStreamHandler - GET /api/v1/console/{env}/sessions/{session_id}/stream
+//
+// Server-Sent Events stream of a console session. It sends a `status` event
+// with the command every time its status changes and a `results` event with
+// the new rows of each node as soon as they are stored, so clients do not
+// need to poll the command and results endpoints. Commands created after the
+// command_id in the `after` query parameter, or still pending when the stream
+// opens, are tracked. Notices arrive through Redis pub/sub when configured,
+// so streams work whichever API instance serves them.
+func (h *HandlersApi) ConsoleStreamHandler(w http.ResponseWriter, r *http.Request) {
+	_, _, session, ok := h.consoleSessionContext(w, r)
+	if !ok {
+		return
+	}
+	if h.ConsoleStream == nil {
+		apiErrorResponse(w, "console streaming is not enabled", http.StatusServiceUnavailable, nil)
+		return
+	}
+	state := consoleStreamState{
+		status:  map[uint]string{},
+		sent:    map[uint]map[string]int{},
+		pending: map[uint]bool{},
+	}
+	if after := r.URL.Query().Get("after"); after != "" {
+		id, err := strconv.ParseUint(after, 10, strconv.IntSize)
+		if err != nil {
+			apiErrorResponse(w, "invalid after", http.StatusBadRequest, err)
+			return
+		}
+		state.lastID = uint(id)
+	} else {
+		pending, err := h.Console.PendingCommands(session.ID)
+		if err != nil {
+			apiErrorResponse(w, "error getting console commands", http.StatusInternalServerError, err)
+			return
+		}
+		for _, command := range pending {
+			state.pending[command.ID] = true
+		}
+		latest, err := h.Console.CommandsSince(session.ID, 0)
+		if err != nil {
+			apiErrorResponse(w, "error getting console commands", http.StatusInternalServerError, err)
+			return
+		}
+		if len(latest) > 0 {
+			state.lastID = latest[len(latest)-1].ID
+		}
+	}
+	notices, unsubscribe := h.ConsoleStream.Subscribe(session.ID)
+	defer unsubscribe()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		log.Err(err).Msg("console stream can not be flushed")
+		return
+	}
+
+	poll := time.NewTicker(consoleStreamPollInterval)
+	defer poll.Stop()
+	keepAlive := time.NewTicker(consoleStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		sent, err := h.syncConsoleStream(w, session.ID, &state)
+		if err != nil {
+			log.Err(err).Msgf("console stream for session %d stopped", session.ID)
+			return
+		}
+		if sent {
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			keepAlive.Reset(consoleStreamKeepAlive)
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-notices:
+		case <-poll.C:
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// syncConsoleStream writes the events for every tracked command that changed
+// since the last call, and reports whether anything was written.
+func (h *HandlersApi) syncConsoleStream(w http.ResponseWriter, sessionID uint, state *consoleStreamState) (bool, error) {
+	created, err := h.Console.CommandsSince(sessionID, state.lastID)
+	if err != nil {
+		return false, err
+	}
+	for _, command := range created {
+		state.pending[command.ID] = true
+		state.lastID = command.ID
+	}
+	wrote := false
+	for commandID := range state.pending {
+		command, err := h.Console.RefreshCommandStatus(commandID)
+		if err != nil {
+			return wrote, err
+		}
+		if state.status[command.ID] != command.Status {
+			state.status[command.ID] = command.Status
+			if err := writeConsoleStreamEvent(w, "status", command); err != nil {
+				return wrote, err
+			}
+			wrote = true
+		}
+		if command.DistributedQueryName != "" {
+			nodes, err := h.Console.CommandNodeResults(command)
+			if err != nil {
+				return wrote, err
+			}
+			if state.sent[command.ID] == nil {
+				state.sent[command.ID] = map[string]int{}
+			}
+			for _, node := range nodes {
+				already := state.sent[command.ID][node.NodeUUID]
+				if len(node.Results) <= already {
+					continue
+				}
+				state.sent[command.ID][node.NodeUUID] = len(node.Results)
+				event := consoleStreamResults{
+					CommandID: command.ID,
+					NodeID:    node.NodeID,
+					NodeUUID:  node.NodeUUID,
+					Hostname:  node.Hostname,
+					Rows:      node.Results[already:],
+				}
+				if err := writeConsoleStreamEvent(w, "results", event); err != nil {
+					return wrote, err
+				}
+				wrote = true
+			}
+		}
+		if command.Status != console.StatusQueued && command.Status != console.StatusDelivered {
+			delete(state.pending, command.ID)
+			delete(state.sent, command.ID)
+			delete(state.status, command.ID)
+		}
+	}
+	return wrote, nil
+}
+
+func writeConsoleStreamEvent(w http.ResponseWriter, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
@@ -1,12 +1,17 @@
 package handlers
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
@@ -15,6 +20,7 @@ import (
 	"github.com/jmpsec/osctrl/pkg/config"
 	"github.com/jmpsec/osctrl/pkg/console"
 	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/logging"
 	"github.com/jmpsec/osctrl/pkg/nodes"
 	"github.com/jmpsec/osctrl/pkg/queries"
 	"github.com/jmpsec/osctrl/pkg/settings"
@@ -386,3 +392,249 @@ func consoleRequest(method, target string, body []byte, username string) *http.R
 	ctx := context.WithValue(req.Context(), ContextKey(contextAPI), ContextValue{ctxUser: username})
 	return req.WithContext(ctx)
 }
+
+func TestConsoleGroupSessionRunsCommandOnEveryNode(t *testing.T) {
+	db, h, env, node := setupConsoleHandlers(t)
+	require.NoError(t, db.AutoMigrate(&logging.OsqueryQueryData{}))
+	second := nodes.OsqueryNode{UUID: "NODE-TWO", Hostname: "bravo", Platform: "linux", EnvironmentID: env.ID, Environment: env.UUID}
+	require.NoError(t, db.Create(&second).Error)
+
+	body := []byte(`{"uuid_list":["NODE-UUID","NODE-TWO"],"label":"incident"}`)
+	req := consoleRequest(http.MethodPost, "/console", body, "alice")
+	req.SetPathValue("env", env.Name)
+	rr := httptest.NewRecorder()
+	h.ConsoleGroupSessionCreateHandler(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var created consoleGroupSessionResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	require.True(t, created.Session.Group)
+	require.Len(t, created.Nodes, 2)
+
+	req = consoleRequest(http.MethodPost, "/console", []byte(`{"input":"ps"}`), "alice")
+	req.SetPathValue("env", env.Name)
+	req.SetPathValue("session_id", fmt.Sprint(created.Session.ID))
+	rr = httptest.NewRecorder()
+	h.ConsoleCommandCreateHandler(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var resp consoleCommandResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+	req = consoleRequest(http.MethodGet, "/console", nil, "alice")
+	req.SetPathValue("env", env.Name)
+	req.SetPathValue("session_id", fmt.Sprint(created.Session.ID))
+	req.SetPathValue("command_id", fmt.Sprint(resp.Command.ID))
+	rr = httptest.NewRecorder()
+	h.ConsoleCommandNodesHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var perNode []console.NodeResults
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &perNode))
+	require.Len(t, perNode, 2)
+	for _, n := range perNode {
+		require.Equal(t, console.StatusQueued, n.Status)
+		require.Contains(t, []uint{node.ID, second.ID}, n.NodeID)
+	}
+}
+
+func TestConsoleGroupSessionRequiresTargets(t *testing.T) {
+	_, h, env, _ := setupConsoleHandlers(t)
+	req := consoleRequest(http.MethodPost, "/console", []byte(`{"label":"everything"}`), "alice")
+	req.SetPathValue("env", env.Name)
+	rr := httptest.NewRecorder()
+	h.ConsoleGroupSessionCreateHandler(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestConsoleStreamSendsStatusAndResults(t *testing.T) {
+	db, h, env, node := setupConsoleHandlers(t)
+	require.NoError(t, db.AutoMigrate(&logging.OsqueryQueryData{}))
+	h.ConsoleStream = console.NewBroker(h.Console)
+	session, err := h.Console.CreateSession(env, node, "alice")
+	require.NoError(t, err)
+	command, _, err := h.Console.SubmitCommand(session.ID, "ps")
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), ContextKey(contextAPI), ContextValue{ctxUser: "alice"}))
+		r.SetPathValue("env", env.Name)
+		r.SetPathValue("session_id", fmt.Sprint(session.ID))
+		h.ConsoleStreamHandler(w, r)
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	events := readConsoleStreamEvents(resp.Body)
+
+	event := <-events
+	require.Equal(t, "status", event[0])
+	require.Contains(t, event[1], `"status":"queued"`)
+
+	data, err := json.Marshal([]map[string]string{{"pid": "1"}})
+	require.NoError(t, err)
+	require.NoError(t, db.Create(&logging.OsqueryQueryData{UUID: node.UUID, Name: command.DistributedQueryName, Data: string(data)}).Error)
+	require.NoError(t, h.Queries.UpdateQueryStatus(command.DistributedQueryName, node.ID, 0))
+	h.ConsoleStream.QueryWritten(command.DistributedQueryName, node.UUID)
+
+	event = <-events
+	require.Equal(t, "status", event[0])
+	require.Contains(t, event[1], `"status":"completed"`)
+	event = <-events
+	require.Equal(t, "results", event[0])
+	var results consoleStreamResults
+	require.NoError(t, json.Unmarshal([]byte(event[1]), &results))
+	require.Equal(t, command.ID, results.CommandID)
+	require.Equal(t, node.UUID, results.NodeUUID)
+	require.Equal(t, []map[string]any{{"pid": "1"}}, results.Rows)
+}
+
+// readConsoleStreamEvents decodes server-sent events as name and data pairs
+func readConsoleStreamEvents(body io.Reader) <-chan [2]string {
+	events := make(chan [2]string)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(body)
+		var event [2]string
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event[0] = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event[1] = strings.TrimPrefix(line, "data: ")
+			case line == "" && event[0] != "":
+				events <- event
+				event = [2]string{}
+			}
+		}
+	}()
+	return events
+}
+
+func TestConsoleHeadCreatesSizeLimitedCarve(t *testing.T) {
+	db, h, env, node := setupConsoleHandlers(t)
+	session, err := h.Console.CreateSession(env, node, "alice")
+	require.NoError(t, err)
+
+	req := consoleRequest(http.MethodPost, "/console", []byte(`{"input":"head /etc/hosts"}`), "alice")
+	req.SetPathValue("env", env.Name)
+	req.SetPathValue("session_id", fmt.Sprint(session.ID))
+	rr := httptest.NewRecorder()
+	h.ConsoleCommandCreateHandler(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	var carveQuery queries.DistributedQuery
+	require.NoError(t, db.Where("type = ?", queries.CarveQueryType).First(&carveQuery).Error)
+	require.Equal(t, "/etc/hosts", carveQuery.Path)
+	require.Equal(t, carves.GenSizedCarveQuery("/etc/hosts", console.HeadMaxSize), carveQuery.Query)
+}
+
+func TestConsoleTranscriptExportIsSigned(t *testing.T) {
+	_, h, env, node := setupConsoleHandlers(t)
+	session, err := h.Console.CreateSession(env, node, "alice")
+	require.NoError(t, err)
+	_, _, err = h.Console.SubmitCommand(session.ID, "pwd")
+	require.NoError(t, err)
+
+	transcriptRequest := func(format string) *httptest.ResponseRecorder {
+		req := consoleRequest(http.MethodGet, "/console?format="+format, nil, "alice")
+		req.SetPathValue("env", env.Name)
+		req.SetPathValue("session_id", fmt.Sprint(session.ID))
+		rr := httptest.NewRecorder()
+		h.ConsoleTranscriptHandler(rr, req)
+		return rr
+	}
+	require.Equal(t, http.StatusServiceUnavailable, transcriptRequest("json").Code)
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	h.ConsoleSigner = console.NewTranscriptSigner(private)
+	rr := transcriptRequest("json")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var signed console.SignedTranscript
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &signed))
+	transcript, err := console.VerifyTranscript(signed, public)
+	require.NoError(t, err)
+	require.Equal(t, "alice", transcript.ExportedBy)
+	require.Len(t, transcript.Entries, 1)
+	require.Equal(t, "pwd", transcript.Entries[0].Command.Input)
+
+	rr = transcriptRequest("cast")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, consoleCastContentType, rr.Header().Get("Content-Type"))
+	_, events, err := console.VerifyCast(rr.Body.Bytes(), public)
+	require.NoError(t, err)
+	require.Contains(t, events[0].Data, "pwd")
+
+	require.Equal(t, http.StatusBadRequest, transcriptRequest("pdf").Code)
+}
+
+func TestConsoleCompleteWaitsForDirectoryListing(t *testing.T) {
+	db, h, env, node := setupConsoleHandlers(t)
+	require.NoError(t, db.AutoMigrate(&logging.OsqueryQueryData{}))
+	session, err := h.Console.CreateSession(env, node, "alice")
+	require.NoError(t, err)
+
+	// Answer the listing as the node would while the request waits for it
+	go func() {
+		for i := 0; i < 40; i++ {
+			var listing console.Listing
+			if db.Where("session_id = ?", session.ID).Limit(1).Find(&listing).Error == nil && listing.ID != 0 {
+				data, _ := json.Marshal([]map[string]string{{"filename": "passwd", "type": "regular"}, {"filename": "pam.d", "type": "directory"}})
+				_ = db.Create(&logging.OsqueryQueryData{UUID: node.UUID, Name: listing.DistributedQueryName, Data: string(data)}).Error
+				_ = h.Queries.UpdateQueryStatus(listing.DistributedQueryName, node.ID, 0)
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+
+	req := consoleRequest(http.MethodGet, "/console?input="+url.QueryEscape("stat /etc/pa"), nil, "alice")
+	req.SetPathValue("env", env.Name)
+	req.SetPathValue("session_id", fmt.Sprint(session.ID))
+	rr := httptest.NewRecorder()
+	h.ConsoleCompleteHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var completion console.Completion
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &completion))
+	require.Equal(t, console.CompletionReady, completion.Status)
+	require.Equal(t, "/etc/pa", completion.Token)
+	require.Equal(t, []console.Candidate{
+		{Value: "/etc/pam.d/", Kind: console.CandidateDirectory},
+		{Value: "/etc/passwd", Kind: console.CandidateFile},
+	}, completion.Candidates)
+}
+
+func TestConsoleCompleteFiltersTablesByPlatform(t *testing.T) {
+	_, h, env, node := setupConsoleHandlers(t)
+	h.OsqueryTables = []types.OsqueryTable{
+		{Name: "deb_packages", Platforms: []string{"linux"}},
+		{Name: "drivers", Platforms: []string{"windows"}},
+	}
+	session, err := h.Console.CreateSession(env, node, "alice")
+	require.NoError(t, err)
+
+	req := consoleRequest(http.MethodGet, "/console?osquery_mode=true&input="+url.QueryEscape("select * from d"), nil, "alice")
+	req.SetPathValue("env", env.Name)
+	req.SetPathValue("session_id", fmt.Sprint(session.ID))
+	rr := httptest.NewRecorder()
+	h.ConsoleCompleteHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var completion console.Completion
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &completion))
+	require.Equal(t, []console.Candidate{{Value: "deb_packages", Kind: console.CandidateTable}}, completion.Candidates)
+
+	req = consoleRequest(http.MethodGet, "/console?input=ls", nil, "bob")
+	req.SetPathValue("env", env.Name)
+	req.SetPathValue("session_id", fmt.Sprint(session.ID))
+	rr = httptest.NewRecorder()
+	h.ConsoleCompleteHandler(rr, req)
+	require.Equal(t, http.StatusForbidden, rr.Code)
+}
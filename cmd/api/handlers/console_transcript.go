@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jmpsec/osctrl/pkg/utils"
+)
+
+const (
+	consoleTranscriptJSON = "json"
+	consoleTranscriptCast = "cast"
+	// consoleCastContentType is the media type of asciinema cast files
+	consoleCastContentType = "application/x-asciicast"
+)
+
+// ConsoleTranscriptHandler - GET /api/v1/console/{env}/sessions/{session_id}/transcript
+//
+// Exports the signed transcript of a console session for IR reports, as a
+// JSON document (`format=json`, default) or an asciinema cast
+// (`format=cast`). Any environment admin can export a session, not only the
+// operator who opened it, and every export is audited.
+func (h *HandlersApi) ConsoleTranscriptHandler(w http.ResponseWriter, r *http.Request) {
+	env, ctx, ok := h.consoleEnvContext(w, r)
+	if !ok {
+		return
+	}
+	sessionID, ok := consolePathUint(w, r, "session_id")
+	if !ok {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = consoleTranscriptJSON
+	}
+	if format != consoleTranscriptJSON && format != consoleTranscriptCast {
+		apiErrorResponse(w, "invalid format", http.StatusBadRequest, nil)
+		return
+	}
+	if h.ConsoleSigner == nil {
+		apiErrorResponse(w, "console transcript signing is not configured", http.StatusServiceUnavailable, nil)
+		return
+	}
+	session, err := h.Console.GetSession(sessionID)
+	if err != nil {
+		consoleNotFoundOrError(w, "session not found", "error getting session", err)
+		return
+	}
+	if session.EnvironmentID != env.ID {
+		apiErrorResponse(w, "session not found", http.StatusNotFound, nil)
+		return
+	}
+	transcript, err := h.Console.Transcript(session, env.Name, ctx[ctxUser])
+	if err != nil {
+		apiErrorResponse(w, "error building transcript", http.StatusInternalServerError, err)
+		return
+	}
+	if h.AuditLog != nil {
+		h.AuditLog.QueryAction(ctx[ctxUser], fmt.Sprintf("console transcript export of session %d", session.ID), strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	}
+	filename := fmt.Sprintf("osctrl-console-%d.%s", session.ID, format)
+	if format == consoleTranscriptCast {
+		cast, err := h.ConsoleSigner.SignCast(transcript)
+		if err != nil {
+			apiErrorResponse(w, "error signing transcript", http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", consoleCastContentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(cast)
+		return
+	}
+	signed, err := h.ConsoleSigner.Sign(transcript)
+	if err != nil {
+		apiErrorResponse(w, "error signing transcript", http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, signed)
+}
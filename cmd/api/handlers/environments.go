@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -44,25 +45,27 @@ func (h *HandlersApi) denyEnv(w http.ResponseWriter, r *http.Request, ctx Contex
 // QueryLevel / CarveLevel) user.
 func projectEnvironmentView(env environments.TLSEnvironment) types.TLSEnvironmentView {
 	return types.TLSEnvironmentView{
-		ID:             env.ID,
-		CreatedAt:      env.CreatedAt,
-		UpdatedAt:      env.UpdatedAt,
-		UUID:           env.UUID,
-		Name:           env.Name,
-		Hostname:       env.Hostname,
-		Type:           env.Type,
-		Icon:           env.Icon,
-		DebugHTTP:      env.DebugHTTP,
-		ConfigTLS:      env.ConfigTLS,
-		ConfigInterval: env.ConfigInterval,
-		LoggingTLS:     env.LoggingTLS,
-		LogInterval:    env.LogInterval,
-		QueryTLS:       env.QueryTLS,
-		QueryInterval:  env.QueryInterval,
-		CarvesTLS:      env.CarvesTLS,
-		AcceptEnrolls:  env.AcceptEnrolls,
-		EnrollExpire:   env.EnrollExpire,
-		RemoveExpire:   env.RemoveExpire,
+		ID:              env.ID,
+		CreatedAt:       env.CreatedAt,
+		UpdatedAt:       env.UpdatedAt,
+		UUID:            env.UUID,
+		Name:            env.Name,
+		Hostname:        env.Hostname,
+		Type:            env.Type,
+		Icon:            env.Icon,
+		DebugHTTP:       env.DebugHTTP,
+		ConfigTLS:       env.ConfigTLS,
+		ConfigInterval:  env.ConfigInterval,
+		LoggingTLS:      env.LoggingTLS,
+		LogInterval:     env.LogInterval,
+		QueryTLS:        env.QueryTLS,
+		QueryInterval:   env.QueryInterval,
+		CarvesTLS:       env.CarvesTLS,
+		AcceptEnrolls:   env.AcceptEnrolls,
+		RequireApproval: env.RequireApproval,
+		ClientCertAuth:  env.ClientCertAuth,
+		EnrollExpire:    env.EnrollExpire,
+		RemoveExpire:    env.RemoveExpire,
 	}
 }
 
@@ -311,21 +314,29 @@ func (h *HandlersApi) EnvEnrollHandler(w http.ResponseWriter, r *http.Request) {
 	case settings.DownloadFlags:
 		returnData = env.Flags
 	case settings.DownloadFlagsLinux:
-		returnData = substitutePlatformPaths(env.Flags, env.Name, "/etc/osquery", "/")
+		returnData = environments.PlatformFlags(env.Flags, env.Name, "/etc/osquery", "/")
 	case settings.DownloadFlagsMac:
-		returnData = substitutePlatformPaths(env.Flags, env.Name, "/private/var/osquery", "/")
+		returnData = environments.PlatformFlags(env.Flags, env.Name, "/private/var/osquery", "/")
 	case settings.DownloadFlagsWin:
-		returnData = substitutePlatformPaths(env.Flags, env.Name, "C:\\Program Files\\osquery", "\\")
+		returnData = environments.PlatformFlags(env.Flags, env.Name, "C:\\Program Files\\osquery", "\\")
 	case settings.DownloadFlagsFreeBSD:
-		returnData = substitutePlatformPaths(env.Flags, env.Name, "/usr/local/etc", "/")
+		returnData = environments.PlatformFlags(env.Flags, env.Name, "/usr/local/etc", "/")
 	case environments.EnrollShell:
 		returnData, err = environments.QuickAddOneLinerShell((env.Certificate != ""), env)
+		if errors.Is(err, environments.ErrClientCertScript) {
+			apiErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+			return
+		}
 		if err != nil {
 			apiErrorResponse(w, "error generating sh one-liner", http.StatusInternalServerError, err)
 			return
 		}
 	case environments.EnrollPowershell:
 		returnData, err = environments.QuickAddOneLinerPowershell((env.Certificate != ""), env)
+		if errors.Is(err, environments.ErrClientCertScript) {
+			apiErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+			return
+		}
 		if err != nil {
 			apiErrorResponse(w, "error generating ps1 one-liner", http.StatusInternalServerError, err)
 			return
@@ -398,12 +409,20 @@ func (h *HandlersApi) EnvRemoveHandler(w http.ResponseWriter, r *http.Request) {
 	switch targetVar {
 	case environments.RemoveShell:
 		returnData, err = environments.QuickRemoveOneLinerShell((env.Certificate != ""), env)
+		if errors.Is(err, environments.ErrClientCertScript) {
+			apiErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+			return
+		}
 		if err != nil {
 			apiErrorResponse(w, "error generating sh one-liner", http.StatusInternalServerError, err)
 			return
 		}
 	case environments.RemovePowershell:
 		returnData, err = environments.QuickRemoveOneLinerPowershell((env.Certificate != ""), env)
+		if errors.Is(err, environments.ErrClientCertScript) {
+			apiErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+			return
+		}
 		if err != nil {
 			apiErrorResponse(w, "error generating ps1 one-liner", http.StatusInternalServerError, err)
 			return
@@ -924,26 +943,3 @@ func (h *HandlersApi) EnvCertUploadHandler(w http.ResponseWriter, r *http.Reques
 	h.AuditLog.EnvAction(ctx[ctxUser], "upload certificate for environment "+env.Name, strings.Split(r.RemoteAddr, ":")[0], env.ID)
 	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "certificate uploaded successfully"})
 }
-
-// substitutePlatformPaths fills the __SECRET_FILE__ / __CERT_FILE__ placeholders
-// in the env.Flags template with the canonical install paths for a given OS.
-// This is the same substitution legacy admin's download path performs (see
-// cmd/admin/handlers/utils.go generateFlags); centralising it here keeps the
-// API's per-OS flag downloads producing the exact bytes operators expect to
-// drop into /etc/osquery/osctrl-{env}.flags (or the platform equivalent).
-//
-// sep is the path separator the OS uses ("/" for everything except Windows).
-//
-// strings.ReplaceAll is deliberate even though the flag template ships with
-// one occurrence of each placeholder today. If an operator ever edits the
-// template to reference the secret/cert path twice (e.g. an additional
-// --logger_tls_endpoint that needs the same path), the single-replace
-// would silently leave the second placeholder unsubstituted — a footgun
-// that costs a real outage.
-func substitutePlatformPaths(flags, envName, dir, sep string) string {
-	secretPath := dir + sep + "osctrl-" + envName + ".secret"
-	certPath := dir + sep + "osctrl-" + envName + ".crt"
-	out := strings.ReplaceAll(flags, "__SECRET_FILE__", secretPath)
-	out = strings.ReplaceAll(out, "__CERT_FILE__", certPath)
-	return out
-}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/jmpsec/osctrl/pkg/utils"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// EnvClientCertsHandler - GET Handler for the client certificates of an environment
+// @Summary Get client certificates
+// @Description Returns the client certificates issued to the nodes of an environment, newest first, with their revocation.
+// @Tags environments
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Success 200 {array} environments.ClientCertificate
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/environments/certs/{env} [get]
+func (h *HandlersApi) EnvClientCertsHandler(w http.ResponseWriter, r *http.Request) {
+	env, _, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	certs, err := h.Envs.ClientCerts(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting client certificates", http.StatusInternalServerError, err)
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, certs)
+}
+
+// EnvClientCertIssueHandler - POST Handler to issue a client certificate of an environment
+// @Summary Issue client certificate
+// @Description Issues a client certificate for the node with the host identifier in the common name, from the client CA of the environment, created if needed. The key of the certificate is only returned in this response.
+// @Tags environments
+// @Accept json
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param request body types.EnvClientCertRequest true "Request body"
+// @Success 201 {object} types.EnvClientCertResponse
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/environments/certs/{env} [post]
+func (h *HandlersApi) EnvClientCertIssueHandler(w http.ResponseWriter, r *http.Request) {
+	env, ctx, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	var body types.EnvClientCertRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		return
+	}
+	if strings.TrimSpace(body.CommonName) == "" {
+		apiErrorResponse(w, "missing common name", http.StatusBadRequest, nil)
+		return
+	}
+	if body.ValidityDays < 0 {
+		apiErrorResponse(w, "invalid validity days", http.StatusBadRequest, nil)
+		return
+	}
+	validity := time.Duration(body.ValidityDays) * 24 * time.Hour
+	cert, certPEM, keyPEM, err := h.Envs.IssueClientCert(env, body.CommonName, validity, ctx[ctxUser])
+	if err != nil {
+		apiErrorResponse(w, "error issuing client certificate", http.StatusInternalServerError, err)
+		return
+	}
+	ca, err := h.Envs.GetClientCA(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting client CA", http.StatusInternalServerError, err)
+		return
+	}
+	h.AuditLog.EnvAction(ctx[ctxUser], fmt.Sprintf("issue client certificate %s for %s in env %s", cert.Serial, cert.CommonName, env.Name), strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	log.Debug().Msgf("Issued client certificate %s for %s in %s", cert.Serial, cert.CommonName, env.Name)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusCreated, types.EnvClientCertResponse{
+		Serial:      cert.Serial,
+		CommonName:  cert.CommonName,
+		NotAfter:    cert.NotAfter,
+		Certificate: certPEM,
+		Key:         keyPEM,
+		CA:          ca.Certificate,
+	})
+}
+
+// EnvClientCertRevokeHandler - DELETE Handler to revoke a client certificate of an environment
+// @Summary Revoke client certificate
+// @Description Revokes a client certificate of an environment, so the node using it can not enroll or be served anymore, and adds it to the CRL of the environment.
+// @Tags environments
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param serial path string true "Certificate serial"
+// @Param reason query string false "Revocation reason"
+// @Success 200 {object} types.ApiGenericResponse
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 409 {object} types.ApiErrorResponse "Already revoked"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/environments/certs/{env}/{serial} [delete]
+func (h *HandlersApi) EnvClientCertRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	env, ctx, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	serial := r.PathValue("serial")
+	if serial == "" {
+		apiErrorResponse(w, "missing certificate serial", http.StatusBadRequest, nil)
+		return
+	}
+	cert, err := h.Envs.RevokeClientCert(env.ID, serial, r.URL.Query().Get("reason"), ctx[ctxUser])
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			apiErrorResponse(w, "client certificate not found", http.StatusNotFound, err)
+			return
+		}
+		if errors.Is(err, environments.ErrClientCertRevoked) {
+			apiErrorResponse(w, "client certificate already revoked", http.StatusConflict, err)
+			return
+		}
+		apiErrorResponse(w, "error revoking client certificate", http.StatusInternalServerError, err)
+		return
+	}
+	h.AuditLog.EnvAction(ctx[ctxUser], fmt.Sprintf("revoke client certificate %s for %s in env %s", cert.Serial, cert.CommonName, env.Name), strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "client certificate revoked"})
+}
+
+// EnvClientCAHandler - GET Handler for the client CA certificate of an environment
+// @Summary Get client CA
+// @Description Returns the PEM certificate of the client CA of an environment, to verify client certificates in proxies terminating TLS.
+// @Tags environments
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Success 200 {object} types.ApiDataResponse
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/environments/certs/ca/{env} [get]
+func (h *HandlersApi) EnvClientCAHandler(w http.ResponseWriter, r *http.Request) {
+	env, _, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	ca, err := h.Envs.GetClientCA(env.ID)
+	if err != nil {
+		if errors.Is(err, environments.ErrClientCANotFound) {
+			apiErrorResponse(w, "client CA not found", http.StatusNotFound, err)
+			return
+		}
+		apiErrorResponse(w, "error getting client CA", http.StatusInternalServerError, err)
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiDataResponse{Data: ca.Certificate})
+}
+
+// EnvClientCRLHandler - GET Handler for the client certificates revocation list of an environment
+// @Summary Get client CRL
+// @Description Returns the PEM revocation list signed by the client CA of an environment, with the revoked certificates that did not expire yet.
+// @Tags environments
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Success 200 {object} types.ApiDataResponse
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/environments/certs/crl/{env} [get]
+func (h *HandlersApi) EnvClientCRLHandler(w http.ResponseWriter, r *http.Request) {
+	env, _, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	crl, err := h.Envs.ClientCRL(env.ID)
+	if err != nil {
+		if errors.Is(err, environments.ErrClientCANotFound) {
+			apiErrorResponse(w, "client CA not found", http.StatusNotFound, err)
+			return
+		}
+		apiErrorResponse(w, "error generating client CRL", http.StatusInternalServerError, err)
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiDataResponse{Data: crl})
+}
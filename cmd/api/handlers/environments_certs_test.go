@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jmpsec/osctrl/pkg/auditlog"
+	"github.com/jmpsec/osctrl/pkg/config"
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvClientCerts(t *testing.T) {
+	_, h, env, _ := setupConsoleHandlers(t)
+	h.DebugHTTPConfig = &config.YAMLConfigurationDebug{}
+	h.AuditLog = &auditlog.AuditLogManager{}
+	serve := func(handler http.HandlerFunc, method, target, body, user string, values map[string]string) *httptest.ResponseRecorder {
+		var raw []byte
+		if body != "" {
+			raw = []byte(body)
+		}
+		req := consoleRequest(method, target, raw, user)
+		req.SetPathValue("env", env.Name)
+		for k, v := range values {
+			req.SetPathValue(k, v)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusForbidden, serve(h.EnvClientCertIssueHandler, http.MethodPost, "/certs", `{"common_name":"NODE-UUID"}`, "bob", nil).Code)
+	require.Equal(t, http.StatusBadRequest, serve(h.EnvClientCertIssueHandler, http.MethodPost, "/certs", `{"common_name":" "}`, "alice", nil).Code)
+	require.Equal(t, http.StatusNotFound, serve(h.EnvClientCAHandler, http.MethodGet, "/certs/ca", "", "alice", nil).Code)
+
+	rr := serve(h.EnvClientCertIssueHandler, http.MethodPost, "/certs", `{"common_name":"NODE-UUID","validity_days":30}`, "alice", nil)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var issued types.EnvClientCertResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &issued))
+	require.Equal(t, "NODE-UUID", issued.CommonName)
+	require.Contains(t, issued.Certificate, "BEGIN CERTIFICATE")
+	require.Contains(t, issued.Key, "PRIVATE KEY")
+
+	rr = serve(h.EnvClientCAHandler, http.MethodGet, "/certs/ca", "", "alice", nil)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var ca types.ApiDataResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &ca))
+	require.Equal(t, issued.CA, ca.Data)
+
+	rr = serve(h.EnvClientCertsHandler, http.MethodGet, "/certs", "", "alice", nil)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var certs []environments.ClientCertificate
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &certs))
+	require.Len(t, certs, 1)
+	require.Equal(t, issued.Serial, certs[0].Serial)
+
+	require.Equal(t, http.StatusNotFound, serve(h.EnvClientCertRevokeHandler, http.MethodDelete, "/certs/unknown", "", "alice", map[string]string{"serial": "abcd"}).Code)
+	rr = serve(h.EnvClientCertRevokeHandler, http.MethodDelete, "/certs/serial?reason=lost", "", "alice", map[string]string{"serial": issued.Serial})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, http.StatusConflict, serve(h.EnvClientCertRevokeHandler, http.MethodDelete, "/certs/serial", "", "alice", map[string]string{"serial": issued.Serial}).Code)
+	revoked, err := h.Envs.GetClientCert(env.ID, issued.Serial)
+	require.NoError(t, err)
+	require.Equal(t, "lost", revoked.Reason)
+	require.Equal(t, "alice", revoked.RevokedBy)
+
+	rr = serve(h.EnvClientCRLHandler, http.MethodGet, "/certs/crl", "", "alice", nil)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var crl types.ApiDataResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &crl))
+	require.Contains(t, crl.Data, "BEGIN X509 CRL")
+}
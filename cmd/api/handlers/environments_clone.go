@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jmpsec/osctrl/pkg/auditlog"
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/tags"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/jmpsec/osctrl/pkg/users"
+	"github.com/jmpsec/osctrl/pkg/utils"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// EnvironmentCloneHandler - POST Handler to create an environment from an existing one
+// @Summary Clone environment
+// @Description Creates an environment from an existing one used as template, copying its settings, intervals, configuration parts, overlays and user permissions, with a new UUID, secret and secret paths. Tag definitions and saved queries are copied when requested, all in one transaction. Super-admin only.
+// @Tags environments
+// @Accept json
+// @Produce json
+// @Param env path string true "Template environment name or UUID"
+// @Param request body types.EnvCloneRequest true "Request body"
+// @Success 201 {object} types.EnvCloneResponse
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 409 {object} types.ApiErrorResponse "Conflict"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/environments/clone/{env} [post]
+func (h *HandlersApi) EnvironmentCloneHandler(w http.ResponseWriter, r *http.Request) {
+	if h.DebugHTTPConfig.EnableHTTP {
+		utils.DebugHTTPDump(h.DebugHTTP, r, h.DebugHTTPConfig.ShowBody)
+	}
+	ctx := r.Context().Value(ContextKey(contextAPI)).(ContextValue)
+	if !h.Users.CheckPermissions(ctx[ctxUser], users.AdminLevel, users.NoEnvironment) {
+		h.denyEnv(w, r, ctx, auditlog.NoEnvironment, "permission check failed")
+		return
+	}
+	envVar := r.PathValue("env")
+	if envVar == "" {
+		apiErrorResponse(w, "missing env", http.StatusBadRequest, nil)
+		return
+	}
+	template, err := h.Envs.Get(envVar)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			apiErrorResponse(w, "environment not found", http.StatusNotFound, err)
+			return
+		}
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		return
+	}
+	var body types.EnvCloneRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		return
+	}
+	// Same canonical form and filters as EnvironmentCreateHandler
+	body.Name = strings.ToLower(strings.TrimSpace(body.Name))
+	body.Hostname = strings.TrimSpace(body.Hostname)
+	if !environments.EnvNameFilter(body.Name) {
+		apiErrorResponse(w, "invalid environment name", http.StatusBadRequest, nil)
+		return
+	}
+	if body.Hostname != "" && !environments.HostnameFilter(body.Hostname) {
+		apiErrorResponse(w, "invalid hostname", http.StatusBadRequest, nil)
+		return
+	}
+	if h.Envs.Exists(body.Name) {
+		apiErrorResponse(w, "environment with that name already exists", http.StatusConflict, nil)
+		return
+	}
+	resp := types.EnvCloneResponse{Template: template.Name}
+	copies := []environments.CloneCopy{
+		func(tx *gorm.DB, template, clone environments.TLSEnvironment) error {
+			var err error
+			if resp.Users, err = h.Users.CopyEnvPermissionsTx(tx, template, clone, ctx[ctxUser]); err != nil {
+				return err
+			}
+			// The cloning user gets full access when the template did not grant any
+			return h.Users.GrantEnvAccessTx(tx, ctx[ctxUser], clone, h.ServiceName)
+		},
+		func(tx *gorm.DB, template, clone environments.TLSEnvironment) error {
+			return h.Tags.NewTagTx(tx, clone.Name, "Tag for environment "+clone.Name, "", clone.Icon, ctx[ctxUser], clone.ID, false, tags.TagTypeEnv, "")
+		},
+	}
+	if body.Tags {
+		copies = append(copies, func(tx *gorm.DB, template, clone environments.TLSEnvironment) error {
+			var err error
+			resp.Tags, err = h.Tags.CopyTagsTx(tx, template.ID, clone.ID, ctx[ctxUser])
+			return err
+		})
+	}
+	if body.SavedQueries {
+		copies = append(copies, func(tx *gorm.DB, template, clone environments.TLSEnvironment) error {
+			var err error
+			resp.SavedQueries, err = h.Queries.CopySavedTx(tx, template.ID, clone.ID)
+			return err
+		})
+	}
+	clone, err := h.Envs.Clone(template.UUID, body.Name, body.Hostname, ctx[ctxUser], copies...)
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			apiErrorResponse(w, "environment with that name already exists", http.StatusConflict, err)
+			return
+		}
+		apiErrorResponse(w, "error cloning environment", http.StatusInternalServerError, err)
+		return
+	}
+	resp.Name = clone.Name
+	resp.UUID = clone.UUID
+	h.invalidateEnvCache(r.Context(), clone.UUID)
+	msg := fmt.Sprintf("clone env %s from %s with %d users, %d tags and %d saved queries", clone.Name, template.Name, resp.Users, resp.Tags, resp.SavedQueries)
+	h.AuditLog.EnvAction(ctx[ctxUser], msg, strings.Split(r.RemoteAddr, ":")[0], clone.ID)
+	log.Debug().Msgf("Cloned environment %s from %s", clone.Name, template.Name)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusCreated, resp)
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jmpsec/osctrl/pkg/auditlog"
+	"github.com/jmpsec/osctrl/pkg/config"
+	"github.com/jmpsec/osctrl/pkg/queries"
+	"github.com/jmpsec/osctrl/pkg/tags"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/jmpsec/osctrl/pkg/users"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvironmentCloneCopiesPermissionsTagsAndSavedQueries(t *testing.T) {
+	db, h, env, _ := setupConsoleHandlers(t)
+	h.AuditLog = &auditlog.AuditLogManager{}
+	h.DebugHTTPConfig = &config.YAMLConfigurationDebug{}
+	h.Tags = tags.CreateTagManager(db)
+	require.NoError(t, h.Users.Create(users.AdminUser{Username: "root", Admin: true}))
+	require.NoError(t, h.Tags.NewTag("canary", "Canary nodes", "", "", "alice", env.ID, false, tags.TagTypeTag, ""))
+	require.NoError(t, h.Queries.CreateSaved("uptime", "SELECT * FROM uptime;", "alice", env.ID))
+	require.NoError(t, db.Model(&env).Updates(map[string]interface{}{"hostname": "env.example.com", "schedule": `{"uptime":{"query":"SELECT * FROM uptime;","interval":60}}`}).Error)
+
+	clone := func(body types.EnvCloneRequest, user string) *httptest.ResponseRecorder {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		req := consoleRequest(http.MethodPost, "/clone", data, user)
+		req.SetPathValue("env", env.Name)
+		h.EnvironmentCloneHandler(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusForbidden, clone(types.EnvCloneRequest{Name: "staging"}, "alice").Code)
+	require.Equal(t, http.StatusBadRequest, clone(types.EnvCloneRequest{Name: "bad name"}, "root").Code)
+	require.Equal(t, http.StatusConflict, clone(types.EnvCloneRequest{Name: env.Name}, "root").Code)
+
+	rr := clone(types.EnvCloneRequest{Name: "Staging", Tags: true, SavedQueries: true}, "root")
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var resp types.EnvCloneResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Equal(t, "staging", resp.Name)
+	require.Equal(t, env.Name, resp.Template)
+	require.Equal(t, 1, resp.Users)
+	require.Equal(t, 1, resp.Tags)
+	require.Equal(t, 1, resp.SavedQueries)
+
+	staging, err := h.Envs.Get("staging")
+	require.NoError(t, err)
+	require.Equal(t, "env.example.com", staging.Hostname)
+	require.Contains(t, staging.Schedule, "uptime")
+	require.True(t, h.Users.CheckPermissions("alice", users.AdminLevel, staging.UUID))
+	require.False(t, h.Users.CheckPermissions("bob", users.UserLevel, staging.UUID))
+	require.True(t, h.Tags.ExistsByEnv("canary", staging.ID))
+	require.True(t, h.Tags.ExistsByEnv("staging", staging.ID))
+	require.False(t, h.Tags.ExistsByEnv(env.Name, staging.ID))
+	require.True(t, h.Queries.SavedExists("uptime", staging.ID))
+
+	// Tags and saved queries are only copied when requested
+	rr = clone(types.EnvCloneRequest{Name: "qa", Hostname: "qa.example.com"}, "root")
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	qa, err := h.Envs.Get("qa")
+	require.NoError(t, err)
+	require.Equal(t, "qa.example.com", qa.Hostname)
+	require.False(t, h.Tags.ExistsByEnv("canary", qa.ID))
+	require.False(t, h.Queries.SavedExists("uptime", qa.ID))
+	require.True(t, h.Users.CheckPermissions("alice", users.AdminLevel, qa.UUID))
+
+	// A failed copy does not leave a half cloned environment
+	require.NoError(t, db.Migrator().DropTable(&queries.SavedQuery{}))
+	rr = clone(types.EnvCloneRequest{Name: "dev", SavedQueries: true}, "root")
+	require.Equal(t, http.StatusInternalServerError, rr.Code, rr.Body.String())
+	require.False(t, h.Envs.Exists("dev"))
+	require.False(t, h.Tags.Exists("dev"))
+}
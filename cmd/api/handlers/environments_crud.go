@@ -125,7 +125,8 @@ func (h *HandlersApi) EnvironmentCreateHandler(w http.ResponseWriter, r *http.Re
 
 // EnvironmentUpdateHandler - PATCH /api/v1/environments/{env}
 //
-// Updates name / hostname / type / icon / debug_http / accept_enrolls.
+// Updates name / hostname / type / icon / debug_http / accept_enrolls /
+// require_approval.
 // Other env fields go through the per-section endpoints. Super-admin only.
 // @Summary Update environment
 // @Description Updates an environment.
@@ -235,6 +236,15 @@ func (h *HandlersApi) EnvironmentUpdateHandler(w http.ResponseWriter, r *http.Re
 	if body.AcceptEnrolls != nil {
 		patch["accept_enrolls"] = *body.AcceptEnrolls
 	}
+	if body.RequireApproval != nil {
+		patch["require_approval"] = *body.RequireApproval
+	}
+	// Flags include the client certificate of nodes when it is required
+	regenFlags := false
+	if body.ClientCertAuth != nil {
+		patch["client_cert_auth"] = *body.ClientCertAuth
+		regenFlags = *body.ClientCertAuth != env.ClientCertAuth
+	}
 	if len(patch) == 0 {
 		// Idempotent no-op — return the current env.
 		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, env)
@@ -253,6 +263,18 @@ func (h *HandlersApi) EnvironmentUpdateHandler(w http.ResponseWriter, r *http.Re
 		apiErrorResponse(w, "error updating environment", http.StatusInternalServerError, err)
 		return
 	}
+	if regenFlags {
+		env.ClientCertAuth = *body.ClientCertAuth
+		flags, err := h.Envs.GenerateFlags(env, "", "", h.OsqueryValues)
+		if err != nil {
+			apiErrorResponse(w, "error generating flags", http.StatusInternalServerError, err)
+			return
+		}
+		if err := h.Envs.UpdateFlags(env.UUID, flags); err != nil {
+			apiErrorResponse(w, "error updating flags", http.StatusInternalServerError, err)
+			return
+		}
+	}
 	h.invalidateEnvCache(r.Context(), env.UUID)
 	updated, _ := h.Envs.Get(envVar)
 	h.AuditLog.EnvAction(ctx[ctxUser], "update env "+env.Name, strings.Split(r.RemoteAddr, ":")[0], env.ID)
@@ -371,9 +393,13 @@ func (h *HandlersApi) EnvironmentConfigHandler(w http.ResponseWriter, r *http.Re
 //
 // Body: optional options/schedule/packs/decorators/atc/flags string fields.
 // Each non-nil field is validated as JSON before persisting; an invalid
-// payload is rejected with 400 (no partial writes).
+// payload is rejected with 400 (no partial writes). Options, packs and ATC
+// are also validated against the known osquery flags, rejected with 400
+// unless force is set, and the warnings are returned in the response. With a
+// rollout, the new revision is staged to a share of the nodes. Changes to the
+// configuration are rejected with 409 while a rollout is pending.
 // @Summary Update environment config
-// @Description Updates raw osquery config sections for an environment.
+// @Description Updates raw osquery config sections for an environment, optionally starting a staged rollout of the new revision. Rejected while a rollout is pending.
 // @Tags environments
 // @Accept json
 // @Produce json
@@ -450,35 +476,117 @@ func (h *HandlersApi) EnvironmentConfigPatchHandler(w http.ResponseWriter, r *ht
 		}
 		normalized[name] = s
 	}
-	if v, ok := normalized["options"]; ok {
-		if err := h.Envs.UpdateOptions(envVar, v); err != nil {
-			apiErrorResponse(w, "error updating options", http.StatusInternalServerError, err)
-			return
+	// Options, packs and ATC are checked against the known osquery flags,
+	// so typos do not ship silently. Errors block the write unless forced.
+	versions, err := h.Nodes.GetEnvOsqueryVersions(env.ID)
+	if err != nil {
+		log.Err(err).Msgf("error getting osquery versions for env %s", env.Name)
+	}
+	validation := environments.ValidateConfigParts(normalized["options"], normalized["packs"], normalized["atc"], versions)
+	if err := validation.Err(); err != nil && !body.Force {
+		apiErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+		return
+	}
+	// The assembled `configuration` blob is recomposed from the parts below.
+	// Flags is not part of the composed osquery config, so a flags-only
+	// patch skips it.
+	composedChanged := false
+	for _, k := range []string{"options", "schedule", "packs", "decorators", "atc"} {
+		if _, ok := normalized[k]; ok {
+			composedChanged = true
+			break
 		}
 	}
-	if v, ok := normalized["schedule"]; ok {
-		if err := h.Envs.UpdateSchedule(envVar, v); err != nil {
-			apiErrorResponse(w, "error updating schedule", http.StatusInternalServerError, err)
+	// Nodes are served the revisions of a pending rollout, so changes wait
+	// until it is promoted or aborted instead of being skipped by every node
+	if composedChanged {
+		if err := h.Envs.CheckNoPendingRollout(env.ID); err != nil {
+			apiErrorResponse(w, err.Error(), http.StatusConflict, err)
 			return
 		}
 	}
-	if v, ok := normalized["packs"]; ok {
-		if err := h.Envs.UpdatePacks(envVar, v); err != nil {
-			apiErrorResponse(w, "error updating packs", http.StatusInternalServerError, err)
+	var stages environments.ConfigRollout
+	if body.Rollout != nil {
+		if !composedChanged {
+			apiErrorResponse(w, "a rollout needs options, schedule, packs, decorators or ATC", http.StatusBadRequest, nil)
 			return
 		}
-	}
-	if v, ok := normalized["decorators"]; ok {
-		if err := h.Envs.UpdateDecorators(envVar, v); err != nil {
-			apiErrorResponse(w, "error updating decorators", http.StatusInternalServerError, err)
+		stages, err = environments.PrepareRollout(environments.ConfigRollout{
+			FromRevision: body.Rollout.FromRevision,
+			CanaryTag:    body.Rollout.CanaryTag,
+			Percent:      body.Rollout.Percent,
+			StepPercent:  body.Rollout.StepPercent,
+			StepMinutes:  body.Rollout.StepMinutes,
+			MaxErrorRate: body.Rollout.MaxErrorRate,
+			MinAdoption:  body.Rollout.MinAdoption,
+		})
+		if err != nil {
+			apiErrorResponse(w, "invalid rollout: "+err.Error(), http.StatusBadRequest, err)
 			return
 		}
 	}
-	if v, ok := normalized["atc"]; ok {
-		if err := h.Envs.UpdateATC(envVar, v); err != nil {
-			apiErrorResponse(w, "error updating atc", http.StatusInternalServerError, err)
+	auditMsg := "config patch on env " + env.Name
+	if len(validation.Errors) > 0 {
+		auditMsg += fmt.Sprintf(" forced with %d validation errors", len(validation.Errors))
+	}
+	var revision environments.ConfigRevision
+	var rollout environments.ConfigRollout
+	if body.Rollout != nil {
+		// The parts, the configuration, its revision and the rollout are
+		// written in one transaction, so nodes keep the previous revision
+		// until the rollout exists
+		cnf, err := h.patchedConf(env, normalized)
+		if err != nil {
+			apiErrorResponse(w, "error structuring configuration", http.StatusBadRequest, err)
 			return
 		}
+		revision, rollout, err = h.Envs.UpdateConfigurationRollout(env.UUID, cnf, ctx[ctxUser], body.Reason, stages)
+		if err != nil {
+			switch {
+			case errors.Is(err, environments.ErrRolloutPending):
+				apiErrorResponse(w, err.Error(), http.StatusConflict, err)
+			case errors.Is(err, environments.ErrRolloutUnchanged):
+				apiErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+			default:
+				apiErrorResponse(w, "error starting rollout", http.StatusInternalServerError, err)
+			}
+			return
+		}
+		auditMsg += fmt.Sprintf(" (revision %d), started rollout from revision %d at %d%%", revision.Revision, rollout.FromRevision, rollout.Percent)
+		if rollout.CanaryTag != "" {
+			auditMsg += " and tag " + rollout.CanaryTag
+		}
+	} else {
+		if v, ok := normalized["options"]; ok {
+			if err := h.Envs.UpdateOptions(envVar, v); err != nil {
+				apiErrorResponse(w, "error updating options", http.StatusInternalServerError, err)
+				return
+			}
+		}
+		if v, ok := normalized["schedule"]; ok {
+			if err := h.Envs.UpdateSchedule(envVar, v); err != nil {
+				apiErrorResponse(w, "error updating schedule", http.StatusInternalServerError, err)
+				return
+			}
+		}
+		if v, ok := normalized["packs"]; ok {
+			if err := h.Envs.UpdatePacks(envVar, v); err != nil {
+				apiErrorResponse(w, "error updating packs", http.StatusInternalServerError, err)
+				return
+			}
+		}
+		if v, ok := normalized["decorators"]; ok {
+			if err := h.Envs.UpdateDecorators(envVar, v); err != nil {
+				apiErrorResponse(w, "error updating decorators", http.StatusInternalServerError, err)
+				return
+			}
+		}
+		if v, ok := normalized["atc"]; ok {
+			if err := h.Envs.UpdateATC(envVar, v); err != nil {
+				apiErrorResponse(w, "error updating atc", http.StatusInternalServerError, err)
+				return
+			}
+		}
 	}
 	if v, ok := normalized["flags"]; ok {
 		if err := h.Envs.DB.Model(&env).Update("flags", v).Error; err != nil {
@@ -491,23 +599,22 @@ func (h *HandlersApi) EnvironmentConfigPatchHandler(w http.ResponseWriter, r *ht
 	// without this recompose the env's `configuration` field — which is what
 	// GET .../configuration/assembled returns and what agents receive on
 	// their next /config refresh — stays at the pre-patch value, so edits made
-	// here never show up on the enroll page's Configuration tab. Flags is not
-	// part of the composed osquery config, so a flags-only patch skips it.
-	composedChanged := false
-	for _, k := range []string{"options", "schedule", "packs", "decorators", "atc"} {
-		if _, ok := normalized[k]; ok {
-			composedChanged = true
-			break
-		}
-	}
-	if composedChanged {
-		if err := h.Envs.RefreshConfiguration(envVar); err != nil {
+	// here never show up on the enroll page's Configuration tab.
+	if composedChanged && body.Rollout == nil {
+		rev, err := h.Envs.RefreshConfigurationBy(envVar, ctx[ctxUser], body.Reason)
+		if err != nil {
+			if errors.Is(err, environments.ErrRolloutPending) {
+				apiErrorResponse(w, err.Error(), http.StatusConflict, err)
+				return
+			}
 			apiErrorResponse(w, "error refreshing configuration", http.StatusInternalServerError, err)
 			return
 		}
+		revision = rev
+		auditMsg += fmt.Sprintf(" (revision %d)", revision.Revision)
 	}
 	h.invalidateEnvCache(r.Context(), env.UUID)
-	h.AuditLog.ConfAction(ctx[ctxUser], "config patch on env "+env.Name, strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	h.AuditLog.ConfAction(ctx[ctxUser], auditMsg, strings.Split(r.RemoteAddr, ":")[0], env.ID)
 	updated, _ := h.Envs.Get(envVar)
 	resp := types.EnvConfigResponse{
 		Options:    updated.Options,
@@ -516,10 +623,42 @@ func (h *HandlersApi) EnvironmentConfigPatchHandler(w http.ResponseWriter, r *ht
 		Decorators: updated.Decorators,
 		ATC:        updated.ATC,
 		Flags:      updated.Flags,
+		Revision:   revision.Revision,
+		RolloutID:  rollout.ID,
+		Warnings:   validation.Messages(body.Force),
 	}
 	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, resp)
 }
 
+// patchedConf structures the configuration of an environment with the
+// sections of a config patch replacing its parts
+func (h *HandlersApi) patchedConf(env environments.TLSEnvironment, sections map[string]string) (environments.OsqueryConf, error) {
+	var cnf environments.OsqueryConf
+	part := func(name, current string) []byte {
+		if v, ok := sections[name]; ok {
+			return []byte(v)
+		}
+		return []byte(current)
+	}
+	var err error
+	if cnf.Options, err = h.Envs.GenStructOptions(part("options", env.Options)); err != nil {
+		return cnf, fmt.Errorf("error structuring options %w", err)
+	}
+	if cnf.Schedule, err = h.Envs.GenStructSchedule(part("schedule", env.Schedule)); err != nil {
+		return cnf, fmt.Errorf("error structuring schedule %w", err)
+	}
+	if cnf.Packs, err = h.Envs.GenStructPacks(part("packs", env.Packs)); err != nil {
+		return cnf, fmt.Errorf("error structuring packs %w", err)
+	}
+	if cnf.Decorators, err = h.Envs.GenStructDecorators(part("decorators", env.Decorators)); err != nil {
+		return cnf, fmt.Errorf("error structuring decorators %w", err)
+	}
+	if cnf.ATC, err = h.Envs.GenStructATC(part("atc", env.ATC)); err != nil {
+		return cnf, fmt.Errorf("error structuring ATC %w", err)
+	}
+	return cnf, nil
+}
+
 // EnvironmentIntervalsPatchHandler - PATCH /api/v1/environments/intervals/{env}
 //
 // Body: { config_interval?, log_interval?, query_interval? }. Updates the
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/jmpsec/osctrl/pkg/utils"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// revisionParam parses a revision number from a query parameter. When the
+// parameter is missing, the latest revision of the environment is used.
+func (h *HandlersApi) revisionParam(r *http.Request, name string, envID uint) (environments.ConfigRevision, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return h.Envs.LatestRevision(envID)
+	}
+	number, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return environments.ConfigRevision{}, fmt.Errorf("invalid revision %q", value)
+	}
+	return h.Envs.GetRevision(envID, uint(number))
+}
+
+// revisionError responds to an error getting a revision
+func revisionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apiErrorResponse(w, "revision not found", http.StatusNotFound, err)
+		return
+	}
+	apiErrorResponse(w, "error getting revision", http.StatusBadRequest, err)
+}
+
+// EnvRevisionsHandler - GET Handler for the configuration revisions of an environment
+// @Summary Get configuration revisions
+// @Description Returns the revisions of the assembled configuration of an environment, most recent first, with author, reason and hash.
+// @Tags environments
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Success 200 {array} environments.ConfigRevision
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/environments/revisions/{env} [get]
+func (h *HandlersApi) EnvRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	env, _, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	revisions, err := h.Envs.Revisions(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting revisions", http.StatusInternalServerError, err)
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, revisions)
+}
+
+// EnvRevisionHandler - GET Handler for a configuration revision of an environment
+// @Summary Get configuration revision
+// @Description Returns a revision of the assembled configuration of an environment with the configuration itself, the latest one if no revision is requested.
+// @Tags environments
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param revision query int false "Revision number"
+// @Success 200 {object} environments.ConfigRevision
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Security ApiKeyAuth
+// @Router /api/v1/environments/revision/{env} [get]
+func (h *HandlersApi) EnvRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	env, _, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	revision, err := h.revisionParam(r, "revision", env.ID)
+	if err != nil {
+		revisionError(w, err)
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, revision)
+}
+
+// EnvRevisionDiffHandler - GET Handler for the diff between two configuration revisions
+// @Summary Diff configuration revisions
+// @Description Returns the unified diff between two revisions of the assembled configuration of an environment. A missing revision is the latest one.
+// @Tags environments
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param from query int true "Revision to diff from"
+// @Param to query int false "Revision to diff to"
+// @Success 200 {object} types.EnvRevisionDiffResponse
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/environments/diff/{env} [get]
+func (h *HandlersApi) EnvRevisionDiffHandler(w http.ResponseWriter, r *http.Request) {
+	env, _, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	if r.URL.Query().Get("from") == "" {
+		apiErrorResponse(w, "revision to diff from is required", http.StatusBadRequest, nil)
+		return
+	}
+	from, err := h.revisionParam(r, "from", env.ID)
+	if err != nil {
+		revisionError(w, err)
+		return
+	}
+	to, err := h.revisionParam(r, "to", env.ID)
+	if err != nil {
+		revisionError(w, err)
+		return
+	}
+	diff, err := environments.DiffRevisions(from, to)
+	if err != nil {
+		apiErrorResponse(w, "error generating diff", http.StatusInternalServerError, err)
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.EnvRevisionDiffResponse{From: from.Revision, To: to.Revision, Diff: diff})
+}
+
+// EnvRollbackHandler - POST Handler to roll back the configuration of an environment
+// @Summary Roll back configuration
+// @Description Restores the configuration of an environment and all its parts from a revision in one transaction. The restored configuration is stored as a new revision.
+// @Tags environments
+// @Accept json
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param request body types.EnvRollbackRequest true "Request body"
+// @Success 200 {object} environments.ConfigRevision
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 409 {object} types.ApiErrorResponse "Conflict"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/environments/rollback/{env} [post]
+func (h *HandlersApi) EnvRollbackHandler(w http.ResponseWriter, r *http.Request) {
+	env, ctx, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	var body types.EnvRollbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		return
+	}
+	if body.Revision == 0 {
+		apiErrorResponse(w, "revision is required", http.StatusBadRequest, nil)
+		return
+	}
+	revision, err := h.Envs.Rollback(env.UUID, body.Revision, ctx[ctxUser], body.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			apiErrorResponse(w, "revision not found", http.StatusNotFound, err)
+		case errors.Is(err, environments.ErrRolloutPending):
+			apiErrorResponse(w, err.Error()+", abort it to roll back", http.StatusConflict, err)
+		default:
+			apiErrorResponse(w, "error rolling back configuration", http.StatusInternalServerError, err)
+		}
+		return
+	}
+	h.invalidateEnvCache(r.Context(), env.UUID)
+	msg := fmt.Sprintf("rolled back configuration of %s to revision %d (revision %d)", env.Name, body.Revision, revision.Revision)
+	if body.Reason != "" {
+		msg += ": " + body.Reason
+	}
+	h.AuditLog.ConfAction(ctx[ctxUser], msg, strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	log.Info().Msgf("Configuration of %s rolled back to revision %d by %s", env.Name, body.Revision, ctx[ctxUser])
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, revision)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jmpsec/osctrl/pkg/auditlog"
+	"github.com/jmpsec/osctrl/pkg/config"
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvConfigRevisionsDiffAndRollback(t *testing.T) {
+	db, h, env, _ := setupConsoleHandlers(t)
+	auditManager, err := auditlog.CreateAuditLogManager(db, config.ServiceAPI, true)
+	require.NoError(t, err)
+	h.AuditLog = auditManager
+	h.DebugHTTPConfig = &config.YAMLConfigurationDebug{}
+	patchSchedule := func(schedule, reason string) types.EnvConfigResponse {
+		body, err := json.Marshal(types.EnvConfigPatchRequest{Schedule: &schedule, Reason: reason})
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		req := consoleRequest(http.MethodPatch, "/environments/config/"+env.Name, body, "alice")
+		req.SetPathValue("env", env.Name)
+		h.EnvironmentConfigPatchHandler(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var resp types.EnvConfigResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp
+	}
+	get := func(target string, handler http.HandlerFunc, user string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := consoleRequest(http.MethodGet, target, nil, user)
+		req.SetPathValue("env", env.Name)
+		handler(rr, req)
+		return rr
+	}
+
+	// The environment of the test starts without configuration parts
+	require.NoError(t, db.Model(&env).Updates(map[string]interface{}{"options": "{}", "packs": "{}", "decorators": "{}", "atc": "{}"}).Error)
+	good := patchSchedule(`{"uptime":{"query":"SELECT * FROM uptime;","interval":60}}`, "add uptime")
+	bad := patchSchedule(`{"everything":{"query":"SELECT * FROM file;","interval":1}}`, "")
+	require.Equal(t, good.Revision+1, bad.Revision)
+
+	require.Equal(t, http.StatusForbidden, get("/revisions", h.EnvRevisionsHandler, "bob").Code)
+	rr := get("/revisions", h.EnvRevisionsHandler, "alice")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var revisions []environments.ConfigRevision
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &revisions))
+	require.Len(t, revisions, 2)
+	require.Equal(t, good.Revision, revisions[1].Revision)
+	require.Equal(t, "alice", revisions[1].Author)
+	require.Equal(t, "add uptime", revisions[1].Reason)
+
+	rr = get("/revision?revision=99", h.EnvRevisionHandler, "alice")
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	require.Equal(t, http.StatusBadRequest, get("/diff", h.EnvRevisionDiffHandler, "alice").Code)
+	rr = get("/diff?from="+fmt.Sprint(good.Revision), h.EnvRevisionDiffHandler, "alice")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var diff types.EnvRevisionDiffResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &diff))
+	require.Equal(t, bad.Revision, diff.To)
+	require.Contains(t, diff.Diff, "SELECT * FROM file;")
+
+	rr = httptest.NewRecorder()
+	req := consoleRequest(http.MethodPost, "/rollback", []byte(fmt.Sprintf(`{"revision":%d,"reason":"bad schedule"}`, good.Revision)), "alice")
+	req.SetPathValue("env", env.Name)
+	h.EnvRollbackHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var rolled environments.ConfigRevision
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &rolled))
+	require.Equal(t, bad.Revision+1, rolled.Revision)
+	require.Equal(t, good.Revision, rolled.RollbackOf)
+
+	current, err := h.Envs.Get(env.Name)
+	require.NoError(t, err)
+	require.Contains(t, current.Configuration, "uptime")
+	require.NotContains(t, current.Schedule, "everything")
+
+	logs, err := auditManager.GetByEnv(env.ID)
+	require.NoError(t, err)
+	var lines []string
+	for _, l := range logs {
+		lines = append(lines, l.Line)
+	}
+	audit := strings.Join(lines, "\n")
+	require.Contains(t, audit, fmt.Sprintf("config patch on env env (revision %d)", bad.Revision))
+	require.Contains(t, audit, fmt.Sprintf("to revision %d (revision %d): bad schedule", good.Revision, rolled.Revision))
+}
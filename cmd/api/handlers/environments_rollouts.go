@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/logging"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/jmpsec/osctrl/pkg/utils"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// RolloutUser is the audit log user of the background rollout job
+const RolloutUser = "osctrl"
+
+const (
+	rolloutActionHalt    = "halt"
+	rolloutActionResume  = "resume"
+	rolloutActionPromote = "promote"
+	rolloutActionAbort   = "abort"
+)
+
+// rolloutHealth gathers what the nodes of an environment reported during the
+// current stage of a rollout. Status logs are only counted when they are
+// stored in the database, otherwise only adoption is measured.
+func (h *HandlersApi) rolloutHealth(env environments.TLSEnvironment, rollout environments.ConfigRollout) (environments.RolloutHealth, error) {
+	var health environments.RolloutHealth
+	// Halted rollouts report the health of the cohort they had
+	cohort := rollout
+	cohort.Status = environments.RolloutActive
+	envNodes, err := h.Nodes.GetByEnv(env.Name, nodes.AllNodes, 0)
+	if err != nil {
+		return health, fmt.Errorf("error getting nodes %w", err)
+	}
+	adopted, err := h.Nodes.ChangedSince(env.ID, "config_hash", rollout.CreatedAt)
+	if err != nil {
+		return health, err
+	}
+	counts := map[string]logging.StatusCounts{}
+	if h.DB != nil && h.DB.Migrator().HasTable(&logging.OsqueryStatusData{}) {
+		if counts, err = logging.GetStatusCounts(h.DB, env.Name, rollout.StageStartedAt); err != nil {
+			return health, fmt.Errorf("error counting status logs %w", err)
+		}
+	}
+	for _, node := range envNodes {
+		// Quarantined nodes and nodes not approved are not served the
+		// configuration of the environment
+		if node.Quarantined || !node.Approved() {
+			continue
+		}
+		var tags []string
+		if rollout.CanaryTag != "" && h.Tags != nil {
+			if tags, err = h.Tags.GetTagNames(node); err != nil {
+				return health, fmt.Errorf("error getting tags %w", err)
+			}
+		}
+		status := counts[strings.ToUpper(node.UUID)]
+		if !cohort.Includes(node.UUID, tags) {
+			health.BaselineLogs += status.Total
+			health.BaselineErrors += status.Errors
+			continue
+		}
+		health.CohortNodes++
+		health.StatusLogs += status.Total
+		health.StatusErrors += status.Errors
+		if node.LastSeen.Before(rollout.StageStartedAt) {
+			continue
+		}
+		health.ReportingNodes++
+		if adopted[node.UUID] {
+			health.AdoptedNodes++
+		}
+	}
+	return health, nil
+}
+
+// rolloutAuditMessage describes a change of status of a rollout
+func rolloutAuditMessage(env environments.TLSEnvironment, rollout environments.ConfigRollout, status string) string {
+	switch status {
+	case environments.RolloutHalted:
+		return fmt.Sprintf("halted rollout of revision %d on %s at %d%%: %s", rollout.ToRevision, env.Name, rollout.Percent, rollout.HaltReason)
+	case environments.RolloutCompleted:
+		return fmt.Sprintf("completed rollout of revision %d on %s", rollout.ToRevision, env.Name)
+	case environments.RolloutAborted:
+		return fmt.Sprintf("aborted rollout of revision %d on %s, rolled back to revision %d", rollout.ToRevision, env.Name, rollout.FromRevision)
+	}
+	return fmt.Sprintf("rollout of revision %d on %s %s at %d%%", rollout.ToRevision, env.Name, status, rollout.Percent)
+}
+
+// RunRollouts checks the health of every active rollout, halting the ones
+// that regress and widening the ones whose stage is over. It is run
+// periodically in the background by osctrl-api.
+func (h *HandlersApi) RunRollouts() {
+	rollouts, err := h.Envs.ActiveRollouts()
+	if err != nil {
+		log.Err(err).Msg("error getting active rollouts")
+		return
+	}
+	for _, rollout := range rollouts {
+		env, err := h.Envs.GetByID(rollout.EnvironmentID)
+		if err != nil {
+			log.Err(err).Msgf("error getting environment of rollout %d", rollout.ID)
+			continue
+		}
+		health, err := h.rolloutHealth(env, rollout)
+		if err != nil {
+			log.Err(err).Msgf("error getting health of rollout %d on %s", rollout.ID, env.Name)
+			continue
+		}
+		status := rollout.Advance(health, time.Now())
+		if status == "" {
+			continue
+		}
+		saved, err := h.Envs.SaveRolloutFrom(rollout, environments.RolloutActive)
+		if err != nil {
+			log.Err(err).Msgf("error saving rollout %d on %s", rollout.ID, env.Name)
+			continue
+		}
+		if !saved {
+			log.Debug().Msgf("Rollout %d on %s changed while it was checked", rollout.ID, env.Name)
+			continue
+		}
+		if status == environments.RolloutActive {
+			status = "widened"
+		}
+		msg := rolloutAuditMessage(env, rollout, status)
+		if h.AuditLog != nil {
+			h.AuditLog.ConfAction(RolloutUser, msg, "", env.ID)
+		}
+		log.Info().Msg(msg)
+	}
+}
+
+// EnvRolloutHandler - GET Handler for the current configuration rollout of an environment
+// @Summary Get configuration rollout
+// @Description Returns the most recent configuration rollout of an environment with the health of its current stage: cohort size, config hash adoption and status log error rates.
+// @Tags environments
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Success 200 {object} environments.RolloutReport
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/environments/rollout/{env} [get]
+func (h *HandlersApi) EnvRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	env, _, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	rollouts, err := h.Envs.Rollouts(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting rollouts", http.StatusInternalServerError, err)
+		return
+	}
+	if len(rollouts) == 0 {
+		apiErrorResponse(w, "no rollouts in environment", http.StatusNotFound, nil)
+		return
+	}
+	var health environments.RolloutHealth
+	if rollouts[0].Pending() {
+		if health, err = h.rolloutHealth(env, rollouts[0]); err != nil {
+			apiErrorResponse(w, "error getting rollout health", http.StatusInternalServerError, err)
+			return
+		}
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, environments.NewRolloutReport(rollouts[0], health))
+}
+
+// EnvRolloutsHandler - GET Handler for the configuration rollouts of an environment
+// @Summary Get configuration rollouts
+// @Description Returns the configuration rollouts of an environment, most recent first.
+// @Tags environments
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Success 200 {array} environments.ConfigRollout
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/environments/rollouts/{env} [get]
+func (h *HandlersApi) EnvRolloutsHandler(w http.ResponseWriter, r *http.Request) {
+	env, _, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	rollouts, err := h.Envs.Rollouts(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting rollouts", http.StatusInternalServerError, err)
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, rollouts)
+}
+
+// EnvRolloutActionHandler - POST Handler to change the configuration rollout of an environment
+// @Summary Change configuration rollout
+// @Description Halts, resumes, promotes or aborts the pending rollout of an environment. Aborting rolls the environment back to the revision the rollout started from. Rollouts are started with the rollout of a configuration patch.
+// @Tags environments
+// @Accept json
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param request body types.EnvRolloutRequest true "Request body"
+// @Success 200 {object} environments.ConfigRollout
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/environments/rollout/{env} [post]
+func (h *HandlersApi) EnvRolloutActionHandler(w http.ResponseWriter, r *http.Request) {
+	env, ctx, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	var body types.EnvRolloutRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		return
+	}
+	var rollout environments.ConfigRollout
+	var err error
+	switch body.Action {
+	case rolloutActionHalt:
+		reason := body.Reason
+		if reason == "" {
+			reason = "halted by " + ctx[ctxUser]
+		}
+		rollout, err = h.Envs.HaltRollout(env.ID, reason)
+	case rolloutActionResume:
+		rollout, err = h.Envs.ResumeRollout(env.ID)
+	case rolloutActionPromote:
+		rollout, err = h.Envs.PromoteRollout(env.ID)
+	case rolloutActionAbort:
+		rollout, _, err = h.Envs.AbortRollout(env.UUID, ctx[ctxUser], body.Reason)
+		if err == nil {
+			h.invalidateEnvCache(r.Context(), env.UUID)
+		}
+	default:
+		apiErrorResponse(w, "invalid rollout action, rollouts are started with a configuration patch", http.StatusBadRequest, nil)
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			apiErrorResponse(w, "rollout not found", http.StatusNotFound, err)
+		default:
+			apiErrorResponse(w, "error in rollout "+body.Action, http.StatusBadRequest, err)
+		}
+		return
+	}
+	var msg string
+	switch body.Action {
+	case rolloutActionResume:
+		msg = rolloutAuditMessage(env, rollout, "resumed")
+	default:
+		msg = rolloutAuditMessage(env, rollout, rollout.Status)
+	}
+	h.AuditLog.ConfAction(ctx[ctxUser], msg, strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	log.Info().Msgf("Rollout %s on %s by %s", body.Action, env.Name, ctx[ctxUser])
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, rollout)
+}
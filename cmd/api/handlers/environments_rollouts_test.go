@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/auditlog"
+	"github.com/jmpsec/osctrl/pkg/config"
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/logging"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvRolloutStartReportAndHalt(t *testing.T) {
+	db, h, env, _ := setupConsoleHandlers(t)
+	auditManager, err := auditlog.CreateAuditLogManager(db, config.ServiceAPI, true)
+	require.NoError(t, err)
+	h.AuditLog = auditManager
+	h.DebugHTTPConfig = &config.YAMLConfigurationDebug{}
+	require.NoError(t, db.AutoMigrate(&logging.OsqueryStatusData{}))
+	post := func(body types.EnvRolloutRequest, user string) *httptest.ResponseRecorder {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		req := consoleRequest(http.MethodPost, "/rollout", data, user)
+		req.SetPathValue("env", env.Name)
+		h.EnvRolloutActionHandler(rr, req)
+		return rr
+	}
+	patch := func(body types.EnvConfigPatchRequest, user string) *httptest.ResponseRecorder {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		req := consoleRequest(http.MethodPatch, "/environments/config/"+env.Name, data, user)
+		req.SetPathValue("env", env.Name)
+		h.EnvironmentConfigPatchHandler(rr, req)
+		return rr
+	}
+	report := func() environments.RolloutReport {
+		rr := httptest.NewRecorder()
+		req := consoleRequest(http.MethodGet, "/rollout", nil, "alice")
+		req.SetPathValue("env", env.Name)
+		h.EnvRolloutHandler(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var resp environments.RolloutReport
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp
+	}
+
+	require.NoError(t, db.Model(&env).Updates(map[string]interface{}{"options": "{}", "schedule": "{}", "packs": "{}", "decorators": "{}", "atc": "{}"}).Error)
+	_, err = h.Envs.RefreshConfigurationBy(env.Name, "alice", "")
+	require.NoError(t, err)
+	before, err := h.Envs.Get(env.Name)
+	require.NoError(t, err)
+	schedule := `{"uptime":{"query":"SELECT * FROM uptime;","interval":60}}`
+	flags := `{}`
+
+	// Rollouts start with the configuration they roll out
+	require.Equal(t, http.StatusBadRequest, post(types.EnvRolloutRequest{Action: "start"}, "alice").Code)
+	require.Equal(t, http.StatusBadRequest, post(types.EnvRolloutRequest{Action: "widen"}, "alice").Code)
+	require.Equal(t, http.StatusForbidden, patch(types.EnvConfigPatchRequest{Schedule: &schedule, Rollout: &types.EnvRolloutStages{Percent: 50}}, "bob").Code)
+	require.Equal(t, http.StatusBadRequest, patch(types.EnvConfigPatchRequest{Flags: &flags, Rollout: &types.EnvRolloutStages{Percent: 50}}, "alice").Code)
+	require.Equal(t, http.StatusBadRequest, patch(types.EnvConfigPatchRequest{Schedule: &schedule, Rollout: &types.EnvRolloutStages{Percent: 200}}, "alice").Code)
+	unchanged, err := h.Envs.Get(env.Name)
+	require.NoError(t, err)
+	require.Equal(t, before.Schedule, unchanged.Schedule)
+	rr := patch(types.EnvConfigPatchRequest{Schedule: &schedule, Reason: "add uptime", Rollout: &types.EnvRolloutStages{Percent: 50, StepMinutes: 5}}, "alice")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var patched types.EnvConfigResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &patched))
+	require.NotZero(t, patched.RolloutID)
+	rollout, err := h.Envs.PendingRollout(env.ID)
+	require.NoError(t, err)
+	require.Equal(t, patched.RolloutID, rollout.ID)
+	require.Equal(t, patched.Revision, rollout.ToRevision)
+	require.Equal(t, environments.RolloutActive, rollout.Status)
+	require.Equal(t, 50, rollout.Percent)
+
+	// Changes of the configuration wait for the pending rollout
+	other := `{}`
+	require.Equal(t, http.StatusConflict, patch(types.EnvConfigPatchRequest{Schedule: &other}, "alice").Code)
+	require.Equal(t, http.StatusConflict, patch(types.EnvConfigPatchRequest{Schedule: &other, Rollout: &types.EnvRolloutStages{}}, "alice").Code)
+	current, err := h.Envs.Get(env.Name)
+	require.NoError(t, err)
+	require.Contains(t, current.Schedule, "uptime")
+	rr = patch(types.EnvConfigPatchRequest{Flags: &flags}, "alice")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	// Nodes of the environment on both sides of the rollout
+	var cohort, rest []string
+	for i := 0; len(cohort) < 2 || len(rest) < 2; i++ {
+		uuid := fmt.Sprintf("ROLLOUT-%d", i)
+		if rollout.Includes(uuid, nil) {
+			cohort = append(cohort, uuid)
+		} else {
+			rest = append(rest, uuid)
+		}
+	}
+	for _, uuid := range []string{cohort[0], cohort[1], rest[0], rest[1]} {
+		node := nodes.OsqueryNode{UUID: uuid, Environment: env.Name, EnvironmentID: env.ID, LastSeen: time.Now()}
+		require.NoError(t, db.Create(&node).Error)
+	}
+	require.NoError(t, db.Create(&nodes.NodeHistoryEntry{UUID: cohort[0], EnvironmentID: env.ID, Field: "config_hash", NewValue: "new"}).Error)
+
+	resp := report()
+	require.Equal(t, 2, resp.Health.CohortNodes)
+	require.Equal(t, 2, resp.Health.ReportingNodes)
+	require.Equal(t, 1, resp.Health.AdoptedNodes)
+	require.Equal(t, 0.5, resp.Adoption)
+
+	// Errors of the cohort halt the rollout in the next check
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Create(&logging.OsqueryStatusData{UUID: cohort[i%2], Environment: env.Name, Severity: "2"}).Error)
+		require.NoError(t, db.Create(&logging.OsqueryStatusData{UUID: rest[i%2], Environment: env.Name, Severity: "0"}).Error)
+	}
+	h.RunRollouts()
+	resp = report()
+	require.Equal(t, environments.RolloutHalted, resp.Rollout.Status)
+	require.Contains(t, resp.Rollout.HaltReason, "error rate")
+	require.Equal(t, 1.0, resp.ErrorRate)
+	require.Equal(t, 0.0, resp.BaselineErrorRate)
+
+	entries, err := auditManager.GetByEnv(env.ID)
+	require.NoError(t, err)
+	var halted bool
+	for _, entry := range entries {
+		if entry.Username == RolloutUser {
+			halted = true
+			require.Contains(t, entry.Line, "halted rollout")
+		}
+	}
+	require.True(t, halted)
+
+	rr = post(types.EnvRolloutRequest{Action: "resume"}, "alice")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	rr = post(types.EnvRolloutRequest{Action: "promote"}, "alice")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &rollout))
+	require.Equal(t, environments.RolloutCompleted, rollout.Status)
+	require.Equal(t, http.StatusNotFound, post(types.EnvRolloutRequest{Action: "halt"}, "alice").Code)
+}
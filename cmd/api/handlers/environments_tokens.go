@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/jmpsec/osctrl/pkg/utils"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// EnvEnrollTokensHandler - GET Handler for the enroll tokens of an environment
+// @Summary Get enroll tokens
+// @Description Returns the enroll tokens of an environment with their expiration, uses and restrictions, without their secrets.
+// @Tags environments
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Success 200 {array} environments.EnrollToken
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/environments/tokens/{env} [get]
+func (h *HandlersApi) EnvEnrollTokensHandler(w http.ResponseWriter, r *http.Request) {
+	env, _, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	tokens, err := h.Envs.EnrollTokens(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting enroll tokens", http.StatusInternalServerError, err)
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, tokens)
+}
+
+// EnvEnrollTokenCreateHandler - POST Handler to create an enroll token of an environment
+// @Summary Create enroll token
+// @Description Creates a named enroll token of an environment, optionally expiring, limited in uses, restricted to source networks and tagging the nodes enrolled with it. The secret of the token is only returned in this response.
+// @Tags environments
+// @Accept json
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param request body types.EnvEnrollTokenRequest true "Request body"
+// @Success 201 {object} types.EnvEnrollTokenResponse
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 409 {object} types.ApiErrorResponse "Conflict"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/environments/tokens/{env} [post]
+func (h *HandlersApi) EnvEnrollTokenCreateHandler(w http.ResponseWriter, r *http.Request) {
+	env, ctx, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	var body types.EnvEnrollTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		return
+	}
+	if body.ExpireHours < 0 {
+		apiErrorResponse(w, "invalid expiration hours", http.StatusBadRequest, nil)
+		return
+	}
+	token := environments.EnrollToken{
+		EnvironmentID: env.ID,
+		Name:          body.Name,
+		MaxUses:       body.MaxUses,
+		CreatedBy:     ctx[ctxUser],
+	}
+	if body.ExpireHours > 0 {
+		token.ExpiresAt = time.Now().Add(time.Duration(body.ExpireHours) * time.Hour)
+	}
+	token, secret, err := h.Envs.CreateEnrollToken(token, body.CIDRs, body.Tags)
+	if err != nil {
+		if errors.Is(err, environments.ErrEnrollTokenExists) {
+			apiErrorResponse(w, err.Error(), http.StatusConflict, err)
+			return
+		}
+		apiErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+		return
+	}
+	h.AuditLog.EnvAction(ctx[ctxUser], fmt.Sprintf("create enroll token %s in env %s", token.Name, env.Name), strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	log.Debug().Msgf("Created enroll token %s in %s", token.Name, env.Name)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusCreated, types.EnvEnrollTokenResponse{
+		Name:      token.Name,
+		Secret:    secret,
+		ExpiresAt: token.ExpiresAt,
+		MaxUses:   token.MaxUses,
+	})
+}
+
+// EnvEnrollTokenDeleteHandler - DELETE Handler to revoke an enroll token of an environment
+// @Summary Revoke enroll token
+// @Description Deletes an enroll token of an environment, so no more nodes can enroll with it. Nodes already enrolled with it are kept.
+// @Tags environments
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param name path string true "Enroll token name"
+// @Success 200 {object} types.ApiGenericResponse
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/environments/tokens/{env}/{name} [delete]
+func (h *HandlersApi) EnvEnrollTokenDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	env, ctx, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	name := r.PathValue("name")
+	if name == "" {
+		apiErrorResponse(w, "missing token name", http.StatusBadRequest, nil)
+		return
+	}
+	if err := h.Envs.DeleteEnrollToken(env.ID, name); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			apiErrorResponse(w, "enroll token not found", http.StatusNotFound, err)
+			return
+		}
+		apiErrorResponse(w, "error deleting enroll token", http.StatusInternalServerError, err)
+		return
+	}
+	h.AuditLog.EnvAction(ctx[ctxUser], fmt.Sprintf("revoke enroll token %s in env %s", name, env.Name), strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "enroll token revoked"})
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jmpsec/osctrl/pkg/auditlog"
+	"github.com/jmpsec/osctrl/pkg/config"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvironmentConfigPatchValidatesOptions(t *testing.T) {
+	db, h, env, node := setupConsoleHandlers(t)
+	h.DebugHTTPConfig = &config.YAMLConfigurationDebug{}
+	h.AuditLog = &auditlog.AuditLogManager{}
+	require.NoError(t, db.Model(&env).Updates(map[string]interface{}{"options": "{}", "schedule": "{}", "packs": "{}", "decorators": "{}", "atc": "{}"}).Error)
+	require.NoError(t, db.Model(&node).Update("osquery_version", "4.9.0").Error)
+	patch := func(req types.EnvConfigPatchRequest) *httptest.ResponseRecorder {
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		r := consoleRequest(http.MethodPatch, "/environments/config/"+env.Name, body, "alice")
+		r.SetPathValue("env", env.Name)
+		h.EnvironmentConfigPatchHandler(rr, r)
+		return rr
+	}
+
+	typo := `{"distributed_intervall": 60}`
+	rr := patch(types.EnvConfigPatchRequest{Options: &typo})
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	require.Contains(t, rr.Body.String(), "did you mean distributed_interval?")
+	current, err := h.Envs.Get(env.Name)
+	require.NoError(t, err)
+	require.Equal(t, "{}", current.Options)
+
+	// Forced writes report the errors as warnings
+	rr = patch(types.EnvConfigPatchRequest{Options: &typo, Force: true})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var resp types.EnvConfigResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Equal(t, typo, resp.Options)
+	require.Len(t, resp.Warnings, 1)
+
+	// Valid options newer than the nodes are written with a warning
+	newer := `{"distributed_interval": 60, "disable_endpointsecurity": true}`
+	rr = patch(types.EnvConfigPatchRequest{Options: &newer})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	resp = types.EnvConfigResponse{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Equal(t, []string{"options.disable_endpointsecurity: requires osquery 5.0.1 but nodes run 4.9.0"}, resp.Warnings)
+}
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"context"
+
 	"github.com/jmpsec/osctrl/pkg/auditlog"
 	"github.com/jmpsec/osctrl/pkg/carves"
 	"github.com/jmpsec/osctrl/pkg/config"
@@ -24,28 +26,33 @@ const errorContent = "❌"
 const okContent = "✅"
 
 type HandlersApi struct {
-	DB              *gorm.DB
-	Users           *users.UserManager
-	Tags            *tags.TagManager
-	Envs            *environments.EnvManager
-	EnvCache        *environments.EnvCache
-	Nodes           *nodes.NodeManager
-	Queries         *queries.Queries
-	Console         *console.Manager
-	Carves          *carves.Carves
-	Settings        *settings.Settings
-	Activity        activityReader
-	GeoIP           *geoip.GeoIPResolver
-	Posture         *posture.PostureManager
-	PostureEnabled  bool
-	ServiceVersion  string
-	ServiceName     string
-	AuditLog        *auditlog.AuditLogManager
-	ApiConfig       *config.APIConfiguration
-	DebugHTTP       *zerolog.Logger
-	DebugHTTPConfig *config.YAMLConfigurationDebug
-	OsqueryTables   []types.OsqueryTable
-	OsqueryValues   config.YAMLConfigurationOsquery
+	DB       *gorm.DB
+	Users    *users.UserManager
+	Tags     *tags.TagManager
+	Envs     *environments.EnvManager
+	EnvCache *environments.EnvCache
+	Nodes    *nodes.NodeManager
+	// NodeCacheInvalidator tells other processes, like osctrl-tls, that
+	// the cached copy of a node is stale
+	NodeCacheInvalidator func(ctx context.Context, nodeKey string)
+	Queries              *queries.Queries
+	Console              *console.Manager
+	ConsoleStream        *console.Broker
+	ConsoleSigner        *console.TranscriptSigner
+	Carves               *carves.Carves
+	Settings             *settings.Settings
+	Activity             activityReader
+	GeoIP                *geoip.GeoIPResolver
+	Posture              *posture.PostureManager
+	PostureEnabled       bool
+	ServiceVersion       string
+	ServiceName          string
+	AuditLog             *auditlog.AuditLogManager
+	ApiConfig            *config.APIConfiguration
+	DebugHTTP            *zerolog.Logger
+	DebugHTTPConfig      *config.YAMLConfigurationDebug
+	OsqueryTables        []types.OsqueryTable
+	OsqueryValues        config.YAMLConfigurationOsquery
 	// JWTSecret is the HMAC key used by pkg/auth state-cookie
 	// helpers. Populated via WithJWTSecret at handler init. Same
 	// bytes the Users manager signs user JWTs with; the auth
@@ -105,6 +112,12 @@ func WithNodes(nodes *nodes.NodeManager) HandlersOption {
 	}
 }
 
+func WithNodeCacheInvalidator(fn func(ctx context.Context, nodeKey string)) HandlersOption {
+	return func(h *HandlersApi) {
+		h.NodeCacheInvalidator = fn
+	}
+}
+
 func WithQueries(queries *queries.Queries) HandlersOption {
 	return func(h *HandlersApi) {
 		h.Queries = queries
@@ -117,6 +130,18 @@ func WithConsole(consoleManager *console.Manager) HandlersOption {
 	}
 }
 
+func WithConsoleStream(broker *console.Broker) HandlersOption {
+	return func(h *HandlersApi) {
+		h.ConsoleStream = broker
+	}
+}
+
+func WithConsoleSigner(signer *console.TranscriptSigner) HandlersOption {
+	return func(h *HandlersApi) {
+		h.ConsoleSigner = signer
+	}
+}
+
 func WithCarves(carves *carves.Carves) HandlersOption {
 	return func(h *HandlersApi) {
 		h.Carves = carves
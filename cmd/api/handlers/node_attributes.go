@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/jmpsec/osctrl/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// NodeAttributesHandler - GET Handler for the custom attributes of a node
+// @Summary Get node attributes
+// @Description Returns the typed custom attributes of a node, like its owner, cost center, criticality or location.
+// @Tags nodes
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param node path string true "Node UUID, hostname, or local name"
+// @Success 200 {array} nodes.NodeAttribute
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/node/{node}/attributes [get]
+func (h *HandlersApi) NodeAttributesHandler(w http.ResponseWriter, r *http.Request) {
+	_, node, _, ok := h.nodeAdminContext(w, r)
+	if !ok {
+		return
+	}
+	attrs, err := h.Nodes.Attributes(node.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting attributes", http.StatusInternalServerError, err)
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, attrs)
+}
+
+// NodeAttributeSetHandler - PUT Handler to set a custom attribute of a node
+// @Summary Set node attribute
+// @Description Creates or replaces a custom attribute of a node. The type is string, number or bool, string by default.
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param node path string true "Node UUID, hostname, or local name"
+// @Param request body types.ApiNodeAttributeRequest true "Request body"
+// @Success 200 {object} nodes.NodeAttribute
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/node/{node}/attributes [put]
+func (h *HandlersApi) NodeAttributeSetHandler(w http.ResponseWriter, r *http.Request) {
+	env, node, ctx, ok := h.nodeAdminContext(w, r)
+	if !ok {
+		return
+	}
+	var body types.ApiNodeAttributeRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiErrorResponse(w, "error parsing PUT body", http.StatusBadRequest, err)
+		return
+	}
+	if _, _, err := nodes.NormalizeAttribute(body.Name, body.Type, body.Value); err != nil {
+		apiErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+		return
+	}
+	attr, err := h.Nodes.SetAttribute(node, body.Name, body.Type, body.Value, ctx[ctxUser])
+	if err != nil {
+		apiErrorResponse(w, "error setting attribute", http.StatusInternalServerError, err)
+		return
+	}
+	h.auditNodeAction(ctx[ctxUser], fmt.Sprintf("set attribute %s=%s of node %s", attr.Name, attr.Value, node.UUID), strings.Split(r.RemoteAddr, ":")[0], node, env.ID)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, attr)
+}
+
+// NodeAttributeDeleteHandler - DELETE Handler to remove a custom attribute of a node
+// @Summary Delete node attribute
+// @Description Removes a custom attribute of a node.
+// @Tags nodes
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param node path string true "Node UUID, hostname, or local name"
+// @Param name path string true "Attribute name"
+// @Success 200 {object} types.ApiGenericResponse
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/node/{node}/attributes/{name} [delete]
+func (h *HandlersApi) NodeAttributeDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	env, node, ctx, ok := h.nodeAdminContext(w, r)
+	if !ok {
+		return
+	}
+	name := r.PathValue("name")
+	if err := h.Nodes.DeleteAttribute(node.ID, name); err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "attribute not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error deleting attribute", http.StatusInternalServerError, err)
+		}
+		return
+	}
+	h.auditNodeAction(ctx[ctxUser], fmt.Sprintf("deleted attribute %s of node %s", name, node.UUID), strings.Split(r.RemoteAddr, ":")[0], node, env.ID)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "attribute deleted"})
+}
+
+// NodeAttributesImportHandler - POST Handler to bulk import custom attributes
+// @Summary Import node attributes
+// @Description Sets custom attributes on the nodes matching each record by UUID, hostname or hardware serial. The body is a JSON array of records, or CSV with Content-Type text/csv whose header has a uuid, hostname or serial column and one name or name:type column per attribute.
+// @Tags nodes
+// @Accept json,text/csv
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param request body []nodes.AttributeRecord true "Request body"
+// @Success 200 {object} nodes.AttributeImportResult
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/attributes/import [post]
+func (h *HandlersApi) NodeAttributesImportHandler(w http.ResponseWriter, r *http.Request) {
+	env, ctx, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	var records []nodes.AttributeRecord
+	var err error
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "text/csv" {
+		records, err = nodes.ParseAttributeCSV(r.Body)
+	} else {
+		records, err = nodes.ParseAttributeJSON(r.Body)
+	}
+	if err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		return
+	}
+	result, err := h.Nodes.ImportAttributes(env.ID, records, ctx[ctxUser])
+	if err != nil {
+		apiErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+		return
+	}
+	log.Debug().Msgf("Imported %d attributes for %d nodes of %s", result.Updated, result.Nodes, env.Name)
+	if h.AuditLog != nil {
+		h.AuditLog.NodeAction(ctx[ctxUser], fmt.Sprintf("imported %d attributes for %d nodes", result.Updated, result.Nodes), strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, result)
+}
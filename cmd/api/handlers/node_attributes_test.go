@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jmpsec/osctrl/pkg/auditlog"
+	"github.com/jmpsec/osctrl/pkg/config"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeAttributesSetImportAndList(t *testing.T) {
+	db, h, env, node := setupConsoleHandlers(t)
+	h.AuditLog = &auditlog.AuditLogManager{}
+	h.DebugHTTPConfig = &config.YAMLConfigurationDebug{}
+	require.NoError(t, db.Model(&node).Update("hardware_serial", "SN-1").Error)
+
+	rr := httptest.NewRecorder()
+	req := consoleRequest(http.MethodPut, "/attributes", []byte(`{"name":"criticality","type":"number","value":"high"}`), "alice")
+	req.SetPathValue("env", env.Name)
+	req.SetPathValue("node", node.UUID)
+	h.NodeAttributeSetHandler(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	req = consoleRequest(http.MethodPut, "/attributes", []byte(`{"name":"criticality","type":"number","value":"3"}`), "alice")
+	req.SetPathValue("env", env.Name)
+	req.SetPathValue("node", node.UUID)
+	h.NodeAttributeSetHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	req = consoleRequest(http.MethodPost, "/attributes/import", []byte("serial,owner,location\nSN-1,alice,Madrid\n"), "alice")
+	req.Header.Set("Content-Type", "text/csv")
+	req.SetPathValue("env", env.Name)
+	h.NodeAttributesImportHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var result nodes.AttributeImportResult
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	require.Equal(t, 2, result.Updated)
+
+	rr = httptest.NewRecorder()
+	req = consoleRequest(http.MethodGet, "/attributes", nil, "alice")
+	req.SetPathValue("env", env.Name)
+	req.SetPathValue("node", node.UUID)
+	h.NodeAttributesHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var attrs []nodes.NodeAttribute
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &attrs))
+	require.Len(t, attrs, 3)
+	require.Equal(t, "location", attrs[1].Name)
+	require.Equal(t, "Madrid", attrs[1].Value)
+
+	rr = httptest.NewRecorder()
+	req = consoleRequest(http.MethodGet, "/attributes", nil, "bob")
+	req.SetPathValue("env", env.Name)
+	req.SetPathValue("node", node.UUID)
+	h.NodeAttributesHandler(rr, req)
+	require.Equal(t, http.StatusForbidden, rr.Code)
+}
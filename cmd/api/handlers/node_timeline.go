@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/jmpsec/osctrl/pkg/users"
+	"github.com/jmpsec/osctrl/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// NodeTimelineHandler - GET Handler for the timeline of a node
+// @Summary Get node timeline
+// @Description Returns the history of a node oldest first, merging enrollments, metadata changes, environment moves, key revocations, quarantines, queries, carves, console sessions and tag changes.
+// @Tags nodes
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param node path string true "Node UUID, hostname, or local name"
+// @Success 200 {array} types.NodeTimelineEvent
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/node/{node}/timeline [get]
+func (h *HandlersApi) NodeTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	// Debug HTTP if enabled
+	if h.DebugHTTPConfig.EnableHTTP {
+		utils.DebugHTTPDump(h.DebugHTTP, r, h.DebugHTTPConfig.ShowBody)
+	}
+	// Extract environment
+	envVar := r.PathValue("env")
+	if envVar == "" {
+		apiErrorResponse(w, "error with environment", http.StatusBadRequest, nil)
+		return
+	}
+	// Get environment
+	env, err := h.Envs.Get(envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(ContextKey(contextAPI)).(ContextValue)
+	if !h.Users.CheckPermissions(ctx[ctxUser], users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		return
+	}
+	// Extract host identifier for node
+	nodeVar := r.PathValue("node")
+	if nodeVar == "" {
+		apiErrorResponse(w, "error getting node", http.StatusBadRequest, nil)
+		return
+	}
+	// Get node by identifier, scoped to this environment
+	node, err := h.Nodes.GetByIdentifierEnv(nodeVar, env.ID)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "node not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting node", http.StatusInternalServerError, err)
+		}
+		return
+	}
+	timeline, err := h.nodeTimeline(node)
+	if err != nil {
+		apiErrorResponse(w, "error getting node timeline", http.StatusInternalServerError, err)
+		return
+	}
+	log.Debug().Msgf("Returned timeline for node %s", nodeVar)
+	h.auditNodeAction(ctx[ctxUser], "viewed timeline of node "+nodeVar, strings.Split(r.RemoteAddr, ":")[0], node, env.ID)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, timeline)
+}
+
+// nodeTimeline merges everything recorded about a node in chronological order
+func (h *HandlersApi) nodeTimeline(node nodes.OsqueryNode) ([]types.NodeTimelineEvent, error) {
+	timeline := []types.NodeTimelineEvent{{
+		Time:    node.CreatedAt,
+		Type:    types.TimelineEnroll,
+		Summary: "enrolled as " + node.Hostname,
+		Details: map[string]string{"platform": node.Platform, "osquery_version": node.OsqueryVersion},
+	}}
+	archives, err := h.Nodes.GetArchives(node.UUID)
+	if err != nil {
+		return nil, err
+	}
+	for _, archived := range archives {
+		event := types.NodeTimelineEvent{
+			Time:    archived.CreatedAt,
+			Type:    types.TimelineArchive,
+			Summary: "archived (" + archived.Trigger + ")",
+			Details: map[string]string{"trigger": archived.Trigger, "hostname": archived.Hostname, "environment": archived.Environment},
+		}
+		// Enrolling again an existing node archives its previous record
+		if archived.Trigger == "exists" {
+			event.Type = types.TimelineReenroll
+			event.Summary = "re-enrolled, previously " + archived.Hostname
+		}
+		timeline = append(timeline, event)
+	}
+	history, err := h.Nodes.History(node.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range history {
+		timeline = append(timeline, types.NodeTimelineEvent{
+			Time:    entry.CreatedAt,
+			Type:    types.TimelineMetadata,
+			Summary: fmt.Sprintf("%s changed from %q to %q", entry.Field, entry.OldValue, entry.NewValue),
+			Details: map[string]string{"field": entry.Field, "old": entry.OldValue, "new": entry.NewValue},
+		})
+	}
+	moves, err := h.Nodes.Moves(node.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, move := range moves {
+		timeline = append(timeline, types.NodeTimelineEvent{
+			Time:    move.CreatedAt,
+			Type:    types.TimelineMove,
+			Summary: fmt.Sprintf("moved from %s to %s", move.FromEnvironment, move.ToEnvironment),
+			Actor:   move.MovedBy,
+			Details: map[string]string{"from": move.FromEnvironment, "to": move.ToEnvironment},
+		})
+	}
+	revocations, err := h.Nodes.Revocations(node.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, revocation := range revocations {
+		timeline = append(timeline, types.NodeTimelineEvent{
+			Time:    revocation.CreatedAt,
+			Type:    types.TimelineRevoke,
+			Summary: "node key revoked",
+			Actor:   revocation.RevokedBy,
+			Details: map[string]string{"reason": revocation.Reason},
+		})
+	}
+	quarantines, err := h.Nodes.Quarantines(node.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, quarantine := range quarantines {
+		timeline = append(timeline, types.NodeTimelineEvent{
+			Time:    quarantine.CreatedAt,
+			Type:    types.TimelineQuarantine,
+			Summary: "quarantined",
+			Actor:   quarantine.QuarantinedBy,
+			Details: map[string]string{"reason": quarantine.Reason},
+		})
+		if quarantine.ReleasedAt != nil {
+			timeline = append(timeline, types.NodeTimelineEvent{
+				Time:    *quarantine.ReleasedAt,
+				Type:    types.TimelineRelease,
+				Summary: "released from quarantine",
+				Actor:   quarantine.ReleasedBy,
+			})
+		}
+	}
+	nodeQueries, err := h.Queries.GetNodeQueryHistory(node.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, query := range nodeQueries {
+		timeline = append(timeline, types.NodeTimelineEvent{
+			Time:    query.CreatedAt,
+			Type:    types.TimelineQuery,
+			Summary: fmt.Sprintf("%s query %s (%s)", query.Type, query.Name, query.Status),
+			Actor:   query.Creator,
+			Details: map[string]string{"name": query.Name, "query": query.Query, "status": query.Status},
+		})
+	}
+	carved, err := h.Carves.GetNodeCarvesByID(node.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, carve := range carved {
+		timeline = append(timeline, types.NodeTimelineEvent{
+			Time:    carve.CreatedAt,
+			Type:    types.TimelineCarve,
+			Summary: fmt.Sprintf("carved %s (%s)", carve.Path, carve.Status),
+			Actor:   carve.Carver,
+			Details: map[string]string{"carve_id": carve.CarveID, "query": carve.QueryName, "path": carve.Path, "status": carve.Status},
+		})
+	}
+	sessions, err := h.Console.NodeSessions(node.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		event := types.NodeTimelineEvent{
+			Time:    session.CreatedAt,
+			Type:    types.TimelineConsole,
+			Summary: "console session opened",
+			Actor:   session.Creator,
+			Details: map[string]string{"session_id": fmt.Sprint(session.ID)},
+		}
+		if session.Group {
+			event.Summary = "group console session opened"
+			event.Details["label"] = session.Label
+		}
+		if session.ClosedAt != nil {
+			event.Details["closed_at"] = session.ClosedAt.UTC().Format(time.RFC3339)
+		}
+		timeline = append(timeline, event)
+	}
+	tagged, err := h.Tags.GetTagHistory(node.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tagged {
+		timeline = append(timeline, types.NodeTimelineEvent{
+			Time:    t.CreatedAt,
+			Type:    types.TimelineTag,
+			Summary: "tagged " + t.Tag,
+			Actor:   t.TaggedBy,
+			Details: map[string]string{"tag": t.Tag},
+		})
+		if t.DeletedAt.Valid {
+			timeline = append(timeline, types.NodeTimelineEvent{
+				Time:    t.DeletedAt.Time,
+				Type:    types.TimelineUntag,
+				Summary: "untagged " + t.Tag,
+				Details: map[string]string{"tag": t.Tag},
+			})
+		}
+	}
+	sort.SliceStable(timeline, func(i, j int) bool { return timeline[i].Time.Before(timeline[j].Time) })
+	return timeline, nil
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/jmpsec/osctrl/pkg/auditlog"
+	"github.com/jmpsec/osctrl/pkg/carves"
+	"github.com/jmpsec/osctrl/pkg/config"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/queries"
+	"github.com/jmpsec/osctrl/pkg/tags"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeTimelineMergesNodeHistory(t *testing.T) {
+	db, h, env, node := setupConsoleHandlers(t)
+	h.Tags = tags.CreateTagManager(db)
+	h.AuditLog = &auditlog.AuditLogManager{}
+	h.DebugHTTPConfig = &config.YAMLConfigurationDebug{}
+	require.NoError(t, db.Model(&node).Update("hostname", "alpha").Error)
+	node.Hostname = "alpha"
+
+	require.NoError(t, h.Nodes.UpdateMetadataByUUID(node.UUID, nodes.NodeMetadata{Hostname: "bravo"}))
+	require.NoError(t, h.Nodes.Archive(node.UUID, "exists"))
+	query := queries.DistributedQuery{Name: "q1", Creator: "alice", Query: "select 1", Type: queries.StandardQueryType, EnvironmentID: env.ID}
+	require.NoError(t, h.Queries.Create(&query))
+	require.NoError(t, h.Queries.CreateNodeQueries([]uint{node.ID}, query.ID))
+	require.NoError(t, h.Carves.CreateCarve(carves.CarvedFile{CarveID: "carve-1", UUID: node.UUID, NodeID: node.ID, Path: "/etc/hosts", Status: "completed", Carver: "alice"}))
+	_, err := h.Console.CreateSession(env, node, "alice")
+	require.NoError(t, err)
+	require.NoError(t, h.Tags.TagNode("incident", node, "alice", false, tags.TagTypeCustom, ""))
+	require.NoError(t, h.Tags.UntagNode("incident", node))
+
+	req := consoleRequest(http.MethodGet, "/timeline", nil, "alice")
+	req.SetPathValue("env", env.Name)
+	req.SetPathValue("node", node.UUID)
+	rr := httptest.NewRecorder()
+	h.NodeTimelineHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var timeline []types.NodeTimelineEvent
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &timeline))
+	require.True(t, sort.SliceIsSorted(timeline, func(i, j int) bool { return timeline[i].Time.Before(timeline[j].Time) }))
+	byType := map[string]types.NodeTimelineEvent{}
+	for _, event := range timeline {
+		byType[event.Type] = event
+	}
+	require.Len(t, timeline, 8)
+	require.Equal(t, `hostname changed from "alpha" to "bravo"`, byType[types.TimelineMetadata].Summary)
+	require.Equal(t, "re-enrolled, previously bravo", byType[types.TimelineReenroll].Summary)
+	require.Equal(t, "q1", byType[types.TimelineQuery].Details["name"])
+	require.Equal(t, "/etc/hosts", byType[types.TimelineCarve].Details["path"])
+	require.Equal(t, "alice", byType[types.TimelineConsole].Actor)
+	require.Equal(t, "incident", byType[types.TimelineTag].Details["tag"])
+	require.Equal(t, "incident", byType[types.TimelineUntag].Details["tag"])
+	require.Contains(t, byType, types.TimelineEnroll)
+}
+
+func TestNodeTimelineRejectsUserWithoutAdminPermission(t *testing.T) {
+	_, h, env, node := setupConsoleHandlers(t)
+	h.DebugHTTPConfig = &config.YAMLConfigurationDebug{}
+
+	req := consoleRequest(http.MethodGet, "/timeline", nil, "bob")
+	req.SetPathValue("env", env.Name)
+	req.SetPathValue("node", node.UUID)
+	rr := httptest.NewRecorder()
+	h.NodeTimelineHandler(rr, req)
+	require.Equal(t, http.StatusForbidden, rr.Code)
+}
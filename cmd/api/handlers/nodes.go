@@ -74,7 +74,7 @@ func (h *HandlersApi) NodeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Debug().Msgf("Returned node %s", nodeVar)
-	h.AuditLog.NodeAction(ctx[ctxUser], "viewed node "+nodeVar, strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	h.auditNodeAction(ctx[ctxUser], "viewed node "+nodeVar, strings.Split(r.RemoteAddr, ":")[0], node, env.ID)
 	// Project to the SPA-facing view that surfaces parsed-and-sanitized
 	// enrichment fields (CPU cores, BIOS, hardware vendor/model) parsed from
 	// the otherwise-hidden RawEnrollment blob. The enroll_secret inside that
@@ -306,7 +306,8 @@ func (h *HandlersApi) DeleteNodeHandler(w http.ResponseWriter, r *http.Request)
 		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
 		return
 	}
-	if _, err := h.Nodes.GetByUUIDEnv(n.UUID, env.ID); err != nil {
+	node, err := h.Nodes.GetByUUIDEnv(n.UUID, env.ID)
+	if err != nil {
 		apiErrorResponse(w, "node not found", http.StatusNotFound, err)
 		return
 	}
@@ -319,7 +320,7 @@ func (h *HandlersApi) DeleteNodeHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	log.Debug().Msgf("Deleted node %s", n.UUID)
-	h.AuditLog.NodeAction(ctx[ctxUser], "deleted node "+n.UUID, strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	h.auditNodeAction(ctx[ctxUser], "deleted node "+n.UUID, strings.Split(r.RemoteAddr, ":")[0], node, env.ID)
 	// Serialize and serve JSON
 	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "node deleted"})
 }
@@ -391,7 +392,7 @@ func (h *HandlersApi) TagNodeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Debug().Msgf("Tagged node %s with %s", n.UUID, t.Tag)
-	h.AuditLog.NodeAction(ctx[ctxUser], "tagged node "+n.UUID+" with "+t.Tag, strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	h.auditNodeAction(ctx[ctxUser], "tagged node "+n.UUID+" with "+t.Tag, strings.Split(r.RemoteAddr, ":")[0], n, env.ID)
 	// Serialize and serve JSON
 	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "node tagged"})
 }
@@ -469,6 +470,7 @@ func (h *HandlersApi) projectNodesWithGeo(in []nodes.OsqueryNode) []types.NodeVi
 //	dir:       "asc" | "desc" (default "desc" for lastseen, "asc" otherwise)
 //	page:      1-indexed page number (default 1)
 //	page_size: 1..500 (default 50)
+//	attr:      custom attribute filter name=value, repeatable (all must match)
 //
 // @Summary List paginated nodes
 // @Description Returns paginated, filtered, and sorted nodes for an environment.
@@ -480,6 +482,7 @@ func (h *HandlersApi) projectNodesWithGeo(in []nodes.OsqueryNode) []types.NodeVi
 // @Param q query string false "Search query"
 // @Param status query string false "Node status filter"
 // @Param platform query string false "Platform filter"
+// @Param attr query []string false "Custom attribute filter as name=value, repeat to match all" collectionFormat(multi)
 // @Param sort query string false "Sort field"
 // @Param order query string false "Sort order"
 // @Success 200 {object} types.NodesPagedResponse
@@ -566,8 +569,19 @@ func (h *HandlersApi) NodesPagedHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Custom attribute filters; every one of them must match
+	var attributes []nodes.AttributeFilter
+	for _, a := range q["attr"] {
+		filter, err := nodes.ParseAttributeFilter(a)
+		if err != nil {
+			apiErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+			return
+		}
+		attributes = append(attributes, filter)
+	}
+
 	hours := h.Settings.InactiveHours(settings.NoEnvironmentID)
-	pageData, err := h.Nodes.GetByEnvPaged(env.Name, status, hours, search, page, pageSize, sortCol, desc, platformBucket)
+	pageData, err := h.Nodes.GetByEnvPaged(env.Name, status, hours, search, page, pageSize, sortCol, desc, platformBucket, attributes)
 	if err != nil {
 		apiErrorResponse(w, "failed to query nodes", http.StatusInternalServerError, err)
 		return
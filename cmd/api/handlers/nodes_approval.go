@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/jmpsec/osctrl/pkg/utils"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// ApprovalNodesHandler - GET Handler for the nodes of an environment pending approval
+// @Summary Get nodes pending approval
+// @Description Returns the nodes of an environment waiting for approval, oldest first, or the rejected ones with status=rejected.
+// @Tags nodes
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param status query string false "pending (default) or rejected"
+// @Success 200 {array} nodes.OsqueryNode
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/approvals [get]
+func (h *HandlersApi) ApprovalNodesHandler(w http.ResponseWriter, r *http.Request) {
+	env, _, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = nodes.ApprovalPending
+	}
+	if status != nodes.ApprovalPending && status != nodes.ApprovalRejected {
+		apiErrorResponse(w, "invalid approval status", http.StatusBadRequest, fmt.Errorf("status %q", status))
+		return
+	}
+	result, err := h.Nodes.GetByApproval(env.ID, status)
+	if err != nil {
+		apiErrorResponse(w, "error getting nodes", http.StatusInternalServerError, err)
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, result)
+}
+
+// NodeApproveHandler - POST Handler to approve a node pending approval
+// @Summary Approve node
+// @Description Approves a node pending approval or rejected, so it is served the configuration and the distributed queries of the environment.
+// @Tags nodes
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param node path string true "Node UUID, hostname, or local name"
+// @Success 200 {object} types.ApiGenericResponse
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 409 {object} types.ApiErrorResponse "Already approved"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/node/{node}/approve [post]
+func (h *HandlersApi) NodeApproveHandler(w http.ResponseWriter, r *http.Request) {
+	env, node, ctx, ok := h.nodeAdminContext(w, r)
+	if !ok {
+		return
+	}
+	if node.Approved() {
+		apiErrorResponse(w, "node is already approved", http.StatusConflict, nil)
+		return
+	}
+	if err := h.Nodes.Approve(node, ctx[ctxUser]); err != nil {
+		apiErrorResponse(w, "error approving node", http.StatusInternalServerError, err)
+		return
+	}
+	if h.NodeCacheInvalidator != nil {
+		h.NodeCacheInvalidator(r.Context(), node.NodeKey)
+	}
+	h.auditNodeAction(ctx[ctxUser], fmt.Sprintf("approved node %s (%s)", node.UUID, node.Hostname), strings.Split(r.RemoteAddr, ":")[0], node, env.ID)
+	log.Info().Msgf("Node %s in %s approved by %s", node.UUID, env.Name, ctx[ctxUser])
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "node approved"})
+}
+
+// NodeRejectHandler - POST Handler to reject a node pending approval
+// @Summary Reject node
+// @Description Rejects a node pending approval. It keeps getting an empty configuration and no distributed queries, also when it enrolls again, until it is approved.
+// @Tags nodes
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param node path string true "Node UUID, hostname, or local name"
+// @Success 200 {object} types.ApiGenericResponse
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 409 {object} types.ApiErrorResponse "Not pending approval"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/node/{node}/reject [post]
+func (h *HandlersApi) NodeRejectHandler(w http.ResponseWriter, r *http.Request) {
+	env, node, ctx, ok := h.nodeAdminContext(w, r)
+	if !ok {
+		return
+	}
+	if node.Approval != nodes.ApprovalPending {
+		apiErrorResponse(w, "node is not pending approval", http.StatusConflict, nil)
+		return
+	}
+	if err := h.Nodes.Reject(node, ctx[ctxUser]); err != nil {
+		apiErrorResponse(w, "error rejecting node", http.StatusInternalServerError, err)
+		return
+	}
+	if h.NodeCacheInvalidator != nil {
+		h.NodeCacheInvalidator(r.Context(), node.NodeKey)
+	}
+	h.auditNodeAction(ctx[ctxUser], fmt.Sprintf("rejected node %s (%s)", node.UUID, node.Hostname), strings.Split(r.RemoteAddr, ":")[0], node, env.ID)
+	log.Info().Msgf("Node %s in %s rejected by %s", node.UUID, env.Name, ctx[ctxUser])
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "node rejected"})
+}
+
+// ApprovalRulesHandler - GET Handler for the approval rules of an environment
+// @Summary Get approval rules
+// @Description Returns the rules approving the nodes of an environment by hardware serial or hostname pattern.
+// @Tags nodes
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Success 200 {array} nodes.ApprovalRule
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/approvals/rules [get]
+func (h *HandlersApi) ApprovalRulesHandler(w http.ResponseWriter, r *http.Request) {
+	env, _, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	rules, err := h.Nodes.ApprovalRules(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting approval rules", http.StatusInternalServerError, err)
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, rules)
+}
+
+// ApprovalRuleCreateHandler - POST Handler to create an approval rule
+// @Summary Create approval rule
+// @Description Creates a rule approving nodes by hardware serial or hostname pattern, like web-*. Nodes matching it are approved when they enroll, and the pending nodes matching it are approved right away.
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param request body types.ApiApprovalRuleRequest true "Request body"
+// @Success 201 {object} types.ApiApprovalRuleResponse
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/approvals/rules [post]
+func (h *HandlersApi) ApprovalRuleCreateHandler(w http.ResponseWriter, r *http.Request) {
+	env, ctx, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	var body types.ApiApprovalRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		return
+	}
+	rule, err := h.Nodes.CreateApprovalRule(nodes.ApprovalRule{
+		EnvironmentID: env.ID,
+		Type:          body.Type,
+		Pattern:       body.Pattern,
+		CreatedBy:     ctx[ctxUser],
+	})
+	if err != nil {
+		apiErrorResponse(w, "invalid approval rule", http.StatusBadRequest, err)
+		return
+	}
+	approved, err := h.Nodes.ApproveByRule(rule, ctx[ctxUser])
+	if err != nil {
+		apiErrorResponse(w, "error approving nodes", http.StatusInternalServerError, err)
+		return
+	}
+	response := types.ApiApprovalRuleResponse{Rule: rule, Approved: []string{}}
+	for _, node := range approved {
+		if h.NodeCacheInvalidator != nil {
+			h.NodeCacheInvalidator(r.Context(), node.NodeKey)
+		}
+		response.Approved = append(response.Approved, node.UUID)
+	}
+	if h.AuditLog != nil {
+		h.AuditLog.EnvAction(ctx[ctxUser], fmt.Sprintf("created approval rule %s in %s approving %d nodes", rule.String(), env.Name, len(approved)), strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusCreated, response)
+}
+
+// ApprovalRuleDeleteHandler - DELETE Handler to delete an approval rule
+// @Summary Delete approval rule
+// @Description Deletes an approval rule of an environment. Nodes it approved stay approved.
+// @Tags nodes
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param id path int true "Approval rule ID"
+// @Success 200 {object} types.ApiGenericResponse
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/approvals/rules/{id} [delete]
+func (h *HandlersApi) ApprovalRuleDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	env, ctx, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil {
+		apiErrorResponse(w, "invalid approval rule", http.StatusBadRequest, err)
+		return
+	}
+	if err := h.Nodes.DeleteApprovalRule(env.ID, uint(id)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			apiErrorResponse(w, "approval rule not found", http.StatusNotFound, err)
+			return
+		}
+		apiErrorResponse(w, "error deleting approval rule", http.StatusInternalServerError, err)
+		return
+	}
+	if h.AuditLog != nil {
+		h.AuditLog.EnvAction(ctx[ctxUser], fmt.Sprintf("deleted approval rule %d in %s", id, env.Name), strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "approval rule deleted"})
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/jmpsec/osctrl/pkg/auditlog"
+	"github.com/jmpsec/osctrl/pkg/config"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeApprovalQueue(t *testing.T) {
+	db, h, env, node := setupConsoleHandlers(t)
+	h.DebugHTTPConfig = &config.YAMLConfigurationDebug{}
+	h.AuditLog = &auditlog.AuditLogManager{}
+	require.NoError(t, db.Model(&node).Update("approval", nodes.ApprovalPending).Error)
+	other := nodes.OsqueryNode{UUID: "WEB-UUID", Hostname: "web-01", EnvironmentID: env.ID, Environment: env.UUID, Approval: nodes.ApprovalPending}
+	require.NoError(t, db.Create(&other).Error)
+	serve := func(handler http.HandlerFunc, method, body, user string, values map[string]string) *httptest.ResponseRecorder {
+		var raw []byte
+		if body != "" {
+			raw = []byte(body)
+		}
+		req := consoleRequest(method, "/approvals", raw, user)
+		req.SetPathValue("env", env.Name)
+		for k, v := range values {
+			req.SetPathValue(k, v)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+	listed := func(status string) []nodes.OsqueryNode {
+		req := consoleRequest(http.MethodGet, "/approvals?status="+status, nil, "alice")
+		req.SetPathValue("env", env.Name)
+		rr := httptest.NewRecorder()
+		h.ApprovalNodesHandler(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var result []nodes.OsqueryNode
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		return result
+	}
+
+	require.Equal(t, http.StatusForbidden, serve(h.ApprovalNodesHandler, http.MethodGet, "", "bob", nil).Code)
+	require.Len(t, listed(nodes.ApprovalPending), 2)
+
+	rr := serve(h.NodeRejectHandler, http.MethodPost, "", "alice", map[string]string{"node": node.UUID})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, http.StatusConflict, serve(h.NodeRejectHandler, http.MethodPost, "", "alice", map[string]string{"node": node.UUID}).Code)
+	require.Len(t, listed(nodes.ApprovalRejected), 1)
+	rr = serve(h.NodeApproveHandler, http.MethodPost, "", "alice", map[string]string{"node": node.UUID})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, http.StatusConflict, serve(h.NodeApproveHandler, http.MethodPost, "", "alice", map[string]string{"node": node.UUID}).Code)
+
+	require.Equal(t, http.StatusBadRequest, serve(h.ApprovalRuleCreateHandler, http.MethodPost, `{"type":"mac","pattern":"x"}`, "alice", nil).Code)
+	rr = serve(h.ApprovalRuleCreateHandler, http.MethodPost, `{"type":"hostname","pattern":"web-*"}`, "alice", nil)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var created types.ApiApprovalRuleResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	require.Equal(t, []string{other.UUID}, created.Approved)
+	require.Empty(t, listed(nodes.ApprovalPending))
+
+	rr = serve(h.ApprovalRulesHandler, http.MethodGet, "", "alice", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var rules []nodes.ApprovalRule
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &rules))
+	require.Len(t, rules, 1)
+	require.Equal(t, http.StatusNotFound, serve(h.ApprovalRuleDeleteHandler, http.MethodDelete, "", "alice", map[string]string{"id": "999"}).Code)
+	rr = serve(h.ApprovalRuleDeleteHandler, http.MethodDelete, "", "alice", map[string]string{"id": strconv.FormatUint(uint64(created.Rule.ID), 10)})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+}
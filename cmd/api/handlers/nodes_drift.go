@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/utils"
+)
+
+// driftCutoff is the cutoff of configuration drift in an environment
+func (h *HandlersApi) driftCutoff(env environments.TLSEnvironment, now time.Time) (int64, time.Time) {
+	intervals := h.Settings.DriftIntervals(env.ID)
+	return intervals, nodes.DriftCutoff(env.ConfigInterval, intervals, now)
+}
+
+// NodeDriftHandler - GET Handler for the nodes with configuration drift
+// @Summary Get nodes with configuration drift
+// @Description Returns the nodes of an environment that report a config hash other than the one of the configuration served to them for longer than the drift intervals setting, in config intervals. They usually have a local override, a stuck agent or a proxy in between.
+// @Tags nodes
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Success 200 {object} nodes.DriftReport
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/drift [get]
+func (h *HandlersApi) NodeDriftHandler(w http.ResponseWriter, r *http.Request) {
+	env, ctx, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	intervals, cutoff := h.driftCutoff(env, time.Now())
+	drifted, err := h.Nodes.Drifted(env.ID, cutoff)
+	if err != nil {
+		apiErrorResponse(w, "error getting drifted nodes", http.StatusInternalServerError, err)
+		return
+	}
+	if h.AuditLog != nil {
+		h.AuditLog.NodeAction(ctx[ctxUser], "viewed configuration drift of "+env.Name, strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, nodes.DriftReport{
+		Intervals:      intervals,
+		ConfigInterval: env.ConfigInterval,
+		Cutoff:         cutoff,
+		Count:          len(drifted),
+		Drifted:        drifted,
+	})
+}
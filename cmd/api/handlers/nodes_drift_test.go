@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/auditlog"
+	"github.com/jmpsec/osctrl/pkg/config"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeDriftHandlerListsDriftedNodes(t *testing.T) {
+	db, h, env, _ := setupConsoleHandlers(t)
+	h.AuditLog = &auditlog.AuditLogManager{}
+	h.DebugHTTPConfig = &config.YAMLConfigurationDebug{}
+	require.NoError(t, db.Model(&env).Update("config_interval", 60).Error)
+	now := time.Now()
+	for uuid, reported := range map[string]string{"SYNCED": "served", "DRIFTED": "local"} {
+		node := nodes.OsqueryNode{UUID: uuid, Environment: env.Name, EnvironmentID: env.ID, ConfigHash: reported, LastSeen: now}
+		require.NoError(t, db.Create(&node).Error)
+		require.NoError(t, h.Nodes.UpdateExpectedConfig(node.ID, "served", now.Add(-10*time.Minute)))
+	}
+	get := func(user string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := consoleRequest(http.MethodGet, "/nodes/env/drift", nil, user)
+		req.SetPathValue("env", env.Name)
+		h.NodeDriftHandler(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusForbidden, get("bob").Code)
+	rr := get("alice")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var report nodes.DriftReport
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	require.Equal(t, int64(3), report.Intervals)
+	require.Equal(t, 60, report.ConfigInterval)
+	require.Equal(t, 1, report.Count)
+	require.Equal(t, "DRIFTED", report.Drifted[0].UUID)
+	require.Equal(t, "served", report.Drifted[0].ExpectedConfigHash)
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/jmpsec/osctrl/pkg/users"
+	"github.com/jmpsec/osctrl/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// NodeDuplicatesHandler - GET Handler for the duplicate nodes of an environment
+// @Summary List duplicate nodes
+// @Description Returns groups of nodes that look like the same machine, by hardware serial, UUID or hostname and platform, newest first.
+// @Tags nodes
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Success 200 {array} nodes.DuplicateGroup
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/duplicates [get]
+func (h *HandlersApi) NodeDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	env, _, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	groups, err := h.Nodes.Duplicates(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting duplicates", http.StatusInternalServerError, err)
+		return
+	}
+	log.Debug().Msgf("Returned %d duplicate groups for %s", len(groups), env.Name)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, groups)
+}
+
+// NodeMergeHandler - POST Handler to merge duplicate nodes
+// @Summary Merge duplicate nodes
+// @Description Keeps the newest of the given duplicate nodes, moves to it the tags, query history and carves of the others and archives them with trigger merged.
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param request body types.ApiNodeMergeRequest true "Request body"
+// @Success 200 {object} types.ApiNodeMergeResponse
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/duplicates/merge [post]
+func (h *HandlersApi) NodeMergeHandler(w http.ResponseWriter, r *http.Request) {
+	env, ctx, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	var body types.ApiNodeMergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		return
+	}
+	if len(body.NodeIDs) < 2 {
+		apiErrorResponse(w, "at least two nodes are needed to merge", http.StatusBadRequest, nil)
+		return
+	}
+	found, err := h.Nodes.GetByIDs(body.NodeIDs)
+	if err != nil {
+		apiErrorResponse(w, "error getting nodes", http.StatusInternalServerError, err)
+		return
+	}
+	if len(found) != len(body.NodeIDs) {
+		apiErrorResponse(w, "node not found", http.StatusNotFound, nil)
+		return
+	}
+	// Duplicates by UUID can be in other environments, which need access too
+	inEnv := false
+	for _, node := range found {
+		if node.EnvironmentID == env.ID {
+			inEnv = true
+			continue
+		}
+		nodeEnv, err := h.Envs.GetByID(node.EnvironmentID)
+		if err != nil {
+			apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+			return
+		}
+		if !h.Users.CheckPermissions(ctx[ctxUser], users.AdminLevel, nodeEnv.UUID) {
+			apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+			return
+		}
+	}
+	if !inEnv {
+		apiErrorResponse(w, "no nodes in environment", http.StatusBadRequest, nil)
+		return
+	}
+	keep, archived, err := h.Nodes.Merge(found, h.Tags.MoveNodeTags, h.Queries.MoveNodeQueries, h.Carves.MoveNodeCarves)
+	if err != nil {
+		apiErrorResponse(w, "error merging nodes", http.StatusBadRequest, err)
+		return
+	}
+	response := types.ApiNodeMergeResponse{Kept: keep.UUID, Archived: []string{}}
+	for _, node := range archived {
+		response.Archived = append(response.Archived, node.UUID)
+		h.auditNodeAction(ctx[ctxUser], fmt.Sprintf("merged node %s (%s) into %s (%s)", node.UUID, node.Hostname, keep.UUID, keep.Hostname), strings.Split(r.RemoteAddr, ":")[0], node, node.EnvironmentID)
+	}
+	log.Debug().Msgf("Merged %d nodes into %s", len(archived), keep.UUID)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, response)
+}
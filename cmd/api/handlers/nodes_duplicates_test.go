@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/auditlog"
+	"github.com/jmpsec/osctrl/pkg/carves"
+	"github.com/jmpsec/osctrl/pkg/config"
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/queries"
+	"github.com/jmpsec/osctrl/pkg/tags"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/jmpsec/osctrl/pkg/users"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeMergeMovesTagsQueriesAndCarves(t *testing.T) {
+	db, h, env, node := setupConsoleHandlers(t)
+	h.Tags = tags.CreateTagManager(db)
+	h.AuditLog = &auditlog.AuditLogManager{}
+	h.DebugHTTPConfig = &config.YAMLConfigurationDebug{}
+	require.NoError(t, db.Model(&node).Updates(map[string]interface{}{"hardware_serial": "SN-1", "created_at": time.Now().Add(-time.Hour)}).Error)
+	reimaged := nodes.OsqueryNode{UUID: "REIMAGED-UUID", HardwareSerial: "SN-1", Platform: "linux", EnvironmentID: env.ID, Environment: env.UUID}
+	require.NoError(t, db.Create(&reimaged).Error)
+
+	require.NoError(t, h.Tags.TagNode("incident", node, "alice", false, tags.TagTypeCustom, ""))
+	require.NoError(t, h.Tags.TagNode("shared", node, "alice", false, tags.TagTypeCustom, ""))
+	require.NoError(t, h.Tags.TagNode("shared", reimaged, "alice", false, tags.TagTypeCustom, ""))
+	query := queries.DistributedQuery{Name: "q1", Creator: "alice", Query: "select 1", Type: queries.StandardQueryType, EnvironmentID: env.ID}
+	require.NoError(t, h.Queries.Create(&query))
+	require.NoError(t, h.Queries.CreateNodeQueries([]uint{node.ID}, query.ID))
+	require.NoError(t, h.Carves.CreateCarve(carves.CarvedFile{CarveID: "carve-1", UUID: node.UUID, NodeID: node.ID, Path: "/etc/hosts", Status: "completed"}))
+
+	rr := httptest.NewRecorder()
+	req := consoleRequest(http.MethodGet, "/duplicates", nil, "alice")
+	req.SetPathValue("env", env.Name)
+	h.NodeDuplicatesHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var groups []nodes.DuplicateGroup
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &groups))
+	require.Len(t, groups, 1)
+	require.Equal(t, nodes.DuplicateSerial, groups[0].Reason)
+
+	rr = httptest.NewRecorder()
+	req = consoleRequest(http.MethodPost, "/duplicates/merge", []byte(fmt.Sprintf(`{"node_ids":[%d,%d]}`, node.ID, reimaged.ID)), "alice")
+	req.SetPathValue("env", env.Name)
+	h.NodeMergeHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var resp types.ApiNodeMergeResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Equal(t, reimaged.UUID, resp.Kept)
+	require.Equal(t, []string{node.UUID}, resp.Archived)
+
+	require.False(t, h.Nodes.CheckByUUID(node.UUID))
+	nodeTags, err := h.Tags.GetTags(reimaged)
+	require.NoError(t, err)
+	require.Len(t, nodeTags, 2)
+	history, err := h.Queries.GetNodeQueryHistory(reimaged.ID)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	carved, err := h.Carves.GetNodeCarvesByID(reimaged.ID)
+	require.NoError(t, err)
+	require.Len(t, carved, 1)
+	carved, err = h.Carves.GetNodeCarves(reimaged.UUID)
+	require.NoError(t, err)
+	require.Len(t, carved, 1)
+}
+
+func TestNodeMergeAcrossEnvironments(t *testing.T) {
+	db, h, env, node := setupConsoleHandlers(t)
+	h.Tags = tags.CreateTagManager(db)
+	h.AuditLog = &auditlog.AuditLogManager{}
+	h.DebugHTTPConfig = &config.YAMLConfigurationDebug{}
+	prod := environments.TLSEnvironment{UUID: "prod-uuid", Name: "prod"}
+	require.NoError(t, db.Create(&prod).Error)
+	require.NoError(t, h.Users.CreatePermission(users.UserPermission{Username: "alice", AccessType: int(users.AdminLevel), AccessValue: true, Environment: prod.UUID, EnvironmentID: prod.ID}))
+	require.NoError(t, db.Model(&node).Update("created_at", time.Now().Add(-time.Hour)).Error)
+	moved := nodes.OsqueryNode{UUID: "node-uuid", Platform: "linux", EnvironmentID: prod.ID, Environment: prod.Name}
+	require.NoError(t, db.Create(&moved).Error)
+
+	require.NoError(t, h.Tags.NewTag("shared", "", "", tags.DefaultTagIcon, "alice", prod.ID, false, tags.TagTypeCustom, ""))
+	require.NoError(t, h.Tags.TagNode("shared", node, "alice", false, tags.TagTypeCustom, ""))
+	require.NoError(t, h.Tags.TagNode("incident", node, "alice", false, tags.TagTypeCustom, ""))
+	require.NoError(t, h.Carves.CreateCarve(carves.CarvedFile{CarveID: "carve-1", UUID: node.UUID, NodeID: node.ID, Environment: env.Name, EnvironmentID: env.ID, Path: "/etc/hosts", Status: "completed"}))
+
+	rr := httptest.NewRecorder()
+	req := consoleRequest(http.MethodPost, "/duplicates/merge", []byte(fmt.Sprintf(`{"node_ids":[%d,%d]}`, node.ID, moved.ID)), "alice")
+	req.SetPathValue("env", env.Name)
+	h.NodeMergeHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	nodeTags, err := h.Tags.GetTags(moved)
+	require.NoError(t, err)
+	require.Len(t, nodeTags, 1)
+	require.Equal(t, prod.ID, nodeTags[0].EnvironmentID)
+	shared, err := h.Tags.Get("shared", prod.ID)
+	require.NoError(t, err)
+	tagged, err := h.Tags.GetTaggedNodes(shared)
+	require.NoError(t, err)
+	require.Len(t, tagged, 1)
+	require.Equal(t, moved.ID, tagged[0].NodeID)
+	carved, err := h.Carves.GetNodeCarves(moved.UUID)
+	require.NoError(t, err)
+	require.Len(t, carved, 1)
+	require.Equal(t, prod.Name, carved[0].Environment)
+	require.Equal(t, prod.ID, carved[0].EnvironmentID)
+}
+
+func TestNodeMergeRejectsNonDuplicatesAndNonAdmins(t *testing.T) {
+	db, h, env, node := setupConsoleHandlers(t)
+	h.DebugHTTPConfig = &config.YAMLConfigurationDebug{}
+	other := nodes.OsqueryNode{UUID: "OTHER-UUID", HardwareSerial: "SN-2", Platform: "linux", EnvironmentID: env.ID, Environment: env.UUID}
+	require.NoError(t, db.Create(&other).Error)
+	body := []byte(fmt.Sprintf(`{"node_ids":[%d,%d]}`, node.ID, other.ID))
+
+	rr := httptest.NewRecorder()
+	req := consoleRequest(http.MethodPost, "/duplicates/merge", body, "bob")
+	req.SetPathValue("env", env.Name)
+	h.NodeMergeHandler(rr, req)
+	require.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = httptest.NewRecorder()
+	req = consoleRequest(http.MethodPost, "/duplicates/merge", body, "alice")
+	req.SetPathValue("env", env.Name)
+	h.NodeMergeHandler(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	require.True(t, h.Nodes.CheckByUUID(node.UUID))
+	require.True(t, h.Nodes.CheckByUUID(other.UUID))
+}
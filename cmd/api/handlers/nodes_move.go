@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/jmpsec/osctrl/pkg/users"
+	"github.com/jmpsec/osctrl/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// NodesMoveHandler - POST Handler to move nodes to another environment
+// @Summary Move nodes to another environment
+// @Description Reassigns nodes to another environment without enrolling them again. Automatic tags are applied again and osctrl-tls redirects the requests of moved nodes to their new environment.
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Param env path string true "Environment name or UUID the nodes are in"
+// @Param request body types.ApiNodeMoveRequest true "Request body"
+// @Success 200 {array} nodes.NodeMove
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/move [post]
+func (h *HandlersApi) NodesMoveHandler(w http.ResponseWriter, r *http.Request) {
+	env, ctx, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	var body types.ApiNodeMoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		return
+	}
+	if len(body.UUIDs) == 0 || body.Environment == "" {
+		apiErrorResponse(w, "nodes and environment are required", http.StatusBadRequest, nil)
+		return
+	}
+	target, err := h.Envs.Get(body.Environment)
+	if err != nil {
+		apiErrorResponse(w, "environment not found", http.StatusNotFound, err)
+		return
+	}
+	if !h.Users.CheckPermissions(ctx[ctxUser], users.AdminLevel, target.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		return
+	}
+	var moving []nodes.OsqueryNode
+	for _, uuid := range body.UUIDs {
+		node, err := h.Nodes.GetByUUIDEnv(uuid, env.ID)
+		if err != nil {
+			apiErrorResponse(w, "node not found", http.StatusNotFound, fmt.Errorf("node %s: %w", uuid, err))
+			return
+		}
+		moving = append(moving, node)
+	}
+	moves, err := h.Nodes.MoveToEnvironment(moving, target.ID, target.Name, ctx[ctxUser])
+	if err != nil {
+		apiErrorResponse(w, "error moving nodes", http.StatusInternalServerError, err)
+		return
+	}
+	ip := strings.Split(r.RemoteAddr, ":")[0]
+	for _, node := range moving {
+		if node.EnvironmentID == target.ID {
+			continue
+		}
+		if h.NodeCacheInvalidator != nil {
+			h.NodeCacheInvalidator(r.Context(), node.NodeKey)
+		}
+		node.Environment = target.Name
+		node.EnvironmentID = target.ID
+		if h.Tags != nil {
+			if err := h.Tags.ReapplyAutoTags(target.Name, node, ctx[ctxUser]); err != nil {
+				log.Err(err).Msgf("error tagging moved node %s", node.UUID)
+			}
+		}
+		msg := fmt.Sprintf("moved node %s (%s) from %s to %s", node.UUID, node.Hostname, env.Name, target.Name)
+		h.auditNodeAction(ctx[ctxUser], msg, ip, node, env.ID)
+		h.auditNodeAction(ctx[ctxUser], msg, ip, node, target.ID)
+	}
+	log.Debug().Msgf("Moved %d nodes from %s to %s", len(moves), env.Name, target.Name)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, moves)
+}
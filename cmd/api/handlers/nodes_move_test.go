@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jmpsec/osctrl/pkg/auditlog"
+	"github.com/jmpsec/osctrl/pkg/config"
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/tags"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/jmpsec/osctrl/pkg/users"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodesMoveReassignsEnvironmentAndAutoTags(t *testing.T) {
+	db, h, env, node := setupConsoleHandlers(t)
+	h.Tags = tags.CreateTagManager(db)
+	h.AuditLog = &auditlog.AuditLogManager{}
+	h.DebugHTTPConfig = &config.YAMLConfigurationDebug{}
+	var invalidated []string
+	h.NodeCacheInvalidator = func(_ context.Context, nodeKey string) { invalidated = append(invalidated, nodeKey) }
+	require.NoError(t, db.Model(&node).Update("node_key", "node-key").Error)
+	node.NodeKey = "node-key"
+	target := environments.TLSEnvironment{UUID: "target-uuid", Name: "target"}
+	require.NoError(t, db.Create(&target).Error)
+	require.NoError(t, h.Tags.AutoTagNode(env.Name, node, "alice"))
+
+	rr := httptest.NewRecorder()
+	req := consoleRequest(http.MethodPost, "/move", []byte(`{"uuid_list":["NODE-UUID"],"environment":"target"}`), "bob")
+	req.SetPathValue("env", env.Name)
+	h.NodesMoveHandler(rr, req)
+	require.Equal(t, http.StatusForbidden, rr.Code)
+
+	// Moving nodes needs admin access to the target environment too
+	rr = httptest.NewRecorder()
+	req = consoleRequest(http.MethodPost, "/move", []byte(`{"uuid_list":["NODE-UUID"],"environment":"target"}`), "alice")
+	req.SetPathValue("env", env.Name)
+	h.NodesMoveHandler(rr, req)
+	require.Equal(t, http.StatusForbidden, rr.Code)
+	require.NoError(t, h.Users.CreatePermission(users.UserPermission{
+		Username:      "alice",
+		AccessType:    int(users.AdminLevel),
+		AccessValue:   true,
+		Environment:   target.UUID,
+		EnvironmentID: target.ID,
+	}))
+
+	rr = httptest.NewRecorder()
+	req = consoleRequest(http.MethodPost, "/move", []byte(`{"uuid_list":["NODE-UUID"],"environment":"target"}`), "alice")
+	req.SetPathValue("env", env.Name)
+	h.NodesMoveHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var moves []nodes.NodeMove
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &moves))
+	require.Len(t, moves, 1)
+	require.Equal(t, env.ID, moves[0].FromEnvironmentID)
+	require.Equal(t, target.ID, moves[0].ToEnvironmentID)
+	require.Equal(t, []string{"node-key"}, invalidated)
+
+	moved, err := h.Nodes.GetByUUID(node.UUID)
+	require.NoError(t, err)
+	require.Equal(t, target.ID, moved.EnvironmentID)
+	nodeTags, err := h.Tags.GetTags(moved)
+	require.NoError(t, err)
+	var names []string
+	for _, tag := range nodeTags {
+		names = append(names, tag.Name)
+	}
+	require.ElementsMatch(t, []string{"target", "linux"}, names)
+
+	timeline, err := h.nodeTimeline(moved)
+	require.NoError(t, err)
+	var movedEvents int
+	for _, event := range timeline {
+		if event.Type == types.TimelineMove {
+			movedEvents++
+			require.Equal(t, "alice", event.Actor)
+		}
+	}
+	require.Equal(t, 1, movedEvents)
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/jmpsec/osctrl/pkg/utils"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// ConfigOverlaysHandler - GET Handler for the configuration overlays of an environment
+// @Summary Get configuration overlays
+// @Description Returns the configuration overlays of an environment in the order they are merged onto its configuration.
+// @Tags nodes
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Success 200 {array} environments.ConfigOverlay
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/overlays [get]
+func (h *HandlersApi) ConfigOverlaysHandler(w http.ResponseWriter, r *http.Request) {
+	env, _, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	overlays, err := h.Envs.Overlays(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting overlays", http.StatusInternalServerError, err)
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, overlays)
+}
+
+// ConfigOverlaySetHandler - PUT Handler to create or replace a configuration overlay
+// @Summary Set configuration overlay
+// @Description Creates or replaces a partial osquery configuration merged onto the configuration of the environment for the nodes with a tag or a platform. Options, schedule, packs and auto table construction entries replace the ones with the same name, decorator queries are added and other sections are replaced. Overlays with higher priority are merged last. Options, packs and auto table construction are validated like the configuration of the environment, and force writes them with the issues returned as warnings.
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param name path string true "Overlay name"
+// @Param request body types.ApiConfigOverlayRequest true "Request body"
+// @Success 200 {object} types.ApiConfigOverlayResponse
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/overlays/{name} [put]
+func (h *HandlersApi) ConfigOverlaySetHandler(w http.ResponseWriter, r *http.Request) {
+	env, ctx, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	var body types.ApiConfigOverlayRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiErrorResponse(w, "error parsing PUT body", http.StatusBadRequest, err)
+		return
+	}
+	var partial map[string]json.RawMessage
+	if err := json.Unmarshal(body.Configuration, &partial); err != nil || len(partial) == 0 {
+		apiErrorResponse(w, "configuration must be a non empty JSON object", http.StatusBadRequest, err)
+		return
+	}
+	overlay := environments.ConfigOverlay{
+		EnvironmentID: env.ID,
+		Name:          r.PathValue("name"),
+		TargetType:    body.TargetType,
+		Target:        body.Target,
+		Priority:      body.Priority,
+		Configuration: string(body.Configuration),
+		CreatedBy:     ctx[ctxUser],
+	}
+	if err := environments.ValidateOverlay(overlay); err != nil {
+		apiErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+		return
+	}
+	// Options, packs and ATC of the overlay are checked like the ones of the
+	// environment. Errors block the write unless forced.
+	versions, err := h.Nodes.GetEnvOsqueryVersions(env.ID)
+	if err != nil {
+		log.Err(err).Msgf("error getting osquery versions for env %s", env.Name)
+	}
+	validation := environments.ValidateOverlayConfig(overlay, versions)
+	if err := validation.Err(); err != nil && !body.Force {
+		apiErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+		return
+	}
+	// Make sure the environment configuration still parses with the overlay
+	if _, err := environments.ApplyOverlays(env.Configuration, []environments.ConfigOverlay{overlay}); err != nil {
+		apiErrorResponse(w, "error merging overlay", http.StatusBadRequest, err)
+		return
+	}
+	overlay, err = h.Envs.SetOverlay(overlay)
+	if err != nil {
+		apiErrorResponse(w, "error saving overlay", http.StatusInternalServerError, err)
+		return
+	}
+	if h.AuditLog != nil {
+		h.AuditLog.EnvAction(ctx[ctxUser], fmt.Sprintf("set configuration overlay %s of %s for %s %s", overlay.Name, env.Name, overlay.TargetType, overlay.Target), strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	}
+	log.Info().Msgf("Configuration overlay %s of %s set by %s", overlay.Name, env.Name, ctx[ctxUser])
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiConfigOverlayResponse{ConfigOverlay: overlay, Warnings: validation.Messages(body.Force)})
+}
+
+// ConfigOverlayDeleteHandler - DELETE Handler to remove a configuration overlay
+// @Summary Delete configuration overlay
+// @Description Removes a configuration overlay of an environment.
+// @Tags nodes
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param name path string true "Overlay name"
+// @Success 200 {object} types.ApiGenericResponse
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/overlays/{name} [delete]
+func (h *HandlersApi) ConfigOverlayDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	env, ctx, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	name := r.PathValue("name")
+	if err := h.Envs.DeleteOverlay(env.ID, name); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			apiErrorResponse(w, "overlay not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error deleting overlay", http.StatusInternalServerError, err)
+		}
+		return
+	}
+	if h.AuditLog != nil {
+		h.AuditLog.EnvAction(ctx[ctxUser], fmt.Sprintf("deleted configuration overlay %s of %s", name, env.Name), strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "overlay deleted"})
+}
+
+// NodeConfigHandler - GET Handler for the effective configuration of a node
+// @Summary Preview node configuration
+// @Description Returns the configuration a node is served: the configuration of its environment with the overlays for its platform and tags merged onto it, the incident response configuration when the node is in quarantine, or an empty configuration when it is pending approval or rejected.
+// @Tags nodes
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param node path string true "Node UUID, hostname, or local name"
+// @Success 200 {object} types.ApiNodeConfigResponse
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/node/{node}/config [get]
+func (h *HandlersApi) NodeConfigHandler(w http.ResponseWriter, r *http.Request) {
+	env, node, ctx, ok := h.nodeAdminContext(w, r)
+	if !ok {
+		return
+	}
+	response := types.ApiNodeConfigResponse{Overlays: []string{}, Quarantined: node.Quarantined, Approval: node.Approval}
+	if !node.Approved() {
+		response.Configuration = json.RawMessage(nodes.UnapprovedConfiguration)
+	} else if node.Quarantined {
+		conf, err := h.Envs.QuarantineConfiguration(env)
+		if err != nil {
+			apiErrorResponse(w, "error generating quarantine configuration", http.StatusInternalServerError, err)
+			return
+		}
+		response.Configuration = json.RawMessage(conf)
+	} else {
+		overlays, err := h.Envs.Overlays(env.ID)
+		if err != nil {
+			apiErrorResponse(w, "error getting overlays", http.StatusInternalServerError, err)
+			return
+		}
+		tagNames, err := h.Tags.GetTagNames(node)
+		if err != nil {
+			apiErrorResponse(w, "error getting tags", http.StatusInternalServerError, err)
+			return
+		}
+		matched := environments.MatchOverlays(overlays, nodes.PlatformNames(node.Platform), tagNames)
+		conf, err := environments.ApplyOverlays(env.Configuration, matched)
+		if err != nil {
+			apiErrorResponse(w, "error applying overlays", http.StatusInternalServerError, err)
+			return
+		}
+		for _, overlay := range matched {
+			response.Overlays = append(response.Overlays, overlay.Name)
+		}
+		if conf == "" {
+			conf = "{}"
+		}
+		response.Configuration = json.RawMessage(conf)
+	}
+	h.auditNodeAction(ctx[ctxUser], "previewed configuration of node "+node.UUID, strings.Split(r.RemoteAddr, ":")[0], node, env.ID)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, response)
+}
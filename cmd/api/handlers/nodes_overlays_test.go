@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jmpsec/osctrl/pkg/auditlog"
+	"github.com/jmpsec/osctrl/pkg/config"
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/tags"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigOverlaysAndNodeConfigPreview(t *testing.T) {
+	db, h, env, node := setupConsoleHandlers(t)
+	h.Tags = tags.CreateTagManager(db)
+	h.AuditLog = &auditlog.AuditLogManager{}
+	h.DebugHTTPConfig = &config.YAMLConfigurationDebug{}
+	setOverlay := func(name, body, user string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := consoleRequest(http.MethodPut, "/overlays/"+name, []byte(body), user)
+		req.SetPathValue("env", env.Name)
+		req.SetPathValue("name", name)
+		h.ConfigOverlaySetHandler(rr, req)
+		return rr
+	}
+	preview := func() types.ApiNodeConfigResponse {
+		rr := httptest.NewRecorder()
+		req := consoleRequest(http.MethodGet, "/config", nil, "alice")
+		req.SetPathValue("env", env.Name)
+		req.SetPathValue("node", node.UUID)
+		h.NodeConfigHandler(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var resp types.ApiNodeConfigResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp
+	}
+
+	require.Equal(t, http.StatusForbidden, setOverlay("pci", `{"target_type":"tag","target":"pci","configuration":{"options":{}}}`, "bob").Code)
+	require.Equal(t, http.StatusBadRequest, setOverlay("pci", `{"target_type":"tag","target":"pci","configuration":[]}`, "alice").Code)
+	require.Equal(t, http.StatusBadRequest, setOverlay("pci", `{"target_type":"host","target":"pci","configuration":{"options":{}}}`, "alice").Code)
+	require.Equal(t, http.StatusBadRequest, setOverlay("typo", `{"target_type":"tag","target":"pci","configuration":{"options":{"logger_tls_perod":30}}}`, "alice").Code)
+	rr := setOverlay("typo", `{"target_type":"tag","target":"pci","force":true,"configuration":{"options":{"logger_tls_perod":30}}}`, "alice")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var forced types.ApiConfigOverlayResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &forced))
+	require.Equal(t, "typo", forced.Name)
+	require.NotEmpty(t, forced.Warnings)
+	require.NoError(t, h.Envs.DeleteOverlay(env.ID, "typo"))
+	rr = setOverlay("pci", `{"target_type":"tag","target":"pci","priority":5,"configuration":{"schedule":{"fim":{"query":"SELECT * FROM file_events;","interval":300}}}}`, "alice")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	rr = setOverlay("linux", `{"target_type":"platform","target":"linux","configuration":{"options":{"logger_tls_period":30}}}`, "alice")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	req := consoleRequest(http.MethodGet, "/overlays", nil, "alice")
+	req.SetPathValue("env", env.Name)
+	h.ConfigOverlaysHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var overlays []environments.ConfigOverlay
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &overlays))
+	require.Len(t, overlays, 2)
+
+	resp := preview()
+	require.Equal(t, []string{"linux"}, resp.Overlays)
+	require.Contains(t, string(resp.Configuration), "logger_tls_period")
+	require.NotContains(t, string(resp.Configuration), "file_events")
+
+	require.NoError(t, h.Tags.TagNode("pci", node, "alice", false, tags.TagTypeCustom, ""))
+	resp = preview()
+	require.Equal(t, []string{"linux", "pci"}, resp.Overlays)
+	require.Contains(t, string(resp.Configuration), "file_events")
+
+	rr = httptest.NewRecorder()
+	req = consoleRequest(http.MethodDelete, "/overlays/linux", nil, "alice")
+	req.SetPathValue("env", env.Name)
+	req.SetPathValue("name", "linux")
+	h.ConfigOverlayDeleteHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr = httptest.NewRecorder()
+	h.ConfigOverlayDeleteHandler(rr, req)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	require.Equal(t, []string{"pci"}, preview().Overlays)
+}
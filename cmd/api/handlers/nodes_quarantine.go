@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/jmpsec/osctrl/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// NodeQuarantineHandler - POST Handler to put a node in quarantine
+// @Summary Quarantine node
+// @Description Puts a node in quarantine. It keeps sending logs but is only served the incident response configuration of the environment and no distributed queries, and every action against it is highlighted in the audit log.
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param node path string true "Node UUID, hostname, or local name"
+// @Param request body types.ApiNodeQuarantineRequest true "Request body"
+// @Success 200 {object} nodes.NodeQuarantine
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 409 {object} types.ApiErrorResponse "Already in quarantine"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/node/{node}/quarantine [post]
+func (h *HandlersApi) NodeQuarantineHandler(w http.ResponseWriter, r *http.Request) {
+	env, node, ctx, ok := h.nodeAdminContext(w, r)
+	if !ok {
+		return
+	}
+	var body types.ApiNodeQuarantineRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		return
+	}
+	if node.Quarantined {
+		apiErrorResponse(w, "node is already in quarantine", http.StatusConflict, nil)
+		return
+	}
+	quarantine, err := h.Nodes.Quarantine(node, body.Reason, ctx[ctxUser])
+	if err != nil {
+		apiErrorResponse(w, "error quarantining node", http.StatusInternalServerError, err)
+		return
+	}
+	if h.NodeCacheInvalidator != nil {
+		h.NodeCacheInvalidator(r.Context(), node.NodeKey)
+	}
+	if h.AuditLog != nil {
+		msg := fmt.Sprintf("quarantined node %s (%s)", node.UUID, node.Hostname)
+		if body.Reason != "" {
+			msg += ": " + body.Reason
+		}
+		h.AuditLog.QuarantineAction(ctx[ctxUser], msg, strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	}
+	log.Info().Msgf("Node %s in %s quarantined by %s", node.UUID, env.Name, ctx[ctxUser])
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, quarantine)
+}
+
+// NodeReleaseHandler - DELETE Handler to release a node from quarantine
+// @Summary Release node from quarantine
+// @Description Takes a node out of quarantine, so it is served the configuration and the distributed queries of the environment again.
+// @Tags nodes
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param node path string true "Node UUID, hostname, or local name"
+// @Success 200 {object} nodes.NodeQuarantine
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 409 {object} types.ApiErrorResponse "Not in quarantine"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/node/{node}/quarantine [delete]
+func (h *HandlersApi) NodeReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	env, node, ctx, ok := h.nodeAdminContext(w, r)
+	if !ok {
+		return
+	}
+	if !node.Quarantined {
+		apiErrorResponse(w, "node is not in quarantine", http.StatusConflict, nil)
+		return
+	}
+	quarantine, err := h.Nodes.Release(node, ctx[ctxUser])
+	if err != nil {
+		apiErrorResponse(w, "error releasing node", http.StatusInternalServerError, err)
+		return
+	}
+	if h.NodeCacheInvalidator != nil {
+		h.NodeCacheInvalidator(r.Context(), node.NodeKey)
+	}
+	if h.AuditLog != nil {
+		h.AuditLog.QuarantineAction(ctx[ctxUser], fmt.Sprintf("released node %s (%s) from quarantine", node.UUID, node.Hostname), strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	}
+	log.Info().Msgf("Node %s in %s released from quarantine by %s", node.UUID, env.Name, ctx[ctxUser])
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, quarantine)
+}
+
+// QuarantinedNodesHandler - GET Handler for the quarantined nodes of an environment
+// @Summary Get quarantined nodes
+// @Description Returns the nodes in quarantine of an environment with who quarantined them and why, most recent first.
+// @Tags nodes
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Success 200 {array} nodes.QuarantinedNode
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/quarantine [get]
+func (h *HandlersApi) QuarantinedNodesHandler(w http.ResponseWriter, r *http.Request) {
+	env, _, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	quarantined, err := h.Nodes.QuarantinedByEnv(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting quarantined nodes", http.StatusInternalServerError, err)
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, quarantined)
+}
+
+// QuarantineConfigHandler - GET Handler for the configuration served to quarantined nodes
+// @Summary Get quarantine configuration
+// @Description Returns the osquery configuration served to quarantined nodes of an environment: its options with only the incident response schedule.
+// @Tags nodes
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Success 200 {object} object
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/quarantine/config [get]
+func (h *HandlersApi) QuarantineConfigHandler(w http.ResponseWriter, r *http.Request) {
+	env, _, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	conf, err := h.Envs.QuarantineConfiguration(env)
+	if err != nil {
+		apiErrorResponse(w, "error generating quarantine configuration", http.StatusInternalServerError, err)
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, []byte(conf))
+}
+
+// QuarantineScheduleHandler - PUT Handler for the schedule served to quarantined nodes
+// @Summary Update quarantine schedule
+// @Description Sets the schedule served to quarantined nodes of an environment. An empty schedule restores the default incident response schedule.
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param request body environments.ScheduleConf true "Schedule"
+// @Success 200 {object} environments.ScheduleConf
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/quarantine/schedule [put]
+func (h *HandlersApi) QuarantineScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	env, ctx, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	var schedule environments.ScheduleConf
+	if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+		apiErrorResponse(w, "error parsing PUT body", http.StatusBadRequest, err)
+		return
+	}
+	for name, query := range schedule {
+		if strings.TrimSpace(query.Query) == "" {
+			apiErrorResponse(w, "query "+name+" is empty", http.StatusBadRequest, nil)
+			return
+		}
+	}
+	serialized := ""
+	if len(schedule) > 0 {
+		raw, err := json.Marshal(schedule)
+		if err != nil {
+			apiErrorResponse(w, "error serializing schedule", http.StatusInternalServerError, err)
+			return
+		}
+		serialized = string(raw)
+	}
+	if err := h.Envs.UpdateQuarantineSchedule(env.UUID, serialized); err != nil {
+		apiErrorResponse(w, "error updating quarantine schedule", http.StatusInternalServerError, err)
+		return
+	}
+	h.invalidateEnvCache(r.Context(), env.UUID)
+	env.QuarantineSchedule = serialized
+	current, err := h.Envs.QuarantineScheduleConf(env)
+	if err != nil {
+		apiErrorResponse(w, "error getting quarantine schedule", http.StatusInternalServerError, err)
+		return
+	}
+	if h.AuditLog != nil {
+		h.AuditLog.EnvAction(ctx[ctxUser], fmt.Sprintf("set quarantine schedule of %s with %d queries", env.Name, len(current)), strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, current)
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jmpsec/osctrl/pkg/auditlog"
+	"github.com/jmpsec/osctrl/pkg/config"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/tags"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeQuarantineHighlightsActionsUntilReleased(t *testing.T) {
+	db, h, env, node := setupConsoleHandlers(t)
+	h.Tags = tags.CreateTagManager(db)
+	auditManager, err := auditlog.CreateAuditLogManager(db, config.ServiceAPI, true)
+	require.NoError(t, err)
+	h.AuditLog = auditManager
+	h.DebugHTTPConfig = &config.YAMLConfigurationDebug{}
+	var invalidated int
+	h.NodeCacheInvalidator = func(context.Context, string) { invalidated++ }
+	tagNode := func(tag string) {
+		rr := httptest.NewRecorder()
+		req := consoleRequest(http.MethodPost, "/tag", []byte(`{"uuid":"NODE-UUID","tag":"`+tag+`"}`), "alice")
+		req.SetPathValue("env", env.Name)
+		h.TagNodeHandler(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	}
+	warnings := func() int {
+		logs, err := auditManager.GetBySeverityEnv(auditlog.SeverityWarning, env.ID)
+		require.NoError(t, err)
+		return len(logs)
+	}
+
+	tagNode("before")
+	require.Zero(t, warnings())
+
+	rr := httptest.NewRecorder()
+	req := consoleRequest(http.MethodPost, "/quarantine", []byte(`{"reason":"beaconing"}`), "alice")
+	req.SetPathValue("env", env.Name)
+	req.SetPathValue("node", node.UUID)
+	h.NodeQuarantineHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, 1, invalidated)
+	require.Equal(t, 1, warnings())
+
+	rr = httptest.NewRecorder()
+	req = consoleRequest(http.MethodPost, "/quarantine", []byte(`{}`), "alice")
+	req.SetPathValue("env", env.Name)
+	req.SetPathValue("node", node.UUID)
+	h.NodeQuarantineHandler(rr, req)
+	require.Equal(t, http.StatusConflict, rr.Code)
+
+	rr = httptest.NewRecorder()
+	req = consoleRequest(http.MethodGet, "/quarantine", nil, "alice")
+	req.SetPathValue("env", env.Name)
+	h.QuarantinedNodesHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var quarantined []nodes.QuarantinedNode
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &quarantined))
+	require.Len(t, quarantined, 1)
+	require.Equal(t, "beaconing", quarantined[0].Quarantine.Reason)
+
+	// Actions against the quarantined node are warnings in the audit log
+	tagNode("during")
+	require.Equal(t, 2, warnings())
+
+	rr = httptest.NewRecorder()
+	req = consoleRequest(http.MethodDelete, "/quarantine", nil, "alice")
+	req.SetPathValue("env", env.Name)
+	req.SetPathValue("node", node.UUID)
+	h.NodeReleaseHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, 2, invalidated)
+	require.Equal(t, 3, warnings())
+	tagNode("after")
+	require.Equal(t, 3, warnings())
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/jmpsec/osctrl/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// revokeTargets resolves the nodes of a revoke request, picked by UUIDs, by
+// tag or all the nodes of the environment
+func (h *HandlersApi) revokeTargets(env environments.TLSEnvironment, body types.ApiNodeRevokeRequest) ([]nodes.OsqueryNode, int, error) {
+	picked := 0
+	if len(body.UUIDs) > 0 {
+		picked++
+	}
+	if body.Tag != "" {
+		picked++
+	}
+	if body.All {
+		picked++
+	}
+	if picked != 1 {
+		return nil, http.StatusBadRequest, fmt.Errorf("one of uuid_list, tag or all is required")
+	}
+	switch {
+	case body.All:
+		found, err := h.Nodes.GetByEnv(env.Name, nodes.AllNodes, 0)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return found, http.StatusOK, nil
+	case body.Tag != "":
+		if h.Tags == nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("tags are not available")
+		}
+		tag, err := h.Tags.Get(body.Tag, env.ID)
+		if err != nil {
+			return nil, http.StatusNotFound, fmt.Errorf("tag %s: %w", body.Tag, err)
+		}
+		tagged, err := h.Tags.GetTaggedNodes(tag)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		ids := make([]uint, 0, len(tagged))
+		for _, t := range tagged {
+			ids = append(ids, t.NodeID)
+		}
+		found, err := h.Nodes.GetByIDs(ids)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		inEnv := []nodes.OsqueryNode{}
+		for _, node := range found {
+			if node.EnvironmentID == env.ID {
+				inEnv = append(inEnv, node)
+			}
+		}
+		return inEnv, http.StatusOK, nil
+	}
+	var found []nodes.OsqueryNode
+	for _, uuid := range body.UUIDs {
+		node, err := h.Nodes.GetByUUIDEnv(uuid, env.ID)
+		if err != nil {
+			return nil, http.StatusNotFound, fmt.Errorf("node %s: %w", uuid, err)
+		}
+		found = append(found, node)
+	}
+	return found, http.StatusOK, nil
+}
+
+// NodesRevokeHandler - POST Handler to revoke the node_key of nodes
+// @Summary Revoke node keys
+// @Description Replaces the node_key of nodes picked by UUIDs, by tag or all the nodes of the environment, so their next request gets node_invalid and osquery enrolls again with the enroll secret. Optionally the enroll secret of the environment is rotated too, when it leaked along with the keys.
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param request body types.ApiNodeRevokeRequest true "Request body"
+// @Success 200 {object} types.ApiNodeRevokeResponse
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 404 {object} types.ApiErrorResponse "Not found"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/revoke [post]
+func (h *HandlersApi) NodesRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	env, ctx, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	var body types.ApiNodeRevokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		return
+	}
+	revoking, code, err := h.revokeTargets(env, body)
+	if err != nil {
+		apiErrorResponse(w, "error getting nodes", code, err)
+		return
+	}
+	revocations, err := h.Nodes.RevokeKeys(revoking, body.Reason, ctx[ctxUser])
+	if err != nil {
+		apiErrorResponse(w, "error revoking node keys", http.StatusInternalServerError, err)
+		return
+	}
+	ip := strings.Split(r.RemoteAddr, ":")[0]
+	response := types.ApiNodeRevokeResponse{Revoked: []string{}}
+	for _, node := range revoking {
+		if h.NodeCacheInvalidator != nil {
+			h.NodeCacheInvalidator(r.Context(), node.NodeKey)
+		}
+		response.Revoked = append(response.Revoked, node.UUID)
+		msg := fmt.Sprintf("revoked node key of %s (%s)", node.UUID, node.Hostname)
+		if body.Reason != "" {
+			msg += ": " + body.Reason
+		}
+		h.auditNodeAction(ctx[ctxUser], msg, ip, node, env.ID)
+	}
+	if body.RotateSecret {
+		if err := h.Envs.RotateSecret(env.Name); err != nil {
+			apiErrorResponse(w, "error rotating enroll secret", http.StatusInternalServerError, err)
+			return
+		}
+		h.invalidateEnvCache(r.Context(), env.UUID)
+		response.SecretRotated = true
+		if h.AuditLog != nil {
+			h.AuditLog.EnvAction(ctx[ctxUser], "rotated enroll secret of "+env.Name+" after revoking node keys", ip, env.ID)
+		}
+	}
+	log.Debug().Msgf("Revoked %d node keys in %s", len(revocations), env.Name)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, response)
+}
+
+// NodesRevocationsHandler - GET Handler for the node key revocations of an environment
+// @Summary Get node key revocations
+// @Description Returns the node_key revocations of an environment newest first, with a hash of each revoked key.
+// @Tags nodes
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Success 200 {array} nodes.NodeKeyRevocation
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/revocations [get]
+func (h *HandlersApi) NodesRevocationsHandler(w http.ResponseWriter, r *http.Request) {
+	env, _, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	revocations, err := h.Nodes.RevocationsByEnv(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting revocations", http.StatusInternalServerError, err)
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, revocations)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jmpsec/osctrl/pkg/auditlog"
+	"github.com/jmpsec/osctrl/pkg/config"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/tags"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodesRevokeByTagRotatesKeysAndSecret(t *testing.T) {
+	db, h, env, node := setupConsoleHandlers(t)
+	h.Tags = tags.CreateTagManager(db)
+	h.AuditLog = &auditlog.AuditLogManager{}
+	h.DebugHTTPConfig = &config.YAMLConfigurationDebug{}
+	var invalidated []string
+	h.NodeCacheInvalidator = func(_ context.Context, nodeKey string) { invalidated = append(invalidated, nodeKey) }
+	require.NoError(t, db.Model(&node).Update("node_key", "node-key").Error)
+	node.NodeKey = "node-key"
+	untagged := nodes.OsqueryNode{UUID: "UNTAGGED-UUID", NodeKey: "untagged-key", EnvironmentID: env.ID, Environment: env.UUID}
+	require.NoError(t, db.Create(&untagged).Error)
+	require.NoError(t, h.Tags.TagNode("compromised", node, "alice", false, tags.TagTypeCustom, ""))
+	before, err := h.Envs.Get(env.Name)
+	require.NoError(t, err)
+
+	for _, body := range []string{`{}`, `{"tag":"compromised","all":true}`} {
+		rr := httptest.NewRecorder()
+		req := consoleRequest(http.MethodPost, "/revoke", []byte(body), "alice")
+		req.SetPathValue("env", env.Name)
+		h.NodesRevokeHandler(rr, req)
+		require.Equal(t, http.StatusBadRequest, rr.Code, body)
+	}
+
+	rr := httptest.NewRecorder()
+	req := consoleRequest(http.MethodPost, "/revoke", []byte(`{"tag":"compromised"}`), "bob")
+	req.SetPathValue("env", env.Name)
+	h.NodesRevokeHandler(rr, req)
+	require.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = httptest.NewRecorder()
+	req = consoleRequest(http.MethodPost, "/revoke", []byte(`{"tag":"compromised","reason":"stolen laptop","rotate_secret":true}`), "alice")
+	req.SetPathValue("env", env.Name)
+	h.NodesRevokeHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var resp types.ApiNodeRevokeResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Equal(t, []string{node.UUID}, resp.Revoked)
+	require.True(t, resp.SecretRotated)
+	require.Equal(t, []string{"node-key"}, invalidated)
+
+	_, err = h.Nodes.GetByKey("node-key")
+	require.Error(t, err)
+	_, err = h.Nodes.GetByKey("untagged-key")
+	require.NoError(t, err)
+	after, err := h.Envs.Get(env.Name)
+	require.NoError(t, err)
+	require.NotEqual(t, before.Secret, after.Secret)
+
+	rr = httptest.NewRecorder()
+	req = consoleRequest(http.MethodGet, "/revocations", nil, "alice")
+	req.SetPathValue("env", env.Name)
+	h.NodesRevocationsHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var revocations []nodes.NodeKeyRevocation
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &revocations))
+	require.Len(t, revocations, 1)
+	require.Equal(t, "stolen laptop", revocations[0].Reason)
+	require.Equal(t, "alice", revocations[0].RevokedBy)
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/config"
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/settings"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/jmpsec/osctrl/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// StalePolicyUser is the audit log user of the background stale policy job
+const StalePolicyUser = "osctrl"
+
+// stalePolicy is the stale node policy of an environment from its settings
+func (h *HandlersApi) stalePolicy(envID uint) nodes.StalePolicy {
+	return nodes.StalePolicy{
+		InactiveHours: h.Settings.InactiveHours(envID),
+		ArchiveDays:   h.Settings.StaleArchiveDays(envID),
+		PurgeDays:     h.Settings.ArchivePurgeDays(envID),
+	}
+}
+
+// applyStalePolicy applies the stale node policy of an environment, writing
+// an audit entry for every archived node and for the purged archives
+func (h *HandlersApi) applyStalePolicy(env environments.TLSEnvironment, user, ip string, dryRun bool) (nodes.StaleReport, error) {
+	report, err := h.Nodes.ApplyStalePolicy(env.ID, h.stalePolicy(env.ID), time.Now(), dryRun)
+	if err != nil || dryRun || h.AuditLog == nil {
+		return report, err
+	}
+	for _, node := range report.Archived {
+		h.AuditLog.NodeAction(user, fmt.Sprintf("archived stale node %s (%s), last seen %s", node.UUID, node.Hostname, node.LastSeen.UTC().Format(time.RFC3339)), ip, env.ID)
+	}
+	if len(report.Purged) > 0 {
+		h.AuditLog.NodeAction(user, fmt.Sprintf("purged %d archived nodes older than %d days", len(report.Purged), report.Policy.PurgeDays), ip, env.ID)
+	}
+	return report, nil
+}
+
+// RunStalePolicies applies the stale node policy of every environment. It is
+// run periodically in the background by osctrl-api.
+func (h *HandlersApi) RunStalePolicies() {
+	envs, err := h.Envs.All()
+	if err != nil {
+		log.Err(err).Msg("error getting environments for stale policies")
+		return
+	}
+	for _, env := range envs {
+		report, err := h.applyStalePolicy(env, StalePolicyUser, "", false)
+		if err != nil {
+			log.Err(err).Msgf("error applying stale policy to environment %s", env.Name)
+			continue
+		}
+		if len(report.Archived) > 0 || len(report.Purged) > 0 {
+			log.Info().Msgf("Stale policy of %s archived %d nodes and purged %d archives", env.Name, len(report.Archived), len(report.Purged))
+		}
+	}
+}
+
+// StalePolicyHandler - GET Handler for the stale node policy of an environment
+// @Summary Get stale node policy
+// @Description Returns when nodes of an environment become inactive, are archived with trigger stale and when archives are purged.
+// @Tags nodes
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Success 200 {object} nodes.StalePolicy
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/stale/policy [get]
+func (h *HandlersApi) StalePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	env, _, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, h.stalePolicy(env.ID))
+}
+
+// StalePolicyUpdateHandler - PUT Handler for the stale node policy of an environment
+// @Summary Update stale node policy
+// @Description Sets the days without checking in after which nodes are archived and the days after which archives are purged. Zero disables a step.
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Param request body types.StalePolicyRequest true "Request body"
+// @Success 200 {object} nodes.StalePolicy
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/stale/policy [put]
+func (h *HandlersApi) StalePolicyUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	env, ctx, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	var body types.StalePolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiErrorResponse(w, "error parsing PUT body", http.StatusBadRequest, err)
+		return
+	}
+	if (body.ArchiveDays != nil && *body.ArchiveDays < 0) || (body.PurgeDays != nil && *body.PurgeDays < 0) {
+		apiErrorResponse(w, "days can not be negative", http.StatusBadRequest, nil)
+		return
+	}
+	if body.ArchiveDays != nil {
+		if err := h.Settings.SetEnvInteger(*body.ArchiveDays, config.ServiceAdmin, settings.StaleArchiveDays, env.ID); err != nil {
+			apiErrorResponse(w, "error updating policy", http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if body.PurgeDays != nil {
+		if err := h.Settings.SetEnvInteger(*body.PurgeDays, config.ServiceAdmin, settings.ArchivePurgeDays, env.ID); err != nil {
+			apiErrorResponse(w, "error updating policy", http.StatusInternalServerError, err)
+			return
+		}
+	}
+	policy := h.stalePolicy(env.ID)
+	if h.AuditLog != nil {
+		h.AuditLog.EnvAction(ctx[ctxUser], fmt.Sprintf("stale policy of %s set to archive after %d days and purge after %d days", env.Name, policy.ArchiveDays, policy.PurgeDays), strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, policy)
+}
+
+// StaleReportHandler - GET Handler for a dry run of the stale node policy
+// @Summary Stale node policy dry run
+// @Description Reports the inactive nodes of an environment and the nodes and archives its stale policy would archive and purge now, without changing anything.
+// @Tags nodes
+// @Produce json
+// @Param env path string true "Environment name or UUID"
+// @Success 200 {object} nodes.StaleReport
+// @Failure 400 {object} types.ApiErrorResponse "Bad request"
+// @Failure 401 {object} types.ApiErrorResponse "Unauthorized"
+// @Failure 403 {object} types.ApiErrorResponse "Forbidden"
+// @Failure 500 {object} types.ApiErrorResponse "Internal server error"
+// @Security ApiKeyAuth
+// @Router /api/v1/nodes/{env}/stale/report [get]
+func (h *HandlersApi) StaleReportHandler(w http.ResponseWriter, r *http.Request) {
+	env, ctx, ok := h.envAdminContext(w, r)
+	if !ok {
+		return
+	}
+	report, err := h.applyStalePolicy(env, ctx[ctxUser], strings.Split(r.RemoteAddr, ":")[0], true)
+	if err != nil {
+		apiErrorResponse(w, "error getting stale report", http.StatusInternalServerError, err)
+		return
+	}
+	if h.AuditLog != nil {
+		h.AuditLog.NodeAction(ctx[ctxUser], "viewed stale node report of "+env.Name, strings.Split(r.RemoteAddr, ":")[0], env.ID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, report)
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/config"
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/stretchr/testify/require"
+)
+
+func staleRequest(method string, env environments.TLSEnvironment, body []byte, username string) *http.Request {
+	req := httptest.NewRequest(method, "/stale", bytes.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), ContextKey(contextAPI), ContextValue{ctxUser: username}))
+	req.SetPathValue("env", env.Name)
+	return req
+}
+
+func TestStalePolicyUpdateAndDryRunReport(t *testing.T) {
+	db, h, env, node := setupConsoleHandlers(t)
+	h.DebugHTTPConfig = &config.YAMLConfigurationDebug{}
+	require.NoError(t, db.Model(&node).Update("last_seen", time.Now().AddDate(0, 0, -45)).Error)
+
+	rr := httptest.NewRecorder()
+	h.StalePolicyUpdateHandler(rr, staleRequest(http.MethodPut, env, []byte(`{"archive_days":30,"purge_days":180}`), "alice"))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var policy nodes.StalePolicy
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &policy))
+	require.Equal(t, nodes.StalePolicy{InactiveHours: 72, ArchiveDays: 30, PurgeDays: 180}, policy)
+
+	rr = httptest.NewRecorder()
+	h.StaleReportHandler(rr, staleRequest(http.MethodGet, env, nil, "alice"))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var report nodes.StaleReport
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	require.True(t, report.DryRun)
+	require.Equal(t, int64(1), report.Inactive)
+	require.Len(t, report.Archived, 1)
+	require.Equal(t, node.UUID, report.Archived[0].UUID)
+	require.True(t, h.Nodes.CheckByUUID(node.UUID), "a dry run does not archive")
+
+	h.RunStalePolicies()
+	require.False(t, h.Nodes.CheckByUUID(node.UUID))
+	archives, err := h.Nodes.GetArchives(node.UUID)
+	require.NoError(t, err)
+	require.Len(t, archives, 1)
+	require.Equal(t, nodes.ArchiveTriggerStale, archives[0].Trigger)
+}
+
+func TestStalePolicyUpdateRejectsNegativeDaysAndNonAdmins(t *testing.T) {
+	_, h, env, _ := setupConsoleHandlers(t)
+	h.DebugHTTPConfig = &config.YAMLConfigurationDebug{}
+
+	rr := httptest.NewRecorder()
+	h.StalePolicyUpdateHandler(rr, staleRequest(http.MethodPut, env, []byte(`{"archive_days":-1}`), "alice"))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	h.StalePolicyUpdateHandler(rr, staleRequest(http.MethodPut, env, []byte(`{"archive_days":1}`), "bob"))
+	require.Equal(t, http.StatusForbidden, rr.Code)
+	require.Equal(t, int64(0), h.Settings.StaleArchiveDays(env.ID))
+}
@@ -216,6 +216,7 @@ func (h *HandlersApi) QueriesRunHandler(w http.ResponseWriter, r *http.Request)
 		UUIDs:         q.UUIDs,
 		Hosts:         q.Hosts,
 		Tags:          q.Tags,
+		Attributes:    q.Attributes,
 		EnvID:         env.ID,
 		InactiveHours: h.Settings.InactiveHours(settings.NoEnvironmentID),
 	}
@@ -37,6 +37,9 @@ type EnvStats struct {
 	// of that platform regardless of staleness — the Active/Inactive toggle
 	// is independent.
 	PlatformCounts nodes.PlatformCounts `json:"platform_counts"`
+	// Drifted counts the nodes whose reported config hash disagrees with the
+	// configuration served to them for longer than the drift intervals.
+	Drifted int64 `json:"drifted"`
 }
 
 // StatsResponse is the canonical /api/v1/stats shape consumed by the dashboard.
@@ -52,6 +55,8 @@ type StatsResponse struct {
 	TotalActiveCarves int `json:"total_active_carves"`
 	// Cross-env platform breakdown — sum of every accessible env's PlatformCounts.
 	PlatformCounts nodes.PlatformCounts `json:"platform_counts"`
+	// DriftedNodes is the sum of every accessible env's Drifted.
+	DriftedNodes int64 `json:"drifted_nodes"`
 
 	// Per-env breakdown, in stable alphabetical order by name.
 	Environments []EnvStats `json:"environments"`
@@ -143,6 +148,13 @@ func (h *HandlersApi) StatsHandler(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
+		// Drift counts degrade to zero like platform counts.
+		_, cutoff := h.driftCutoff(e, time.Now())
+		drifted, err := h.Nodes.CountDrifted(e.ID, cutoff)
+		if err != nil {
+			log.Warn().Err(err).Str("env", e.Name).Msg("stats: failed to count drifted nodes, defaulting to zero")
+		}
+
 		row := EnvStats{
 			UUID:           e.UUID,
 			Name:           e.Name,
@@ -152,6 +164,7 @@ func (h *HandlersApi) StatsHandler(w http.ResponseWriter, r *http.Request) {
 			ActiveQueries:  len(activeQ),
 			ActiveCarves:   len(activeC),
 			PlatformCounts: platCounts,
+			Drifted:        drifted,
 		}
 		out.Environments = append(out.Environments, row)
 		out.ActiveNodes += ns.Active
@@ -159,6 +172,7 @@ func (h *HandlersApi) StatsHandler(w http.ResponseWriter, r *http.Request) {
 		out.TotalNodes += ns.Total
 		out.TotalActiveQueries += len(activeQ)
 		out.TotalActiveCarves += len(activeC)
+		out.DriftedNodes += drifted
 		// Aggregate cross-env platform totals.
 		out.PlatformCounts.Linux += platCounts.Linux
 		out.PlatformCounts.Darwin += platCounts.Darwin
@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/nodes"
 	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/jmpsec/osctrl/pkg/users"
 	"github.com/jmpsec/osctrl/pkg/utils"
 	"github.com/rs/zerolog/log"
 )
@@ -28,3 +32,65 @@ func apiErrorResponse(w http.ResponseWriter, msg string, code int, err error) {
 	log.Debug().Msgf("apiErrorResponse %s: %v", msg, err)
 	utils.HTTPResponse(w, utils.JSONApplicationUTF8, code, types.ApiErrorResponse{Error: msg})
 }
+
+// envAdminContext resolves the environment of a request and
+// checks that the user is an administrator of it
+func (h *HandlersApi) envAdminContext(w http.ResponseWriter, r *http.Request) (environments.TLSEnvironment, ContextValue, bool) {
+	// Debug HTTP if enabled
+	if h.DebugHTTPConfig.EnableHTTP {
+		utils.DebugHTTPDump(h.DebugHTTP, r, h.DebugHTTPConfig.ShowBody)
+	}
+	envVar := r.PathValue("env")
+	if envVar == "" {
+		apiErrorResponse(w, "error with environment", http.StatusBadRequest, nil)
+		return environments.TLSEnvironment{}, nil, false
+	}
+	env, err := h.Envs.Get(envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		return environments.TLSEnvironment{}, nil, false
+	}
+	ctx := r.Context().Value(ContextKey(contextAPI)).(ContextValue)
+	if !h.Users.CheckPermissions(ctx[ctxUser], users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		return environments.TLSEnvironment{}, nil, false
+	}
+	return env, ctx, true
+}
+
+// nodeAdminContext resolves the environment and node of a request and checks
+// that the user is an administrator of the environment
+func (h *HandlersApi) nodeAdminContext(w http.ResponseWriter, r *http.Request) (environments.TLSEnvironment, nodes.OsqueryNode, ContextValue, bool) {
+	env, ctx, ok := h.envAdminContext(w, r)
+	if !ok {
+		return env, nodes.OsqueryNode{}, ctx, false
+	}
+	nodeVar := r.PathValue("node")
+	if nodeVar == "" {
+		apiErrorResponse(w, "error getting node", http.StatusBadRequest, nil)
+		return env, nodes.OsqueryNode{}, ctx, false
+	}
+	node, err := h.Nodes.GetByIdentifierEnv(nodeVar, env.ID)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "node not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting node", http.StatusInternalServerError, err)
+		}
+		return env, nodes.OsqueryNode{}, ctx, false
+	}
+	return env, node, ctx, true
+}
+
+// auditNodeAction writes an action against a node to the audit log, highlighted
+// as a quarantine action when the node is in quarantine
+func (h *HandlersApi) auditNodeAction(user, action, ip string, node nodes.OsqueryNode, envID uint) {
+	if h.AuditLog == nil {
+		return
+	}
+	if node.Quarantined {
+		h.AuditLog.QuarantineAction(user, action, ip, envID)
+		return
+	}
+	h.AuditLog.NodeAction(user, action, ip, envID)
+}
@@ -51,6 +51,10 @@ const (
 	appDescription = serviceDescription + ", a fast and efficient osquery management"
 	// Default refreshing interval in seconds
 	defaultRefresh int = 300
+	// Interval to apply the stale node policies of every environment
+	stalePolicyInterval = time.Hour
+	// rolloutInterval is how often configuration rollouts are checked
+	rolloutInterval = time.Minute
 )
 
 // Build-time metadata (overridden via -ldflags "-X main.buildVersion=... -X main.buildCommit=... -X main.buildDate=...")
@@ -125,6 +129,8 @@ var (
 	nodesmgr             *nodes.NodeManager
 	queriesmgr           *queries.Queries
 	consolemgr           *console.Manager
+	consoleStream        *console.Broker
+	consoleSigner        *console.TranscriptSigner
 	filecarves           *carves.Carves
 	handlersApi          *handlers.HandlersApi
 	app                  *cli.Command
@@ -190,7 +196,8 @@ func init() {
 			S3:    &config.S3Carver{},
 			Local: &config.LocalCarver{},
 		},
-		Debug: &config.YAMLConfigurationDebug{},
+		Console: &config.YAMLConfigurationConsole{},
+		Debug:   &config.YAMLConfigurationDebug{},
 	}
 	// Initialize CLI flags using the config package
 	flags = config.InitAPIFlags(flagParams)
@@ -243,6 +250,21 @@ func guardAuthMode(ctx context.Context, auth string) {
 	}()
 }
 
+// runExclusive runs a periodic job unless another replica of osctrl-api ran
+// it in the last interval, with a lock in Redis shared by all replicas.
+func runExclusive(name string, interval time.Duration, job func()) {
+	ok, err := cache.TryLock(context.Background(), redis.Client, name, interval)
+	if err != nil {
+		log.Err(err).Msgf("error locking %s", name)
+		return
+	}
+	if !ok {
+		log.Debug().Msgf("Skipping %s, another replica ran it", name)
+		return
+	}
+	job()
+}
+
 // Go go!
 func osctrlAPIService() {
 	// Refuse to run unauthenticated unless the operator explicitly opts in.
@@ -320,8 +342,25 @@ func osctrlAPIService() {
 	queriesmgr = queries.CreateQueries(db.Conn)
 	log.Info().Msg("Initialize console")
 	consolemgr = console.NewManager(db.Conn, queriesmgr)
+	consoleStream = console.NewBroker(consolemgr).WithRedis(redis.Client)
+	go consoleStream.Listen(context.Background())
+	if flagParams.Console.TranscriptKeyFile != "" {
+		consoleSigner, err = console.LoadTranscriptSigner(flagParams.Console.TranscriptKeyFile)
+		if err != nil {
+			log.Fatal().Msgf("Error loading console transcript key - %v", err)
+		}
+		log.Info().Msg("Console transcript signing enabled")
+	}
 	log.Info().Msg("Initialize carves")
 	filecarves = carves.CreateFileCarves(db.Conn, flagParams.Carver.Type, nil)
+	if flagParams.Carver.KeyFile != "" {
+		carveKeys, err := carves.LoadKeyRing(flagParams.Carver.KeyFile)
+		if err != nil {
+			log.Fatal().Msgf("Error loading carves keyfile - %v", err)
+		}
+		filecarves.WithEncryption(carveKeys)
+		log.Info().Msg("Carves encryption at rest enabled")
+	}
 	log.Info().Msg("Loading service settings")
 	if err := loadingSettings(settingsmgr, flagParams); err != nil {
 		log.Fatal().Msgf("Error loading settings - %v", err)
@@ -378,8 +417,15 @@ func osctrlAPIService() {
 		handlers.WithUsers(apiUsers),
 		handlers.WithTags(tagsmgr),
 		handlers.WithNodes(nodesmgr),
+		handlers.WithNodeCacheInvalidator(func(ctx context.Context, nodeKey string) {
+			if err := redis.Client.Set(ctx, nodes.RedisNodeInvalidatePrefix+nodeKey, nodes.NodeInvalidationVersion(), nodes.NodeInvalidateTTL).Err(); err != nil {
+				log.Err(err).Msg("error invalidating cached node")
+			}
+		}),
 		handlers.WithQueries(queriesmgr),
 		handlers.WithConsole(consolemgr),
+		handlers.WithConsoleStream(consoleStream),
+		handlers.WithConsoleSigner(consoleSigner),
 		handlers.WithCarves(filecarves),
 		handlers.WithSettings(settingsmgr),
 		handlers.WithActivityReader(activity.NewRedisStore(redis.Client, activity.DefaultPrefix, activity.DefaultRetentionDays, 8*24*time.Hour)),
@@ -397,6 +443,26 @@ func osctrlAPIService() {
 		handlers.WithSAML(flagParams.SAML != nil && flagParams.SAML.Enabled),
 	)
 
+	// Goroutine to archive stale nodes and purge old archives
+	log.Info().Msg("Initialize stale node policies")
+	go func() {
+		for {
+			log.Debug().Msg("Applying stale node policies")
+			runExclusive("stale-policies", stalePolicyInterval, handlersApi.RunStalePolicies)
+			time.Sleep(stalePolicyInterval)
+		}
+	}()
+
+	// Goroutine to widen and halt configuration rollouts
+	log.Info().Msg("Initialize configuration rollouts")
+	go func() {
+		for {
+			time.Sleep(rolloutInterval)
+			log.Debug().Msg("Checking configuration rollouts")
+			runExclusive("rollouts", rolloutInterval, handlersApi.RunRollouts)
+		}
+	}()
+
 	// ///////////////////////// API
 	log.Info().Msg("Initializing router")
 	// Create router for API endpoint
@@ -491,6 +557,102 @@ func osctrlAPIService() {
 	muxAPI.Handle(
 		"GET "+_apiPath(apiNodesPath)+"/{env}/node/{node}",
 		handlerAuthCheck(http.HandlerFunc(handlersApi.NodeHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"GET "+_apiPath(apiNodesPath)+"/{env}/node/{node}/timeline",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.NodeTimelineHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	// API: custom node attributes
+	muxAPI.Handle(
+		"GET "+_apiPath(apiNodesPath)+"/{env}/node/{node}/attributes",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.NodeAttributesHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"PUT "+_apiPath(apiNodesPath)+"/{env}/node/{node}/attributes",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.NodeAttributeSetHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"DELETE "+_apiPath(apiNodesPath)+"/{env}/node/{node}/attributes/{name}",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.NodeAttributeDeleteHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"POST "+_apiPath(apiNodesPath)+"/{env}/attributes/import",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.NodeAttributesImportHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	// API: stale node policy and its dry run report
+	muxAPI.Handle(
+		"GET "+_apiPath(apiNodesPath)+"/{env}/stale/policy",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.StalePolicyHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"PUT "+_apiPath(apiNodesPath)+"/{env}/stale/policy",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.StalePolicyUpdateHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"GET "+_apiPath(apiNodesPath)+"/{env}/stale/report",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.StaleReportHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	// Nodes reporting a config hash other than the served configuration
+	muxAPI.Handle(
+		"GET "+_apiPath(apiNodesPath)+"/{env}/drift",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.NodeDriftHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	// API: configuration overlays by tag or platform
+	muxAPI.Handle(
+		"GET "+_apiPath(apiNodesPath)+"/{env}/overlays",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.ConfigOverlaysHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"PUT "+_apiPath(apiNodesPath)+"/{env}/overlays/{name}",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.ConfigOverlaySetHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"DELETE "+_apiPath(apiNodesPath)+"/{env}/overlays/{name}",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.ConfigOverlayDeleteHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"GET "+_apiPath(apiNodesPath)+"/{env}/node/{node}/config",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.NodeConfigHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	// API: node quarantine
+	muxAPI.Handle(
+		"GET "+_apiPath(apiNodesPath)+"/{env}/quarantine",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.QuarantinedNodesHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"GET "+_apiPath(apiNodesPath)+"/{env}/quarantine/config",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.QuarantineConfigHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"PUT "+_apiPath(apiNodesPath)+"/{env}/quarantine/schedule",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.QuarantineScheduleHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"POST "+_apiPath(apiNodesPath)+"/{env}/node/{node}/quarantine",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.NodeQuarantineHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"DELETE "+_apiPath(apiNodesPath)+"/{env}/node/{node}/quarantine",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.NodeReleaseHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	// API: node approval
+	muxAPI.Handle(
+		"GET "+_apiPath(apiNodesPath)+"/{env}/approvals",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.ApprovalNodesHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"GET "+_apiPath(apiNodesPath)+"/{env}/approvals/rules",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.ApprovalRulesHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"POST "+_apiPath(apiNodesPath)+"/{env}/approvals/rules",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.ApprovalRuleCreateHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"DELETE "+_apiPath(apiNodesPath)+"/{env}/approvals/rules/{id}",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.ApprovalRuleDeleteHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"POST "+_apiPath(apiNodesPath)+"/{env}/node/{node}/approve",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.NodeApproveHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"POST "+_apiPath(apiNodesPath)+"/{env}/node/{node}/reject",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.NodeRejectHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	// API: node key revocation
+	muxAPI.Handle(
+		"POST "+_apiPath(apiNodesPath)+"/{env}/revoke",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.NodesRevokeHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"GET "+_apiPath(apiNodesPath)+"/{env}/revocations",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.NodesRevocationsHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	// API: move nodes to another environment
+	muxAPI.Handle(
+		"POST "+_apiPath(apiNodesPath)+"/{env}/move",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.NodesMoveHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	// API: duplicate nodes and merging them
+	muxAPI.Handle(
+		"GET "+_apiPath(apiNodesPath)+"/{env}/duplicates",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.NodeDuplicatesHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"POST "+_apiPath(apiNodesPath)+"/{env}/duplicates/merge",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.NodeMergeHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
 	muxAPI.Handle(
 		"POST "+_apiPath(apiNodesPath)+"/{env}/delete",
 		handlerAuthCheck(http.HandlerFunc(handlersApi.DeleteNodeHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
@@ -594,10 +756,13 @@ func osctrlAPIService() {
 		muxAPI.Handle(
 			"POST "+_apiPath(apiQueriesPath)+"/{env}/{action}/{name}",
 			handlerAuthCheck(http.HandlerFunc(handlersApi.QueriesActionHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
-		// API: accelerated per-node console
+		// API: accelerated per-node and group console
 		muxAPI.Handle(
 			"POST "+_apiPath("/console")+"/{env}/nodes/{uuid}/sessions",
 			handlerAuthCheck(http.HandlerFunc(handlersApi.ConsoleSessionCreateHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+		muxAPI.Handle(
+			"POST "+_apiPath("/console")+"/{env}/groups/sessions",
+			handlerAuthCheck(http.HandlerFunc(handlersApi.ConsoleGroupSessionCreateHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
 		muxAPI.Handle(
 			"GET "+_apiPath("/console")+"/{env}/sessions/{session_id}",
 			handlerAuthCheck(http.HandlerFunc(handlersApi.ConsoleSessionShowHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
@@ -613,6 +778,18 @@ func osctrlAPIService() {
 		muxAPI.Handle(
 			"GET "+_apiPath("/console")+"/{env}/sessions/{session_id}/commands/{command_id}/results",
 			handlerAuthCheck(http.HandlerFunc(handlersApi.ConsoleCommandResultsHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+		muxAPI.Handle(
+			"GET "+_apiPath("/console")+"/{env}/sessions/{session_id}/commands/{command_id}/nodes",
+			handlerAuthCheck(http.HandlerFunc(handlersApi.ConsoleCommandNodesHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+		muxAPI.Handle(
+			"GET "+_apiPath("/console")+"/{env}/sessions/{session_id}/stream",
+			handlerAuthCheck(http.HandlerFunc(handlersApi.ConsoleStreamHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+		muxAPI.Handle(
+			"GET "+_apiPath("/console")+"/{env}/sessions/{session_id}/transcript",
+			handlerAuthCheck(http.HandlerFunc(handlersApi.ConsoleTranscriptHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+		muxAPI.Handle(
+			"GET "+_apiPath("/console")+"/{env}/sessions/{session_id}/complete",
+			handlerAuthCheck(http.HandlerFunc(handlersApi.ConsoleCompleteHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
 		// API: saved queries (Track 4)
 		muxAPI.Handle(
 			"GET "+_apiPath(apiSavedQueriesPath)+"/{env}",
@@ -659,6 +836,9 @@ func osctrlAPIService() {
 		muxAPI.Handle(
 			"GET "+_apiPath(apiCarvesPath)+"/{env}/archive/{name}",
 			handlerAuthCheck(http.HandlerFunc(handlersApi.CarveArchiveHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+		muxAPI.Handle(
+			"POST "+_apiPath(apiCarvesPath)+"/{env}/keys/rotate",
+			handlerAuthCheck(http.HandlerFunc(handlersApi.CarveKeysRotateHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
 		muxAPI.Handle(
 			"POST "+_apiPath(apiCarvesPath)+"/{env}/{action}/{name}",
 			handlerAuthCheck(http.HandlerFunc(handlersApi.CarvesActionHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
@@ -743,6 +923,9 @@ func osctrlAPIService() {
 	muxAPI.Handle(
 		"DELETE "+_apiPath(apiEnvironmentsPath)+"/{env}",
 		handlerAuthCheck(http.HandlerFunc(handlersApi.EnvironmentDeleteHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"POST "+_apiPath(apiEnvironmentsPath)+"/clone/{env}",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.EnvironmentCloneHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
 	// Env config routes use a `/config/{env}` shape (literal in segment 1) so
 	// they cannot register-conflict with `/map/{target}` registered above. A
 	// `/{env}/config` shape would put a wildcard in segment 1 — Go's ServeMux
@@ -760,6 +943,55 @@ func osctrlAPIService() {
 	muxAPI.Handle(
 		"PATCH "+_apiPath(apiEnvironmentsPath)+"/expiration/{env}",
 		handlerAuthCheck(http.HandlerFunc(handlersApi.EnvironmentExpirationPatchHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	// Configuration revisions use the same `/<literal>/{env}` shape
+	muxAPI.Handle(
+		"GET "+_apiPath(apiEnvironmentsPath)+"/revisions/{env}",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.EnvRevisionsHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"GET "+_apiPath(apiEnvironmentsPath)+"/revision/{env}",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.EnvRevisionHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"GET "+_apiPath(apiEnvironmentsPath)+"/diff/{env}",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.EnvRevisionDiffHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"POST "+_apiPath(apiEnvironmentsPath)+"/rollback/{env}",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.EnvRollbackHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	// Configuration rollouts
+	muxAPI.Handle(
+		"GET "+_apiPath(apiEnvironmentsPath)+"/rollout/{env}",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.EnvRolloutHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"GET "+_apiPath(apiEnvironmentsPath)+"/rollouts/{env}",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.EnvRolloutsHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"POST "+_apiPath(apiEnvironmentsPath)+"/rollout/{env}",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.EnvRolloutActionHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	// Enroll tokens
+	muxAPI.Handle(
+		"GET "+_apiPath(apiEnvironmentsPath)+"/tokens/{env}",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.EnvEnrollTokensHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"POST "+_apiPath(apiEnvironmentsPath)+"/tokens/{env}",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.EnvEnrollTokenCreateHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"DELETE "+_apiPath(apiEnvironmentsPath)+"/tokens/{env}/{name}",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.EnvEnrollTokenDeleteHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	// Client certificates
+	muxAPI.Handle(
+		"GET "+_apiPath(apiEnvironmentsPath)+"/certs/{env}",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.EnvClientCertsHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"POST "+_apiPath(apiEnvironmentsPath)+"/certs/{env}",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.EnvClientCertIssueHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"GET "+_apiPath(apiEnvironmentsPath)+"/certs/ca/{env}",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.EnvClientCAHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"GET "+_apiPath(apiEnvironmentsPath)+"/certs/crl/{env}",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.EnvClientCRLHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
+	muxAPI.Handle(
+		"DELETE "+_apiPath(apiEnvironmentsPath)+"/certs/{env}/{serial}",
+		handlerAuthCheck(http.HandlerFunc(handlersApi.EnvClientCertRevokeHandler), flagParams.Service.Auth, flagParams.JWT.JWTSecret))
 	// API: tags by environment
 	muxAPI.Handle(
 		"GET "+_apiPath(apiTagsPath),
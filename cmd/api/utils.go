@@ -20,6 +20,7 @@ func loadedYAMLToServiceParams(yml config.APIConfiguration, loadedFile string) *
 		TLS:               &yml.TLS,
 		Logger:            &yml.Logger,
 		Carver:            &yml.Carver,
+		Console:           &yml.Console,
 		Debug:             &yml.Debug,
 	}
 }
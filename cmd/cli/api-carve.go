@@ -2,9 +2,19 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
 	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jmpsec/osctrl/pkg/carves"
 	"github.com/jmpsec/osctrl/pkg/queries"
@@ -97,8 +107,8 @@ func (api *OsctrlAPI) CompleteCarve(env, name string) (types.ApiGenericResponse,
 	return r, nil
 }
 
-// RunCarve to initiate a carve in osctrl
-func (api *OsctrlAPI) RunCarve(env, fPath string, uuids, hosts, platforms, tags []string, hidden bool, exp int) (types.ApiQueriesResponse, error) {
+// RunCarve to initiate a carve in osctrl, recursive when directory is set
+func (api *OsctrlAPI) RunCarve(env, fPath string, uuids, hosts, platforms, tags []string, hidden bool, exp int, directory *types.CarveDirectoryRequest) (types.ApiQueriesResponse, error) {
 	c := types.ApiDistributedQueryRequest{
 		UUIDs:     uuids,
 		Hosts:     hosts,
@@ -107,6 +117,7 @@ func (api *OsctrlAPI) RunCarve(env, fPath string, uuids, hosts, platforms, tags
 		Path:      fPath,
 		Hidden:    hidden,
 		ExpHours:  exp,
+		Directory: directory,
 	}
 	var r types.ApiQueriesResponse
 	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APICarves, env))
@@ -124,3 +135,174 @@ func (api *OsctrlAPI) RunCarve(env, fPath string, uuids, hosts, platforms, tags
 	}
 	return r, nil
 }
+
+// RotateCarveKeys to re-wrap carve data keys with the active environment key
+func (api *OsctrlAPI) RotateCarveKeys(env string) (types.ApiGenericResponse, error) {
+	var r types.ApiGenericResponse
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APICarves, env, "keys", "rotate"))
+	rawR, err := api.PostGeneric(reqURL, nil)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %w - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %w", err)
+	}
+	return r, nil
+}
+
+const (
+	// carveDownloadPartSuffix for the partial file of an ongoing carve download
+	carveDownloadPartSuffix = ".part"
+	// carveDownloadETagSuffix for the file keeping the ETag of a partial download
+	carveDownloadETagSuffix = ".etag"
+	// carveDownloadRetries is how many times an interrupted download is resumed
+	carveDownloadRetries = 5
+)
+
+// errCarveDownload wraps errors that retrying the download will not fix
+type errCarveDownload struct {
+	err error
+}
+
+func (e errCarveDownload) Error() string {
+	return e.err.Error()
+}
+
+// DownloadCarve to download a carve archive from osctrl into output. The
+// archive is written to output+".part" and renamed once complete. When a
+// partial file is present, from an interrupted transfer in this or a
+// previous run, the download resumes with a Range request bound to the
+// ETag of the partial data via If-Range; if the archive changed the server
+// sends it whole and the download restarts. Returns the archive size.
+func (api *OsctrlAPI) DownloadCarve(env, name, session, output string) (int64, error) {
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APICarves, env, "archive", name))
+	if session != "" {
+		reqURL += "?session=" + url.QueryEscape(session)
+	}
+	part := output + carveDownloadPartSuffix
+	var lastErr error
+	for attempt := 0; attempt <= carveDownloadRetries; attempt++ {
+		if attempt > 0 {
+			wait := time.Duration(attempt) * 2 * time.Second
+			log.Warn().Msgf("download interrupted (%v), resuming in %s", lastErr, wait)
+			time.Sleep(wait)
+		}
+		etag, err := api.downloadCarvePart(reqURL, part)
+		if err == nil {
+			if err := verifyCarveDownload(part, etag); err != nil {
+				os.Remove(part)
+				os.Remove(part + carveDownloadETagSuffix)
+				return 0, err
+			}
+			if err := os.Rename(part, output); err != nil {
+				return 0, fmt.Errorf("rename - %w", err)
+			}
+			os.Remove(part + carveDownloadETagSuffix)
+			stat, err := os.Stat(output)
+			if err != nil {
+				return 0, fmt.Errorf("stat - %w", err)
+			}
+			return stat.Size(), nil
+		}
+		var permanent errCarveDownload
+		if errors.As(err, &permanent) {
+			return 0, permanent.err
+		}
+		lastErr = err
+	}
+	return 0, fmt.Errorf("download failed after %d retries - %w", carveDownloadRetries, lastErr)
+}
+
+// downloadCarvePart performs one download request, appending to the partial
+// file when the server honors the range. Returns the ETag of the archive.
+func (api *OsctrlAPI) downloadCarvePart(reqURL, part string) (string, error) {
+	var offset int64
+	if stat, err := os.Stat(part); err == nil {
+		offset = stat.Size()
+	}
+	etagFile := part + carveDownloadETagSuffix
+	etag := ""
+	if raw, err := os.ReadFile(etagFile); err == nil {
+		etag = strings.TrimSpace(string(raw))
+	}
+	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+	if err != nil {
+		return "", errCarveDownload{fmt.Errorf("NewRequest - %w", err)}
+	}
+	req.Header.Set(UserAgent, osctrlUserAgent)
+	for key, value := range api.Headers {
+		req.Header.Add(key, value)
+	}
+	if offset > 0 && etag != "" {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", etag)
+	}
+	resp, err := api.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Client.Do - %w", err)
+	}
+	defer resp.Body.Close()
+	flags := os.O_WRONLY | os.O_CREATE
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// Full content, either first attempt or the archive changed
+		flags |= os.O_TRUNC
+		etag = resp.Header.Get("ETag")
+		if err := os.WriteFile(etagFile, []byte(etag), 0600); err != nil {
+			return "", errCarveDownload{fmt.Errorf("writing etag - %w", err)}
+		}
+	case http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			return "", errCarveDownload{fmt.Errorf("unexpected Content-Range %q", resp.Header.Get("Content-Range"))}
+		}
+		flags |= os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Nothing left to fetch when the partial file already has every byte
+		if resp.Header.Get("Content-Range") == "bytes */"+strconv.FormatInt(offset, 10) {
+			return etag, nil
+		}
+		os.Remove(part)
+		return "", fmt.Errorf("HTTP Code %d, restarting download", resp.StatusCode)
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return "", fmt.Errorf("HTTP Code %d - %s", resp.StatusCode, string(body))
+		}
+		return "", errCarveDownload{fmt.Errorf("HTTP Code %d - %s", resp.StatusCode, string(body))}
+	}
+	f, err := os.OpenFile(part, flags, 0600)
+	if err != nil {
+		return "", errCarveDownload{fmt.Errorf("opening %s - %w", part, err)}
+	}
+	defer f.Close()
+	written, err := io.Copy(f, resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("reading response - %w", err)
+	}
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return "", fmt.Errorf("short read, %d of %d bytes", written, resp.ContentLength)
+	}
+	return etag, nil
+}
+
+// verifyCarveDownload checks the downloaded archive against the ETag, which
+// osctrl sets to the SHA-256 of the archive content
+func verifyCarveDownload(file, etag string) error {
+	expected := strings.Trim(etag, `"`)
+	if len(expected) != sha256.Size*2 {
+		return nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("opening %s - %w", file, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("hashing %s - %w", file, err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != expected {
+		return fmt.Errorf("downloaded archive hash %s does not match %s", got, expected)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+
+	"github.com/jmpsec/osctrl/pkg/console"
+)
+
+type consoleSessionResponse struct {
+	Session console.Session        `json:"session"`
+	History []console.HistoryEntry `json:"history"`
+}
+
+type consoleCommandResponse struct {
+	Command console.Command       `json:"command"`
+	Parsed  console.ParsedCommand `json:"parsed"`
+}
+
+// consoleSessionURL builds the URL of a console session, or of one of its
+// subresources
+func (api *OsctrlAPI) consoleSessionURL(env string, session uint, elems ...string) string {
+	parts := append([]string{APIPath, APIConsole, env, "sessions", strconv.FormatUint(uint64(session), 10)}, elems...)
+	return fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(parts...))
+}
+
+// OpenConsole to open a console session on a node by UUID
+func (api *OsctrlAPI) OpenConsole(env, uuid string) (console.Session, error) {
+	var r consoleSessionResponse
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APIConsole, env, "nodes", uuid, "sessions"))
+	raw, err := api.PostGeneric(reqURL, nil)
+	if err != nil {
+		return console.Session{}, fmt.Errorf("error api request - %w - %s", err, string(raw))
+	}
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return console.Session{}, fmt.Errorf("can not parse body - %w", err)
+	}
+	return r.Session, nil
+}
+
+// GetConsole to retrieve a console session, which also keeps it alive
+func (api *OsctrlAPI) GetConsole(env string, session uint) (console.Session, error) {
+	var s console.Session
+	raw, err := api.GetGeneric(api.consoleSessionURL(env, session), nil)
+	if err != nil {
+		return s, fmt.Errorf("error api request - %w - %s", err, string(raw))
+	}
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return s, fmt.Errorf("can not parse body - %w", err)
+	}
+	return s, nil
+}
+
+// CloseConsole to close a console session
+func (api *OsctrlAPI) CloseConsole(env string, session uint) error {
+	raw, err := api.ReqGeneric(http.MethodDelete, api.consoleSessionURL(env, session), nil)
+	if err != nil {
+		return fmt.Errorf("error api request - %w - %s", err, string(raw))
+	}
+	return nil
+}
+
+// RunConsoleCommand to send a command to a console session
+func (api *OsctrlAPI) RunConsoleCommand(env string, session uint, input string, osqueryMode bool) (console.Command, console.ParsedCommand, error) {
+	var r consoleCommandResponse
+	jsonMessage, err := json.Marshal(map[string]any{"input": input, "osquery_mode": osqueryMode})
+	if err != nil {
+		return r.Command, r.Parsed, fmt.Errorf("error marshaling data - %w", err)
+	}
+	raw, err := api.PostGeneric(api.consoleSessionURL(env, session, "commands"), bytes.NewReader(jsonMessage))
+	if err != nil {
+		return r.Command, r.Parsed, fmt.Errorf("error api request - %w - %s", err, string(raw))
+	}
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return r.Command, r.Parsed, fmt.Errorf("can not parse body - %w", err)
+	}
+	return r.Command, r.Parsed, nil
+}
+
+// GetConsoleCommand to retrieve the status of a console command
+func (api *OsctrlAPI) GetConsoleCommand(env string, session, command uint) (console.Command, error) {
+	var c console.Command
+	raw, err := api.GetGeneric(api.consoleSessionURL(env, session, "commands", strconv.FormatUint(uint64(command), 10)), nil)
+	if err != nil {
+		return c, fmt.Errorf("error api request - %w - %s", err, string(raw))
+	}
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return c, fmt.Errorf("can not parse body - %w", err)
+	}
+	return c, nil
+}
+
+// GetConsoleResults to retrieve the result rows of a console command
+func (api *OsctrlAPI) GetConsoleResults(env string, session, command uint) ([]map[string]any, error) {
+	var rows []map[string]any
+	raw, err := api.GetGeneric(api.consoleSessionURL(env, session, "commands", strconv.FormatUint(uint64(command), 10), "results"), nil)
+	if err != nil {
+		return rows, fmt.Errorf("error api request - %w - %s", err, string(raw))
+	}
+	if err := json.Unmarshal(raw, &rows); err != nil {
+		return rows, fmt.Errorf("can not parse body - %w", err)
+	}
+	return rows, nil
+}
+
+// CompleteConsole to retrieve the completions for the input of a console
+// session up to the cursor
+func (api *OsctrlAPI) CompleteConsole(env string, session uint, input string, osqueryMode bool) (console.Completion, error) {
+	var c console.Completion
+	reqURL := fmt.Sprintf("%s?input=%s&osquery_mode=%t", api.consoleSessionURL(env, session, "complete"), url.QueryEscape(input), osqueryMode)
+	raw, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return c, fmt.Errorf("error api request - %w - %s", err, string(raw))
+	}
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return c, fmt.Errorf("can not parse body - %w", err)
+	}
+	return c, nil
+}
+
+// ExportTranscript to retrieve the signed transcript of a console session,
+// in json or cast format
+func (api *OsctrlAPI) ExportTranscript(env string, session uint, format string) ([]byte, error) {
+	reqURL := fmt.Sprintf("%s?format=%s", api.consoleSessionURL(env, session, "transcript"), url.QueryEscape(format))
+	raw, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error api request - %w - %s", err, string(raw))
+	}
+	return raw, nil
+}
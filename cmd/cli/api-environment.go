@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"path"
 
 	"github.com/jmpsec/osctrl/pkg/environments"
@@ -106,3 +109,318 @@ func (api *OsctrlAPI) ActionEnrollmentRemove(identifier, action, target string,
 	}
 	return res.Message, nil
 }
+
+// GetRevisions to retrieve the configuration revisions of an environment from osctrl
+func (api *OsctrlAPI) GetRevisions(identifier string) ([]environments.ConfigRevision, error) {
+	var revisions []environments.ConfigRevision
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APIEnvironments, "revisions", identifier))
+	rawR, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return revisions, fmt.Errorf("error api request - %w - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &revisions); err != nil {
+		return revisions, fmt.Errorf("can not parse body - %w", err)
+	}
+	return revisions, nil
+}
+
+// GetRevision to retrieve a configuration revision of an environment from
+// osctrl, the latest one when revision is zero
+func (api *OsctrlAPI) GetRevision(identifier string, revision uint) (environments.ConfigRevision, error) {
+	var rev environments.ConfigRevision
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APIEnvironments, "revision", identifier))
+	if revision > 0 {
+		reqURL += "?" + url.Values{"revision": {fmt.Sprint(revision)}}.Encode()
+	}
+	rawR, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return rev, fmt.Errorf("error api request - %w - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &rev); err != nil {
+		return rev, fmt.Errorf("can not parse body - %w", err)
+	}
+	return rev, nil
+}
+
+// DiffRevisions to retrieve the diff between two configuration revisions of
+// an environment from osctrl, to the latest one when to is zero
+func (api *OsctrlAPI) DiffRevisions(identifier string, from, to uint) (types.EnvRevisionDiffResponse, error) {
+	var diff types.EnvRevisionDiffResponse
+	params := url.Values{"from": {fmt.Sprint(from)}}
+	if to > 0 {
+		params.Set("to", fmt.Sprint(to))
+	}
+	reqURL := fmt.Sprintf("%s%s?%s", api.Configuration.URL, path.Join(APIPath, APIEnvironments, "diff", identifier), params.Encode())
+	rawD, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return diff, fmt.Errorf("error api request - %w - %s", err, string(rawD))
+	}
+	if err := json.Unmarshal(rawD, &diff); err != nil {
+		return diff, fmt.Errorf("can not parse body - %w", err)
+	}
+	return diff, nil
+}
+
+// RollbackConfiguration to roll back the configuration of an environment to a revision in osctrl
+func (api *OsctrlAPI) RollbackConfiguration(identifier string, revision uint, reason string) (environments.ConfigRevision, error) {
+	var rev environments.ConfigRevision
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APIEnvironments, "rollback", identifier))
+	jsonMessage, err := json.Marshal(types.EnvRollbackRequest{Revision: revision, Reason: reason})
+	if err != nil {
+		return rev, fmt.Errorf("error marshaling data - %w", err)
+	}
+	rawR, err := api.PostGeneric(reqURL, bytes.NewReader(jsonMessage))
+	if err != nil {
+		return rev, fmt.Errorf("error api request - %w - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &rev); err != nil {
+		return rev, fmt.Errorf("can not parse body - %w", err)
+	}
+	return rev, nil
+}
+
+// GetRollout to retrieve the current configuration rollout of an environment with its health
+func (api *OsctrlAPI) GetRollout(identifier string) (environments.RolloutReport, error) {
+	var report environments.RolloutReport
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APIEnvironments, "rollout", identifier))
+	rawR, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return report, fmt.Errorf("error api request - %w - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &report); err != nil {
+		return report, fmt.Errorf("can not parse body - %w", err)
+	}
+	return report, nil
+}
+
+// GetRollouts to retrieve the configuration rollouts of an environment
+func (api *OsctrlAPI) GetRollouts(identifier string) ([]environments.ConfigRollout, error) {
+	var rollouts []environments.ConfigRollout
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APIEnvironments, "rollouts", identifier))
+	rawR, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return rollouts, fmt.Errorf("error api request - %w - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &rollouts); err != nil {
+		return rollouts, fmt.Errorf("can not parse body - %w", err)
+	}
+	return rollouts, nil
+}
+
+// RolloutAction to start, halt, resume, promote or abort the configuration rollout of an environment
+func (api *OsctrlAPI) RolloutAction(identifier string, req types.EnvRolloutRequest) (environments.ConfigRollout, error) {
+	var rollout environments.ConfigRollout
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APIEnvironments, "rollout", identifier))
+	jsonMessage, err := json.Marshal(req)
+	if err != nil {
+		return rollout, fmt.Errorf("error marshaling data - %w", err)
+	}
+	rawR, err := api.PostGeneric(reqURL, bytes.NewReader(jsonMessage))
+	if err != nil {
+		return rollout, fmt.Errorf("error api request - %w - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &rollout); err != nil {
+		return rollout, fmt.Errorf("can not parse body - %w", err)
+	}
+	return rollout, nil
+}
+
+// CreateEnvironment to create a new environment in osctrl
+func (api *OsctrlAPI) CreateEnvironment(req types.EnvCreateRequest) (environments.TLSEnvironment, error) {
+	var e environments.TLSEnvironment
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APIEnvironments))
+	jsonMessage, err := json.Marshal(req)
+	if err != nil {
+		return e, fmt.Errorf("error marshaling data - %w", err)
+	}
+	rawE, err := api.PostGeneric(reqURL, bytes.NewReader(jsonMessage))
+	if err != nil {
+		return e, fmt.Errorf("error api request - %w - %s", err, string(rawE))
+	}
+	if err := json.Unmarshal(rawE, &e); err != nil {
+		return e, fmt.Errorf("can not parse body - %w", err)
+	}
+	return e, nil
+}
+
+// UpdateEnvironment to update the settings of an environment in osctrl
+func (api *OsctrlAPI) UpdateEnvironment(identifier string, req types.EnvUpdateRequest) (environments.TLSEnvironment, error) {
+	var e environments.TLSEnvironment
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APIEnvironments, identifier))
+	jsonMessage, err := json.Marshal(req)
+	if err != nil {
+		return e, fmt.Errorf("error marshaling data - %w", err)
+	}
+	rawE, err := api.PatchGeneric(reqURL, bytes.NewReader(jsonMessage))
+	if err != nil {
+		return e, fmt.Errorf("error api request - %w - %s", err, string(rawE))
+	}
+	if err := json.Unmarshal(rawE, &e); err != nil {
+		return e, fmt.Errorf("can not parse body - %w", err)
+	}
+	return e, nil
+}
+
+// UpdateEnvironmentConfig to update the configuration sections and flags of an environment in osctrl
+func (api *OsctrlAPI) UpdateEnvironmentConfig(identifier string, req types.EnvConfigPatchRequest) (types.EnvConfigResponse, error) {
+	var resp types.EnvConfigResponse
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APIEnvironments, "config", identifier))
+	jsonMessage, err := json.Marshal(req)
+	if err != nil {
+		return resp, fmt.Errorf("error marshaling data - %w", err)
+	}
+	rawR, err := api.PatchGeneric(reqURL, bytes.NewReader(jsonMessage))
+	if err != nil {
+		return resp, fmt.Errorf("error api request - %w - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &resp); err != nil {
+		return resp, fmt.Errorf("can not parse body - %w", err)
+	}
+	return resp, nil
+}
+
+// UpdateEnvironmentIntervals to update the intervals of an environment in osctrl
+func (api *OsctrlAPI) UpdateEnvironmentIntervals(identifier string, req types.EnvIntervalsPatchRequest) (environments.TLSEnvironment, error) {
+	var e environments.TLSEnvironment
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APIEnvironments, "intervals", identifier))
+	jsonMessage, err := json.Marshal(req)
+	if err != nil {
+		return e, fmt.Errorf("error marshaling data - %w", err)
+	}
+	rawE, err := api.PatchGeneric(reqURL, bytes.NewReader(jsonMessage))
+	if err != nil {
+		return e, fmt.Errorf("error api request - %w - %s", err, string(rawE))
+	}
+	if err := json.Unmarshal(rawE, &e); err != nil {
+		return e, fmt.Errorf("can not parse body - %w", err)
+	}
+	return e, nil
+}
+
+// CloneEnvironment to create an environment in osctrl from an existing one
+func (api *OsctrlAPI) CloneEnvironment(identifier string, req types.EnvCloneRequest) (types.EnvCloneResponse, error) {
+	var resp types.EnvCloneResponse
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APIEnvironments, "clone", identifier))
+	jsonMessage, err := json.Marshal(req)
+	if err != nil {
+		return resp, fmt.Errorf("error marshaling data - %w", err)
+	}
+	rawR, err := api.PostGeneric(reqURL, bytes.NewReader(jsonMessage))
+	if err != nil {
+		return resp, fmt.Errorf("error api request - %w - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &resp); err != nil {
+		return resp, fmt.Errorf("can not parse body - %w", err)
+	}
+	return resp, nil
+}
+
+// GetEnrollTokens to retrieve the enroll tokens of an environment
+func (api *OsctrlAPI) GetEnrollTokens(identifier string) ([]environments.EnrollToken, error) {
+	var tokens []environments.EnrollToken
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APIEnvironments, "tokens", identifier))
+	rawT, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return tokens, fmt.Errorf("error api request - %w - %s", err, string(rawT))
+	}
+	if err := json.Unmarshal(rawT, &tokens); err != nil {
+		return tokens, fmt.Errorf("can not parse body - %w", err)
+	}
+	return tokens, nil
+}
+
+// CreateEnrollToken to create an enroll token of an environment
+func (api *OsctrlAPI) CreateEnrollToken(identifier string, req types.EnvEnrollTokenRequest) (types.EnvEnrollTokenResponse, error) {
+	var resp types.EnvEnrollTokenResponse
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APIEnvironments, "tokens", identifier))
+	jsonMessage, err := json.Marshal(req)
+	if err != nil {
+		return resp, fmt.Errorf("error marshaling data - %w", err)
+	}
+	rawT, err := api.PostGeneric(reqURL, bytes.NewReader(jsonMessage))
+	if err != nil {
+		return resp, fmt.Errorf("error api request - %w - %s", err, string(rawT))
+	}
+	if err := json.Unmarshal(rawT, &resp); err != nil {
+		return resp, fmt.Errorf("can not parse body - %w", err)
+	}
+	return resp, nil
+}
+
+// DeleteEnrollToken to delete an enroll token of an environment
+func (api *OsctrlAPI) DeleteEnrollToken(identifier, name string) error {
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APIEnvironments, "tokens", identifier, name))
+	rawT, err := api.ReqGeneric(http.MethodDelete, reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("error api request - %w - %s", err, string(rawT))
+	}
+	return nil
+}
+
+// GetClientCerts to retrieve the client certificates of an environment
+func (api *OsctrlAPI) GetClientCerts(identifier string) ([]environments.ClientCertificate, error) {
+	var certs []environments.ClientCertificate
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APIEnvironments, "certs", identifier))
+	rawC, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return certs, fmt.Errorf("error api request - %w - %s", err, string(rawC))
+	}
+	if err := json.Unmarshal(rawC, &certs); err != nil {
+		return certs, fmt.Errorf("can not parse body - %w", err)
+	}
+	return certs, nil
+}
+
+// IssueClientCert to issue a client certificate of an environment
+func (api *OsctrlAPI) IssueClientCert(identifier string, req types.EnvClientCertRequest) (types.EnvClientCertResponse, error) {
+	var resp types.EnvClientCertResponse
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APIEnvironments, "certs", identifier))
+	jsonMessage, err := json.Marshal(req)
+	if err != nil {
+		return resp, fmt.Errorf("error marshaling data - %w", err)
+	}
+	rawC, err := api.PostGeneric(reqURL, bytes.NewReader(jsonMessage))
+	if err != nil {
+		return resp, fmt.Errorf("error api request - %w - %s", err, string(rawC))
+	}
+	if err := json.Unmarshal(rawC, &resp); err != nil {
+		return resp, fmt.Errorf("can not parse body - %w", err)
+	}
+	return resp, nil
+}
+
+// RevokeClientCert to revoke a client certificate of an environment
+func (api *OsctrlAPI) RevokeClientCert(identifier, serial, reason string) error {
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APIEnvironments, "certs", identifier, serial))
+	if reason != "" {
+		reqURL += "?reason=" + url.QueryEscape(reason)
+	}
+	rawC, err := api.ReqGeneric(http.MethodDelete, reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("error api request - %w - %s", err, string(rawC))
+	}
+	return nil
+}
+
+// GetClientCA to retrieve the PEM certificate of the client CA of an environment
+func (api *OsctrlAPI) GetClientCA(identifier string) (string, error) {
+	return api.getClientCAData(path.Join(APIPath, APIEnvironments, "certs", "ca", identifier))
+}
+
+// GetClientCRL to retrieve the PEM revocation list of the client CA of an environment
+func (api *OsctrlAPI) GetClientCRL(identifier string) (string, error) {
+	return api.getClientCAData(path.Join(APIPath, APIEnvironments, "certs", "crl", identifier))
+}
+
+func (api *OsctrlAPI) getClientCAData(apiPath string) (string, error) {
+	var resp types.ApiDataResponse
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, apiPath)
+	rawC, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("error api request - %w - %s", err, string(rawC))
+	}
+	if err := json.Unmarshal(rawC, &resp); err != nil {
+		return "", fmt.Errorf("can not parse body - %w", err)
+	}
+	return resp.Data, nil
+}
@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
 	"path"
+	"strconv"
 
+	"github.com/jmpsec/osctrl/pkg/environments"
 	"github.com/jmpsec/osctrl/pkg/nodes"
 	"github.com/jmpsec/osctrl/pkg/types"
 )
@@ -106,3 +110,291 @@ func (api *OsctrlAPI) LookupNode(identifier string) (nodes.OsqueryNode, error) {
 	}
 	return node, nil
 }
+
+// GetNodeAttributes to retrieve the custom attributes of a node from osctrl
+func (api *OsctrlAPI) GetNodeAttributes(env, identifier string) ([]nodes.NodeAttribute, error) {
+	var attrs []nodes.NodeAttribute
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APINodes, env, "node", identifier, "attributes"))
+	rawAttrs, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return attrs, fmt.Errorf("error api request - %w - %s", err, string(rawAttrs))
+	}
+	if err := json.Unmarshal(rawAttrs, &attrs); err != nil {
+		return attrs, fmt.Errorf("can not parse body - %w", err)
+	}
+	return attrs, nil
+}
+
+// SetNodeAttribute to set a custom attribute of a node in osctrl
+func (api *OsctrlAPI) SetNodeAttribute(env, identifier, name, attrType, value string) error {
+	a := types.ApiNodeAttributeRequest{
+		Name:  name,
+		Type:  attrType,
+		Value: value,
+	}
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APINodes, env, "node", identifier, "attributes"))
+	jsonMessage, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("error marshaling data - %w", err)
+	}
+	rawA, err := api.ReqGeneric(http.MethodPut, reqURL, bytes.NewReader(jsonMessage))
+	if err != nil {
+		return fmt.Errorf("error api request - %w - %s", err, string(rawA))
+	}
+	return nil
+}
+
+// DeleteNodeAttribute to remove a custom attribute of a node in osctrl
+func (api *OsctrlAPI) DeleteNodeAttribute(env, identifier, name string) error {
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APINodes, env, "node", identifier, "attributes", name))
+	rawA, err := api.ReqGeneric(http.MethodDelete, reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("error api request - %w - %s", err, string(rawA))
+	}
+	return nil
+}
+
+// ImportNodeAttributes to bulk import custom attributes of nodes in osctrl
+func (api *OsctrlAPI) ImportNodeAttributes(env string, records []nodes.AttributeRecord) (nodes.AttributeImportResult, error) {
+	var r nodes.AttributeImportResult
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APINodes, env, "attributes", "import"))
+	jsonMessage, err := json.Marshal(records)
+	if err != nil {
+		return r, fmt.Errorf("error marshaling data - %w", err)
+	}
+	rawR, err := api.PostGeneric(reqURL, bytes.NewReader(jsonMessage))
+	if err != nil {
+		return r, fmt.Errorf("error api request - %w - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %w", err)
+	}
+	return r, nil
+}
+
+// MoveNodes to reassign nodes to another environment in osctrl
+func (api *OsctrlAPI) MoveNodes(env string, uuids []string, target string) ([]nodes.NodeMove, error) {
+	var moves []nodes.NodeMove
+	m := types.ApiNodeMoveRequest{
+		UUIDs:       uuids,
+		Environment: target,
+	}
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APINodes, env, "move"))
+	jsonMessage, err := json.Marshal(m)
+	if err != nil {
+		return moves, fmt.Errorf("error marshaling data - %w", err)
+	}
+	rawMoves, err := api.PostGeneric(reqURL, bytes.NewReader(jsonMessage))
+	if err != nil {
+		return moves, fmt.Errorf("error api request - %w - %s", err, string(rawMoves))
+	}
+	if err := json.Unmarshal(rawMoves, &moves); err != nil {
+		return moves, fmt.Errorf("can not parse body - %w", err)
+	}
+	return moves, nil
+}
+
+// RevokeNodes to revoke the node keys of nodes in osctrl
+func (api *OsctrlAPI) RevokeNodes(env string, req types.ApiNodeRevokeRequest) (types.ApiNodeRevokeResponse, error) {
+	var r types.ApiNodeRevokeResponse
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APINodes, env, "revoke"))
+	jsonMessage, err := json.Marshal(req)
+	if err != nil {
+		return r, fmt.Errorf("error marshaling data - %w", err)
+	}
+	rawR, err := api.PostGeneric(reqURL, bytes.NewReader(jsonMessage))
+	if err != nil {
+		return r, fmt.Errorf("error api request - %w - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %w", err)
+	}
+	return r, nil
+}
+
+// QuarantineNode to put a node in quarantine in osctrl
+func (api *OsctrlAPI) QuarantineNode(env, identifier, reason string) error {
+	q := types.ApiNodeQuarantineRequest{
+		Reason: reason,
+	}
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APINodes, env, "node", identifier, "quarantine"))
+	jsonMessage, err := json.Marshal(q)
+	if err != nil {
+		return fmt.Errorf("error marshaling data - %w", err)
+	}
+	rawQ, err := api.PostGeneric(reqURL, bytes.NewReader(jsonMessage))
+	if err != nil {
+		return fmt.Errorf("error api request - %w - %s", err, string(rawQ))
+	}
+	return nil
+}
+
+// ReleaseNode to release a node from quarantine in osctrl
+func (api *OsctrlAPI) ReleaseNode(env, identifier string) error {
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APINodes, env, "node", identifier, "quarantine"))
+	rawQ, err := api.ReqGeneric(http.MethodDelete, reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("error api request - %w - %s", err, string(rawQ))
+	}
+	return nil
+}
+
+// GetQuarantinedNodes to retrieve the quarantined nodes of an environment from osctrl
+func (api *OsctrlAPI) GetQuarantinedNodes(env string) ([]nodes.QuarantinedNode, error) {
+	var quarantined []nodes.QuarantinedNode
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APINodes, env, "quarantine"))
+	rawQ, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return quarantined, fmt.Errorf("error api request - %w - %s", err, string(rawQ))
+	}
+	if err := json.Unmarshal(rawQ, &quarantined); err != nil {
+		return quarantined, fmt.Errorf("can not parse body - %w", err)
+	}
+	return quarantined, nil
+}
+
+// GetApprovalNodes to retrieve the nodes pending approval, or rejected, of an environment from osctrl
+func (api *OsctrlAPI) GetApprovalNodes(env, status string) ([]nodes.OsqueryNode, error) {
+	var result []nodes.OsqueryNode
+	reqURL := fmt.Sprintf("%s%s?status=%s", api.Configuration.URL, path.Join(APIPath, APINodes, env, "approvals"), url.QueryEscape(status))
+	rawN, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return result, fmt.Errorf("error api request - %w - %s", err, string(rawN))
+	}
+	if err := json.Unmarshal(rawN, &result); err != nil {
+		return result, fmt.Errorf("can not parse body - %w", err)
+	}
+	return result, nil
+}
+
+// ApproveNode to approve a node pending approval in osctrl
+func (api *OsctrlAPI) ApproveNode(env, identifier string) error {
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APINodes, env, "node", identifier, "approve"))
+	rawN, err := api.PostGeneric(reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("error api request - %w - %s", err, string(rawN))
+	}
+	return nil
+}
+
+// RejectNode to reject a node pending approval in osctrl
+func (api *OsctrlAPI) RejectNode(env, identifier string) error {
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APINodes, env, "node", identifier, "reject"))
+	rawN, err := api.PostGeneric(reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("error api request - %w - %s", err, string(rawN))
+	}
+	return nil
+}
+
+// GetApprovalRules to retrieve the approval rules of an environment from osctrl
+func (api *OsctrlAPI) GetApprovalRules(env string) ([]nodes.ApprovalRule, error) {
+	var rules []nodes.ApprovalRule
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APINodes, env, "approvals", "rules"))
+	rawR, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return rules, fmt.Errorf("error api request - %w - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &rules); err != nil {
+		return rules, fmt.Errorf("can not parse body - %w", err)
+	}
+	return rules, nil
+}
+
+// CreateApprovalRule to create an approval rule of an environment in osctrl
+func (api *OsctrlAPI) CreateApprovalRule(env, ruleType, pattern string) (types.ApiApprovalRuleResponse, error) {
+	var r types.ApiApprovalRuleResponse
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APINodes, env, "approvals", "rules"))
+	jsonMessage, err := json.Marshal(types.ApiApprovalRuleRequest{Type: ruleType, Pattern: pattern})
+	if err != nil {
+		return r, fmt.Errorf("error marshaling data - %w", err)
+	}
+	rawR, err := api.PostGeneric(reqURL, bytes.NewReader(jsonMessage))
+	if err != nil {
+		return r, fmt.Errorf("error api request - %w - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %w", err)
+	}
+	return r, nil
+}
+
+// DeleteApprovalRule to delete an approval rule of an environment in osctrl
+func (api *OsctrlAPI) DeleteApprovalRule(env string, id uint) error {
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APINodes, env, "approvals", "rules", strconv.FormatUint(uint64(id), 10)))
+	rawR, err := api.ReqGeneric(http.MethodDelete, reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("error api request - %w - %s", err, string(rawR))
+	}
+	return nil
+}
+
+// GetConfigOverlays to retrieve the configuration overlays of an environment from osctrl
+func (api *OsctrlAPI) GetConfigOverlays(env string) ([]environments.ConfigOverlay, error) {
+	var overlays []environments.ConfigOverlay
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APINodes, env, "overlays"))
+	rawO, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return overlays, fmt.Errorf("error api request - %w - %s", err, string(rawO))
+	}
+	if err := json.Unmarshal(rawO, &overlays); err != nil {
+		return overlays, fmt.Errorf("can not parse body - %w", err)
+	}
+	return overlays, nil
+}
+
+// SetConfigOverlay to create or replace a configuration overlay in osctrl
+func (api *OsctrlAPI) SetConfigOverlay(env, name string, o types.ApiConfigOverlayRequest) (types.ApiConfigOverlayResponse, error) {
+	var resp types.ApiConfigOverlayResponse
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APINodes, env, "overlays", name))
+	jsonMessage, err := json.Marshal(o)
+	if err != nil {
+		return resp, fmt.Errorf("error marshaling data - %w", err)
+	}
+	rawO, err := api.ReqGeneric(http.MethodPut, reqURL, bytes.NewReader(jsonMessage))
+	if err != nil {
+		return resp, fmt.Errorf("error api request - %w - %s", err, string(rawO))
+	}
+	if err := json.Unmarshal(rawO, &resp); err != nil {
+		return resp, fmt.Errorf("can not parse body - %w", err)
+	}
+	return resp, nil
+}
+
+// DeleteConfigOverlay to remove a configuration overlay in osctrl
+func (api *OsctrlAPI) DeleteConfigOverlay(env, name string) error {
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APINodes, env, "overlays", name))
+	rawO, err := api.ReqGeneric(http.MethodDelete, reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("error api request - %w - %s", err, string(rawO))
+	}
+	return nil
+}
+
+// GetNodeConfig to retrieve the configuration served to a node from osctrl
+func (api *OsctrlAPI) GetNodeConfig(env, identifier string) (types.ApiNodeConfigResponse, error) {
+	var conf types.ApiNodeConfigResponse
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APINodes, env, "node", identifier, "config"))
+	rawC, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return conf, fmt.Errorf("error api request - %w - %s", err, string(rawC))
+	}
+	if err := json.Unmarshal(rawC, &conf); err != nil {
+		return conf, fmt.Errorf("can not parse body - %w", err)
+	}
+	return conf, nil
+}
+
+// GetDriftedNodes to retrieve the nodes with configuration drift in an environment
+func (api *OsctrlAPI) GetDriftedNodes(env string) (nodes.DriftReport, error) {
+	var report nodes.DriftReport
+	reqURL := fmt.Sprintf("%s%s", api.Configuration.URL, path.Join(APIPath, APINodes, env, "drift"))
+	rawR, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return report, fmt.Errorf("error api request - %w - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &report); err != nil {
+		return report, fmt.Errorf("can not parse body - %w", err)
+	}
+	return report, nil
+}
@@ -35,6 +35,8 @@ const (
 	APILogin = "/login"
 	// APIAuditLogs for the audit logs path
 	APIAuditLogs = "/audit-logs"
+	// APIConsole for the node console path
+	APIConsole = "/console"
 	// APIChecksNoAuth for the unauthenticated checks path
 	APIChecksNoAuth = "/checks-no-auth"
 	// APIChecksAuth for the authenticated checks path
@@ -146,6 +148,11 @@ func (api *OsctrlAPI) PostGeneric(url string, body io.Reader) ([]byte, error) {
 	return api.ReqGeneric(http.MethodPost, url, body)
 }
 
+// PatchGeneric - Helper function to implement generic updates with the API with a PATCH request
+func (api *OsctrlAPI) PatchGeneric(url string, body io.Reader) ([]byte, error) {
+	return api.ReqGeneric(http.MethodPatch, url, body)
+}
+
 // ReqGeneric - Helper function to implement generic retrieval from API with a POST request
 func (api *OsctrlAPI) ReqGeneric(reqType string, url string, body io.Reader) ([]byte, error) {
 	req, err := http.NewRequest(reqType, url, body)
@@ -169,8 +176,8 @@ func (api *OsctrlAPI) ReqGeneric(reqType string, url string, body io.Reader) ([]
 	if err != nil {
 		return []byte{}, fmt.Errorf("can not read response - %w", err)
 	}
-	// Check response code
-	if resp.StatusCode != http.StatusOK {
+	// Check response code, creating resources answers with 201
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
 		return bodyBytes, fmt.Errorf("HTTP Code %d", resp.StatusCode)
 	}
 	return bodyBytes, nil
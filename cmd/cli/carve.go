@@ -5,6 +5,7 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"strings"
@@ -14,6 +15,7 @@ import (
 	"github.com/jmpsec/osctrl/pkg/handlers"
 	"github.com/jmpsec/osctrl/pkg/queries"
 	"github.com/jmpsec/osctrl/pkg/settings"
+	"github.com/jmpsec/osctrl/pkg/types"
 	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli/v3"
 )
@@ -348,6 +350,23 @@ func runCarve(ctx context.Context, cmd *cli.Command) error {
 	}
 	expHours := cmd.Int("expiration")
 	hidden := cmd.Bool("hidden")
+	var directory *types.CarveDirectoryRequest
+	carveQuery := carves.GenCarveQuery(path, false)
+	if cmd.Bool("recursive") {
+		directory = &types.CarveDirectoryRequest{
+			Root:         path,
+			MaxDepth:     cmd.Int("max-depth"),
+			Include:      splitCarveGlobs(cmd.String("include")),
+			Exclude:      splitCarveGlobs(cmd.String("exclude")),
+			MaxFileSize:  cmd.Int64("max-file-size"),
+			MaxTotalSize: cmd.Int64("max-total-size"),
+		}
+		dirQuery, err := carves.GenDirectoryCarveQuery(*directory)
+		if err != nil {
+			return fmt.Errorf("❌ invalid directory carve - %w", err)
+		}
+		carveQuery = dirQuery
+	}
 	cName := carves.GenCarveName()
 	if dbFlag {
 		e, err := envs.Get(env)
@@ -359,7 +378,7 @@ func runCarve(ctx context.Context, cmd *cli.Command) error {
 			expTime = time.Time{}
 		}
 		newQuery := queries.DistributedQuery{
-			Query:         carves.GenCarveQuery(path, false),
+			Query:         carveQuery,
 			Name:          cName,
 			Creator:       appName,
 			Active:        true,
@@ -403,7 +422,7 @@ func runCarve(ctx context.Context, cmd *cli.Command) error {
 		// Audit log
 		auditlogsmgr.NewCarve(getShellUsername(), path, "CLI", e.ID)
 	} else if apiFlag {
-		c, err := osctrlAPI.RunCarve(env, path, uuidList, hostList, platformList, tagList, hidden, expHours)
+		c, err := osctrlAPI.RunCarve(env, path, uuidList, hostList, platformList, tagList, hidden, expHours, directory)
 		if err != nil {
 			return fmt.Errorf("❌ error running carve - %w", err)
 		}
@@ -414,3 +433,136 @@ func runCarve(ctx context.Context, cmd *cli.Command) error {
 	}
 	return nil
 }
+
+// splitCarveGlobs splits a comma separated list of globs, dropping empty values
+func splitCarveGlobs(value string) []string {
+	var globs []string
+	for _, g := range strings.Split(value, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			globs = append(globs, g)
+		}
+	}
+	return globs
+}
+
+func rotateCarveKeys(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	var msg string
+	if dbFlag {
+		keyfile := cmd.String("keyfile")
+		if keyfile == "" {
+			fmt.Println("❌ keyfile is required")
+			os.Exit(1)
+		}
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("❌ error env get - %w", err)
+		}
+		keys, err := carves.LoadKeyRing(keyfile)
+		if err != nil {
+			return fmt.Errorf("❌ error loading keyfile - %w", err)
+		}
+		rotated, err := filecarves.WithEncryption(keys).RotateKeys(e.Name)
+		if err != nil {
+			return fmt.Errorf("❌ error rotating carve keys - %w", err)
+		}
+		msg = fmt.Sprintf("%d carve keys rotated in %s", rotated, e.Name)
+		// Audit log
+		auditlogsmgr.CarveAction(getShellUsername(), "rotate carve keys", "CLI", e.ID)
+	} else if apiFlag {
+		r, err := osctrlAPI.RotateCarveKeys(env)
+		if err != nil {
+			return fmt.Errorf("❌ error rotating carve keys - %w", err)
+		}
+		msg = r.Message
+	}
+	if !silentFlag {
+		fmt.Printf("✅ %s\n", msg)
+	}
+	return nil
+}
+
+func downloadCarve(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	name := cmd.String("name")
+	if name == "" {
+		fmt.Println("❌ carve name is required")
+		os.Exit(1)
+	}
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	session := cmd.String("session")
+	output := cmd.String("output")
+	if output == "" {
+		output = name + carves.TarFileExtension
+	}
+	var size int64
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("❌ error env get - %w", err)
+		}
+		if keyfile := cmd.String("keyfile"); keyfile != "" {
+			keys, err := carves.LoadKeyRing(keyfile)
+			if err != nil {
+				return fmt.Errorf("❌ error loading keyfile - %w", err)
+			}
+			filecarves.WithEncryption(keys)
+		}
+		files, err := filecarves.GetByQuery(name, e.ID)
+		if err != nil {
+			return fmt.Errorf("❌ error getting carve files - %w", err)
+		}
+		var selected *carves.CarvedFile
+		for i := range files {
+			if files[i].SessionID == session || (session == "" && len(files) == 1) {
+				selected = &files[i]
+				break
+			}
+		}
+		if selected == nil {
+			return fmt.Errorf("❌ carve has %d files, select one with --session", len(files))
+		}
+		tmpDir, err := os.MkdirTemp("", "osctrl-carve-archive-")
+		if err != nil {
+			return fmt.Errorf("❌ error preparing archive workspace - %w", err)
+		}
+		defer os.RemoveAll(tmpDir)
+		archived, err := filecarves.Archive(selected.SessionID, tmpDir)
+		if err != nil {
+			return fmt.Errorf("❌ error archiving carve - %w", err)
+		}
+		src, _, err := filecarves.OpenArchive(*selected, archived.File)
+		if err != nil {
+			return fmt.Errorf("❌ error opening archive - %w", err)
+		}
+		defer src.Close()
+		dst, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("❌ error creating %s - %w", output, err)
+		}
+		defer dst.Close()
+		if size, err = io.Copy(dst, src); err != nil {
+			return fmt.Errorf("❌ error writing %s - %w", output, err)
+		}
+		// Audit log
+		auditlogsmgr.CarveAction(getShellUsername(), "download "+name, "CLI", e.ID)
+	} else if apiFlag {
+		size, err = osctrlAPI.DownloadCarve(env, name, session, output)
+		if err != nil {
+			return fmt.Errorf("❌ error downloading carve - %w", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ carve %s downloaded to %s (%d bytes)\n", name, output, size)
+	}
+	return nil
+}
@@ -1,19 +1,25 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jmpsec/osctrl/pkg/config"
 	"github.com/jmpsec/osctrl/pkg/environments"
 	"github.com/jmpsec/osctrl/pkg/posture"
+	"github.com/jmpsec/osctrl/pkg/settings"
 	"github.com/jmpsec/osctrl/pkg/tags"
+	"github.com/jmpsec/osctrl/pkg/types"
 	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli/v3"
+	"gorm.io/gorm"
 )
 
 const (
@@ -106,6 +112,99 @@ func addEnvironment(ctx context.Context, cmd *cli.Command) error {
 	return nil
 }
 
+func cloneEnvironment(ctx context.Context, cmd *cli.Command) error {
+	// Get template environment name
+	fromName := cmd.String("from")
+	if fromName == "" {
+		fmt.Println("❌ template environment name is required")
+		os.Exit(1)
+	}
+	// Get environment name
+	envName := strings.ToLower(cmd.String("name"))
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	if !environments.EnvNameFilter(envName) {
+		fmt.Printf("❌ invalid environment name %s\n", envName)
+		os.Exit(1)
+	}
+	envHost := cmd.String("hostname")
+	if envHost != "" && !environments.HostnameFilter(envHost) {
+		fmt.Printf("❌ invalid hostname %s\n", envHost)
+		os.Exit(1)
+	}
+	var resp types.EnvCloneResponse
+	if dbFlag {
+		if envs.Exists(envName) {
+			fmt.Printf("❌ environment %s already exists!\n", envName)
+			os.Exit(1)
+		}
+		template, err := envs.Get(fromName)
+		if err != nil {
+			return err
+		}
+		resp = types.EnvCloneResponse{Template: template.Name}
+		copies := []environments.CloneCopy{
+			func(tx *gorm.DB, template, clone environments.TLSEnvironment) error {
+				var err error
+				resp.Users, err = adminUsers.CopyEnvPermissionsTx(tx, template, clone, getShellUsername())
+				return err
+			},
+			// Create a tag for this new environment
+			func(tx *gorm.DB, template, clone environments.TLSEnvironment) error {
+				return tagsmgr.NewTagTx(
+					tx,
+					clone.Name,
+					"Tag for environment "+clone.Name,
+					tags.RandomColor(),
+					clone.Icon,
+					appName,
+					clone.ID,
+					false,
+					tags.TagTypeEnv,
+					tags.TagCustomEnv)
+			},
+		}
+		if cmd.Bool("tags") {
+			copies = append(copies, func(tx *gorm.DB, template, clone environments.TLSEnvironment) error {
+				var err error
+				resp.Tags, err = tagsmgr.CopyTagsTx(tx, template.ID, clone.ID, getShellUsername())
+				return err
+			})
+		}
+		if cmd.Bool("saved-queries") {
+			copies = append(copies, func(tx *gorm.DB, template, clone environments.TLSEnvironment) error {
+				var err error
+				resp.SavedQueries, err = queriesmgr.CopySavedTx(tx, template.ID, clone.ID)
+				return err
+			})
+		}
+		clone, err := envs.Clone(template.Name, envName, envHost, getShellUsername(), copies...)
+		if err != nil {
+			return err
+		}
+		resp.Name = clone.Name
+		resp.UUID = clone.UUID
+		// Audit log
+		auditlogsmgr.EnvAction(getShellUsername(), fmt.Sprintf("clone environment %s from %s", clone.Name, template.Name), "CLI", clone.ID)
+	} else if apiFlag {
+		resp, err = osctrlAPI.CloneEnvironment(fromName, types.EnvCloneRequest{
+			Name:         envName,
+			Hostname:     envHost,
+			Tags:         cmd.Bool("tags"),
+			SavedQueries: cmd.Bool("saved-queries"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ environment %s was cloned from %s with %d users, %d tags and %d saved queries\n", resp.Name, resp.Template, resp.Users, resp.Tags, resp.SavedQueries)
+	}
+	return nil
+}
+
 func updateEnvironment(ctx context.Context, cmd *cli.Command) error {
 	// Get environment name
 	envName := cmd.String("name")
@@ -167,6 +266,17 @@ func updateEnvironment(ctx context.Context, cmd *cli.Command) error {
 		if err := envs.Update(env); err != nil {
 			return err
 		}
+		if cmd.IsSet("require-approval") {
+			if err := envs.UpdateRequireApproval(envName, cmd.Bool("require-approval")); err != nil {
+				return err
+			}
+		}
+		if cmd.IsSet("client-cert-auth") {
+			if err := envs.UpdateClientCertAuth(envName, cmd.Bool("client-cert-auth")); err != nil {
+				return err
+			}
+			env.ClientCertAuth = cmd.Bool("client-cert-auth")
+		}
 		// Make sure flags are up to date
 		flags, err := envs.GenerateFlags(env, "", "", osqueryValues)
 		if err != nil {
@@ -385,13 +495,16 @@ func quickAddEnvironment(ctx context.Context, cmd *cli.Command) error {
 	var oneLiner string
 	switch cmd.String("target") {
 	case targetShell:
-		oneLiner, _ = environments.QuickAddOneLinerShell(insecure, env)
+		oneLiner, err = environments.QuickAddOneLinerShell(insecure, env)
 	case targetPowershell:
-		oneLiner, _ = environments.QuickAddOneLinerPowershell(insecure, env)
+		oneLiner, err = environments.QuickAddOneLinerPowershell(insecure, env)
 	default:
 		fmt.Printf("❌ invalid target! It can be %s or %s\n", targetShell, targetPowershell)
 		os.Exit(1)
 	}
+	if err != nil {
+		return err
+	}
 	fmt.Printf("%s\n", oneLiner)
 	return nil
 }
@@ -752,7 +865,7 @@ func addScheduledQuery(ctx context.Context, cmd *cli.Command) error {
 		Platform: cmd.String("platform"),
 		Version:  cmd.String("version"),
 	}
-	if err := envs.AddScheduleConfQuery(envName, queryName, qData); err != nil {
+	if err := envs.AddScheduleConfQuery(envName, queryName, qData, getShellUsername()); err != nil {
 		return err
 	}
 	fmt.Printf("✅ query %s was created successfully\n", queryName)
@@ -790,7 +903,7 @@ func addPostureQueries(ctx context.Context, cmd *cli.Command) error {
 		if err != nil {
 			return err
 		}
-		if err := envs.AddScheduleConfQueries(envName, schedule); err != nil {
+		if err := envs.AddScheduleConfQueries(envName, schedule, getShellUsername()); err != nil {
 			return err
 		}
 		auditlogsmgr.EnvAction(getShellUsername(), "add posture queries "+profileID+" to "+envName, "CLI", env.ID)
@@ -837,7 +950,7 @@ func removeScheduledQuery(ctx context.Context, cmd *cli.Command) error {
 		os.Exit(1)
 	}
 	// Remove query
-	if err := envs.RemoveScheduleConfQuery(envName, queryName); err != nil {
+	if err := envs.RemoveScheduleConfQuery(envName, queryName, getShellUsername()); err != nil {
 		return err
 	}
 	fmt.Printf("✅ query %s was removed successfully\n", queryName)
@@ -876,8 +989,25 @@ func addOsqueryOption(ctx context.Context, cmd *cli.Command) error {
 		fmt.Printf("❌ invalid type! It can be %s, %s or %s\n", optionTypeBool, optionTypeInt, optionTypeString)
 		os.Exit(1)
 	}
+	env, err := envs.Get(envName)
+	if err != nil {
+		return err
+	}
+	versions, err := nodesmgr.GetEnvOsqueryVersions(env.ID)
+	if err != nil {
+		return err
+	}
+	// Validate option against the known osquery flags
+	force := cmd.Bool("force")
+	validation := environments.ValidateOptions(environments.OptionsConf{option: optionValue}, versions)
+	if err := validation.Err(); err != nil && !force {
+		return err
+	}
+	for _, w := range validation.Messages(force) {
+		fmt.Printf("⚠️  %s\n", w)
+	}
 	// Add osquery option
-	if err := envs.AddOptionsConf(envName, option, optionValue); err != nil {
+	if err := envs.AddOptionsConf(envName, option, optionValue, getShellUsername()); err != nil {
 		return err
 	}
 	fmt.Printf("✅ option %s was added successfully\n", option)
@@ -898,7 +1028,7 @@ func removeOsqueryOption(ctx context.Context, cmd *cli.Command) error {
 		os.Exit(1)
 	}
 	// Remove osquery option
-	if err := envs.RemoveOptionsConf(envName, option); err != nil {
+	if err := envs.RemoveOptionsConf(envName, option, getShellUsername()); err != nil {
 		return err
 	}
 	fmt.Printf("✅ option %s was added successfully\n", option)
@@ -922,16 +1052,43 @@ func addNewPack(ctx context.Context, cmd *cli.Command) error {
 	pack := environments.PackEntry{
 		Platform: cmd.String("platform"),
 		Version:  cmd.String("version"),
-		Shard:    json.Number(strconv.Itoa(cmd.Int("shard"))),
+	}
+	if shard := cmd.Int("shard"); shard != 0 {
+		pack.Shard = json.Number(strconv.Itoa(shard))
+	}
+	// Validate pack as serialized, new packs start without queries
+	newPack := struct {
+		environments.PackEntry
+		Queries map[string]environments.ScheduleQuery `json:"queries"`
+	}{PackEntry: pack, Queries: map[string]environments.ScheduleQuery{}}
+	if err := validatePacksConf(environments.PacksConf{pName: newPack}, cmd.Bool("force")); err != nil {
+		return err
 	}
 	// Add pack to configuration
-	if err := envs.AddQueryPackConf(envName, pName, pack); err != nil {
+	if err := envs.AddQueryPackConf(envName, pName, pack, getShellUsername()); err != nil {
 		return err
 	}
 	fmt.Printf("✅ pack %s was added successfully\n", pName)
 	return nil
 }
 
+// validatePacksConf checks the packs as they are serialized in the
+// configuration and prints the warnings, failing on errors unless forced
+func validatePacksConf(packs environments.PacksConf, force bool) error {
+	serialized, err := json.Marshal(packs)
+	if err != nil {
+		return err
+	}
+	validation := environments.ValidateConfigParts("", string(serialized), "", nil)
+	if err := validation.Err(); err != nil && !force {
+		return err
+	}
+	for _, w := range validation.Messages(force) {
+		fmt.Printf("⚠️  %s\n", w)
+	}
+	return nil
+}
+
 func removePack(ctx context.Context, cmd *cli.Command) error {
 	// Get environment name
 	envName := cmd.String("name")
@@ -946,7 +1103,7 @@ func removePack(ctx context.Context, cmd *cli.Command) error {
 		os.Exit(1)
 	}
 	// Remove pack from configuration
-	if err := envs.RemoveQueryPackConf(envName, pName); err != nil {
+	if err := envs.RemoveQueryPackConf(envName, pName, getShellUsername()); err != nil {
 		return err
 	}
 	fmt.Printf("✅ pack %s was added successfully\n", pName)
@@ -972,8 +1129,12 @@ func addLocalPack(ctx context.Context, cmd *cli.Command) error {
 		fmt.Println("❌ pack path is required")
 		os.Exit(1)
 	}
+	// Validate local pack
+	if err := validatePacksConf(environments.PacksConf{pName: pPath}, cmd.Bool("force")); err != nil {
+		return err
+	}
 	// Add pack to configuration option
-	if err := envs.AddQueryPackConf(envName, pName, pPath); err != nil {
+	if err := envs.AddQueryPackConf(envName, pName, pPath, getShellUsername()); err != nil {
 		return err
 	}
 	fmt.Printf("✅ pack %s was added successfully\n", pName)
@@ -1018,7 +1179,35 @@ func addPackQuery(ctx context.Context, cmd *cli.Command) error {
 		Platform: cmd.String("platform"),
 		Version:  cmd.String("version"),
 	}
-	if err := envs.AddQueryToPackConf(envName, packName, queryName, qData); err != nil {
+	// Validate the pack with the new query
+	env, err := envs.Get(envName)
+	if err != nil {
+		return err
+	}
+	packs, err := envs.GenStructPacks([]byte(env.Packs))
+	if err != nil {
+		return err
+	}
+	current, ok := packs[packName].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("pack %s not found or not a pack with queries", packName)
+	}
+	pack := map[string]interface{}{}
+	for k, v := range current {
+		pack[k] = v
+	}
+	queries := map[string]interface{}{}
+	if q, ok := current["queries"].(map[string]interface{}); ok {
+		for k, v := range q {
+			queries[k] = v
+		}
+	}
+	queries[queryName] = qData
+	pack["queries"] = queries
+	if err := validatePacksConf(environments.PacksConf{packName: pack}, cmd.Bool("force")); err != nil {
+		return err
+	}
+	if err := envs.AddQueryToPackConf(envName, packName, queryName, qData, getShellUsername()); err != nil {
 		return err
 	}
 	fmt.Printf("✅ query %s was added to pack %s successfully\n", queryName, packName)
@@ -1045,9 +1234,1194 @@ func removePackQuery(ctx context.Context, cmd *cli.Command) error {
 		os.Exit(1)
 	}
 	// Remove query
-	if err := envs.RemoveQueryFromPackConf(envName, packName, queryName); err != nil {
+	if err := envs.RemoveQueryFromPackConf(envName, packName, queryName, getShellUsername()); err != nil {
 		return err
 	}
 	fmt.Printf("✅ query %s was removed from pack %s successfully\n", queryName, packName)
 	return nil
 }
+
+func listRevisions(ctx context.Context, cmd *cli.Command) error {
+	// Get environment name
+	envName := cmd.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	var revisions []environments.ConfigRevision
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		revisions, err = envs.Revisions(env.ID)
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		revisions, err = osctrlAPI.GetRevisions(envName)
+		if err != nil {
+			return err
+		}
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header("Revision", "Created", "Author", "Reason", "Rollback Of", "Hash")
+	if len(revisions) > 0 {
+		data := [][]string{}
+		for _, rev := range revisions {
+			rollbackOf := ""
+			if rev.RollbackOf > 0 {
+				rollbackOf = strconv.FormatUint(uint64(rev.RollbackOf), 10)
+			}
+			data = append(data, []string{
+				strconv.FormatUint(uint64(rev.Revision), 10),
+				rev.CreatedAt.Format(time.RFC3339),
+				rev.Author,
+				rev.Reason,
+				rollbackOf,
+				rev.Hash[:12],
+			})
+		}
+		if err := table.Bulk(data); err != nil {
+			return fmt.Errorf("❌ error bulk table - %w", err)
+		}
+		if err := table.Render(); err != nil {
+			return fmt.Errorf("❌ error rendering table - %w", err)
+		}
+	} else {
+		fmt.Printf("No revisions for %s\n", envName)
+	}
+	return nil
+}
+
+func showRevision(ctx context.Context, cmd *cli.Command) error {
+	// Get environment name
+	envName := cmd.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	revision := uint(cmd.Int("revision"))
+	var rev environments.ConfigRevision
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		if revision > 0 {
+			rev, err = envs.GetRevision(env.ID, revision)
+		} else {
+			rev, err = envs.LatestRevision(env.ID)
+		}
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		rev, err = osctrlAPI.GetRevision(envName, revision)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf(" Revision: %d\n", rev.Revision)
+	fmt.Printf(" Created: %s\n", rev.CreatedAt.Format(time.RFC3339))
+	fmt.Printf(" Author: %s\n", rev.Author)
+	fmt.Printf(" Reason: %s\n", rev.Reason)
+	if rev.RollbackOf > 0 {
+		fmt.Printf(" Rollback Of: %d\n", rev.RollbackOf)
+	}
+	fmt.Printf(" Hash: %s\n", rev.Hash)
+	fmt.Println(" Configuration: ")
+	fmt.Printf("%s\n", rev.Configuration)
+	return nil
+}
+
+func diffRevisions(ctx context.Context, cmd *cli.Command) error {
+	// Get environment name
+	envName := cmd.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	from := uint(cmd.Int("from"))
+	if from == 0 {
+		fmt.Println("❌ revision to diff from is required")
+		os.Exit(1)
+	}
+	to := uint(cmd.Int("to"))
+	var diff string
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		fromRev, err := envs.GetRevision(env.ID, from)
+		if err != nil {
+			return fmt.Errorf("error getting revision %d - %w", from, err)
+		}
+		var toRev environments.ConfigRevision
+		if to > 0 {
+			toRev, err = envs.GetRevision(env.ID, to)
+		} else {
+			toRev, err = envs.LatestRevision(env.ID)
+		}
+		if err != nil {
+			return fmt.Errorf("error getting revision - %w", err)
+		}
+		diff, err = environments.DiffRevisions(fromRev, toRev)
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		resp, err := osctrlAPI.DiffRevisions(envName, from, to)
+		if err != nil {
+			return err
+		}
+		diff = resp.Diff
+	}
+	if diff == "" {
+		fmt.Println("No differences")
+		return nil
+	}
+	fmt.Print(diff)
+	return nil
+}
+
+func rollbackConfiguration(ctx context.Context, cmd *cli.Command) error {
+	// Get environment name
+	envName := cmd.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	revision := uint(cmd.Int("revision"))
+	if revision == 0 {
+		fmt.Println("❌ revision is required")
+		os.Exit(1)
+	}
+	reason := cmd.String("reason")
+	var rev environments.ConfigRevision
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		rev, err = envs.Rollback(envName, revision, getShellUsername(), reason)
+		if err != nil {
+			return err
+		}
+		// Audit log
+		auditlogsmgr.ConfAction(getShellUsername(), fmt.Sprintf("rolled back configuration of %s to revision %d (revision %d)", envName, revision, rev.Revision), "CLI", env.ID)
+	} else if apiFlag {
+		rev, err = osctrlAPI.RollbackConfiguration(envName, revision, reason)
+		if err != nil {
+			return err
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ configuration of %s rolled back to revision %d as revision %d\n", envName, revision, rev.Revision)
+	}
+	return nil
+}
+
+func rolloutRows(rollouts []environments.ConfigRollout) [][]string {
+	data := [][]string{}
+	for _, r := range rollouts {
+		data = append(data, []string{
+			strconv.FormatUint(uint64(r.ID), 10),
+			r.CreatedAt.Format(time.RFC3339),
+			fmt.Sprintf("%d → %d", r.FromRevision, r.ToRevision),
+			r.Status,
+			fmt.Sprintf("%d%%", r.Percent),
+			r.CanaryTag,
+			r.CreatedBy,
+			r.HaltReason,
+		})
+	}
+	return data
+}
+
+func showRollout(ctx context.Context, cmd *cli.Command) error {
+	// Get environment name
+	envName := cmd.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	var report environments.RolloutReport
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		rollouts, err := envs.Rollouts(env.ID)
+		if err != nil {
+			return err
+		}
+		if len(rollouts) == 0 {
+			fmt.Printf("No rollouts for %s\n", envName)
+			return nil
+		}
+		report = environments.NewRolloutReport(rollouts[0], environments.RolloutHealth{})
+	} else if apiFlag {
+		report, err = osctrlAPI.GetRollout(envName)
+		if err != nil {
+			return err
+		}
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header("ID", "Created", "Revisions", "Status", "Percent", "Canary Tag", "Created By", "Halt Reason")
+	if err := table.Bulk(rolloutRows([]environments.ConfigRollout{report.Rollout})); err != nil {
+		return fmt.Errorf("❌ error bulk table - %w", err)
+	}
+	if err := table.Render(); err != nil {
+		return fmt.Errorf("❌ error rendering table - %w", err)
+	}
+	if report.Rollout.Pending() && apiFlag {
+		fmt.Printf("Cohort: %d nodes, %d reporting, %d adopted (%.0f%%)\n", report.Health.CohortNodes, report.Health.ReportingNodes, report.Health.AdoptedNodes, report.Adoption*100)
+		fmt.Printf("Status log errors: %.1f%% of %d in the cohort, %.1f%% of %d in the rest\n", report.ErrorRate*100, report.Health.StatusLogs, report.BaselineErrorRate*100, report.Health.BaselineLogs)
+	}
+	return nil
+}
+
+func listRollouts(ctx context.Context, cmd *cli.Command) error {
+	// Get environment name
+	envName := cmd.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	var rollouts []environments.ConfigRollout
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		rollouts, err = envs.Rollouts(env.ID)
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		rollouts, err = osctrlAPI.GetRollouts(envName)
+		if err != nil {
+			return err
+		}
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header("ID", "Created", "Revisions", "Status", "Percent", "Canary Tag", "Created By", "Halt Reason")
+	if len(rollouts) > 0 {
+		if err := table.Bulk(rolloutRows(rollouts)); err != nil {
+			return fmt.Errorf("❌ error bulk table - %w", err)
+		}
+		if err := table.Render(); err != nil {
+			return fmt.Errorf("❌ error rendering table - %w", err)
+		}
+	} else {
+		fmt.Printf("No rollouts for %s\n", envName)
+	}
+	return nil
+}
+
+func startRollout(ctx context.Context, cmd *cli.Command) error {
+	// Get environment name
+	envName := cmd.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	confFile := cmd.String("conf")
+	if confFile == "" {
+		fmt.Println("❌ configuration file is required")
+		os.Exit(1)
+	}
+	raw, err := os.ReadFile(confFile)
+	if err != nil {
+		return fmt.Errorf("❌ error reading configuration - %w", err)
+	}
+	// The configuration is written and rolled out in one step, so nodes keep
+	// the current revision until the rollout exists
+	var cnf environments.OsqueryConf
+	if err := json.Unmarshal(raw, &cnf); err != nil {
+		return fmt.Errorf("❌ error parsing configuration - %w", err)
+	}
+	reason := cmd.String("reason")
+	force := cmd.Bool("force")
+	stages := types.EnvRolloutStages{
+		FromRevision: uint(cmd.Int("from")),
+		CanaryTag:    cmd.String("canary-tag"),
+		Percent:      int(cmd.Int("percent")),
+		StepPercent:  int(cmd.Int("step-percent")),
+		StepMinutes:  int(cmd.Int("step-minutes")),
+		MaxErrorRate: cmd.Float64("max-error-rate"),
+		MinAdoption:  cmd.Float64("min-adoption"),
+	}
+	var revision uint
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		versions, err := nodesmgr.GetEnvOsqueryVersions(env.ID)
+		if err != nil {
+			return err
+		}
+		validation := environments.ValidateOptions(cnf.Options, versions)
+		validation.Merge(environments.ValidatePacks(cnf.Packs))
+		validation.Merge(environments.ValidateATC(cnf.ATC))
+		if err := validation.Err(); err != nil && !force {
+			return err
+		}
+		if !silentFlag {
+			for _, w := range validation.Messages(force) {
+				fmt.Printf("⚠️  %s\n", w)
+			}
+		}
+		rev, rollout, err := envs.UpdateConfigurationRollout(envName, cnf, getShellUsername(), reason, environments.ConfigRollout{
+			FromRevision: stages.FromRevision,
+			CanaryTag:    stages.CanaryTag,
+			Percent:      stages.Percent,
+			StepPercent:  stages.StepPercent,
+			StepMinutes:  stages.StepMinutes,
+			MaxErrorRate: stages.MaxErrorRate,
+			MinAdoption:  stages.MinAdoption,
+		})
+		if err != nil {
+			return err
+		}
+		revision = rev.Revision
+		// Audit log
+		auditlogsmgr.ConfAction(getShellUsername(), fmt.Sprintf("started rollout of revision %d on %s from revision %d at %d%%", rollout.ToRevision, envName, rollout.FromRevision, rollout.Percent), "CLI", env.ID)
+	} else if apiFlag {
+		patch := types.EnvConfigPatchRequest{Reason: reason, Force: force, Rollout: &stages}
+		for _, section := range []struct {
+			part  interface{}
+			field **string
+		}{
+			{cnf.Options, &patch.Options},
+			{cnf.Schedule, &patch.Schedule},
+			{cnf.Packs, &patch.Packs},
+			{cnf.Decorators, &patch.Decorators},
+			{cnf.ATC, &patch.ATC},
+		} {
+			serialized, err := json.MarshalIndent(section.part, "", "  ")
+			if err != nil {
+				return fmt.Errorf("❌ error serializing configuration - %w", err)
+			}
+			s := string(serialized)
+			*section.field = &s
+		}
+		resp, err := osctrlAPI.UpdateEnvironmentConfig(envName, patch)
+		if err != nil {
+			return err
+		}
+		revision = resp.Revision
+		if !silentFlag {
+			for _, w := range resp.Warnings {
+				fmt.Printf("⚠️  %s\n", w)
+			}
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ rollout of revision %d on %s started\n", revision, envName)
+	}
+	return nil
+}
+
+func changeRollout(ctx context.Context, cmd *cli.Command) error {
+	// Get environment name
+	envName := cmd.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	// The subcommand is the action on the rollout
+	action := cmd.Name
+	reason := cmd.String("reason")
+	var rollout environments.ConfigRollout
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		switch action {
+		case "halt":
+			if reason == "" {
+				reason = "halted by " + getShellUsername()
+			}
+			rollout, err = envs.HaltRollout(env.ID, reason)
+		case "resume":
+			rollout, err = envs.ResumeRollout(env.ID)
+		case "promote":
+			rollout, err = envs.PromoteRollout(env.ID)
+		case "abort":
+			rollout, _, err = envs.AbortRollout(envName, getShellUsername(), reason)
+		default:
+			return fmt.Errorf("invalid rollout action %s", action)
+		}
+		if err != nil {
+			return err
+		}
+		// Audit log
+		auditlogsmgr.ConfAction(getShellUsername(), fmt.Sprintf("rollout of revision %d on %s %s", rollout.ToRevision, envName, rollout.Status), "CLI", env.ID)
+	} else if apiFlag {
+		rollout, err = osctrlAPI.RolloutAction(envName, types.EnvRolloutRequest{Action: action, Reason: reason})
+		if err != nil {
+			return err
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ rollout of revision %d on %s is %s\n", rollout.ToRevision, envName, rollout.Status)
+	}
+	return nil
+}
+
+func enrollTokenRows(tokens []environments.EnrollToken) [][]string {
+	data := [][]string{}
+	for _, t := range tokens {
+		uses := strconv.Itoa(t.Uses)
+		if t.MaxUses > 0 {
+			uses = fmt.Sprintf("%d/%d", t.Uses, t.MaxUses)
+		}
+		expires := "never"
+		if !t.ExpiresAt.IsZero() {
+			expires = t.ExpiresAt.Format(time.RFC3339)
+		}
+		lastUsed := ""
+		if !t.LastUsedAt.IsZero() {
+			lastUsed = t.LastUsedAt.Format(time.RFC3339)
+		}
+		data = append(data, []string{
+			t.Name,
+			uses,
+			expires,
+			t.CIDRs,
+			t.Tags,
+			lastUsed,
+			t.CreatedBy,
+		})
+	}
+	return data
+}
+
+func listEnrollTokens(ctx context.Context, cmd *cli.Command) error {
+	// Get environment name
+	envName := cmd.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	var tokens []environments.EnrollToken
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		tokens, err = envs.EnrollTokens(env.ID)
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		tokens, err = osctrlAPI.GetEnrollTokens(envName)
+		if err != nil {
+			return err
+		}
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header("Name", "Uses", "Expires", "Networks", "Tags", "Last Used", "Created By")
+	if len(tokens) > 0 {
+		if err := table.Bulk(enrollTokenRows(tokens)); err != nil {
+			return fmt.Errorf("❌ error bulk table - %w", err)
+		}
+		if err := table.Render(); err != nil {
+			return fmt.Errorf("❌ error rendering table - %w", err)
+		}
+	} else {
+		fmt.Printf("No enroll tokens for %s\n", envName)
+	}
+	return nil
+}
+
+func addEnrollToken(ctx context.Context, cmd *cli.Command) error {
+	// Get environment name
+	envName := cmd.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	// Get token name
+	tokenName := cmd.String("token")
+	if tokenName == "" {
+		fmt.Println("❌ enroll token name is required")
+		os.Exit(1)
+	}
+	req := types.EnvEnrollTokenRequest{
+		Name:        tokenName,
+		ExpireHours: int(cmd.Int("expire-hours")),
+		MaxUses:     int(cmd.Int("max-uses")),
+		CIDRs:       cmd.StringSlice("cidr"),
+		Tags:        cmd.StringSlice("tag"),
+	}
+	if req.ExpireHours < 0 {
+		fmt.Println("❌ expiration hours can not be negative")
+		os.Exit(1)
+	}
+	var resp types.EnvEnrollTokenResponse
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		token := environments.EnrollToken{
+			EnvironmentID: env.ID,
+			Name:          req.Name,
+			MaxUses:       req.MaxUses,
+			CreatedBy:     getShellUsername(),
+		}
+		if req.ExpireHours > 0 {
+			token.ExpiresAt = time.Now().Add(time.Duration(req.ExpireHours) * time.Hour)
+		}
+		token, secret, err := envs.CreateEnrollToken(token, req.CIDRs, req.Tags)
+		if err != nil {
+			return err
+		}
+		resp = types.EnvEnrollTokenResponse{Name: token.Name, Secret: secret, ExpiresAt: token.ExpiresAt, MaxUses: token.MaxUses}
+		// Audit log
+		auditlogsmgr.EnvAction(getShellUsername(), fmt.Sprintf("create enroll token %s in env %s", token.Name, env.Name), "CLI", env.ID)
+	} else if apiFlag {
+		resp, err = osctrlAPI.CreateEnrollToken(envName, req)
+		if err != nil {
+			return err
+		}
+	}
+	if silentFlag {
+		fmt.Println(resp.Secret)
+		return nil
+	}
+	fmt.Printf("✅ enroll token %s was created in %s, its secret will not be shown again:\n%s\n", resp.Name, envName, resp.Secret)
+	return nil
+}
+
+func deleteEnrollToken(ctx context.Context, cmd *cli.Command) error {
+	// Get environment name
+	envName := cmd.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	// Get token name
+	tokenName := cmd.String("token")
+	if tokenName == "" {
+		fmt.Println("❌ enroll token name is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		if err := envs.DeleteEnrollToken(env.ID, tokenName); err != nil {
+			return err
+		}
+		// Audit log
+		auditlogsmgr.EnvAction(getShellUsername(), fmt.Sprintf("revoke enroll token %s in env %s", tokenName, env.Name), "CLI", env.ID)
+	} else if apiFlag {
+		if err := osctrlAPI.DeleteEnrollToken(envName, tokenName); err != nil {
+			return err
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ enroll token %s was deleted from %s\n", tokenName, envName)
+	}
+	return nil
+}
+
+func clientCertRows(certs []environments.ClientCertificate) [][]string {
+	data := [][]string{}
+	for _, c := range certs {
+		revoked := ""
+		if c.Revoked() {
+			revoked = c.RevokedAt.Format(time.RFC3339) + " by " + c.RevokedBy
+			if c.Reason != "" {
+				revoked += " (" + c.Reason + ")"
+			}
+		}
+		data = append(data, []string{
+			c.Serial,
+			c.CommonName,
+			c.NotAfter.Format(time.RFC3339),
+			revoked,
+			c.CreatedBy,
+		})
+	}
+	return data
+}
+
+func listClientCerts(ctx context.Context, cmd *cli.Command) error {
+	// Get environment name
+	envName := cmd.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	var certs []environments.ClientCertificate
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		certs, err = envs.ClientCerts(env.ID)
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		certs, err = osctrlAPI.GetClientCerts(envName)
+		if err != nil {
+			return err
+		}
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header("Serial", "Common Name", "Expires", "Revoked", "Created By")
+	if len(certs) > 0 {
+		if err := table.Bulk(clientCertRows(certs)); err != nil {
+			return fmt.Errorf("❌ error bulk table - %w", err)
+		}
+		if err := table.Render(); err != nil {
+			return fmt.Errorf("❌ error rendering table - %w", err)
+		}
+	} else {
+		fmt.Printf("No client certificates for %s\n", envName)
+	}
+	return nil
+}
+
+func issueClientCert(ctx context.Context, cmd *cli.Command) error {
+	// Get environment name
+	envName := cmd.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	// Get common name
+	commonName := cmd.String("common-name")
+	if commonName == "" {
+		fmt.Println("❌ common name is required")
+		os.Exit(1)
+	}
+	req := types.EnvClientCertRequest{
+		CommonName:   commonName,
+		ValidityDays: int(cmd.Int("validity-days")),
+	}
+	if req.ValidityDays < 0 {
+		fmt.Println("❌ validity days can not be negative")
+		os.Exit(1)
+	}
+	var resp types.EnvClientCertResponse
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		validity := time.Duration(req.ValidityDays) * 24 * time.Hour
+		cert, certPEM, keyPEM, err := envs.IssueClientCert(env, req.CommonName, validity, getShellUsername())
+		if err != nil {
+			return err
+		}
+		ca, err := envs.GetClientCA(env.ID)
+		if err != nil {
+			return err
+		}
+		resp = types.EnvClientCertResponse{Serial: cert.Serial, CommonName: cert.CommonName, NotAfter: cert.NotAfter, Certificate: certPEM, Key: keyPEM, CA: ca.Certificate}
+		// Audit log
+		auditlogsmgr.EnvAction(getShellUsername(), fmt.Sprintf("issue client certificate %s for %s in env %s", cert.Serial, cert.CommonName, env.Name), "CLI", env.ID)
+	} else if apiFlag {
+		resp, err = osctrlAPI.IssueClientCert(envName, req)
+		if err != nil {
+			return err
+		}
+	}
+	certFile := cmd.String("cert-file")
+	if certFile != "" {
+		if err := os.WriteFile(certFile, []byte(resp.Certificate), 0644); err != nil {
+			return fmt.Errorf("error writing certificate - %w", err)
+		}
+	}
+	keyFile := cmd.String("key-file")
+	if keyFile != "" {
+		if err := os.WriteFile(keyFile, []byte(resp.Key), 0600); err != nil {
+			return fmt.Errorf("error writing key - %w", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ client certificate %s was issued for %s in %s, valid until %s\n", resp.Serial, resp.CommonName, envName, resp.NotAfter.Format(time.RFC3339))
+	}
+	if certFile == "" {
+		fmt.Print(resp.Certificate)
+	}
+	if keyFile == "" {
+		fmt.Print(resp.Key)
+	}
+	return nil
+}
+
+func revokeClientCert(ctx context.Context, cmd *cli.Command) error {
+	// Get environment name
+	envName := cmd.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	// Get certificate serial
+	serial := cmd.String("serial")
+	if serial == "" {
+		fmt.Println("❌ certificate serial is required")
+		os.Exit(1)
+	}
+	reason := cmd.String("reason")
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		cert, err := envs.RevokeClientCert(env.ID, serial, reason, getShellUsername())
+		if err != nil {
+			return err
+		}
+		// Audit log
+		auditlogsmgr.EnvAction(getShellUsername(), fmt.Sprintf("revoke client certificate %s for %s in env %s", cert.Serial, cert.CommonName, env.Name), "CLI", env.ID)
+	} else if apiFlag {
+		if err := osctrlAPI.RevokeClientCert(envName, serial, reason); err != nil {
+			return err
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ client certificate %s was revoked in %s\n", serial, envName)
+	}
+	return nil
+}
+
+func showClientCA(ctx context.Context, cmd *cli.Command) error {
+	// Get environment name
+	envName := cmd.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	var caPEM string
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		ca, err := envs.GetClientCA(env.ID)
+		if err != nil {
+			return err
+		}
+		caPEM = ca.Certificate
+	} else if apiFlag {
+		caPEM, err = osctrlAPI.GetClientCA(envName)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Print(caPEM)
+	return nil
+}
+
+func showClientCRL(ctx context.Context, cmd *cli.Command) error {
+	// Get environment name
+	envName := cmd.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	var crlPEM string
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		crlPEM, err = envs.ClientCRL(env.ID)
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		crlPEM, err = osctrlAPI.GetClientCRL(envName)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Print(crlPEM)
+	return nil
+}
+
+func environmentPlans(specs []environments.EnvironmentSpec) ([]environments.SpecPlan, error) {
+	var current []environments.TLSEnvironment
+	if dbFlag {
+		current, err = envs.All()
+	} else if apiFlag {
+		current, err = osctrlAPI.GetEnvironments()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting environments - %w", err)
+	}
+	byName := make(map[string]environments.TLSEnvironment)
+	for _, e := range current {
+		byName[e.Name] = e
+	}
+	var plans []environments.SpecPlan
+	for _, spec := range specs {
+		var env *environments.TLSEnvironment
+		if e, ok := byName[spec.Name]; ok {
+			env = &e
+		}
+		plan, err := environments.PlanSpec(env, spec)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+func printPlans(plans []environments.SpecPlan) error {
+	changed := 0
+	for _, plan := range plans {
+		if plan.Empty() {
+			continue
+		}
+		changed++
+		if plan.Create {
+			fmt.Printf("+ environment %s will be created\n", plan.Name)
+		} else {
+			fmt.Printf("~ environment %s will be updated\n", plan.Name)
+		}
+		for _, c := range plan.Changes {
+			if !c.Multiline() {
+				fmt.Printf("    %s: %q -> %q\n", c.Field, c.From, c.To)
+				continue
+			}
+			diff, err := c.Diff()
+			if err != nil {
+				return fmt.Errorf("error diffing %s of %s - %w", c.Field, plan.Name, err)
+			}
+			fmt.Printf("    %s:\n", c.Field)
+			for _, line := range strings.Split(strings.TrimRight(diff, "\n"), "\n") {
+				fmt.Printf("      %s\n", line)
+			}
+		}
+	}
+	if changed == 0 {
+		fmt.Println("No changes, environments match their specs")
+	}
+	return nil
+}
+
+func diffEnvironments(ctx context.Context, cmd *cli.Command) error {
+	// Get directory with specs
+	dir := cmd.String("file")
+	if dir == "" {
+		fmt.Println("❌ directory with environment specs is required")
+		os.Exit(1)
+	}
+	specs, err := environments.LoadSpecs(dir)
+	if err != nil {
+		return err
+	}
+	plans, err := environmentPlans(specs)
+	if err != nil {
+		return err
+	}
+	return printPlans(plans)
+}
+
+func validateSpec(spec environments.EnvironmentSpec, plan environments.SpecPlan) (environments.ConfigValidation, error) {
+	var versions []string
+	if dbFlag && !plan.Create {
+		env, err := envs.Get(spec.Name)
+		if err != nil {
+			return environments.ConfigValidation{}, err
+		}
+		if versions, err = nodesmgr.GetEnvOsqueryVersions(env.ID); err != nil {
+			return environments.ConfigValidation{}, err
+		}
+	}
+	validation := environments.ValidateOptions(spec.Options, versions)
+	validation.Merge(environments.ValidatePacks(spec.Packs))
+	validation.Merge(environments.ValidateATC(spec.ATC))
+	return validation, nil
+}
+
+func applySpecDB(spec environments.EnvironmentSpec, plan environments.SpecPlan) error {
+	if plan.Create {
+		newEnv := envs.Empty(spec.Name, spec.Hostname)
+		newEnv.Configuration = envs.GenEmptyConfiguration(true)
+		newEnv.EnrollExpire = time.Now().Add(time.Duration(environments.DefaultLinkExpire) * time.Hour)
+		newEnv.RemoveExpire = time.Now().Add(time.Duration(environments.DefaultLinkExpire) * time.Hour)
+		if err := envs.Create(&newEnv); err != nil {
+			return err
+		}
+		// Update configuration parts from serialized
+		cnf, err := envs.GenStructConf([]byte(newEnv.Configuration))
+		if err != nil {
+			return err
+		}
+		if err := envs.UpdateConfigurationParts(spec.Name, cnf); err != nil {
+			return err
+		}
+		// Create a tag for this new environment
+		if err := tagsmgr.NewTag(
+			newEnv.Name,
+			"Tag for environment "+newEnv.Name,
+			tags.RandomColor(),
+			newEnv.Icon,
+			appName,
+			newEnv.ID,
+			false,
+			tags.TagTypeEnv,
+			tags.TagCustomEnv); err != nil {
+			return err
+		}
+		auditlogsmgr.EnvAction(getShellUsername(), "add environment "+spec.Name, "CLI", 0)
+	}
+	applied, err := envs.ApplySpec(spec, getShellUsername())
+	if err != nil {
+		return err
+	}
+	env, err := envs.Get(spec.Name)
+	if err != nil {
+		return err
+	}
+	// Flags of new environments are generated when the spec has none
+	if plan.Create && spec.Flags == "" {
+		osqueryValues := config.YAMLConfigurationOsquery{Config: true, Logger: true, Query: true, Carve: true}
+		flags, err := envs.GenerateFlags(env, "", "", osqueryValues)
+		if err != nil {
+			return err
+		}
+		if err := envs.UpdateFlags(spec.Name, flags); err != nil {
+			return err
+		}
+	}
+	// Audit log
+	auditlogsmgr.ConfAction(getShellUsername(), fmt.Sprintf("applied spec of environment %s with %d changes", spec.Name, len(applied.Changes)), "CLI", env.ID)
+	return nil
+}
+
+func applySpecAPI(spec environments.EnvironmentSpec, plan environments.SpecPlan, force bool) error {
+	if plan.Create {
+		if _, err := osctrlAPI.CreateEnvironment(types.EnvCreateRequest{Name: spec.Name, Hostname: spec.Hostname}); err != nil {
+			return err
+		}
+		// Plan again against the defaults of the new environment
+		env, err := osctrlAPI.GetEnvironment(spec.Name)
+		if err != nil {
+			return err
+		}
+		if plan, err = environments.PlanSpec(&env, spec); err != nil {
+			return err
+		}
+	}
+	var update types.EnvUpdateRequest
+	var intervals types.EnvIntervalsPatchRequest
+	configPatch := types.EnvConfigPatchRequest{Reason: "apply spec of " + spec.Name, Force: force}
+	var updated, intervalsUpdated, configUpdated bool
+	packages := make(map[string]types.ApiActionsRequest)
+	for _, c := range plan.Changes {
+		switch c.Field {
+		case environments.SpecFieldHostname:
+			update.Hostname = &c.To
+			updated = true
+		case environments.SpecFieldAcceptEnrolls:
+			accept := c.To == "true"
+			update.AcceptEnrolls = &accept
+			updated = true
+		case environments.SpecFieldRequireApproval:
+			require := c.To == "true"
+			update.RequireApproval = &require
+			updated = true
+		case environments.SpecFieldConfigInterval:
+			intervals.ConfigInterval = &spec.Intervals.Config
+			intervalsUpdated = true
+		case environments.SpecFieldLogInterval:
+			intervals.LogInterval = &spec.Intervals.Log
+			intervalsUpdated = true
+		case environments.SpecFieldQueryInterval:
+			intervals.QueryInterval = &spec.Intervals.Query
+			intervalsUpdated = true
+		case environments.SpecFieldOptions:
+			configPatch.Options = &c.To
+			configUpdated = true
+		case environments.SpecFieldSchedule:
+			configPatch.Schedule = &c.To
+			configUpdated = true
+		case environments.SpecFieldPacks:
+			configPatch.Packs = &c.To
+			configUpdated = true
+		case environments.SpecFieldDecorators:
+			configPatch.Decorators = &c.To
+			configUpdated = true
+		case environments.SpecFieldATC:
+			configPatch.ATC = &c.To
+			configUpdated = true
+		case environments.SpecFieldFlags:
+			configPatch.Flags = &c.To
+			configUpdated = true
+		case environments.SpecFieldDebPackage:
+			packages[settings.SetDebPackage] = types.ApiActionsRequest{DebPkgURL: c.To}
+		case environments.SpecFieldRpmPackage:
+			packages[settings.SetRpmPackage] = types.ApiActionsRequest{RpmPkgURL: c.To}
+		case environments.SpecFieldMsiPackage:
+			packages[settings.SetMsiPackage] = types.ApiActionsRequest{MsiPkgURL: c.To}
+		case environments.SpecFieldPkgPackage:
+			packages[settings.SetMacPackage] = types.ApiActionsRequest{MacPkgURL: c.To}
+		}
+	}
+	if updated {
+		if _, err := osctrlAPI.UpdateEnvironment(spec.Name, update); err != nil {
+			return err
+		}
+	}
+	if intervalsUpdated {
+		if _, err := osctrlAPI.UpdateEnvironmentIntervals(spec.Name, intervals); err != nil {
+			return err
+		}
+	}
+	if configUpdated {
+		resp, err := osctrlAPI.UpdateEnvironmentConfig(spec.Name, configPatch)
+		if err != nil {
+			return err
+		}
+		if !silentFlag {
+			for _, w := range resp.Warnings {
+				fmt.Printf("⚠️  %s: %s\n", spec.Name, w)
+			}
+		}
+	}
+	for action, req := range packages {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return fmt.Errorf("error marshaling data - %w", err)
+		}
+		if _, err := osctrlAPI.ActionEnrollmentRemove(spec.Name, action, "enroll", bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyEnvironments(ctx context.Context, cmd *cli.Command) error {
+	// Get directory with specs
+	dir := cmd.String("file")
+	if dir == "" {
+		fmt.Println("❌ directory with environment specs is required")
+		os.Exit(1)
+	}
+	specs, err := environments.LoadSpecs(dir)
+	if err != nil {
+		return err
+	}
+	plans, err := environmentPlans(specs)
+	if err != nil {
+		return err
+	}
+	if !silentFlag {
+		if err := printPlans(plans); err != nil {
+			return err
+		}
+	}
+	// Validate all specs before applying any of them
+	force := cmd.Bool("force")
+	validations := make([]environments.ConfigValidation, len(plans))
+	for i, plan := range plans {
+		if plan.Empty() {
+			continue
+		}
+		if validations[i], err = validateSpec(specs[i], plan); err != nil {
+			return err
+		}
+		if err := validations[i].Err(); err != nil && !force {
+			return fmt.Errorf("spec of %s - %w", plan.Name, err)
+		}
+	}
+	applied := 0
+	for i, plan := range plans {
+		if plan.Empty() {
+			continue
+		}
+		if dbFlag {
+			if !silentFlag {
+				for _, w := range validations[i].Messages(force) {
+					fmt.Printf("⚠️  %s: %s\n", plan.Name, w)
+				}
+			}
+			err = applySpecDB(specs[i], plan)
+		} else if apiFlag {
+			err = applySpecAPI(specs[i], plan, force)
+		}
+		if err != nil {
+			return fmt.Errorf("error applying spec of %s - %w", plan.Name, err)
+		}
+		applied++
+	}
+	if !silentFlag && applied > 0 {
+		fmt.Printf("✅ %d environments were applied successfully\n", applied)
+	}
+	return nil
+}
+
+func exportEnvironments(ctx context.Context, cmd *cli.Command) error {
+	// Get environment name, all environments by default
+	envName := cmd.String("name")
+	output := cmd.String("output")
+	var exported []environments.TLSEnvironment
+	if dbFlag {
+		if envName != "" {
+			env, err := envs.Get(envName)
+			if err != nil {
+				return err
+			}
+			exported = append(exported, env)
+		} else if exported, err = envs.All(); err != nil {
+			return err
+		}
+	} else if apiFlag {
+		if envName != "" {
+			env, err := osctrlAPI.GetEnvironment(envName)
+			if err != nil {
+				return err
+			}
+			exported = append(exported, env)
+		} else if exported, err = osctrlAPI.GetEnvironments(); err != nil {
+			return err
+		}
+	}
+	if output != "" {
+		if err := os.MkdirAll(output, 0o755); err != nil {
+			return fmt.Errorf("error creating %s - %w", output, err)
+		}
+	}
+	for i, env := range exported {
+		spec, err := environments.ExportSpec(env)
+		if err != nil {
+			return err
+		}
+		data, err := environments.MarshalSpec(spec)
+		if err != nil {
+			return err
+		}
+		if output == "" {
+			if i > 0 {
+				fmt.Println("---")
+			}
+			fmt.Print(string(data))
+			continue
+		}
+		file := filepath.Join(output, env.Name+".yaml")
+		if err := os.WriteFile(file, data, 0o644); err != nil {
+			return fmt.Errorf("error writing %s - %w", file, err)
+		}
+		if !silentFlag {
+			fmt.Printf("✅ environment %s was exported to %s\n", env.Name, file)
+		}
+	}
+	return nil
+}
@@ -6,9 +6,11 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/jmpsec/osctrl/pkg/auditlog"
 	"github.com/jmpsec/osctrl/pkg/backend"
+	"github.com/jmpsec/osctrl/pkg/cache"
 	"github.com/jmpsec/osctrl/pkg/carves"
 	"github.com/jmpsec/osctrl/pkg/config"
 	"github.com/jmpsec/osctrl/pkg/environments"
@@ -62,6 +64,7 @@ var (
 	err          error
 	app          *cli.Command
 	dbConfig     *config.YAMLConfigurationDB
+	redisConfig  *config.YAMLConfigurationRedis
 	apiConfig    JSONConfigurationAPI
 	flags        []cli.Flag
 	commands     []*cli.Command
@@ -75,6 +78,7 @@ var (
 	db           *backend.DBManager
 	auditlogsmgr *auditlog.AuditLogManager
 	osctrlAPI    *OsctrlAPI
+	redismgr     *cache.RedisManager
 	formats      map[string]bool
 )
 
@@ -101,6 +105,8 @@ var (
 func init() {
 	// Initialize db config
 	dbConfig = &config.YAMLConfigurationDB{}
+	// Initialize redis config
+	redisConfig = &config.YAMLConfigurationRedis{}
 	// Initialize logging
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	zerolog.CallerMarshalFunc = func(pc uintptr, file string, line int) string {
@@ -211,6 +217,41 @@ func init() {
 			Sources:     cli.EnvVars("DB_CONN_MAX_LIFETIME"),
 			Destination: &dbConfig.ConnMaxLifetime,
 		},
+		&cli.StringFlag{
+			Name:        "redis-connection-string",
+			Value:       "",
+			Usage:       "Redis connection string, used to invalidate nodes cached by osctrl-tls when using the DB",
+			Sources:     cli.EnvVars("REDIS_CONNECTION_STRING"),
+			Destination: &redisConfig.ConnectionString,
+		},
+		&cli.StringFlag{
+			Name:        "redis-host",
+			Value:       "127.0.0.1",
+			Usage:       "Redis host to be connected to",
+			Sources:     cli.EnvVars("REDIS_HOST"),
+			Destination: &redisConfig.Host,
+		},
+		&cli.IntFlag{
+			Name:        "redis-port",
+			Value:       6379,
+			Usage:       "Redis port to be connected to",
+			Sources:     cli.EnvVars("REDIS_PORT"),
+			Destination: &redisConfig.Port,
+		},
+		&cli.StringFlag{
+			Name:        "redis-pass",
+			Value:       "",
+			Usage:       "Password to be used for redis",
+			Sources:     cli.EnvVars("REDIS_PASS"),
+			Destination: &redisConfig.Password,
+		},
+		&cli.IntFlag{
+			Name:        "redis-db",
+			Value:       0,
+			Usage:       "Redis database to be selected after connecting",
+			Sources:     cli.EnvVars("REDIS_DB"),
+			Destination: &redisConfig.DB,
+		},
 		&cli.BoolFlag{
 			Name:        "insecure",
 			Aliases:     []string{"i"},
@@ -353,9 +394,83 @@ func init() {
 							Value:   6,
 							Usage:   "Expiration in hours (0 for no expiration)",
 						},
+						&cli.BoolFlag{
+							Name:    "recursive",
+							Aliases: []string{"R"},
+							Usage:   "Carve the directory in path recursively",
+						},
+						&cli.IntFlag{
+							Name:  "max-depth",
+							Usage: "Maximum directory depth for recursive carves (0 for no limit)",
+						},
+						&cli.StringFlag{
+							Name:  "include",
+							Usage: "Glob(s) of files to include in recursive carves. Comma separated for multiple values",
+						},
+						&cli.StringFlag{
+							Name:  "exclude",
+							Usage: "Glob(s) of files to exclude from recursive carves. Comma separated for multiple values",
+						},
+						&cli.Int64Flag{
+							Name:  "max-file-size",
+							Usage: "Maximum size in bytes of each file in recursive carves (0 for no limit)",
+						},
+						&cli.Int64Flag{
+							Name:  "max-total-size",
+							Usage: "Maximum total size in bytes of recursive carves (0 for no limit)",
+						},
 					},
 					Action: cliWrapper(runCarve),
 				},
+				{
+					Name:  "download",
+					Usage: "Download the archive of a file carve, resuming interrupted transfers",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Carve name to be downloaded",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringFlag{
+							Name:    "session",
+							Aliases: []string{"s"},
+							Usage:   "Carve session to download, when the carve has several files",
+						},
+						&cli.StringFlag{
+							Name:    "output",
+							Aliases: []string{"o"},
+							Usage:   "File to write the archive to, defaults to the carve name",
+						},
+						&cli.StringFlag{
+							Name:    "keyfile",
+							Aliases: []string{"k"},
+							Usage:   "Carves keyfile to decrypt encrypted carves with --db",
+						},
+					},
+					Action: cliWrapper(downloadCarve),
+				},
+				{
+					Name:  "rotate-keys",
+					Usage: "Re-wrap encrypted carve data keys with the active environment key",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringFlag{
+							Name:    "keyfile",
+							Aliases: []string{"k"},
+							Usage:   "Carves keyfile with the environment keys, required with --db",
+						},
+					},
+					Action: cliWrapper(rotateCarveKeys),
+				},
 				{
 					Name:    "list",
 					Aliases: []string{"l"},
@@ -483,6 +598,36 @@ func init() {
 					},
 					Action: cliWrapper(addEnvironment),
 				},
+				{
+					Name:  "clone",
+					Usage: "Add a new TLS environment from an existing one used as template",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "from",
+							Aliases: []string{"f"},
+							Usage:   "Environment name to be used as template",
+						},
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be added",
+						},
+						&cli.StringFlag{
+							Name:    "hostname",
+							Aliases: []string{"host"},
+							Usage:   "Environment host to be added, the one of the template by default",
+						},
+						&cli.BoolFlag{
+							Name:  "tags",
+							Usage: "Copy the tag definitions of the template",
+						},
+						&cli.BoolFlag{
+							Name:  "saved-queries",
+							Usage: "Copy the saved queries of the template",
+						},
+					},
+					Action: cliWrapper(cloneEnvironment),
+				},
 				{
 					Name:    "update",
 					Aliases: []string{"u"},
@@ -503,6 +648,14 @@ func init() {
 							Aliases: []string{"e"},
 							Usage:   "Environment enroll capability",
 						},
+						&cli.BoolFlag{
+							Name:  "require-approval",
+							Usage: "New nodes stay pending until they are approved",
+						},
+						&cli.BoolFlag{
+							Name:  "client-cert-auth",
+							Usage: "Nodes must present a client certificate issued by the environment",
+						},
 						&cli.StringFlag{
 							Name:    "hostname",
 							Aliases: []string{"host"},
@@ -699,6 +852,10 @@ func init() {
 							Aliases: []string{"b"},
 							Usage:   "Boolean value for the option",
 						},
+						&cli.BoolFlag{
+							Name:  "force",
+							Usage: "Add the option even if it is not a valid osquery option",
+						},
 					},
 					Action: cliWrapper(addOsqueryOption),
 				},
@@ -752,6 +909,10 @@ func init() {
 							Aliases: []string{"s"},
 							Usage:   "Restrict this query to a percentage (1-100) of target hosts",
 						},
+						&cli.BoolFlag{
+							Name:  "force",
+							Usage: "Add the pack even if it is not a valid osquery pack",
+						},
 					},
 					Action: cliWrapper(addNewPack),
 				},
@@ -776,6 +937,10 @@ func init() {
 							Aliases: []string{"P"},
 							Usage:   "Local full path to load the query pack within osquery",
 						},
+						&cli.BoolFlag{
+							Name:  "force",
+							Usage: "Add the pack even if it is not a valid osquery pack",
+						},
 					},
 					Action: cliWrapper(addLocalPack),
 				},
@@ -844,6 +1009,10 @@ func init() {
 							Value:   "",
 							Usage:   "Only run on osquery versions greater than or equal-to this version",
 						},
+						&cli.BoolFlag{
+							Name:  "force",
+							Usage: "Add the query even if it is not a valid osquery pack query",
+						},
 					},
 					Action: cliWrapper(addPackQuery),
 				},
@@ -1059,114 +1228,566 @@ func init() {
 					},
 				},
 				{
-					Name:    "delete",
-					Aliases: []string{"d"},
-					Usage:   "Delete an existing TLS environment",
-					Flags: []cli.Flag{
-						&cli.StringFlag{
-							Name:    "name",
-							Aliases: []string{"n"},
-							Usage:   "Environment name to be deleted",
+					Name:    "revision",
+					Aliases: []string{"rev"},
+					Usage:   "Commands for configuration revisions of a TLS environment",
+					Commands: []*cli.Command{
+						{
+							Name:    "list",
+							Aliases: []string{"l"},
+							Usage:   "List the configuration revisions, most recent first",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+							},
+							Action: cliWrapper(listRevisions),
 						},
-					},
-					Action: cliWrapper(deleteEnvironment),
-				},
-				{
-					Name:    "show",
-					Aliases: []string{"s"},
-					Usage:   "Show a TLS environment",
-					Flags: []cli.Flag{
-						&cli.StringFlag{
-							Name:    "name",
-							Aliases: []string{"n"},
-							Usage:   "Environment name to be displayed",
+						{
+							Name:    "show",
+							Aliases: []string{"s"},
+							Usage:   "Show a configuration revision, the latest by default",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+								&cli.IntFlag{
+									Name:    "revision",
+									Aliases: []string{"r"},
+									Usage:   "Revision number to be displayed",
+								},
+							},
+							Action: cliWrapper(showRevision),
+						},
+						{
+							Name:    "diff",
+							Aliases: []string{"d"},
+							Usage:   "Show the differences between two configuration revisions",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+								&cli.IntFlag{
+									Name:    "from",
+									Aliases: []string{"f"},
+									Usage:   "Revision number to diff from",
+								},
+								&cli.IntFlag{
+									Name:    "to",
+									Aliases: []string{"t"},
+									Usage:   "Revision number to diff to, the latest by default",
+								},
+							},
+							Action: cliWrapper(diffRevisions),
+						},
+						{
+							Name:    "rollback",
+							Aliases: []string{"rb"},
+							Usage:   "Roll back the configuration to a revision",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+								&cli.IntFlag{
+									Name:    "revision",
+									Aliases: []string{"r"},
+									Usage:   "Revision number to roll back to",
+								},
+								&cli.StringFlag{
+									Name:    "reason",
+									Aliases: []string{"R"},
+									Usage:   "Reason of the rollback",
+								},
+							},
+							Action: cliWrapper(rollbackConfiguration),
 						},
 					},
-					Action: cliWrapper(showEnvironment),
-				},
-				{
-					Name:    "list",
-					Aliases: []string{"l"},
-					Usage:   "List all existing TLS environments",
-					Action:  cliWrapper(listEnvironment),
-				},
-			},
-		},
-		{
-			Name:  "login",
-			Usage: "Login into API and generate JSON config file with token",
-			Flags: []cli.Flag{
-				&cli.StringFlag{
-					Name:    "username",
-					Aliases: []string{"u"},
-					Usage:   "User to be used in login",
 				},
-				&cli.StringFlag{
-					Name:    "url",
-					Aliases: []string{"U"},
-					Usage:   "URL to be used in login",
-				},
-				&cli.StringFlag{
-					Name:    "environment",
-					Aliases: []string{"e"},
-					Usage:   "Environment to be used in login",
-				},
-				&cli.IntFlag{
-					Name:    "expiration",
-					Aliases: []string{"E"},
-					Value:   6,
-					Usage:   "Expiration in hours (0 for server default)",
-				},
-				&cli.BoolFlag{
-					Name:        "write-api-file",
-					Aliases:     []string{"w"},
-					Destination: &writeApiFileFlag,
-					Usage:       "Write API configuration to JSON file",
-				},
-			},
-			Action: loginAPI,
-		},
-		{
-			Name:  "node",
-			Usage: "Commands for nodes",
-			Commands: []*cli.Command{
 				{
-					Name:    "delete",
-					Aliases: []string{"d"},
-					Usage:   "Delete and archive an existing node",
-					Flags: []cli.Flag{
-						&cli.StringFlag{
-							Name:    "uuid, u",
-							Aliases: []string{"u"},
-							Usage:   "Node UUID to be deleted",
+					Name:    "token",
+					Aliases: []string{"tk"},
+					Usage:   "Commands for enroll tokens of a TLS environment",
+					Commands: []*cli.Command{
+						{
+							Name:    "list",
+							Aliases: []string{"l"},
+							Usage:   "List the enroll tokens",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+							},
+							Action: cliWrapper(listEnrollTokens),
 						},
-						&cli.StringFlag{
-							Name:    "env",
-							Aliases: []string{"e"},
-							Usage:   "Environment to be used",
+						{
+							Name:    "add",
+							Aliases: []string{"a"},
+							Usage:   "Add an enroll token, its secret is only shown once",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+								&cli.StringFlag{
+									Name:    "token",
+									Aliases: []string{"t"},
+									Usage:   "Enroll token name to be added",
+								},
+								&cli.IntFlag{
+									Name:    "expire-hours",
+									Aliases: []string{"e"},
+									Usage:   "Hours until the token expires, it never expires by default",
+								},
+								&cli.IntFlag{
+									Name:    "max-uses",
+									Aliases: []string{"m"},
+									Usage:   "Maximum number of enrollments, unlimited by default",
+								},
+								&cli.StringSliceFlag{
+									Name:  "cidr",
+									Usage: "Network nodes can enroll from, it can be repeated",
+								},
+								&cli.StringSliceFlag{
+									Name:  "tag",
+									Usage: "Tag for the nodes enrolled with the token, it can be repeated",
+								},
+							},
+							Action: cliWrapper(addEnrollToken),
+						},
+						{
+							Name:    "delete",
+							Aliases: []string{"d"},
+							Usage:   "Delete an enroll token, so no more nodes enroll with it",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+								&cli.StringFlag{
+									Name:    "token",
+									Aliases: []string{"t"},
+									Usage:   "Enroll token name to be deleted",
+								},
+							},
+							Action: cliWrapper(deleteEnrollToken),
 						},
 					},
-					Action: cliWrapper(deleteNode),
 				},
 				{
-					Name:    "tag",
-					Aliases: []string{"t"},
-					Usage:   "Tag an existing node",
-					Flags: []cli.Flag{
-						&cli.StringFlag{
-							Name:    "uuid, u",
-							Aliases: []string{"u"},
-							Usage:   "Node UUID to be tagged",
-						},
-						&cli.StringFlag{
-							Name:    "env",
-							Aliases: []string{"e"},
-							Usage:   "Environment to be used",
+					Name:    "client-cert",
+					Aliases: []string{"cc"},
+					Usage:   "Commands for client certificates of a TLS environment",
+					Commands: []*cli.Command{
+						{
+							Name:    "list",
+							Aliases: []string{"l"},
+							Usage:   "List the client certificates",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+							},
+							Action: cliWrapper(listClientCerts),
 						},
-						&cli.StringFlag{
-							Name:    "name",
-							Aliases: []string{"n", "tag", "tag-value"},
-							Usage:   "Tag name to be used. It will be created if does not exist",
+						{
+							Name:    "issue",
+							Aliases: []string{"i"},
+							Usage:   "Issue a client certificate for a node, its key is only shown once",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+								&cli.StringFlag{
+									Name:    "common-name",
+									Aliases: []string{"c"},
+									Usage:   "Host identifier of the node using the certificate",
+								},
+								&cli.IntFlag{
+									Name:    "validity-days",
+									Aliases: []string{"d"},
+									Usage:   "Days the certificate is valid, a year by default",
+								},
+								&cli.StringFlag{
+									Name:  "cert-file",
+									Usage: "File to write the certificate to, instead of showing it",
+								},
+								&cli.StringFlag{
+									Name:  "key-file",
+									Usage: "File to write the key to, instead of showing it",
+								},
+							},
+							Action: cliWrapper(issueClientCert),
+						},
+						{
+							Name:    "revoke",
+							Aliases: []string{"r"},
+							Usage:   "Revoke a client certificate, so the node using it is not served anymore",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+								&cli.StringFlag{
+									Name:    "serial",
+									Aliases: []string{"s"},
+									Usage:   "Serial of the certificate to be revoked",
+								},
+								&cli.StringFlag{
+									Name:    "reason",
+									Aliases: []string{"R"},
+									Usage:   "Reason of the revocation",
+								},
+							},
+							Action: cliWrapper(revokeClientCert),
+						},
+						{
+							Name:  "ca",
+							Usage: "Show the certificate of the client CA",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+							},
+							Action: cliWrapper(showClientCA),
+						},
+						{
+							Name:  "crl",
+							Usage: "Show the revocation list of the client CA",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+							},
+							Action: cliWrapper(showClientCRL),
+						},
+					},
+				},
+				{
+					Name:    "rollout",
+					Aliases: []string{"ro"},
+					Usage:   "Commands for staged configuration rollouts of a TLS environment",
+					Commands: []*cli.Command{
+						{
+							Name:    "show",
+							Aliases: []string{"s"},
+							Usage:   "Show the current rollout and the health of its stage",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+							},
+							Action: cliWrapper(showRollout),
+						},
+						{
+							Name:    "list",
+							Aliases: []string{"l"},
+							Usage:   "List the rollouts, most recent first",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+							},
+							Action: cliWrapper(listRollouts),
+						},
+						{
+							Name:  "start",
+							Usage: "Update the configuration and start a staged rollout of it",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+								&cli.StringFlag{
+									Name:    "conf",
+									Aliases: []string{"c"},
+									Usage:   "Osquery configuration file to be rolled out",
+								},
+								&cli.StringFlag{
+									Name:    "reason",
+									Aliases: []string{"R"},
+									Usage:   "Reason stored with the configuration revision",
+								},
+								&cli.BoolFlag{
+									Name:  "force",
+									Usage: "Roll out options, packs and ATC that fail validation",
+								},
+								&cli.IntFlag{
+									Name:    "from",
+									Aliases: []string{"f"},
+									Usage:   "Revision served to the rest of the nodes, the current one by default",
+								},
+								&cli.StringFlag{
+									Name:    "canary-tag",
+									Aliases: []string{"t"},
+									Usage:   "Tag of nodes always served the new revision",
+								},
+								&cli.IntFlag{
+									Name:    "percent",
+									Aliases: []string{"p"},
+									Usage:   "Percent of nodes of the first stage",
+								},
+								&cli.IntFlag{
+									Name:  "step-percent",
+									Usage: "Percent of nodes added by each stage",
+								},
+								&cli.IntFlag{
+									Name:  "step-minutes",
+									Usage: "Minutes each stage lasts",
+								},
+								&cli.Float64Flag{
+									Name:  "max-error-rate",
+									Usage: "Share of error status logs of the cohort that halts the rollout",
+								},
+								&cli.Float64Flag{
+									Name:  "min-adoption",
+									Usage: "Share of reporting nodes that must adopt the configuration to widen",
+								},
+							},
+							Action: cliWrapper(startRollout),
+						},
+						{
+							Name:  "halt",
+							Usage: "Halt the rollout, serving the previous revision to every node",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+								&cli.StringFlag{
+									Name:    "reason",
+									Aliases: []string{"R"},
+									Usage:   "Reason to halt the rollout",
+								},
+							},
+							Action: cliWrapper(changeRollout),
+						},
+						{
+							Name:    "resume",
+							Aliases: []string{"r"},
+							Usage:   "Resume a halted rollout",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+							},
+							Action: cliWrapper(changeRollout),
+						},
+						{
+							Name:    "promote",
+							Aliases: []string{"p"},
+							Usage:   "Serve the new revision to every node now",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+							},
+							Action: cliWrapper(changeRollout),
+						},
+						{
+							Name:    "abort",
+							Aliases: []string{"a"},
+							Usage:   "Abort the rollout and roll back to the previous revision",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+								&cli.StringFlag{
+									Name:    "reason",
+									Aliases: []string{"R"},
+									Usage:   "Reason to abort the rollout",
+								},
+							},
+							Action: cliWrapper(changeRollout),
+						},
+					},
+				},
+				{
+					Name:    "delete",
+					Aliases: []string{"d"},
+					Usage:   "Delete an existing TLS environment",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be deleted",
+						},
+					},
+					Action: cliWrapper(deleteEnvironment),
+				},
+				{
+					Name:    "show",
+					Aliases: []string{"s"},
+					Usage:   "Show a TLS environment",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be displayed",
+						},
+					},
+					Action: cliWrapper(showEnvironment),
+				},
+				{
+					Name:    "list",
+					Aliases: []string{"l"},
+					Usage:   "List all existing TLS environments",
+					Action:  cliWrapper(listEnvironment),
+				},
+				{
+					Name:  "apply",
+					Usage: "Apply a directory of YAML environment specs, creating and updating TLS environments",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "file",
+							Aliases: []string{"f"},
+							Usage:   "Directory with the environment specs to be applied",
+						},
+						&cli.BoolFlag{
+							Name:  "force",
+							Usage: "Apply options, packs and ATC that fail validation",
+						},
+					},
+					Action: cliWrapper(applyEnvironments),
+				},
+				{
+					Name:  "diff",
+					Usage: "Show the changes that applying a directory of YAML environment specs would make",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "file",
+							Aliases: []string{"f"},
+							Usage:   "Directory with the environment specs to be compared",
+						},
+					},
+					Action: cliWrapper(diffEnvironments),
+				},
+				{
+					Name:  "export",
+					Usage: "Export TLS environments as YAML environment specs",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be exported, all environments by default",
+						},
+						&cli.StringFlag{
+							Name:    "output",
+							Aliases: []string{"o"},
+							Usage:   "Directory to write the specs to, standard output by default",
+						},
+					},
+					Action: cliWrapper(exportEnvironments),
+				},
+			},
+		},
+		{
+			Name:  "login",
+			Usage: "Login into API and generate JSON config file with token",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "username",
+					Aliases: []string{"u"},
+					Usage:   "User to be used in login",
+				},
+				&cli.StringFlag{
+					Name:    "url",
+					Aliases: []string{"U"},
+					Usage:   "URL to be used in login",
+				},
+				&cli.StringFlag{
+					Name:    "environment",
+					Aliases: []string{"e"},
+					Usage:   "Environment to be used in login",
+				},
+				&cli.IntFlag{
+					Name:    "expiration",
+					Aliases: []string{"E"},
+					Value:   6,
+					Usage:   "Expiration in hours (0 for server default)",
+				},
+				&cli.BoolFlag{
+					Name:        "write-api-file",
+					Aliases:     []string{"w"},
+					Destination: &writeApiFileFlag,
+					Usage:       "Write API configuration to JSON file",
+				},
+			},
+			Action: loginAPI,
+		},
+		{
+			Name:  "node",
+			Usage: "Commands for nodes",
+			Commands: []*cli.Command{
+				{
+					Name:    "delete",
+					Aliases: []string{"d"},
+					Usage:   "Delete and archive an existing node",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "uuid, u",
+							Aliases: []string{"u"},
+							Usage:   "Node UUID to be deleted",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(deleteNode),
+				},
+				{
+					Name:    "tag",
+					Aliases: []string{"t"},
+					Usage:   "Tag an existing node",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "uuid, u",
+							Aliases: []string{"u"},
+							Usage:   "Node UUID to be tagged",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n", "tag", "tag-value"},
+							Usage:   "Tag name to be used. It will be created if does not exist",
 						},
 						&cli.StringFlag{
 							Name:    "tag-type",
@@ -1245,6 +1866,446 @@ func init() {
 					},
 					Action: cliWrapper(lookupNode),
 				},
+				{
+					Name:    "move",
+					Aliases: []string{"m"},
+					Usage:   "Move nodes to another environment without enrolling them again",
+					Flags: []cli.Flag{
+						&cli.StringSliceFlag{
+							Name:    "uuid",
+							Aliases: []string{"u"},
+							Usage:   "Node UUID to be moved, it can be repeated",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment the nodes are in",
+						},
+						&cli.StringFlag{
+							Name:    "to",
+							Aliases: []string{"t"},
+							Usage:   "Environment the nodes are moved to",
+						},
+					},
+					Action: cliWrapper(moveNodes),
+				},
+				{
+					Name:    "revoke",
+					Aliases: []string{"r"},
+					Usage:   "Revoke the node keys of nodes so they have to enroll again",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringSliceFlag{
+							Name:    "uuid",
+							Aliases: []string{"u"},
+							Usage:   "Node UUID to be revoked, it can be repeated",
+						},
+						&cli.StringFlag{
+							Name:    "tag",
+							Aliases: []string{"t"},
+							Usage:   "Revoke all the nodes with this tag",
+						},
+						&cli.BoolFlag{
+							Name:  "all",
+							Usage: "Revoke all the nodes of the environment",
+						},
+						&cli.StringFlag{
+							Name:    "reason",
+							Aliases: []string{"r"},
+							Usage:   "Reason of the revocation, kept in the audit trail",
+						},
+						&cli.BoolFlag{
+							Name:  "rotate-secret",
+							Usage: "Rotate the enroll secret of the environment too, when it leaked along with the keys",
+						},
+					},
+					Action: cliWrapper(revokeNodes),
+				},
+				{
+					Name:    "quarantine",
+					Aliases: []string{"q"},
+					Usage:   "Commands for node quarantine",
+					Commands: []*cli.Command{
+						{
+							Name:    "set",
+							Aliases: []string{"s"},
+							Usage:   "Put a node in quarantine, serving it only the incident response configuration",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "uuid",
+									Aliases: []string{"u"},
+									Usage:   "Node UUID to be quarantined",
+								},
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+								&cli.StringFlag{
+									Name:    "reason",
+									Aliases: []string{"r"},
+									Usage:   "Reason of the quarantine",
+								},
+							},
+							Action: cliWrapper(quarantineNode),
+						},
+						{
+							Name:    "release",
+							Aliases: []string{"r"},
+							Usage:   "Release a node from quarantine",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "uuid",
+									Aliases: []string{"u"},
+									Usage:   "Node UUID to be released",
+								},
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+							},
+							Action: cliWrapper(releaseNode),
+						},
+						{
+							Name:    "list",
+							Aliases: []string{"l"},
+							Usage:   "List the quarantined nodes of an environment",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+							},
+							Action: cliWrapper(listQuarantinedNodes),
+						},
+					},
+				},
+				{
+					Name:    "approval",
+					Aliases: []string{"a"},
+					Usage:   "Commands for nodes pending approval in environments requiring it",
+					Commands: []*cli.Command{
+						{
+							Name:    "list",
+							Aliases: []string{"l"},
+							Usage:   "List the nodes pending approval of an environment",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+								&cli.BoolFlag{
+									Name:  "rejected",
+									Usage: "List the rejected nodes instead",
+								},
+							},
+							Action: cliWrapper(listApprovalNodes),
+						},
+						{
+							Name:    "approve",
+							Aliases: []string{"a"},
+							Usage:   "Approve a node pending approval or rejected",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "uuid",
+									Aliases: []string{"u"},
+									Usage:   "Node UUID to be approved",
+								},
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+							},
+							Action: cliWrapper(approveNode),
+						},
+						{
+							Name:    "reject",
+							Aliases: []string{"r"},
+							Usage:   "Reject a node pending approval",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "uuid",
+									Aliases: []string{"u"},
+									Usage:   "Node UUID to be rejected",
+								},
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+							},
+							Action: cliWrapper(rejectNode),
+						},
+						{
+							Name:  "rules",
+							Usage: "List the approval rules of an environment",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+							},
+							Action: cliWrapper(listApprovalRules),
+						},
+						{
+							Name:  "add-rule",
+							Usage: "Add a rule approving nodes by hardware serial or hostname pattern, approving the pending nodes matching it",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+								&cli.StringFlag{
+									Name:  "serial",
+									Usage: "Hardware serial of the nodes to approve",
+								},
+								&cli.StringFlag{
+									Name:  "hostname",
+									Usage: "Hostname pattern of the nodes to approve, like web-*",
+								},
+							},
+							Action: cliWrapper(addApprovalRule),
+						},
+						{
+							Name:  "delete-rule",
+							Usage: "Delete an approval rule, nodes it approved stay approved",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+								&cli.UintFlag{
+									Name:  "id",
+									Usage: "Approval rule ID to be deleted",
+								},
+							},
+							Action: cliWrapper(deleteApprovalRule),
+						},
+					},
+				},
+				{
+					Name:    "overlay",
+					Aliases: []string{"o"},
+					Usage:   "Commands for configuration overlays by tag or platform",
+					Commands: []*cli.Command{
+						{
+							Name:    "list",
+							Aliases: []string{"l"},
+							Usage:   "List the configuration overlays of an environment",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+							},
+							Action: cliWrapper(listConfigOverlays),
+						},
+						{
+							Name:    "set",
+							Aliases: []string{"s"},
+							Usage:   "Create or replace a configuration overlay for the nodes with a tag or a platform",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Overlay name",
+								},
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+								&cli.StringFlag{
+									Name:    "target-type",
+									Aliases: []string{"T"},
+									Value:   environments.OverlayTargetTag,
+									Usage:   "Target of the overlay, tag or platform",
+								},
+								&cli.StringFlag{
+									Name:    "target",
+									Aliases: []string{"t"},
+									Usage:   "Tag or platform of the nodes to apply the overlay",
+								},
+								&cli.IntFlag{
+									Name:    "priority",
+									Aliases: []string{"p"},
+									Value:   0,
+									Usage:   "Overlays with higher priority are merged last",
+								},
+								&cli.StringFlag{
+									Name:    "file",
+									Aliases: []string{"f"},
+									Usage:   "JSON file with the partial osquery configuration",
+								},
+								&cli.BoolFlag{
+									Name:  "force",
+									Usage: "Save options, packs and ATC that fail validation",
+								},
+							},
+							Action: cliWrapper(setConfigOverlay),
+						},
+						{
+							Name:    "delete",
+							Aliases: []string{"d"},
+							Usage:   "Delete a configuration overlay",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Overlay name",
+								},
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+							},
+							Action: cliWrapper(deleteConfigOverlay),
+						},
+					},
+				},
+				{
+					Name:    "config",
+					Aliases: []string{"c"},
+					Usage:   "Show the configuration served to a node, with its overlays",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "uuid",
+							Aliases: []string{"u"},
+							Usage:   "Node UUID to be shown",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(showNodeConfig),
+				},
+				{
+					Name:  "drift",
+					Usage: "List the nodes reporting a config hash other than their served configuration",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(showDriftedNodes),
+				},
+				{
+					Name:    "attribute",
+					Aliases: []string{"attr"},
+					Usage:   "Commands for custom node attributes",
+					Commands: []*cli.Command{
+						{
+							Name:    "list",
+							Aliases: []string{"l"},
+							Usage:   "List the custom attributes of a node",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "uuid",
+									Aliases: []string{"u"},
+									Usage:   "Node UUID to be used",
+								},
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+							},
+							Action: cliWrapper(listNodeAttributes),
+						},
+						{
+							Name:    "set",
+							Aliases: []string{"s"},
+							Usage:   "Set a custom attribute of a node",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "uuid",
+									Aliases: []string{"u"},
+									Usage:   "Node UUID to be used",
+								},
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Attribute name to be set",
+								},
+								&cli.StringFlag{
+									Name:    "type",
+									Aliases: []string{"t"},
+									Value:   nodes.AttributeString,
+									Usage:   "Attribute type to be used. It can be 'string', 'number' and 'bool'",
+								},
+								&cli.StringFlag{
+									Name:    "value",
+									Aliases: []string{"v"},
+									Usage:   "Attribute value to be set",
+								},
+							},
+							Action: cliWrapper(setNodeAttribute),
+						},
+						{
+							Name:    "delete",
+							Aliases: []string{"d"},
+							Usage:   "Delete a custom attribute of a node",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "uuid",
+									Aliases: []string{"u"},
+									Usage:   "Node UUID to be used",
+								},
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Attribute name to be deleted",
+								},
+							},
+							Action: cliWrapper(deleteNodeAttribute),
+						},
+						{
+							Name:    "import",
+							Aliases: []string{"i"},
+							Usage:   "Import custom attributes for nodes matched by uuid, hostname or serial from a CSV or JSON file",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+								&cli.StringFlag{
+									Name:    "file",
+									Aliases: []string{"f"},
+									Usage:   "CSV or JSON file with the attributes to be imported",
+								},
+							},
+							Action: cliWrapper(importNodeAttributes),
+						},
+					},
+				},
 			},
 		},
 		{
@@ -1678,6 +2739,67 @@ func init() {
 				},
 			},
 		},
+		{
+			Name:  "transcript",
+			Usage: "Commands for signed console session transcripts",
+			Commands: []*cli.Command{
+				{
+					Name:  "export",
+					Usage: "Export the signed transcript of a console session",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.UintFlag{
+							Name:    "session",
+							Aliases: []string{"s"},
+							Usage:   "Console session to export",
+						},
+						&cli.StringFlag{
+							Name:    "format",
+							Aliases: []string{"f"},
+							Value:   "json",
+							Usage:   "Transcript format, json or cast (asciinema)",
+						},
+						&cli.StringFlag{
+							Name:    "output",
+							Aliases: []string{"o"},
+							Usage:   "File to write the transcript to, defaults to osctrl-console-<session>.<format>",
+						},
+					},
+					Action: cliWrapper(exportTranscript),
+				},
+				{
+					Name:  "replay",
+					Usage: "Verify and replay a transcript file locally",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "file",
+							Aliases: []string{"f"},
+							Usage:   "Transcript file, JSON or cast",
+						},
+						&cli.StringFlag{
+							Name:    "public-key",
+							Aliases: []string{"k"},
+							Usage:   "PEM public key of the server, to verify who signed the transcript",
+						},
+						&cli.Float64Flag{
+							Name:  "speed",
+							Value: 1,
+							Usage: "Replay speed multiplier, 0 prints everything at once",
+						},
+						&cli.DurationFlag{
+							Name:  "max-wait",
+							Value: 2 * time.Second,
+							Usage: "Longest pause between two events",
+						},
+					},
+					Action: replayTranscript,
+				},
+			},
+		},
 		{
 			Name:  "user",
 			Usage: "Commands for users",
@@ -6,10 +6,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jmpsec/osctrl/pkg/environments"
 	"github.com/jmpsec/osctrl/pkg/nodes"
 	"github.com/jmpsec/osctrl/pkg/settings"
 	"github.com/jmpsec/osctrl/pkg/tags"
+	"github.com/jmpsec/osctrl/pkg/types"
 	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli/v3"
 )
@@ -189,7 +195,7 @@ func tagNode(ctx context.Context, cmd *cli.Command) error {
 			return fmt.Errorf("error tagging - %w", err)
 		}
 		// Audit log
-		auditlogsmgr.NodeAction(getShellUsername(), "tag node "+uuid+" with "+tag, "CLI", e.ID)
+		auditNodeAction("tag node "+uuid+" with "+tag, n, e.ID)
 	} else if apiFlag {
 		if err := osctrlAPI.TagNode(env, uuid, tag, tagTypeInt, tagCustom); err != nil {
 			return fmt.Errorf("error tagging node - %w", err)
@@ -288,3 +294,1178 @@ func lookupNode(ctx context.Context, cmd *cli.Command) error {
 	}
 	return _showNode(node)
 }
+
+func listNodeAttributes(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	uuid := cmd.String("uuid")
+	if uuid == "" {
+		fmt.Println("❌ UUID is required")
+		os.Exit(1)
+	}
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	var attrs []nodes.NodeAttribute
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		n, err := nodesmgr.GetByUUIDEnv(uuid, e.ID)
+		if err != nil {
+			return fmt.Errorf("error getting node - %w", err)
+		}
+		attrs, err = nodesmgr.Attributes(n.ID)
+		if err != nil {
+			return fmt.Errorf("error getting attributes - %w", err)
+		}
+	} else if apiFlag {
+		attrs, err = osctrlAPI.GetNodeAttributes(env, uuid)
+		if err != nil {
+			return fmt.Errorf("error getting attributes - %w", err)
+		}
+	}
+	header := []string{
+		"Name",
+		"Type",
+		"Value",
+		"Set By",
+	}
+	var data [][]string
+	for _, a := range attrs {
+		data = append(data, []string{a.Name, a.Type, a.Value, a.SetBy})
+	}
+	// Prepare output
+	switch formatFlag {
+	case jsonFormat:
+		jsonRaw, err := json.Marshal(attrs)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %w", err)
+		}
+		fmt.Println(string(jsonRaw))
+	case csvFormat:
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writing csv - %w", err)
+		}
+	case prettyFormat:
+		table := tablewriter.NewWriter(os.Stdout)
+		table.Header(stringSliceToAnySlice(header)...)
+		if len(attrs) > 0 {
+			fmt.Printf("Attributes of node %s (%d):\n", uuid, len(attrs))
+			if err := table.Bulk(data); err != nil {
+				return fmt.Errorf("❌ error bulk table - %w", err)
+			}
+		} else {
+			fmt.Printf("No attributes for node %s\n", uuid)
+		}
+		if err := table.Render(); err != nil {
+			return fmt.Errorf("❌ error rendering table - %w", err)
+		}
+	}
+	return nil
+}
+
+func setNodeAttribute(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	uuid := cmd.String("uuid")
+	if uuid == "" {
+		fmt.Println("❌ UUID is required")
+		os.Exit(1)
+	}
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	name := cmd.String("name")
+	if name == "" {
+		fmt.Println("❌ attribute name is required")
+		os.Exit(1)
+	}
+	attrType := cmd.String("type")
+	value := cmd.String("value")
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		n, err := nodesmgr.GetByUUIDEnv(uuid, e.ID)
+		if err != nil {
+			return fmt.Errorf("error getting node - %w", err)
+		}
+		attr, err := nodesmgr.SetAttribute(n, name, attrType, value, getShellUsername())
+		if err != nil {
+			return fmt.Errorf("error setting attribute - %w", err)
+		}
+		// Audit log
+		auditNodeAction(fmt.Sprintf("set attribute %s=%s of node %s", attr.Name, attr.Value, uuid), n, e.ID)
+	} else if apiFlag {
+		if err := osctrlAPI.SetNodeAttribute(env, uuid, name, attrType, value); err != nil {
+			return fmt.Errorf("error setting attribute - %w", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Println("✅ attribute was set successfully")
+	}
+	return nil
+}
+
+func deleteNodeAttribute(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	uuid := cmd.String("uuid")
+	if uuid == "" {
+		fmt.Println("❌ UUID is required")
+		os.Exit(1)
+	}
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	name := cmd.String("name")
+	if name == "" {
+		fmt.Println("❌ attribute name is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		n, err := nodesmgr.GetByUUIDEnv(uuid, e.ID)
+		if err != nil {
+			return fmt.Errorf("error getting node - %w", err)
+		}
+		if err := nodesmgr.DeleteAttribute(n.ID, name); err != nil {
+			return fmt.Errorf("error deleting attribute - %w", err)
+		}
+		// Audit log
+		auditNodeAction(fmt.Sprintf("deleted attribute %s of node %s", name, uuid), n, e.ID)
+	} else if apiFlag {
+		if err := osctrlAPI.DeleteNodeAttribute(env, uuid, name); err != nil {
+			return fmt.Errorf("error deleting attribute - %w", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Println("✅ attribute was deleted successfully")
+	}
+	return nil
+}
+
+func moveNodes(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	uuids := cmd.StringSlice("uuid")
+	if len(uuids) == 0 {
+		fmt.Println("❌ UUID is required")
+		os.Exit(1)
+	}
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	to := cmd.String("to")
+	if to == "" {
+		fmt.Println("❌ target environment is required")
+		os.Exit(1)
+	}
+	var moves []nodes.NodeMove
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		target, err := envs.Get(to)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		var moving []nodes.OsqueryNode
+		for _, uuid := range uuids {
+			n, err := nodesmgr.GetByUUIDEnv(uuid, e.ID)
+			if err != nil {
+				return fmt.Errorf("error getting node %s - %w", uuid, err)
+			}
+			moving = append(moving, n)
+		}
+		if err := checkRedis(); err != nil {
+			return err
+		}
+		moves, err = nodesmgr.MoveToEnvironment(moving, target.ID, target.Name, getShellUsername())
+		if err != nil {
+			return fmt.Errorf("error moving nodes - %w", err)
+		}
+		for _, n := range moving {
+			if n.EnvironmentID == target.ID {
+				continue
+			}
+			if err := invalidateNodeCache(ctx, n.NodeKey); err != nil {
+				return err
+			}
+			n.Environment = target.Name
+			n.EnvironmentID = target.ID
+			if err := tagsmgr.ReapplyAutoTags(target.Name, n, getShellUsername()); err != nil {
+				return fmt.Errorf("error tagging node %s - %w", n.UUID, err)
+			}
+			// Audit log
+			msg := fmt.Sprintf("moved node %s from %s to %s", n.UUID, e.Name, target.Name)
+			auditNodeAction(msg, n, e.ID)
+			auditNodeAction(msg, n, target.ID)
+		}
+	} else if apiFlag {
+		var err error
+		moves, err = osctrlAPI.MoveNodes(env, uuids, to)
+		if err != nil {
+			return fmt.Errorf("error moving nodes - %w", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ %d nodes were moved to %s successfully\n", len(moves), to)
+	}
+	return nil
+}
+
+func revokeNodes(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	req := types.ApiNodeRevokeRequest{
+		UUIDs:        cmd.StringSlice("uuid"),
+		Tag:          cmd.String("tag"),
+		All:          cmd.Bool("all"),
+		Reason:       cmd.String("reason"),
+		RotateSecret: cmd.Bool("rotate-secret"),
+	}
+	if len(req.UUIDs) == 0 && req.Tag == "" && !req.All {
+		fmt.Println("❌ UUID, tag or all is required")
+		os.Exit(1)
+	}
+	var revoked types.ApiNodeRevokeResponse
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		var revoking []nodes.OsqueryNode
+		switch {
+		case req.All:
+			revoking, err = nodesmgr.GetByEnv(e.Name, nodes.AllNodes, 0)
+			if err != nil {
+				return fmt.Errorf("error getting nodes - %w", err)
+			}
+		case req.Tag != "":
+			tag, err := tagsmgr.Get(req.Tag, e.ID)
+			if err != nil {
+				return fmt.Errorf("error getting tag - %w", err)
+			}
+			tagged, err := tagsmgr.GetTaggedNodes(tag)
+			if err != nil {
+				return fmt.Errorf("error getting tagged nodes - %w", err)
+			}
+			var ids []uint
+			for _, t := range tagged {
+				ids = append(ids, t.NodeID)
+			}
+			found, err := nodesmgr.GetByIDs(ids)
+			if err != nil {
+				return fmt.Errorf("error getting nodes - %w", err)
+			}
+			for _, n := range found {
+				if n.EnvironmentID == e.ID {
+					revoking = append(revoking, n)
+				}
+			}
+		default:
+			for _, uuid := range req.UUIDs {
+				n, err := nodesmgr.GetByUUIDEnv(uuid, e.ID)
+				if err != nil {
+					return fmt.Errorf("error getting node %s - %w", uuid, err)
+				}
+				revoking = append(revoking, n)
+			}
+		}
+		if err := checkRedis(); err != nil {
+			return err
+		}
+		if _, err := nodesmgr.RevokeKeys(revoking, req.Reason, getShellUsername()); err != nil {
+			return fmt.Errorf("error revoking node keys - %w", err)
+		}
+		for _, n := range revoking {
+			if err := invalidateNodeCache(ctx, n.NodeKey); err != nil {
+				return err
+			}
+			revoked.Revoked = append(revoked.Revoked, n.UUID)
+			// Audit log
+			msg := fmt.Sprintf("revoked node key of %s (%s)", n.UUID, n.Hostname)
+			if req.Reason != "" {
+				msg += ": " + req.Reason
+			}
+			auditNodeAction(msg, n, e.ID)
+		}
+		if req.RotateSecret {
+			if err := envs.RotateSecret(e.Name); err != nil {
+				return fmt.Errorf("error rotating enroll secret - %w", err)
+			}
+			revoked.SecretRotated = true
+			auditlogsmgr.EnvAction(getShellUsername(), "rotated enroll secret of "+e.Name+" after revoking node keys", "CLI", e.ID)
+		}
+	} else if apiFlag {
+		var err error
+		revoked, err = osctrlAPI.RevokeNodes(env, req)
+		if err != nil {
+			return fmt.Errorf("error revoking node keys - %w", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ %d node keys were revoked successfully\n", len(revoked.Revoked))
+		if revoked.SecretRotated {
+			fmt.Println("✅ enroll secret was rotated, nodes need the new secret to enroll again")
+		}
+	}
+	return nil
+}
+
+func quarantineNode(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	uuid := cmd.String("uuid")
+	if uuid == "" {
+		fmt.Println("❌ UUID is required")
+		os.Exit(1)
+	}
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	reason := cmd.String("reason")
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		n, err := nodesmgr.GetByUUIDEnv(uuid, e.ID)
+		if err != nil {
+			return fmt.Errorf("error getting node - %w", err)
+		}
+		if err := checkRedis(); err != nil {
+			return err
+		}
+		if _, err := nodesmgr.Quarantine(n, reason, getShellUsername()); err != nil {
+			return fmt.Errorf("error quarantining node - %w", err)
+		}
+		if err := invalidateNodeCache(ctx, n.NodeKey); err != nil {
+			return err
+		}
+		// Audit log
+		auditlogsmgr.QuarantineAction(getShellUsername(), fmt.Sprintf("quarantined node %s (%s): %s", n.UUID, n.Hostname, reason), "CLI", e.ID)
+	} else if apiFlag {
+		if err := osctrlAPI.QuarantineNode(env, uuid, reason); err != nil {
+			return fmt.Errorf("error quarantining node - %w", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ node %s was quarantined successfully\n", uuid)
+	}
+	return nil
+}
+
+func releaseNode(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	uuid := cmd.String("uuid")
+	if uuid == "" {
+		fmt.Println("❌ UUID is required")
+		os.Exit(1)
+	}
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		n, err := nodesmgr.GetByUUIDEnv(uuid, e.ID)
+		if err != nil {
+			return fmt.Errorf("error getting node - %w", err)
+		}
+		if err := checkRedis(); err != nil {
+			return err
+		}
+		if _, err := nodesmgr.Release(n, getShellUsername()); err != nil {
+			return fmt.Errorf("error releasing node - %w", err)
+		}
+		if err := invalidateNodeCache(ctx, n.NodeKey); err != nil {
+			return err
+		}
+		// Audit log
+		auditlogsmgr.QuarantineAction(getShellUsername(), fmt.Sprintf("released node %s (%s) from quarantine", n.UUID, n.Hostname), "CLI", e.ID)
+	} else if apiFlag {
+		if err := osctrlAPI.ReleaseNode(env, uuid); err != nil {
+			return fmt.Errorf("error releasing node - %w", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ node %s was released from quarantine successfully\n", uuid)
+	}
+	return nil
+}
+
+func listQuarantinedNodes(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	var quarantined []nodes.QuarantinedNode
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		quarantined, err = nodesmgr.QuarantinedByEnv(e.ID)
+		if err != nil {
+			return fmt.Errorf("error getting quarantined nodes - %w", err)
+		}
+	} else if apiFlag {
+		quarantined, err = osctrlAPI.GetQuarantinedNodes(env)
+		if err != nil {
+			return fmt.Errorf("error getting quarantined nodes - %w", err)
+		}
+	}
+	header := []string{
+		"UUID",
+		"Hostname",
+		"Quarantined",
+		"Quarantined By",
+		"Reason",
+	}
+	var data [][]string
+	for _, q := range quarantined {
+		data = append(data, []string{q.Node.UUID, q.Node.Hostname, q.Quarantine.CreatedAt.String(), q.Quarantine.QuarantinedBy, q.Quarantine.Reason})
+	}
+	// Prepare output
+	switch formatFlag {
+	case jsonFormat:
+		jsonRaw, err := json.Marshal(quarantined)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %w", err)
+		}
+		fmt.Println(string(jsonRaw))
+	case csvFormat:
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writing csv - %w", err)
+		}
+	case prettyFormat:
+		table := tablewriter.NewWriter(os.Stdout)
+		table.Header(stringSliceToAnySlice(header)...)
+		if len(quarantined) > 0 {
+			fmt.Printf("Quarantined nodes in %s (%d):\n", env, len(quarantined))
+			if err := table.Bulk(data); err != nil {
+				return fmt.Errorf("❌ error bulk table - %w", err)
+			}
+		} else {
+			fmt.Printf("No quarantined nodes in %s\n", env)
+		}
+		if err := table.Render(); err != nil {
+			return fmt.Errorf("❌ error rendering table - %w", err)
+		}
+	}
+	return nil
+}
+
+func listApprovalNodes(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	status := nodes.ApprovalPending
+	if cmd.Bool("rejected") {
+		status = nodes.ApprovalRejected
+	}
+	var result []nodes.OsqueryNode
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		result, err = nodesmgr.GetByApproval(e.ID, status)
+		if err != nil {
+			return fmt.Errorf("error getting nodes - %w", err)
+		}
+	} else if apiFlag {
+		result, err = osctrlAPI.GetApprovalNodes(env, status)
+		if err != nil {
+			return fmt.Errorf("error getting nodes - %w", err)
+		}
+	}
+	header := []string{
+		"UUID",
+		"Hostname",
+		"Serial",
+		"Platform",
+		"IP Address",
+		"Enrolled",
+	}
+	var data [][]string
+	for _, n := range result {
+		data = append(data, []string{n.UUID, n.Hostname, n.HardwareSerial, n.Platform, n.IPAddress, n.CreatedAt.String()})
+	}
+	// Prepare output
+	switch formatFlag {
+	case jsonFormat:
+		jsonRaw, err := json.Marshal(result)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %w", err)
+		}
+		fmt.Println(string(jsonRaw))
+	case csvFormat:
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writing csv - %w", err)
+		}
+	case prettyFormat:
+		table := tablewriter.NewWriter(os.Stdout)
+		table.Header(stringSliceToAnySlice(header)...)
+		if len(result) > 0 {
+			fmt.Printf("Nodes %s in %s (%d):\n", status, env, len(result))
+			if err := table.Bulk(data); err != nil {
+				return fmt.Errorf("❌ error bulk table - %w", err)
+			}
+		} else {
+			fmt.Printf("No nodes %s in %s\n", status, env)
+		}
+		if err := table.Render(); err != nil {
+			return fmt.Errorf("❌ error rendering table - %w", err)
+		}
+	}
+	return nil
+}
+
+func approveNode(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	uuid := cmd.String("uuid")
+	if uuid == "" {
+		fmt.Println("❌ UUID is required")
+		os.Exit(1)
+	}
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		n, err := nodesmgr.GetByUUIDEnv(uuid, e.ID)
+		if err != nil {
+			return fmt.Errorf("error getting node - %w", err)
+		}
+		if err := checkRedis(); err != nil {
+			return err
+		}
+		if err := nodesmgr.Approve(n, getShellUsername()); err != nil {
+			return fmt.Errorf("error approving node - %w", err)
+		}
+		if err := invalidateNodeCache(ctx, n.NodeKey); err != nil {
+			return err
+		}
+		// Audit log
+		auditNodeAction(fmt.Sprintf("approved node %s (%s)", n.UUID, n.Hostname), n, e.ID)
+	} else if apiFlag {
+		if err := osctrlAPI.ApproveNode(env, uuid); err != nil {
+			return fmt.Errorf("error approving node - %w", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ node %s was approved successfully\n", uuid)
+	}
+	return nil
+}
+
+func rejectNode(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	uuid := cmd.String("uuid")
+	if uuid == "" {
+		fmt.Println("❌ UUID is required")
+		os.Exit(1)
+	}
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		n, err := nodesmgr.GetByUUIDEnv(uuid, e.ID)
+		if err != nil {
+			return fmt.Errorf("error getting node - %w", err)
+		}
+		if err := checkRedis(); err != nil {
+			return err
+		}
+		if err := nodesmgr.Reject(n, getShellUsername()); err != nil {
+			return fmt.Errorf("error rejecting node - %w", err)
+		}
+		if err := invalidateNodeCache(ctx, n.NodeKey); err != nil {
+			return err
+		}
+		// Audit log
+		auditNodeAction(fmt.Sprintf("rejected node %s (%s)", n.UUID, n.Hostname), n, e.ID)
+	} else if apiFlag {
+		if err := osctrlAPI.RejectNode(env, uuid); err != nil {
+			return fmt.Errorf("error rejecting node - %w", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ node %s was rejected successfully\n", uuid)
+	}
+	return nil
+}
+
+func listApprovalRules(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	var rules []nodes.ApprovalRule
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		rules, err = nodesmgr.ApprovalRules(e.ID)
+		if err != nil {
+			return fmt.Errorf("error getting approval rules - %w", err)
+		}
+	} else if apiFlag {
+		rules, err = osctrlAPI.GetApprovalRules(env)
+		if err != nil {
+			return fmt.Errorf("error getting approval rules - %w", err)
+		}
+	}
+	header := []string{
+		"ID",
+		"Type",
+		"Pattern",
+		"Created By",
+		"Created",
+	}
+	var data [][]string
+	for _, r := range rules {
+		data = append(data, []string{strconv.FormatUint(uint64(r.ID), 10), r.Type, r.Pattern, r.CreatedBy, r.CreatedAt.String()})
+	}
+	// Prepare output
+	switch formatFlag {
+	case jsonFormat:
+		jsonRaw, err := json.Marshal(rules)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %w", err)
+		}
+		fmt.Println(string(jsonRaw))
+	case csvFormat:
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writing csv - %w", err)
+		}
+	case prettyFormat:
+		table := tablewriter.NewWriter(os.Stdout)
+		table.Header(stringSliceToAnySlice(header)...)
+		if len(rules) > 0 {
+			fmt.Printf("Approval rules in %s (%d):\n", env, len(rules))
+			if err := table.Bulk(data); err != nil {
+				return fmt.Errorf("❌ error bulk table - %w", err)
+			}
+		} else {
+			fmt.Printf("No approval rules in %s\n", env)
+		}
+		if err := table.Render(); err != nil {
+			return fmt.Errorf("❌ error rendering table - %w", err)
+		}
+	}
+	return nil
+}
+
+func addApprovalRule(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	serial := cmd.String("serial")
+	hostname := cmd.String("hostname")
+	if (serial == "") == (hostname == "") {
+		fmt.Println("❌ one of serial or hostname is required")
+		os.Exit(1)
+	}
+	ruleType, pattern := nodes.ApprovalRuleSerial, serial
+	if hostname != "" {
+		ruleType, pattern = nodes.ApprovalRuleHostname, hostname
+	}
+	var approved []string
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		rule, err := nodesmgr.CreateApprovalRule(nodes.ApprovalRule{
+			EnvironmentID: e.ID,
+			Type:          ruleType,
+			Pattern:       pattern,
+			CreatedBy:     getShellUsername(),
+		})
+		if err != nil {
+			return fmt.Errorf("error creating approval rule - %w", err)
+		}
+		matched, err := nodesmgr.ApproveByRule(rule, getShellUsername())
+		if err != nil {
+			return fmt.Errorf("error approving nodes - %w", err)
+		}
+		for _, n := range matched {
+			approved = append(approved, n.UUID)
+		}
+		// Audit log
+		auditlogsmgr.EnvAction(getShellUsername(), fmt.Sprintf("created approval rule %s in %s approving %d nodes", rule.String(), e.Name, len(approved)), "CLI", e.ID)
+	} else if apiFlag {
+		r, err := osctrlAPI.CreateApprovalRule(env, ruleType, pattern)
+		if err != nil {
+			return fmt.Errorf("error creating approval rule - %w", err)
+		}
+		approved = r.Approved
+	}
+	if !silentFlag {
+		fmt.Printf("✅ approval rule %s %s was created successfully\n", ruleType, pattern)
+		if len(approved) > 0 {
+			fmt.Printf("Approved pending nodes: %s\n", strings.Join(approved, ", "))
+		}
+	}
+	return nil
+}
+
+func deleteApprovalRule(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	id := uint(cmd.Uint("id"))
+	if id == 0 {
+		fmt.Println("❌ approval rule ID is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		if err := nodesmgr.DeleteApprovalRule(e.ID, id); err != nil {
+			return fmt.Errorf("error deleting approval rule - %w", err)
+		}
+		// Audit log
+		auditlogsmgr.EnvAction(getShellUsername(), fmt.Sprintf("deleted approval rule %d in %s", id, e.Name), "CLI", e.ID)
+	} else if apiFlag {
+		if err := osctrlAPI.DeleteApprovalRule(env, id); err != nil {
+			return fmt.Errorf("error deleting approval rule - %w", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ approval rule %d was deleted successfully\n", id)
+	}
+	return nil
+}
+
+func listConfigOverlays(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	var overlays []environments.ConfigOverlay
+	var err error
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		overlays, err = envs.Overlays(e.ID)
+		if err != nil {
+			return fmt.Errorf("error getting overlays - %w", err)
+		}
+	} else if apiFlag {
+		overlays, err = osctrlAPI.GetConfigOverlays(env)
+		if err != nil {
+			return fmt.Errorf("error getting overlays - %w", err)
+		}
+	}
+	header := []string{
+		"Name",
+		"Target Type",
+		"Target",
+		"Priority",
+		"Updated",
+	}
+	var data [][]string
+	for _, o := range overlays {
+		data = append(data, []string{o.Name, o.TargetType, o.Target, fmt.Sprint(o.Priority), o.UpdatedAt.String()})
+	}
+	// Prepare output
+	switch formatFlag {
+	case jsonFormat:
+		jsonRaw, err := json.Marshal(overlays)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %w", err)
+		}
+		fmt.Println(string(jsonRaw))
+	case csvFormat:
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writing csv - %w", err)
+		}
+	case prettyFormat:
+		table := tablewriter.NewWriter(os.Stdout)
+		table.Header(stringSliceToAnySlice(header)...)
+		if len(overlays) > 0 {
+			fmt.Printf("Configuration overlays in %s (%d):\n", env, len(overlays))
+			if err := table.Bulk(data); err != nil {
+				return fmt.Errorf("❌ error bulk table - %w", err)
+			}
+		} else {
+			fmt.Printf("No configuration overlays in %s\n", env)
+		}
+		if err := table.Render(); err != nil {
+			return fmt.Errorf("❌ error rendering table - %w", err)
+		}
+	}
+	return nil
+}
+
+func setConfigOverlay(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	name := cmd.String("name")
+	if name == "" {
+		fmt.Println("❌ overlay name is required")
+		os.Exit(1)
+	}
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	file := cmd.String("file")
+	if file == "" {
+		fmt.Println("❌ configuration file is required")
+		os.Exit(1)
+	}
+	conf, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("error reading file - %w", err)
+	}
+	request := types.ApiConfigOverlayRequest{
+		TargetType:    cmd.String("target-type"),
+		Target:        cmd.String("target"),
+		Priority:      int(cmd.Int("priority")),
+		Configuration: json.RawMessage(conf),
+		Force:         cmd.Bool("force"),
+	}
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		overlay := environments.ConfigOverlay{
+			EnvironmentID: e.ID,
+			Name:          name,
+			TargetType:    request.TargetType,
+			Target:        request.Target,
+			Priority:      request.Priority,
+			Configuration: string(conf),
+			CreatedBy:     getShellUsername(),
+		}
+		versions, err := nodesmgr.GetEnvOsqueryVersions(e.ID)
+		if err != nil {
+			return err
+		}
+		validation := environments.ValidateOverlayConfig(overlay, versions)
+		if err := validation.Err(); err != nil && !request.Force {
+			return err
+		}
+		if !silentFlag {
+			for _, w := range validation.Messages(request.Force) {
+				fmt.Printf("⚠️  %s\n", w)
+			}
+		}
+		if _, err := envs.SetOverlay(overlay); err != nil {
+			return fmt.Errorf("error setting overlay - %w", err)
+		}
+		// Audit log
+		auditlogsmgr.EnvAction(getShellUsername(), fmt.Sprintf("set configuration overlay %s of %s for %s %s", name, e.Name, overlay.TargetType, overlay.Target), "CLI", e.ID)
+	} else if apiFlag {
+		resp, err := osctrlAPI.SetConfigOverlay(env, name, request)
+		if err != nil {
+			return fmt.Errorf("error setting overlay - %w", err)
+		}
+		if !silentFlag {
+			for _, w := range resp.Warnings {
+				fmt.Printf("⚠️  %s\n", w)
+			}
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ overlay %s was set successfully\n", name)
+	}
+	return nil
+}
+
+func deleteConfigOverlay(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	name := cmd.String("name")
+	if name == "" {
+		fmt.Println("❌ overlay name is required")
+		os.Exit(1)
+	}
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		if err := envs.DeleteOverlay(e.ID, name); err != nil {
+			return fmt.Errorf("error deleting overlay - %w", err)
+		}
+		// Audit log
+		auditlogsmgr.EnvAction(getShellUsername(), fmt.Sprintf("deleted configuration overlay %s of %s", name, e.Name), "CLI", e.ID)
+	} else if apiFlag {
+		if err := osctrlAPI.DeleteConfigOverlay(env, name); err != nil {
+			return fmt.Errorf("error deleting overlay - %w", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ overlay %s was deleted successfully\n", name)
+	}
+	return nil
+}
+
+func showNodeConfig(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	uuid := cmd.String("uuid")
+	if uuid == "" {
+		fmt.Println("❌ UUID is required")
+		os.Exit(1)
+	}
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	var conf types.ApiNodeConfigResponse
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		n, err := nodesmgr.GetByUUIDEnv(uuid, e.ID)
+		if err != nil {
+			return fmt.Errorf("error getting node - %w", err)
+		}
+		conf.Overlays = []string{}
+		conf.Quarantined = n.Quarantined
+		conf.Approval = n.Approval
+		effective := ""
+		if !n.Approved() {
+			effective = nodes.UnapprovedConfiguration
+		} else if n.Quarantined {
+			effective, err = envs.QuarantineConfiguration(e)
+			if err != nil {
+				return fmt.Errorf("error generating quarantine configuration - %w", err)
+			}
+		} else {
+			overlays, err := envs.Overlays(e.ID)
+			if err != nil {
+				return fmt.Errorf("error getting overlays - %w", err)
+			}
+			tagNames, err := tagsmgr.GetTagNames(n)
+			if err != nil {
+				return fmt.Errorf("error getting tags - %w", err)
+			}
+			matched := environments.MatchOverlays(overlays, nodes.PlatformNames(n.Platform), tagNames)
+			for _, o := range matched {
+				conf.Overlays = append(conf.Overlays, o.Name)
+			}
+			effective, err = environments.ApplyOverlays(e.Configuration, matched)
+			if err != nil {
+				return fmt.Errorf("error applying overlays - %w", err)
+			}
+		}
+		conf.Configuration = json.RawMessage(effective)
+	} else if apiFlag {
+		var err error
+		conf, err = osctrlAPI.GetNodeConfig(env, uuid)
+		if err != nil {
+			return fmt.Errorf("error getting node configuration - %w", err)
+		}
+	}
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(conf)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %w", err)
+		}
+		fmt.Println(string(jsonRaw))
+		return nil
+	}
+	if conf.Approval == nodes.ApprovalPending {
+		fmt.Printf("⚠️ node %s is pending approval and gets an empty configuration\n", uuid)
+	} else if conf.Approval == nodes.ApprovalRejected {
+		fmt.Printf("⚠️ node %s was rejected and gets an empty configuration\n", uuid)
+	} else if conf.Quarantined {
+		fmt.Printf("⚠️ node %s is in quarantine and gets the incident response configuration\n", uuid)
+	} else if len(conf.Overlays) > 0 {
+		fmt.Printf("Overlays applied to %s: %s\n", uuid, strings.Join(conf.Overlays, ", "))
+	} else {
+		fmt.Printf("No overlays applied to %s\n", uuid)
+	}
+	fmt.Println(string(conf.Configuration))
+	return nil
+}
+
+func showDriftedNodes(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	var report nodes.DriftReport
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		report.Intervals = settingsmgr.DriftIntervals(e.ID)
+		report.ConfigInterval = e.ConfigInterval
+		report.Cutoff = nodes.DriftCutoff(e.ConfigInterval, report.Intervals, time.Now())
+		report.Drifted, err = nodesmgr.Drifted(e.ID, report.Cutoff)
+		if err != nil {
+			return fmt.Errorf("error getting drifted nodes - %w", err)
+		}
+		report.Count = len(report.Drifted)
+	} else if apiFlag {
+		report, err = osctrlAPI.GetDriftedNodes(env)
+		if err != nil {
+			return fmt.Errorf("error getting drifted nodes - %w", err)
+		}
+	}
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(report)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %w", err)
+		}
+		fmt.Println(string(jsonRaw))
+		return nil
+	}
+	if report.Count == 0 {
+		fmt.Printf("No nodes with configuration drift in %s\n", env)
+		return nil
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header("UUID", "Hostname", "Reported Hash", "Expected Hash", "Served", "Last Seen")
+	data := [][]string{}
+	for _, n := range report.Drifted {
+		data = append(data, []string{
+			n.UUID,
+			n.Hostname,
+			n.ConfigHash,
+			n.ExpectedConfigHash,
+			n.ExpectedConfigAt.Format(time.RFC3339),
+			n.LastSeen.Format(time.RFC3339),
+		})
+	}
+	if err := table.Bulk(data); err != nil {
+		return fmt.Errorf("❌ error bulk table - %w", err)
+	}
+	if err := table.Render(); err != nil {
+		return fmt.Errorf("❌ error rendering table - %w", err)
+	}
+	fmt.Printf("%d nodes disagree with their configuration for over %d config intervals\n", report.Count, report.Intervals)
+	return nil
+}
+
+// readAttributeRecords reads attribute records from a CSV or JSON file,
+// picking the format by the file extension
+func readAttributeRecords(file string) ([]nodes.AttributeRecord, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file - %w", err)
+	}
+	defer f.Close()
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		return nodes.ParseAttributeJSON(f)
+	}
+	return nodes.ParseAttributeCSV(f)
+}
+
+func importNodeAttributes(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	file := cmd.String("file")
+	if file == "" {
+		fmt.Println("❌ file is required")
+		os.Exit(1)
+	}
+	records, err := readAttributeRecords(file)
+	if err != nil {
+		return err
+	}
+	var result nodes.AttributeImportResult
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %w", err)
+		}
+		result, err = nodesmgr.ImportAttributes(e.ID, records, getShellUsername())
+		if err != nil {
+			return fmt.Errorf("error importing attributes - %w", err)
+		}
+		// Audit log
+		auditlogsmgr.NodeAction(getShellUsername(), fmt.Sprintf("imported %d attributes for %d nodes", result.Updated, result.Nodes), "CLI", e.ID)
+	} else if apiFlag {
+		result, err = osctrlAPI.ImportNodeAttributes(env, records)
+		if err != nil {
+			return fmt.Errorf("error importing attributes - %w", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ %d attributes were set on %d nodes from %d records\n", result.Updated, result.Nodes, result.Records)
+		for _, key := range result.Unmatched {
+			fmt.Printf("⚠️  no node matches %s\n", key)
+		}
+	}
+	return nil
+}
@@ -214,7 +214,7 @@ func (s *shellState) argCandidates(tokens []string, line string, cursor int) ([]
 	// resource-name completion for the active module's primary key
 	switch s.ctx {
 	case "nodes":
-		if cmd == "show" || cmd == "delete" || cmd == "tag" {
+		if cmd == "show" || cmd == "delete" || cmd == "tag" || cmd == "console" {
 			c := filterPrefix(s.nodeKeys, currentWord(line, cursor))
 			return c, longestCommonPrefix(c)
 		}
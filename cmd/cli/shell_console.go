@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/console"
+)
+
+// shell_console.go — remote node console inside the interactive shell. Every
+// line is sent to the console API of osctrl-api and Tab completes verbs,
+// paths and osquery tables and columns through its completion endpoint.
+
+// consolePollInterval is how often a running console command is checked
+const consolePollInterval = 500 * time.Millisecond
+
+// consoleShell is an open console session on one node
+type consoleShell struct {
+	api         *OsctrlAPI
+	env         string
+	host        string
+	session     console.Session
+	osqueryMode bool
+	rl          *lineEditor
+}
+
+func shNodesConsole(s *shellState, args []string) {
+	if !s.requireEnv() {
+		return
+	}
+	store, ok := s.store.(*apiStore)
+	if !ok {
+		errf("the node console is only available with --api")
+		return
+	}
+	n, err := spinGet("🖥️  Fetching node", func() (nodeRow, error) { return s.store.Node(s.env, args[0]) })
+	if err != nil {
+		errf("%v", err)
+		return
+	}
+	session, err := spinGet("🖥️  Opening console", func() (console.Session, error) { return store.api.OpenConsole(s.env, n.UUID) })
+	if err != nil {
+		errf("%v", err)
+		return
+	}
+	c := &consoleShell{api: store.api, env: s.env, host: n.Hostname, session: session}
+	c.rl = &lineEditor{complete: c.completer}
+	fmt.Println(paint(cDim, "console on "+n.Hostname+", type help for commands and exit to leave"))
+	c.loop()
+	if err := c.api.CloseConsole(c.env, c.session.ID); err != nil {
+		errf("%v", err)
+	}
+}
+
+func (c *consoleShell) prompt() string {
+	if c.osqueryMode {
+		return paint(cMagenta, "osquery") + paint(cGreen, "> ")
+	}
+	return paint(cCyan, c.host) + ":" + paint(cYellow, c.session.CWD) + paint(cGreen, " $ ")
+}
+
+func (c *consoleShell) loop() {
+	for {
+		line, err := c.rl.readline(c.prompt())
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if errors.Is(err, errInterrupt) {
+			continue
+		}
+		if err != nil {
+			errf("%v", err)
+			return
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !c.osqueryMode && (line == "exit" || line == "quit") {
+			return
+		}
+		c.run(line)
+	}
+}
+
+// run sends one line to the session and prints its outcome, waiting for
+// the node when the command is remote
+func (c *consoleShell) run(input string) {
+	command, parsed, err := c.api.RunConsoleCommand(c.env, c.session.ID, input, c.osqueryMode)
+	if err != nil {
+		errf("%v", err)
+		return
+	}
+	switch parsed.Kind {
+	case console.CommandMode, console.CommandExitMode:
+		c.osqueryMode = parsed.Kind == console.CommandMode
+		fmt.Println(paint(cDim, parsed.Message))
+		return
+	case console.CommandCarve:
+		okf("%s", parsed.Message)
+		return
+	case console.CommandLocal:
+		if parsed.Command == "clear" {
+			fmt.Print("\x1b[H\x1b[2J")
+		} else if parsed.Output != "" {
+			fmt.Println(parsed.Output)
+		}
+		return
+	}
+	for command.Status == console.StatusQueued || command.Status == console.StatusDelivered {
+		time.Sleep(consolePollInterval)
+		if command, err = c.api.GetConsoleCommand(c.env, c.session.ID, command.ID); err != nil {
+			errf("%v", err)
+			return
+		}
+	}
+	if command.Status != console.StatusCompleted {
+		if command.Error == "" {
+			command.Error = "command " + command.Status
+		}
+		errf("%s", command.Error)
+		return
+	}
+	rows, err := c.api.GetConsoleResults(c.env, c.session.ID, command.ID)
+	if err != nil {
+		errf("%v", err)
+		return
+	}
+	printConsoleRows(rows)
+	if parsed.Command == "cd" {
+		if session, err := c.api.GetConsole(c.env, c.session.ID); err == nil {
+			c.session = session
+		}
+	}
+}
+
+// completer asks the API to complete the input up to the cursor. The API
+// completes the text after the last SQL separator, which may be shorter
+// than the word the line editor replaces, so candidates keep that part.
+func (c *consoleShell) completer(line string, cursor int) ([]string, string) {
+	input := string([]rune(line)[:cursor])
+	completion, err := c.api.CompleteConsole(c.env, c.session.ID, input, c.osqueryMode)
+	if err != nil || completion.Status != console.CompletionReady {
+		return nil, ""
+	}
+	word := currentWord(input, len(input))
+	if !strings.HasSuffix(word, completion.Token) {
+		return nil, ""
+	}
+	kept := word[:len(word)-len(completion.Token)]
+	cands := make([]string, 0, len(completion.Candidates))
+	for _, candidate := range completion.Candidates {
+		cands = append(cands, kept+candidate.Value)
+	}
+	if len(cands) == 1 {
+		return cands, cands[0]
+	}
+	if len(completion.Common) > len(completion.Token) {
+		return cands, kept + completion.Common
+	}
+	return cands, ""
+}
+
+func printConsoleRows(rows []map[string]any) {
+	if len(rows) == 0 {
+		fmt.Println(paint(cDim, "no rows"))
+		return
+	}
+	seen := map[string]bool{}
+	var headers []string
+	for _, row := range rows {
+		for column := range row {
+			if !seen[column] {
+				seen[column] = true
+				headers = append(headers, column)
+			}
+		}
+	}
+	sort.Strings(headers)
+	table := make([][]string, 0, len(rows))
+	for _, row := range rows {
+		cells := make([]string, 0, len(headers))
+		for _, column := range headers {
+			value := ""
+			if v, ok := row[column]; ok && v != nil {
+				value = fmt.Sprint(v)
+			}
+			cells = append(cells, value)
+		}
+		table = append(table, cells)
+	}
+	printTable(headers, table)
+}
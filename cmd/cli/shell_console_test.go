@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/jmpsec/osctrl/pkg/console"
+)
+
+// TestConsoleCompleterUsesAPICompletion confirms Tab in the node console
+// asks the API and keeps the part of the word before the SQL token.
+func TestConsoleCompleterUsesAPICompletion(t *testing.T) {
+	var inputs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/console/dev/sessions/7/complete" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		inputs = append(inputs, r.URL.Query().Get("input")+"|"+r.URL.Query().Get("osquery_mode"))
+		completion := console.Completion{Status: console.CompletionReady, Token: "na", Common: "na", Candidates: []console.Candidate{
+			{Value: "name", Kind: console.CandidateColumn},
+			{Value: "namespace", Kind: console.CandidateColumn},
+		}}
+		if r.URL.Query().Get("osquery_mode") == "false" {
+			completion = console.Completion{Status: console.CompletionReady, Token: "/et", Common: "/etc/", Candidates: []console.Candidate{
+				{Value: "/etc/", Kind: console.CandidateDirectory},
+			}}
+		}
+		_ = json.NewEncoder(w).Encode(completion)
+	}))
+	defer srv.Close()
+
+	c := &consoleShell{api: CreateAPI(JSONConfigurationAPI{URL: srv.URL, Token: "token"}, false), env: "dev", session: console.Session{ID: 7}}
+	c.osqueryMode = true
+	cands, common := c.completer("select count(na", 15)
+	if want := []string{"count(name", "count(namespace"}; !reflect.DeepEqual(cands, want) {
+		t.Fatalf("candidates = %q, want %q", cands, want)
+	}
+	if common != "" {
+		t.Fatalf("common = %q, want no change for an ambiguous prefix", common)
+	}
+
+	c.osqueryMode = false
+	cands, common = c.completer("ls /et", 6)
+	if len(cands) != 1 || common != "/etc/" {
+		t.Fatalf("got %q and %q, want the single directory", cands, common)
+	}
+	if want := []string{"select count(na|true", "ls /et|false"}; !reflect.DeepEqual(inputs, want) {
+		t.Fatalf("inputs = %q, want %q", inputs, want)
+	}
+}
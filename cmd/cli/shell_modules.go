@@ -22,6 +22,7 @@ func nodesCommands() []shellCmd {
 		{name: "show", args: "<uuid|hostname>", help: "show node detail", min: 1, fn: shNodesShow},
 		{name: "delete", aliases: "rm", args: "<uuid>", help: "delete (archive) a node", min: 1, fn: shNodesDelete},
 		{name: "tag", args: "<uuid> <tag>", help: "apply a tag to a node", min: 2, fn: shNodesTag},
+		{name: "console", args: "<uuid|hostname>", help: "open a remote console on a node (api only)", min: 1, fn: shNodesConsole},
 	}
 }
 
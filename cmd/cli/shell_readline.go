@@ -192,8 +192,8 @@ func (le *lineEditor) doCompletion(out *os.File, buf *[]rune, cursor *int, redra
 		newBuf = append(newBuf, (*buf)[*cursor:]...)
 		*buf = newBuf
 		*cursor = start + len([]rune(common))
-		if len(cands) == 1 {
-			// add trailing space for a completed word
+		if len(cands) == 1 && !strings.HasSuffix(common, "/") && !strings.HasSuffix(common, `\`) {
+			// add trailing space for a completed word, but not a directory
 			*buf = append(*buf, ' ')
 			*cursor++
 		}
@@ -624,7 +624,7 @@ func (s *apiStore) DeleteQuery(env, name string) error {
 }
 
 func (s *apiStore) RunCarve(req runQueryReq) error {
-	_, err := s.api.RunCarve(req.Env, req.Query, req.UUIDs, req.Hosts, req.Platforms, req.Tags, req.Hidden, req.ExpHours)
+	_, err := s.api.RunCarve(req.Env, req.Query, req.UUIDs, req.Hosts, req.Platforms, req.Tags, req.Hidden, req.ExpHours, nil)
 	return err
 }
 func (s *apiStore) CompleteCarve(env, name string) error {
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/console"
+	"github.com/urfave/cli/v3"
+)
+
+func exportTranscript(ctx context.Context, cmd *cli.Command) error {
+	// Get values from flags
+	env := cmd.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	session := cmd.Uint("session")
+	if session == 0 {
+		fmt.Println("❌ console session is required")
+		os.Exit(1)
+	}
+	format := cmd.String("format")
+	if format != "json" && format != "cast" {
+		fmt.Println("❌ format must be json or cast")
+		os.Exit(1)
+	}
+	output := cmd.String("output")
+	if output == "" {
+		output = fmt.Sprintf("osctrl-console-%d.%s", session, format)
+	}
+	if dbFlag {
+		// Transcripts are signed with the key of osctrl-api
+		return fmt.Errorf("❌ transcripts can only be exported with --api")
+	}
+	raw, err := osctrlAPI.ExportTranscript(env, uint(session), format)
+	if err != nil {
+		return fmt.Errorf("❌ error exporting transcript - %w", err)
+	}
+	if err := os.WriteFile(output, raw, 0600); err != nil {
+		return fmt.Errorf("❌ error writing %s - %w", output, err)
+	}
+	if !silentFlag {
+		fmt.Printf("✅ transcript of session %d written to %s\n", session, output)
+	}
+	return nil
+}
+
+// replayTranscript runs without a DB or API connection: it only verifies the
+// signature of a transcript file and plays it back in the terminal.
+func replayTranscript(ctx context.Context, cmd *cli.Command) error {
+	file := cmd.String("file")
+	if file == "" {
+		fmt.Println("❌ transcript file is required")
+		os.Exit(1)
+	}
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("❌ error reading %s - %w", file, err)
+	}
+	var trusted ed25519.PublicKey
+	if keyFile := cmd.String("public-key"); keyFile != "" {
+		rawKey, err := os.ReadFile(keyFile)
+		if err != nil {
+			return fmt.Errorf("❌ error reading %s - %w", keyFile, err)
+		}
+		if trusted, err = console.ParseTranscriptPublicKey(rawKey); err != nil {
+			return fmt.Errorf("❌ %w", err)
+		}
+	}
+	title, keyID, events, err := loadTranscriptEvents(raw, trusted)
+	if err != nil {
+		return fmt.Errorf("❌ error verifying transcript - %w", err)
+	}
+	if !silentFlag {
+		fmt.Printf("✅ %s\n", console.SanitizeTerminal(title))
+		if trusted != nil {
+			fmt.Printf("✅ signature verified with %s (key %s)\n", cmd.String("public-key"), keyID)
+		} else {
+			fmt.Printf("⚠️  signature verified with the embedded key %s, use --public-key to check the signer\n", keyID)
+		}
+		fmt.Println()
+	}
+	return playCastEvents(ctx, os.Stdout, events, cmd.Float64("speed"), cmd.Duration("max-wait"))
+}
+
+// loadTranscriptEvents verifies a signed JSON transcript or a signed cast
+// and returns its title, signing key and terminal events.
+func loadTranscriptEvents(raw []byte, trusted ed25519.PublicKey) (string, string, []console.CastEvent, error) {
+	var signed console.SignedTranscript
+	if err := json.Unmarshal(raw, &signed); err == nil && len(signed.Transcript) > 0 {
+		t, err := console.VerifyTranscript(signed, trusted)
+		if err != nil {
+			return "", "", nil, err
+		}
+		title := fmt.Sprintf("session %d by %s in %s, exported by %s at %s", t.Session.ID, t.Session.Creator, t.Environment, t.ExportedBy, t.ExportedAt.Format(time.RFC3339))
+		return title, signed.KeyID, console.CastEvents(t), nil
+	}
+	header, events, err := console.VerifyCast(bytes.TrimLeft(raw, " \t\r\n"), trusted)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return header.Title, header.Osctrl.KeyID, events, nil
+}
+
+// playCastEvents writes events to out keeping their timing, scaled by speed
+// and with pauses capped at maxWait. A zero speed writes them at once. The
+// output comes from nodes, so escape sequences and control characters are
+// removed before it reaches the terminal.
+func playCastEvents(ctx context.Context, out io.Writer, events []console.CastEvent, speed float64, maxWait time.Duration) error {
+	last := 0.0
+	for _, event := range events {
+		if speed > 0 {
+			wait := time.Duration((event.Time - last) / speed * float64(time.Second))
+			if maxWait > 0 && wait > maxWait {
+				wait = maxWait
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+		last = event.Time
+		if _, err := io.WriteString(out, console.SanitizeTerminal(event.Data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
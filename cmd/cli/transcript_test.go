@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/console"
+)
+
+func TestReplayTranscriptFormats(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Now().Add(-time.Minute)
+	completed := created.Add(2 * time.Second)
+	transcript := console.Transcript{
+		Version: console.TranscriptVersion,
+		Session: console.Session{ID: 7, Creator: "alice", NodeUUID: "NODE-UUID", CreatedAt: created},
+		Entries: []console.TranscriptEntry{{
+			Command: console.Command{Input: "ps", Status: console.StatusCompleted, CreatedAt: created, CompletedAt: &completed},
+			Nodes:   []console.NodeResults{{Results: []map[string]any{{"pid": "1", "name": "init"}}}},
+		}},
+	}
+	signer := console.NewTranscriptSigner(private)
+	signed, err := signer.Sign(transcript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawJSON, err := json.Marshal(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cast, err := signer.SignCast(transcript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, raw := range map[string][]byte{"json": rawJSON, "cast": cast} {
+		_, keyID, events, err := loadTranscriptEvents(raw, public)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if keyID != signer.KeyID() {
+			t.Errorf("%s: key id = %s; want %s", name, keyID, signer.KeyID())
+		}
+		var out bytes.Buffer
+		if err := playCastEvents(context.Background(), &out, events, 0, 0); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(out.Bytes(), []byte("alice@NODE-UUID$ ps")) || !bytes.Contains(out.Bytes(), []byte("init")) {
+			t.Errorf("%s: unexpected replay output %q", name, out.String())
+		}
+	}
+
+	var out bytes.Buffer
+	escaped := []console.CastEvent{{Data: "\x1b]0;owned\x07\x1b[31mroot\x1b[0m\r\n"}}
+	if err := playCastEvents(context.Background(), &out, escaped, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "root\r\n" {
+		t.Errorf("escape sequences were replayed: %q", out.String())
+	}
+
+	tampered := bytes.Replace(rawJSON, []byte("alice"), []byte("mallory"), 1)
+	if _, _, _, err := loadTranscriptEvents(tampered, nil); err == nil {
+		t.Error("tampered transcript was verified")
+	}
+}
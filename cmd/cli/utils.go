@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"os"
 
+	"github.com/jmpsec/osctrl/pkg/cache"
 	"github.com/jmpsec/osctrl/pkg/nodes"
 	"github.com/jmpsec/osctrl/pkg/utils"
 )
@@ -42,3 +45,38 @@ func getShellUsername() string {
 	}
 	return user
 }
+
+// Helper to write an action against a node to the audit log, highlighted as a
+// quarantine action when the node is in quarantine
+func auditNodeAction(action string, n nodes.OsqueryNode, envID uint) {
+	if n.Quarantined {
+		auditlogsmgr.QuarantineAction(getShellUsername(), action, "CLI", envID)
+		return
+	}
+	auditlogsmgr.NodeAction(getShellUsername(), action, "CLI", envID)
+}
+
+// Helper to connect to redis, needed to invalidate nodes cached by osctrl-tls
+// when changing them directly in the DB
+func checkRedis() error {
+	if redismgr != nil {
+		return nil
+	}
+	rm, err := cache.CreateRedisManager(*redisConfig)
+	if err != nil {
+		return fmt.Errorf("error connecting to redis to invalidate cached nodes, use the API instead - %w", err)
+	}
+	redismgr = rm
+	return nil
+}
+
+// Helper to invalidate a node cached by osctrl-tls, the same way the API does
+func invalidateNodeCache(ctx context.Context, nodeKey string) error {
+	if err := checkRedis(); err != nil {
+		return err
+	}
+	if err := redismgr.Client.Set(ctx, nodes.RedisNodeInvalidatePrefix+nodeKey, nodes.NodeInvalidationVersion(), nodes.NodeInvalidateTTL).Err(); err != nil {
+		return fmt.Errorf("error invalidating cached node - %w", err)
+	}
+	return nil
+}
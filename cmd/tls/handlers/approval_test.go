@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/auditlog"
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/queries"
+	"github.com/jmpsec/osctrl/pkg/tags"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestPendingNodeGetsEmptyConfigUntilApproved(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	require.NoError(t, err)
+	envs := environments.CreateEnvironment(db)
+	nodesMgr := nodes.CreateNodes(db)
+	queryManager := queries.CreateQueries(db)
+	env := environments.TLSEnvironment{
+		UUID:            "33333333-3333-4333-8333-333333333333",
+		Name:            "env",
+		Secret:          "environment-secret",
+		AcceptEnrolls:   true,
+		RequireApproval: true,
+		Configuration:   `{"schedule":{"regular":{"query":"SELECT 1;","interval":3600}}}`,
+	}
+	require.NoError(t, db.Create(&env).Error)
+	_, err = nodesMgr.CreateApprovalRule(nodes.ApprovalRule{EnvironmentID: env.ID, Type: nodes.ApprovalRuleSerial, Pattern: "TRUSTED-SERIAL"})
+	require.NoError(t, err)
+
+	handler := CreateHandlersTLS(
+		WithEnvs(envs),
+		WithEnvCache(environments.NewEnvCache(*envs)),
+		WithNodes(nodesMgr),
+		WithQueries(queryManager),
+		WithTags(tags.CreateTagManager(db)),
+		WithAuditLog(&auditlog.AuditLogManager{}),
+		WithWriteHandler(NewBatchWriter(100, time.Hour, 10, *nodesMgr)),
+	)
+	enroll := func(uuid, serial string) string {
+		var req types.EnrollRequest
+		req.EnrollSecret = env.Secret
+		req.HostIdentifier = uuid
+		req.HostDetails.EnrollSystemInfo.HardwareSerial = serial
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+		r := httptest.NewRequest(http.MethodPost, "/"+env.UUID+"/"+environments.DefaultEnrollPath, bytes.NewReader(body))
+		r.SetPathValue("env", env.UUID)
+		rr := httptest.NewRecorder()
+		handler.EnrollHandler(rr, r)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var resp types.EnrollResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.False(t, resp.NodeInvalid)
+		return resp.NodeKey
+	}
+	config := func(nodeKey string) string {
+		body, err := json.Marshal(types.ConfigRequest{NodeKey: nodeKey})
+		require.NoError(t, err)
+		r := httptest.NewRequest(http.MethodPost, "/"+env.UUID+"/"+environments.DefaultConfigPath, bytes.NewReader(body))
+		r.SetPathValue("env", env.UUID)
+		rr := httptest.NewRecorder()
+		handler.ConfigHandler(rr, r)
+		require.Equal(t, http.StatusOK, rr.Code)
+		return rr.Body.String()
+	}
+
+	pendingKey := enroll("pending-node", "UNKNOWN-SERIAL")
+	node, err := nodesMgr.GetByUUIDEnv("pending-node", env.ID)
+	require.NoError(t, err)
+	require.Equal(t, nodes.ApprovalPending, node.Approval)
+	query := queries.DistributedQuery{Name: "pending", Query: "SELECT 2;", Type: queries.StandardQueryType, Active: true, Expiration: time.Now().Add(time.Hour), EnvironmentID: env.ID}
+	require.NoError(t, queryManager.Create(&query))
+	require.NoError(t, queryManager.CreateNodeQueries([]uint{node.ID}, query.ID))
+	require.Equal(t, nodes.UnapprovedConfiguration, config(pendingKey))
+	require.Empty(t, queryReadResponse(t, handler, env.UUID, pendingKey)["queries"])
+
+	require.NoError(t, nodesMgr.Approve(node, "alice"))
+	require.Contains(t, config(pendingKey), "regular")
+	require.Contains(t, queryReadResponse(t, handler, env.UUID, pendingKey)["queries"], "pending")
+
+	// Approved nodes enrolling again as another host wait for approval
+	pendingKey = enroll("pending-node", "UNKNOWN-SERIAL")
+	node, err = nodesMgr.GetByUUIDEnv("pending-node", env.ID)
+	require.NoError(t, err)
+	require.True(t, node.Approved())
+	pendingKey = enroll("pending-node", "OTHER-SERIAL")
+	node, err = nodesMgr.GetByUUIDEnv("pending-node", env.ID)
+	require.NoError(t, err)
+	require.Equal(t, nodes.ApprovalPending, node.Approval)
+	require.Equal(t, nodes.UnapprovedConfiguration, config(pendingKey))
+
+	// Nodes matching an approval rule are approved when they enroll
+	trustedKey := enroll("trusted-node", "trusted-serial")
+	node, err = nodesMgr.GetByUUIDEnv("trusted-node", env.ID)
+	require.NoError(t, err)
+	require.True(t, node.Approved())
+	require.Equal(t, "rule serial TRUSTED-SERIAL", node.ApprovalBy)
+	require.Contains(t, config(trustedKey), "regular")
+}
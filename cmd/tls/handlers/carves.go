@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"strconv"
+
 	"github.com/jmpsec/osctrl/pkg/carves"
 	"github.com/jmpsec/osctrl/pkg/config"
+	"github.com/jmpsec/osctrl/pkg/nodes"
 	"github.com/jmpsec/osctrl/pkg/types"
 	"github.com/rs/zerolog/log"
 )
@@ -73,6 +76,24 @@ func (h *HandlersTLS) ProcessCarveWrite(req types.QueryCarveScheduled, queryName
 	return nil
 }
 
+// ProcessCarveSkipped - Function to record the files a node left out of a directory carve
+func (h *HandlersTLS) ProcessCarveSkipped(rows []types.QueryCarveScheduled, queryName string, node nodes.OsqueryNode) error {
+	skipped := make([]carves.CarveSkippedFile, 0, len(rows))
+	for _, row := range rows {
+		size, _ := strconv.ParseInt(row.Size, 10, 64)
+		skipped = append(skipped, carves.CarveSkippedFile{
+			QueryName:     queryName,
+			UUID:          node.UUID,
+			NodeID:        node.ID,
+			Path:          row.Path,
+			Size:          size,
+			Reason:        row.SkipReason,
+			EnvironmentID: node.EnvironmentID,
+		})
+	}
+	return h.Carves.CreateSkipped(skipped)
+}
+
 // ProcessCarveInit - Function to initialize a file carve from a node
 func (h *HandlersTLS) ProcessCarveInit(req types.CarveInitRequest, sessionid, environment string) error {
 	// Create File Carve
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/auditlog"
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/queries"
+	"github.com/jmpsec/osctrl/pkg/tags"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestClientCertPinnedAtEnroll(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	require.NoError(t, err)
+	envs := environments.CreateEnvironment(db)
+	nodesMgr := nodes.CreateNodes(db)
+	env := environments.TLSEnvironment{
+		UUID:           "44444444-4444-4444-8444-444444444444",
+		Name:           "env",
+		Secret:         "environment-secret",
+		AcceptEnrolls:  true,
+		ClientCertAuth: true,
+		Configuration:  `{"schedule":{"regular":{"query":"SELECT 1;","interval":3600}}}`,
+	}
+	require.NoError(t, db.Create(&env).Error)
+	issue := func(commonName string) *x509.Certificate {
+		_, certPEM, _, err := envs.IssueClientCert(env, commonName, 0, "alice")
+		require.NoError(t, err)
+		block, _ := pem.Decode([]byte(certPEM))
+		cert, err := x509.ParseCertificate(block.Bytes)
+		require.NoError(t, err)
+		return cert
+	}
+	nodeCert := issue("node-a")
+	otherCert := issue("node-b")
+
+	handler := CreateHandlersTLS(
+		WithEnvs(envs),
+		WithEnvCache(environments.NewEnvCache(*envs)),
+		WithNodes(nodesMgr),
+		WithQueries(queries.CreateQueries(db)),
+		WithTags(tags.CreateTagManager(db)),
+		WithAuditLog(&auditlog.AuditLogManager{}),
+		WithWriteHandler(NewBatchWriter(100, time.Hour, 10, *nodesMgr)),
+	)
+	withCert := func(r *http.Request, cert *x509.Certificate) *http.Request {
+		if cert != nil {
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		}
+		return r
+	}
+	enroll := func(uuid string, cert *x509.Certificate) *httptest.ResponseRecorder {
+		var req types.EnrollRequest
+		req.EnrollSecret = env.Secret
+		req.HostIdentifier = uuid
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+		r := httptest.NewRequest(http.MethodPost, "/"+env.UUID+"/"+environments.DefaultEnrollPath, bytes.NewReader(body))
+		r.SetPathValue("env", env.UUID)
+		rr := httptest.NewRecorder()
+		handler.EnrollHandler(rr, withCert(r, cert))
+		return rr
+	}
+	config := func(nodeKey string, cert *x509.Certificate) string {
+		body, err := json.Marshal(types.ConfigRequest{NodeKey: nodeKey})
+		require.NoError(t, err)
+		r := httptest.NewRequest(http.MethodPost, "/"+env.UUID+"/"+environments.DefaultConfigPath, bytes.NewReader(body))
+		r.SetPathValue("env", env.UUID)
+		rr := httptest.NewRecorder()
+		handler.ConfigHandler(rr, withCert(r, cert))
+		require.Equal(t, http.StatusOK, rr.Code)
+		return rr.Body.String()
+	}
+
+	// Nodes need a certificate issued for their host identifier
+	require.Equal(t, http.StatusForbidden, enroll("node-a", nil).Code)
+	require.Equal(t, http.StatusForbidden, enroll("node-a", otherCert).Code)
+	rr := enroll("node-a", nodeCert)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp types.EnrollResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.False(t, resp.NodeInvalid)
+	node, err := nodesMgr.GetByUUIDEnv("node-a", env.ID)
+	require.NoError(t, err)
+	require.Equal(t, environments.ClientCertSerial(nodeCert), node.ClientCertSerial)
+
+	// The node key only works with the certificate pinned to the node
+	require.Contains(t, config(resp.NodeKey, nodeCert), "regular")
+	require.Contains(t, config(resp.NodeKey, nil), `"node_invalid":true`)
+	require.Contains(t, config(resp.NodeKey, otherCert), `"node_invalid":true`)
+
+	// Revocations apply once the cached certificate expires
+	_, err = envs.RevokeClientCert(env.ID, node.ClientCertSerial, "stolen", "alice")
+	require.NoError(t, err)
+	require.Contains(t, config(resp.NodeKey, nodeCert), "regular")
+	handler.ClientCerts.Invalidate(context.Background(), env.ID, node.ClientCertSerial)
+	require.Contains(t, config(resp.NodeKey, nodeCert), `"node_invalid":true`)
+	require.Equal(t, http.StatusForbidden, enroll("node-a", nodeCert).Code)
+}
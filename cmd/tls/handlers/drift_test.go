@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestConfigHandlerRecordsExpectedConfigHash(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	require.NoError(t, err)
+	envs := environments.CreateEnvironment(db)
+	nodesMgr := nodes.CreateNodes(db)
+	env := environments.TLSEnvironment{
+		UUID:          "33333333-3333-4333-8333-333333333333",
+		Name:          "env",
+		Configuration: `{"options":{"logger_tls_period":10}}`,
+	}
+	require.NoError(t, db.Create(&env).Error)
+	node := nodes.OsqueryNode{NodeKey: "drift-node-key", UUID: "DRIFT-NODE", EnvironmentID: env.ID, Environment: env.Name}
+	require.NoError(t, db.Create(&node).Error)
+
+	handler := CreateHandlersTLS(
+		WithEnvs(envs),
+		WithEnvCache(environments.NewEnvCache(*envs)),
+		WithNodes(nodesMgr),
+		WithWriteHandler(NewBatchWriter(1, time.Hour, 10, *nodesMgr)),
+	)
+	body, err := json.Marshal(types.ConfigRequest{NodeKey: node.NodeKey})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/"+env.UUID+"/"+environments.DefaultConfigPath, bytes.NewReader(body))
+	req.SetPathValue("env", env.UUID)
+	rr := httptest.NewRecorder()
+	handler.ConfigHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	expected := environments.OsqueryConfigHash(rr.Body.Bytes())
+	require.Eventually(t, func() bool {
+		var stored nodes.OsqueryNode
+		return db.First(&stored, node.ID).Error == nil && stored.ExpectedConfigHash == expected && !stored.ExpectedConfigAt.IsZero()
+	}, 5*time.Second, 10*time.Millisecond)
+}
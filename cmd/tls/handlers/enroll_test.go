@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jmpsec/osctrl/pkg/auditlog"
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/tags"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestEnrollWithTokenRecordsAndTagsNode(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	require.NoError(t, err)
+	envs := environments.CreateEnvironment(db)
+	nodesMgr := nodes.CreateNodes(db)
+	tagsMgr := tags.CreateTagManager(db)
+	env := environments.TLSEnvironment{
+		UUID:          "22222222-2222-4222-8222-222222222222",
+		Name:          "env",
+		Secret:        "environment-secret",
+		AcceptEnrolls: true,
+	}
+	require.NoError(t, db.Create(&env).Error)
+	_, secret, err := envs.CreateEnrollToken(environments.EnrollToken{EnvironmentID: env.ID, Name: "laptops", MaxUses: 1}, []string{"192.0.2.0/24"}, []string{"laptop"})
+	require.NoError(t, err)
+	_, remote, err := envs.CreateEnrollToken(environments.EnrollToken{EnvironmentID: env.ID, Name: "remote"}, []string{"198.51.100.0/24"}, nil)
+	require.NoError(t, err)
+	_, spare, err := envs.CreateEnrollToken(environments.EnrollToken{EnvironmentID: env.ID, Name: "spare", MaxUses: 1}, nil, nil)
+	require.NoError(t, err)
+
+	handler := CreateHandlersTLS(
+		WithEnvs(envs),
+		WithEnvCache(environments.NewEnvCache(*envs)),
+		WithNodes(nodesMgr),
+		WithTags(tagsMgr),
+		WithAuditLog(&auditlog.AuditLogManager{}),
+	)
+	enroll := func(secret, uuid string) *httptest.ResponseRecorder {
+		var req types.EnrollRequest
+		req.EnrollSecret = secret
+		req.HostIdentifier = uuid
+		req.HostDetails.EnrollOSVersion.Platform = "darwin"
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+		r := httptest.NewRequest(http.MethodPost, "/"+env.UUID+"/"+environments.DefaultEnrollPath, bytes.NewReader(body))
+		r.SetPathValue("env", env.UUID)
+		rr := httptest.NewRecorder()
+		handler.EnrollHandler(rr, r)
+		return rr
+	}
+
+	rr := enroll(secret, "node-a")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp types.EnrollResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.False(t, resp.NodeInvalid)
+	node, err := nodesMgr.GetByUUIDEnv("node-a", env.ID)
+	require.NoError(t, err)
+	require.Equal(t, "laptops", node.EnrollToken)
+	require.True(t, tagsMgr.IsTagged("laptop", node))
+
+	// The token was used up, the secret of the environment keeps working
+	require.Equal(t, http.StatusForbidden, enroll(secret, "node-b").Code)
+	require.Equal(t, http.StatusOK, enroll(env.Secret, "node-b").Code)
+	node, err = nodesMgr.GetByUUIDEnv("node-b", env.ID)
+	require.NoError(t, err)
+	require.Empty(t, node.EnrollToken)
+
+	// Enrolling again with the same token is not another use
+	require.Equal(t, http.StatusOK, enroll(secret, "node-a").Code)
+	used, err := envs.GetEnrollToken(env.ID, "laptops")
+	require.NoError(t, err)
+	require.Equal(t, 1, used.Uses)
+
+	// Tokens restricted to other networks are rejected
+	require.Equal(t, http.StatusForbidden, enroll(remote, "node-c").Code)
+	require.Equal(t, http.StatusForbidden, enroll("unknown", "node-c").Code)
+
+	// Uses of nodes that could not be persisted are given back
+	require.NoError(t, db.Migrator().DropTable(&nodes.OsqueryNode{}))
+	rr = enroll(spare, "node-d")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.True(t, resp.NodeInvalid)
+	unused, err := envs.GetEnrollToken(env.ID, "spare")
+	require.NoError(t, err)
+	require.Zero(t, unused.Uses)
+}
@@ -57,6 +57,9 @@ var validPlatform = map[string]bool{
 type HandlersTLS struct {
 	Envs            *environments.EnvManager
 	EnvCache        *environments.EnvCache
+	Overlays        *environments.OverlayCache
+	Rollouts        *environments.RolloutCache
+	ClientCerts     *environments.ClientCertCache
 	Nodes           *nodes.NodeManager
 	Tags            *tags.TagManager
 	Queries         *queries.Queries
@@ -98,6 +101,30 @@ func WithEnvCache(ec *environments.EnvCache) Option {
 	}
 }
 
+// WithOverlays sets the cache of configuration overlays. When not provided,
+// CreateHandlersTLS builds one from the environment manager.
+func WithOverlays(oc *environments.OverlayCache) Option {
+	return func(h *HandlersTLS) {
+		h.Overlays = oc
+	}
+}
+
+// WithRollouts sets the cache of configuration rollouts. When not provided,
+// CreateHandlersTLS builds one from the environment manager.
+func WithRollouts(rc *environments.RolloutCache) Option {
+	return func(h *HandlersTLS) {
+		h.Rollouts = rc
+	}
+}
+
+// WithClientCerts sets the cache of client certificates. When not provided,
+// CreateHandlersTLS builds one from the environment manager.
+func WithClientCerts(cc *environments.ClientCertCache) Option {
+	return func(h *HandlersTLS) {
+		h.ClientCerts = cc
+	}
+}
+
 // WithSettings to pass value as option
 func WithSettings(settings *settings.Settings) Option {
 	return func(h *HandlersTLS) {
@@ -221,6 +248,15 @@ func CreateHandlersTLS(opts ...Option) *HandlersTLS {
 	if h.Envs != nil && h.EnvCache == nil {
 		h.EnvCache = environments.NewEnvCache(*h.Envs)
 	}
+	if h.Envs != nil && h.Overlays == nil {
+		h.Overlays = environments.NewOverlayCache(*h.Envs)
+	}
+	if h.Envs != nil && h.Rollouts == nil {
+		h.Rollouts = environments.NewRolloutCache(*h.Envs)
+	}
+	if h.Envs != nil && h.ClientCerts == nil {
+		h.ClientCerts = environments.NewClientCertCache(*h.Envs)
+	}
 	if h.AuditLog == nil {
 		// Defensive — handlers call h.AuditLog.FailedEnroll(...). Disabled
 		// manager is a no-op so we don't have to nil-check at every site.
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestConfigRedirectsMovedNode(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	require.NoError(t, err)
+	envs := environments.CreateEnvironment(db)
+	nodesMgr := nodes.CreateNodes(db)
+	oldEnv := environments.TLSEnvironment{UUID: "11111111-1111-4111-8111-111111111111", Name: "old", Hostname: "old.example.com"}
+	newEnv := environments.TLSEnvironment{UUID: "22222222-2222-4222-8222-222222222222", Name: "new", Hostname: "new.example.com"}
+	otherEnv := environments.TLSEnvironment{UUID: "33333333-3333-4333-8333-333333333333", Name: "other"}
+	require.NoError(t, db.Create(&oldEnv).Error)
+	require.NoError(t, db.Create(&newEnv).Error)
+	require.NoError(t, db.Create(&otherEnv).Error)
+	node := nodes.OsqueryNode{NodeKey: "moved-node-key", UUID: "MOVED-NODE", EnvironmentID: oldEnv.ID, Environment: oldEnv.Name}
+	require.NoError(t, db.Create(&node).Error)
+	_, err = nodesMgr.MoveToEnvironment([]nodes.OsqueryNode{node}, newEnv.ID, newEnv.Name, "alice")
+	require.NoError(t, err)
+
+	handler := CreateHandlersTLS(
+		WithEnvs(envs),
+		WithEnvCache(environments.NewEnvCache(*envs)),
+		WithNodes(nodesMgr),
+	)
+	configRequestPath := func(envUUID, path string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(types.ConfigRequest{NodeKey: node.NodeKey})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.SetPathValue("env", envUUID)
+		rr := httptest.NewRecorder()
+		handler.ConfigHandler(rr, req)
+		return rr
+	}
+	configRequest := func(envUUID string) *httptest.ResponseRecorder {
+		return configRequestPath(envUUID, "/"+envUUID+"/"+environments.DefaultConfigPath)
+	}
+
+	rr := configRequest(oldEnv.UUID)
+	require.Equal(t, http.StatusTemporaryRedirect, rr.Code)
+	require.Equal(t, "https://new.example.com/"+newEnv.UUID+"/"+environments.DefaultConfigPath, rr.Header().Get("Location"))
+
+	// The environment is replaced as sent by the node, wherever it is
+	upper := strings.ToUpper(oldEnv.UUID)
+	rr = configRequestPath(upper, "/"+upper+"?debug=1")
+	require.Equal(t, http.StatusTemporaryRedirect, rr.Code)
+	require.Equal(t, "https://new.example.com/"+newEnv.UUID+"?debug=1", rr.Header().Get("Location"))
+
+	// A node that was never in the environment is still rejected
+	rr = configRequest(otherEnv.UUID)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp types.ConfigResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.True(t, resp.NodeInvalid)
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/tags"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestConfigHandlerMergesOverlaysOfNode(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	require.NoError(t, err)
+	envs := environments.CreateEnvironment(db)
+	nodesMgr := nodes.CreateNodes(db)
+	tagsMgr := tags.CreateTagManager(db)
+	env := environments.TLSEnvironment{
+		UUID:          "22222222-2222-4222-8222-222222222222",
+		Name:          "env",
+		Configuration: `{"options":{"logger_tls_period":10},"schedule":{"regular":{"query":"SELECT 1;","interval":3600}}}`,
+	}
+	require.NoError(t, db.Create(&env).Error)
+	server := nodes.OsqueryNode{NodeKey: "server-node-key", UUID: "SERVER-NODE", Platform: "ubuntu", EnvironmentID: env.ID, Environment: env.Name}
+	require.NoError(t, db.Create(&server).Error)
+	laptop := nodes.OsqueryNode{NodeKey: "laptop-node-key", UUID: "LAPTOP-NODE", Platform: "darwin", EnvironmentID: env.ID, Environment: env.Name}
+	require.NoError(t, db.Create(&laptop).Error)
+	require.NoError(t, tagsMgr.TagNode("pci", server, "alice", false, tags.TagTypeCustom, ""))
+
+	_, err = envs.SetOverlay(environments.ConfigOverlay{EnvironmentID: env.ID, Name: "pci", TargetType: environments.OverlayTargetTag, Target: "pci",
+		Configuration: `{"schedule":{"file_integrity":{"query":"SELECT * FROM file_events;","interval":300}}}`})
+	require.NoError(t, err)
+	_, err = envs.SetOverlay(environments.ConfigOverlay{EnvironmentID: env.ID, Name: "laptops", TargetType: environments.OverlayTargetPlatform, Target: "darwin",
+		Configuration: `{"options":{"logger_tls_period":60}}`})
+	require.NoError(t, err)
+
+	handler := CreateHandlersTLS(
+		WithEnvs(envs),
+		WithEnvCache(environments.NewEnvCache(*envs)),
+		WithNodes(nodesMgr),
+		WithTags(tagsMgr),
+		WithWriteHandler(NewBatchWriter(100, time.Hour, 10, *nodesMgr)),
+	)
+	configResponse := func(nodeKey string) environments.OsqueryConf {
+		body, err := json.Marshal(types.ConfigRequest{NodeKey: nodeKey})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/"+env.UUID+"/"+environments.DefaultConfigPath, bytes.NewReader(body))
+		req.SetPathValue("env", env.UUID)
+		rr := httptest.NewRecorder()
+		handler.ConfigHandler(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var conf environments.OsqueryConf
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &conf))
+		return conf
+	}
+
+	conf := configResponse(server.NodeKey)
+	require.Contains(t, conf.Schedule, "regular")
+	require.Contains(t, conf.Schedule, "file_integrity")
+	require.Equal(t, float64(10), conf.Options["logger_tls_period"])
+
+	conf = configResponse(laptop.NodeKey)
+	require.NotContains(t, conf.Schedule, "file_integrity")
+	require.Equal(t, float64(60), conf.Options["logger_tls_period"])
+
+	// Deleted overlays stop applying once the cached overlays are dropped
+	require.NoError(t, envs.DeleteOverlay(env.ID, "laptops"))
+	handler.Overlays.Invalidate(context.Background(), env.ID)
+	require.Equal(t, float64(10), configResponse(laptop.NodeKey).Options["logger_tls_period"])
+}
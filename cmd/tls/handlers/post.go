@@ -129,16 +129,39 @@ func (h *HandlersTLS) EnrollHandler(w http.ResponseWriter, r *http.Request) {
 	if h.shouldDebugHTTP(t.HostIdentifier) {
 		utils.DebugHTTPDumpWithBody(h.DebugHTTP, r, body, h.DebugHTTPConfig.ShowBody)
 	}
-	// Check if received secret is valid
+	// Check if received secret is valid, the one of the environment or an enroll token
 	var nodeKey string
 	var newNode nodes.OsqueryNode
 	nodeInvalid := true
-	if h.checkValidSecret(t.EnrollSecret, env) {
+	ipaddress := utils.GetIP(r)
+	// Environments requiring client certificates pin the one of the node
+	var clientCertSerial string
+	if env.ClientCertAuth {
+		if clientCertSerial, err = h.checkEnrollClientCert(r, env, t.HostIdentifier); err != nil {
+			if !isClientCertError(err) {
+				log.Err(err).Msg("error checking client certificate")
+				utils.HTTPResponse(w, "", http.StatusInternalServerError, []byte(""))
+				return
+			}
+			log.Warn().Msgf("%s in %s from %s", err.Error(), env.Name, ipaddress)
+			h.AuditLog.FailedEnroll(ipaddress, env.Name, err.Error(), env.ID)
+			utils.HTTPResponse(w, "", http.StatusForbidden, []byte(""))
+			return
+		}
+	}
+	// Nodes enrolling again are not counted again for the same token
+	existing, existingErr := h.Nodes.GetByUUIDEnv(t.HostIdentifier, env.ID)
+	reenroll := existingErr == nil
+	token, err := h.checkEnrollSecret(t.EnrollSecret, env, ipaddress, existing.EnrollToken)
+	if err == nil {
 		// Generate node_key using UUID as entropy
 		nodeKey = generateNodeKey(t.HostIdentifier, time.Now())
-		newNode = nodeFromEnroll(t, env, utils.GetIP(r), nodeKey, len(body))
+		newNode = nodeFromEnroll(t, env, ipaddress, nodeKey, len(body))
+		newNode.EnrollToken = token.Name
+		newNode.ClientCertSerial = clientCertSerial
 		// Check if UUID exists already, if so archive node and enroll new node
-		if h.Nodes.CheckByUUIDEnv(t.HostIdentifier, env.Name) {
+		if reenroll {
+			h.reenrollApproval(env, existing, &newNode)
 			if err := h.Nodes.Archive(t.HostIdentifier, "exists"); err != nil {
 				log.Err(err).Msg("error archiving node")
 			}
@@ -147,8 +170,12 @@ func (h *HandlersTLS) EnrollHandler(w http.ResponseWriter, r *http.Request) {
 				log.Err(err).Msg("error updating existing node")
 			} else {
 				nodeInvalid = false
+				if updated, err := h.Nodes.GetByUUIDEnv(t.HostIdentifier, env.ID); err == nil {
+					h.tagEnrolledNode(token, updated)
+				}
 			}
-		} else { // New node, persist it
+		} else { // New node, persist it, pending approval if the environment requires it
+			h.enrollApproval(env, &newNode)
 			if err := h.Nodes.Create(&newNode); err != nil {
 				log.Err(err).Msg("error creating node")
 			} else {
@@ -156,11 +183,27 @@ func (h *HandlersTLS) EnrollHandler(w http.ResponseWriter, r *http.Request) {
 				if err := h.Tags.AutoTagNode(env.Name, newNode, "osctrl-tls"); err != nil {
 					log.Err(err).Msg("error tagging node")
 				}
+				h.tagEnrolledNode(token, newNode)
+			}
+		}
+		// The use of the token is given back when the node was not persisted
+		if nodeInvalid && token.Name != "" && token.Name != existing.EnrollToken {
+			if err := h.Envs.ReleaseEnrollToken(token); err != nil {
+				log.Err(err).Msgf("error releasing enroll token %s", token.Name)
 			}
 		}
 	} else {
-		log.Err(err).Msg("invalid enrolling secret")
-		h.AuditLog.FailedEnroll(utils.GetIP(r), env.Name, "invalid enroll secret", env.ID)
+		reason := "invalid enroll secret"
+		switch {
+		case errors.Is(err, environments.ErrEnrollTokenExpired), errors.Is(err, environments.ErrEnrollTokenExhausted), errors.Is(err, environments.ErrEnrollTokenSource):
+			reason = fmt.Sprintf("%s %s", err.Error(), token.Name)
+		case !errors.Is(err, environments.ErrEnrollTokenInvalid):
+			log.Err(err).Msg("error checking enroll token")
+			utils.HTTPResponse(w, "", http.StatusInternalServerError, []byte(""))
+			return
+		}
+		log.Warn().Msgf("%s in %s from %s", reason, env.Name, ipaddress)
+		h.AuditLog.FailedEnroll(ipaddress, env.Name, reason, env.ID)
 		utils.HTTPResponse(w, "", http.StatusForbidden, []byte(""))
 		return
 	}
@@ -230,24 +273,69 @@ func (h *HandlersTLS) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 	if nodeErr == nil {
 		// Check if node belongs to the environment
 		if node.EnvironmentID != env.ID {
+			if h.redirectMovedNode(w, r, node, env) {
+				return
+			}
 			log.Warn().Msgf("node UUID: %s in %s environment does not belong to the environment", node.UUID, env.Name)
 			response = types.ConfigResponse{NodeInvalid: true}
 			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, response)
 			return
 		}
+		// Nodes of environments requiring client certificates must present the one pinned to them
+		if !h.validClientCert(r, env, node) {
+			response = types.ConfigResponse{NodeInvalid: true}
+			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, response)
+			return
+		}
 		// Node and environment match, so we can proceed to update the node
 		ip := utils.GetIP(r)
 		if ip == node.IPAddress {
 			ip = ""
 		}
-		h.WriteHandler.addEvent(lastSeenUpdate{NodeID: node.ID, IP: ip, SeenAt: time.Now()})
-		log.Debug().Msgf("node-uuid: %s with nodeid %d added to batch writer for config update", node.UUID, node.ID)
+		seen := lastSeenUpdate{NodeID: node.ID, IP: ip, SeenAt: time.Now()}
 		h.recordActivity(env.UUID, node.UUID, activity.EventConfig)
 
 		// Record ingested data
 		requestSize.WithLabelValues(string(env.UUID), "ConfigHandler").Observe(float64(len(body)))
 		log.Debug().Msgf("node UUID: %s in %s environment ingested %d bytes for ConfigHandler endpoint", node.UUID, env.Name, len(body))
 		response = []byte(env.Configuration)
+		// Pending rollouts pick the revision served to the node
+		nodeEnv := env
+		if !node.Quarantined && h.Rollouts != nil {
+			if nodeEnv, err = h.Rollouts.NodeEnvironment(ctx, env, node.UUID, h.nodeTagNames(node)); err != nil {
+				log.Err(err).Msgf("error getting configuration rollout for %s", env.Name)
+				utils.HTTPResponse(w, "", http.StatusInternalServerError, []byte(""))
+				return
+			}
+			response = []byte(nodeEnv.Configuration)
+		}
+		// Quarantined nodes only get the incident response configuration
+		if !node.Quarantined && h.Overlays != nil {
+			nodeConf, _, err := h.Overlays.NodeConfiguration(ctx, nodeEnv, nodes.PlatformNames(node.Platform), h.nodeTagNames(node))
+			if err != nil {
+				log.Err(err).Msgf("error applying configuration overlays for %s", env.Name)
+				utils.HTTPResponse(w, "", http.StatusInternalServerError, []byte(""))
+				return
+			}
+			response = []byte(nodeConf)
+		}
+		if node.Quarantined {
+			quarantineConf, err := h.Envs.QuarantineConfiguration(env)
+			if err != nil {
+				log.Err(err).Msgf("error generating quarantine configuration for %s", env.Name)
+				utils.HTTPResponse(w, "", http.StatusInternalServerError, []byte(""))
+				return
+			}
+			response = []byte(quarantineConf)
+		}
+		// Nodes pending approval or rejected get an empty configuration
+		if !node.Approved() {
+			response = []byte(nodes.UnapprovedConfiguration)
+		}
+		// The served configuration is what the node should report as config hash
+		seen.ConfigHash = environments.OsqueryConfigHash(response.([]byte))
+		h.WriteHandler.addEvent(seen)
+		log.Debug().Msgf("node-uuid: %s with nodeid %d added to batch writer for config update", node.UUID, node.ID)
 	} else {
 		response = types.ConfigResponse{NodeInvalid: true}
 	}
@@ -341,11 +429,20 @@ func (h *HandlersTLS) LogHandler(w http.ResponseWriter, r *http.Request) {
 	if nodeErr == nil {
 		// Check if node belongs to the environment
 		if node.EnvironmentID != env.ID {
+			if h.redirectMovedNode(w, r, node, env) {
+				return
+			}
 			log.Warn().Msgf("node UUID: %s in %s environment does not belong to the environment", node.UUID, env.Name)
 			response = types.LogResponse{NodeInvalid: true}
 			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, response)
 			return
 		}
+		// Nodes of environments requiring client certificates must present the one pinned to them
+		if !h.validClientCert(r, env, node) {
+			response = types.LogResponse{NodeInvalid: true}
+			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, response)
+			return
+		}
 		// Node and environment match, so we can proceed to update the node
 		nodeInvalid = false
 		// Record ingested data
@@ -434,22 +531,34 @@ func (h *HandlersTLS) QueryReadHandler(w http.ResponseWriter, r *http.Request) {
 	if nodeErr == nil {
 		// Check if node belongs to the environment
 		if node.EnvironmentID != env.ID {
+			if h.redirectMovedNode(w, r, node, env) {
+				return
+			}
 			log.Warn().Msgf("node UUID: %s in %s environment does not belong to the environment", node.UUID, env.Name)
 			response = types.ConfigResponse{NodeInvalid: true}
 			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, response)
 			return
 		}
+		// Nodes of environments requiring client certificates must present the one pinned to them
+		if !h.validClientCert(r, env, node) {
+			response = types.ConfigResponse{NodeInvalid: true}
+			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, response)
+			return
+		}
 		// Node and environment match, so we can proceed
 		// Record ingested data
 		requestSize.WithLabelValues(string(env.UUID), "QueryRead").Observe(float64(len(body)))
 		log.Debug().Msgf("node UUID: %s in %s environment ingested %d bytes for QueryReadHandler endpoint", node.UUID, env.Name, len(body))
-		// Get queries and update node
+		// Get queries and update node, quarantined nodes and nodes not approved
+		// get no distributed queries
 		nodeInvalid = false
-		qs, accelerate, err = h.Queries.NodeQueries(node)
-		if err != nil {
-			log.Err(err).Msg("error getting queries from db")
+		if !node.Quarantined && node.Approved() {
+			qs, accelerate, err = h.Queries.NodeQueries(node)
+			if err != nil {
+				log.Err(err).Msg("error getting queries from db")
+			}
+			accelerate = h.shouldAccelerateQueryRead(node, accelerate)
 		}
-		accelerate = h.shouldAccelerateQueryRead(node, accelerate)
 		// Refresh node last seen
 		ip := utils.GetIP(r)
 		if ip == node.IPAddress {
@@ -533,11 +642,20 @@ func (h *HandlersTLS) QueryWriteHandler(w http.ResponseWriter, r *http.Request)
 	if nodeErr == nil {
 		// Check if node belongs to the environment
 		if node.EnvironmentID != env.ID {
+			if h.redirectMovedNode(w, r, node, env) {
+				return
+			}
 			log.Warn().Msgf("node UUID: %s in %s environment does not belong to the environment", node.UUID, env.Name)
 			response = types.QueryWriteResponse{NodeInvalid: true}
 			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, response)
 			return
 		}
+		// Nodes of environments requiring client certificates must present the one pinned to them
+		if !h.validClientCert(r, env, node) {
+			response = types.QueryWriteResponse{NodeInvalid: true}
+			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, response)
+			return
+		}
 		// Node and environment match, so we can proceed
 		// Record ingested data
 		requestSize.WithLabelValues(string(env.UUID), "QueryWrite").Observe(float64(len(body)))
@@ -547,11 +665,19 @@ func (h *HandlersTLS) QueryWriteHandler(w http.ResponseWriter, r *http.Request)
 		for name, c := range t.Queries {
 			var carves []types.QueryCarveScheduled
 			if err := json.Unmarshal(c, &carves); err == nil {
+				var skipped []types.QueryCarveScheduled
 				for _, cc := range carves {
 					if cc.Carve == "1" {
 						if err := h.ProcessCarveWrite(cc, name, t.NodeKey, env.Name); err != nil {
 							log.Err(err).Msg("error scheduling carve")
 						}
+					} else if cc.SkipReason != "" {
+						skipped = append(skipped, cc)
+					}
+				}
+				if len(skipped) > 0 {
+					if err := h.ProcessCarveSkipped(skipped, name, node); err != nil {
+						log.Err(err).Msg("error recording skipped carve files")
 					}
 				}
 			}
@@ -647,6 +773,11 @@ func (h *HandlersTLS) QuickEnrollHandler(w http.ResponseWriter, r *http.Request)
 	}
 	// Prepare response with the script
 	quickScript, err := environments.QuickAddScript("osctrl-"+env.Name, script, env)
+	if errors.Is(err, environments.ErrClientCertScript) {
+		log.Warn().Msgf("%s in %s", err.Error(), env.Name)
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusBadRequest, TLSResponse{Message: err.Error()})
+		return
+	}
 	if err != nil {
 		log.Err(err).Msg("error getting script")
 		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: "Error generating script"})
@@ -787,11 +918,20 @@ func (h *HandlersTLS) CarveInitHandler(w http.ResponseWriter, r *http.Request) {
 	if nodeErr == nil {
 		// Check if node belongs to the environment
 		if node.EnvironmentID != env.ID {
+			if h.redirectMovedNode(w, r, node, env) {
+				return
+			}
 			log.Warn().Msgf("node UUID: %s in %s environment does not belong to the environment", node.UUID, env.Name)
 			response = types.CarveInitResponse{Success: false, SessionID: ""}
 			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, response)
 			return
 		}
+		// Nodes of environments requiring client certificates must present the one pinned to them
+		if !h.validClientCert(r, env, node) {
+			response = types.CarveInitResponse{Success: false, SessionID: ""}
+			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, response)
+			return
+		}
 		// Node and environment match, so we can proceed
 		// Record ingested data
 		requestSize.WithLabelValues(string(env.UUID), "CarveInit").Observe(float64(len(body)))
@@ -928,7 +1068,7 @@ func (h *HandlersTLS) FlagsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Check if provided secret is valid and if so, prepare flags
-	if h.checkValidSecret(t.Secret, env) {
+	if h.checkValidSecret(t.Secret, env, utils.GetIP(r)) {
 		flagsStr, err := h.Envs.GenerateFlags(env, t.SecrefFile, t.CertFile, *h.OsqueryValues)
 		if err != nil {
 			log.Err(err).Msg("error generating flags")
@@ -989,7 +1129,7 @@ func (h *HandlersTLS) CertHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Check if provided secret is valid and if so, prepare flags
-	if h.checkValidSecret(t.Secret, env) {
+	if h.checkValidSecret(t.Secret, env, utils.GetIP(r)) {
 		response = []byte(env.Certificate)
 	} else {
 		utils.HTTPResponse(w, "", http.StatusForbidden, []byte("uh oh..."))
@@ -1044,7 +1184,7 @@ func (h *HandlersTLS) VerifyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Check if provided secret is valid and if so, prepare flags
-	if h.checkValidSecret(t.Secret, env) {
+	if h.checkValidSecret(t.Secret, env, utils.GetIP(r)) {
 		flagsStr, err := h.Envs.GenerateFlags(env, t.SecrefFile, t.CertFile, *h.OsqueryValues)
 		if err != nil {
 			log.Err(err).Msg("error generating flags")
@@ -1136,8 +1276,13 @@ func (h *HandlersTLS) ScriptHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Check if provided secret is valid and if so, prepare flags
-	if h.checkValidSecret(t.Secret, env) {
+	if h.checkValidSecret(t.Secret, env, utils.GetIP(r)) {
 		script, err := environments.QuickAddScript("osctrl-"+env.Name, actionVar, env)
+		if errors.Is(err, environments.ErrClientCertScript) {
+			log.Warn().Msgf("%s in %s", err.Error(), env.Name)
+			utils.HTTPResponse(w, utils.TextPlainUTF8, http.StatusBadRequest, []byte(err.Error()))
+			return
+		}
 		if err != nil {
 			log.Err(err).Msg("error preparing script")
 			utils.HTTPResponse(w, "", http.StatusInternalServerError, []byte(""))
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/queries"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestQuarantinedNodeGetsQuarantineConfigAndNoQueries(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	require.NoError(t, err)
+	envs := environments.CreateEnvironment(db)
+	nodesMgr := nodes.CreateNodes(db)
+	queryManager := queries.CreateQueries(db)
+	env := environments.TLSEnvironment{
+		UUID:          "11111111-1111-4111-8111-111111111111",
+		Name:          "env",
+		Options:       `{"logger_tls_period":10}`,
+		Configuration: `{"schedule":{"regular":{"query":"SELECT 1;","interval":3600}}}`,
+	}
+	require.NoError(t, db.Create(&env).Error)
+	node := nodes.OsqueryNode{NodeKey: "incident-node-key", UUID: "INCIDENT-NODE", EnvironmentID: env.ID, Environment: env.Name}
+	require.NoError(t, db.Create(&node).Error)
+	query := queries.DistributedQuery{Name: "pending", Query: "SELECT 2;", Type: queries.StandardQueryType, Active: true, Expiration: time.Now().Add(time.Hour), EnvironmentID: env.ID}
+	require.NoError(t, queryManager.Create(&query))
+	require.NoError(t, queryManager.CreateNodeQueries([]uint{node.ID}, query.ID))
+
+	handler := CreateHandlersTLS(
+		WithEnvs(envs),
+		WithEnvCache(environments.NewEnvCache(*envs)),
+		WithNodes(nodesMgr),
+		WithQueries(queryManager),
+		WithWriteHandler(NewBatchWriter(100, time.Hour, 10, *nodesMgr)),
+	)
+	configResponse := func() environments.OsqueryConf {
+		body, err := json.Marshal(types.ConfigRequest{NodeKey: node.NodeKey})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/"+env.UUID+"/"+environments.DefaultConfigPath, bytes.NewReader(body))
+		req.SetPathValue("env", env.UUID)
+		rr := httptest.NewRecorder()
+		handler.ConfigHandler(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var conf environments.OsqueryConf
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &conf))
+		return conf
+	}
+
+	require.Contains(t, configResponse().Schedule, "regular")
+	require.Contains(t, queryReadResponse(t, handler, env.UUID, node.NodeKey)["queries"], "pending")
+
+	_, err = nodesMgr.Quarantine(node, "beaconing", "alice")
+	require.NoError(t, err)
+	conf := configResponse()
+	require.NotContains(t, conf.Schedule, "regular")
+	require.Contains(t, conf.Schedule, "quarantine_process_open_sockets")
+	require.Equal(t, float64(10), conf.Options["logger_tls_period"])
+	require.Empty(t, queryReadResponse(t, handler, env.UUID, node.NodeKey)["queries"])
+}
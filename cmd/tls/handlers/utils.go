@@ -5,14 +5,18 @@ import (
 	"crypto/sha1"
 	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/jmpsec/osctrl/pkg/environments"
 	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/tags"
 	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/jmpsec/osctrl/pkg/utils"
 	"github.com/rs/zerolog/log"
 	"github.com/segmentio/ksuid"
 )
@@ -33,16 +37,135 @@ func generateCarveSessionID() string {
 	return id.String()
 }
 
-// Helper to check if the provided secret is valid for this environment.
+// Helper to check if the provided secret is the one of this environment.
 // Constant-time compare to avoid leaking the secret via byte-by-byte timing
 // from the anonymous internet-facing enroll endpoint.
-func (h *HandlersTLS) checkValidSecret(secret string, env environments.TLSEnvironment) bool {
+func (h *HandlersTLS) checkEnvSecret(secret string, env environments.TLSEnvironment) bool {
 	return subtle.ConstantTimeCompare(
 		[]byte(strings.TrimSpace(secret)),
 		[]byte(env.Secret),
 	) == 1
 }
 
+// Helper to check if the provided secret is valid for this environment, as
+// its secret or as an enroll token nodes can enroll with from an address.
+// Tokens are only matched by the hash of the secret, so there is no timing
+// to leak.
+func (h *HandlersTLS) checkValidSecret(secret string, env environments.TLSEnvironment, address string) bool {
+	if h.checkEnvSecret(secret, env) {
+		return true
+	}
+	if h.Envs == nil {
+		return false
+	}
+	_, err := h.Envs.CheckEnrollToken(env.ID, secret, address)
+	return err == nil
+}
+
+// Helper to check the secret of an enrollment, counting it when it is an
+// enroll token other than the one the node enrolled with before. The token
+// is returned to record it on the node, empty for the secret of the
+// environment.
+func (h *HandlersTLS) checkEnrollSecret(secret string, env environments.TLSEnvironment, address, enrolledWith string) (environments.EnrollToken, error) {
+	if h.checkEnvSecret(secret, env) {
+		return environments.EnrollToken{}, nil
+	}
+	if h.Envs == nil {
+		return environments.EnrollToken{}, environments.ErrEnrollTokenInvalid
+	}
+	return h.Envs.ReuseEnrollToken(env.ID, secret, address, enrolledWith)
+}
+
+// Helper to tag a node with the tags of the enroll token it used
+func (h *HandlersTLS) tagEnrolledNode(token environments.EnrollToken, node nodes.OsqueryNode) {
+	for _, t := range token.TagList() {
+		if h.Tags.IsTagged(t, node) {
+			continue
+		}
+		if err := h.Tags.TagNode(t, node, "osctrl-tls", true, tags.TagTypeTag, ""); err != nil {
+			log.Err(err).Msgf("error tagging node %s with %s", node.UUID, t)
+		}
+	}
+}
+
+// Helper to check the client certificate presented to enroll in an
+// environment requiring them, issued for the host identifier of the node.
+// It returns the serial of the certificate to pin to the node.
+func (h *HandlersTLS) checkEnrollClientCert(r *http.Request, env environments.TLSEnvironment, hostIdentifier string) (string, error) {
+	if h.ClientCerts == nil {
+		return "", environments.ErrClientCertUntrusted
+	}
+	cert, err := h.ClientCerts.Verify(r.Context(), env.ID, utils.ClientCertificate(r))
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(cert.CommonName, strings.TrimSpace(hostIdentifier)) {
+		return "", fmt.Errorf("%w: issued for %s", environments.ErrClientCertUntrusted, cert.CommonName)
+	}
+	return cert.Serial, nil
+}
+
+// isClientCertError returns true if a node can not use a client certificate,
+// as opposed to errors verifying it
+func isClientCertError(err error) bool {
+	return errors.Is(err, environments.ErrClientCertMissing) || errors.Is(err, environments.ErrClientCertUntrusted) ||
+		errors.Is(err, environments.ErrClientCertUnknown) || errors.Is(err, environments.ErrClientCertRevoked)
+}
+
+// Helper to check if a node of an environment requiring client certificates
+// presented the certificate pinned to it when it enrolled, which is still
+// valid and not revoked
+func (h *HandlersTLS) validClientCert(r *http.Request, env environments.TLSEnvironment, node nodes.OsqueryNode) bool {
+	if !env.ClientCertAuth {
+		return true
+	}
+	if h.ClientCerts == nil {
+		return false
+	}
+	cert, err := h.ClientCerts.Verify(r.Context(), env.ID, utils.ClientCertificate(r))
+	if err != nil {
+		log.Warn().Msgf("node UUID: %s in %s environment: %v", node.UUID, env.Name, err)
+		return false
+	}
+	if node.ClientCertSerial == "" || cert.Serial != node.ClientCertSerial {
+		log.Warn().Msgf("node UUID: %s in %s environment presented client certificate %s not pinned to the node", node.UUID, env.Name, cert.Serial)
+		return false
+	}
+	return true
+}
+
+// Helper to set the approval of a new node of an environment requiring it,
+// pending unless the node matches an approval rule of the environment
+func (h *HandlersTLS) enrollApproval(env environments.TLSEnvironment, node *nodes.OsqueryNode) {
+	if !env.RequireApproval {
+		return
+	}
+	rule, ok, err := h.Nodes.MatchApprovalRule(env.ID, *node)
+	if err != nil {
+		log.Err(err).Msgf("error matching approval rules for %s", node.UUID)
+	}
+	if !ok {
+		node.Approval = nodes.ApprovalPending
+		return
+	}
+	node.ApprovalBy = "rule " + rule.String()
+	node.ApprovalAt = time.Now()
+}
+
+// Helper to set the approval of a node enrolling again in an environment
+// requiring it. Approved nodes keep their approval while they enroll as the
+// same host, otherwise they are evaluated like new nodes, so a host can not
+// take over the approval of another with its host identifier.
+func (h *HandlersTLS) reenrollApproval(env environments.TLSEnvironment, existing nodes.OsqueryNode, node *nodes.OsqueryNode) {
+	if !env.RequireApproval || !existing.Approved() {
+		return
+	}
+	if strings.EqualFold(existing.HardwareSerial, node.HardwareSerial) && strings.EqualFold(existing.Hostname, node.Hostname) {
+		return
+	}
+	h.enrollApproval(env, node)
+}
+
 // Helper to check if the provided SecretPath is valid for enrolling in a environment
 func (h *HandlersTLS) checkValidEnrollSecretPath(env environments.TLSEnvironment, secretpath string) bool {
 	return h.checkValidRemovePath(secretpath, env.EnrollSecretPath)
@@ -129,3 +252,58 @@ func uniq(duplicated []string) []string {
 func genPackageFilename(envName, osctrlVersion, osqueryVersion, pkgType string) string {
 	return fmt.Sprintf("osctrl-%s-%s-osquery-%s.%s", envName, osctrlVersion, osqueryVersion, pkgType)
 }
+
+// redirectMovedNode sends a request of a node moved out of env to the same
+// path of its current environment, so osquery follows the new environment
+// without enrolling again. It returns false if the node was not moved out of
+// env, and the request must be rejected.
+func (h *HandlersTLS) redirectMovedNode(w http.ResponseWriter, r *http.Request, node nodes.OsqueryNode, env environments.TLSEnvironment) bool {
+	if !h.Nodes.MovedFrom(node.ID, env.ID) {
+		return false
+	}
+	current, err := h.Envs.GetByID(node.EnvironmentID)
+	if err != nil {
+		log.Err(err).Msgf("error getting current environment of moved node %s", node.UUID)
+		return false
+	}
+	host := current.Hostname
+	if host == "" {
+		host = r.Host
+	}
+	location := "https://" + host + movedNodePath(r, env, current)
+	log.Info().Msgf("node UUID: %s moved from %s to %s, redirecting to %s", node.UUID, env.Name, current.Name, location)
+	http.Redirect(w, r, location, http.StatusTemporaryRedirect)
+	return true
+}
+
+// movedNodePath replaces the {env} segment of the request path, as sent by
+// the node, with the UUID of the current environment of the node
+func movedNodePath(r *http.Request, env, current environments.TLSEnvironment) string {
+	envVar := r.PathValue("env")
+	if envVar == "" {
+		envVar = env.UUID
+	}
+	segments := strings.Split(r.URL.Path, "/")
+	for i, segment := range segments {
+		if segment == envVar {
+			segments[i] = current.UUID
+			break
+		}
+	}
+	path := strings.Join(segments, "/")
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+	return path
+}
+
+// nodeTagNames returns a function to retrieve the tag names of a node, used
+// when configuration overlays target tags
+func (h *HandlersTLS) nodeTagNames(node nodes.OsqueryNode) func() ([]string, error) {
+	if h.Tags == nil {
+		return nil
+	}
+	return func() ([]string, error) {
+		return h.Tags.GetTagNames(node)
+	}
+}
@@ -16,6 +16,9 @@ type lastSeenUpdate struct {
 	// time — without this, all nodes in a batch show identical last_seen
 	// values in the UI, masking per-node check-in cadence.
 	SeenAt time.Time
+	// ConfigHash is the expected config hash of the configuration served
+	// to the node, only set by config requests
+	ConfigHash string
 }
 
 // batchWriter encapsulates the batching logic.
@@ -58,7 +61,11 @@ func (bw *batchWriter) run() {
 				}
 				return
 			}
-			// Overwrite any existing event for the same NodeID.
+			// Overwrite any existing event for the same NodeID, keeping
+			// the served config hash when the new event has none.
+			if prev, ok := batch[ev.NodeID]; ok && ev.ConfigHash == "" {
+				ev.ConfigHash = prev.ConfigHash
+			}
 			batch[ev.NodeID] = ev
 
 			// Flush if we have reached the batch size threshold.
@@ -115,6 +122,24 @@ func (bw *batchWriter) flush(batch map[uint]lastSeenUpdate) {
 	}
 	lastSeenDuration := time.Since(lastSeenStart).Seconds()
 
+	// Record the config hash expected from nodes that got a configuration,
+	// with one update per configuration served
+	expectedStart := time.Now()
+	served := make(map[string][]uint)
+	for _, ev := range batch {
+		if ev.ConfigHash != "" {
+			served[ev.ConfigHash] = append(served[ev.ConfigHash], ev.NodeID)
+		}
+	}
+	for hash, nodeIDs := range served {
+		if err := bw.nodesRepo.UpdateExpectedConfigBatch(nodeIDs, hash, expectedStart); err != nil {
+			log.Err(err).Int("count", len(nodeIDs)).Str("config_hash", hash).Msg("updating expected config hash failed")
+		}
+	}
+	if len(served) > 0 {
+		batchFlushDuration.WithLabelValues("expected_config_update").Observe(time.Since(expectedStart).Seconds())
+	}
+
 	// Record total flush duration and batch last_seen update duration
 	totalDuration := time.Since(start).Seconds()
 	batchFlushDuration.WithLabelValues("total").Observe(totalDuration)
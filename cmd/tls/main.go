@@ -18,6 +18,7 @@ import (
 	"github.com/jmpsec/osctrl/pkg/cache"
 	"github.com/jmpsec/osctrl/pkg/carves"
 	"github.com/jmpsec/osctrl/pkg/config"
+	"github.com/jmpsec/osctrl/pkg/console"
 	"github.com/jmpsec/osctrl/pkg/environments"
 	"github.com/jmpsec/osctrl/pkg/logging"
 	"github.com/jmpsec/osctrl/pkg/nodes"
@@ -221,6 +222,14 @@ func osctrlService() {
 	settingsmgr = settings.NewSettings(db.Conn)
 	log.Info().Msg("Initialize nodes")
 	nodesmgr = nodes.CreateNodes(db.Conn)
+	// Nodes changed by osctrl-api are read again by every replica
+	nodesmgr.Cache.SetInvalidationVersion(func(ctx context.Context, nodeKey string) string {
+		version, err := redis.Client.Get(ctx, nodes.RedisNodeInvalidatePrefix+nodeKey).Result()
+		if err != nil {
+			return ""
+		}
+		return version
+	})
 	var posturemgr *posture.PostureManager
 	if flagParams.Service.PostureEnabled {
 		posture.SetPrefix(flagParams.Service.PostureQueryPrefix)
@@ -236,6 +245,14 @@ func osctrlService() {
 	queriesmgr = queries.CreateQueries(db.Conn)
 	log.Info().Msg("Initialize carves")
 	filecarves = carves.CreateFileCarves(db.Conn, flagParams.Carver.Type, carvers3)
+	if flagParams.Carver.KeyFile != "" {
+		carveKeys, err := carves.LoadKeyRing(flagParams.Carver.KeyFile)
+		if err != nil {
+			log.Fatal().Msgf("Error loading carves keyfile - %v", err)
+		}
+		filecarves.WithEncryption(carveKeys)
+		log.Info().Msg("Carves encryption at rest enabled")
+	}
 	log.Info().Msg("Loading service settings")
 	if err := loadingSettings(settingsmgr, flagParams); err != nil {
 		log.Fatal().Msgf("Error loading settings - %v", err)
@@ -269,6 +286,8 @@ func osctrlService() {
 	if err != nil {
 		log.Fatal().Msgf("Error loading logger - %s: %v", flagParams.Logger.Type, err)
 	}
+	// Console streams in osctrl-api learn about query results through Redis
+	loggerTLS.WithNotifier(console.NewBroker(nil).WithRedis(redis.Client))
 	if flagParams.Metrics.Enabled {
 		log.Info().Msg("Metrics are enabled")
 		// Register Prometheus metrics
@@ -399,6 +418,9 @@ func osctrlService() {
 				tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
 				tls.TLS_RSA_WITH_AES_256_CBC_SHA,
 			},
+			// Client certificates are verified by the handlers against the
+			// client CA of the environment of each request
+			ClientAuth: tls.RequestClientCert,
 		}
 		srv := &http.Server{
 			Addr:         serviceListener,
@@ -47,6 +47,8 @@ export interface RunCarveBody {
   environment_list?: string[];
   host_list?: string[];
   tag_list?: string[];
+  /** Custom node attributes as name=value. */
+  attribute_list?: string[];
   exp_hours?: number;
 }
 
@@ -1,6 +1,7 @@
 import { apiFetch } from './client';
 import type {
   ConsoleCommand,
+  ConsoleCompletion,
   ConsoleHistoryEntry,
   ConsoleResultRow,
   ConsoleSession,
@@ -63,3 +64,15 @@ export function getConsoleCommandResults(
     `/api/v1/console/${encodeURIComponent(env)}/sessions/${sessionId}/commands/${commandId}/results`,
   );
 }
+
+export function completeConsoleInput(
+  env: string,
+  sessionId: number,
+  input: string,
+  osqueryMode = false,
+): Promise<ConsoleCompletion> {
+  const params = new URLSearchParams({ input, osquery_mode: String(osqueryMode) });
+  return apiFetch<ConsoleCompletion>(
+    `/api/v1/console/${encodeURIComponent(env)}/sessions/${sessionId}/complete?${params.toString()}`,
+  );
+}
@@ -52,6 +52,8 @@ export interface TLSEnvironment {
   carver_init_path: string;
   carver_block_path: string;
   accept_enrolls: boolean;
+  require_approval: boolean;
+  client_cert_auth: boolean;
   user_id: number;
 }
 
@@ -69,6 +71,8 @@ export interface EnvUpdateRequest {
   icon?: string;
   debug_http?: boolean;
   accept_enrolls?: boolean;
+  require_approval?: boolean;
+  client_cert_auth?: boolean;
 }
 
 export interface EnvConfigResponse {
@@ -78,6 +82,10 @@ export interface EnvConfigResponse {
   decorators: string;
   atc: string;
   flags: string;
+  /** Revision of the assembled configuration, when the patch changed it. */
+  revision?: number;
+  /** Rollout started with the revision. */
+  rollout_id?: number;
 }
 
 export interface EnvConfigPatchRequest {
@@ -87,6 +95,102 @@ export interface EnvConfigPatchRequest {
   decorators?: string;
   atc?: string;
   flags?: string;
+  /** Stored with the configuration revision. */
+  reason?: string;
+  /**
+   * Stages the new revision to a share of the nodes, created with the write.
+   * Writes are rejected with 409 while a rollout is pending.
+   */
+  rollout?: EnvRolloutStages;
+}
+
+/**
+ * ConfigRevision — immutable copy of an assembled configuration. The list
+ * endpoint omits `configuration`.
+ */
+export interface ConfigRevision {
+  id: number;
+  created_at: string;
+  environment_id: number;
+  revision: number;
+  hash: string;
+  author: string;
+  reason: string;
+  /** Revision restored by this one, 0 when it is not a rollback. */
+  rollback_of: number;
+  configuration?: string;
+}
+
+export interface EnvRevisionDiff {
+  from: number;
+  to: number;
+  /** Unified diff, empty when both revisions are the same. */
+  diff: string;
+}
+
+export type ConfigRolloutStatus = 'active' | 'halted' | 'completed' | 'aborted';
+
+/**
+ * ConfigRollout — staged rollout of a revision. Nodes in the cohort (by
+ * bucket under `percent`, or with `canary_tag`) get `to_revision`, the rest
+ * `from_revision`; halted rollouts serve `from_revision` to every node.
+ */
+export interface ConfigRollout {
+  id: number;
+  created_at: string;
+  updated_at: string;
+  environment_id: number;
+  from_revision: number;
+  to_revision: number;
+  canary_tag: string;
+  percent: number;
+  step_percent: number;
+  step_minutes: number;
+  max_error_rate: number;
+  min_adoption: number;
+  status: ConfigRolloutStatus;
+  stage_started_at: string;
+  finished_at: string;
+  halt_reason: string;
+  created_by: string;
+}
+
+export interface RolloutHealth {
+  cohort_nodes: number;
+  reporting_nodes: number;
+  adopted_nodes: number;
+  status_logs: number;
+  status_errors: number;
+  baseline_logs: number;
+  baseline_errors: number;
+}
+
+export interface RolloutReport {
+  rollout: ConfigRollout;
+  /** Zero for rollouts that are no longer pending. */
+  health: RolloutHealth;
+  adoption: number;
+  error_rate: number;
+  baseline_error_rate: number;
+}
+
+export type RolloutAction = 'halt' | 'resume' | 'promote' | 'abort';
+
+/** Rollouts are started by the `rollout` of EnvConfigPatchRequest. */
+export interface EnvRolloutRequest {
+  action: RolloutAction;
+  reason?: string;
+}
+
+/** Stages and thresholds of a rollout; zero values use defaults. */
+export interface EnvRolloutStages {
+  from_revision?: number;
+  canary_tag?: string;
+  percent?: number;
+  step_percent?: number;
+  step_minutes?: number;
+  max_error_rate?: number;
+  min_adoption?: number;
 }
 
 export interface EnvIntervalsPatchRequest {
@@ -204,3 +308,155 @@ export function patchEnvironmentExpiration(
     },
   );
 }
+
+/** GET /api/v1/environments/revisions/{env} — config revisions, most recent first. */
+export function listEnvironmentRevisions(env: string): Promise<ConfigRevision[]> {
+  return apiFetch<ConfigRevision[]>(
+    `/api/v1/environments/revisions/${encodeURIComponent(env)}`,
+  );
+}
+
+/** GET /api/v1/environments/revision/{env}?revision=N — latest when omitted. */
+export function getEnvironmentRevision(env: string, revision?: number): Promise<ConfigRevision> {
+  const query = revision ? `?revision=${revision}` : '';
+  return apiFetch<ConfigRevision>(
+    `/api/v1/environments/revision/${encodeURIComponent(env)}${query}`,
+  );
+}
+
+/** GET /api/v1/environments/diff/{env}?from=A&to=B — `to` defaults to latest. */
+export function diffEnvironmentRevisions(
+  env: string,
+  from: number,
+  to?: number,
+): Promise<EnvRevisionDiff> {
+  const params = new URLSearchParams({ from: String(from) });
+  if (to) params.set('to', String(to));
+  return apiFetch<EnvRevisionDiff>(
+    `/api/v1/environments/diff/${encodeURIComponent(env)}?${params.toString()}`,
+  );
+}
+
+/** POST /api/v1/environments/rollback/{env} — restore a revision atomically. */
+export function rollbackEnvironmentConfig(
+  env: string,
+  revision: number,
+  reason = '',
+): Promise<ConfigRevision> {
+  return apiFetch<ConfigRevision>(
+    `/api/v1/environments/rollback/${encodeURIComponent(env)}`,
+    {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ revision, reason }),
+    },
+  );
+}
+
+/** GET /api/v1/environments/rollout/{env} — most recent rollout with its health. */
+export function getEnvironmentRollout(env: string): Promise<RolloutReport> {
+  return apiFetch<RolloutReport>(
+    `/api/v1/environments/rollout/${encodeURIComponent(env)}`,
+  );
+}
+
+/** GET /api/v1/environments/rollouts/{env} — most recent first. */
+export function listEnvironmentRollouts(env: string): Promise<ConfigRollout[]> {
+  return apiFetch<ConfigRollout[]>(
+    `/api/v1/environments/rollouts/${encodeURIComponent(env)}`,
+  );
+}
+
+/** POST /api/v1/environments/rollout/{env} — change the pending rollout. */
+export function environmentRolloutAction(
+  env: string,
+  body: EnvRolloutRequest,
+): Promise<ConfigRollout> {
+  return apiFetch<ConfigRollout>(
+    `/api/v1/environments/rollout/${encodeURIComponent(env)}`,
+    {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(body),
+    },
+  );
+}
+
+/** Mirrors pkg/environments.ClientCertificate. */
+export interface ClientCertificate {
+  id: number;
+  created_at: string;
+  environment_id: number;
+  serial: string;
+  common_name: string;
+  fingerprint: string;
+  not_after: string;
+  revoked_at: string | null;
+  revoked_by: string;
+  reason: string;
+  created_by: string;
+}
+
+export interface EnvClientCertRequest {
+  common_name: string;
+  validity_days?: number;
+}
+
+/** The key is only returned when the certificate is issued. */
+export interface EnvClientCertResponse {
+  serial: string;
+  common_name: string;
+  not_after: string;
+  certificate: string;
+  key: string;
+  ca: string;
+}
+
+/** GET /api/v1/environments/certs/{env} — newest first. */
+export function listClientCerts(env: string): Promise<ClientCertificate[]> {
+  return apiFetch<ClientCertificate[]>(
+    `/api/v1/environments/certs/${encodeURIComponent(env)}`,
+  );
+}
+
+/** POST /api/v1/environments/certs/{env} — issue a client certificate for a node. */
+export function issueClientCert(
+  env: string,
+  body: EnvClientCertRequest,
+): Promise<EnvClientCertResponse> {
+  return apiFetch<EnvClientCertResponse>(
+    `/api/v1/environments/certs/${encodeURIComponent(env)}`,
+    {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(body),
+    },
+  );
+}
+
+/** DELETE /api/v1/environments/certs/{env}/{serial} — revoke a client certificate. */
+export function revokeClientCert(
+  env: string,
+  serial: string,
+  reason = '',
+): Promise<{ message: string }> {
+  const query = reason ? `?reason=${encodeURIComponent(reason)}` : '';
+  return apiFetch<{ message: string }>(
+    `/api/v1/environments/certs/${encodeURIComponent(env)}/${encodeURIComponent(serial)}${query}`,
+    { method: 'DELETE' },
+  );
+}
+
+/** GET /api/v1/environments/certs/ca/{env} — PEM certificate of the client CA. */
+export function getClientCA(env: string): Promise<{ data: string }> {
+  return apiFetch<{ data: string }>(
+    `/api/v1/environments/certs/ca/${encodeURIComponent(env)}`,
+  );
+}
+
+/** GET /api/v1/environments/certs/crl/{env} — PEM revocation list of the client CA. */
+export function getClientCRL(env: string): Promise<{ data: string }> {
+  return apiFetch<{ data: string }>(
+    `/api/v1/environments/certs/crl/${encodeURIComponent(env)}`,
+  );
+}
@@ -1,5 +1,29 @@
 import { apiFetch } from './client';
-import type { NodePosture, PostureProfile, PostureScore } from './types';
+import type {
+  NodePosture,
+  NodeTimelineEvent,
+  PostureProfile,
+  PostureScore,
+  StalePolicy,
+  StaleReport,
+  DuplicateGroup,
+  NodeMergeResult,
+  NodeMove,
+  NodeKeyRevocation,
+  NodeRevokeRequest,
+  NodeRevokeResult,
+  NodeQuarantine,
+  QuarantinedNode,
+  ApprovalRule,
+  ApprovalRuleResult,
+  ConfigOverlay,
+  ConfigOverlayRequest,
+  NodeConfigPreview,
+  DriftReport,
+  NodeAttribute,
+  NodeAttributeType,
+  AttributeImportResult,
+} from './types';
 import type {
   NodesPagedResponse,
   OsqueryNode,
@@ -22,6 +46,8 @@ export interface ListNodesParams {
   pageSize?: number;
   /** Narrow to one platform bucket. Empty / omitted means "all". */
   platform?: NodePlatform;
+  /** Custom attributes as name=value; every one must match. */
+  attributes?: string[];
 }
 
 export function listNodes(p: ListNodesParams): Promise<NodesPagedResponse> {
@@ -33,6 +59,7 @@ export function listNodes(p: ListNodesParams): Promise<NodesPagedResponse> {
   if (p.page != null) params.set('page', String(p.page));
   if (p.pageSize != null) params.set('page_size', String(p.pageSize));
   if (p.platform) params.set('platform', p.platform);
+  for (const a of p.attributes ?? []) params.append('attr', a);
 
   const qs = params.toString();
   return apiFetch<NodesPagedResponse>(
@@ -46,6 +73,247 @@ export function getNode(env: string, uuid: string): Promise<OsqueryNode> {
   );
 }
 
+/**
+ * GET /api/v1/nodes/{env}/node/{node}/timeline — enrollments, metadata
+ * changes, queries, carves, console sessions and tag changes of a node,
+ * oldest first. AdminLevel-gated server-side.
+ */
+export function getNodeTimeline(env: string, uuid: string): Promise<NodeTimelineEvent[]> {
+  return apiFetch<NodeTimelineEvent[]>(
+    `/api/v1/nodes/${encodeURIComponent(env)}/node/${encodeURIComponent(uuid)}/timeline`,
+  );
+}
+
+/** GET /api/v1/nodes/{env}/node/{node}/attributes — custom attributes of a node. */
+export function getNodeAttributes(env: string, uuid: string): Promise<NodeAttribute[]> {
+  return apiFetch<NodeAttribute[]>(
+    `/api/v1/nodes/${encodeURIComponent(env)}/node/${encodeURIComponent(uuid)}/attributes`,
+  );
+}
+
+/** PUT /api/v1/nodes/{env}/node/{node}/attributes — create or replace one attribute. */
+export function setNodeAttribute(
+  env: string,
+  uuid: string,
+  attr: { name: string; type?: NodeAttributeType; value: string },
+): Promise<NodeAttribute> {
+  return apiFetch<NodeAttribute>(
+    `/api/v1/nodes/${encodeURIComponent(env)}/node/${encodeURIComponent(uuid)}/attributes`,
+    {
+      method: 'PUT',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(attr),
+    },
+  );
+}
+
+/** DELETE /api/v1/nodes/{env}/node/{node}/attributes/{name} */
+export function deleteNodeAttribute(env: string, uuid: string, name: string): Promise<{ message: string }> {
+  return apiFetch<{ message: string }>(
+    `/api/v1/nodes/${encodeURIComponent(env)}/node/${encodeURIComponent(uuid)}/attributes/${encodeURIComponent(name)}`,
+    { method: 'DELETE' },
+  );
+}
+
+/**
+ * POST /api/v1/nodes/{env}/attributes/import — bulk import from CSV text
+ * (header with a uuid, hostname or serial column and name[:type] columns)
+ * or JSON records.
+ */
+export function importNodeAttributes(env: string, body: string, format: 'csv' | 'json'): Promise<AttributeImportResult> {
+  return apiFetch<AttributeImportResult>(`/api/v1/nodes/${encodeURIComponent(env)}/attributes/import`, {
+    method: 'POST',
+    headers: { 'Content-Type': format === 'csv' ? 'text/csv' : 'application/json' },
+    body,
+  });
+}
+
+/** GET /api/v1/nodes/{env}/stale/policy — AdminLevel-gated server-side. */
+export function getStalePolicy(env: string): Promise<StalePolicy> {
+  return apiFetch<StalePolicy>(`/api/v1/nodes/${encodeURIComponent(env)}/stale/policy`);
+}
+
+/**
+ * PUT /api/v1/nodes/{env}/stale/policy — days after which nodes that stopped
+ * checking in are archived, and after which archives are purged. Omitted
+ * fields keep their value; zero disables the step.
+ */
+export function updateStalePolicy(
+  env: string,
+  policy: Partial<Pick<StalePolicy, 'archive_days' | 'purge_days'>>,
+): Promise<StalePolicy> {
+  return apiFetch<StalePolicy>(`/api/v1/nodes/${encodeURIComponent(env)}/stale/policy`, {
+    method: 'PUT',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(policy),
+  });
+}
+
+/** GET /api/v1/nodes/{env}/stale/report — dry run of the stale policy. */
+export function getStaleReport(env: string): Promise<StaleReport> {
+  return apiFetch<StaleReport>(`/api/v1/nodes/${encodeURIComponent(env)}/stale/report`);
+}
+
+/** GET /api/v1/nodes/{env}/duplicates — nodes sharing a serial, UUID or hostname. */
+export function listNodeDuplicates(env: string): Promise<DuplicateGroup[]> {
+  return apiFetch<DuplicateGroup[]>(`/api/v1/nodes/${encodeURIComponent(env)}/duplicates`);
+}
+
+/**
+ * POST /api/v1/nodes/{env}/duplicates/merge — keeps the newest node, moves
+ * tags, query history and carves of the others to it and archives them.
+ */
+export function mergeNodes(env: string, nodeIds: number[]): Promise<NodeMergeResult> {
+  return apiFetch<NodeMergeResult>(`/api/v1/nodes/${encodeURIComponent(env)}/duplicates/merge`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ node_ids: nodeIds }),
+  });
+}
+
+/**
+ * POST /api/v1/nodes/{env}/move — reassigns nodes to another environment
+ * without enrolling them again. Nodes already there are skipped.
+ */
+export function moveNodes(env: string, uuids: string[], environment: string): Promise<NodeMove[]> {
+  return apiFetch<NodeMove[]>(`/api/v1/nodes/${encodeURIComponent(env)}/move`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ uuid_list: uuids, environment }),
+  });
+}
+
+/**
+ * POST /api/v1/nodes/{env}/revoke — replaces the node_key of the picked nodes
+ * so osquery enrolls again, optionally rotating the enroll secret too.
+ */
+export function revokeNodes(env: string, req: NodeRevokeRequest): Promise<NodeRevokeResult> {
+  return apiFetch<NodeRevokeResult>(`/api/v1/nodes/${encodeURIComponent(env)}/revoke`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(req),
+  });
+}
+
+/** GET /api/v1/nodes/{env}/revocations — node key revocations, newest first. */
+export function listNodeRevocations(env: string): Promise<NodeKeyRevocation[]> {
+  return apiFetch<NodeKeyRevocation[]>(`/api/v1/nodes/${encodeURIComponent(env)}/revocations`);
+}
+
+/**
+ * POST /api/v1/nodes/{env}/node/{node}/quarantine — the node is only served
+ * the incident response config and no distributed queries until released.
+ */
+export function quarantineNode(env: string, node: string, reason: string): Promise<NodeQuarantine> {
+  return apiFetch<NodeQuarantine>(
+    `/api/v1/nodes/${encodeURIComponent(env)}/node/${encodeURIComponent(node)}/quarantine`,
+    {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ reason }),
+    },
+  );
+}
+
+/** DELETE /api/v1/nodes/{env}/node/{node}/quarantine — release from quarantine. */
+export function releaseNode(env: string, node: string): Promise<NodeQuarantine> {
+  return apiFetch<NodeQuarantine>(
+    `/api/v1/nodes/${encodeURIComponent(env)}/node/${encodeURIComponent(node)}/quarantine`,
+    { method: 'DELETE' },
+  );
+}
+
+/** GET /api/v1/nodes/{env}/quarantine — nodes in quarantine, most recent first. */
+export function listQuarantinedNodes(env: string): Promise<QuarantinedNode[]> {
+  return apiFetch<QuarantinedNode[]>(`/api/v1/nodes/${encodeURIComponent(env)}/quarantine`);
+}
+
+/** GET /api/v1/nodes/{env}/approvals — nodes pending approval, or rejected, oldest first. */
+export function listApprovalNodes(env: string, status: 'pending' | 'rejected' = 'pending'): Promise<OsqueryNode[]> {
+  return apiFetch<OsqueryNode[]>(
+    `/api/v1/nodes/${encodeURIComponent(env)}/approvals?status=${encodeURIComponent(status)}`,
+  );
+}
+
+/** POST /api/v1/nodes/{env}/node/{node}/approve — serve it the environment config and queries. */
+export function approveNode(env: string, node: string): Promise<{ message: string }> {
+  return apiFetch<{ message: string }>(
+    `/api/v1/nodes/${encodeURIComponent(env)}/node/${encodeURIComponent(node)}/approve`,
+    { method: 'POST' },
+  );
+}
+
+/** POST /api/v1/nodes/{env}/node/{node}/reject — keeps an empty config, also after enrolling again. */
+export function rejectNode(env: string, node: string): Promise<{ message: string }> {
+  return apiFetch<{ message: string }>(
+    `/api/v1/nodes/${encodeURIComponent(env)}/node/${encodeURIComponent(node)}/reject`,
+    { method: 'POST' },
+  );
+}
+
+/** GET /api/v1/nodes/{env}/approvals/rules */
+export function listApprovalRules(env: string): Promise<ApprovalRule[]> {
+  return apiFetch<ApprovalRule[]>(`/api/v1/nodes/${encodeURIComponent(env)}/approvals/rules`);
+}
+
+/** POST /api/v1/nodes/{env}/approvals/rules — also approves the pending nodes matching it. */
+export function createApprovalRule(
+  env: string,
+  type: ApprovalRule['type'],
+  pattern: string,
+): Promise<ApprovalRuleResult> {
+  return apiFetch<ApprovalRuleResult>(`/api/v1/nodes/${encodeURIComponent(env)}/approvals/rules`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ type, pattern }),
+  });
+}
+
+/** DELETE /api/v1/nodes/{env}/approvals/rules/{id} — approved nodes stay approved. */
+export function deleteApprovalRule(env: string, id: number): Promise<{ message: string }> {
+  return apiFetch<{ message: string }>(
+    `/api/v1/nodes/${encodeURIComponent(env)}/approvals/rules/${id}`,
+    { method: 'DELETE' },
+  );
+}
+
+/** GET /api/v1/nodes/{env}/overlays — config overlays in the order they are merged. */
+export function listConfigOverlays(env: string): Promise<ConfigOverlay[]> {
+  return apiFetch<ConfigOverlay[]>(`/api/v1/nodes/${encodeURIComponent(env)}/overlays`);
+}
+
+/** PUT /api/v1/nodes/{env}/overlays/{name} — create or replace an overlay. */
+export function setConfigOverlay(env: string, name: string, req: ConfigOverlayRequest): Promise<ConfigOverlay> {
+  return apiFetch<ConfigOverlay>(
+    `/api/v1/nodes/${encodeURIComponent(env)}/overlays/${encodeURIComponent(name)}`,
+    {
+      method: 'PUT',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(req),
+    },
+  );
+}
+
+/** DELETE /api/v1/nodes/{env}/overlays/{name} */
+export function deleteConfigOverlay(env: string, name: string): Promise<{ message: string }> {
+  return apiFetch<{ message: string }>(
+    `/api/v1/nodes/${encodeURIComponent(env)}/overlays/${encodeURIComponent(name)}`,
+    { method: 'DELETE' },
+  );
+}
+
+/** GET /api/v1/nodes/{env}/node/{node}/config — effective config served to a node. */
+export function getNodeConfig(env: string, node: string): Promise<NodeConfigPreview> {
+  return apiFetch<NodeConfigPreview>(
+    `/api/v1/nodes/${encodeURIComponent(env)}/node/${encodeURIComponent(node)}/config`,
+  );
+}
+
+/** GET /api/v1/nodes/{env}/drift — nodes with configuration drift. */
+export function getDriftedNodes(env: string): Promise<DriftReport> {
+  return apiFetch<DriftReport>(`/api/v1/nodes/${encodeURIComponent(env)}/drift`);
+}
+
 /**
  * POST /api/v1/nodes/{env}/delete — archive + delete a node.
  *
@@ -68,6 +68,8 @@ export interface RunQueryBody {
   environment_list?: string[];
   host_list?: string[];
   tag_list?: string[];
+  /** Custom node attributes as name=value. */
+  attribute_list?: string[];
   hidden?: boolean;
   exp_hours?: number;
 }
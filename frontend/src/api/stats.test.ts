@@ -22,6 +22,7 @@ const STUB_RESPONSE: StatsResponse = {
   total_active_queries: 2,
   total_active_carves: 1,
   platform_counts: { linux: 6, darwin: 2, windows: 2, other: 0 },
+  drifted_nodes: 1,
   environments: [
     {
       uuid: 'env-uuid-1',
@@ -32,6 +33,7 @@ const STUB_RESPONSE: StatsResponse = {
       active_queries: 2,
       active_carves: 1,
       platform_counts: { linux: 6, darwin: 2, windows: 2, other: 0 },
+      drifted: 1,
     },
   ],
 };
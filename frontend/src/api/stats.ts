@@ -23,6 +23,8 @@ export interface EnvStats {
   active_carves: number;
   /** Per-env breakdown by OS family. */
   platform_counts: PlatformCounts;
+  /** Nodes reporting a config hash other than their served configuration. */
+  drifted: number;
 }
 
 export interface StatsResponse {
@@ -34,6 +36,8 @@ export interface StatsResponse {
   total_active_carves: number;
   /** Cross-env aggregate (sum of every env.platform_counts the user can see). */
   platform_counts: PlatformCounts;
+  /** Sum of every env.drifted the user can see. */
+  drifted_nodes: number;
   environments: EnvStats[];
 }
 
@@ -89,6 +89,16 @@ export interface OsqueryNode {
   user_id: number;
   environment_id: number;
   extra_data: string;
+  /** Only served the incident response config and no distributed queries. */
+  quarantined?: boolean;
+  /** Config hash osquery should report for the last served configuration. */
+  expected_config_hash?: string;
+  /** When the node was first served that configuration. */
+  expected_config_at?: string;
+  /** Pending or rejected until approved, empty for approved nodes. */
+  approval?: '' | 'pending' | 'rejected';
+  approval_by?: string;
+  approval_at?: string;
   /** ISO 3166-1 alpha-2 country code from GeoIP, or empty. */
   country_code?: string;
   /** Optional enrichment parsed server-side from RawEnrollment (no secrets). */
@@ -282,6 +292,26 @@ export interface ConsoleSessionResponse {
   node_info?: ConsoleNodeInfo;
 }
 
+export type ConsoleCandidateKind = 'command' | 'directory' | 'file' | 'table' | 'column';
+
+export interface ConsoleCandidate {
+  value: string;
+  kind: ConsoleCandidateKind;
+}
+
+/**
+ * Tab completion for the console input up to the cursor. Clients replace
+ * `token` at the end of the input with a candidate, or with `common` when it
+ * is longer than the token. `pending` means the node is still listing the
+ * directory and the completion should be requested again.
+ */
+export interface ConsoleCompletion {
+  status: 'ready' | 'pending';
+  token: string;
+  common: string;
+  candidates: ConsoleCandidate[];
+}
+
 // ---------------------------------------------------------------------------
 // Saved queries
 // ---------------------------------------------------------------------------
@@ -470,13 +500,218 @@ export interface OsqueryTableColumn {
   type: string;
 }
 
+export interface OsqueryColumn {
+  name: string;
+  type: string;
+  description: string;
+  hidden: boolean;
+}
+
 export interface OsqueryTable {
   name: string;
   url: string;
   platforms: string[];
+  columns?: OsqueryColumn[];
   filter: string;
 }
 
+/** Kind of a node timeline event, mirrors the Timeline* constants in pkg/types. */
+export type NodeTimelineEventType =
+  | 'enroll'
+  | 'reenroll'
+  | 'archive'
+  | 'metadata'
+  | 'query'
+  | 'carve'
+  | 'console'
+  | 'tag'
+  | 'untag'
+  | 'move'
+  | 'revoke'
+  | 'quarantine'
+  | 'release';
+
+/** One entry of GET /api/v1/nodes/{env}/node/{node}/timeline. */
+export interface NodeTimelineEvent {
+  time: string;
+  type: NodeTimelineEventType;
+  summary: string;
+  actor?: string;
+  details?: Record<string, string>;
+}
+
+/** Stale node policy of an environment; zero days disable a step. */
+export interface StalePolicy {
+  inactive_hours: number;
+  archive_days: number;
+  purge_days: number;
+}
+
+/** Outcome, or dry run, of applying a stale node policy. */
+export interface StaleReport {
+  environment_id: number;
+  policy: StalePolicy;
+  dry_run: boolean;
+  ran_at: string;
+  inactive: number;
+  archived: { uuid: string; hostname: string; platform: string; last_seen: string }[];
+  purged: { id: number; uuid: string; hostname: string; trigger: string; archived_at: string }[];
+}
+
+/** Nodes whose config_hash disagrees with expected_config_hash since before cutoff. */
+export interface DriftReport {
+  /** Config intervals a disagreement may last before the node is drifted. */
+  intervals: number;
+  /** Config interval of the environment in seconds. */
+  config_interval: number;
+  cutoff: string;
+  count: number;
+  drifted: OsqueryNode[];
+}
+
+export type NodeAttributeType = 'string' | 'number' | 'bool';
+
+/** Typed custom attribute of a node, like its owner or criticality. */
+export interface NodeAttribute {
+  id: number;
+  created_at: string;
+  updated_at: string;
+  node_id: number;
+  environment_id: number;
+  name: string;
+  type: NodeAttributeType;
+  value: string;
+  set_by: string;
+}
+
+/** Outcome of a bulk attribute import. */
+export interface AttributeImportResult {
+  records: number;
+  nodes: number;
+  updated: number;
+  unmatched: string[];
+}
+
+/** Nodes that look like the same machine, newest first. */
+export interface DuplicateGroup {
+  reason: 'hardware_serial' | 'uuid' | 'hostname_platform';
+  value: string;
+  nodes: OsqueryNode[];
+}
+
+/** Outcome of merging duplicate nodes into the newest one. */
+export interface NodeMergeResult {
+  kept: string;
+  archived: string[];
+}
+
+/** A node reassigned to another environment. */
+export interface NodeMove {
+  id: number;
+  created_at: string;
+  node_id: number;
+  uuid: string;
+  from_environment_id: number;
+  from_environment: string;
+  to_environment_id: number;
+  to_environment: string;
+  moved_by: string;
+}
+
+/** A revoked node_key, only a hash of the key is kept. */
+export interface NodeKeyRevocation {
+  id: number;
+  created_at: string;
+  node_id: number;
+  uuid: string;
+  hostname: string;
+  environment_id: number;
+  key_hash: string;
+  reason: string;
+  revoked_by: string;
+}
+
+/** Nodes to revoke, picked by UUIDs, by tag or all of the environment. */
+export interface NodeRevokeRequest {
+  uuid_list?: string[];
+  tag?: string;
+  all?: boolean;
+  reason?: string;
+  rotate_secret?: boolean;
+}
+
+export interface NodeRevokeResult {
+  revoked: string[];
+  secret_rotated: boolean;
+}
+
+/** A quarantine of a node, released when released_at is set. */
+export interface NodeQuarantine {
+  id: number;
+  created_at: string;
+  node_id: number;
+  uuid: string;
+  environment_id: number;
+  reason: string;
+  quarantined_by: string;
+  released_at: string | null;
+  released_by: string;
+}
+
+/** A node in quarantine with the record of its quarantine. */
+export interface QuarantinedNode {
+  node: OsqueryNode;
+  quarantine: NodeQuarantine;
+}
+
+/** Approves nodes by hardware serial or hostname pattern, like web-*. */
+export interface ApprovalRule {
+  id: number;
+  created_at: string;
+  environment_id: number;
+  type: 'serial' | 'hostname';
+  pattern: string;
+  created_by: string;
+}
+
+/** A new approval rule with the UUIDs of the pending nodes it approved. */
+export interface ApprovalRuleResult {
+  rule: ApprovalRule;
+  approved: string[];
+}
+
+export type ConfigOverlayTargetType = 'tag' | 'platform';
+
+/** A partial osquery config merged onto the env config for a tag or platform. */
+export interface ConfigOverlay {
+  id: number;
+  created_at: string;
+  updated_at: string;
+  environment_id: number;
+  name: string;
+  target_type: ConfigOverlayTargetType;
+  target: string;
+  priority: number;
+  /** Serialized partial osquery configuration. */
+  configuration: string;
+  created_by: string;
+}
+
+export interface ConfigOverlayRequest {
+  target_type: ConfigOverlayTargetType;
+  target: string;
+  priority: number;
+  configuration: Record<string, unknown>;
+}
+
+/** The config served to a node and the overlays merged into it. */
+export interface NodeConfigPreview {
+  overlays: string[];
+  quarantined: boolean;
+  approval?: 'pending' | 'rejected';
+  configuration: Record<string, unknown>;
+}
+
 export interface NodePosture {
   id: number;
   created_at: string;
@@ -57,6 +57,7 @@ function makeStatsResponse(overrides: Partial<StatsResponse> = {}): StatsRespons
     total_active_queries: 2,
     total_active_carves: 1,
     platform_counts: { linux: 6, darwin: 2, windows: 2, other: 0 },
+    drifted_nodes: 0,
     environments: [
       {
         uuid: 'env-uuid-1',
@@ -67,6 +68,7 @@ function makeStatsResponse(overrides: Partial<StatsResponse> = {}): StatsRespons
         active_queries: 1,
         active_carves: 0,
         platform_counts: { linux: 4, darwin: 2, windows: 1, other: 0 },
+        drifted: 0,
       },
       {
         uuid: 'env-uuid-2',
@@ -77,6 +79,7 @@ function makeStatsResponse(overrides: Partial<StatsResponse> = {}): StatsRespons
         active_queries: 1,
         active_carves: 1,
         platform_counts: { linux: 2, darwin: 0, windows: 1, other: 0 },
+        drifted: 0,
       },
     ],
     ...overrides,
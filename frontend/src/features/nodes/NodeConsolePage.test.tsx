@@ -11,6 +11,7 @@ const consoleApi = vi.hoisted(() => ({
   submitConsoleCommand: vi.fn(),
   getConsoleCommand: vi.fn(),
   getConsoleCommandResults: vi.fn(),
+  completeConsoleInput: vi.fn(),
 }));
 
 vi.mock('@tanstack/react-router', () => ({
@@ -25,6 +26,7 @@ vi.mock('$/api/console', () => ({
   submitConsoleCommand: consoleApi.submitConsoleCommand,
   getConsoleCommand: consoleApi.getConsoleCommand,
   getConsoleCommandResults: consoleApi.getConsoleCommandResults,
+  completeConsoleInput: consoleApi.completeConsoleInput,
 }));
 
 describe('NodeConsolePanel', () => {
@@ -220,6 +222,39 @@ describe('NodeConsolePanel', () => {
     expect(screen.queryByText(/waiting for node/i)).not.toBeInTheDocument();
   });
 
+  it('completes paths with Tab and lists ambiguous candidates', async () => {
+    consoleApi.completeConsoleInput
+      .mockResolvedValueOnce({
+        status: 'ready',
+        token: '/et',
+        common: '/etc/',
+        candidates: [{ value: '/etc/', kind: 'directory' }],
+      })
+      .mockResolvedValueOnce({
+        status: 'ready',
+        token: '/etc/pa',
+        common: '/etc/pa',
+        candidates: [
+          { value: '/etc/pam.d/', kind: 'directory' },
+          { value: '/etc/passwd', kind: 'file' },
+        ],
+      });
+
+    renderConsole();
+    const input = await screen.findByLabelText(/console input/i);
+    await waitFor(() => expect(input).not.toBeDisabled());
+
+    fireEvent.change(input, { target: { value: 'ls /et' } });
+    fireEvent.keyDown(input, { key: 'Tab' });
+    await waitFor(() => expect(input).toHaveValue('ls /etc/'));
+    expect(consoleApi.completeConsoleInput).toHaveBeenLastCalledWith('env', 1, 'ls /et', false);
+
+    fireEvent.change(input, { target: { value: 'ls /etc/pa' } });
+    fireEvent.keyDown(input, { key: 'Tab' });
+    expect(await screen.findByText('/etc/pam.d/  /etc/passwd')).toBeInTheDocument();
+    expect(input).toHaveValue('ls /etc/pa');
+  });
+
   it('returns focus to the command input after Run is clicked', async () => {
     consoleApi.submitConsoleCommand.mockResolvedValue({
       command: makeCommand({ input: 'pwd', status: 'completed' }),
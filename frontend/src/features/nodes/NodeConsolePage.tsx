@@ -4,6 +4,7 @@ import { useMutation, useQuery } from '@tanstack/react-query';
 import { ArrowLeft, Loader2, Terminal } from 'lucide-react';
 import {
   closeConsoleSession,
+  completeConsoleInput,
   createConsoleSession,
   getConsoleCommand,
   getConsoleCommandResults,
@@ -13,6 +14,7 @@ import {
 import { ApiError, AuthError } from '$/api/client';
 import type {
   ConsoleCommand,
+  ConsoleCompletion,
   ConsoleHistoryEntry,
   ConsoleNodeInfo,
   ConsoleResultRow,
@@ -53,6 +55,7 @@ export function NodeConsolePanel({ env, uuid }: { env: string; uuid: string }) {
   const [osqueryMode, setOsqueryMode] = useState(false);
   const [sessionError, setSessionError] = useState<string | null>(null);
   const [commandError, setCommandError] = useState<string | null>(null);
+  const [completionHint, setCompletionHint] = useState<string | null>(null);
   const sessionID = session?.id;
 
   useEffect(() => {
@@ -216,6 +219,7 @@ export function NodeConsolePanel({ env, uuid }: { env: string; uuid: string }) {
       { id: `in-${Date.now()}`, type: 'input', prompt, text: value },
     ]);
     setInput('');
+    setCompletionHint(null);
     focusAfterCommandRef.current = true;
     submitMutation.mutate(value);
   }
@@ -224,6 +228,27 @@ export function NodeConsolePanel({ env, uuid }: { env: string; uuid: string }) {
   const prompt = useMemo(() => (osqueryMode ? 'osquery>' : `${session?.cwd ?? '/'} $`), [osqueryMode, session?.cwd]);
   const nodeInfoItems = useMemo(() => formatNodeInfoItems(nodeInfo), [nodeInfo]);
 
+  async function onInputKeyDown(event: React.KeyboardEvent<HTMLInputElement>) {
+    if (event.key !== 'Tab' || event.shiftKey || !session || disabled) return;
+    event.preventDefault();
+    const element = event.currentTarget;
+    const typed = input;
+    const cursor = element.selectionStart ?? typed.length;
+    const before = typed.slice(0, cursor);
+    const after = typed.slice(cursor);
+    try {
+      const completion = await completeConsoleInput(env, session.id, before, osqueryMode);
+      const next = applyCompletion(before, completion);
+      setInput((current) => (current === typed ? next.text + after : current));
+      setCompletionHint(next.hint ?? null);
+      window.requestAnimationFrame(() => element.setSelectionRange(next.text.length, next.text.length));
+    } catch (error: unknown) {
+      if (error instanceof AuthError) {
+        void navigate({ to: '/login' });
+      }
+    }
+  }
+
   function focusCommandInput() {
     if (disabled) return;
     inputRef.current?.focus({ preventScroll: true });
@@ -294,6 +319,7 @@ export function NodeConsolePanel({ env, uuid }: { env: string; uuid: string }) {
             </div>
           )}
           {commandError && <Line tone="error" text={commandError} />}
+          {completionHint && <Line tone="muted" text={completionHint} />}
           <div ref={bottomRef} />
         </div>
 
@@ -307,7 +333,11 @@ export function NodeConsolePanel({ env, uuid }: { env: string; uuid: string }) {
             ref={inputRef}
             aria-label="Console input"
             value={input}
-            onChange={(event) => setInput(event.target.value)}
+            onChange={(event) => {
+              setInput(event.target.value);
+              setCompletionHint(null);
+            }}
+            onKeyDown={(event) => void onInputKeyDown(event)}
             disabled={disabled}
             className="min-w-0 flex-1 bg-transparent font-mono text-xs text-[#d7f8df] outline-none placeholder:text-[#5a705f] disabled:cursor-not-allowed disabled:opacity-60"
             autoComplete="off"
@@ -337,6 +367,24 @@ function ConsoleEntry({ entry }: { entry: Entry }) {
   return <Line text={entry.text} tone={entry.tone} />;
 }
 
+// applyCompletion replaces the completed token at the end of the text
+// before the cursor, and returns the candidates to list when there are
+// several of them.
+function applyCompletion(before: string, completion: ConsoleCompletion): { text: string; hint?: string } {
+  if (completion.status === 'pending') {
+    return { text: before, hint: 'listing the directory on the node, press Tab again' };
+  }
+  if (!before.endsWith(completion.token)) return { text: before };
+  const head = before.slice(0, before.length - completion.token.length);
+  if (completion.candidates.length === 1) {
+    const value = completion.candidates[0].value;
+    return { text: head + value + (/[\\/]$/.test(value) ? '' : ' ') };
+  }
+  const text = completion.common.length > completion.token.length ? head + completion.common : before;
+  const hint = completion.candidates.map((candidate) => candidate.value).join('  ');
+  return { text, hint: hint || undefined };
+}
+
 function historyToEntries(history: ConsoleHistoryEntry[]): Entry[] {
   const entries: Entry[] = [];
   for (const item of history) {
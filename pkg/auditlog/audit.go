@@ -356,6 +356,18 @@ func (m *AuditLogManager) NodeAction(username, action, ip string, envID uint) {
 	}
 }
 
+// QuarantineAction records an action against a quarantined node at
+// SeverityWarning, so actions during an incident stand out in the audit log
+func (m *AuditLogManager) QuarantineAction(username, action, ip string, envID uint) {
+	if !m.Enabled {
+		return
+	}
+	line := fmt.Sprintf("user %s performed action on quarantined node: %s", username, action)
+	if err := m.CreateNew(username, line, ip, LogTypeNode, SeverityWarning, envID); err != nil {
+		log.Err(err).Msg("error creating quarantine action audit log")
+	}
+}
+
 // EnvAction - create new environment action audit log entry
 func (m *AuditLogManager) EnvAction(username, action, ip string, envID uint) {
 	if !m.Enabled {
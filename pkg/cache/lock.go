@@ -0,0 +1,27 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+const (
+	// LockPrefix is the Redis key prefix of the locks shared by replicas
+	LockPrefix = "osctrl:lock:"
+)
+
+// TryLock takes the named lock for ttl with SETNX, so only one replica of a
+// service runs a periodic job in each interval. The lock is not released,
+// it expires after ttl, and returns false while another replica holds it.
+func TryLock(ctx context.Context, client *redis.Client, name string, ttl time.Duration) (bool, error) {
+	hostname, _ := os.Hostname()
+	ok, err := client.SetNX(ctx, LockPrefix+name, fmt.Sprintf("%s:%d", hostname, os.Getpid()), ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("SetNX %w", err)
+	}
+	return ok, nil
+}
@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTryLock(t *testing.T) {
+	client, store := newRedisJSONTestClient(t)
+	ctx := context.Background()
+
+	ok, err := TryLock(ctx, client, "stale", time.Hour)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, time.Hour, store.expireFor(LockPrefix+"stale"))
+
+	// Other replicas skip the job until the lock expires
+	ok, err = TryLock(ctx, client, "stale", time.Hour)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ok, err = TryLock(ctx, client, "rollouts", time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	store.delete(LockPrefix + "stale")
+	ok, err = TryLock(ctx, client, "stale", time.Hour)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
@@ -122,6 +122,13 @@ func handleRedisJSONFakeCommand(conn net.Conn, store *redisJSONFakeStore, args [
 		return err
 
 	case "SET":
+		if len(args) == 6 && strings.ToUpper(args[5]) == "NX" {
+			if _, ok := store.get(args[1]); ok {
+				_, err := conn.Write([]byte("$-1\r\n"))
+				return err
+			}
+			args = args[:5]
+		}
 		if len(args) != 3 && len(args) != 5 {
 			return fmt.Errorf("unexpected SET args: %v", args)
 		}
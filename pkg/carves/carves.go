@@ -1,14 +1,18 @@
 package carves
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/jmpsec/osctrl/pkg/config"
 	"github.com/jmpsec/osctrl/pkg/dbutil"
+	"github.com/jmpsec/osctrl/pkg/nodes"
 	"github.com/jmpsec/osctrl/pkg/types"
 	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
@@ -30,6 +34,12 @@ const (
 	TarFileExtension string = ".tar"
 	// ZstFileExtension to identify ZST compressed files
 	ZstFileExtension string = ".zst"
+	// SkipExcluded for directory carve files matching an exclude glob
+	SkipExcluded string = "excluded"
+	// SkipMaxFileSize for directory carve files over the per-file limit
+	SkipMaxFileSize string = "max_file_size"
+	// SkipMaxTotalSize for directory carve files past the total size cap
+	SkipMaxTotalSize string = "max_total_size"
 )
 
 // CarveType as abstraction of storage type for the carver
@@ -56,6 +66,8 @@ type QueriedCarve struct {
 type CarveResult struct {
 	Size int64
 	File string
+	// Hash is the hex SHA-256 of the archive content
+	Hash string
 }
 
 // Carves to handle file carves from nodes
@@ -63,6 +75,8 @@ type Carves struct {
 	DB     *gorm.DB
 	S3     *CarverS3
 	Carver string
+	// Keys wraps per-carve data keys, nil when encryption is not configured
+	Keys *KeyRing
 }
 
 // CreateFileCarves to initialize the carves struct and tables
@@ -76,6 +90,19 @@ func CreateFileCarves(backend *gorm.DB, carverType string, s3 *CarverS3) *Carves
 	if err := backend.AutoMigrate(&CarvedBlock{}); err != nil {
 		log.Fatal().Msgf("Failed to AutoMigrate table (carved_blocks): %v", err)
 	}
+	// table carve_skipped_files
+	if err := backend.AutoMigrate(&CarveSkippedFile{}); err != nil {
+		log.Fatal().Msgf("Failed to AutoMigrate table (carve_skipped_files): %v", err)
+	}
+	return c
+}
+
+// WithEncryption attaches the keyring used to encrypt carves at rest. Carves
+// for environments present in the keyring are encrypted from then on, and
+// returns the receiver so calls can chain:
+// carves.CreateFileCarves(db, carver, s3).WithEncryption(keys).
+func (c *Carves) WithEncryption(keys *KeyRing) *Carves {
+	c.Keys = keys
 	return c
 }
 
@@ -99,6 +126,20 @@ func (c *Carves) InitCarve(req types.CarveInitRequest, sessionid string) error {
 			"status":       StatusInProgress,
 			"carver":       c.Carver,
 		}
+		// Generate and wrap the data key for this session
+		if c.Keys.Enabled(carve.Environment) {
+			dataKey, err := NewDataKey()
+			if err != nil {
+				return err
+			}
+			keyID, wrapped, err := c.Keys.WrapDataKey(carve.Environment, dataKey)
+			if err != nil {
+				return err
+			}
+			toUpdate["encrypted"] = true
+			toUpdate["key_id"] = keyID
+			toUpdate["wrapped_key"] = wrapped
+		}
 		if err := c.DB.Model(&carve).Updates(toUpdate).Error; err != nil {
 			return err
 		}
@@ -150,6 +191,10 @@ func (c *Carves) InitateBlock(env, uuid, requestid, sessionid, data string, bloc
 
 // CreateBlock to create a new block for a carve
 func (c *Carves) CreateBlock(block CarvedBlock, uuid, data string) error {
+	data, err := c.sealBlock(&block, data)
+	if err != nil {
+		return fmt.Errorf("sealBlock %w", err)
+	}
 	switch c.Carver {
 	case config.CarverDB:
 		return c.DB.Create(&block).Error // can be nil or err
@@ -165,6 +210,79 @@ func (c *Carves) CreateBlock(block CarvedBlock, uuid, data string) error {
 	return fmt.Errorf("unknown carver") // can be nil or err
 }
 
+// sealBlock encrypts the base64 block data when its carve is encrypted,
+// updating the block so it is stored sealed. Returns the data to store.
+func (c *Carves) sealBlock(block *CarvedBlock, data string) (string, error) {
+	carve, err := c.GetBySession(block.SessionID)
+	if err != nil {
+		return "", fmt.Errorf("getCarveBySessionID %w", err)
+	}
+	if !carve.Encrypted {
+		return data, nil
+	}
+	dataKey, err := c.DataKey(carve)
+	if err != nil {
+		return "", err
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("decoding data - %w", err)
+	}
+	frame, err := SealBlock(dataKey, block.SessionID, block.BlockID, raw)
+	if err != nil {
+		return "", err
+	}
+	sealed := base64.StdEncoding.EncodeToString(frame)
+	block.Encrypted = true
+	if c.Carver != config.CarverS3 {
+		block.Data = sealed
+	}
+	return sealed, nil
+}
+
+// DataKey to unwrap the data key of an encrypted carve
+func (c *Carves) DataKey(carve CarvedFile) ([]byte, error) {
+	if !carve.Encrypted {
+		return nil, fmt.Errorf("carve %s is not encrypted", carve.SessionID)
+	}
+	return c.Keys.UnwrapDataKey(carve.Environment, carve.KeyID, carve.WrappedKey)
+}
+
+// RotateKeys to re-wrap the data keys of every encrypted carve in the
+// environment with the active environment key. Carved data itself is not
+// touched, so rotation is cheap regardless of carve size. Returns how many
+// carves were re-wrapped.
+func (c *Carves) RotateKeys(env string) (int, error) {
+	activeID := c.Keys.ActiveKeyID(env)
+	if activeID == "" {
+		return 0, fmt.Errorf("%w %s", ErrNoEnvironmentKey, env)
+	}
+	var carves []CarvedFile
+	if err := c.DB.Where("environment = ? AND encrypted = ? AND key_id <> ?", env, true, activeID).Find(&carves).Error; err != nil {
+		return 0, err
+	}
+	rotated := 0
+	for _, carve := range carves {
+		dataKey, err := c.DataKey(carve)
+		if err != nil {
+			return rotated, fmt.Errorf("carve %s - %w", carve.CarveID, err)
+		}
+		keyID, wrapped, err := c.Keys.WrapDataKey(env, dataKey)
+		if err != nil {
+			return rotated, fmt.Errorf("carve %s - %w", carve.CarveID, err)
+		}
+		toUpdate := map[string]interface{}{
+			"key_id":      keyID,
+			"wrapped_key": wrapped,
+		}
+		if err := c.DB.Model(&carve).Updates(toUpdate).Error; err != nil {
+			return rotated, fmt.Errorf("update %w", err)
+		}
+		rotated++
+	}
+	return rotated, nil
+}
+
 // Delete to delete a carve by id
 func (c *Carves) Delete(carveid string) error {
 	carve, err := c.GetByCarve(carveid)
@@ -236,6 +354,23 @@ func (c *Carves) GetByQuery(name string, env uint) ([]CarvedFile, error) {
 	return carves, nil
 }
 
+// CreateSkipped to record the files a node left out of a directory carve
+func (c *Carves) CreateSkipped(files []CarveSkippedFile) error {
+	if len(files) == 0 {
+		return nil
+	}
+	return c.DB.Create(&files).Error
+}
+
+// GetSkipped to get the files left out of a directory carve by query name
+func (c *Carves) GetSkipped(name string, env uint) ([]CarveSkippedFile, error) {
+	var skipped []CarveSkippedFile
+	if err := c.DB.Where("query_name = ? AND environment_id = ?", name, env).Order("uuid, path").Find(&skipped).Error; err != nil {
+		return skipped, err
+	}
+	return skipped, nil
+}
+
 // GetByEnv to get carves by environment
 func (c *Carves) GetByEnv(env uint) ([]CarvedFile, error) {
 	var carves []CarvedFile
@@ -254,6 +389,38 @@ func (c *Carves) GetNodeCarves(uuid string) ([]CarvedFile, error) {
 	return carves, nil
 }
 
+// GetNodeCarvesByID to get all the carves for a given node ID, including
+// the carves moved to it from merged duplicates
+func (c *Carves) GetNodeCarvesByID(nodeID uint) ([]CarvedFile, error) {
+	var carves []CarvedFile
+	if err := c.DB.Where("node_id = ?", nodeID).Order("created_at, id").Find(&carves).Error; err != nil {
+		return carves, err
+	}
+	return carves, nil
+}
+
+// MoveNodeCarves moves the carves of a node to another node inside a
+// transaction, so they are found by the UUID and in the environment of the
+// other node. Their stored data is located by the archive path and the
+// blocks, which are left as they are. It is a nodes.NodeMover for merging
+// duplicate nodes.
+func (c *Carves) MoveNodeCarves(tx *gorm.DB, from, to nodes.OsqueryNode) error {
+	moved := map[string]interface{}{
+		"node_id":        to.ID,
+		"uuid":           to.UUID,
+		"environment":    to.Environment,
+		"environment_id": to.EnvironmentID,
+	}
+	if err := tx.Model(&CarvedFile{}).Where("node_id = ?", from.ID).Updates(moved).Error; err != nil {
+		return err
+	}
+	return tx.Model(&CarveSkippedFile{}).Where("node_id = ?", from.ID).Updates(map[string]interface{}{
+		"node_id":        to.ID,
+		"uuid":           to.UUID,
+		"environment_id": to.EnvironmentID,
+	}).Error
+}
+
 // GetNodeCarveTimestamps returns CreatedAt of every CarvedFile row from this
 // node since the cutoff. Used by the per-node activity heatmap so it can
 // bucket without dragging the full carve metadata.
@@ -334,33 +501,53 @@ func (c *Carves) Completed(sessionid string) bool {
 	return (carve.TotalBlocks == carve.CompletedBlocks)
 }
 
-// Archive to convert finalize a completed carve and create a file ready to download
+// Archive to convert finalize a completed carve and create a file ready to download.
+// The SHA-256 of the archive content is stored with the carve the first time
+// it is archived, and returned as part of the result.
 func (c *Carves) Archive(sessionid, destPath string) (*CarveResult, error) {
 	// Get carve
 	carve, err := c.GetBySession(sessionid)
 	if err != nil {
 		return nil, fmt.Errorf("error getting carve - %w", err)
 	}
+	var res *CarveResult
 	if carve.Archived {
-		return &CarveResult{
+		res = &CarveResult{
 			Size: int64(carve.CarveSize),
 			File: carve.ArchivePath,
-		}, nil
+			Hash: carve.ArchiveHash,
+		}
+	} else {
+		// Get all blocks
+		blocks, err := c.GetBlocks(carve.SessionID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting blocks - %w", err)
+		}
+		switch c.Carver {
+		case config.CarverLocal, config.CarverDB:
+			res, err = c.ArchiveLocal(destPath, carve, blocks)
+		case config.CarverS3:
+			res, err = c.S3.Archive(carve, blocks)
+		default:
+			return nil, fmt.Errorf("unknown carver - %s", c.Carver)
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
-	// Get all blocks
-	blocks, err := c.GetBlocks(carve.SessionID)
-	if err != nil {
-		return nil, fmt.Errorf("error getting blocks - %w", err)
+	if carve.ArchiveHash != "" {
+		res.Hash = carve.ArchiveHash
+		return res, nil
 	}
-	switch c.Carver {
-	case config.CarverLocal:
-		return c.ArchiveLocal(destPath, carve, blocks)
-	case config.CarverDB:
-		return c.ArchiveLocal(destPath, carve, blocks)
-	case config.CarverS3:
-		return c.S3.Archive(carve, blocks)
+	archived := carve
+	archived.ArchivePath = res.File
+	if res.Hash, err = c.hashArchive(archived, res.File); err != nil {
+		return nil, fmt.Errorf("error hashing archive - %w", err)
 	}
-	return nil, fmt.Errorf("unknown carver - %s", c.Carver)
+	if err := c.DB.Model(&carve).Update("archive_hash", res.Hash).Error; err != nil {
+		return nil, fmt.Errorf("update %w", err)
+	}
+	return res, nil
 }
 
 // Archive to convert finalize a completed carve and create a file ready to download
@@ -379,17 +566,24 @@ func (c *Carves) ArchiveLocal(destPath string, carve CarvedFile, blocks []Carved
 	// If file already exists, no need to re-generate it from blocks
 	_f, err := os.Stat(res.File)
 	if err == nil {
-		res.Size = _f.Size()
+		res.Size = archiveSize(carve, _f.Size())
 		return res, nil
 	}
 	// Also check for compressed
 	_f, err = os.Stat(res.File + ZstFileExtension)
 	if err == nil {
-		res.Size = _f.Size()
+		res.File += ZstFileExtension
+		res.Size = archiveSize(carve, _f.Size())
 		return res, nil
 	}
+	var dataKey []byte
+	if carve.Encrypted {
+		if dataKey, err = c.DataKey(carve); err != nil {
+			return res, fmt.Errorf("data key - %w", err)
+		}
+	}
 	// Check if data is compressed
-	zstd, err := CheckCompressionBlock(blocks[0])
+	zstd, err := c.checkCompression(blocks[0], carve, dataKey)
 	if err != nil {
 		return res, fmt.Errorf("compression check - %w", err)
 	}
@@ -401,8 +595,13 @@ func (c *Carves) ArchiveLocal(destPath string, carve CarvedFile, blocks []Carved
 		return res, fmt.Errorf("file creation - %w", err)
 	}
 	defer f.Close()
-	// Iterate through blocks and write decoded content to file
+	// Iterate through blocks and write decoded content to file. Encrypted
+	// blocks are written as sealed frames, so the archive stays encrypted
+	// at rest and is decrypted by OpenArchive when downloaded.
 	for _, b := range blocks {
+		if b.Encrypted != carve.Encrypted {
+			return res, fmt.Errorf("block %d encryption does not match carve", b.BlockID)
+		}
 		toFile, err := base64.StdEncoding.DecodeString(b.Data)
 		if err != nil {
 			return res, fmt.Errorf("decoding data - %w", err)
@@ -410,7 +609,105 @@ func (c *Carves) ArchiveLocal(destPath string, carve CarvedFile, blocks []Carved
 		if _, err := f.Write(toFile); err != nil {
 			return res, fmt.Errorf("writing to file - %w", err)
 		}
-		res.Size += int64(len(toFile))
+		if b.Encrypted {
+			res.Size += int64(len(toFile) - FrameOverhead())
+		} else {
+			res.Size += int64(len(toFile))
+		}
 	}
 	return res, nil
 }
+
+// checkCompression to check if the first block of a carve is zstd
+// compressed, decrypting it first if needed
+func (c *Carves) checkCompression(block CarvedBlock, carve CarvedFile, dataKey []byte) (bool, error) {
+	if !block.Encrypted {
+		return CheckCompressionBlock(block)
+	}
+	if block.BlockID != 0 {
+		return false, fmt.Errorf("block_id is not 0 (%d)", block.BlockID)
+	}
+	frame, err := base64.StdEncoding.DecodeString(block.Data)
+	if err != nil {
+		return false, fmt.Errorf("error decoding block %w", err)
+	}
+	raw, err := OpenBlock(dataKey, carve.SessionID, block.BlockID, frame)
+	if err != nil {
+		return false, fmt.Errorf("error decrypting block %w", err)
+	}
+	return CheckCompressionRaw(raw), nil
+}
+
+// archiveSize returns the plaintext size of an archive given its size on
+// disk, which for encrypted carves includes the framing overhead
+func archiveSize(carve CarvedFile, stored int64) int64 {
+	if carve.Encrypted {
+		return int64(carve.CarveSize)
+	}
+	return stored
+}
+
+// OpenArchive to open an archived carve for download. Encrypted archives
+// are decrypted transparently while being read. The returned reader is
+// seekable, so byte ranges can be served without reading the whole archive,
+// and it returns the plaintext size. The archive is read from the local path
+// for local/DB carvers and streamed from the S3 object for the S3 carver.
+func (c *Carves) OpenArchive(carve CarvedFile, archivePath string) (io.ReadSeekCloser, int64, error) {
+	var rc io.ReadSeekCloser
+	var size int64
+	if c.Carver == config.CarverS3 {
+		if c.S3 == nil {
+			return nil, 0, fmt.Errorf("s3 carver not initialized")
+		}
+		body, length, err := c.S3.Download(carve)
+		if err != nil {
+			return nil, 0, err
+		}
+		rc, size = body, length
+	} else {
+		f, err := os.Open(archivePath)
+		if err != nil {
+			return nil, 0, fmt.Errorf("opening archive - %w", err)
+		}
+		stat, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, fmt.Errorf("stat archive - %w", err)
+		}
+		rc, size = f, stat.Size()
+	}
+	if !carve.Encrypted {
+		return rc, size, nil
+	}
+	dataKey, err := c.DataKey(carve)
+	if err != nil {
+		rc.Close()
+		return nil, 0, fmt.Errorf("data key - %w", err)
+	}
+	decrypted, err := NewDecryptReadSeeker(rc, dataKey, carve.SessionID, int64(carve.BlockSize), int64(carve.CarveSize))
+	if err != nil {
+		rc.Close()
+		return nil, 0, err
+	}
+	return &decryptReadCloser{ReadSeeker: decrypted, Closer: rc}, int64(carve.CarveSize), nil
+}
+
+type decryptReadCloser struct {
+	io.ReadSeeker
+	io.Closer
+}
+
+// hashArchive to calculate the SHA-256 of the plaintext content of an
+// archive, used as strong validator (ETag) for downloads
+func (c *Carves) hashArchive(carve CarvedFile, archivePath string) (string, error) {
+	rc, _, err := c.OpenArchive(carve, archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return "", fmt.Errorf("hashing archive - %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -26,7 +26,15 @@ type CarvedFile struct {
 	Carver          string
 	Archived        bool
 	ArchivePath     string
-	EnvironmentID   uint
+	// ArchiveHash is the hex SHA-256 of the archive content
+	ArchiveHash   string
+	EnvironmentID uint
+	// Encrypted is set when blocks and archive are sealed with a data key
+	Encrypted bool
+	// KeyID of the environment key that wraps the data key
+	KeyID string
+	// WrappedKey is the base64 encoded data key, sealed with KeyID
+	WrappedKey string `json:"-"`
 }
 
 // CarvedBlock to store each block from a carve
@@ -40,4 +48,17 @@ type CarvedBlock struct {
 	Size          int
 	Carver        string
 	EnvironmentID uint
+	Encrypted     bool
+}
+
+// CarveSkippedFile to keep track of files left out of a directory carve
+type CarveSkippedFile struct {
+	gorm.Model
+	QueryName     string `gorm:"index"`
+	UUID          string `gorm:"index"`
+	NodeID        uint
+	Path          string
+	Size          int64
+	Reason        string
+	EnvironmentID uint
 }
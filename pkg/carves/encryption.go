@@ -0,0 +1,366 @@
+package carves
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// Envelope encryption for carved data at rest.
+//
+// Every carve session gets a random AES-256 data key when it is initialized.
+// Blocks are sealed with AES-GCM under that data key before they reach the
+// DB or S3, and the data key itself is stored in carved_files wrapped by the
+// environment key loaded from a local keyfile. Archives reconstructed from
+// encrypted blocks stay encrypted on disk / in S3 and are only decrypted on
+// the way out, in the download paths.
+//
+// Each sealed block is stored as a self-delimiting frame:
+//
+//	[4-byte big-endian length][12-byte nonce][ciphertext + 16-byte tag]
+//
+// so that archives (which are a plain concatenation of frames in block_id
+// order) can be decrypted as a stream. The session id and block id are bound
+// as GCM additional data, which makes reordering or splicing blocks across
+// carves detectable.
+
+const (
+	// DataKeySize is the size in bytes of the per-carve AES-256 data key
+	DataKeySize = 32
+	// frameHeaderSize is the size of the length prefix of a sealed block
+	frameHeaderSize = 4
+	// maxFrameSize caps the length prefix accepted when streaming, so a
+	// corrupted archive can not make the reader allocate unbounded memory
+	maxFrameSize = 64 * 1024 * 1024
+)
+
+var (
+	// ErrNoEnvironmentKey when the keyfile has no key for the environment
+	ErrNoEnvironmentKey = errors.New("no carve encryption key for environment")
+	// ErrUnknownKeyID when a carve was wrapped with a key no longer in the keyfile
+	ErrUnknownKeyID = errors.New("unknown carve encryption key id")
+)
+
+// EnvironmentKeys holds the key-encryption keys for one environment, as
+// read from the keyfile. Keys are base64 encoded 32-byte values indexed by
+// an operator chosen key id. Active is the id used to wrap new data keys.
+type EnvironmentKeys struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+}
+
+// KeyRing holds the per-environment keys used to wrap carve data keys.
+// Environments are indexed by environment name, which is what carved
+// files and blocks record.
+//
+//	{
+//	  "environments": {
+//	    "dev": {"active": "2026-10", "keys": {"2026-10": "<base64>", "2026-01": "<base64>"}}
+//	  }
+//	}
+type KeyRing struct {
+	Environments map[string]EnvironmentKeys `json:"environments"`
+	keys         map[string]map[string][]byte
+}
+
+// LoadKeyRing to read and validate a carve keyfile
+func LoadKeyRing(path string) (*KeyRing, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading keyfile - %w", err)
+	}
+	return ParseKeyRing(raw)
+}
+
+// ParseKeyRing to parse and validate the JSON content of a carve keyfile
+func ParseKeyRing(raw []byte) (*KeyRing, error) {
+	var k KeyRing
+	if err := json.Unmarshal(raw, &k); err != nil {
+		return nil, fmt.Errorf("parsing keyfile - %w", err)
+	}
+	k.keys = make(map[string]map[string][]byte, len(k.Environments))
+	for env, ek := range k.Environments {
+		if _, ok := ek.Keys[ek.Active]; !ok {
+			return nil, fmt.Errorf("environment %s: active key %q not found", env, ek.Active)
+		}
+		k.keys[env] = make(map[string][]byte, len(ek.Keys))
+		for id, encoded := range ek.Keys {
+			key, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("environment %s: key %s - %w", env, id, err)
+			}
+			if len(key) != DataKeySize {
+				return nil, fmt.Errorf("environment %s: key %s must be %d bytes, got %d", env, id, DataKeySize, len(key))
+			}
+			k.keys[env][id] = key
+		}
+	}
+	return &k, nil
+}
+
+// Enabled to check if carves for the environment must be encrypted
+func (k *KeyRing) Enabled(env string) bool {
+	if k == nil {
+		return false
+	}
+	_, ok := k.keys[env]
+	return ok
+}
+
+// ActiveKeyID to get the id of the key used to wrap new data keys
+func (k *KeyRing) ActiveKeyID(env string) string {
+	if !k.Enabled(env) {
+		return ""
+	}
+	return k.Environments[env].Active
+}
+
+func (k *KeyRing) key(env, keyID string) ([]byte, error) {
+	if !k.Enabled(env) {
+		return nil, fmt.Errorf("%w %s", ErrNoEnvironmentKey, env)
+	}
+	key, ok := k.keys[env][keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %s for environment %s", ErrUnknownKeyID, keyID, env)
+	}
+	return key, nil
+}
+
+// WrapDataKey to seal a data key with the active key of the environment.
+// Returns the key id used and the base64 encoded wrapped key.
+func (k *KeyRing) WrapDataKey(env string, dataKey []byte) (string, string, error) {
+	keyID := k.ActiveKeyID(env)
+	kek, err := k.key(env, keyID)
+	if err != nil {
+		return "", "", err
+	}
+	sealed, err := seal(kek, dataKey, []byte(env+":"+keyID))
+	if err != nil {
+		return "", "", fmt.Errorf("wrapping data key - %w", err)
+	}
+	return keyID, base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// UnwrapDataKey to recover a data key wrapped with WrapDataKey
+func (k *KeyRing) UnwrapDataKey(env, keyID, wrapped string) ([]byte, error) {
+	kek, err := k.key(env, keyID)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("decoding wrapped key - %w", err)
+	}
+	dataKey, err := open(kek, sealed, []byte(env+":"+keyID))
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping data key - %w", err)
+	}
+	return dataKey, nil
+}
+
+// NewDataKey to generate a random data key for a carve session
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, DataKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("generating data key - %w", err)
+	}
+	return key, nil
+}
+
+// SealBlock to encrypt the raw content of a carve block into a frame
+func SealBlock(dataKey []byte, sessionid string, blockid int, plain []byte) ([]byte, error) {
+	sealed, err := seal(dataKey, plain, blockAAD(sessionid, blockid))
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, frameHeaderSize+len(sealed))
+	binary.BigEndian.PutUint32(frame, uint32(len(sealed)))
+	copy(frame[frameHeaderSize:], sealed)
+	return frame, nil
+}
+
+// OpenBlock to decrypt a frame generated by SealBlock
+func OpenBlock(dataKey []byte, sessionid string, blockid int, frame []byte) ([]byte, error) {
+	if len(frame) < frameHeaderSize {
+		return nil, fmt.Errorf("block %d: frame too short", blockid)
+	}
+	size := binary.BigEndian.Uint32(frame)
+	if int(size) != len(frame)-frameHeaderSize {
+		return nil, fmt.Errorf("block %d: frame length mismatch", blockid)
+	}
+	return open(dataKey, frame[frameHeaderSize:], blockAAD(sessionid, blockid))
+}
+
+// FrameOverhead is the number of bytes SealBlock adds to each block
+func FrameOverhead() int {
+	return frameHeaderSize + 12 + 16
+}
+
+// decryptReader decrypts a concatenation of frames, in block order
+type decryptReader struct {
+	src       io.Reader
+	dataKey   []byte
+	sessionid string
+	blockid   int
+	buf       []byte
+	// Only used when the reader is seekable
+	seeker    io.ReadSeeker
+	blockSize int64
+	size      int64
+	offset    int64
+	pending   bool
+}
+
+// NewDecryptReader to decrypt an encrypted carve archive as a stream
+func NewDecryptReader(src io.Reader, dataKey []byte, sessionid string) io.Reader {
+	return &decryptReader{src: src, dataKey: dataKey, sessionid: sessionid}
+}
+
+// NewDecryptReadSeeker to decrypt an encrypted carve archive with random
+// access. Every block but the last holds exactly blockSize plaintext bytes,
+// so a plaintext offset maps to a fixed frame offset in src and seeking
+// only decrypts the frame that contains the new position.
+func NewDecryptReadSeeker(src io.ReadSeeker, dataKey []byte, sessionid string, blockSize, size int64) (io.ReadSeeker, error) {
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("invalid block size %d", blockSize)
+	}
+	return &decryptReader{
+		src:       src,
+		seeker:    src,
+		dataKey:   dataKey,
+		sessionid: sessionid,
+		blockSize: blockSize,
+		size:      size,
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	if d.pending {
+		// Position src at the start of the frame holding the offset, then
+		// drop the bytes of that block that precede the offset
+		idx := d.offset / d.blockSize
+		if _, err := d.seeker.Seek(idx*(d.blockSize+int64(FrameOverhead())), io.SeekStart); err != nil {
+			return 0, err
+		}
+		d.blockid = int(idx)
+		d.buf = nil
+		d.pending = false
+		if skip := d.offset - idx*d.blockSize; skip > 0 {
+			if err := d.next(); err != nil {
+				return 0, err
+			}
+			if skip > int64(len(d.buf)) {
+				return 0, io.EOF
+			}
+			d.buf = d.buf[skip:]
+		}
+	}
+	for len(d.buf) == 0 {
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	d.offset += int64(n)
+	return n, nil
+}
+
+// next reads and decrypts the next frame into buf
+func (d *decryptReader) next() error {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(d.src, header); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("block %d: truncated frame header", d.blockid)
+		}
+		return err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > maxFrameSize {
+		return fmt.Errorf("block %d: frame of %d bytes exceeds limit", d.blockid, size)
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.src, sealed); err != nil {
+		return fmt.Errorf("block %d: truncated frame - %w", d.blockid, err)
+	}
+	plain, err := open(d.dataKey, sealed, blockAAD(d.sessionid, d.blockid))
+	if err != nil {
+		return fmt.Errorf("block %d: %w", d.blockid, err)
+	}
+	d.buf = plain
+	d.blockid++
+	return nil
+}
+
+func (d *decryptReader) Seek(offset int64, whence int) (int64, error) {
+	if d.seeker == nil {
+		return 0, fmt.Errorf("decrypt reader is not seekable")
+	}
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = d.offset + offset
+	case io.SeekEnd:
+		abs = d.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+	if abs != d.offset {
+		d.offset = abs
+		d.pending = true
+	}
+	return abs, nil
+}
+
+func blockAAD(sessionid string, blockid int) []byte {
+	return []byte(sessionid + ":" + strconv.Itoa(blockid))
+}
+
+func seal(key, plain, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce - %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plain, aad), nil
+}
+
+func open(key, sealed, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting - %w", err)
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher - %w", err)
+	}
+	return cipher.NewGCM(block)
+}
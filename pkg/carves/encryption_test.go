@@ -0,0 +1,166 @@
+package carves
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmpsec/osctrl/pkg/config"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, DataKeySize))
+}
+
+func testKeyRing(t *testing.T, active string) *KeyRing {
+	t.Helper()
+	raw := `{"environments": {"dev": {"active": "` + active + `", "keys": {"k1": "` + testKey(1) + `", "k2": "` + testKey(2) + `"}}}}`
+	k, err := ParseKeyRing([]byte(raw))
+	require.NoError(t, err)
+	return k
+}
+
+func TestParseKeyRingValidation(t *testing.T) {
+	_, err := ParseKeyRing([]byte(`{"environments": {"dev": {"active": "missing", "keys": {"k1": "` + testKey(1) + `"}}}}`))
+	assert.Error(t, err)
+	_, err = ParseKeyRing([]byte(`{"environments": {"dev": {"active": "k1", "keys": {"k1": "c2hvcnQ="}}}}`))
+	assert.Error(t, err)
+	k := testKeyRing(t, "k1")
+	assert.True(t, k.Enabled("dev"))
+	assert.False(t, k.Enabled("prod"))
+	var nilRing *KeyRing
+	assert.False(t, nilRing.Enabled("dev"))
+}
+
+func TestWrapDataKeyRoundTrip(t *testing.T) {
+	k := testKeyRing(t, "k1")
+	dataKey, err := NewDataKey()
+	require.NoError(t, err)
+	keyID, wrapped, err := k.WrapDataKey("dev", dataKey)
+	require.NoError(t, err)
+	assert.Equal(t, "k1", keyID)
+	got, err := k.UnwrapDataKey("dev", keyID, wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, got)
+	// Wrong key id must fail authentication
+	_, err = k.UnwrapDataKey("dev", "k2", wrapped)
+	assert.Error(t, err)
+}
+
+// TestDecryptReaderDetectsReordering confirms frames are bound to their
+// block position, so swapping blocks in an archive fails to decrypt.
+func TestDecryptReaderDetectsReordering(t *testing.T) {
+	dataKey, err := NewDataKey()
+	require.NoError(t, err)
+	f0, err := SealBlock(dataKey, "session", 0, []byte("hello "))
+	require.NoError(t, err)
+	f1, err := SealBlock(dataKey, "session", 1, []byte("world"))
+	require.NoError(t, err)
+
+	plain, err := io.ReadAll(NewDecryptReader(bytes.NewReader(append(append([]byte{}, f0...), f1...)), dataKey, "session"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(plain))
+
+	_, err = io.ReadAll(NewDecryptReader(bytes.NewReader(append(append([]byte{}, f1...), f0...)), dataKey, "session"))
+	assert.Error(t, err)
+}
+
+func TestEncryptedCarveArchiveAndRotation(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	c := CreateFileCarves(db, config.CarverDB, nil).WithEncryption(testKeyRing(t, "k1"))
+
+	require.NoError(t, c.CreateCarve(CarvedFile{
+		CarveID:     "carve-1",
+		RequestID:   "req-1",
+		UUID:        "node-1",
+		Environment: "dev",
+		Path:        "/etc/passwd",
+		Status:      StatusScheduled,
+	}))
+	content := []byte("root:x:0:0:root:/root:/bin/bash\n")
+	require.NoError(t, c.InitCarve(types.CarveInitRequest{RequestID: "req-1", CarveSize: len(content), BlockCount: 2, BlockSize: 16}, "session-1"))
+	for i, chunk := range [][]byte{content[:16], content[16:]} {
+		data := base64.StdEncoding.EncodeToString(chunk)
+		block := c.InitateBlock("dev", "node-1", "req-1", "session-1", data, i, 1)
+		require.NoError(t, c.CreateBlock(block, "node-1", data))
+	}
+	blocks, err := c.GetBlocks("session-1")
+	require.NoError(t, err)
+	require.Len(t, blocks, 2)
+	for _, b := range blocks {
+		assert.True(t, b.Encrypted)
+		stored, err := base64.StdEncoding.DecodeString(b.Data)
+		require.NoError(t, err)
+		assert.False(t, bytes.Contains(stored, []byte("root")), "block stored in plaintext")
+	}
+
+	res, err := c.Archive("session-1", t.TempDir())
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), res.Size)
+	onDisk, err := os.ReadFile(res.File)
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(onDisk, []byte("root")), "archive stored in plaintext")
+
+	carve, err := c.GetBySession("session-1")
+	require.NoError(t, err)
+	rc, size, err := c.OpenArchive(carve, res.File)
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, rc.Close())
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+	assert.Equal(t, content, got)
+
+	// Rotate to k2 and confirm the archive still decrypts
+	c.WithEncryption(testKeyRing(t, "k2"))
+	rotated, err := c.RotateKeys("dev")
+	require.NoError(t, err)
+	assert.Equal(t, 1, rotated)
+	carve, err = c.GetBySession("session-1")
+	require.NoError(t, err)
+	assert.Equal(t, "k2", carve.KeyID)
+	rc, _, err = c.OpenArchive(carve, filepath.Clean(res.File))
+	require.NoError(t, err)
+	got, err = io.ReadAll(rc)
+	require.NoError(t, rc.Close())
+	require.NoError(t, err)
+	assert.Equal(t, content, got)
+}
+
+// TestDecryptReadSeekerRanges confirms seeking into the middle of an
+// encrypted archive returns the same bytes as the plaintext at that offset.
+func TestDecryptReadSeekerRanges(t *testing.T) {
+	dataKey, err := NewDataKey()
+	require.NoError(t, err)
+	plain := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	blockSize := 8
+	var archive []byte
+	for i := 0; i*blockSize < len(plain); i++ {
+		end := min((i+1)*blockSize, len(plain))
+		frame, err := SealBlock(dataKey, "session", i, plain[i*blockSize:end])
+		require.NoError(t, err)
+		archive = append(archive, frame...)
+	}
+	rs, err := NewDecryptReadSeeker(bytes.NewReader(archive), dataKey, "session", int64(blockSize), int64(len(plain)))
+	require.NoError(t, err)
+
+	size, err := rs.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(plain)), size)
+	for _, off := range []int64{0, 5, 8, 13, 35} {
+		_, err := rs.Seek(off, io.SeekStart)
+		require.NoError(t, err)
+		got, err := io.ReadAll(rs)
+		require.NoError(t, err)
+		assert.Equal(t, plain[off:], got, "offset %d", off)
+	}
+}
@@ -169,33 +169,25 @@ func (carveS3 *CarverS3) Archive(carve CarvedFile, blocks []CarvedBlock) (*Carve
 	return res, nil
 }
 
-// Download - Function to download an archived carve from s3
-func (carveS3 *CarverS3) Download(carve CarvedFile) (io.WriterAt, error) {
+// Download - Function to stream an archived carve from s3. Nothing is
+// buffered beyond what the caller reads: the returned reader issues a ranged
+// GET from the current offset on the first Read after a Seek, so callers such
+// as http.ServeContent can serve byte ranges of multi-GB archives.
+func (carveS3 *CarverS3) Download(carve CarvedFile) (io.ReadSeekCloser, int64, error) {
 	ctx := context.Background()
 	if carveS3.Debug {
 		log.Debug().Msgf("Downloading %s from S3", carve.ArchivePath)
 	}
-	output, err := carveS3.Client.GetObject(ctx, &s3.GetObjectInput{
+	key := S3URLtoKey(carve.ArchivePath, carveS3.S3Config.Bucket)
+	head, err := carveS3.Client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(carveS3.S3Config.Bucket),
-		Key:    aws.String(S3URLtoKey(carve.ArchivePath, carveS3.S3Config.Bucket)),
+		Key:    aws.String(key),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("Download - %w", err)
-	}
-	defer output.Body.Close()
-
-	data, err := io.ReadAll(output.Body)
-	if err != nil {
-		return nil, fmt.Errorf("Download - reading object body - %w", err)
+		return nil, 0, fmt.Errorf("Download - %w", err)
 	}
-	fileReader := newBytesWriterAt(len(data))
-	if _, err := fileReader.WriteAt(data, 0); err != nil {
-		return nil, fmt.Errorf("Download - buffering object body - %w", err)
-	}
-	if carveS3.Debug {
-		log.Debug().Msgf("S3 Downloaded %s [%d bytes]", carve.ArchivePath, len(data))
-	}
-	return fileReader, nil
+	size := aws.ToInt64(head.ContentLength)
+	return &s3ObjectReader{carveS3: carveS3, key: key, size: size}, size, nil
 }
 
 // GetDownloadLink - Function to generate a pre-signed link to download directly from s3
@@ -215,23 +207,64 @@ func (carveS3 *CarverS3) GetDownloadLink(carve CarvedFile) (string, error) {
 	return lnk.URL, nil
 }
 
-type bytesWriterAt struct {
-	data []byte
+// s3ObjectReader reads an s3 object sequentially, reopening it with a
+// Range request whenever the caller seeks
+type s3ObjectReader struct {
+	carveS3 *CarverS3
+	key     string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
 }
 
-func newBytesWriterAt(size int) *bytesWriterAt {
-	return &bytesWriterAt{data: make([]byte, size)}
+func (o *s3ObjectReader) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		output, err := o.carveS3.Client.GetObject(context.Background(), &s3.GetObjectInput{
+			Bucket: aws.String(o.carveS3.S3Config.Bucket),
+			Key:    aws.String(o.key),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-", o.offset)),
+		})
+		if err != nil {
+			return 0, fmt.Errorf("GetObject - %w", err)
+		}
+		o.body = output.Body
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	return n, err
 }
 
-func (b *bytesWriterAt) WriteAt(p []byte, off int64) (int, error) {
-	if off < 0 {
-		return 0, fmt.Errorf("negative offset")
+func (o *s3ObjectReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = o.offset + offset
+	case io.SeekEnd:
+		abs = o.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("negative position")
 	}
-	start := int(off)
-	end := start + len(p)
-	if end > len(b.data) {
-		return 0, fmt.Errorf("write exceeds buffer size")
+	if abs != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.offset = abs
+	return abs, nil
+}
+
+func (o *s3ObjectReader) Close() error {
+	if o.body == nil {
+		return nil
 	}
-	copy(b.data[start:end], p)
-	return len(p), nil
+	err := o.body.Close()
+	o.body = nil
+	return err
 }
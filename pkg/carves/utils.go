@@ -5,8 +5,10 @@ import (
 	"encoding/base64"
 	"fmt"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/jmpsec/osctrl/pkg/types"
 	"github.com/jmpsec/osctrl/pkg/utils"
 )
 
@@ -145,3 +147,118 @@ func GenCarveQuery(file string, glob bool) string {
 	}
 	return "SELECT * FROM carves WHERE carve=1 AND path = '" + escapeSQLString(file) + "';"
 }
+
+// GenSizedCarveQuery builds the osquery SQL that carves a single file only
+// when it is not larger than maxSize bytes. A larger file is not carved and
+// comes back as a row with `carve` 0 and the max_file_size skip reason, the
+// same way directory carves report skipped files.
+func GenSizedCarveQuery(file string, maxSize int64) string {
+	path := "'" + escapeSQLString(file) + "'"
+	size := strconv.FormatInt(maxSize, 10)
+	return "SELECT time, sha256, size, path, status, carve_guid, request_id, carve, '' AS skip_reason FROM carves " +
+		"WHERE carve=1 AND path IN (SELECT path FROM file WHERE path = " + path + " AND type = 'regular' AND size <= " + size + ") " +
+		"UNION ALL SELECT 0, '', size, path, 'SKIPPED', '', '', 0, '" + SkipMaxFileSize + "' FROM file WHERE path = " + path + " AND size > " + size + ";"
+}
+
+// ValidateDirectoryCarve checks a directory carve request before it is
+// turned into a query. The root must be an absolute path and the limits
+// can not be negative.
+func ValidateDirectoryCarve(d types.CarveDirectoryRequest) error {
+	root := strings.TrimSpace(d.Root)
+	if root == "" {
+		return fmt.Errorf("directory root can not be empty")
+	}
+	if !strings.HasPrefix(root, "/") && !(len(root) >= 3 && root[1] == ':' && root[2] == '\\') {
+		return fmt.Errorf("directory root must be an absolute path")
+	}
+	if d.MaxDepth < 0 {
+		return fmt.Errorf("max depth can not be negative")
+	}
+	if d.MaxFileSize < 0 || d.MaxTotalSize < 0 {
+		return fmt.Errorf("size limits can not be negative")
+	}
+	for _, g := range append(append([]string{}, d.Include...), d.Exclude...) {
+		if strings.TrimSpace(g) == "" {
+			return fmt.Errorf("include and exclude globs can not be empty")
+		}
+	}
+	return nil
+}
+
+// directorySeparator guesses the path separator of the node from the root,
+// so Windows roots like `C:\Users` are walked with `\`.
+func directorySeparator(root string) string {
+	if strings.Contains(root, `\`) && !strings.Contains(root, "/") {
+		return `\`
+	}
+	return "/"
+}
+
+// globMatchSQL returns the SQL condition matching a carve-style glob against
+// the file name, or against the full path when the glob has a separator.
+func globMatchSQL(glob, sep string) string {
+	column := "filename"
+	if strings.Contains(glob, sep) {
+		column = "path"
+	}
+	return column + " LIKE '" + globToLike(glob) + "' ESCAPE '\\'"
+}
+
+// GenDirectoryCarveQuery builds the osquery SQL for a recursive carve of a
+// directory tree. Candidate regular files come from the `file` table under
+// the root, limited by depth and the include globs. Files matching an
+// exclude glob, larger than the per-file limit or past the total size cap
+// (files are accumulated in path order) are not carved, and are returned as
+// extra rows with `carve` 0 and a `skip_reason`, so the server can report
+// them in the carve detail. The same escaping as GenCarveQuery applies to
+// the root and to every glob.
+func GenDirectoryCarveQuery(d types.CarveDirectoryRequest) (string, error) {
+	if err := ValidateDirectoryCarve(d); err != nil {
+		return "", err
+	}
+	root := strings.TrimSpace(d.Root)
+	sep := directorySeparator(root)
+	// Root without the trailing separator: `/` becomes empty and `C:\`
+	// becomes `C:`, so depth is counted in separators past the root
+	base := strings.TrimSuffix(root, sep)
+	where := []string{
+		"path LIKE '" + escapeSQLString(escapeLikePattern(base+sep)) + "%%' ESCAPE '\\'",
+		"type = 'regular'",
+	}
+	if d.MaxDepth > 0 {
+		rootDepth := strings.Count(base, sep)
+		where = append(where, fmt.Sprintf("(LENGTH(path) - LENGTH(REPLACE(path, '%s', ''))) <= %d", sep, rootDepth+d.MaxDepth))
+	}
+	if len(d.Include) > 0 {
+		include := make([]string, 0, len(d.Include))
+		for _, g := range d.Include {
+			include = append(include, globMatchSQL(g, sep))
+		}
+		where = append(where, "("+strings.Join(include, " OR ")+")")
+	}
+	reasons := []string{}
+	if len(d.Exclude) > 0 {
+		exclude := make([]string, 0, len(d.Exclude))
+		for _, g := range d.Exclude {
+			exclude = append(exclude, globMatchSQL(g, sep))
+		}
+		reasons = append(reasons, "WHEN "+strings.Join(exclude, " OR ")+" THEN '"+SkipExcluded+"'")
+	}
+	if d.MaxFileSize > 0 {
+		reasons = append(reasons, "WHEN size > "+strconv.FormatInt(d.MaxFileSize, 10)+" THEN '"+SkipMaxFileSize+"'")
+	}
+	reason := "''"
+	if len(reasons) > 0 {
+		reason = "CASE " + strings.Join(reasons, " ") + " ELSE '' END"
+	}
+	total := "reason"
+	if d.MaxTotalSize > 0 {
+		total = "CASE WHEN reason = '' AND SUM(CASE WHEN reason = '' THEN size ELSE 0 END) OVER (ORDER BY path ROWS UNBOUNDED PRECEDING) > " +
+			strconv.FormatInt(d.MaxTotalSize, 10) + " THEN '" + SkipMaxTotalSize + "' ELSE reason END"
+	}
+	return "WITH candidates AS (SELECT path, size, " + reason + " AS reason FROM file WHERE " + strings.Join(where, " AND ") + "), " +
+		"selected AS (SELECT path, size, " + total + " AS reason FROM candidates) " +
+		"SELECT time, sha256, size, path, status, carve_guid, request_id, carve, '' AS skip_reason FROM carves " +
+		"WHERE carve=1 AND path IN (SELECT path FROM selected WHERE reason = '') " +
+		"UNION ALL SELECT 0, '', size, path, 'SKIPPED', '', '', 0, reason FROM selected WHERE reason <> '';", nil
+}
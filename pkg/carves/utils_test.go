@@ -1,8 +1,14 @@
 package carves
 
 import (
+	"sort"
+	"strconv"
 	"strings"
 	"testing"
+
+	"github.com/jmpsec/osctrl/pkg/types"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
 // TestGenCarveQueryEscapes confirms that single quotes in the input
@@ -110,3 +116,139 @@ func TestGenCarveQueryShape(t *testing.T) {
 		t.Errorf("glob: got %q", q2)
 	}
 }
+
+// TestGenDirectoryCarveQuerySelection runs the generated directory carve
+// query against stand-in `file` and `carves` tables, confirming which files
+// are carved and which are reported as skipped, and why.
+func TestGenDirectoryCarveQuerySelection(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts := []string{
+		"CREATE TABLE file (path TEXT, filename TEXT, type TEXT, size INTEGER)",
+		"CREATE TABLE carves (time INTEGER, sha256 TEXT, size INTEGER, path TEXT, status TEXT, carve_guid TEXT, request_id TEXT, carve INTEGER)",
+	}
+	files := []struct {
+		path string
+		typ  string
+		size int
+	}{
+		{"/var/log/a.log", "regular", 10},
+		{"/var/log/b.log", "regular", 500},
+		{"/var/log/c.log", "regular", 30},
+		{"/var/log/d.log", "regular", 40},
+		{"/var/log/debug.log", "regular", 5},
+		{"/var/log/notes.txt", "regular", 5},
+		{"/var/log/old", "directory", 0},
+		{"/var/log/old/deep/e.log", "regular", 5},
+		{"/var/logs/other.log", "regular", 5},
+	}
+	for _, f := range files {
+		name := f.path[strings.LastIndex(f.path, "/")+1:]
+		stmts = append(stmts,
+			"INSERT INTO file VALUES ('"+f.path+"', '"+name+"', '"+f.typ+"', "+strconv.Itoa(f.size)+")",
+			"INSERT INTO carves VALUES (0, '', 0, '"+f.path+"', 'STARTING', 'guid', 'req', 1)")
+	}
+	for _, stmt := range stmts {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	query, err := GenDirectoryCarveQuery(types.CarveDirectoryRequest{
+		Root:         "/var/log/",
+		MaxDepth:     2,
+		Include:      []string{"*.log"},
+		Exclude:      []string{"debug*"},
+		MaxFileSize:  100,
+		MaxTotalSize: 60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []types.QueryCarveScheduled
+	if err := db.Raw(strings.TrimSuffix(query, ";")).Scan(&rows).Error; err != nil {
+		t.Fatalf("query failed: %v\n%s", err, query)
+	}
+	var carved, skipped []string
+	for _, r := range rows {
+		if r.SkipReason == "" {
+			carved = append(carved, r.Path)
+		} else {
+			skipped = append(skipped, r.Path+":"+r.SkipReason)
+		}
+	}
+	sort.Strings(carved)
+	sort.Strings(skipped)
+	wantCarved := []string{"/var/log/a.log", "/var/log/c.log"}
+	wantSkipped := []string{"/var/log/b.log:" + SkipMaxFileSize, "/var/log/d.log:" + SkipMaxTotalSize, "/var/log/debug.log:" + SkipExcluded}
+	if strings.Join(carved, ",") != strings.Join(wantCarved, ",") {
+		t.Errorf("carved = %v; want %v", carved, wantCarved)
+	}
+	if strings.Join(skipped, ",") != strings.Join(wantSkipped, ",") {
+		t.Errorf("skipped = %v; want %v", skipped, wantSkipped)
+	}
+}
+
+// TestGenDirectoryCarveQueryValidation confirms bad requests are rejected
+// and that the root and globs are escaped like single path carves.
+func TestGenSizedCarveQuery(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts := []string{
+		"CREATE TABLE file (path TEXT, filename TEXT, type TEXT, size INTEGER)",
+		"CREATE TABLE carves (time INTEGER, sha256 TEXT, size INTEGER, path TEXT, status TEXT, carve_guid TEXT, request_id TEXT, carve INTEGER)",
+		"INSERT INTO file VALUES ('/etc/hosts', 'hosts', 'regular', 200)",
+		"INSERT INTO file VALUES ('/var/log/big.log', 'big.log', 'regular', 5000)",
+		"INSERT INTO carves VALUES (0, '', 0, '/etc/hosts', 'STARTING', 'guid', 'req', 1)",
+		"INSERT INTO carves VALUES (0, '', 0, '/var/log/big.log', 'STARTING', 'guid', 'req', 1)",
+	}
+	for _, stmt := range stmts {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	cases := []struct {
+		path   string
+		reason string
+	}{
+		{"/etc/hosts", ""},
+		{"/var/log/big.log", SkipMaxFileSize},
+	}
+	for _, c := range cases {
+		query := GenSizedCarveQuery(c.path, 1024)
+		var rows []types.QueryCarveScheduled
+		if err := db.Raw(strings.TrimSuffix(query, ";")).Scan(&rows).Error; err != nil {
+			t.Fatalf("query failed: %v\n%s", err, query)
+		}
+		if len(rows) != 1 || rows[0].Path != c.path || rows[0].SkipReason != c.reason {
+			t.Errorf("GenSizedCarveQuery(%q) rows = %+v; want one row with reason %q", c.path, rows, c.reason)
+		}
+	}
+}
+
+func TestGenDirectoryCarveQueryValidation(t *testing.T) {
+	for _, d := range []types.CarveDirectoryRequest{
+		{Root: ""},
+		{Root: "relative/dir"},
+		{Root: "/tmp", MaxDepth: -1},
+		{Root: "/tmp", MaxTotalSize: -1},
+		{Root: "/tmp", Include: []string{" "}},
+	} {
+		if _, err := GenDirectoryCarveQuery(d); err == nil {
+			t.Errorf("GenDirectoryCarveQuery(%+v) expected error", d)
+		}
+	}
+	q, err := GenDirectoryCarveQuery(types.CarveDirectoryRequest{Root: `C:\Users\o'neil`, Exclude: []string{"*.tmp"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(q, `path LIKE 'C:\\Users\\o''neil\\%%' ESCAPE '\'`) {
+		t.Errorf("root not escaped: %q", q)
+	}
+	if !strings.Contains(q, `filename LIKE '%.tmp' ESCAPE '\'`) {
+		t.Errorf("exclude glob not matched on filename: %q", q)
+	}
+}
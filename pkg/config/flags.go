@@ -77,6 +77,8 @@ type ServiceParameters struct {
 	Logger *YAMLConfigurationLogger
 	// Carver configuration values
 	Carver *YAMLConfigurationCarver
+	// Console configuration values
+	Console *YAMLConfigurationConsole
 	// Admin configuration values
 	Admin *YAMLConfigurationAdmin
 	// Debug configuration values
@@ -168,6 +170,7 @@ func InitAPIFlags(params *ServiceParameters) []cli.Flag {
 	allFlags = append(allFlags, initSAMLFlags(params)...)
 	allFlags = append(allFlags, initOsqueryFlags(params)...)
 	allFlags = append(allFlags, initCarverFlags(params, ServiceAPI)...)
+	allFlags = append(allFlags, initConsoleFlags(params)...)
 	allFlags = append(allFlags, initDebugFlags(params, ServiceAPI)...)
 	return allFlags
 }
@@ -570,6 +573,26 @@ func initCarverFlags(params *ServiceParameters, service string) []cli.Flag {
 			Sources:     cli.EnvVars("CARVER_LOCAL_DIR"),
 			Destination: &params.Carver.Local.CarvesDir,
 		},
+		&cli.StringFlag{
+			Name:        "carver-keyfile",
+			Value:       "",
+			Usage:       "Keyfile with per-environment keys to encrypt carves at rest from `FILE`",
+			Sources:     cli.EnvVars("CARVER_KEYFILE"),
+			Destination: &params.Carver.KeyFile,
+		},
+	}
+}
+
+// initConsoleFlags initializes node console-related flags
+func initConsoleFlags(params *ServiceParameters) []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "console-transcript-key",
+			Value:       "",
+			Usage:       "Ed25519 private key in PEM to sign console session transcripts from `FILE`",
+			Sources:     cli.EnvVars("CONSOLE_TRANSCRIPT_KEY"),
+			Destination: &params.Console.TranscriptKeyFile,
+		},
 	}
 }
 
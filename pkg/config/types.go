@@ -108,6 +108,7 @@ type APIConfiguration struct {
 	TLS     YAMLConfigurationTLS     `mapstructure:"tls"`
 	Logger  YAMLConfigurationLogger  `mapstructure:"logger"`
 	Carver  YAMLConfigurationCarver  `mapstructure:"carver"`
+	Console YAMLConfigurationConsole `mapstructure:"console"`
 	Debug   YAMLConfigurationDebug   `mapstructure:"debug"`
 }
 
@@ -228,6 +229,16 @@ type YAMLConfigurationCarver struct {
 	Type  string       `yaml:"type"`
 	S3    *S3Carver    `mapstructure:"s3"`
 	Local *LocalCarver `mapstructure:"local"`
+	// KeyFile is the JSON keyfile with per-environment keys to encrypt
+	// carves at rest. When empty, carves are stored in plaintext.
+	KeyFile string `yaml:"keyFile"`
+}
+
+// YAMLConfigurationConsole to hold the node console configuration values
+type YAMLConfigurationConsole struct {
+	// TranscriptKeyFile is the PEM ed25519 private key that signs session
+	// transcripts. When empty, transcripts can not be exported.
+	TranscriptKeyFile string `yaml:"transcriptKeyFile"`
 }
 
 // YAMLConfigurationAdmin to hold admin UI specific configuration values
@@ -57,6 +57,7 @@ func GenerateAPIConfigFile(path string, cfg *ServiceParameters, overwrite bool)
 		JWT:     *cfg.JWT,
 		TLS:     *cfg.TLS,
 		Logger:  *cfg.Logger,
+		Console: *cfg.Console,
 		Debug:   *cfg.Debug,
 	}
 	return GenerateGenericConfigFile(path, cfgAPI, overwrite)
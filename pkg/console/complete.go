@@ -0,0 +1,332 @@
+package console
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jmpsec/osctrl/pkg/queries"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"gorm.io/gorm"
+)
+
+const (
+	CompletionReady   = "ready"
+	CompletionPending = "pending"
+
+	CandidateCommand   = "command"
+	CandidateDirectory = "directory"
+	CandidateFile      = "file"
+	CandidateTable     = "table"
+	CandidateColumn    = "column"
+
+	// ListingTTL is how long a directory listing is reused for completions
+	ListingTTL = 2 * time.Minute
+)
+
+// Candidate is one possible value for the token being completed.
+type Candidate struct {
+	Value string `json:"value"`
+	Kind  string `json:"kind"`
+}
+
+// Completion holds the candidates for the last token of the input. Clients
+// replace Token at the end of the input with a candidate, or with Common
+// when there are several and it is longer than Token. A pending completion
+// is waiting for the nodes to list a directory and should be asked again.
+type Completion struct {
+	Status     string      `json:"status"`
+	Token      string      `json:"token"`
+	Common     string      `json:"common"`
+	Candidates []Candidate `json:"candidates"`
+}
+
+// consoleVerbs are the commands accepted by Parse outside osquery mode
+var consoleVerbs = []string{
+	"cd", "clear", "find", "get", "hash", "head", "help", "last", "ls", "netstat",
+	"osquery", "ps", "pwd", "sql", "startup", "stat", "users", "who",
+}
+
+// pathVerbs are the commands that take a path argument
+var pathVerbs = map[string]bool{
+	"cd": true, "find": true, "get": true, "hash": true, "head": true, "ls": true, "stat": true,
+}
+
+// switchOptions are the options that do not take a value
+var switchOptions = map[string]bool{
+	"-r": true, "--recursive": true, "-l": true,
+}
+
+var sqlKeywords = map[string]bool{
+	"and": true, "as": true, "by": true, "cross": true, "distinct": true, "from": true,
+	"group": true, "having": true, "in": true, "inner": true, "join": true, "left": true,
+	"like": true, "limit": true, "not": true, "on": true, "or": true, "order": true,
+	"select": true, "union": true, "using": true, "where": true,
+}
+
+// Complete returns the completions for the end of input, which is what the
+// operator typed up to the cursor. Verbs and SQL are completed locally, SQL
+// from tables that the caller has filtered by the session platform. Paths
+// are completed from a listing of the directory on the session nodes, which
+// runs as a hidden query expiring after timeout and is reused for ListingTTL.
+func (m *Manager) Complete(session Session, input string, osqueryMode bool, tables []types.OsqueryTable, timeout time.Duration) (Completion, error) {
+	if !session.Active {
+		return Completion{}, fmt.Errorf("console session is closed")
+	}
+	if osqueryMode {
+		return completeSQL(input, tables), nil
+	}
+	token := lastToken(input, " \t")
+	fields := strings.Fields(input)
+	if len(fields) == 0 || (len(fields) == 1 && token != "") {
+		return completeWords(token, consoleVerbs, CandidateCommand), nil
+	}
+	verb := strings.ToLower(fields[0])
+	if verb == "sql" {
+		return completeSQL(input, tables), nil
+	}
+	if !pathVerbs[verb] || strings.HasPrefix(token, "-") {
+		return completeWords(token, nil, ""), nil
+	}
+	previous := fields[len(fields)-1]
+	if token != "" {
+		previous = fields[len(fields)-2]
+	}
+	if strings.HasPrefix(previous, "-") && !switchOptions[previous] {
+		// Values of options like -name or --depth are not paths
+		return completeWords(token, nil, ""), nil
+	}
+	return m.completePath(session, token, verb == "cd", timeout)
+}
+
+// completePath completes token with the entries of the directory it points
+// to, keeping the directory part as the operator typed it.
+func (m *Manager) completePath(session Session, token string, directoriesOnly bool, timeout time.Duration) (Completion, error) {
+	completion := Completion{Status: CompletionReady, Token: token, Candidates: []Candidate{}}
+	windows := strings.EqualFold(session.Platform, "windows")
+	separator := "/"
+	cut := strings.LastIndex(token, "/")
+	if windows {
+		separator = `\`
+		if i := strings.LastIndex(token, `\`); i > cut {
+			cut = i
+		}
+	}
+	typed, prefix := token[:cut+1], token[cut+1:]
+	rows, ready, err := m.listDirectory(session, resolvePath(typed, session.CWD, session.Platform), timeout)
+	if err != nil {
+		return Completion{}, err
+	}
+	if !ready {
+		completion.Status = CompletionPending
+		return completion, nil
+	}
+	seen := map[string]bool{}
+	for _, row := range rows {
+		name, _ := row["filename"].(string)
+		if name == "" || name == "." || name == ".." || seen[name] {
+			continue
+		}
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".") {
+			continue
+		}
+		if !hasPrefix(name, prefix, windows) {
+			continue
+		}
+		seen[name] = true
+		candidate := Candidate{Value: typed + name, Kind: CandidateFile}
+		if fileType, _ := row["type"].(string); fileType == "directory" {
+			candidate = Candidate{Value: typed + name + separator, Kind: CandidateDirectory}
+		} else if directoriesOnly {
+			continue
+		}
+		completion.Candidates = append(completion.Candidates, candidate)
+	}
+	return finishCompletion(completion), nil
+}
+
+// listDirectory returns the fresh listing of directory for a session, or
+// starts one and reports it as not ready. Listings that expire before any
+// node answers are dropped so that the next completion asks again.
+func (m *Manager) listDirectory(session Session, directory string, timeout time.Duration) ([]map[string]any, bool, error) {
+	if timeout <= 0 {
+		timeout = defaultCommandTimeout
+	}
+	var listing Listing
+	if err := m.DB.Where("session_id = ? AND directory = ? AND created_at > ?", session.ID, directory, time.Now().Add(-ListingTTL)).
+		Order("id DESC").Limit(1).Find(&listing).Error; err != nil {
+		return nil, false, err
+	}
+	if listing.ID == 0 {
+		return nil, false, m.createListing(session, directory, timeout)
+	}
+	var distributed queries.DistributedQuery
+	if err := m.DB.Where("name = ?", listing.DistributedQueryName).First(&distributed).Error; err != nil {
+		return nil, false, err
+	}
+	var pending int64
+	if err := m.DB.Model(&queries.NodeQuery{}).
+		Where("query_id = ? AND status = ?", distributed.ID, queries.DistributedQueryStatusPending).
+		Count(&pending).Error; err != nil {
+		return nil, false, err
+	}
+	if pending > 0 {
+		if distributed.Expiration.After(time.Now()) {
+			return nil, false, nil
+		}
+		if err := m.expireDistributedQuery(distributed.ID); err != nil {
+			return nil, false, err
+		}
+	}
+	rows, err := m.queryResults(listing.DistributedQueryName)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(rows) == 0 && pending > 0 {
+		if err := m.DB.Delete(&listing).Error; err != nil {
+			return nil, false, err
+		}
+	}
+	return rows, true, nil
+}
+
+func (m *Manager) createListing(session Session, directory string, timeout time.Duration) error {
+	members, err := m.SessionNodes(session)
+	if err != nil {
+		return err
+	}
+	sql := fmt.Sprintf("select filename, type from file where directory = %s", quoteSQL(directory))
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		extra, err := json.Marshal(map[string]any{"session_id": session.ID, "listing": directory})
+		if err != nil {
+			return err
+		}
+		distributed, err := createSessionQuery(tx, session, members, sql, timeout, string(extra))
+		if err != nil {
+			return err
+		}
+		listing := Listing{SessionID: session.ID, Directory: directory, DistributedQueryName: distributed.Name}
+		return tx.Create(&listing).Error
+	})
+}
+
+// completeSQL completes table names after FROM and JOIN, qualified columns
+// of the tables in the statement, and unqualified columns of those tables,
+// or table names when the statement does not reference any yet.
+func completeSQL(input string, tables []types.OsqueryTable) Completion {
+	token := lastToken(input, " \t\r\n,()=<>")
+	before := input[:len(input)-len(token)]
+	if strings.HasPrefix(token, ".") && strings.TrimSpace(before) == "" {
+		return completeWords(token, []string{".exit", ".tables"}, CandidateCommand)
+	}
+	byName := make(map[string]types.OsqueryTable, len(tables))
+	names := make([]string, 0, len(tables))
+	for _, table := range tables {
+		byName[strings.ToLower(table.Name)] = table
+		names = append(names, table.Name)
+	}
+	words := strings.FieldsFunc(input, func(r rune) bool {
+		return strings.ContainsRune(" \t\r\n,()=<>", r)
+	})
+	// Tables referenced anywhere in the statement, by name and by alias
+	referenced := map[string]string{}
+	var order []string
+	for i, word := range words {
+		keyword := strings.ToLower(word)
+		if (keyword != "from" && keyword != "join") || i+1 >= len(words) {
+			continue
+		}
+		name := strings.ToLower(words[i+1])
+		if _, ok := byName[name]; !ok {
+			continue
+		}
+		if _, ok := referenced[name]; !ok {
+			order = append(order, name)
+		}
+		referenced[name] = name
+		alias := i + 2
+		if alias < len(words) && strings.EqualFold(words[alias], "as") {
+			alias++
+		}
+		if alias < len(words) && words[alias] != token && !sqlKeywords[strings.ToLower(words[alias])] {
+			referenced[strings.ToLower(words[alias])] = name
+		}
+	}
+	previous := strings.Fields(strings.ToLower(before))
+	if len(previous) > 0 && (previous[len(previous)-1] == "from" || previous[len(previous)-1] == "join") {
+		return completeWords(token, names, CandidateTable)
+	}
+	if dot := strings.LastIndex(token, "."); dot > 0 {
+		completion := Completion{Status: CompletionReady, Token: token, Candidates: []Candidate{}}
+		table, ok := referenced[strings.ToLower(token[:dot])]
+		if !ok {
+			return completion
+		}
+		for _, column := range byName[table].Columns {
+			if hasPrefix(column.Name, token[dot+1:], true) {
+				completion.Candidates = append(completion.Candidates, Candidate{Value: token[:dot+1] + column.Name, Kind: CandidateColumn})
+			}
+		}
+		return finishCompletion(completion)
+	}
+	if len(order) == 0 {
+		return completeWords(token, names, CandidateTable)
+	}
+	var columns []string
+	for _, table := range order {
+		for _, column := range byName[table].Columns {
+			columns = append(columns, column.Name)
+		}
+	}
+	return completeWords(token, columns, CandidateColumn)
+}
+
+// completeWords completes token with the words that start with it
+func completeWords(token string, words []string, kind string) Completion {
+	completion := Completion{Status: CompletionReady, Token: token, Candidates: []Candidate{}}
+	seen := map[string]bool{}
+	for _, word := range words {
+		if seen[word] || !hasPrefix(word, token, kind != CandidateCommand) {
+			continue
+		}
+		seen[word] = true
+		completion.Candidates = append(completion.Candidates, Candidate{Value: word, Kind: kind})
+	}
+	return finishCompletion(completion)
+}
+
+func finishCompletion(completion Completion) Completion {
+	sort.Slice(completion.Candidates, func(i, j int) bool {
+		return completion.Candidates[i].Value < completion.Candidates[j].Value
+	})
+	if len(completion.Candidates) == 0 {
+		return completion
+	}
+	common := completion.Candidates[0].Value
+	for _, candidate := range completion.Candidates[1:] {
+		for !strings.HasPrefix(candidate.Value, common) {
+			_, size := utf8.DecodeLastRuneInString(common)
+			common = common[:len(common)-size]
+		}
+	}
+	completion.Common = common
+	return completion
+}
+
+// lastToken is the text after the last of the separators in input
+func lastToken(input, separators string) string {
+	return input[strings.LastIndexAny(input, separators)+1:]
+}
+
+func hasPrefix(value, prefix string, foldCase bool) bool {
+	if len(value) < len(prefix) {
+		return false
+	}
+	if foldCase {
+		return strings.EqualFold(value[:len(prefix)], prefix)
+	}
+	return value[:len(prefix)] == prefix
+}
@@ -0,0 +1,149 @@
+package console_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/console"
+	"github.com/jmpsec/osctrl/pkg/logging"
+	"github.com/jmpsec/osctrl/pkg/queries"
+	"github.com/jmpsec/osctrl/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func candidateValues(completion console.Completion) []string {
+	values := []string{}
+	for _, candidate := range completion.Candidates {
+		values = append(values, candidate.Value)
+	}
+	return values
+}
+
+func TestCompletePathListsDirectoryOnceAndReusesIt(t *testing.T) {
+	db, manager, env, node := setupConsoleManager(t)
+	session, err := manager.CreateSession(env, node, "alice")
+	require.NoError(t, err)
+	require.NoError(t, db.Model(&session).Update("cwd", "/etc").Error)
+	session.CWD = "/etc"
+
+	completion, err := manager.Complete(session, "ls ssh/ss", false, nil, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, console.CompletionPending, completion.Status)
+	require.Equal(t, "ssh/ss", completion.Token)
+
+	var listing console.Listing
+	require.NoError(t, db.Where("session_id = ?", session.ID).First(&listing).Error)
+	require.Equal(t, "/etc/ssh", listing.Directory)
+	var distributed queries.DistributedQuery
+	require.NoError(t, db.Where("name = ?", listing.DistributedQueryName).First(&distributed).Error)
+	require.Equal(t, queries.ConsoleQueryType, distributed.Type)
+	require.True(t, distributed.Hidden)
+	require.Contains(t, distributed.Query, "directory = '/etc/ssh'")
+
+	// Asking again while the node has not answered does not list it twice
+	completion, err = manager.Complete(session, "ls ssh/ss", false, nil, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, console.CompletionPending, completion.Status)
+	var count int64
+	require.NoError(t, db.Model(&console.Listing{}).Count(&count).Error)
+	require.Equal(t, int64(1), count)
+
+	rows, err := json.Marshal([]map[string]string{
+		{"filename": "ssh_config", "type": "regular"},
+		{"filename": "sshd_config", "type": "regular"},
+		{"filename": "ssh_config.d", "type": "directory"},
+		{"filename": "moduli", "type": "regular"},
+		{"filename": ".hidden", "type": "regular"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Create(&logging.OsqueryQueryData{UUID: node.UUID, Name: listing.DistributedQueryName, Data: string(rows)}).Error)
+	require.NoError(t, markNodeQueryStatus(db, listing.DistributedQueryName, queries.DistributedQueryStatusCompleted))
+
+	completion, err = manager.Complete(session, "ls ssh/ss", false, nil, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, console.CompletionReady, completion.Status)
+	require.Equal(t, []string{"ssh/ssh_config", "ssh/ssh_config.d/", "ssh/sshd_config"}, candidateValues(completion))
+	require.Equal(t, "ssh/ssh", completion.Common)
+
+	completion, err = manager.Complete(session, "cd ssh/", false, nil, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ssh/ssh_config.d/"}, candidateValues(completion))
+	require.Equal(t, console.CandidateDirectory, completion.Candidates[0].Kind)
+
+	require.NoError(t, db.Model(&console.Listing{}).Count(&count).Error)
+	require.Equal(t, int64(1), count)
+}
+
+func TestCompleteDropsListingsThatExpireUnanswered(t *testing.T) {
+	db, manager, env, node := setupConsoleManager(t)
+	session, err := manager.CreateSession(env, node, "alice")
+	require.NoError(t, err)
+
+	completion, err := manager.Complete(session, "stat /tm", false, nil, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, console.CompletionPending, completion.Status)
+	require.NoError(t, db.Model(&queries.DistributedQuery{}).Where("1 = 1").Update("expiration", time.Now().Add(-time.Second)).Error)
+
+	completion, err = manager.Complete(session, "stat /tm", false, nil, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, console.CompletionReady, completion.Status)
+	require.Empty(t, completion.Candidates)
+	var count int64
+	require.NoError(t, db.Model(&console.Listing{}).Count(&count).Error)
+	require.Equal(t, int64(0), count)
+}
+
+func TestCompleteVerbsAndOptionsWithoutListing(t *testing.T) {
+	db, manager, env, node := setupConsoleManager(t)
+	session, err := manager.CreateSession(env, node, "alice")
+	require.NoError(t, err)
+
+	completion, err := manager.Complete(session, "st", false, nil, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []string{"startup", "stat"}, candidateValues(completion))
+	require.Equal(t, "sta", completion.Common)
+
+	for _, input := range []string{"ps ", "find /var -name ", "get -r --depth "} {
+		completion, err = manager.Complete(session, input, false, nil, time.Minute)
+		require.NoError(t, err, input)
+		require.Equal(t, console.CompletionReady, completion.Status, input)
+		require.Empty(t, completion.Candidates, input)
+	}
+	var count int64
+	require.NoError(t, db.Model(&console.Listing{}).Count(&count).Error)
+	require.Equal(t, int64(0), count)
+}
+
+func TestCompleteSQLTablesAndColumns(t *testing.T) {
+	_, manager, env, node := setupConsoleManager(t)
+	session, err := manager.CreateSession(env, node, "alice")
+	require.NoError(t, err)
+	tables := []types.OsqueryTable{
+		{Name: "processes", Columns: []types.OsqueryColumn{{Name: "pid"}, {Name: "name"}, {Name: "path"}}},
+		{Name: "process_open_sockets", Columns: []types.OsqueryColumn{{Name: "pid"}, {Name: "remote_address"}}},
+		{Name: "users", Columns: []types.OsqueryColumn{{Name: "uid"}, {Name: "username"}}},
+	}
+
+	completion, err := manager.Complete(session, "select * from proc", true, tables, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []string{"process_open_sockets", "processes"}, candidateValues(completion))
+	require.Equal(t, "process", completion.Common)
+
+	completion, err = manager.Complete(session, "select p.pa", true, tables, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, completion.Candidates)
+
+	completion, err = manager.Complete(session, "select * from processes p join users as u using (uid) where u.user", true, tables, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []string{"u.username"}, candidateValues(completion))
+
+	completion, err = manager.Complete(session, "sql select * from processes where pa", false, tables, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []string{"path"}, candidateValues(completion))
+	require.Equal(t, console.CandidateColumn, completion.Candidates[0].Kind)
+
+	completion, err = manager.Complete(session, ".ta", true, tables, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []string{".tables"}, candidateValues(completion))
+}
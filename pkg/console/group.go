@@ -0,0 +1,283 @@
+package console
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/logging"
+	"github.com/jmpsec/osctrl/pkg/nodes"
+	"github.com/jmpsec/osctrl/pkg/queries"
+	"gorm.io/gorm"
+)
+
+// MaxGroupNodes caps how many nodes a group session can target, so one
+// console command can not turn into a fleet-wide query.
+const MaxGroupNodes = 50
+
+// CreateGroupSession opens a console session bound to several nodes of the
+// environment. Every remote command of the session is sent as one hidden
+// distributed query targeting all of them. Windows and non-Windows nodes can
+// not be mixed, because paths are resolved against a single working directory.
+func (m *Manager) CreateGroupSession(env environments.TLSEnvironment, nodeIDs []uint, creator, label string) (Session, []SessionNode, error) {
+	if len(nodeIDs) == 0 {
+		return Session{}, nil, fmt.Errorf("group session requires at least one node")
+	}
+	if len(nodeIDs) > MaxGroupNodes {
+		return Session{}, nil, fmt.Errorf("group session targets %d nodes, maximum is %d", len(nodeIDs), MaxGroupNodes)
+	}
+	var targets []nodes.OsqueryNode
+	if err := m.DB.Where("id IN ? AND environment_id = ?", nodeIDs, env.ID).Order("hostname").Find(&targets).Error; err != nil {
+		return Session{}, nil, err
+	}
+	if len(targets) == 0 {
+		return Session{}, nil, fmt.Errorf("no nodes found for group session")
+	}
+	platform := targets[0].Platform
+	windows := strings.EqualFold(platform, "windows")
+	for _, n := range targets[1:] {
+		if strings.EqualFold(n.Platform, "windows") != windows {
+			return Session{}, nil, fmt.Errorf("group session can not mix windows and non-windows nodes")
+		}
+		if !strings.EqualFold(n.Platform, platform) {
+			platform = ""
+		}
+	}
+	cwdPlatform := platform
+	if windows {
+		cwdPlatform = "windows"
+	}
+	session := Session{
+		EnvironmentID: env.ID,
+		Creator:       creator,
+		CWD:           DefaultCWD(cwdPlatform),
+		Platform:      platform,
+		Active:        true,
+		Group:         true,
+		Label:         label,
+	}
+	members := make([]SessionNode, 0, len(targets))
+	err := m.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		for _, n := range targets {
+			members = append(members, SessionNode{
+				SessionID: session.ID,
+				NodeID:    n.ID,
+				NodeUUID:  n.UUID,
+				Hostname:  n.Hostname,
+				Platform:  n.Platform,
+			})
+		}
+		return tx.Create(&members).Error
+	})
+	if err != nil {
+		return Session{}, nil, err
+	}
+	return session, members, nil
+}
+
+// SessionNodes returns the nodes of a group session, or the single node of
+// a regular session.
+func (m *Manager) SessionNodes(session Session) ([]SessionNode, error) {
+	if !session.Group {
+		return []SessionNode{{SessionID: session.ID, NodeID: session.NodeID, NodeUUID: session.NodeUUID, Platform: session.Platform}}, nil
+	}
+	var members []SessionNode
+	if err := m.DB.Where("session_id = ?", session.ID).Order("hostname").Find(&members).Error; err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// NodeStatuses returns the status of a command on every node of its session.
+func (m *Manager) NodeStatuses(command Command) ([]NodeStatus, error) {
+	session, err := m.GetSession(command.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	members, err := m.SessionNodes(session)
+	if err != nil {
+		return nil, err
+	}
+	byNode := map[uint]string{}
+	if command.DistributedQueryName != "" {
+		var distributed queries.DistributedQuery
+		if err := m.DB.Where("name = ?", command.DistributedQueryName).First(&distributed).Error; err != nil {
+			return nil, err
+		}
+		var nodeQueries []queries.NodeQuery
+		if err := m.DB.Where("query_id = ?", distributed.ID).Find(&nodeQueries).Error; err != nil {
+			return nil, err
+		}
+		for _, nq := range nodeQueries {
+			byNode[nq.NodeID] = nodeQueryStatus(nq.Status)
+		}
+	}
+	statuses := make([]NodeStatus, 0, len(members))
+	for _, member := range members {
+		status, ok := byNode[member.NodeID]
+		if !ok {
+			// Local commands never reach the nodes
+			status = command.Status
+		}
+		statuses = append(statuses, NodeStatus{
+			NodeID:   member.NodeID,
+			NodeUUID: member.NodeUUID,
+			Hostname: member.Hostname,
+			Status:   status,
+		})
+	}
+	return statuses, nil
+}
+
+// GroupResults returns the results of a command grouped by node, after
+// refreshing its status.
+func (m *Manager) GroupResults(commandID uint) ([]NodeResults, error) {
+	command, err := m.RefreshCommandStatus(commandID)
+	if err != nil {
+		return nil, err
+	}
+	return m.CommandNodeResults(command)
+}
+
+// CommandNodeResults returns the results of a command grouped by node,
+// without refreshing its status first.
+func (m *Manager) CommandNodeResults(command Command) ([]NodeResults, error) {
+	statuses, err := m.NodeStatuses(command)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := m.queryResultsByNode(command.DistributedQueryName)
+	if err != nil {
+		return nil, err
+	}
+	grouped := make([]NodeResults, 0, len(statuses))
+	for _, status := range statuses {
+		results := rows[status.NodeUUID]
+		if results == nil {
+			results = []map[string]any{}
+		}
+		grouped = append(grouped, NodeResults{NodeStatus: status, Results: results})
+	}
+	return grouped, nil
+}
+
+func (m *Manager) queryResultsByNode(queryName string) (map[string][]map[string]any, error) {
+	rows := map[string][]map[string]any{}
+	if queryName == "" {
+		return rows, nil
+	}
+	err := logging.StreamQueryResults(m.DB, queryName, func(row logging.OsqueryQueryData) error {
+		decoded, err := decodeResultRows([]byte(row.Data))
+		if err != nil {
+			return err
+		}
+		rows[row.UUID] = append(rows[row.UUID], decoded...)
+		return nil
+	})
+	return rows, err
+}
+
+// refreshGroupCommand derives the status of a group command from the node
+// queries of all its nodes. The command stays queued while any node is
+// pending and the query has not expired; afterwards it is completed if at
+// least one node answered, an error if every answer was an error, and
+// expired if no node answered at all.
+func (m *Manager) refreshGroupCommand(command Command, distributed queries.DistributedQuery) (map[string]any, error) {
+	var nodeQueries []queries.NodeQuery
+	if err := m.DB.Where("query_id = ?", distributed.ID).Find(&nodeQueries).Error; err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, nq := range nodeQueries {
+		counts[nq.Status]++
+	}
+	now := time.Now()
+	if counts[queries.DistributedQueryStatusPending] > 0 {
+		if !distributed.Expiration.Before(now) {
+			return nil, nil
+		}
+		if err := m.expireDistributedQuery(distributed.ID); err != nil {
+			return nil, err
+		}
+		counts[queries.DistributedQueryStatusExpired] += counts[queries.DistributedQueryStatusPending]
+		counts[queries.DistributedQueryStatusPending] = 0
+	}
+	completed := counts[queries.DistributedQueryStatusCompleted]
+	failed := counts[queries.DistributedQueryStatusError]
+	expired := counts[queries.DistributedQueryStatusExpired]
+	updates := map[string]any{}
+	switch {
+	case completed > 0:
+		status, errText, err := m.completedStatusForCommand(command)
+		if err != nil {
+			return nil, err
+		}
+		if errText == "" && failed+expired > 0 {
+			errText = fmt.Sprintf("%d of %d nodes did not complete this command", failed+expired, len(nodeQueries))
+		}
+		updates["status"] = status
+		updates["completed_at"] = &now
+		if errText != "" {
+			updates["error"] = errText
+		}
+	case failed > 0:
+		updates["status"] = StatusError
+		updates["error"] = "osquery returned an error for this command"
+		updates["completed_at"] = &now
+	default:
+		updates["status"] = StatusExpired
+		updates["expired_at"] = &now
+	}
+	return updates, nil
+}
+
+// nodesWithoutRows lists the nodes that completed a command without
+// returning any row, by hostname or UUID.
+func (m *Manager) nodesWithoutRows(command Command) ([]string, error) {
+	statuses, err := m.NodeStatuses(command)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := m.queryResultsByNode(command.DistributedQueryName)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, status := range statuses {
+		if status.Status != StatusCompleted || len(rows[status.NodeUUID]) > 0 {
+			continue
+		}
+		name := status.Hostname
+		if name == "" {
+			name = status.NodeUUID
+		}
+		missing = append(missing, name)
+	}
+	return missing, nil
+}
+
+func nodeQueryStatus(status string) string {
+	switch status {
+	case queries.DistributedQueryStatusCompleted:
+		return StatusCompleted
+	case queries.DistributedQueryStatusError:
+		return StatusError
+	case queries.DistributedQueryStatusExpired:
+		return StatusExpired
+	default:
+		return StatusQueued
+	}
+}
+
+// NodeSessions returns the sessions opened on a node, on its own or as a
+// member of a group session, oldest first.
+func (m *Manager) NodeSessions(nodeID uint) ([]Session, error) {
+	var sessions []Session
+	members := m.DB.Model(&SessionNode{}).Select("session_id").Where("node_id = ?", nodeID)
+	err := m.DB.Where("node_id = ? OR id IN (?)", nodeID, members).Order("created_at, id").Find(&sessions).Error
+	return sessions, err
+}
@@ -22,7 +22,7 @@ type Manager struct {
 }
 
 func NewManager(db *gorm.DB, queryManager *queries.Queries) *Manager {
-	if err := db.AutoMigrate(&Session{}, &Command{}); err != nil {
+	if err := db.AutoMigrate(&Session{}, &Command{}, &SessionNode{}, &Listing{}); err != nil {
 		panic(fmt.Sprintf("failed to migrate console tables: %v", err))
 	}
 	return &Manager{DB: db, Queries: queryManager}
@@ -95,6 +95,11 @@ func (m *Manager) SubmitCommandWithTimeout(sessionID uint, input string, timeout
 		return Command{}, ParsedCommand{}, err
 	}
 
+	members, err := m.SessionNodes(session)
+	if err != nil {
+		return Command{}, ParsedCommand{}, err
+	}
+
 	command := Command{
 		SessionID:     sessionID,
 		Input:         input,
@@ -117,27 +122,8 @@ func (m *Manager) SubmitCommandWithTimeout(sessionID uint, input string, timeout
 		if err != nil {
 			return err
 		}
-		distributed := queries.DistributedQuery{
-			Name:          queries.GenQueryName(),
-			Query:         parsed.SQL,
-			Creator:       session.Creator,
-			Active:        true,
-			Hidden:        true,
-			Type:          queries.ConsoleQueryType,
-			EnvironmentID: session.EnvironmentID,
-			Expiration:    time.Now().Add(timeout),
-			Expected:      1,
-			ExtraData:     string(extra),
-		}
-		if err := tx.Create(&distributed).Error; err != nil {
-			return err
-		}
-		nodeQuery := queries.NodeQuery{
-			NodeID:  session.NodeID,
-			QueryID: distributed.ID,
-			Status:  queries.DistributedQueryStatusPending,
-		}
-		if err := tx.Create(&nodeQuery).Error; err != nil {
+		distributed, err := createSessionQuery(tx, session, members, parsed.SQL, timeout, string(extra))
+		if err != nil {
 			return err
 		}
 		command.DistributedQueryName = distributed.Name
@@ -150,6 +136,37 @@ func (m *Manager) SubmitCommandWithTimeout(sessionID uint, input string, timeout
 	return command, parsed, nil
 }
 
+// createSessionQuery creates the hidden accelerated query that runs sql on
+// every node of a session.
+func createSessionQuery(tx *gorm.DB, session Session, members []SessionNode, sql string, timeout time.Duration, extra string) (queries.DistributedQuery, error) {
+	distributed := queries.DistributedQuery{
+		Name:          queries.GenQueryName(),
+		Query:         sql,
+		Creator:       session.Creator,
+		Active:        true,
+		Hidden:        true,
+		Type:          queries.ConsoleQueryType,
+		EnvironmentID: session.EnvironmentID,
+		Expiration:    time.Now().Add(timeout),
+		Expected:      len(members),
+		ExtraData:     extra,
+	}
+	if err := tx.Create(&distributed).Error; err != nil {
+		return queries.DistributedQuery{}, err
+	}
+	for _, member := range members {
+		nodeQuery := queries.NodeQuery{
+			NodeID:  member.NodeID,
+			QueryID: distributed.ID,
+			Status:  queries.DistributedQueryStatusPending,
+		}
+		if err := tx.Create(&nodeQuery).Error; err != nil {
+			return queries.DistributedQuery{}, err
+		}
+	}
+	return distributed, nil
+}
+
 func (m *Manager) CloseSession(sessionID uint) error {
 	now := time.Now()
 	return m.DB.Transaction(func(tx *gorm.DB) error {
@@ -171,6 +188,25 @@ func (m *Manager) GetCommand(sessionID, commandID uint) (Command, error) {
 	return command, nil
 }
 
+// CommandsSince returns the commands of a session with an ID above afterID,
+// oldest first.
+func (m *Manager) CommandsSince(sessionID, afterID uint) ([]Command, error) {
+	var commands []Command
+	if err := m.DB.Where("session_id = ? AND id > ?", sessionID, afterID).Order("id").Find(&commands).Error; err != nil {
+		return nil, err
+	}
+	return commands, nil
+}
+
+// PendingCommands returns the commands of a session still waiting on nodes.
+func (m *Manager) PendingCommands(sessionID uint) ([]Command, error) {
+	var commands []Command
+	if err := m.DB.Where("session_id = ? AND status IN ?", sessionID, []string{StatusQueued, StatusDelivered}).Order("id").Find(&commands).Error; err != nil {
+		return nil, err
+	}
+	return commands, nil
+}
+
 func (m *Manager) RefreshCommandStatus(commandID uint) (Command, error) {
 	var command Command
 	if err := m.DB.First(&command, commandID).Error; err != nil {
@@ -184,6 +220,17 @@ func (m *Manager) RefreshCommandStatus(commandID uint) (Command, error) {
 	if err := m.DB.Where("name = ?", command.DistributedQueryName).First(&distributed).Error; err != nil {
 		return Command{}, err
 	}
+	var session Session
+	if err := m.DB.First(&session, command.SessionID).Error; err != nil {
+		return Command{}, err
+	}
+	if session.Group {
+		updates, err := m.refreshGroupCommand(command, distributed)
+		if err != nil {
+			return Command{}, err
+		}
+		return m.applyCommandUpdates(command, updates)
+	}
 	var nodeQuery queries.NodeQuery
 	if err := m.DB.Where("query_id = ?", distributed.ID).First(&nodeQuery).Error; err != nil {
 		return Command{}, err
@@ -218,13 +265,18 @@ func (m *Manager) RefreshCommandStatus(commandID uint) (Command, error) {
 		updates["status"] = StatusExpired
 		updates["expired_at"] = &now
 	}
-	if len(updates) > 0 {
-		if err := m.DB.Model(&command).Updates(updates).Error; err != nil {
-			return Command{}, err
-		}
-		if err := m.DB.First(&command, commandID).Error; err != nil {
-			return Command{}, err
-		}
+	return m.applyCommandUpdates(command, updates)
+}
+
+func (m *Manager) applyCommandUpdates(command Command, updates map[string]any) (Command, error) {
+	if len(updates) == 0 {
+		return command, nil
+	}
+	if err := m.DB.Model(&command).Updates(updates).Error; err != nil {
+		return Command{}, err
+	}
+	if err := m.DB.First(&command, command.ID).Error; err != nil {
+		return Command{}, err
 	}
 	return command, nil
 }
@@ -297,12 +349,22 @@ func (m *Manager) completedStatusForCommand(command Command) (string, string, er
 		return StatusCompleted, "", nil
 	}
 
-	rows, err := m.queryResults(command.DistributedQueryName)
-	if err != nil {
-		return StatusError, "", err
-	}
-	if len(rows) == 0 {
-		return StatusError, fmt.Sprintf("directory not found: %s", parsed.Path), nil
+	if session.Group {
+		missing, err := m.nodesWithoutRows(command)
+		if err != nil {
+			return StatusError, "", err
+		}
+		if len(missing) > 0 {
+			return StatusError, fmt.Sprintf("directory not found: %s on %s", parsed.Path, strings.Join(missing, ", ")), nil
+		}
+	} else {
+		rows, err := m.queryResults(command.DistributedQueryName)
+		if err != nil {
+			return StatusError, "", err
+		}
+		if len(rows) == 0 {
+			return StatusError, fmt.Sprintf("directory not found: %s", parsed.Path), nil
+		}
 	}
 	if err := m.DB.Model(&session).Update("cwd", parsed.Path).Error; err != nil {
 		return StatusError, "", err
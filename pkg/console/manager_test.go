@@ -298,3 +298,64 @@ func markNodeQueryStatus(db *gorm.DB, name, status string) error {
 	}
 	return db.Model(&queries.NodeQuery{}).Where("query_id = ?", distributed.ID).Update("status", status).Error
 }
+
+func TestGroupSessionFansOutCommandAndGroupsResultsByNode(t *testing.T) {
+	db, manager, env, node := setupConsoleManager(t)
+	require.NoError(t, db.Model(&node).Update("hostname", "alpha").Error)
+	second := nodes.OsqueryNode{UUID: "NODE-TWO", Hostname: "bravo", Platform: "ubuntu", EnvironmentID: env.ID, Environment: env.UUID}
+	require.NoError(t, db.Create(&second).Error)
+
+	session, members, err := manager.CreateGroupSession(env, []uint{node.ID, second.ID}, "alice", "tag:incident")
+	require.NoError(t, err)
+	require.True(t, session.Group)
+	require.Equal(t, "/", session.CWD)
+	require.Len(t, members, 2)
+
+	command, _, err := manager.SubmitCommand(session.ID, "ls /tmp")
+	require.NoError(t, err)
+	var distributed queries.DistributedQuery
+	require.NoError(t, db.Where("name = ?", command.DistributedQueryName).First(&distributed).Error)
+	require.True(t, distributed.Hidden)
+	require.Equal(t, 2, distributed.Expected)
+	var nodeQueries int64
+	require.NoError(t, db.Model(&queries.NodeQuery{}).Where("query_id = ?", distributed.ID).Count(&nodeQueries).Error)
+	require.Equal(t, int64(2), nodeQueries)
+
+	// Only the first node answers: the command stays queued
+	data, err := json.Marshal([]map[string]string{{"path": "/tmp/x"}})
+	require.NoError(t, err)
+	require.NoError(t, db.Create(&logging.OsqueryQueryData{UUID: node.UUID, Name: command.DistributedQueryName, Data: string(data)}).Error)
+	require.NoError(t, manager.Queries.UpdateQueryStatus(command.DistributedQueryName, node.ID, 0))
+	got, err := manager.RefreshCommandStatus(command.ID)
+	require.NoError(t, err)
+	require.Equal(t, console.StatusQueued, got.Status)
+
+	require.NoError(t, manager.Queries.UpdateQueryStatus(command.DistributedQueryName, second.ID, 1))
+	grouped, err := manager.GroupResults(command.ID)
+	require.NoError(t, err)
+	require.Len(t, grouped, 2)
+	require.Equal(t, "alpha", grouped[0].Hostname)
+	require.Equal(t, console.StatusCompleted, grouped[0].Status)
+	require.Equal(t, []map[string]any{{"path": "/tmp/x"}}, grouped[0].Results)
+	require.Equal(t, "bravo", grouped[1].Hostname)
+	require.Equal(t, console.StatusError, grouped[1].Status)
+	require.Empty(t, grouped[1].Results)
+
+	got, err = manager.RefreshCommandStatus(command.ID)
+	require.NoError(t, err)
+	require.Equal(t, console.StatusCompleted, got.Status)
+	require.Contains(t, got.Error, "1 of 2 nodes")
+}
+
+func TestGroupSessionRejectsMixedWindowsAndTooManyNodes(t *testing.T) {
+	db, manager, env, node := setupConsoleManager(t)
+	windows := nodes.OsqueryNode{UUID: "NODE-WIN", Platform: "windows", EnvironmentID: env.ID, Environment: env.UUID}
+	require.NoError(t, db.Create(&windows).Error)
+
+	_, _, err := manager.CreateGroupSession(env, []uint{node.ID, windows.ID}, "alice", "")
+	require.Error(t, err)
+
+	tooMany := make([]uint, console.MaxGroupNodes+1)
+	_, _, err = manager.CreateGroupSession(env, tooMany, "alice", "")
+	require.Error(t, err)
+}
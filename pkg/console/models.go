@@ -3,6 +3,7 @@ package console
 import (
 	"time"
 
+	"github.com/jmpsec/osctrl/pkg/types"
 	"gorm.io/gorm"
 )
 
@@ -33,12 +34,44 @@ type Session struct {
 	Platform      string         `json:"platform"`
 	Active        bool           `gorm:"not null;default:true" json:"active"`
 	ClosedAt      *time.Time     `json:"closed_at,omitempty"`
+	// Group sessions fan every command out to the nodes in SessionNode
+	Group bool   `gorm:"not null;default:false" json:"group"`
+	Label string `json:"label,omitempty"`
 }
 
 func (Session) TableName() string {
 	return "console_sessions"
 }
 
+// SessionNode is one of the nodes targeted by a group session.
+type SessionNode struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	SessionID uint      `gorm:"not null;index" json:"session_id"`
+	NodeID    uint      `gorm:"not null" json:"node_id"`
+	NodeUUID  string    `gorm:"not null" json:"node_uuid"`
+	Hostname  string    `json:"hostname"`
+	Platform  string    `json:"platform"`
+}
+
+func (SessionNode) TableName() string {
+	return "console_session_nodes"
+}
+
+// NodeStatus is the state of a group session command on one node.
+type NodeStatus struct {
+	NodeID   uint   `json:"node_id"`
+	NodeUUID string `json:"node_uuid"`
+	Hostname string `json:"hostname"`
+	Status   string `json:"status"`
+}
+
+// NodeResults groups the result rows of a command by the node that sent them.
+type NodeResults struct {
+	NodeStatus
+	Results []map[string]any `json:"results"`
+}
+
 type Command struct {
 	ID                   uint           `gorm:"primarykey" json:"id"`
 	CreatedAt            time.Time      `json:"created_at"`
@@ -59,6 +92,20 @@ func (Command) TableName() string {
 	return "console_commands"
 }
 
+// Listing is a directory listing run on the nodes of a session to complete
+// paths, reused by later completions while it is younger than ListingTTL.
+type Listing struct {
+	ID                   uint      `gorm:"primarykey" json:"id"`
+	CreatedAt            time.Time `json:"created_at"`
+	SessionID            uint      `gorm:"not null;index" json:"session_id"`
+	Directory            string    `gorm:"not null" json:"directory"`
+	DistributedQueryName string    `gorm:"index" json:"distributed_query_name"`
+}
+
+func (Listing) TableName() string {
+	return "console_listings"
+}
+
 type ParsedCommand struct {
 	Kind    string `json:"kind"`
 	Command string `json:"command"`
@@ -67,6 +114,8 @@ type ParsedCommand struct {
 	SQL     string `json:"sql,omitempty"`
 	Output  string `json:"output,omitempty"`
 	Message string `json:"message,omitempty"`
+	// Directory is set for recursive `get -r` carves
+	Directory *types.CarveDirectoryRequest `json:"directory,omitempty"`
 }
 
 type HistoryEntry struct {
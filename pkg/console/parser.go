@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
+
+	"github.com/jmpsec/osctrl/pkg/types"
 )
 
 var forbiddenShellSyntax = regexp.MustCompile(`[|&;<>]`)
@@ -57,8 +60,7 @@ func ParseInput(input, cwd, platform string, osqueryMode bool) (ParsedCommand, e
 		if forbiddenShellSyntax.MatchString(args) {
 			return ParsedCommand{}, fmt.Errorf("shell syntax is not supported")
 		}
-		target := resolvePath(args, cwd, platform)
-		return ParsedCommand{Kind: CommandCarve, Command: "get", Path: target}, nil
+		return parseGet(args, cwd, platform)
 	case "ls":
 		if forbiddenShellSyntax.MatchString(args) {
 			return ParsedCommand{}, fmt.Errorf("shell syntax is not supported")
@@ -100,6 +102,8 @@ func ParseInput(input, cwd, platform string, osqueryMode bool) (ParsedCommand, e
 			return ParsedCommand{}, err
 		}
 		return ParsedCommand{Kind: CommandRemote, Command: "sql", SQL: sql}, nil
+	case "hash", "find", "netstat", "users", "who", "last", "startup", "head":
+		return parseTriage(cmd, args, cwd, platform)
 	case "osquery":
 		if args != "" {
 			return ParsedCommand{}, fmt.Errorf("osquery does not accept arguments")
@@ -110,6 +114,53 @@ func ParseInput(input, cwd, platform string, osqueryMode bool) (ParsedCommand, e
 	}
 }
 
+// parseGet handles `get <path>` and the recursive form
+// `get -r [--depth N] [--include GLOB] [--exclude GLOB] [--max-file-size N] [--max-total-size N] <dir>`,
+// where include and exclude may be repeated and sizes are in bytes.
+func parseGet(args, cwd, platform string) (ParsedCommand, error) {
+	fields := strings.Fields(args)
+	if fields[0] != "-r" && fields[0] != "--recursive" {
+		target := resolvePath(args, cwd, platform)
+		return ParsedCommand{Kind: CommandCarve, Command: "get", Path: target}, nil
+	}
+	dir := &types.CarveDirectoryRequest{}
+	i := 1
+	for ; i < len(fields) && strings.HasPrefix(fields[i], "--"); i++ {
+		option := fields[i]
+		if i+1 >= len(fields) {
+			return ParsedCommand{}, fmt.Errorf("get option %s requires a value", option)
+		}
+		i++
+		value := fields[i]
+		switch option {
+		case "--include":
+			dir.Include = append(dir.Include, value)
+		case "--exclude":
+			dir.Exclude = append(dir.Exclude, value)
+		case "--depth", "--max-file-size", "--max-total-size":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return ParsedCommand{}, fmt.Errorf("get option %s requires a non-negative number", option)
+			}
+			switch option {
+			case "--depth":
+				dir.MaxDepth = int(n)
+			case "--max-file-size":
+				dir.MaxFileSize = n
+			case "--max-total-size":
+				dir.MaxTotalSize = n
+			}
+		default:
+			return ParsedCommand{}, fmt.Errorf("unsupported get option %q", option)
+		}
+	}
+	if i >= len(fields) {
+		return ParsedCommand{}, fmt.Errorf("get -r requires a directory path")
+	}
+	dir.Root = resolvePath(strings.Join(fields[i:], " "), cwd, platform)
+	return ParsedCommand{Kind: CommandCarve, Command: "get", Path: dir.Root, Directory: dir}, nil
+}
+
 func validateSelect(sql string) error {
 	lower := strings.ToLower(strings.TrimSpace(sql))
 	if !strings.HasPrefix(lower, "select ") {
@@ -182,5 +233,5 @@ func quoteSQL(value string) string {
 }
 
 func helpText() string {
-	return "Supported commands: pwd, cd <path>, ls [path], stat <path>, ps, sql [select ...], osquery, get <path>, help, clear. In osquery mode: .tables, .exit"
+	return "Supported commands: pwd, cd <path>, ls [path], stat <path>, ps, hash <path>, find [dir] [-name glob] [-depth n], netstat [-l], users, who, last, startup, head <path>, sql [select ...], osquery, get [-r] <path>, help, clear. In osquery mode: .tables, .exit"
 }
@@ -110,9 +110,107 @@ func TestParseGetCreatesCarveCommand(t *testing.T) {
 	require.Contains(t, err.Error(), "path")
 }
 
+func TestParseGetRecursiveDirectory(t *testing.T) {
+	got, err := console.Parse("get -r --depth 2 --include *.log --include *.gz --exclude debug* --max-file-size 1048576 --max-total-size 10485760 log", "/var", "linux")
+	require.NoError(t, err)
+	require.Equal(t, console.CommandCarve, got.Kind)
+	require.Equal(t, "/var/log", got.Path)
+	require.NotNil(t, got.Directory)
+	require.Equal(t, "/var/log", got.Directory.Root)
+	require.Equal(t, 2, got.Directory.MaxDepth)
+	require.Equal(t, []string{"*.log", "*.gz"}, got.Directory.Include)
+	require.Equal(t, []string{"debug*"}, got.Directory.Exclude)
+	require.Equal(t, int64(1048576), got.Directory.MaxFileSize)
+	require.Equal(t, int64(10485760), got.Directory.MaxTotalSize)
+
+	for _, input := range []string{"get -r", "get -r --depth", "get -r --depth -1 /tmp", "get -r --follow yes /tmp", "get -r /tmp; ps"} {
+		_, err := console.Parse(input, "/", "linux")
+		require.Error(t, err, input)
+	}
+}
+
 func TestParseRejectsShellSyntax(t *testing.T) {
 	for _, input := range []string{"ls /tmp | head", "ls > out", "ls && ps", "cat /etc/passwd"} {
 		_, err := console.Parse(input, "/", "linux")
 		require.Error(t, err, input)
 	}
 }
+
+func TestParseHashResolvesPath(t *testing.T) {
+	got, err := console.Parse("hash ssh/sshd_config", "/etc", "linux")
+	require.NoError(t, err)
+	require.Equal(t, console.CommandRemote, got.Kind)
+	require.Equal(t, "/etc/ssh/sshd_config", got.Path)
+	require.Contains(t, got.SQL, "from hash")
+	require.Contains(t, got.SQL, "path = '/etc/ssh/sshd_config'")
+
+	_, err = console.Parse("hash", "/", "linux")
+	require.Error(t, err)
+}
+
+func TestParseFindBuildsRecursiveFileQuery(t *testing.T) {
+	got, err := console.Parse("find log -name *.log -depth 2", "/var", "linux")
+	require.NoError(t, err)
+	require.Equal(t, console.CommandRemote, got.Kind)
+	require.Equal(t, "find", got.Command)
+	require.Equal(t, "/var/log", got.Path)
+	require.Contains(t, got.SQL, `path like '/var/log/%%' escape '\'`)
+	require.Contains(t, got.SQL, "<= 4")
+	require.Contains(t, got.SQL, `filename like '%.log' escape '\'`)
+
+	got, err = console.Parse(`find -name run_me?.bat`, `C:\Users`, "windows")
+	require.NoError(t, err)
+	require.Equal(t, `C:\Users`, got.Path)
+	require.Contains(t, got.SQL, `path like 'C:\\Users\\%%' escape '\'`)
+	require.Contains(t, got.SQL, `filename like 'run\_me_.bat' escape '\'`)
+	require.Contains(t, got.SQL, "<= 4")
+
+	for _, input := range []string{"find /tmp -depth 0", "find /tmp -depth 11", "find /tmp -name", "find /tmp -type f", "find /tmp -name *.sh; ps"} {
+		_, err := console.Parse(input, "/", "linux")
+		require.Error(t, err, input)
+	}
+}
+
+func TestParseTriageCommandsArePlatformAware(t *testing.T) {
+	cases := []struct {
+		input    string
+		platform string
+		table    string
+	}{
+		{"netstat", "linux", "from process_open_sockets"},
+		{"netstat -l", "windows", "from listening_ports"},
+		{"users", "darwin", "shell from users"},
+		{"users", "windows", "type from users"},
+		{"who", "linux", "from logged_in_users"},
+		{"last", "ubuntu", "from last"},
+		{"last", "windows", "from logon_sessions"},
+		{"startup", "darwin", "from launchd"},
+		{"startup", "windows", "from services"},
+		{"startup", "ubuntu", "from startup_items"},
+		{"startup", "", "from startup_items"},
+	}
+	for _, c := range cases {
+		got, err := console.Parse(c.input, console.DefaultCWD(c.platform), c.platform)
+		require.NoError(t, err, c.input)
+		require.Equal(t, console.CommandRemote, got.Kind, c.input)
+		require.Contains(t, got.SQL, c.table, c.input+" on "+c.platform)
+	}
+
+	for _, input := range []string{"netstat -an", "users root", "last -n 5", "startup all"} {
+		_, err := console.Parse(input, "/", "linux")
+		require.Error(t, err, input)
+	}
+}
+
+func TestParseHeadCreatesCarveCommand(t *testing.T) {
+	got, err := console.Parse(`head drivers\etc\hosts`, `C:\Windows\System32`, "windows")
+	require.NoError(t, err)
+	require.Equal(t, console.CommandCarve, got.Kind)
+	require.Equal(t, "head", got.Command)
+	require.Equal(t, `C:\Windows\System32\drivers\etc\hosts`, got.Path)
+
+	for _, input := range []string{"head", "head /etc/passwd > /tmp/x"} {
+		_, err := console.Parse(input, "/", "linux")
+		require.Error(t, err, input)
+	}
+}
@@ -0,0 +1,147 @@
+package console
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog/log"
+)
+
+// StreamChannel is the Redis pub/sub channel used to fan console stream
+// notices out to every API instance.
+const StreamChannel = "osctrl:console:stream"
+
+// StreamNotice tells console streams that something changed. osctrl-tls
+// sends Query, the name of the distributed query a node just wrote results
+// for; API instances send SessionID and CommandID when a command is created.
+type StreamNotice struct {
+	Query     string `json:"query,omitempty"`
+	SessionID uint   `json:"session_id,omitempty"`
+	CommandID uint   `json:"command_id,omitempty"`
+}
+
+// Broker delivers stream notices to the console streams open in this
+// process. With Redis, notices are published to StreamChannel and every
+// instance running Listen delivers them to its own streams; without it,
+// notices only reach streams of the same process.
+type Broker struct {
+	manager     *Manager
+	redis       *redis.Client
+	mu          sync.Mutex
+	subscribers map[uint]map[chan struct{}]struct{}
+}
+
+// NewBroker creates a broker. The manager resolves query names to console
+// sessions, and can be nil for publish-only brokers such as osctrl-tls.
+func NewBroker(manager *Manager) *Broker {
+	return &Broker{
+		manager:     manager,
+		subscribers: make(map[uint]map[chan struct{}]struct{}),
+	}
+}
+
+// WithRedis makes the broker publish notices through Redis pub/sub, so they
+// reach streams on every API instance. A nil client is ignored.
+func (b *Broker) WithRedis(client *redis.Client) *Broker {
+	b.redis = client
+	return b
+}
+
+// Subscribe returns a channel signalled whenever a notice concerns the
+// session, and the function to release it. Signals are coalesced, so a
+// slow reader sees one pending signal rather than a backlog.
+func (b *Broker) Subscribe(sessionID uint) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	if b.subscribers[sessionID] == nil {
+		b.subscribers[sessionID] = make(map[chan struct{}]struct{})
+	}
+	b.subscribers[sessionID][ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers[sessionID], ch)
+		if len(b.subscribers[sessionID]) == 0 {
+			delete(b.subscribers, sessionID)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Publish sends a notice to every instance, or delivers it locally when
+// Redis is not configured or publishing fails.
+func (b *Broker) Publish(ctx context.Context, notice StreamNotice) {
+	if b.redis != nil {
+		data, err := json.Marshal(notice)
+		if err == nil {
+			err = b.redis.Publish(ctx, StreamChannel, data).Err()
+		}
+		if err == nil {
+			return
+		}
+		log.Err(err).Msg("error publishing console stream notice")
+	}
+	b.deliver(notice)
+}
+
+// QueryWritten publishes that a node wrote results for a distributed query,
+// so the broker can be used as the query write notifier of osctrl-tls.
+func (b *Broker) QueryWritten(name, uuid string) {
+	b.Publish(context.Background(), StreamNotice{Query: name})
+}
+
+// Listen delivers notices received from Redis until ctx is done. It returns
+// right away when Redis is not configured.
+func (b *Broker) Listen(ctx context.Context) {
+	if b.redis == nil {
+		return
+	}
+	pubsub := b.redis.Subscribe(ctx, StreamChannel)
+	defer pubsub.Close()
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var notice StreamNotice
+			if err := json.Unmarshal([]byte(msg.Payload), &notice); err != nil {
+				log.Err(err).Msg("error decoding console stream notice")
+				continue
+			}
+			b.deliver(notice)
+		}
+	}
+}
+
+func (b *Broker) deliver(notice StreamNotice) {
+	b.mu.Lock()
+	idle := len(b.subscribers) == 0
+	b.mu.Unlock()
+	if idle {
+		return
+	}
+	sessionID := notice.SessionID
+	if sessionID == 0 && notice.Query != "" && b.manager != nil {
+		var command Command
+		if err := b.manager.DB.Where("distributed_query_name = ?", notice.Query).Limit(1).Find(&command).Error; err != nil {
+			log.Err(err).Msg("error resolving console stream notice")
+			return
+		}
+		// Zero for queries that are not console commands
+		sessionID = command.SessionID
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers[sessionID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package console_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/console"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBrokerDeliversQueryNoticesToCommandSession(t *testing.T) {
+	_, manager, env, node := setupConsoleManager(t)
+	session, err := manager.CreateSession(env, node, "alice")
+	require.NoError(t, err)
+	other, err := manager.CreateSession(env, node, "bob")
+	require.NoError(t, err)
+	command, _, err := manager.SubmitCommand(session.ID, "ps")
+	require.NoError(t, err)
+
+	broker := console.NewBroker(manager)
+	notices, unsubscribe := broker.Subscribe(session.ID)
+	defer unsubscribe()
+	otherNotices, unsubscribeOther := broker.Subscribe(other.ID)
+	defer unsubscribeOther()
+
+	broker.QueryWritten(command.DistributedQueryName, node.UUID)
+	// Signals are coalesced
+	broker.Publish(context.Background(), console.StreamNotice{SessionID: session.ID})
+	select {
+	case <-notices:
+	case <-time.After(time.Second):
+		t.Fatal("notice was not delivered")
+	}
+	select {
+	case <-notices:
+		t.Fatal("notices were not coalesced")
+	case <-otherNotices:
+		t.Fatal("notice delivered to another session")
+	default:
+	}
+
+	// Queries that are not console commands reach nobody
+	broker.QueryWritten("not-a-console-query", node.UUID)
+	select {
+	case <-notices:
+		t.Fatal("unexpected notice")
+	default:
+	}
+}
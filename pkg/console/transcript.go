@@ -0,0 +1,510 @@
+package console
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// TranscriptVersion is the version of the transcript document
+	TranscriptVersion = 1
+	// TranscriptAlgorithm is the only supported signature algorithm
+	TranscriptAlgorithm = "ed25519"
+	// castWidth and castHeight are the terminal size announced in casts
+	castWidth  = 160
+	castHeight = 48
+)
+
+// Transcript is the complete record of a console session: every command
+// with its translated SQL, timestamps, status and the rows each node sent.
+type Transcript struct {
+	Version     int               `json:"version"`
+	Environment string            `json:"environment"`
+	Session     Session           `json:"session"`
+	Nodes       []SessionNode     `json:"nodes"`
+	ExportedBy  string            `json:"exported_by"`
+	ExportedAt  time.Time         `json:"exported_at"`
+	Entries     []TranscriptEntry `json:"entries"`
+}
+
+// TranscriptEntry is one command of a transcript
+type TranscriptEntry struct {
+	Command Command       `json:"command"`
+	Nodes   []NodeResults `json:"nodes"`
+}
+
+// SignedTranscript wraps the exact transcript bytes that were signed, so
+// verification does not depend on how the JSON is re-encoded.
+type SignedTranscript struct {
+	Transcript json.RawMessage `json:"transcript"`
+	Algorithm  string          `json:"algorithm"`
+	KeyID      string          `json:"key_id"`
+	PublicKey  string          `json:"public_key"`
+	Signature  string          `json:"signature"`
+}
+
+// CastSignature is stored in the `osctrl` field of the cast header and
+// signs the header without it, as re-encoded by castSignedData, and every
+// line after the header.
+type CastSignature struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id"`
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+// CastHeader is the first line of an asciinema v2 cast file
+type CastHeader struct {
+	Version   int            `json:"version"`
+	Width     int            `json:"width"`
+	Height    int            `json:"height"`
+	Timestamp int64          `json:"timestamp"`
+	Title     string         `json:"title"`
+	Osctrl    *CastSignature `json:"osctrl,omitempty"`
+}
+
+// CastEvent is an output event of a cast, written as `[time, "o", data]`
+type CastEvent struct {
+	Time float64
+	Data string
+}
+
+// MarshalJSON encodes the event as the asciinema array form
+func (e CastEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{e.Time, "o", e.Data})
+}
+
+// UnmarshalJSON decodes the asciinema array form, ignoring input events
+func (e *CastEvent) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw) != 3 {
+		return fmt.Errorf("cast event must have 3 elements")
+	}
+	if err := json.Unmarshal(raw[0], &e.Time); err != nil {
+		return err
+	}
+	return json.Unmarshal(raw[2], &e.Data)
+}
+
+// Transcript builds the transcript of a session with all its commands, in
+// the order they were sent.
+func (m *Manager) Transcript(session Session, environment, exportedBy string) (Transcript, error) {
+	members, err := m.SessionNodes(session)
+	if err != nil {
+		return Transcript{}, err
+	}
+	var commands []Command
+	if err := m.DB.Where("session_id = ?", session.ID).Order("id").Find(&commands).Error; err != nil {
+		return Transcript{}, err
+	}
+	entries := make([]TranscriptEntry, 0, len(commands))
+	for _, command := range commands {
+		nodes, err := m.CommandNodeResults(command)
+		if err != nil {
+			return Transcript{}, err
+		}
+		entries = append(entries, TranscriptEntry{Command: command, Nodes: nodes})
+	}
+	return Transcript{
+		Version:     TranscriptVersion,
+		Environment: environment,
+		Session:     session,
+		Nodes:       members,
+		ExportedBy:  exportedBy,
+		ExportedAt:  time.Now().UTC(),
+		Entries:     entries,
+	}, nil
+}
+
+// TranscriptSigner signs transcripts with the server ed25519 key
+type TranscriptSigner struct {
+	key ed25519.PrivateKey
+}
+
+// NewTranscriptSigner creates a signer for the private key
+func NewTranscriptSigner(key ed25519.PrivateKey) *TranscriptSigner {
+	return &TranscriptSigner{key: key}
+}
+
+// LoadTranscriptSigner reads a PEM encoded PKCS #8 ed25519 private key, as
+// generated by `openssl genpkey -algorithm ed25519`.
+func LoadTranscriptSigner(path string) (*TranscriptSigner, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading transcript key - %w", err)
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("transcript key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing transcript key - %w", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("transcript key must be an ed25519 key")
+	}
+	return NewTranscriptSigner(key), nil
+}
+
+// PublicKey returns the public half of the signing key
+func (s *TranscriptSigner) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// KeyID identifies the signing key by the SHA-256 of its public key
+func (s *TranscriptSigner) KeyID() string {
+	return transcriptKeyID(s.PublicKey())
+}
+
+// Sign encodes and signs a transcript
+func (s *TranscriptSigner) Sign(t Transcript) (SignedTranscript, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return SignedTranscript{}, err
+	}
+	return SignedTranscript{
+		Transcript: data,
+		Algorithm:  TranscriptAlgorithm,
+		KeyID:      s.KeyID(),
+		PublicKey:  base64.StdEncoding.EncodeToString(s.PublicKey()),
+		Signature:  base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, data)),
+	}, nil
+}
+
+// SignCast renders a transcript as an asciinema v2 cast, with the signature
+// of the header and the events in the header.
+func (s *TranscriptSigner) SignCast(t Transcript) ([]byte, error) {
+	var events bytes.Buffer
+	for _, event := range CastEvents(t) {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		events.Write(line)
+		events.WriteByte('\n')
+	}
+	header := CastHeader{
+		Version:   2,
+		Width:     castWidth,
+		Height:    castHeight,
+		Timestamp: t.Session.CreatedAt.Unix(),
+		Title:     transcriptTitle(t),
+	}
+	data, err := castSignedData(header, events.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	header.Osctrl = &CastSignature{
+		Algorithm: TranscriptAlgorithm,
+		KeyID:     s.KeyID(),
+		PublicKey: base64.StdEncoding.EncodeToString(s.PublicKey()),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, data)),
+	}
+	headerLine, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(headerLine, '\n'), events.Bytes()...), nil
+}
+
+// castSignedData is what the signature of a cast covers: the header encoded
+// without the signature, so edits to any header field are detected, and the
+// event lines as written.
+func castSignedData(header CastHeader, body []byte) ([]byte, error) {
+	header.Osctrl = nil
+	headerLine, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(headerLine, '\n'), body...), nil
+}
+
+// VerifyTranscript checks the signature of a transcript and decodes it. With
+// a nil trusted key the public key embedded in the document is used, which
+// only proves the transcript was not modified after signing, not who signed.
+func VerifyTranscript(signed SignedTranscript, trusted ed25519.PublicKey) (Transcript, error) {
+	if err := verifySignature(signed.Algorithm, signed.KeyID, signed.PublicKey, signed.Signature, signed.Transcript, trusted); err != nil {
+		return Transcript{}, err
+	}
+	var t Transcript
+	if err := json.Unmarshal(signed.Transcript, &t); err != nil {
+		return Transcript{}, fmt.Errorf("decoding transcript - %w", err)
+	}
+	return t, nil
+}
+
+// VerifyCast checks the signature of a cast file and returns its events
+func VerifyCast(data []byte, trusted ed25519.PublicKey) (CastHeader, []CastEvent, error) {
+	headerLine, body, _ := bytes.Cut(data, []byte("\n"))
+	var header CastHeader
+	if err := json.Unmarshal(headerLine, &header); err != nil {
+		return CastHeader{}, nil, fmt.Errorf("decoding cast header - %w", err)
+	}
+	if header.Osctrl == nil {
+		return CastHeader{}, nil, fmt.Errorf("cast is not signed")
+	}
+	signedData, err := castSignedData(header, body)
+	if err != nil {
+		return CastHeader{}, nil, err
+	}
+	if err := verifySignature(header.Osctrl.Algorithm, header.Osctrl.KeyID, header.Osctrl.PublicKey, header.Osctrl.Signature, signedData, trusted); err != nil {
+		return CastHeader{}, nil, err
+	}
+	var events []CastEvent
+	for _, line := range bytes.Split(bytes.TrimSpace(body), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var event CastEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return CastHeader{}, nil, fmt.Errorf("decoding cast event - %w", err)
+		}
+		events = append(events, event)
+	}
+	return header, events, nil
+}
+
+// ParseTranscriptPublicKey reads a PEM encoded PKIX ed25519 public key, as
+// generated by `openssl pkey -pubout`.
+func ParseTranscriptPublicKey(raw []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("public key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key - %w", err)
+	}
+	key, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key must be an ed25519 key")
+	}
+	return key, nil
+}
+
+// CastEvents renders a transcript as terminal output: a prompt with the
+// input when each command was sent, and its results, per node for group
+// sessions, when it finished.
+func CastEvents(t Transcript) []CastEvent {
+	start := t.Session.CreatedAt
+	prompt := t.Session.Creator + "@" + transcriptTarget(t) + "$ "
+	events := []CastEvent{}
+	last := 0.0
+	add := func(at time.Time, data string) {
+		offset := at.Sub(start).Seconds()
+		if offset < last {
+			offset = last
+		}
+		last = offset
+		events = append(events, CastEvent{Time: offset, Data: strings.ReplaceAll(data, "\n", "\r\n")})
+	}
+	for _, entry := range t.Entries {
+		command := entry.Command
+		add(command.CreatedAt, prompt+command.Input+"\n")
+		finished := command.CreatedAt
+		for _, at := range []*time.Time{command.CompletedAt, command.ExpiredAt} {
+			if at != nil {
+				finished = *at
+			}
+		}
+		add(finished, renderTranscriptEntry(entry, t.Session.Group))
+	}
+	return events
+}
+
+func renderTranscriptEntry(entry TranscriptEntry, group bool) string {
+	var out strings.Builder
+	if entry.Command.Status != StatusCompleted {
+		fmt.Fprintf(&out, "[%s]", entry.Command.Status)
+		if entry.Command.Error != "" {
+			fmt.Fprintf(&out, " %s", SanitizeTerminal(entry.Command.Error))
+		}
+		out.WriteString("\n")
+	}
+	for _, node := range entry.Nodes {
+		if group {
+			name := node.Hostname
+			if name == "" {
+				name = node.NodeUUID
+			}
+			fmt.Fprintf(&out, "== %s (%s) ==\n", SanitizeTerminal(name), node.Status)
+		}
+		out.WriteString(renderRows(node.Results))
+	}
+	return out.String()
+}
+
+func renderRows(rows []map[string]any) string {
+	if len(rows) == 0 {
+		return ""
+	}
+	columns := []string{}
+	seen := map[string]bool{}
+	for _, row := range rows {
+		for column := range row {
+			if !seen[column] {
+				seen[column] = true
+				columns = append(columns, column)
+			}
+		}
+	}
+	sort.Strings(columns)
+	header := make([]string, 0, len(columns))
+	for _, column := range columns {
+		header = append(header, sanitizeCell(column))
+	}
+	var out bytes.Buffer
+	w := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		values := make([]string, 0, len(columns))
+		for _, column := range columns {
+			value := ""
+			if v, ok := row[column]; ok && v != nil {
+				value = sanitizeCell(fmt.Sprint(v))
+			}
+			values = append(values, value)
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+	w.Flush()
+	return out.String()
+}
+
+// sanitizeCell makes a value sent by a node safe for a table cell, without
+// control characters nor line breaks to spoof other rows.
+func sanitizeCell(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return ' '
+		}
+		return r
+	}, SanitizeTerminal(value))
+}
+
+// SanitizeTerminal removes escape sequences and C0 and C1 control characters
+// from output sent by nodes before it is written to a terminal, so query
+// results can not move the cursor, rewrite what the operator sees or send
+// commands to the terminal. Line feeds and tabs are kept, and carriage
+// returns only when they end a line.
+func SanitizeTerminal(data string) string {
+	var out strings.Builder
+	out.Grow(len(data))
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRuneInString(data[i:])
+		switch {
+		case r == 0x1b:
+			i += escapeSequenceLength(data[i:])
+			continue
+		case r == '\r':
+			if strings.HasPrefix(data[i+size:], "\n") {
+				out.WriteRune(r)
+			}
+		case r == '\n' || r == '\t':
+			out.WriteRune(r)
+		case r < 0x20 || r == 0x7f || (r >= 0x80 && r <= 0x9f):
+		case r == utf8.RuneError && size == 1:
+		default:
+			out.WriteRune(r)
+		}
+		i += size
+	}
+	return out.String()
+}
+
+// escapeSequenceLength returns the length of the escape sequence starting
+// with the ESC at the beginning of data: CSI sequences up to their final
+// byte, OSC, DCS, SOS, PM and APC strings up to their terminator and two
+// bytes for any other escape.
+func escapeSequenceLength(data string) int {
+	if len(data) < 2 {
+		return len(data)
+	}
+	switch data[1] {
+	case '[':
+		for i := 2; i < len(data); i++ {
+			if data[i] >= 0x40 && data[i] <= 0x7e {
+				return i + 1
+			}
+		}
+		return len(data)
+	case ']', 'P', 'X', '^', '_':
+		for i := 2; i < len(data); i++ {
+			if data[i] == 0x07 {
+				return i + 1
+			}
+			if data[i] == 0x1b && i+1 < len(data) && data[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return len(data)
+	}
+	return 2
+}
+
+func transcriptTarget(t Transcript) string {
+	if t.Session.Group {
+		if t.Session.Label != "" {
+			return t.Session.Label
+		}
+		return fmt.Sprintf("%d-nodes", len(t.Nodes))
+	}
+	for _, node := range t.Nodes {
+		if node.Hostname != "" {
+			return node.Hostname
+		}
+	}
+	return t.Session.NodeUUID
+}
+
+func transcriptTitle(t Transcript) string {
+	return fmt.Sprintf("osctrl console session %d on %s (%s)", t.Session.ID, transcriptTarget(t), t.Environment)
+}
+
+func transcriptKeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
+
+func verifySignature(algorithm, keyID, publicKey, signature string, data []byte, trusted ed25519.PublicKey) error {
+	if algorithm != TranscriptAlgorithm {
+		return fmt.Errorf("unsupported signature algorithm %q", algorithm)
+	}
+	key := trusted
+	if key == nil {
+		embedded, err := base64.StdEncoding.DecodeString(publicKey)
+		if err != nil || len(embedded) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid public key")
+		}
+		key = embedded
+	}
+	if keyID != transcriptKeyID(key) {
+		return fmt.Errorf("transcript was not signed with key %s", transcriptKeyID(key))
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding")
+	}
+	if !ed25519.Verify(key, data, sig) {
+		return fmt.Errorf("signature verification failed")
+	}
+	return nil
+}
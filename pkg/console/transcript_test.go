@@ -0,0 +1,105 @@
+package console_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/console"
+	"github.com/jmpsec/osctrl/pkg/logging"
+	"github.com/jmpsec/osctrl/pkg/queries"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranscriptSignAndVerify(t *testing.T) {
+	db, manager, env, node := setupConsoleManager(t)
+	session, err := manager.CreateSession(env, node, "alice")
+	require.NoError(t, err)
+	_, _, err = manager.SubmitCommand(session.ID, "pwd")
+	require.NoError(t, err)
+	command, _, err := manager.SubmitCommand(session.ID, "ps")
+	require.NoError(t, err)
+	data, err := json.Marshal([]map[string]string{{"pid": "1", "name": "init"}})
+	require.NoError(t, err)
+	require.NoError(t, db.Create(&logging.OsqueryQueryData{UUID: node.UUID, Name: command.DistributedQueryName, Data: string(data)}).Error)
+	require.NoError(t, markNodeQueryStatus(db, command.DistributedQueryName, queries.DistributedQueryStatusCompleted))
+	_, err = manager.RefreshCommandStatus(command.ID)
+	require.NoError(t, err)
+
+	transcript, err := manager.Transcript(session, env.Name, "bob")
+	require.NoError(t, err)
+	require.Equal(t, "bob", transcript.ExportedBy)
+	require.Len(t, transcript.Entries, 2)
+	require.Equal(t, "pwd", transcript.Entries[0].Command.Input)
+	require.Equal(t, command.TranslatedSQL, transcript.Entries[1].Command.TranslatedSQL)
+	require.Equal(t, []map[string]any{{"pid": "1", "name": "init"}}, transcript.Entries[1].Nodes[0].Results)
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer := console.NewTranscriptSigner(private)
+	signed, err := signer.Sign(transcript)
+	require.NoError(t, err)
+	verified, err := console.VerifyTranscript(signed, public)
+	require.NoError(t, err)
+	require.Len(t, verified.Entries, 2)
+
+	tampered := signed
+	tampered.Transcript = json.RawMessage(strings.Replace(string(signed.Transcript), `"init"`, `"sshd"`, 1))
+	_, err = console.VerifyTranscript(tampered, nil)
+	require.Error(t, err)
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, err = console.VerifyTranscript(signed, other)
+	require.Error(t, err)
+
+	cast, err := signer.SignCast(transcript)
+	require.NoError(t, err)
+	header, events, err := console.VerifyCast(cast, public)
+	require.NoError(t, err)
+	require.Equal(t, 2, header.Version)
+	require.Len(t, events, 4)
+	require.Contains(t, events[2].Data, "alice@"+node.UUID+"$ ps")
+	require.Contains(t, events[3].Data, "init")
+	_, _, err = console.VerifyCast([]byte(strings.Replace(string(cast), "init", "sshd", 1)), nil)
+	require.Error(t, err)
+}
+
+func TestVerifyCastCoversHeader(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer := console.NewTranscriptSigner(private)
+	transcript := console.Transcript{
+		Version:     console.TranscriptVersion,
+		Environment: "prod",
+		Session:     console.Session{ID: 3, Creator: "alice", NodeUUID: "NODE-UUID", CreatedAt: time.Now()},
+	}
+	cast, err := signer.SignCast(transcript)
+	require.NoError(t, err)
+	header, _, err := console.VerifyCast(cast, public)
+	require.NoError(t, err)
+	require.Contains(t, header.Title, "(prod)")
+	_, _, err = console.VerifyCast([]byte(strings.Replace(string(cast), "(prod)", "(dev)", 1)), public)
+	require.Error(t, err)
+	_, _, err = console.VerifyCast([]byte(strings.Replace(string(cast), `"width":160`, `"width":80`, 1)), public)
+	require.Error(t, err)
+}
+
+func TestSanitizeTerminal(t *testing.T) {
+	require.Equal(t, "red text\r\nnext\tcol", console.SanitizeTerminal("\x1b[31mred\x1b[0m text\r\nnext\tcol"))
+	require.Equal(t, "title", console.SanitizeTerminal("\x1b]0;owned\x07title"))
+	require.Equal(t, "ab", console.SanitizeTerminal("a\rb"))
+	require.Equal(t, "bell2J", console.SanitizeTerminal("be\x07ll\u009b2J"))
+	require.Equal(t, "clipboard", console.SanitizeTerminal("\x1b]52;c;cGF5bG9hZA==\x1b\\clipboard"))
+	events := console.CastEvents(console.Transcript{
+		Session: console.Session{Creator: "alice", NodeUUID: "NODE-UUID"},
+		Entries: []console.TranscriptEntry{{
+			Command: console.Command{Input: "ps", Status: console.StatusCompleted},
+			Nodes:   []console.NodeResults{{Results: []map[string]any{{"name": "\x1b[2Jinit\nfake row"}}}},
+		}},
+	})
+	require.NotContains(t, events[1].Data, "\x1b")
+	require.Contains(t, events[1].Data, "init fake row")
+}
@@ -0,0 +1,151 @@
+package console
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// FindDefaultDepth is how deep `find` walks when -depth is not given
+	FindDefaultDepth = 3
+	// FindMaxDepth caps -depth, since osquery walks the whole tree to
+	// answer recursive file queries
+	FindMaxDepth = 10
+	// HeadMaxSize is the largest file `head` carves, in bytes
+	HeadMaxSize int64 = 1 << 20
+)
+
+// parseTriage handles the investigator verbs that map to a single osquery
+// table, picking the table and columns for the platform of the session.
+func parseTriage(cmd, args, cwd, platform string) (ParsedCommand, error) {
+	if forbiddenShellSyntax.MatchString(args) {
+		return ParsedCommand{}, fmt.Errorf("shell syntax is not supported")
+	}
+	windows := strings.EqualFold(platform, "windows")
+	switch cmd {
+	case "hash":
+		if args == "" {
+			return ParsedCommand{}, fmt.Errorf("hash requires a path")
+		}
+		target := resolvePath(args, cwd, platform)
+		sql := fmt.Sprintf("select path, md5, sha1, sha256 from hash where path = %s", quoteSQL(target))
+		return ParsedCommand{Kind: CommandRemote, Command: "hash", Path: target, SQL: sql}, nil
+	case "find":
+		return parseFind(args, cwd, platform)
+	case "netstat":
+		switch args {
+		case "":
+			return ParsedCommand{Kind: CommandRemote, Command: "netstat", SQL: "select s.pid, p.name, s.protocol, s.local_address, s.local_port, s.remote_address, s.remote_port, s.state from process_open_sockets s left join processes p on p.pid = s.pid where s.protocol in (6, 17) order by s.pid"}, nil
+		case "-l":
+			return ParsedCommand{Kind: CommandRemote, Command: "netstat", SQL: "select l.pid, p.name, l.protocol, l.address, l.port from listening_ports l left join processes p on p.pid = l.pid where l.port <> 0 order by l.port"}, nil
+		}
+		return ParsedCommand{}, fmt.Errorf("netstat only accepts -l")
+	case "users":
+		if args != "" {
+			return ParsedCommand{}, fmt.Errorf("users does not accept arguments")
+		}
+		sql := "select uid, gid, username, description, directory, shell from users order by uid"
+		if windows {
+			sql = "select uid, gid, username, description, directory, type from users order by username"
+		}
+		return ParsedCommand{Kind: CommandRemote, Command: "users", SQL: sql}, nil
+	case "who":
+		if args != "" {
+			return ParsedCommand{}, fmt.Errorf("who does not accept arguments")
+		}
+		return ParsedCommand{Kind: CommandRemote, Command: "who", SQL: "select user, tty, host, time, pid, type from logged_in_users order by time desc"}, nil
+	case "last":
+		if args != "" {
+			return ParsedCommand{}, fmt.Errorf("last does not accept arguments")
+		}
+		// The last table reads wtmp, which Windows does not have
+		sql := "select username, tty, pid, type, time, host from last order by time desc"
+		if windows {
+			sql = "select user, logon_domain, logon_type, authentication_package, logon_time, logon_server from logon_sessions order by logon_time desc"
+		}
+		return ParsedCommand{Kind: CommandRemote, Command: "last", SQL: sql}, nil
+	case "startup":
+		if args != "" {
+			return ParsedCommand{}, fmt.Errorf("startup does not accept arguments")
+		}
+		sql := "select name, path, args, type, source, status, username from startup_items order by name"
+		switch {
+		case windows:
+			sql = "select name, display_name, status, start_type, path, user_account from services where start_type in ('AUTO_START', 'BOOT_START', 'SYSTEM_START') order by name"
+		case strings.EqualFold(platform, "darwin"):
+			sql = "select label, path, program, program_arguments, run_at_load, keep_alive from launchd where run_at_load = '1' or keep_alive = '1' order by label"
+		}
+		return ParsedCommand{Kind: CommandRemote, Command: "startup", SQL: sql}, nil
+	case "head":
+		if args == "" {
+			return ParsedCommand{}, fmt.Errorf("head requires a path")
+		}
+		// osquery can not read file contents, so head carves the file
+		// when it is small enough
+		target := resolvePath(args, cwd, platform)
+		return ParsedCommand{Kind: CommandCarve, Command: "head", Path: target}, nil
+	}
+	return ParsedCommand{}, fmt.Errorf("unsupported command %q", cmd)
+}
+
+// parseFind handles `find [dir] [-name GLOB] [-depth N]`. The directory
+// defaults to the working directory and the glob is matched against file
+// names, or against full paths when it contains a separator.
+func parseFind(args, cwd, platform string) (ParsedCommand, error) {
+	fields := strings.Fields(args)
+	depth := FindDefaultDepth
+	name := ""
+	dir := []string{}
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "-name", "-depth":
+			if i+1 >= len(fields) {
+				return ParsedCommand{}, fmt.Errorf("find option %s requires a value", fields[i])
+			}
+			i++
+			if fields[i-1] == "-name" {
+				name = fields[i]
+				continue
+			}
+			n, err := strconv.Atoi(fields[i])
+			if err != nil || n < 1 || n > FindMaxDepth {
+				return ParsedCommand{}, fmt.Errorf("find -depth must be between 1 and %d", FindMaxDepth)
+			}
+			depth = n
+		default:
+			if strings.HasPrefix(fields[i], "-") {
+				return ParsedCommand{}, fmt.Errorf("unsupported find option %q", fields[i])
+			}
+			dir = append(dir, fields[i])
+		}
+	}
+	target := resolvePath(strings.Join(dir, " "), cwd, platform)
+	sep := "/"
+	if strings.EqualFold(platform, "windows") {
+		sep = `\`
+	}
+	// `/` becomes empty and `C:\` becomes `C:`, so depth is counted in
+	// separators past the directory
+	base := strings.TrimSuffix(target, sep)
+	where := []string{
+		"path like " + quoteSQL(escapeLike(base+sep)+"%%") + ` escape '\'`,
+		fmt.Sprintf("(length(path) - length(replace(path, %s, ''))) <= %d", quoteSQL(sep), strings.Count(base, sep)+depth),
+	}
+	if name != "" {
+		column := "filename"
+		if strings.Contains(name, sep) {
+			column = "path"
+		}
+		pattern := strings.NewReplacer("*", "%", "?", "_").Replace(escapeLike(name))
+		where = append(where, column+" like "+quoteSQL(pattern)+` escape '\'`)
+	}
+	sql := "select path, filename, type, size, mode, uid, gid, mtime from file where " + strings.Join(where, " and ") + " order by path"
+	return ParsedCommand{Kind: CommandRemote, Command: "find", Path: target, SQL: sql}, nil
+}
+
+// escapeLike escapes the LIKE wildcards of s, for patterns used with
+// `escape '\'`.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,83 @@
+package environments
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/cache"
+)
+
+const (
+	clientCAsCacheName   = "client-cas"
+	clientCertsCacheName = "client-certs"
+	// clientCertsCacheTTL is how long the client CA of an environment and the
+	// issued certificates are kept before they are read again from the
+	// database, which bounds how long osctrl-tls takes to reject a revoked
+	// certificate
+	clientCertsCacheTTL = 30 * time.Second
+)
+
+// ClientCertCache verifies the client certificates presented by nodes,
+// caching the client CA of every environment and the issued certificates
+type ClientCertCache struct {
+	pools *cache.MemoryCache[*x509.CertPool]
+	certs *cache.MemoryCache[ClientCertificate]
+	envs  EnvManager
+}
+
+// NewClientCertCache creates a new client certificate cache
+func NewClientCertCache(envs EnvManager) *ClientCertCache {
+	return &ClientCertCache{
+		pools: cache.NewMemoryCache(
+			cache.WithCleanupInterval[*x509.CertPool](clientCertsCacheTTL),
+			cache.WithName[*x509.CertPool](clientCAsCacheName),
+		),
+		certs: cache.NewMemoryCache(
+			cache.WithCleanupInterval[ClientCertificate](clientCertsCacheTTL),
+			cache.WithName[ClientCertificate](clientCertsCacheName),
+		),
+		envs: envs,
+	}
+}
+
+// Verify is VerifyClientCert using cache when available. Environments
+// without a client CA and unknown certificates are not cached, so a CA or a
+// certificate created meanwhile is used right away.
+func (cc *ClientCertCache) Verify(ctx context.Context, envID uint, cert *x509.Certificate) (ClientCertificate, error) {
+	if cert == nil {
+		return ClientCertificate{}, ErrClientCertMissing
+	}
+	key := fmt.Sprint(envID)
+	roots, found := cc.pools.Get(ctx, key)
+	if !found {
+		var err error
+		if roots, err = cc.envs.clientCAPool(envID); err != nil {
+			return ClientCertificate{}, err
+		}
+		cc.pools.Set(ctx, key, roots, clientCertsCacheTTL)
+	}
+	if err := verifyClientCertChain(cert, roots); err != nil {
+		return ClientCertificate{}, err
+	}
+	serial := ClientCertSerial(cert)
+	record, found := cc.certs.Get(ctx, key+":"+serial)
+	if !found {
+		var err error
+		if record, err = cc.envs.clientCertRecord(envID, serial); err != nil {
+			return record, err
+		}
+		cc.certs.Set(ctx, key+":"+serial, record, clientCertsCacheTTL)
+	}
+	if record.Revoked() {
+		return record, ErrClientCertRevoked
+	}
+	return record, nil
+}
+
+// Invalidate removes a cached certificate of an environment
+func (cc *ClientCertCache) Invalidate(ctx context.Context, envID uint, serial string) {
+	cc.certs.Delete(ctx, fmt.Sprint(envID)+":"+strings.ToLower(serial))
+}
@@ -0,0 +1,403 @@
+package environments
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultClientCertValidity is the validity of client certificates issued without one
+	DefaultClientCertValidity = 365 * 24 * time.Hour
+	// ClientCAValidity is the validity of the client CA of an environment
+	ClientCAValidity = 10 * 365 * 24 * time.Hour
+	// ClientCRLValidity is how long a generated CRL is valid, clients must fetch it again before
+	ClientCRLValidity = 24 * time.Hour
+)
+
+var (
+	// ErrClientCANotFound is returned when the environment has no client CA
+	ErrClientCANotFound = errors.New("environment has no client CA")
+	// ErrClientCertMissing is returned when no client certificate was presented
+	ErrClientCertMissing = errors.New("missing client certificate")
+	// ErrClientCertUntrusted is returned when the certificate is not issued by the client CA of the environment
+	ErrClientCertUntrusted = errors.New("untrusted client certificate")
+	// ErrClientCertUnknown is returned when a certificate signed by the client CA is not in the database
+	ErrClientCertUnknown = errors.New("unknown client certificate")
+	// ErrClientCertRevoked is returned when the certificate was revoked
+	ErrClientCertRevoked = errors.New("revoked client certificate")
+	// ErrClientCertScript is returned for quick-add scripts of environments requiring client certificates
+	ErrClientCertScript = errors.New("quick-add scripts can not install client certificates, enroll nodes with the certificate issued for each one")
+)
+
+// ClientCA is the certificate authority issuing the client certificates of
+// an environment. Its key never leaves the database, where it is stored in
+// the clear like the enroll secret and the certificate of the environment:
+// the database already holds what is needed to enroll nodes, so it must be
+// protected as the secret it is. osctrl-tls only reads the certificate.
+type ClientCA struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	EnvironmentID uint      `gorm:"uniqueIndex" json:"environment_id"`
+	Certificate   string    `json:"certificate"`
+	Key           string    `json:"-"`
+}
+
+// ClientCertificate is a client certificate issued to a node of an
+// environment. Only the certificate metadata is stored, the key is handed
+// to the requester once.
+type ClientCertificate struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	EnvironmentID uint      `gorm:"uniqueIndex:idx_client_cert_serial" json:"environment_id"`
+	Serial        string    `gorm:"uniqueIndex:idx_client_cert_serial" json:"serial"`
+	// CommonName is the host identifier of the node using the certificate
+	CommonName  string     `gorm:"index" json:"common_name"`
+	Fingerprint string     `json:"fingerprint"`
+	NotAfter    time.Time  `json:"not_after"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	RevokedBy   string     `json:"revoked_by"`
+	Reason      string     `json:"reason"`
+	CreatedBy   string     `json:"created_by"`
+}
+
+// Revoked returns true if the certificate was revoked
+func (c ClientCertificate) Revoked() bool {
+	return c.RevokedAt != nil
+}
+
+// ClientCertSerial returns the serial of a certificate as stored
+func ClientCertSerial(cert *x509.Certificate) string {
+	return hex.EncodeToString(cert.SerialNumber.Bytes())
+}
+
+func clientCertFingerprint(der []byte) string {
+	h := sha256.Sum256(der)
+	return hex.EncodeToString(h[:])
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func encodeKey(key *ecdsa.PrivateKey) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", fmt.Errorf("MarshalPKCS8PrivateKey %w", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// parseCertificate returns the certificate of the CA
+func (ca ClientCA) parseCertificate() (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(ca.Certificate))
+	if block == nil {
+		return nil, fmt.Errorf("invalid client CA certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("ParseCertificate %w", err)
+	}
+	return cert, nil
+}
+
+// parse returns the certificate and the key of the CA
+func (ca ClientCA) parse() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	cert, err := ca.parseCertificate()
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode([]byte(ca.Key))
+	if block == nil {
+		return nil, nil, fmt.Errorf("invalid client CA key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ParsePKCS8PrivateKey %w", err)
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid client CA key type")
+	}
+	return cert, key, nil
+}
+
+// GetClientCA to get the client CA of an environment
+func (environment *EnvManager) GetClientCA(envID uint) (ClientCA, error) {
+	var ca ClientCA
+	err := environment.DB.Where("environment_id = ?", envID).First(&ca).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ca, ErrClientCANotFound
+	}
+	return ca, err
+}
+
+// clientCA to get the client CA of an environment, creating it if needed
+func (environment *EnvManager) clientCA(env TLSEnvironment) (ClientCA, error) {
+	ca, err := environment.GetClientCA(env.ID)
+	if !errors.Is(err, ErrClientCANotFound) {
+		return ca, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return ca, fmt.Errorf("GenerateKey %w", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return ca, fmt.Errorf("randomSerial %w", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "osctrl client CA " + env.Name, Organization: []string{"osctrl"}, OrganizationalUnit: []string{env.UUID}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(ClientCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return ca, fmt.Errorf("CreateCertificate %w", err)
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return ca, err
+	}
+	ca = ClientCA{
+		EnvironmentID: env.ID,
+		Certificate:   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		Key:           keyPEM,
+	}
+	if err := environment.DB.Create(&ca).Error; err != nil {
+		return ClientCA{}, fmt.Errorf("Create %w", err)
+	}
+	return ca, nil
+}
+
+// IssueClientCert to issue a client certificate for the node of an
+// environment with the host identifier in commonName, creating the client CA
+// of the environment if needed. It returns the PEM certificate and key, the
+// key is not stored and can not be retrieved later.
+func (environment *EnvManager) IssueClientCert(env TLSEnvironment, commonName string, validity time.Duration, user string) (ClientCertificate, string, string, error) {
+	commonName = strings.TrimSpace(commonName)
+	if commonName == "" {
+		return ClientCertificate{}, "", "", fmt.Errorf("empty common name")
+	}
+	if validity < 0 {
+		return ClientCertificate{}, "", "", fmt.Errorf("invalid validity %s", validity)
+	}
+	if validity == 0 {
+		validity = DefaultClientCertValidity
+	}
+	ca, err := environment.clientCA(env)
+	if err != nil {
+		return ClientCertificate{}, "", "", err
+	}
+	caCert, caKey, err := ca.parse()
+	if err != nil {
+		return ClientCertificate{}, "", "", err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return ClientCertificate{}, "", "", fmt.Errorf("GenerateKey %w", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return ClientCertificate{}, "", "", fmt.Errorf("randomSerial %w", err)
+	}
+	now := time.Now()
+	notAfter := now.Add(validity)
+	if notAfter.After(caCert.NotAfter) {
+		notAfter = caCert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"osctrl"}, OrganizationalUnit: []string{env.UUID}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return ClientCertificate{}, "", "", fmt.Errorf("CreateCertificate %w", err)
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return ClientCertificate{}, "", "", err
+	}
+	record := ClientCertificate{
+		EnvironmentID: env.ID,
+		Serial:        hex.EncodeToString(serial.Bytes()),
+		CommonName:    commonName,
+		Fingerprint:   clientCertFingerprint(der),
+		NotAfter:      notAfter,
+		CreatedBy:     user,
+	}
+	if err := environment.DB.Create(&record).Error; err != nil {
+		return ClientCertificate{}, "", "", fmt.Errorf("Create %w", err)
+	}
+	certPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	return record, certPEM, keyPEM, nil
+}
+
+// ClientCerts to get the client certificates of an environment, newest first
+func (environment *EnvManager) ClientCerts(envID uint) ([]ClientCertificate, error) {
+	var certs []ClientCertificate
+	if err := environment.DB.Where("environment_id = ?", envID).Order("created_at DESC, id DESC").Find(&certs).Error; err != nil {
+		return certs, fmt.Errorf("Find %w", err)
+	}
+	return certs, nil
+}
+
+// GetClientCert to get a client certificate of an environment by serial
+func (environment *EnvManager) GetClientCert(envID uint, serial string) (ClientCertificate, error) {
+	var cert ClientCertificate
+	if err := environment.DB.Where("environment_id = ? AND serial = ?", envID, strings.ToLower(serial)).First(&cert).Error; err != nil {
+		return cert, err
+	}
+	return cert, nil
+}
+
+// RevokeClientCert to revoke a client certificate of an environment, nodes
+// using it can not enroll or talk to osctrl anymore
+func (environment *EnvManager) RevokeClientCert(envID uint, serial, reason, user string) (ClientCertificate, error) {
+	cert, err := environment.GetClientCert(envID, serial)
+	if err != nil {
+		return cert, err
+	}
+	if cert.Revoked() {
+		return cert, ErrClientCertRevoked
+	}
+	now := time.Now()
+	updates := map[string]interface{}{"revoked_at": now, "revoked_by": user, "reason": reason}
+	if err := environment.DB.Model(&cert).Updates(updates).Error; err != nil {
+		return cert, fmt.Errorf("Updates %w", err)
+	}
+	cert.RevokedAt = &now
+	cert.RevokedBy = user
+	cert.Reason = reason
+	return cert, nil
+}
+
+// VerifyClientCert returns the client certificate of an environment matching
+// the certificate presented by a node, if it was issued by the client CA of
+// the environment, is still valid and was not revoked
+func (environment *EnvManager) VerifyClientCert(envID uint, cert *x509.Certificate) (ClientCertificate, error) {
+	if cert == nil {
+		return ClientCertificate{}, ErrClientCertMissing
+	}
+	roots, err := environment.clientCAPool(envID)
+	if err != nil {
+		return ClientCertificate{}, err
+	}
+	if err := verifyClientCertChain(cert, roots); err != nil {
+		return ClientCertificate{}, err
+	}
+	record, err := environment.clientCertRecord(envID, ClientCertSerial(cert))
+	if err != nil {
+		return record, err
+	}
+	if record.Revoked() {
+		return record, ErrClientCertRevoked
+	}
+	return record, nil
+}
+
+// clientCAPool returns the pool with the client CA of an environment, to
+// verify the certificates presented by its nodes
+func (environment *EnvManager) clientCAPool(envID uint) (*x509.CertPool, error) {
+	ca, err := environment.GetClientCA(envID)
+	if err != nil {
+		if errors.Is(err, ErrClientCANotFound) {
+			return nil, ErrClientCertUntrusted
+		}
+		return nil, err
+	}
+	caCert, err := ca.parseCertificate()
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	return roots, nil
+}
+
+// verifyClientCertChain checks a client certificate was issued by one of the roots
+func verifyClientCertChain(cert *x509.Certificate, roots *x509.CertPool) error {
+	opts := x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	if _, err := cert.Verify(opts); err != nil {
+		return fmt.Errorf("%w: %v", ErrClientCertUntrusted, err)
+	}
+	return nil
+}
+
+// clientCertRecord returns the client certificate of an environment issued
+// with a serial, ErrClientCertUnknown if there is none
+func (environment *EnvManager) clientCertRecord(envID uint, serial string) (ClientCertificate, error) {
+	record, err := environment.GetClientCert(envID, serial)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return record, ErrClientCertUnknown
+	}
+	return record, err
+}
+
+// ClientCRL to generate the PEM revocation list of the client CA of an
+// environment, with the revoked certificates that did not expire yet
+func (environment *EnvManager) ClientCRL(envID uint) (string, error) {
+	ca, err := environment.GetClientCA(envID)
+	if err != nil {
+		return "", err
+	}
+	caCert, caKey, err := ca.parse()
+	if err != nil {
+		return "", err
+	}
+	var revoked []ClientCertificate
+	now := time.Now()
+	if err := environment.DB.Where("environment_id = ? AND revoked_at IS NOT NULL AND not_after > ?", envID, now).Order("id").Find(&revoked).Error; err != nil {
+		return "", fmt.Errorf("Find %w", err)
+	}
+	entries := make([]x509.RevocationListEntry, 0, len(revoked))
+	for _, c := range revoked {
+		serial, ok := new(big.Int).SetString(c.Serial, 16)
+		if !ok {
+			return "", fmt.Errorf("invalid serial %q", c.Serial)
+		}
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: *c.RevokedAt})
+	}
+	template := &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		Number:                    big.NewInt(now.UnixNano()),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(ClientCRLValidity),
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, caCert, caKey)
+	if err != nil {
+		return "", fmt.Errorf("CreateRevocationList %w", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})), nil
+}
+
+// UpdateClientCertAuth to update if the nodes of an environment must present
+// a client certificate issued by its client CA
+func (environment *EnvManager) UpdateClientCertAuth(idEnv string, enabled bool) error {
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Update("client_cert_auth", enabled).Error; err != nil {
+		return fmt.Errorf("Update client cert auth %w", err)
+	}
+	return nil
+}
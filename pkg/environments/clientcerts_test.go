@@ -0,0 +1,160 @@
+package environments
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+func parseTestCert(t *testing.T, certPEM string) *x509.Certificate {
+	block, _ := pem.Decode([]byte(certPEM))
+	require.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestClientCertIssueVerifyRevoke(t *testing.T) {
+	db := setupTestDB(t)
+	envs := CreateEnvironment(db)
+	env := envs.Empty("dev", "osctrl.example.com")
+	require.NoError(t, envs.Create(&env))
+	other := envs.Empty("prod", "osctrl.example.com")
+	require.NoError(t, envs.Create(&other))
+
+	_, err := envs.GetClientCA(env.ID)
+	require.ErrorIs(t, err, ErrClientCANotFound)
+	_, _, _, err = envs.IssueClientCert(env, " ", 0, "alice")
+	require.Error(t, err)
+
+	record, certPEM, keyPEM, err := envs.IssueClientCert(env, " NODE-UUID ", 0, "alice")
+	require.NoError(t, err)
+	require.Equal(t, "NODE-UUID", record.CommonName)
+	require.Contains(t, keyPEM, "PRIVATE KEY")
+	cert := parseTestCert(t, certPEM)
+	require.Equal(t, record.Serial, ClientCertSerial(cert))
+	require.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, cert.ExtKeyUsage)
+	require.WithinDuration(t, time.Now().Add(DefaultClientCertValidity), record.NotAfter, time.Minute)
+
+	// The CA is created once per environment
+	ca, err := envs.GetClientCA(env.ID)
+	require.NoError(t, err)
+	second, _, _, err := envs.IssueClientCert(env, "OTHER-UUID", 24*time.Hour, "alice")
+	require.NoError(t, err)
+	again, err := envs.GetClientCA(env.ID)
+	require.NoError(t, err)
+	require.Equal(t, ca.Certificate, again.Certificate)
+
+	verified, err := envs.VerifyClientCert(env.ID, cert)
+	require.NoError(t, err)
+	require.Equal(t, record.ID, verified.ID)
+	_, err = envs.VerifyClientCert(env.ID, nil)
+	require.ErrorIs(t, err, ErrClientCertMissing)
+	// Certificates of other environments are not trusted
+	_, err = envs.VerifyClientCert(other.ID, cert)
+	require.ErrorIs(t, err, ErrClientCertUntrusted)
+	_, otherPEM, _, err := envs.IssueClientCert(other, "NODE-UUID", 0, "alice")
+	require.NoError(t, err)
+	_, err = envs.VerifyClientCert(env.ID, parseTestCert(t, otherPEM))
+	require.ErrorIs(t, err, ErrClientCertUntrusted)
+
+	revoked, err := envs.RevokeClientCert(env.ID, strings.ToUpper(record.Serial), "stolen", "bob")
+	require.NoError(t, err)
+	require.True(t, revoked.Revoked())
+	_, err = envs.RevokeClientCert(env.ID, record.Serial, "", "bob")
+	require.ErrorIs(t, err, ErrClientCertRevoked)
+	_, err = envs.VerifyClientCert(env.ID, cert)
+	require.ErrorIs(t, err, ErrClientCertRevoked)
+
+	certs, err := envs.ClientCerts(env.ID)
+	require.NoError(t, err)
+	require.Len(t, certs, 2)
+
+	crlPEM, err := envs.ClientCRL(env.ID)
+	require.NoError(t, err)
+	block, _ := pem.Decode([]byte(crlPEM))
+	require.NotNil(t, block)
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	require.NoError(t, err)
+	require.NoError(t, crl.CheckSignatureFrom(parseTestCert(t, ca.Certificate)))
+	require.Len(t, crl.RevokedCertificateEntries, 1)
+	require.Equal(t, cert.SerialNumber, crl.RevokedCertificateEntries[0].SerialNumber)
+	require.NotEqual(t, second.Serial, record.Serial)
+
+	// Deleting the environment deletes its CA and certificates
+	require.NoError(t, envs.Delete(env.Name))
+	_, err = envs.GetClientCA(env.ID)
+	require.ErrorIs(t, err, ErrClientCANotFound)
+	certs, err = envs.ClientCerts(env.ID)
+	require.NoError(t, err)
+	require.Empty(t, certs)
+}
+
+func TestGenerateFlagsClientCert(t *testing.T) {
+	envs := &EnvManager{}
+	env := TLSEnvironment{UUID: "env-uuid", Hostname: "osctrl.example.com"}
+	osqCfg := config.YAMLConfigurationOsquery{}
+	flags, err := envs.GenerateFlags(env, "", "", osqCfg)
+	require.NoError(t, err)
+	require.NotContains(t, flags, FlagNameTLSClientCert)
+
+	env.ClientCertAuth = true
+	flags, err = envs.GenerateFlags(env, "", "", osqCfg)
+	require.NoError(t, err)
+	require.Contains(t, flags, "--tls_client_cert="+EmptyFlagClientCert+"\n")
+	require.Contains(t, flags, "--tls_client_key="+EmptyFlagClientKey+"\n")
+}
+
+func TestClientCertFlagsAndScripts(t *testing.T) {
+	envs := &EnvManager{}
+	env := TLSEnvironment{UUID: "env-uuid", Name: "dev", Hostname: "osctrl.example.com", ClientCertAuth: true}
+	flags, err := envs.GenerateFlags(env, "", "", config.YAMLConfigurationOsquery{})
+	require.NoError(t, err)
+	flags = PlatformFlags(flags, env.Name, "/etc/osquery", "/")
+	require.Contains(t, flags, "--enroll_secret_path=/etc/osquery/osctrl-dev.secret\n")
+	require.Contains(t, flags, "--tls_client_cert=/etc/osquery/osctrl-dev.client.crt\n")
+	require.Contains(t, flags, "--tls_client_key=/etc/osquery/osctrl-dev.client.key\n")
+	require.NotContains(t, flags, "__")
+
+	// Quick-add scripts can not install the certificate of each node
+	_, err = QuickAddScript("osctrl-dev", EnrollShell, env)
+	require.ErrorIs(t, err, ErrClientCertScript)
+	_, err = QuickAddOneLinerPowershell(false, env)
+	require.ErrorIs(t, err, ErrClientCertScript)
+	_, err = QuickAddScript("osctrl-dev", RemoveShell, env)
+	require.NoError(t, err)
+}
+
+func TestClientCertCache(t *testing.T) {
+	db := setupTestDB(t)
+	envs := CreateEnvironment(db)
+	env := envs.Empty("dev", "osctrl.example.com")
+	require.NoError(t, envs.Create(&env))
+	certs := NewClientCertCache(*envs)
+	ctx := context.Background()
+
+	_, err := certs.Verify(ctx, env.ID, nil)
+	require.ErrorIs(t, err, ErrClientCertMissing)
+	// Environments without a CA are not cached, the first certificate issued is trusted
+	record, certPEM, _, err := envs.IssueClientCert(env, "NODE-UUID", 0, "alice")
+	require.NoError(t, err)
+	cert := parseTestCert(t, certPEM)
+	verified, err := certs.Verify(ctx, env.ID, cert)
+	require.NoError(t, err)
+	require.Equal(t, record.ID, verified.ID)
+
+	// Revocations are served from the database once the cached certificate expires
+	_, err = envs.RevokeClientCert(env.ID, record.Serial, "stolen", "bob")
+	require.NoError(t, err)
+	_, err = certs.Verify(ctx, env.ID, cert)
+	require.NoError(t, err)
+	certs.Invalidate(ctx, env.ID, record.Serial)
+	_, err = certs.Verify(ctx, env.ID, cert)
+	require.ErrorIs(t, err, ErrClientCertRevoked)
+}
@@ -0,0 +1,98 @@
+package environments
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/utils"
+	"gorm.io/gorm"
+)
+
+// CloneCopy copies data of a template environment to its clone, within the
+// transaction that creates the clone
+type CloneCopy func(tx *gorm.DB, template, clone TLSEnvironment) error
+
+// Clone creates a new environment from an existing one used as template. The
+// settings, intervals, packages and configuration parts are copied along with
+// the configuration overlays, while the UUID, secret and secret paths are new.
+// The flags are those of the template pointing to the new environment, and
+// the configuration is stored as the first revision of the clone. Client
+// certificates are not required in the clone, which has no client CA until
+// one is created for it. The copies run in the same transaction, so the
+// clone is not created when one fails.
+func (environment *EnvManager) Clone(source, name, hostname, author string, copies ...CloneCopy) (TLSEnvironment, error) {
+	template, err := environment.Get(source)
+	if err != nil {
+		return TLSEnvironment{}, fmt.Errorf("error getting environment %w", err)
+	}
+	if hostname == "" {
+		hostname = template.Hostname
+	}
+	clone := template
+	clone.ID = 0
+	clone.CreatedAt = time.Time{}
+	clone.UpdatedAt = time.Time{}
+	clone.DeletedAt = gorm.DeletedAt{}
+	clone.UUID = utils.GenUUID()
+	clone.Name = name
+	clone.Hostname = hostname
+	clone.Secret = utils.GenRandomString(DefaultSecretLength)
+	clone.EnrollSecretPath = utils.GenKSUID()
+	clone.RemoveSecretPath = utils.GenKSUID()
+	clone.EnrollExpire = time.Now().Add(time.Duration(DefaultLinkExpire) * time.Hour)
+	clone.RemoveExpire = time.Now().Add(time.Duration(DefaultLinkExpire) * time.Hour)
+	clone.ClientCertAuth = false
+	clone.Flags = cloneFlags(template, clone)
+	var overlays []ConfigOverlay
+	if err := environment.DB.Where("environment_id = ?", template.ID).Find(&overlays).Error; err != nil {
+		return TLSEnvironment{}, fmt.Errorf("overlays %w", err)
+	}
+	err = environment.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&clone).Error; err != nil {
+			return err
+		}
+		for _, o := range overlays {
+			o.ID = 0
+			o.CreatedAt = time.Time{}
+			o.UpdatedAt = time.Time{}
+			o.EnvironmentID = clone.ID
+			if err := tx.Create(&o).Error; err != nil {
+				return err
+			}
+		}
+		if clone.Configuration != "" {
+			if _, err := recordRevisionTx(tx, clone.ID, clone.Configuration, author, "cloned from "+template.Name, 0); err != nil {
+				return err
+			}
+		}
+		for _, c := range copies {
+			if err := c(tx, template, clone); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return TLSEnvironment{}, fmt.Errorf("Clone TLS Environment %w", err)
+	}
+	return clone, nil
+}
+
+// cloneFlags points the flags of a template to its clone, so any change made
+// to the generated flags is kept, without the client certificate flags
+func cloneFlags(template, clone TLSEnvironment) string {
+	lines := strings.Split(strings.ReplaceAll(template.Flags, "/"+template.UUID+"/", "/"+clone.UUID+"/"), "\n")
+	flags := make([]string, 0, len(lines))
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "--tls_hostname="):
+			line = "--tls_hostname=" + clone.Hostname
+		case strings.HasPrefix(trimmed, "--"+FlagNameTLSClientCert+"="), strings.HasPrefix(trimmed, "--"+FlagNameTLSClientKey+"="):
+			continue
+		}
+		flags = append(flags, line)
+	}
+	return strings.Join(flags, "\n")
+}
@@ -0,0 +1,77 @@
+package environments
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestCloneCopiesConfigurationWithNewSecrets(t *testing.T) {
+	db := setupTestDB(t)
+	envs := CreateEnvironment(db)
+	template := envs.Empty("prod", "prod.example.com")
+	template.Flags = "--enroll_tls_endpoint=/" + template.UUID + "/enroll\n--tls_hostname=prod.example.com\n--custom_flag=1\n" + GenClientCertFlags(EmptyFlagClientCert, EmptyFlagClientKey)
+	template.ClientCertAuth = true
+	template.ConfigInterval = 120
+	template.DebPackage = "osquery.deb"
+	require.NoError(t, envs.Create(&template))
+	require.NoError(t, envs.UpdateSchedule(template.Name, `{"uptime":{"query":"SELECT * FROM uptime;","interval":60}}`))
+	_, err := envs.RefreshConfigurationBy(template.Name, "alice", "add uptime")
+	require.NoError(t, err)
+	_, err = envs.SetOverlay(ConfigOverlay{EnvironmentID: template.ID, Name: "linux", TargetType: OverlayTargetPlatform, Target: "linux", Configuration: "{}"})
+	require.NoError(t, err)
+	template, err = envs.Get(template.Name)
+	require.NoError(t, err)
+
+	clone, err := envs.Clone(template.Name, "staging", "staging.example.com", "bob")
+	require.NoError(t, err)
+	require.NotEqual(t, template.UUID, clone.UUID)
+	require.NotEqual(t, template.Secret, clone.Secret)
+	require.NotEqual(t, template.EnrollSecretPath, clone.EnrollSecretPath)
+	require.NotEqual(t, template.RemoveSecretPath, clone.RemoveSecretPath)
+
+	stored, err := envs.Get("staging")
+	require.NoError(t, err)
+	require.Equal(t, "staging.example.com", stored.Hostname)
+	require.Equal(t, 120, stored.ConfigInterval)
+	require.Equal(t, "osquery.deb", stored.DebPackage)
+	require.Equal(t, template.Schedule, stored.Schedule)
+	require.Equal(t, template.Configuration, stored.Configuration)
+	require.Contains(t, stored.Flags, "/"+clone.UUID+"/enroll")
+	require.NotContains(t, stored.Flags, template.UUID)
+	require.Contains(t, stored.Flags, "--tls_hostname=staging.example.com")
+	require.Contains(t, stored.Flags, "--custom_flag=1")
+	// Client certificates are issued by the CA of each environment
+	require.False(t, stored.ClientCertAuth)
+	require.NotContains(t, stored.Flags, FlagNameTLSClientCert)
+	_, err = envs.GetClientCA(clone.ID)
+	require.ErrorIs(t, err, ErrClientCANotFound)
+
+	overlays, err := envs.Overlays(clone.ID)
+	require.NoError(t, err)
+	require.Len(t, overlays, 1)
+	revision, err := envs.LatestRevision(clone.ID)
+	require.NoError(t, err)
+	require.Equal(t, uint(1), revision.Revision)
+	require.Equal(t, "bob", revision.Author)
+
+	// The template is left as it was
+	again, err := envs.Get(template.Name)
+	require.NoError(t, err)
+	require.Equal(t, template.Flags, again.Flags)
+	_, err = envs.Clone("missing", "other", "", "bob")
+	require.Error(t, err)
+
+	// A failed copy leaves nothing of the clone behind
+	failed := func(tx *gorm.DB, template, clone TLSEnvironment) error {
+		return errors.New("copy failed")
+	}
+	_, err = envs.Clone(template.Name, "qa", "", "bob", failed)
+	require.Error(t, err)
+	require.False(t, envs.Exists("qa"))
+	var orphans int64
+	require.NoError(t, db.Model(&ConfigOverlay{}).Where("environment_id NOT IN (?)", db.Model(&TLSEnvironment{}).Select("id")).Count(&orphans).Error)
+	require.Zero(t, orphans)
+}
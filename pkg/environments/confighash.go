@@ -0,0 +1,91 @@
+package environments
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// OsqueryConfigHash returns the config hash osquery reports for a served
+// configuration. osquery hashes the content of every config source with SHA1
+// and reports the SHA1 of those hashes concatenated. The configuration served
+// by osctrl is the only source of its nodes, and osquery stores it as it
+// serializes the parsed response: compact and without unneeded escapes.
+func OsqueryConfigHash(configuration []byte) string {
+	source := sha1.Sum(osqueryJSON(configuration))
+	sum := sha1.Sum([]byte(hex.EncodeToString(source[:])))
+	return hex.EncodeToString(sum[:])
+}
+
+// osqueryJSON serializes JSON the way osquery does, removing whitespace and
+// unescaping the characters that osquery writes as they are, like the HTML
+// characters escaped by encoding/json. Invalid JSON is returned unchanged.
+func osqueryJSON(data []byte) []byte {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		return data
+	}
+	in := compact.Bytes()
+	out := make([]byte, 0, len(in))
+	inString := false
+	for i := 0; i < len(in); i++ {
+		c := in[i]
+		if !inString {
+			inString = c == '"'
+			out = append(out, c)
+			continue
+		}
+		switch {
+		case c == '"':
+			inString = false
+			out = append(out, c)
+		case c == '\\' && i+1 < len(in) && in[i+1] == '/':
+			out = append(out, '/')
+			i++
+		case c == '\\' && i+5 < len(in) && in[i+1] == 'u':
+			r, size := unescapeRune(in[i:])
+			if r < 0x20 || r == '"' || r == '\\' || r == utf8.RuneError {
+				out = append(out, in[i:i+6]...)
+				i += 5
+				continue
+			}
+			out = utf8.AppendRune(out, r)
+			i += size - 1
+		case c == '\\' && i+1 < len(in):
+			out = append(out, c, in[i+1])
+			i++
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// unescapeRune decodes a \uXXXX escape, combining surrogate pairs, and
+// returns the rune with the number of bytes of its escapes
+func unescapeRune(s []byte) (rune, int) {
+	code, err := strconv.ParseUint(string(s[2:6]), 16, 32)
+	if err != nil {
+		return utf8.RuneError, 6
+	}
+	r := rune(code)
+	if !utf16.IsSurrogate(r) {
+		return r, 6
+	}
+	if len(s) < 12 || s[6] != '\\' || s[7] != 'u' {
+		return utf8.RuneError, 6
+	}
+	low, err := strconv.ParseUint(string(s[8:12]), 16, 32)
+	if err != nil {
+		return utf8.RuneError, 6
+	}
+	combined := utf16.DecodeRune(r, rune(low))
+	if combined == utf8.RuneError {
+		return utf8.RuneError, 6
+	}
+	return combined, 12
+}
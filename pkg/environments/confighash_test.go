@@ -0,0 +1,20 @@
+package environments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOsqueryConfigHashMatchesOsquerySerialization(t *testing.T) {
+	// SHA1 of the hex SHA1 of the compact configuration
+	require.Equal(t, "f80dee827635db39077a458243379b3ad63311fd", OsqueryConfigHash([]byte(`{}`)))
+
+	compact := `{"schedule":{"big":{"query":"SELECT * FROM file WHERE size > 1 AND path LIKE '/tmp/%';","interval":60}}}`
+	indented := "{\n  \"schedule\": {\n    \"big\": {\n      \"query\": \"SELECT * FROM file WHERE size \\u003e 1 AND path LIKE '\\/tmp/%';\",\n      \"interval\": 60\n    }\n  }\n}"
+	require.Equal(t, OsqueryConfigHash([]byte(compact)), OsqueryConfigHash([]byte(indented)))
+	require.NotEqual(t, OsqueryConfigHash([]byte(compact)), OsqueryConfigHash([]byte(`{"schedule":{}}`)))
+
+	// Escapes osquery keeps are kept
+	require.Equal(t, `{"a":"\"\\\n\u0001é😀😀"}`, string(osqueryJSON([]byte(`{ "a": "\"\\\n\u0001é😀\ud83d\ude00" }`))))
+}
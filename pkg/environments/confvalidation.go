@@ -0,0 +1,397 @@
+package environments
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+const (
+	// ConfSectionOptions for issues in the options of the configuration
+	ConfSectionOptions string = "options"
+	// ConfSectionPacks for issues in the packs of the configuration
+	ConfSectionPacks string = "packs"
+	// ConfSectionATC for issues in the ATC of the configuration
+	ConfSectionATC string = "atc"
+	// maxSuggestionDistance is the max edit distance to suggest a known flag
+	maxSuggestionDistance int = 2
+)
+
+var (
+	// packKeys are the keys of a pack in the configuration
+	packKeys = []string{"queries", "platform", "version", "shard", "discovery"}
+	// packQueryKeys are the keys of a query in a pack
+	packQueryKeys = []string{"query", "interval", "removed", "snapshot", "platform", "version", "shard", "description", "value", "denylist", "blacklist"}
+	// atcKeys are the keys of an auto table construction
+	atcKeys = []string{"query", "path", "columns", "platform"}
+)
+
+// ConfigIssue to hold one finding of the configuration validation
+type ConfigIssue struct {
+	Section string `json:"section"`
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+// String to format the issue as section.key: message
+func (i ConfigIssue) String() string {
+	return fmt.Sprintf("%s.%s: %s", i.Section, i.Key, i.Message)
+}
+
+// ConfigValidation to hold the errors that block a configuration write and
+// the warnings that do not
+type ConfigValidation struct {
+	Errors   []ConfigIssue `json:"errors"`
+	Warnings []ConfigIssue `json:"warnings"`
+}
+
+func (v *ConfigValidation) errorf(section, key, format string, a ...interface{}) {
+	v.Errors = append(v.Errors, ConfigIssue{Section: section, Key: key, Message: fmt.Sprintf(format, a...)})
+}
+
+func (v *ConfigValidation) warnf(section, key, format string, a ...interface{}) {
+	v.Warnings = append(v.Warnings, ConfigIssue{Section: section, Key: key, Message: fmt.Sprintf(format, a...)})
+}
+
+// Merge to add the issues of another validation
+func (v *ConfigValidation) Merge(other ConfigValidation) {
+	v.Errors = append(v.Errors, other.Errors...)
+	v.Warnings = append(v.Warnings, other.Warnings...)
+}
+
+// Err returns an error with all the errors found, nil if there are none
+func (v ConfigValidation) Err() error {
+	if len(v.Errors) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(v.Errors))
+	for _, i := range v.Errors {
+		msgs = append(msgs, i.String())
+	}
+	return fmt.Errorf("invalid configuration: %s", strings.Join(msgs, "; "))
+}
+
+// Messages returns the warnings to report for a write, including the errors
+// when the write is forced
+func (v ConfigValidation) Messages(force bool) []string {
+	var msgs []string
+	if force {
+		for _, i := range v.Errors {
+			msgs = append(msgs, i.String())
+		}
+	}
+	for _, i := range v.Warnings {
+		msgs = append(msgs, i.String())
+	}
+	return msgs
+}
+
+// ValidateConfigParts to validate the serialized options, packs and ATC of a
+// configuration, skipping the empty ones. The osquery versions of the nodes
+// are used to warn about options they do not support yet.
+func ValidateConfigParts(options, packs, atc string, versions []string) ConfigValidation {
+	var v ConfigValidation
+	parts := []struct {
+		section  string
+		raw      string
+		validate func(map[string]interface{}) ConfigValidation
+	}{
+		{ConfSectionOptions, options, func(m map[string]interface{}) ConfigValidation { return ValidateOptions(m, versions) }},
+		{ConfSectionPacks, packs, func(m map[string]interface{}) ConfigValidation { return ValidatePacks(m) }},
+		{ConfSectionATC, atc, func(m map[string]interface{}) ConfigValidation { return ValidateATC(m) }},
+	}
+	for _, p := range parts {
+		if strings.TrimSpace(p.raw) == "" {
+			continue
+		}
+		var parsed map[string]interface{}
+		if err := json.Unmarshal([]byte(p.raw), &parsed); err != nil {
+			v.errorf(p.section, "", "not a JSON object: %v", err)
+			continue
+		}
+		v.Merge(p.validate(parsed))
+	}
+	return v
+}
+
+// ValidateOptions to check the options of a configuration against the
+// catalog of osquery flags
+func ValidateOptions(options OptionsConf, versions []string) ConfigValidation {
+	var v ConfigValidation
+	oldest := OldestOsqueryVersion(versions)
+	for _, name := range sortedKeys(options) {
+		value := options[name]
+		if strings.HasPrefix(name, OsqueryCustomOptionPrefix) {
+			continue
+		}
+		flag, ok := OsqueryFlags[name]
+		if !ok {
+			if suggestion := suggestFlag(name); suggestion != "" {
+				v.errorf(ConfSectionOptions, name, "unknown osquery option, did you mean %s?", suggestion)
+			} else {
+				v.errorf(ConfSectionOptions, name, "unknown osquery option")
+			}
+			continue
+		}
+		if flag.CLIOnly {
+			v.errorf(ConfSectionOptions, name, "only settable as a command line flag, osquery ignores it in the configuration")
+			continue
+		}
+		if err := checkOptionValue(flag, value); err != nil {
+			v.errorf(ConfSectionOptions, name, "%v", err)
+			continue
+		}
+		if flag.Since != "" && oldest != "" && semver.Compare("v"+oldest, "v"+flag.Since) < 0 {
+			v.warnf(ConfSectionOptions, name, "requires osquery %s but nodes run %s", flag.Since, oldest)
+		}
+	}
+	return v
+}
+
+// ValidatePacks to check the structure of the packs of a configuration
+// https://osquery.readthedocs.io/en/stable/deployment/configuration/#packs
+func ValidatePacks(packs PacksConf) ConfigValidation {
+	var v ConfigValidation
+	for _, name := range sortedKeys(packs) {
+		switch pack := packs[name].(type) {
+		case string:
+			// Local pack, the value is the path in the node
+			if strings.TrimSpace(pack) == "" {
+				v.errorf(ConfSectionPacks, name, "local pack without a path")
+			}
+		case map[string]interface{}:
+			validatePack(&v, name, pack)
+		default:
+			v.errorf(ConfSectionPacks, name, "pack must be an object or the path of a local pack")
+		}
+	}
+	return v
+}
+
+func validatePack(v *ConfigValidation, name string, pack map[string]interface{}) {
+	for _, k := range unknownKeys(pack, packKeys) {
+		v.errorf(ConfSectionPacks, name, "unknown pack key %s", k)
+	}
+	if shard, ok := pack["shard"]; ok {
+		checkShard(v, ConfSectionPacks, name, shard)
+	}
+	if discovery, ok := pack["discovery"]; ok && !isStringList(discovery) {
+		v.errorf(ConfSectionPacks, name, "discovery must be a list of queries")
+	}
+	queries, ok := pack["queries"].(map[string]interface{})
+	if !ok {
+		v.errorf(ConfSectionPacks, name, "pack without queries")
+		return
+	}
+	if len(queries) == 0 {
+		v.warnf(ConfSectionPacks, name, "pack without queries")
+	}
+	for _, qName := range sortedKeys(queries) {
+		key := name + "." + qName
+		query, ok := queries[qName].(map[string]interface{})
+		if !ok {
+			v.errorf(ConfSectionPacks, key, "query must be an object")
+			continue
+		}
+		for _, k := range unknownKeys(query, packQueryKeys) {
+			v.errorf(ConfSectionPacks, key, "unknown query key %s", k)
+		}
+		if sql, _ := query["query"].(string); strings.TrimSpace(sql) == "" {
+			v.errorf(ConfSectionPacks, key, "query is required")
+		}
+		if interval, ok := query["interval"]; ok {
+			if n, isInt := intValue(interval); !isInt || n <= 0 {
+				v.errorf(ConfSectionPacks, key, "interval must be a positive number of seconds")
+			}
+		} else {
+			v.warnf(ConfSectionPacks, key, "no interval, osquery uses schedule_default_interval")
+		}
+		if shard, ok := query["shard"]; ok {
+			checkShard(v, ConfSectionPacks, key, shard)
+		}
+	}
+}
+
+// ValidateATC to check the auto table construction of a configuration
+// https://osquery.readthedocs.io/en/stable/deployment/configuration/#automatic-table-construction
+func ValidateATC(atc ATCConf) ConfigValidation {
+	var v ConfigValidation
+	for _, name := range sortedKeys(atc) {
+		table, ok := atc[name].(map[string]interface{})
+		if !ok {
+			v.errorf(ConfSectionATC, name, "table must be an object")
+			continue
+		}
+		for _, k := range unknownKeys(table, atcKeys) {
+			v.errorf(ConfSectionATC, name, "unknown key %s", k)
+		}
+		for _, k := range []string{"query", "path"} {
+			if s, _ := table[k].(string); strings.TrimSpace(s) == "" {
+				v.errorf(ConfSectionATC, name, "%s is required", k)
+			}
+		}
+		if columns, ok := table["columns"].([]interface{}); !ok || len(columns) == 0 || !isStringList(columns) {
+			v.errorf(ConfSectionATC, name, "columns must be a non empty list of names")
+		}
+	}
+	return v
+}
+
+// OldestOsqueryVersion returns the oldest of the osquery versions, empty
+// if none of them is valid
+func OldestOsqueryVersion(versions []string) string {
+	oldest := ""
+	for _, version := range versions {
+		if !semver.IsValid("v" + version) {
+			continue
+		}
+		if oldest == "" || semver.Compare("v"+version, "v"+oldest) < 0 {
+			oldest = version
+		}
+	}
+	return oldest
+}
+
+func checkOptionValue(flag OsqueryFlag, value interface{}) error {
+	switch flag.Type {
+	case OsqueryFlagBool:
+		switch b := value.(type) {
+		case bool:
+			return nil
+		case string:
+			if _, err := strconv.ParseBool(b); err == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be a boolean")
+	case OsqueryFlagInt:
+		if _, ok := intValue(value); !ok {
+			return fmt.Errorf("must be an integer")
+		}
+	case OsqueryFlagDouble:
+		if _, ok := floatValue(value); !ok {
+			return fmt.Errorf("must be a number")
+		}
+	case OsqueryFlagString:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("must be a string")
+		}
+		if len(flag.Values) > 0 && !containsString(flag.Values, s) {
+			return fmt.Errorf("must be one of %s", strings.Join(flag.Values, ", "))
+		}
+	}
+	return nil
+}
+
+func checkShard(v *ConfigValidation, section, key string, value interface{}) {
+	if n, ok := intValue(value); !ok || n < 1 || n > 100 {
+		v.errorf(section, key, "shard must be a percentage between 1 and 100")
+	}
+}
+
+// floatValue converts the numeric values decoded from JSON, YAML or flags
+func floatValue(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func intValue(value interface{}) (int64, bool) {
+	f, ok := floatValue(value)
+	if !ok || f != math.Trunc(f) {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+func isStringList(value interface{}) bool {
+	list, ok := value.([]interface{})
+	if !ok {
+		return false
+	}
+	for _, item := range list {
+		if _, ok := item.(string); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func unknownKeys(m map[string]interface{}, known []string) []string {
+	var unknown []string
+	for _, k := range sortedKeys(m) {
+		if !containsString(known, k) {
+			unknown = append(unknown, k)
+		}
+	}
+	return unknown
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// suggestFlag returns the closest known flag to a name, if close enough
+func suggestFlag(name string) string {
+	best, bestDistance := "", maxSuggestionDistance+1
+	for _, flag := range sortedKeys(OsqueryFlags) {
+		if d := editDistance(name, flag); d < bestDistance {
+			best, bestDistance = flag, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,69 @@
+package environments
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateOptions(t *testing.T) {
+	v := ValidateOptions(OptionsConf{
+		"distributed_intervall":    60,
+		"config_plugin":            "tls",
+		"logger_tls_period":        "often",
+		"host_identifier":          "serial",
+		"verbose":                  "true",
+		"schedule_splay_percent":   json.Number("10"),
+		"custom_owner":             "it",
+		"disable_endpointsecurity": false,
+	}, []string{"5.12.1", "4.9.0", "garbage"})
+	require.Len(t, v.Errors, 4)
+	require.Equal(t, "config_plugin", v.Errors[0].Key)
+	require.Equal(t, "distributed_intervall", v.Errors[1].Key)
+	require.Contains(t, v.Errors[1].Message, "did you mean distributed_interval?")
+	require.Equal(t, "host_identifier", v.Errors[2].Key)
+	require.Equal(t, "logger_tls_period", v.Errors[3].Key)
+	require.Len(t, v.Warnings, 1)
+	require.Equal(t, "options.disable_endpointsecurity: requires osquery 5.0.1 but nodes run 4.9.0", v.Warnings[0].String())
+	require.Error(t, v.Err())
+	require.Len(t, v.Messages(false), 1)
+	require.Len(t, v.Messages(true), 5)
+
+	v = ValidateOptions(OptionsConf{"distributed_interval": float64(60), "utc": true}, nil)
+	require.NoError(t, v.Err())
+	require.Empty(t, v.Warnings)
+}
+
+func TestValidatePacksAndATC(t *testing.T) {
+	v := ValidateConfigParts("", `{
+		"local": "/etc/osquery/packs/local.conf",
+		"good": {"platform": "linux", "queries": {"uptime": {"query": "SELECT * FROM uptime;", "interval": 60}}},
+		"bad": {"shard": 200, "discovery": "SELECT 1;", "queries": {
+			"noquery": {"interval": 60},
+			"nointerval": {"query": "SELECT 1;"},
+			"typo": {"query": "SELECT 1;", "interval": 60, "snapshots": true}
+		}}
+	}`, `{
+		"good": {"query": "SELECT * FROM t;", "path": "/var/db.sqlite", "columns": ["a", "b"], "platform": "darwin"},
+		"bad": {"query": "SELECT * FROM t;", "columns": []}
+	}`, nil)
+	var errs []string
+	for _, i := range v.Errors {
+		errs = append(errs, i.String())
+	}
+	require.Equal(t, []string{
+		"packs.bad: shard must be a percentage between 1 and 100",
+		"packs.bad: discovery must be a list of queries",
+		"packs.bad.noquery: query is required",
+		"packs.bad.typo: unknown query key snapshots",
+		"atc.bad: path is required",
+		"atc.bad: columns must be a non empty list of names",
+	}, errs)
+	require.Len(t, v.Warnings, 1)
+	require.Equal(t, "bad.nointerval", v.Warnings[0].Key)
+
+	v = ValidateConfigParts("[]", "", "", nil)
+	require.Len(t, v.Errors, 1)
+	require.Equal(t, ConfSectionOptions, v.Errors[0].Section)
+}
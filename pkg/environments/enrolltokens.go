@@ -0,0 +1,268 @@
+package environments
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrEnrollTokenInvalid is returned when a secret matches no token
+	ErrEnrollTokenInvalid = errors.New("invalid enroll token")
+	// ErrEnrollTokenExpired is returned when the matched token expired
+	ErrEnrollTokenExpired = errors.New("expired enroll token")
+	// ErrEnrollTokenExhausted is returned when the matched token reached its maximum number of uses
+	ErrEnrollTokenExhausted = errors.New("exhausted enroll token")
+	// ErrEnrollTokenSource is returned when the address of a node is not allowed by the matched token
+	ErrEnrollTokenSource = errors.New("enroll token not allowed from address")
+	// ErrEnrollTokenExists is returned when creating a token with a name already used in the environment
+	ErrEnrollTokenExists = errors.New("enroll token already exists")
+)
+
+// EnrollToken is a named enrollment secret of an environment, so installers
+// can be given their own secret and revoked without rotating the one of the
+// environment. Only the hash of the secret is stored.
+type EnrollToken struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	EnvironmentID uint      `gorm:"uniqueIndex:idx_enroll_token_name" json:"environment_id"`
+	Name          string    `gorm:"uniqueIndex:idx_enroll_token_name" json:"name"`
+	SecretHash    string    `gorm:"index" json:"-"`
+	// ExpiresAt is zero for tokens that do not expire
+	ExpiresAt time.Time `json:"expires_at"`
+	// MaxUses is zero for tokens without a limit of enrollments
+	MaxUses int `json:"max_uses"`
+	Uses    int `json:"uses"`
+	// CIDRs is the comma separated list of networks nodes can enroll from,
+	// empty for any address
+	CIDRs string `json:"cidrs"`
+	// Tags is the comma separated list of tags of the enrolled nodes
+	Tags       string    `json:"tags"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	CreatedBy  string    `json:"created_by"`
+}
+
+// Expired returns true if the token expired at a given time
+func (t EnrollToken) Expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && now.After(t.ExpiresAt)
+}
+
+// Exhausted returns true if the token reached its maximum number of uses
+func (t EnrollToken) Exhausted() bool {
+	return t.MaxUses > 0 && t.Uses >= t.MaxUses
+}
+
+// Allows returns true if a node can enroll with the token from an address
+func (t EnrollToken) Allows(address string) bool {
+	cidrs := splitList(t.CIDRs)
+	if len(cidrs) == 0 {
+		return true
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, c := range cidrs {
+		if _, n, err := net.ParseCIDR(c); err == nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// TagList returns the tags of the nodes enrolled with the token
+func (t EnrollToken) TagList() []string {
+	return splitList(t.Tags)
+}
+
+// check returns why a node can not enroll with the token, nil if it can
+func (t EnrollToken) check(address string, now time.Time) error {
+	if t.Expired(now) {
+		return ErrEnrollTokenExpired
+	}
+	if t.Exhausted() {
+		return ErrEnrollTokenExhausted
+	}
+	if !t.Allows(address) {
+		return ErrEnrollTokenSource
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func hashEnrollSecret(secret string) string {
+	h := sha256.Sum256([]byte(strings.TrimSpace(secret)))
+	return hex.EncodeToString(h[:])
+}
+
+// CreateEnrollToken to create an enroll token, returning it with its secret,
+// which is not stored and can not be retrieved later
+func (environment *EnvManager) CreateEnrollToken(token EnrollToken, cidrs, tags []string) (EnrollToken, string, error) {
+	token.Name = strings.ToLower(strings.TrimSpace(token.Name))
+	if !EnvNameFilter(token.Name) {
+		return EnrollToken{}, "", fmt.Errorf("invalid enroll token name %q", token.Name)
+	}
+	if token.MaxUses < 0 {
+		return EnrollToken{}, "", fmt.Errorf("invalid maximum uses %d", token.MaxUses)
+	}
+	networks := make([]string, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(c))
+		if err != nil {
+			return EnrollToken{}, "", fmt.Errorf("invalid network %q", c)
+		}
+		networks = append(networks, n.String())
+	}
+	tagNames := make([]string, 0, len(tags))
+	for _, t := range tags {
+		name := strings.TrimSpace(t)
+		if name == "" || strings.Contains(name, ",") {
+			return EnrollToken{}, "", fmt.Errorf("invalid tag %q", t)
+		}
+		tagNames = append(tagNames, name)
+	}
+	var count int64
+	if err := environment.DB.Model(&EnrollToken{}).Where("environment_id = ? AND name = ?", token.EnvironmentID, token.Name).Count(&count).Error; err != nil {
+		return EnrollToken{}, "", fmt.Errorf("Count %w", err)
+	}
+	if count > 0 {
+		return EnrollToken{}, "", ErrEnrollTokenExists
+	}
+	secret := utils.GenRandomString(DefaultSecretLength)
+	token.ID = 0
+	token.SecretHash = hashEnrollSecret(secret)
+	token.Uses = 0
+	token.CIDRs = strings.Join(networks, ",")
+	token.Tags = strings.Join(tagNames, ",")
+	if err := environment.DB.Create(&token).Error; err != nil {
+		return EnrollToken{}, "", fmt.Errorf("Create %w", err)
+	}
+	return token, secret, nil
+}
+
+// EnrollTokens to get the enroll tokens of an environment by name
+func (environment *EnvManager) EnrollTokens(envID uint) ([]EnrollToken, error) {
+	var tokens []EnrollToken
+	if err := environment.DB.Where("environment_id = ?", envID).Order("name").Find(&tokens).Error; err != nil {
+		return tokens, fmt.Errorf("Find %w", err)
+	}
+	return tokens, nil
+}
+
+// GetEnrollToken to get an enroll token of an environment by name
+func (environment *EnvManager) GetEnrollToken(envID uint, name string) (EnrollToken, error) {
+	var token EnrollToken
+	if err := environment.DB.Where("environment_id = ? AND name = ?", envID, strings.ToLower(name)).First(&token).Error; err != nil {
+		return token, err
+	}
+	return token, nil
+}
+
+// DeleteEnrollToken to revoke an enroll token of an environment
+func (environment *EnvManager) DeleteEnrollToken(envID uint, name string) error {
+	token, err := environment.GetEnrollToken(envID, name)
+	if err != nil {
+		return err
+	}
+	if err := environment.DB.Delete(&token).Error; err != nil {
+		return fmt.Errorf("Delete %w", err)
+	}
+	return nil
+}
+
+// matchEnrollToken returns the token of an environment with a secret
+func (environment *EnvManager) matchEnrollToken(envID uint, secret string) (EnrollToken, error) {
+	var token EnrollToken
+	if strings.TrimSpace(secret) == "" {
+		return token, ErrEnrollTokenInvalid
+	}
+	err := environment.DB.Where("environment_id = ? AND secret_hash = ?", envID, hashEnrollSecret(secret)).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return token, ErrEnrollTokenInvalid
+	}
+	return token, err
+}
+
+// CheckEnrollToken returns the token of an environment with a secret, if
+// a node can enroll with it from an address, without using it
+func (environment *EnvManager) CheckEnrollToken(envID uint, secret, address string) (EnrollToken, error) {
+	token, err := environment.matchEnrollToken(envID, secret)
+	if err != nil {
+		return token, err
+	}
+	return token, token.check(address, time.Now())
+}
+
+// UseEnrollToken returns the token of an environment with a secret, if a
+// node can enroll with it from an address, and counts the enrollment. The
+// token is also returned when the node can not enroll, with the reason.
+func (environment *EnvManager) UseEnrollToken(envID uint, secret, address string) (EnrollToken, error) {
+	return environment.ReuseEnrollToken(envID, secret, address, "")
+}
+
+// ReuseEnrollToken is UseEnrollToken for a node that enrolled before with the
+// token named enrolledWith. Enrolling again with the same token is not
+// counted, and is allowed when the token was exhausted since.
+func (environment *EnvManager) ReuseEnrollToken(envID uint, secret, address, enrolledWith string) (EnrollToken, error) {
+	token, err := environment.matchEnrollToken(envID, secret)
+	if err != nil {
+		return token, err
+	}
+	now := time.Now()
+	if enrolledWith != "" && enrolledWith == token.Name {
+		if token.Expired(now) {
+			return token, ErrEnrollTokenExpired
+		}
+		if !token.Allows(address) {
+			return token, ErrEnrollTokenSource
+		}
+		if err := environment.DB.Model(&EnrollToken{}).Where("id = ?", token.ID).Update("last_used_at", now).Error; err != nil {
+			return token, fmt.Errorf("Update %w", err)
+		}
+		token.LastUsedAt = now
+		return token, nil
+	}
+	if err := token.check(address, now); err != nil {
+		return token, err
+	}
+	// Concurrent enrollments must not go over the maximum uses, so the use
+	// is counted before the node is persisted and released if it is not
+	res := environment.DB.Model(&EnrollToken{}).
+		Where("id = ? AND (max_uses = 0 OR uses < max_uses)", token.ID).
+		Updates(map[string]interface{}{"uses": gorm.Expr("uses + 1"), "last_used_at": now})
+	if res.Error != nil {
+		return token, fmt.Errorf("Updates %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return token, ErrEnrollTokenExhausted
+	}
+	token.Uses++
+	token.LastUsedAt = now
+	return token, nil
+}
+
+// ReleaseEnrollToken gives back a use of the token, counted for an enrollment
+// whose node could not be persisted
+func (environment *EnvManager) ReleaseEnrollToken(token EnrollToken) error {
+	if err := environment.DB.Model(&EnrollToken{}).Where("id = ? AND uses > 0", token.ID).Update("uses", gorm.Expr("uses - 1")).Error; err != nil {
+		return fmt.Errorf("Update %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package environments
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnrollTokenUsesAndRestrictions(t *testing.T) {
+	db := setupTestDB(t)
+	envs := CreateEnvironment(db)
+	env := envs.Empty("dev", "osctrl.example.com")
+	require.NoError(t, envs.Create(&env))
+
+	token, secret, err := envs.CreateEnrollToken(EnrollToken{EnvironmentID: env.ID, Name: "CI", MaxUses: 2, CreatedBy: "alice"}, []string{"10.1.2.3/16"}, []string{"ci", " build "})
+	require.NoError(t, err)
+	require.Equal(t, "ci", token.Name)
+	require.Equal(t, "10.1.0.0/16", token.CIDRs)
+	require.Equal(t, []string{"ci", "build"}, token.TagList())
+	require.NotEqual(t, secret, token.SecretHash)
+	_, _, err = envs.CreateEnrollToken(EnrollToken{EnvironmentID: env.ID, Name: "ci"}, nil, nil)
+	require.ErrorIs(t, err, ErrEnrollTokenExists)
+	_, _, err = envs.CreateEnrollToken(EnrollToken{EnvironmentID: env.ID, Name: "lab"}, []string{"10.1.2.3"}, nil)
+	require.Error(t, err)
+
+	_, err = envs.UseEnrollToken(env.ID, "wrong", "10.1.0.1")
+	require.ErrorIs(t, err, ErrEnrollTokenInvalid)
+	_, err = envs.UseEnrollToken(env.ID+1, secret, "10.1.0.1")
+	require.ErrorIs(t, err, ErrEnrollTokenInvalid)
+	_, err = envs.UseEnrollToken(env.ID, secret, "10.2.0.1")
+	require.ErrorIs(t, err, ErrEnrollTokenSource)
+
+	// Checking does not count as an enrollment
+	_, err = envs.CheckEnrollToken(env.ID, secret, "10.1.0.1")
+	require.NoError(t, err)
+	used, err := envs.UseEnrollToken(env.ID, secret, "10.1.0.1")
+	require.NoError(t, err)
+	require.Equal(t, 1, used.Uses)
+	_, err = envs.UseEnrollToken(env.ID, " "+secret+"\n", "10.1.0.2")
+	require.NoError(t, err)
+	_, err = envs.UseEnrollToken(env.ID, secret, "10.1.0.3")
+	require.ErrorIs(t, err, ErrEnrollTokenExhausted)
+	stored, err := envs.GetEnrollToken(env.ID, "ci")
+	require.NoError(t, err)
+	require.Equal(t, 2, stored.Uses)
+	require.False(t, stored.LastUsedAt.IsZero())
+
+	// Nodes enrolling again with the same token are not counted
+	again, err := envs.ReuseEnrollToken(env.ID, secret, "10.1.0.1", "ci")
+	require.NoError(t, err)
+	require.Equal(t, 2, again.Uses)
+	_, err = envs.ReuseEnrollToken(env.ID, secret, "10.2.0.1", "ci")
+	require.ErrorIs(t, err, ErrEnrollTokenSource)
+	_, err = envs.ReuseEnrollToken(env.ID, secret, "10.1.0.4", "lab")
+	require.ErrorIs(t, err, ErrEnrollTokenExhausted)
+
+	// Uses of nodes that were not persisted are given back
+	require.NoError(t, envs.ReleaseEnrollToken(stored))
+	used, err = envs.UseEnrollToken(env.ID, secret, "10.1.0.3")
+	require.NoError(t, err)
+	require.Equal(t, 2, used.Uses)
+
+	expiring, expiringSecret, err := envs.CreateEnrollToken(EnrollToken{EnvironmentID: env.ID, Name: "old", ExpiresAt: time.Now().Add(-time.Minute)}, nil, nil)
+	require.NoError(t, err)
+	require.True(t, expiring.Expired(time.Now()))
+	_, err = envs.UseEnrollToken(env.ID, expiringSecret, "192.0.2.1")
+	require.ErrorIs(t, err, ErrEnrollTokenExpired)
+
+	require.NoError(t, envs.DeleteEnrollToken(env.ID, "old"))
+	tokens, err := envs.EnrollTokens(env.ID)
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	_, err = envs.UseEnrollToken(env.ID, expiringSecret, "192.0.2.1")
+	require.ErrorIs(t, err, ErrEnrollTokenInvalid)
+}
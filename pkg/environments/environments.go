@@ -96,6 +96,14 @@ type TLSEnvironment struct {
 	CarverBlockPath  string         `json:"carver_block_path"`
 	AcceptEnrolls    bool           `json:"accept_enrolls"`
 	UserID           uint           `json:"user_id"`
+	// QuarantineSchedule is the schedule served to quarantined nodes, the
+	// default incident response schedule when empty
+	QuarantineSchedule string `json:"quarantine_schedule"`
+	// RequireApproval keeps new nodes pending until an admin approves them
+	RequireApproval bool `json:"require_approval"`
+	// ClientCertAuth requires nodes to present a client certificate issued
+	// by the client CA of the environment, pinned to the node when it enrolls
+	ClientCertAuth bool `json:"client_cert_auth"`
 }
 
 // MapEnvironments to hold the TLS environments by name and UUID
@@ -126,6 +134,30 @@ func CreateEnvironment(backend *gorm.DB) *EnvManager {
 	if err := backend.AutoMigrate(&TLSEnvironment{}); err != nil {
 		log.Fatal().Msgf("Failed to AutoMigrate table (tls_environments): %v", err)
 	}
+	// table config_revisions
+	if err := backend.AutoMigrate(&ConfigRevision{}); err != nil {
+		log.Fatal().Msgf("Failed to AutoMigrate table (config_revisions): %v", err)
+	}
+	// table config_overlays
+	if err := backend.AutoMigrate(&ConfigOverlay{}); err != nil {
+		log.Fatal().Msgf("Failed to AutoMigrate table (config_overlays): %v", err)
+	}
+	// table config_rollouts
+	if err := backend.AutoMigrate(&ConfigRollout{}); err != nil {
+		log.Fatal().Msgf("Failed to AutoMigrate table (config_rollouts): %v", err)
+	}
+	// table enroll_tokens
+	if err := backend.AutoMigrate(&EnrollToken{}); err != nil {
+		log.Fatal().Msgf("Failed to AutoMigrate table (enroll_tokens): %v", err)
+	}
+	// table client_cas
+	if err := backend.AutoMigrate(&ClientCA{}); err != nil {
+		log.Fatal().Msgf("Failed to AutoMigrate table (client_cas): %v", err)
+	}
+	// table client_certificates
+	if err := backend.AutoMigrate(&ClientCertificate{}); err != nil {
+		log.Fatal().Msgf("Failed to AutoMigrate table (client_certificates): %v", err)
+	}
 	return e
 }
 
@@ -209,9 +241,19 @@ func (environment *EnvManager) Empty(name, hostname string) TLSEnvironment {
 	}
 }
 
-// Create new TLS Environment
+// Create new TLS Environment, storing its configuration as the first revision
 func (environment *EnvManager) Create(env *TLSEnvironment) error {
-	if err := environment.DB.Create(&env).Error; err != nil {
+	err := environment.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&env).Error; err != nil {
+			return err
+		}
+		if env.Configuration == "" {
+			return nil
+		}
+		_, err := recordRevisionTx(tx, env.ID, env.Configuration, "", "environment created", 0)
+		return err
+	})
+	if err != nil {
 		return fmt.Errorf("Create TLS Environment %w", err)
 	}
 	return nil
@@ -347,7 +389,28 @@ func (environment *EnvManager) Delete(identifier string) error {
 	if err != nil {
 		return fmt.Errorf("error getting environment %w", err)
 	}
-	if err := environment.DB.Unscoped().Delete(&env).Error; err != nil {
+	err = environment.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("environment_id = ?", env.ID).Delete(&ConfigOverlay{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("environment_id = ?", env.ID).Delete(&ConfigRevision{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("environment_id = ?", env.ID).Delete(&ConfigRollout{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("environment_id = ?", env.ID).Delete(&EnrollToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("environment_id = ?", env.ID).Delete(&ClientCertificate{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("environment_id = ?", env.ID).Delete(&ClientCA{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&env).Error
+	})
+	if err != nil {
 		return fmt.Errorf("delete %w", err)
 	}
 	return nil
@@ -473,6 +536,22 @@ func (environment *EnvManager) UpdateHostname(idEnv, hostname string) error {
 	return nil
 }
 
+// UpdateAcceptEnrolls to update if an environment accepts enrolling nodes
+func (environment *EnvManager) UpdateAcceptEnrolls(idEnv string, accept bool) error {
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Update("accept_enrolls", accept).Error; err != nil {
+		return fmt.Errorf("Update accept enrolls %w", err)
+	}
+	return nil
+}
+
+// UpdateRequireApproval to update if new nodes of an environment need to be approved
+func (environment *EnvManager) UpdateRequireApproval(idEnv string, require bool) error {
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Update("require_approval", require).Error; err != nil {
+		return fmt.Errorf("Update require approval %w", err)
+	}
+	return nil
+}
+
 // UpdateIntervals to update intervals for an environment
 func (environment *EnvManager) UpdateIntervals(name string, csecs, lsecs, qsecs int) error {
 	env, err := environment.Get(name)
@@ -3,6 +3,7 @@ package environments
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"text/template"
 
 	"github.com/jmpsec/osctrl/pkg/config"
@@ -15,6 +16,10 @@ const (
 	FlagGenericValue string = `--{{ .FlagName }}={{ .FlagValue }}`
 	// FlagTLSServerCerts for the --tls_server_certs flag
 	FlagNameTLSServerCerts string = `tls_server_certs`
+	// FlagNameTLSClientCert for the --tls_client_cert flag
+	FlagNameTLSClientCert string = `tls_client_cert`
+	// FlagNameTLSClientKey for the --tls_client_key flag
+	FlagNameTLSClientKey string = `tls_client_key`
 	// FlagCarverBlockSize for the --carver_block_size flag
 	FlagNameCarverBlockSize string = `carver_block_size`
 	// FlagsConfigPlugin to configure the config plugin
@@ -61,6 +66,7 @@ const (
 {{ .FlagsCarverPlugin }}
 --tls_hostname={{ .Environment.Hostname }}
 {{ .FlagServerCerts }}
+{{ .FlagClientCert }}
 `
 )
 
@@ -69,6 +75,10 @@ const (
 	EmptyFlagSecret string = "__SECRET_FILE__"
 	// EmptyFlagCert to use as placeholder for the certificate file
 	EmptyFlagCert string = "__CERT_FILE__"
+	// EmptyFlagClientCert to use as placeholder for the client certificate file
+	EmptyFlagClientCert string = "__CLIENT_CERT_FILE__"
+	// EmptyFlagClientKey to use as placeholder for the client key file
+	EmptyFlagClientKey string = "__CLIENT_KEY_FILE__"
 )
 
 type flagData struct {
@@ -80,6 +90,7 @@ type flagData struct {
 	FlagsCarverPlugin string
 	FlagServerCerts   string
 	FlagCarverBlock   string
+	FlagClientCert    string
 }
 
 // GenServerCertsFlag to generate the --tls_server_certs flag
@@ -90,6 +101,14 @@ func GenServerCertsFlag(certificatePath string) string {
 	return GenSingleFlag("servercerts", FlagNameTLSServerCerts, certificatePath)
 }
 
+// GenClientCertFlags to generate the --tls_client_cert and --tls_client_key flags
+func GenClientCertFlags(certPath, keyPath string) string {
+	if certPath == "" || keyPath == "" {
+		return ""
+	}
+	return GenSingleFlag("clientcert", FlagNameTLSClientCert, certPath) + "\n" + GenSingleFlag("clientkey", FlagNameTLSClientKey, keyPath)
+}
+
 // GenCarveBlockSizeFlag to generate the --carver_block_size flag
 func GenCarveBlockSizeFlag(blockSize string) string {
 	if blockSize == "" {
@@ -98,6 +117,24 @@ func GenCarveBlockSizeFlag(blockSize string) string {
 	return GenSingleFlag("blocksize", FlagNameCarverBlockSize, blockSize)
 }
 
+// PlatformFlags fills the placeholders of the flags of an environment with
+// the canonical install paths for a given OS, so the flags can be dropped into
+// /etc/osquery/osctrl-{env}.flags (or the platform equivalent). The client
+// certificate and key are issued for each node and installed next to the
+// secret. sep is the path separator the OS uses.
+//
+// strings.ReplaceAll is deliberate, an operator editing the flags to use a
+// path twice must not get the second placeholder left unsubstituted.
+func PlatformFlags(flags, envName, dir, sep string) string {
+	base := dir + sep + "osctrl-" + envName
+	return strings.NewReplacer(
+		EmptyFlagSecret, base+".secret",
+		EmptyFlagCert, base+".crt",
+		EmptyFlagClientCert, base+".client.crt",
+		EmptyFlagClientKey, base+".client.key",
+	).Replace(flags)
+}
+
 // GenSingleFlag to generate a generic flag to be used by osquery
 func GenSingleFlag(tmplName, flagName, flagValue string) string {
 	data := struct {
@@ -170,6 +207,10 @@ func (environment *EnvManager) GenerateFlags(env TLSEnvironment, secretPath, cer
 	if env.Certificate == "" {
 		flagServerCerts = ""
 	}
+	var flagClientCert string
+	if env.ClientCertAuth {
+		flagClientCert = GenClientCertFlags(EmptyFlagClientCert, EmptyFlagClientKey)
+	}
 	var configFlags, loggerFlags, queryFlags, carverFlags string
 	if osqCfg.Config {
 		configFlags = GenConfigFlags(env)
@@ -191,6 +232,7 @@ func (environment *EnvManager) GenerateFlags(env TLSEnvironment, secretPath, cer
 		FlagsQueryPlugin:  queryFlags,
 		FlagsCarverPlugin: carverFlags,
 		FlagServerCerts:   flagServerCerts,
+		FlagClientCert:    flagClientCert,
 	}
 	return ParseFlagTemplate("flags", FlagsTemplate, data), nil
 }
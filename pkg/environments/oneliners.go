@@ -52,8 +52,21 @@ var validScript = map[string]bool{
 	RemovePowershell: true,
 }
 
+// checkQuickAdd returns an error for quick-add scripts of environments
+// requiring client certificates, because the scripts are the same for all
+// nodes while the certificates are issued for each one
+func checkQuickAdd(target string, environment TLSEnvironment) error {
+	if environment.ClientCertAuth && strings.HasPrefix(target, EnrollTarget) {
+		return ErrClientCertScript
+	}
+	return nil
+}
+
 // PrepareOneLiner generic to generate  one-liners
 func PrepareOneLiner(oneliner string, insecure bool, environment TLSEnvironment, target string) (string, error) {
+	if err := checkQuickAdd(target, environment); err != nil {
+		return "", err
+	}
 	// Determine if insecure TLS is on
 	insecureTLS := ""
 	if insecure {
@@ -124,6 +137,9 @@ func QuickAddScript(project, script string, environment TLSEnvironment) (string,
 	if !validScript[script] {
 		return "", fmt.Errorf("invalid script - %s", script)
 	}
+	if err := checkQuickAdd(script, environment); err != nil {
+		return "", err
+	}
 	var templateName, templateScript string
 	// What script is it?
 	switch script {
@@ -2,8 +2,11 @@ package environments
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+
+	"gorm.io/gorm"
 )
 
 // OsqueryConf to hold the structure for the configuration
@@ -66,29 +69,36 @@ type ATCConf map[string]interface{}
 
 // RefreshConfiguration to take all parts and put them together in the configuration
 func (environment *EnvManager) RefreshConfiguration(idEnv string) error {
+	_, err := environment.RefreshConfigurationBy(idEnv, "", "")
+	return err
+}
+
+// RefreshConfigurationBy to put together the configuration from all parts and
+// store it as a revision by an author, returning the revision
+func (environment *EnvManager) RefreshConfigurationBy(idEnv, author, reason string) (ConfigRevision, error) {
 	env, err := environment.Get(idEnv)
 	if err != nil {
-		return fmt.Errorf("error structuring environment %w", err)
+		return ConfigRevision{}, fmt.Errorf("error structuring environment %w", err)
 	}
 	_options, err := environment.GenStructOptions([]byte(env.Options))
 	if err != nil {
-		return fmt.Errorf("error structuring options %w", err)
+		return ConfigRevision{}, fmt.Errorf("error structuring options %w", err)
 	}
 	_schedule, err := environment.GenStructSchedule([]byte(env.Schedule))
 	if err != nil {
-		return fmt.Errorf("error structuring schedule %w", err)
+		return ConfigRevision{}, fmt.Errorf("error structuring schedule %w", err)
 	}
 	_packs, err := environment.GenStructPacks([]byte(env.Packs))
 	if err != nil {
-		return fmt.Errorf("error structuring packs %w", err)
+		return ConfigRevision{}, fmt.Errorf("error structuring packs %w", err)
 	}
 	_decorators, err := environment.GenStructDecorators([]byte(env.Decorators))
 	if err != nil {
-		return fmt.Errorf("error structuring decorators %w", err)
+		return ConfigRevision{}, fmt.Errorf("error structuring decorators %w", err)
 	}
 	_ATC, err := environment.GenStructATC([]byte(env.ATC))
 	if err != nil {
-		return fmt.Errorf("error structuring ATC %w", err)
+		return ConfigRevision{}, fmt.Errorf("error structuring ATC %w", err)
 	}
 	conf := OsqueryConf{
 		Options:    _options,
@@ -99,24 +109,73 @@ func (environment *EnvManager) RefreshConfiguration(idEnv string) error {
 	}
 	indentedConf, err := environment.GenSerializedConf(conf, true)
 	if err != nil {
-		return fmt.Errorf("error serializing configuration %w", err)
+		return ConfigRevision{}, fmt.Errorf("error serializing configuration %w", err)
 	}
-	if err := environment.DB.Model(&env).Update("configuration", indentedConf).Error; err != nil {
-		return fmt.Errorf("Update configuration %w", err)
-	}
-	return nil
+	return environment.saveConfiguration(env.ID, indentedConf, author, reason)
 }
 
 // UpdateConfiguration to update configuration for an environment
 func (environment *EnvManager) UpdateConfiguration(idEnv string, cnf OsqueryConf) error {
+	_, err := environment.UpdateConfigurationBy(idEnv, cnf, "", "")
+	return err
+}
+
+// UpdateConfigurationBy to update configuration for an environment and store
+// it as a revision by an author, returning the revision
+func (environment *EnvManager) UpdateConfigurationBy(idEnv string, cnf OsqueryConf, author, reason string) (ConfigRevision, error) {
+	env, err := environment.Get(idEnv)
+	if err != nil {
+		return ConfigRevision{}, fmt.Errorf("error getting environment %w", err)
+	}
 	indentedConf, err := environment.GenSerializedConf(cnf, true)
 	if err != nil {
-		return fmt.Errorf("error serializing configuration %w", err)
+		return ConfigRevision{}, fmt.Errorf("error serializing configuration %w", err)
 	}
-	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Update("configuration", indentedConf).Error; err != nil {
-		return fmt.Errorf("Update configuration %w", err)
+	return environment.saveConfiguration(env.ID, indentedConf, author, reason)
+}
+
+// saveConfiguration updates the configuration of an environment and stores it
+// as a revision in one transaction. Changes are rejected while a rollout is
+// pending, since nodes are served the revisions of the rollout meanwhile.
+func (environment *EnvManager) saveConfiguration(envID uint, configuration, author, reason string) (ConfigRevision, error) {
+	var rev ConfigRevision
+	err := environment.DB.Transaction(func(tx *gorm.DB) error {
+		latest, err := latestRevisionTx(tx, envID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("latest revision %w", err)
+		}
+		if err != nil || latest.Hash != ConfigHash(configuration) {
+			if err := pendingRolloutTx(tx, envID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&TLSEnvironment{}).Where("id = ?", envID).Update("configuration", configuration).Error; err != nil {
+			return fmt.Errorf("Update configuration %w", err)
+		}
+		rev, err = recordRevisionTx(tx, envID, configuration, author, reason, 0)
+		return err
+	})
+	return rev, err
+}
+
+// serializeParts serializes the parts of a configuration, as the columns of
+// an environment to update
+func (environment *EnvManager) serializeParts(cnf OsqueryConf) (map[string]interface{}, error) {
+	parts := map[string]interface{}{}
+	for column, part := range map[string]interface{}{
+		"options":    cnf.Options,
+		"schedule":   cnf.Schedule,
+		"packs":      cnf.Packs,
+		"decorators": cnf.Decorators,
+		"atc":        cnf.ATC,
+	} {
+		serialized, err := environment.GenSerializedConf(part, true)
+		if err != nil {
+			return nil, fmt.Errorf("error serializing %s %w", column, err)
+		}
+		parts[column] = serialized
 	}
-	return nil
+	return parts, nil
 }
 
 // UpdateConfigurationParts to update all the configuration parts for an environment
@@ -298,11 +357,14 @@ func (environment *EnvManager) GenEmptyConfiguration(indent bool) string {
 }
 
 // AddOptionsConf to add an osquery option to the configuration
-func (environment *EnvManager) AddOptionsConf(name, option string, value interface{}) error {
+func (environment *EnvManager) AddOptionsConf(name, option string, value interface{}, author string) error {
 	env, err := environment.Get(name)
 	if err != nil {
 		return fmt.Errorf("error getting environment %w", err)
 	}
+	if err := environment.CheckNoPendingRollout(env.ID); err != nil {
+		return err
+	}
 	// Parse options into struct
 	_options, err := environment.GenStructOptions([]byte(env.Options))
 	if err != nil {
@@ -320,18 +382,21 @@ func (environment *EnvManager) AddOptionsConf(name, option string, value interfa
 		return fmt.Errorf("error updating options %w", err)
 	}
 	// Refresh all configuration
-	if err := environment.RefreshConfiguration(name); err != nil {
+	if _, err := environment.RefreshConfigurationBy(name, author, fmt.Sprintf("add option %s", option)); err != nil {
 		return fmt.Errorf("error refreshing configuration %w", err)
 	}
 	return nil
 }
 
 // RemoveOptionsConf to remove an osquery option from the configuration
-func (environment *EnvManager) RemoveOptionsConf(name, option string) error {
+func (environment *EnvManager) RemoveOptionsConf(name, option string, author string) error {
 	env, err := environment.Get(name)
 	if err != nil {
 		return fmt.Errorf("error getting environment %w", err)
 	}
+	if err := environment.CheckNoPendingRollout(env.ID); err != nil {
+		return err
+	}
 	// Parse options into struct
 	_options, err := environment.GenStructOptions([]byte(env.Options))
 	if err != nil {
@@ -349,18 +414,21 @@ func (environment *EnvManager) RemoveOptionsConf(name, option string) error {
 		return fmt.Errorf("error updating options %w", err)
 	}
 	// Refresh all configuration
-	if err := environment.RefreshConfiguration(name); err != nil {
+	if _, err := environment.RefreshConfigurationBy(name, author, fmt.Sprintf("remove option %s", option)); err != nil {
 		return fmt.Errorf("error refreshing configuration %w", err)
 	}
 	return nil
 }
 
 // AddScheduleConfQuery to add a new query to the osquery schedule
-func (environment *EnvManager) AddScheduleConfQuery(name, qName string, query ScheduleQuery) error {
+func (environment *EnvManager) AddScheduleConfQuery(name, qName string, query ScheduleQuery, author string) error {
 	env, err := environment.Get(name)
 	if err != nil {
 		return fmt.Errorf("error getting environment %w", err)
 	}
+	if err := environment.CheckNoPendingRollout(env.ID); err != nil {
+		return err
+	}
 	// Parse schedule into struct
 	_schedule, err := environment.GenStructSchedule([]byte(env.Schedule))
 	if err != nil {
@@ -378,18 +446,21 @@ func (environment *EnvManager) AddScheduleConfQuery(name, qName string, query Sc
 		return fmt.Errorf("error updating schedule %w", err)
 	}
 	// Refresh all configuration
-	if err := environment.RefreshConfiguration(name); err != nil {
+	if _, err := environment.RefreshConfigurationBy(name, author, fmt.Sprintf("add query %s to the schedule", qName)); err != nil {
 		return fmt.Errorf("error refreshing configuration %w", err)
 	}
 	return nil
 }
 
 // AddScheduleConfQueries merges multiple queries into the osquery schedule.
-func (environment *EnvManager) AddScheduleConfQueries(name string, queries ScheduleConf) error {
+func (environment *EnvManager) AddScheduleConfQueries(name string, queries ScheduleConf, author string) error {
 	env, err := environment.Get(name)
 	if err != nil {
 		return fmt.Errorf("error getting environment %w", err)
 	}
+	if err := environment.CheckNoPendingRollout(env.ID); err != nil {
+		return err
+	}
 	// Parse schedule into struct
 	_schedule, err := environment.GenStructSchedule([]byte(env.Schedule))
 	if err != nil {
@@ -409,18 +480,21 @@ func (environment *EnvManager) AddScheduleConfQueries(name string, queries Sched
 		return fmt.Errorf("error updating schedule %w", err)
 	}
 	// Refresh all configuration
-	if err := environment.RefreshConfiguration(name); err != nil {
+	if _, err := environment.RefreshConfigurationBy(name, author, fmt.Sprintf("add %d queries to the schedule", len(queries))); err != nil {
 		return fmt.Errorf("error refreshing configuration %w", err)
 	}
 	return nil
 }
 
 // RemoveScheduleConfQuery to remove a query from the osquery schedule
-func (environment *EnvManager) RemoveScheduleConfQuery(name, qName string) error {
+func (environment *EnvManager) RemoveScheduleConfQuery(name, qName string, author string) error {
 	env, err := environment.Get(name)
 	if err != nil {
 		return fmt.Errorf("error getting environment %w", err)
 	}
+	if err := environment.CheckNoPendingRollout(env.ID); err != nil {
+		return err
+	}
 	// Parse schedule into struct
 	_schedule, err := environment.GenStructSchedule([]byte(env.Schedule))
 	if err != nil {
@@ -438,18 +512,21 @@ func (environment *EnvManager) RemoveScheduleConfQuery(name, qName string) error
 		return fmt.Errorf("error updating schedule %w", err)
 	}
 	// Refresh all configuration
-	if err := environment.RefreshConfiguration(name); err != nil {
+	if _, err := environment.RefreshConfigurationBy(name, author, fmt.Sprintf("remove query %s from the schedule", qName)); err != nil {
 		return fmt.Errorf("error refreshing configuration %w", err)
 	}
 	return nil
 }
 
 // AddQueryPackConf to add a new query pack to the osquery configuration
-func (environment *EnvManager) AddQueryPackConf(name, pName string, pack interface{}) error {
+func (environment *EnvManager) AddQueryPackConf(name, pName string, pack interface{}, author string) error {
 	env, err := environment.Get(name)
 	if err != nil {
 		return fmt.Errorf("error getting environment %w", err)
 	}
+	if err := environment.CheckNoPendingRollout(env.ID); err != nil {
+		return err
+	}
 	// Parse packs into struct
 	_packs, err := environment.GenStructPacks([]byte(env.Packs))
 	if err != nil {
@@ -467,18 +544,21 @@ func (environment *EnvManager) AddQueryPackConf(name, pName string, pack interfa
 		return fmt.Errorf("error updating packs %w", err)
 	}
 	// Refresh all configuration
-	if err := environment.RefreshConfiguration(name); err != nil {
+	if _, err := environment.RefreshConfigurationBy(name, author, fmt.Sprintf("add pack %s", pName)); err != nil {
 		return fmt.Errorf("error refreshing configuration %w", err)
 	}
 	return nil
 }
 
 // RemoveQueryPackConf to add a new query pack to the osquery configuration
-func (environment *EnvManager) RemoveQueryPackConf(name, pName string) error {
+func (environment *EnvManager) RemoveQueryPackConf(name, pName string, author string) error {
 	env, err := environment.Get(name)
 	if err != nil {
 		return fmt.Errorf("error getting environment %w", err)
 	}
+	if err := environment.CheckNoPendingRollout(env.ID); err != nil {
+		return err
+	}
 	// Parse packs into struct
 	_packs, err := environment.GenStructPacks([]byte(env.Packs))
 	if err != nil {
@@ -496,18 +576,21 @@ func (environment *EnvManager) RemoveQueryPackConf(name, pName string) error {
 		return fmt.Errorf("error updating packs %w", err)
 	}
 	// Refresh all configuration
-	if err := environment.RefreshConfiguration(name); err != nil {
+	if _, err := environment.RefreshConfigurationBy(name, author, fmt.Sprintf("remove pack %s", pName)); err != nil {
 		return fmt.Errorf("error refreshing configuration %w", err)
 	}
 	return nil
 }
 
 // AddQueryToPackConf to add a new query to an existing pack in the osquery configuration
-func (environment *EnvManager) AddQueryToPackConf(name, pName, qName string, query ScheduleQuery) error {
+func (environment *EnvManager) AddQueryToPackConf(name, pName, qName string, query ScheduleQuery, author string) error {
 	env, err := environment.Get(name)
 	if err != nil {
 		return fmt.Errorf("error getting environment %w", err)
 	}
+	if err := environment.CheckNoPendingRollout(env.ID); err != nil {
+		return err
+	}
 	// Parse packs into struct
 	_packs, err := environment.GenStructPacks([]byte(env.Packs))
 	if err != nil {
@@ -527,18 +610,21 @@ func (environment *EnvManager) AddQueryToPackConf(name, pName, qName string, que
 		return fmt.Errorf("error updating packs %w", err)
 	}
 	// Refresh all configuration
-	if err := environment.RefreshConfiguration(name); err != nil {
+	if _, err := environment.RefreshConfigurationBy(name, author, fmt.Sprintf("add query %s to pack %s", qName, pName)); err != nil {
 		return fmt.Errorf("error refreshing configuration %w", err)
 	}
 	return nil
 }
 
 // RemoveQueryFromPackConf to remove a query from an existing query pack in the osquery configuration
-func (environment *EnvManager) RemoveQueryFromPackConf(name, pName, qName string) error {
+func (environment *EnvManager) RemoveQueryFromPackConf(name, pName, qName string, author string) error {
 	env, err := environment.Get(name)
 	if err != nil {
 		return fmt.Errorf("error getting environment %w", err)
 	}
+	if err := environment.CheckNoPendingRollout(env.ID); err != nil {
+		return err
+	}
 	// Parse packs into struct
 	_packs, err := environment.GenStructPacks([]byte(env.Packs))
 	if err != nil {
@@ -558,7 +644,7 @@ func (environment *EnvManager) RemoveQueryFromPackConf(name, pName, qName string
 		return fmt.Errorf("error updating packs %w", err)
 	}
 	// Refresh all configuration
-	if err := environment.RefreshConfiguration(name); err != nil {
+	if _, err := environment.RefreshConfigurationBy(name, author, fmt.Sprintf("remove query %s from pack %s", qName, pName)); err != nil {
 		return fmt.Errorf("error refreshing configuration %w", err)
 	}
 	return nil
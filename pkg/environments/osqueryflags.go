@@ -0,0 +1,172 @@
+package environments
+
+const (
+	// OsqueryFlagBool for boolean osquery flags
+	OsqueryFlagBool string = "bool"
+	// OsqueryFlagInt for integer osquery flags
+	OsqueryFlagInt string = "int"
+	// OsqueryFlagDouble for floating point osquery flags
+	OsqueryFlagDouble string = "double"
+	// OsqueryFlagString for string osquery flags
+	OsqueryFlagString string = "string"
+	// OsqueryCustomOptionPrefix for options osquery accepts without a flag
+	OsqueryCustomOptionPrefix string = "custom_"
+)
+
+// OsqueryFlag to describe one osquery CLI flag or configuration option
+// https://osquery.readthedocs.io/en/stable/installation/cli-flags/
+type OsqueryFlag struct {
+	Type string
+	// Since is the first osquery version with the flag, empty when every
+	// supported osquery version has it
+	Since string
+	// CLIOnly flags are read at startup and ignored in the options of
+	// the configuration
+	CLIOnly bool
+	// Values accepted by the flag, any value when empty
+	Values []string
+}
+
+// OsqueryFlags is the catalog of known osquery flags
+var OsqueryFlags = map[string]OsqueryFlag{
+	// Configuration control
+	"config_plugin":                  {Type: OsqueryFlagString, CLIOnly: true},
+	"config_path":                    {Type: OsqueryFlagString, CLIOnly: true},
+	"config_refresh":                 {Type: OsqueryFlagInt},
+	"config_accelerated_refresh":     {Type: OsqueryFlagInt},
+	"config_check":                   {Type: OsqueryFlagBool, CLIOnly: true},
+	"config_dump":                    {Type: OsqueryFlagBool, CLIOnly: true},
+	"config_enable_backup":           {Type: OsqueryFlagBool},
+	"config_tls_endpoint":            {Type: OsqueryFlagString},
+	"config_tls_refresh":             {Type: OsqueryFlagInt},
+	"config_tls_max_attempts":        {Type: OsqueryFlagInt},
+	"config_tls_accelerated_refresh": {Type: OsqueryFlagInt},
+	"flagfile":                       {Type: OsqueryFlagString, CLIOnly: true},
+	"pack_delimiter":                 {Type: OsqueryFlagString},
+	"pack_refresh_interval":          {Type: OsqueryFlagInt},
+	// Daemon control
+	"daemonize":                      {Type: OsqueryFlagBool, CLIOnly: true},
+	"database_path":                  {Type: OsqueryFlagString, CLIOnly: true},
+	"disable_database":               {Type: OsqueryFlagBool, CLIOnly: true},
+	"force":                          {Type: OsqueryFlagBool, CLIOnly: true},
+	"pidfile":                        {Type: OsqueryFlagString, CLIOnly: true},
+	"host_identifier":                {Type: OsqueryFlagString, Values: []string{"hostname", "uuid", "instance", "ephemeral", "specified"}},
+	"specified_identifier":           {Type: OsqueryFlagString},
+	"utc":                            {Type: OsqueryFlagBool},
+	"verbose":                        {Type: OsqueryFlagBool},
+	"worker_threads":                 {Type: OsqueryFlagInt},
+	"table_delay":                    {Type: OsqueryFlagInt},
+	"read_max":                       {Type: OsqueryFlagInt},
+	"hash_cache_max":                 {Type: OsqueryFlagInt},
+	"hash_delay":                     {Type: OsqueryFlagInt},
+	"disable_caching":                {Type: OsqueryFlagBool},
+	"disable_tables":                 {Type: OsqueryFlagString},
+	"enable_tables":                  {Type: OsqueryFlagString},
+	"disable_watchdog":               {Type: OsqueryFlagBool, CLIOnly: true},
+	"watchdog_level":                 {Type: OsqueryFlagInt},
+	"watchdog_memory_limit":          {Type: OsqueryFlagInt},
+	"watchdog_utilization_limit":     {Type: OsqueryFlagInt},
+	"watchdog_delay":                 {Type: OsqueryFlagInt},
+	"watchdog_latency_limit":         {Type: OsqueryFlagInt},
+	"watchdog_forced_shutdown_delay": {Type: OsqueryFlagInt},
+	"enable_numeric_monitoring":      {Type: OsqueryFlagBool},
+	"numeric_monitoring_plugins":     {Type: OsqueryFlagString},
+	"numeric_monitoring_pre_aggregation_time": {Type: OsqueryFlagInt},
+	"augeas_lenses": {Type: OsqueryFlagString},
+	"yara_delay":    {Type: OsqueryFlagInt},
+	// Extensions
+	"disable_extensions":       {Type: OsqueryFlagBool, CLIOnly: true},
+	"extensions_autoload":      {Type: OsqueryFlagString, CLIOnly: true},
+	"extensions_socket":        {Type: OsqueryFlagString, CLIOnly: true},
+	"extensions_timeout":       {Type: OsqueryFlagInt},
+	"extensions_interval":      {Type: OsqueryFlagInt},
+	"extensions_require":       {Type: OsqueryFlagString},
+	"extensions_default_index": {Type: OsqueryFlagBool},
+	// Remote settings
+	"tls_hostname":            {Type: OsqueryFlagString},
+	"tls_server_certs":        {Type: OsqueryFlagString},
+	"tls_client_cert":         {Type: OsqueryFlagString},
+	"tls_client_key":          {Type: OsqueryFlagString},
+	"tls_session_reuse":       {Type: OsqueryFlagBool},
+	"tls_session_timeout":     {Type: OsqueryFlagInt},
+	"tls_dump":                {Type: OsqueryFlagBool},
+	"tls_enroll_max_attempts": {Type: OsqueryFlagInt},
+	"tls_enroll_max_interval": {Type: OsqueryFlagInt},
+	"proxy_hostname":          {Type: OsqueryFlagString},
+	"disable_enrollment":      {Type: OsqueryFlagBool},
+	"enroll_always":           {Type: OsqueryFlagBool},
+	"enroll_secret_path":      {Type: OsqueryFlagString, CLIOnly: true},
+	"enroll_secret_env":       {Type: OsqueryFlagString, CLIOnly: true},
+	"enroll_tls_endpoint":     {Type: OsqueryFlagString},
+	// Distributed queries
+	"disable_distributed":            {Type: OsqueryFlagBool},
+	"distributed_plugin":             {Type: OsqueryFlagString},
+	"distributed_interval":           {Type: OsqueryFlagInt},
+	"distributed_tls_max_attempts":   {Type: OsqueryFlagInt},
+	"distributed_tls_read_endpoint":  {Type: OsqueryFlagString},
+	"distributed_tls_write_endpoint": {Type: OsqueryFlagString},
+	"distributed_denylist_duration":  {Type: OsqueryFlagInt, Since: "4.6.0"},
+	"distributed_loginfo":            {Type: OsqueryFlagBool},
+	// File carving
+	"disable_carver":           {Type: OsqueryFlagBool},
+	"carver_disable_function":  {Type: OsqueryFlagBool},
+	"carver_start_endpoint":    {Type: OsqueryFlagString},
+	"carver_continue_endpoint": {Type: OsqueryFlagString},
+	"carver_block_size":        {Type: OsqueryFlagInt},
+	"carver_compression":       {Type: OsqueryFlagBool},
+	// Scheduling
+	"schedule_splay_percent":    {Type: OsqueryFlagInt},
+	"schedule_default_interval": {Type: OsqueryFlagInt},
+	"schedule_timeout":          {Type: OsqueryFlagInt},
+	"schedule_max_drift":        {Type: OsqueryFlagInt},
+	"schedule_reload":           {Type: OsqueryFlagInt},
+	"schedule_epoch":            {Type: OsqueryFlagInt},
+	// Logging
+	"disable_logging":            {Type: OsqueryFlagBool},
+	"logger_plugin":              {Type: OsqueryFlagString},
+	"logger_path":                {Type: OsqueryFlagString, CLIOnly: true},
+	"logger_mode":                {Type: OsqueryFlagString},
+	"logger_rotate":              {Type: OsqueryFlagBool},
+	"logger_rotate_size":         {Type: OsqueryFlagInt},
+	"logger_rotate_max_files":    {Type: OsqueryFlagInt},
+	"logger_stderr":              {Type: OsqueryFlagBool},
+	"logger_min_status":          {Type: OsqueryFlagInt},
+	"logger_min_stderr":          {Type: OsqueryFlagInt},
+	"logger_event_type":          {Type: OsqueryFlagBool},
+	"logger_snapshot_event_type": {Type: OsqueryFlagBool},
+	"logger_numerics":            {Type: OsqueryFlagBool},
+	"logger_tls_endpoint":        {Type: OsqueryFlagString},
+	"logger_tls_period":          {Type: OsqueryFlagInt},
+	"logger_tls_compress":        {Type: OsqueryFlagBool},
+	"logger_tls_max_lines":       {Type: OsqueryFlagInt},
+	"logger_tls_max_linesize":    {Type: OsqueryFlagInt},
+	"buffered_log_max":           {Type: OsqueryFlagInt},
+	"log_result_events":          {Type: OsqueryFlagBool},
+	"enable_syslog":              {Type: OsqueryFlagBool},
+	"syslog_pipe_path":           {Type: OsqueryFlagString},
+	// Events
+	"disable_events":                   {Type: OsqueryFlagBool},
+	"events_expiry":                    {Type: OsqueryFlagInt},
+	"events_max":                       {Type: OsqueryFlagInt},
+	"events_optimize":                  {Type: OsqueryFlagBool},
+	"events_enforce_denylist":          {Type: OsqueryFlagBool, Since: "4.6.0"},
+	"enable_file_events":               {Type: OsqueryFlagBool},
+	"disable_audit":                    {Type: OsqueryFlagBool},
+	"audit_allow_config":               {Type: OsqueryFlagBool},
+	"audit_persist":                    {Type: OsqueryFlagBool},
+	"audit_allow_process_events":       {Type: OsqueryFlagBool},
+	"audit_allow_sockets":              {Type: OsqueryFlagBool},
+	"audit_allow_user_events":          {Type: OsqueryFlagBool},
+	"audit_allow_fim_events":           {Type: OsqueryFlagBool},
+	"audit_backlog_limit":              {Type: OsqueryFlagInt},
+	"enable_bpf_events":                {Type: OsqueryFlagBool, Since: "4.6.0"},
+	"bpf_perf_event_array_exp":         {Type: OsqueryFlagInt, Since: "4.6.0"},
+	"bpf_buffer_storage_size":          {Type: OsqueryFlagInt, Since: "4.6.0"},
+	"enable_keyboard_events":           {Type: OsqueryFlagBool},
+	"enable_mouse_events":              {Type: OsqueryFlagBool},
+	"disable_endpointsecurity":         {Type: OsqueryFlagBool, Since: "5.0.1"},
+	"disable_endpointsecurity_fim":     {Type: OsqueryFlagBool, Since: "5.3.0"},
+	"enable_windows_events_publisher":  {Type: OsqueryFlagBool, Since: "4.7.0"},
+	"enable_windows_events_subscriber": {Type: OsqueryFlagBool, Since: "4.7.0"},
+	"windows_event_channels":           {Type: OsqueryFlagString},
+}
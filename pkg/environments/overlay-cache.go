@@ -0,0 +1,111 @@
+package environments
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/cache"
+)
+
+const (
+	overlaysCacheName = "overlays"
+	mergedCacheName   = "overlay-configurations"
+	// overlaysCacheTTL is how long the overlays of an environment are kept
+	// before they are read again from the database. There is no invalidation
+	// signal across processes for overlays, so changes made by osctrl-api are
+	// served by osctrl-tls after at most this long.
+	overlaysCacheTTL = time.Minute
+	// mergedCacheTTL is how long a merged configuration is kept. Keys include
+	// the base configuration and the versions of the overlays, so entries are
+	// never stale and this only bounds memory.
+	mergedCacheTTL = 10 * time.Minute
+)
+
+// OverlayCache merges configuration overlays onto the configuration of
+// environments, caching the merged result by the set of overlays of the node
+type OverlayCache struct {
+	overlays *cache.MemoryCache[[]ConfigOverlay]
+	merged   *cache.MemoryCache[string]
+	envs     EnvManager
+}
+
+// NewOverlayCache creates a new overlay cache
+func NewOverlayCache(envs EnvManager) *OverlayCache {
+	return &OverlayCache{
+		overlays: cache.NewMemoryCache(
+			cache.WithCleanupInterval[[]ConfigOverlay](overlaysCacheTTL),
+			cache.WithName[[]ConfigOverlay](overlaysCacheName),
+		),
+		merged: cache.NewMemoryCache(
+			cache.WithCleanupInterval[string](mergedCacheTTL),
+			cache.WithName[string](mergedCacheName),
+		),
+		envs: envs,
+	}
+}
+
+// EnvOverlays retrieves the overlays of an environment, using cache when available
+func (oc *OverlayCache) EnvOverlays(ctx context.Context, envID uint) ([]ConfigOverlay, error) {
+	key := fmt.Sprint(envID)
+	if overlays, found := oc.overlays.Get(ctx, key); found {
+		return overlays, nil
+	}
+	overlays, err := oc.envs.Overlays(envID)
+	if err != nil {
+		return nil, err
+	}
+	oc.overlays.Set(ctx, key, overlays, overlaysCacheTTL)
+	return overlays, nil
+}
+
+// NodeConfiguration returns the configuration of an environment with the
+// overlays for the platforms and tags of a node merged onto it, and the names
+// of those overlays. Tags are only retrieved when some overlay targets tags.
+func (oc *OverlayCache) NodeConfiguration(ctx context.Context, env TLSEnvironment, platforms []string, tags func() ([]string, error)) (string, []string, error) {
+	overlays, err := oc.EnvOverlays(ctx, env.ID)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(overlays) == 0 {
+		return env.Configuration, []string{}, nil
+	}
+	var nodeTags []string
+	for _, overlay := range overlays {
+		if overlay.TargetType == OverlayTargetTag && tags != nil {
+			if nodeTags, err = tags(); err != nil {
+				return "", nil, err
+			}
+			break
+		}
+	}
+	matched := MatchOverlays(overlays, platforms, nodeTags)
+	names := make([]string, 0, len(matched))
+	versions := make([]string, 0, len(matched))
+	for _, overlay := range matched {
+		names = append(names, overlay.Name)
+		versions = append(versions, fmt.Sprintf("%d@%d", overlay.ID, overlay.UpdatedAt.UnixNano()))
+	}
+	if len(matched) == 0 {
+		return env.Configuration, names, nil
+	}
+	sum := sha256.Sum256([]byte(env.Configuration))
+	key := env.UUID + ":" + hex.EncodeToString(sum[:8]) + ":" + strings.Join(versions, ",")
+	if conf, found := oc.merged.Get(ctx, key); found {
+		return conf, names, nil
+	}
+	conf, err := ApplyOverlays(env.Configuration, matched)
+	if err != nil {
+		return "", nil, err
+	}
+	oc.merged.Set(ctx, key, conf, mergedCacheTTL)
+	return conf, names, nil
+}
+
+// Invalidate removes the cached overlays of an environment
+func (oc *OverlayCache) Invalidate(ctx context.Context, envID uint) {
+	oc.overlays.Delete(ctx, fmt.Sprint(envID))
+}
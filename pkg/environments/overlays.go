@@ -0,0 +1,228 @@
+package environments
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Targets of configuration overlays
+const (
+	OverlayTargetTag      = "tag"
+	OverlayTargetPlatform = "platform"
+)
+
+// ConfigOverlay is a partial osquery configuration merged onto the
+// configuration of an environment for the nodes with a tag or a platform.
+// Overlays are applied by ascending priority, so higher priorities win.
+type ConfigOverlay struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	EnvironmentID uint      `gorm:"uniqueIndex:idx_config_overlays_env_name" json:"environment_id"`
+	Name          string    `gorm:"uniqueIndex:idx_config_overlays_env_name" json:"name"`
+	TargetType    string    `json:"target_type"`
+	Target        string    `json:"target"`
+	Priority      int       `json:"priority"`
+	Configuration string    `json:"configuration"`
+	CreatedBy     string    `json:"created_by"`
+}
+
+// ValidateOverlay checks the target and the configuration of an overlay
+func ValidateOverlay(overlay ConfigOverlay) error {
+	if strings.TrimSpace(overlay.Name) == "" {
+		return fmt.Errorf("overlay name is required")
+	}
+	if overlay.TargetType != OverlayTargetTag && overlay.TargetType != OverlayTargetPlatform {
+		return fmt.Errorf("invalid target type %q, it must be %q or %q", overlay.TargetType, OverlayTargetTag, OverlayTargetPlatform)
+	}
+	if strings.TrimSpace(overlay.Target) == "" {
+		return fmt.Errorf("overlay target is required")
+	}
+	if _, err := decodeConfiguration([]byte(overlay.Configuration)); err != nil {
+		return fmt.Errorf("invalid overlay configuration %w", err)
+	}
+	return nil
+}
+
+// ValidateOverlayConfig to validate the options, packs and ATC of the
+// configuration of an overlay, the same way as the configuration of an
+// environment
+func ValidateOverlayConfig(overlay ConfigOverlay, versions []string) ConfigValidation {
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(overlay.Configuration), &sections); err != nil {
+		var v ConfigValidation
+		v.errorf("configuration", "", "not a JSON object: %v", err)
+		return v
+	}
+	return ValidateConfigParts(string(sections["options"]), string(sections["packs"]), string(sections["auto_table_construction"]), versions)
+}
+
+// Overlays to retrieve the configuration overlays of an environment, in the
+// order they are applied
+func (environment *EnvManager) Overlays(envID uint) ([]ConfigOverlay, error) {
+	var overlays []ConfigOverlay
+	if err := environment.DB.Where("environment_id = ?", envID).Order("priority, name").Find(&overlays).Error; err != nil {
+		return overlays, fmt.Errorf("overlays %w", err)
+	}
+	return overlays, nil
+}
+
+// GetOverlay to retrieve a configuration overlay of an environment by name
+func (environment *EnvManager) GetOverlay(envID uint, name string) (ConfigOverlay, error) {
+	var overlay ConfigOverlay
+	if err := environment.DB.Where("environment_id = ? AND name = ?", envID, name).First(&overlay).Error; err != nil {
+		return overlay, err
+	}
+	return overlay, nil
+}
+
+// SetOverlay creates a configuration overlay or replaces the one with the same name
+func (environment *EnvManager) SetOverlay(overlay ConfigOverlay) (ConfigOverlay, error) {
+	if err := ValidateOverlay(overlay); err != nil {
+		return overlay, err
+	}
+	existing, err := environment.GetOverlay(overlay.EnvironmentID, overlay.Name)
+	if err == nil {
+		overlay.ID = existing.ID
+		overlay.CreatedAt = existing.CreatedAt
+		overlay.CreatedBy = existing.CreatedBy
+	} else if err != gorm.ErrRecordNotFound {
+		return overlay, fmt.Errorf("overlay %w", err)
+	}
+	if err := environment.DB.Save(&overlay).Error; err != nil {
+		return overlay, fmt.Errorf("Save overlay %w", err)
+	}
+	return overlay, nil
+}
+
+// DeleteOverlay to remove a configuration overlay of an environment
+func (environment *EnvManager) DeleteOverlay(envID uint, name string) error {
+	result := environment.DB.Where("environment_id = ? AND name = ?", envID, name).Delete(&ConfigOverlay{})
+	if result.Error != nil {
+		return fmt.Errorf("Delete overlay %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// MatchOverlays returns the overlays that apply to a node with any of the
+// platforms, like the osquery platform and its family, and any of the tags
+func MatchOverlays(overlays []ConfigOverlay, platforms, tags []string) []ConfigOverlay {
+	matched := []ConfigOverlay{}
+	for _, overlay := range overlays {
+		values := tags
+		if overlay.TargetType == OverlayTargetPlatform {
+			values = platforms
+		}
+		for _, v := range values {
+			if strings.EqualFold(v, overlay.Target) {
+				matched = append(matched, overlay)
+				break
+			}
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if matched[i].Priority == matched[j].Priority {
+			return matched[i].Name < matched[j].Name
+		}
+		return matched[i].Priority < matched[j].Priority
+	})
+	return matched
+}
+
+// ApplyOverlays merges overlays in order onto a serialized configuration
+func ApplyOverlays(base string, overlays []ConfigOverlay) (string, error) {
+	if len(overlays) == 0 {
+		return base, nil
+	}
+	merged, err := decodeConfiguration([]byte(base))
+	if err != nil {
+		return "", fmt.Errorf("error parsing configuration %w", err)
+	}
+	for _, overlay := range overlays {
+		partial, err := decodeConfiguration([]byte(overlay.Configuration))
+		if err != nil {
+			return "", fmt.Errorf("error parsing overlay %s %w", overlay.Name, err)
+		}
+		mergeConfiguration(merged, partial)
+	}
+	indented, err := json.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error serializing configuration %w", err)
+	}
+	return string(indented), nil
+}
+
+// decodeConfiguration parses a configuration keeping numbers as they are
+func decodeConfiguration(raw []byte) (map[string]interface{}, error) {
+	conf := map[string]interface{}{}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return conf, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// mergeConfiguration merges a partial configuration onto another. Entries of
+// options, schedule, packs and auto table construction replace the ones with
+// the same name, decorator queries are added and other sections are replaced.
+func mergeConfiguration(base, partial map[string]interface{}) {
+	for section, value := range partial {
+		switch section {
+		case "options", "schedule", "packs", "auto_table_construction":
+			entries, ok := value.(map[string]interface{})
+			current, isMap := base[section].(map[string]interface{})
+			if !ok || !isMap {
+				base[section] = value
+				continue
+			}
+			for name, entry := range entries {
+				current[name] = entry
+			}
+		case "decorators":
+			decorators, ok := value.(map[string]interface{})
+			current, isMap := base[section].(map[string]interface{})
+			if !ok || !isMap {
+				base[section] = value
+				continue
+			}
+			for kind, queries := range decorators {
+				added, isList := queries.([]interface{})
+				existing, hasList := current[kind].([]interface{})
+				if !isList || !hasList {
+					current[kind] = queries
+					continue
+				}
+				for _, q := range added {
+					if !containsValue(existing, q) {
+						existing = append(existing, q)
+					}
+				}
+				current[kind] = existing
+			}
+		default:
+			base[section] = value
+		}
+	}
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package environments
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyOverlaysMergesSectionsByPriority(t *testing.T) {
+	base := `{
+		"options": {"logger_tls_period": 10, "host_identifier": "uuid"},
+		"schedule": {"processes": {"query": "SELECT * FROM processes;", "interval": 3600}},
+		"decorators": {"load": ["SELECT uuid FROM system_info;"], "interval": {"3600": ["SELECT 1;"]}},
+		"file_paths": {"etc": ["/etc/%%"]}
+	}`
+	overlays := MatchOverlays([]ConfigOverlay{
+		{Name: "pci-late", TargetType: OverlayTargetTag, Target: "pci", Priority: 10,
+			Configuration: `{"schedule": {"processes": {"query": "SELECT pid FROM processes;", "interval": 60}}}`},
+		{Name: "pci", TargetType: OverlayTargetTag, Target: "PCI", Priority: 1,
+			Configuration: `{"options": {"logger_tls_period": 5}, "schedule": {"sockets": {"query": "SELECT * FROM process_open_sockets;", "interval": 300}}, "decorators": {"load": ["SELECT uuid FROM system_info;", "SELECT hostname FROM system_info;"]}, "file_paths": {"home": ["/home/%%"]}}`},
+		{Name: "laptops", TargetType: OverlayTargetPlatform, Target: "darwin",
+			Configuration: `{"schedule": {"battery": {"query": "SELECT * FROM battery;", "interval": 600}}}`},
+	}, []string{"ubuntu", "linux"}, []string{"pci"})
+	require.Len(t, overlays, 2)
+	require.Equal(t, "pci", overlays[0].Name)
+
+	merged, err := ApplyOverlays(base, overlays)
+	require.NoError(t, err)
+	var conf map[string]map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(merged), &conf))
+	require.Equal(t, float64(5), conf["options"]["logger_tls_period"])
+	require.Equal(t, "uuid", conf["options"]["host_identifier"])
+	require.Contains(t, conf["schedule"], "sockets")
+	require.NotContains(t, conf["schedule"], "battery")
+	require.Equal(t, float64(60), conf["schedule"]["processes"].(map[string]interface{})["interval"])
+	require.Len(t, conf["decorators"]["load"], 2)
+	require.Contains(t, conf["decorators"], "interval")
+	require.Equal(t, map[string]interface{}{"home": []interface{}{"/home/%%"}}, conf["file_paths"])
+
+	unchanged, err := ApplyOverlays(base, nil)
+	require.NoError(t, err)
+	require.Equal(t, base, unchanged)
+}
+
+func TestSetOverlayValidatesAndReplaces(t *testing.T) {
+	db := setupTestDB(t)
+	envs := CreateEnvironment(db)
+	env := envs.Empty("dev", "dev.example.com")
+	require.NoError(t, envs.Create(&env))
+
+	_, err := envs.SetOverlay(ConfigOverlay{EnvironmentID: env.ID, Name: "servers", TargetType: "hostname", Target: "web", Configuration: `{}`})
+	require.Error(t, err)
+	_, err = envs.SetOverlay(ConfigOverlay{EnvironmentID: env.ID, Name: "servers", TargetType: OverlayTargetTag, Target: "server", Configuration: `not json`})
+	require.Error(t, err)
+
+	created, err := envs.SetOverlay(ConfigOverlay{EnvironmentID: env.ID, Name: "servers", TargetType: OverlayTargetTag, Target: "server", Configuration: `{"options":{"logger_tls_period":60}}`, CreatedBy: "alice"})
+	require.NoError(t, err)
+	replaced, err := envs.SetOverlay(ConfigOverlay{EnvironmentID: env.ID, Name: "servers", TargetType: OverlayTargetPlatform, Target: "linux", Priority: 2, Configuration: `{"options":{"logger_tls_period":30}}`, CreatedBy: "bob"})
+	require.NoError(t, err)
+	require.Equal(t, created.ID, replaced.ID)
+	require.Equal(t, "alice", replaced.CreatedBy)
+
+	overlays, err := envs.Overlays(env.ID)
+	require.NoError(t, err)
+	require.Len(t, overlays, 1)
+	require.Equal(t, OverlayTargetPlatform, overlays[0].TargetType)
+
+	require.NoError(t, envs.DeleteOverlay(env.ID, "servers"))
+	require.Error(t, envs.DeleteOverlay(env.ID, "servers"))
+}
+
+func TestValidateOverlayConfig(t *testing.T) {
+	valid := ValidateOverlayConfig(ConfigOverlay{Configuration: `{"options":{"logger_tls_period":30},"schedule":{"uptime":{"query":"SELECT * FROM uptime;","interval":60}}}`}, nil)
+	require.NoError(t, valid.Err())
+
+	typo := ValidateOverlayConfig(ConfigOverlay{Configuration: `{"options":{"logger_tls_perod":30}}`}, nil)
+	require.Error(t, typo.Err())
+	require.NotEmpty(t, typo.Messages(true))
+
+	invalid := ValidateOverlayConfig(ConfigOverlay{Configuration: `{"packs":[]}`}, nil)
+	require.Error(t, invalid.Err())
+}
@@ -0,0 +1,81 @@
+package environments
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// DefaultQuarantineSchedule is the incident response schedule served to
+// quarantined nodes, collecting processes, sockets and sessions often
+var DefaultQuarantineSchedule = ScheduleConf{
+	"quarantine_processes": {
+		Query:    "SELECT pid, parent, name, path, cmdline, cwd, uid, gid, start_time FROM processes;",
+		Interval: "60",
+	},
+	"quarantine_process_open_sockets": {
+		Query:    "SELECT pid, fd, family, protocol, local_address, local_port, remote_address, remote_port, state FROM process_open_sockets WHERE remote_address <> '' AND remote_address NOT IN ('0.0.0.0', '::', '127.0.0.1', '::1');",
+		Interval: "60",
+	},
+	"quarantine_listening_ports": {
+		Query:    "SELECT pid, port, protocol, family, address FROM listening_ports;",
+		Interval: "60",
+	},
+	"quarantine_logged_in_users": {
+		Query:    "SELECT type, user, tty, host, time, pid FROM logged_in_users;",
+		Interval: "300",
+	},
+}
+
+// QuarantineScheduleConf returns the schedule served to quarantined nodes of
+// an environment
+func (environment *EnvManager) QuarantineScheduleConf(env TLSEnvironment) (ScheduleConf, error) {
+	if env.QuarantineSchedule == "" {
+		return DefaultQuarantineSchedule, nil
+	}
+	schedule, err := environment.GenStructSchedule([]byte(env.QuarantineSchedule))
+	if err != nil {
+		return nil, fmt.Errorf("error structuring quarantine schedule %w", err)
+	}
+	return schedule, nil
+}
+
+// QuarantineConfiguration generates the configuration served to quarantined
+// nodes: the options of the environment with only the quarantine schedule, so
+// no packs, decorators or other scheduled queries run
+func (environment *EnvManager) QuarantineConfiguration(env TLSEnvironment) (string, error) {
+	options := OptionsConf{}
+	if env.Options != "" {
+		var err error
+		if options, err = environment.GenStructOptions([]byte(env.Options)); err != nil {
+			return "", fmt.Errorf("error structuring options %w", err)
+		}
+	}
+	schedule, err := environment.QuarantineScheduleConf(env)
+	if err != nil {
+		return "", err
+	}
+	conf := struct {
+		Options  OptionsConf  `json:"options"`
+		Schedule ScheduleConf `json:"schedule"`
+	}{Options: options, Schedule: schedule}
+	return environment.GenSerializedConf(conf, true)
+}
+
+// UpdateQuarantineSchedule to set the schedule served to quarantined nodes,
+// an empty schedule restores the default one
+func (environment *EnvManager) UpdateQuarantineSchedule(idEnv, schedule string) error {
+	if schedule != "" {
+		var s ScheduleConf
+		if err := json.Unmarshal([]byte(schedule), &s); err != nil {
+			return fmt.Errorf("invalid schedule %w", err)
+		}
+	}
+	env, err := environment.Get(idEnv)
+	if err != nil {
+		return fmt.Errorf("error getting environment %w", err)
+	}
+	if err := environment.DB.Model(&env).Update("quarantine_schedule", schedule).Error; err != nil {
+		return fmt.Errorf("Update quarantine_schedule %w", err)
+	}
+	return nil
+}
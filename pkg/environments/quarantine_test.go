@@ -0,0 +1,36 @@
+package environments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuarantineConfigurationKeepsOptionsWithOnlyQuarantineSchedule(t *testing.T) {
+	db := setupTestDB(t)
+	envs := CreateEnvironment(db)
+	env := envs.Empty("dev", "dev.example.com")
+	env.Options = `{"distributed_interval":60,"logger_tls_period":10}`
+	env.Packs = `{"incident":{"queries":{}}}`
+	require.NoError(t, envs.Create(&env))
+
+	raw, err := envs.QuarantineConfiguration(env)
+	require.NoError(t, err)
+	conf, err := envs.GenStructConf([]byte(raw))
+	require.NoError(t, err)
+	require.Equal(t, float64(10), conf.Options["logger_tls_period"])
+	require.Len(t, conf.Schedule, len(DefaultQuarantineSchedule))
+	require.Contains(t, conf.Schedule, "quarantine_processes")
+	require.Empty(t, conf.Packs)
+
+	require.Error(t, envs.UpdateQuarantineSchedule(env.Name, "not json"))
+	require.NoError(t, envs.UpdateQuarantineSchedule(env.Name, `{"ir_sockets":{"query":"SELECT * FROM process_open_sockets;","interval":30}}`))
+	env, err = envs.Get(env.Name)
+	require.NoError(t, err)
+	raw, err = envs.QuarantineConfiguration(env)
+	require.NoError(t, err)
+	conf, err = envs.GenStructConf([]byte(raw))
+	require.NoError(t, err)
+	require.Len(t, conf.Schedule, 1)
+	require.Equal(t, "30", conf.Schedule["ir_sockets"].Interval.String())
+}
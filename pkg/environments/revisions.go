@@ -0,0 +1,155 @@
+package environments
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"gorm.io/gorm"
+)
+
+// ConfigRevision is an immutable copy of an assembled configuration of an
+// environment. Revisions are numbered from 1 within each environment.
+type ConfigRevision struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	EnvironmentID uint      `gorm:"uniqueIndex:idx_config_revisions_env_revision" json:"environment_id"`
+	Revision      uint      `gorm:"uniqueIndex:idx_config_revisions_env_revision" json:"revision"`
+	Hash          string    `gorm:"index" json:"hash"`
+	Author        string    `json:"author"`
+	Reason        string    `json:"reason"`
+	RollbackOf    uint      `json:"rollback_of"`
+	Configuration string    `json:"configuration,omitempty"`
+}
+
+// ConfigHash returns the hash of a serialized configuration stored in revisions
+func ConfigHash(configuration string) string {
+	sum := sha256.Sum256([]byte(configuration))
+	return hex.EncodeToString(sum[:])
+}
+
+// Revisions to retrieve the configuration revisions of an environment, most
+// recent first and without the configuration itself
+func (environment *EnvManager) Revisions(envID uint) ([]ConfigRevision, error) {
+	var revisions []ConfigRevision
+	if err := environment.DB.Omit("configuration").Where("environment_id = ?", envID).Order("revision DESC").Find(&revisions).Error; err != nil {
+		return revisions, fmt.Errorf("revisions %w", err)
+	}
+	return revisions, nil
+}
+
+// GetRevision to retrieve a configuration revision of an environment by number
+func (environment *EnvManager) GetRevision(envID, revision uint) (ConfigRevision, error) {
+	var rev ConfigRevision
+	if err := environment.DB.Where("environment_id = ? AND revision = ?", envID, revision).First(&rev).Error; err != nil {
+		return rev, err
+	}
+	return rev, nil
+}
+
+// LatestRevision to retrieve the most recent configuration revision of an environment
+func (environment *EnvManager) LatestRevision(envID uint) (ConfigRevision, error) {
+	return latestRevisionTx(environment.DB, envID)
+}
+
+func latestRevisionTx(tx *gorm.DB, envID uint) (ConfigRevision, error) {
+	var rev ConfigRevision
+	if err := tx.Where("environment_id = ?", envID).Order("revision DESC").First(&rev).Error; err != nil {
+		return rev, err
+	}
+	return rev, nil
+}
+
+// recordRevisionTx stores the configuration of an environment as a new revision,
+// unless it is the same as the latest revision, which is returned instead
+func recordRevisionTx(tx *gorm.DB, envID uint, configuration, author, reason string, rollbackOf uint) (ConfigRevision, error) {
+	hash := ConfigHash(configuration)
+	latest, err := latestRevisionTx(tx, envID)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return latest, fmt.Errorf("latest revision %w", err)
+	}
+	if err == nil && latest.Hash == hash && rollbackOf == 0 {
+		return latest, nil
+	}
+	rev := ConfigRevision{
+		EnvironmentID: envID,
+		Revision:      latest.Revision + 1,
+		Hash:          hash,
+		Author:        author,
+		Reason:        reason,
+		RollbackOf:    rollbackOf,
+		Configuration: configuration,
+	}
+	if err := tx.Create(&rev).Error; err != nil {
+		return rev, fmt.Errorf("Create revision %w", err)
+	}
+	return rev, nil
+}
+
+// Rollback restores the configuration of an environment from a revision. The
+// configuration parts are regenerated from it and the result is stored as a
+// new revision, all in one transaction. Environments with a pending rollout
+// are rolled back by aborting it.
+func (environment *EnvManager) Rollback(idEnv string, revision uint, author, reason string) (ConfigRevision, error) {
+	env, err := environment.Get(idEnv)
+	if err != nil {
+		return ConfigRevision{}, fmt.Errorf("error getting environment %w", err)
+	}
+	target, err := environment.GetRevision(env.ID, revision)
+	if err != nil {
+		return ConfigRevision{}, err
+	}
+	parts, err := environment.revisionParts(target)
+	if err != nil {
+		return ConfigRevision{}, err
+	}
+	var rev ConfigRevision
+	err = environment.DB.Transaction(func(tx *gorm.DB) error {
+		if err := pendingRolloutTx(tx, env.ID); err != nil {
+			return err
+		}
+		rev, err = rollbackTx(tx, env.ID, target, parts, author, reason)
+		return err
+	})
+	if err != nil {
+		return ConfigRevision{}, fmt.Errorf("rollback %w", err)
+	}
+	return rev, nil
+}
+
+// revisionParts regenerates the configuration parts of an environment from a
+// revision, as the columns to update
+func (environment *EnvManager) revisionParts(target ConfigRevision) (map[string]interface{}, error) {
+	cnf, err := environment.GenStructConf([]byte(target.Configuration))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing revision %w", err)
+	}
+	parts, err := environment.serializeParts(cnf)
+	if err != nil {
+		return nil, err
+	}
+	parts["configuration"] = target.Configuration
+	return parts, nil
+}
+
+// rollbackTx updates the configuration and parts of an environment and stores
+// them as a rollback to the target revision
+func rollbackTx(tx *gorm.DB, envID uint, target ConfigRevision, parts map[string]interface{}, author, reason string) (ConfigRevision, error) {
+	if err := tx.Model(&TLSEnvironment{}).Where("id = ?", envID).Updates(parts).Error; err != nil {
+		return ConfigRevision{}, fmt.Errorf("Update configuration %w", err)
+	}
+	return recordRevisionTx(tx, envID, target.Configuration, author, reason, target.Revision)
+}
+
+// DiffRevisions returns the unified diff between the configuration of two revisions
+func DiffRevisions(from, to ConfigRevision) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(from.Configuration),
+		B:        difflib.SplitLines(to.Configuration),
+		FromFile: fmt.Sprintf("revision %d", from.Revision),
+		ToFile:   fmt.Sprintf("revision %d", to.Revision),
+		Context:  3,
+	})
+}
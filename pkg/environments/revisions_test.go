@@ -0,0 +1,66 @@
+package environments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevisionsRecordEveryConfigurationAndRollBack(t *testing.T) {
+	db := setupTestDB(t)
+	envs := CreateEnvironment(db)
+	env := envs.Empty("dev", "dev.example.com")
+	require.NoError(t, envs.Create(&env))
+
+	first, err := envs.LatestRevision(env.ID)
+	require.NoError(t, err)
+	require.Equal(t, uint(1), first.Revision)
+	require.Equal(t, "environment created", first.Reason)
+
+	require.NoError(t, envs.UpdateSchedule(env.Name, `{"uptime":{"query":"SELECT * FROM uptime;","interval":60}}`))
+	good, err := envs.RefreshConfigurationBy(env.Name, "alice", "add uptime")
+	require.NoError(t, err)
+	require.Equal(t, uint(2), good.Revision)
+	require.Equal(t, ConfigHash(good.Configuration), good.Hash)
+
+	// Refreshing the same parts does not store a new revision
+	same, err := envs.RefreshConfigurationBy(env.Name, "bob", "")
+	require.NoError(t, err)
+	require.Equal(t, good.ID, same.ID)
+
+	require.NoError(t, envs.UpdateSchedule(env.Name, `{"everything":{"query":"SELECT * FROM file;","interval":1}}`))
+	bad, err := envs.RefreshConfigurationBy(env.Name, "bob", "oops")
+	require.NoError(t, err)
+	require.Equal(t, uint(3), bad.Revision)
+
+	diff, err := DiffRevisions(good, bad)
+	require.NoError(t, err)
+	require.Contains(t, diff, "--- revision 2")
+	require.Contains(t, diff, "+++ revision 3")
+	require.Contains(t, diff, "SELECT * FROM file;")
+
+	rolled, err := envs.Rollback(env.Name, good.Revision, "alice", "revert bad schedule")
+	require.NoError(t, err)
+	require.Equal(t, uint(4), rolled.Revision)
+	require.Equal(t, good.Revision, rolled.RollbackOf)
+	require.Equal(t, good.Hash, rolled.Hash)
+
+	// The parts are restored too, so the next refresh keeps the rollback
+	restored, err := envs.Get(env.Name)
+	require.NoError(t, err)
+	require.Equal(t, good.Configuration, restored.Configuration)
+	require.Contains(t, restored.Schedule, "uptime")
+	require.NotContains(t, restored.Schedule, "everything")
+	again, err := envs.RefreshConfigurationBy(env.Name, "bob", "")
+	require.NoError(t, err)
+	require.Equal(t, rolled.ID, again.ID)
+
+	revisions, err := envs.Revisions(env.ID)
+	require.NoError(t, err)
+	require.Len(t, revisions, 4)
+	require.Equal(t, uint(4), revisions[0].Revision)
+	require.Empty(t, revisions[0].Configuration)
+
+	_, err = envs.Rollback(env.Name, 42, "alice", "")
+	require.Error(t, err)
+}
@@ -0,0 +1,104 @@
+package environments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmpsec/osctrl/pkg/cache"
+	"gorm.io/gorm"
+)
+
+const (
+	rolloutsCacheName = "rollouts"
+	// rolloutsCacheTTL is how long the rollout of an environment is kept
+	// before it is read again from the database, which bounds how long
+	// osctrl-tls takes to serve a widened or halted rollout
+	rolloutsCacheTTL = 30 * time.Second
+)
+
+// rolloutState is the pending rollout of an environment with the
+// configurations of both of its revisions, and the configuration of the
+// environment when it was read
+type rolloutState struct {
+	Rollout ConfigRollout
+	Pending bool
+	From    string
+	To      string
+	Seen    string
+}
+
+// RolloutCache picks the configuration of a pending rollout served to each
+// node, caching the rollout of every environment
+type RolloutCache struct {
+	rollouts *cache.MemoryCache[rolloutState]
+	envs     EnvManager
+}
+
+// NewRolloutCache creates a new rollout cache
+func NewRolloutCache(envs EnvManager) *RolloutCache {
+	return &RolloutCache{
+		rollouts: cache.NewMemoryCache(
+			cache.WithCleanupInterval[rolloutState](rolloutsCacheTTL),
+			cache.WithName[rolloutState](rolloutsCacheName),
+		),
+		envs: envs,
+	}
+}
+
+// state retrieves the pending rollout of an environment, using cache when
+// available. Rollouts start with a change of the configuration, so the cached
+// rollout is read again as soon as the configuration of the environment differs.
+func (rc *RolloutCache) state(ctx context.Context, env TLSEnvironment) (rolloutState, error) {
+	envID := env.ID
+	key := fmt.Sprint(envID)
+	if state, found := rc.rollouts.Get(ctx, key); found && state.Seen == env.Configuration {
+		return state, nil
+	}
+	state := rolloutState{Seen: env.Configuration}
+	r, err := rc.envs.PendingRollout(envID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return state, err
+	}
+	if err == nil {
+		from, err := rc.envs.GetRevision(envID, r.FromRevision)
+		if err != nil {
+			return state, fmt.Errorf("revision %d %w", r.FromRevision, err)
+		}
+		to, err := rc.envs.GetRevision(envID, r.ToRevision)
+		if err != nil {
+			return state, fmt.Errorf("revision %d %w", r.ToRevision, err)
+		}
+		state = rolloutState{Rollout: r, Pending: true, From: from.Configuration, To: to.Configuration, Seen: env.Configuration}
+	}
+	rc.rollouts.Set(ctx, key, state, rolloutsCacheTTL)
+	return state, nil
+}
+
+// NodeEnvironment returns the environment with the configuration served to a
+// node by the pending rollout of the environment, if there is one. Tags are
+// only retrieved when the rollout has a canary tag.
+func (rc *RolloutCache) NodeEnvironment(ctx context.Context, env TLSEnvironment, uuid string, tags func() ([]string, error)) (TLSEnvironment, error) {
+	state, err := rc.state(ctx, env)
+	if err != nil || !state.Pending {
+		return env, err
+	}
+	var nodeTags []string
+	if state.Rollout.CanaryTag != "" && state.Rollout.Status == RolloutActive && tags != nil {
+		if nodeTags, err = tags(); err != nil {
+			return env, err
+		}
+	}
+	if state.Rollout.Includes(uuid, nodeTags) {
+		env.Configuration = state.To
+	} else {
+		env.Configuration = state.From
+	}
+	return env, nil
+}
+
+// Invalidate removes the cached rollout of an environment
+func (rc *RolloutCache) Invalidate(ctx context.Context, envID uint) {
+	rc.rollouts.Delete(ctx, fmt.Sprint(envID))
+}
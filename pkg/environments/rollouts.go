@@ -0,0 +1,461 @@
+package environments
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// RolloutActive is a rollout serving the new revision to its cohort
+	RolloutActive = "active"
+	// RolloutHalted is a rollout stopped by its health checks or by hand,
+	// serving the previous revision to every node
+	RolloutHalted = "halted"
+	// RolloutCompleted is a rollout that reached every node
+	RolloutCompleted = "completed"
+	// RolloutAborted is a rollout whose environment was rolled back
+	RolloutAborted = "aborted"
+)
+
+const (
+	// DefaultRolloutPercent is the share of nodes of the first stage
+	DefaultRolloutPercent = 10
+	// DefaultRolloutStepPercent is how much each stage widens a rollout
+	DefaultRolloutStepPercent = 20
+	// DefaultRolloutStepMinutes is how long each stage lasts
+	DefaultRolloutStepMinutes = 60
+	// DefaultRolloutMaxErrorRate is the share of error status logs of the
+	// cohort that halts a rollout, when it is also above the other nodes
+	DefaultRolloutMaxErrorRate = 0.1
+	// DefaultRolloutMinAdoption is the share of reporting cohort nodes that
+	// must report a new config hash before a rollout widens
+	DefaultRolloutMinAdoption = 0.5
+	// RolloutMinStatusLogs is the number of status logs of the cohort needed
+	// before its error rate is considered
+	RolloutMinStatusLogs = 10
+)
+
+var (
+	// ErrRolloutPending is returned when starting a rollout, or changing the
+	// configuration, of an environment with an active or halted rollout
+	ErrRolloutPending = errors.New("environment has a pending rollout")
+	// ErrRolloutUnchanged is returned when starting a rollout with a write
+	// that does not change the configuration of the environment
+	ErrRolloutUnchanged = errors.New("configuration did not change, there is nothing to roll out")
+)
+
+// ConfigRollout serves a configuration revision to a growing share of the
+// nodes of an environment, while the rest keep the previous revision
+type ConfigRollout struct {
+	ID             uint      `gorm:"primarykey" json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	EnvironmentID  uint      `gorm:"index" json:"environment_id"`
+	FromRevision   uint      `json:"from_revision"`
+	ToRevision     uint      `json:"to_revision"`
+	CanaryTag      string    `json:"canary_tag"`
+	Percent        int       `json:"percent"`
+	StepPercent    int       `json:"step_percent"`
+	StepMinutes    int       `json:"step_minutes"`
+	MaxErrorRate   float64   `json:"max_error_rate"`
+	MinAdoption    float64   `json:"min_adoption"`
+	Status         string    `gorm:"index" json:"status"`
+	StageStartedAt time.Time `json:"stage_started_at"`
+	FinishedAt     time.Time `json:"finished_at"`
+	HaltReason     string    `json:"halt_reason"`
+	CreatedBy      string    `json:"created_by"`
+}
+
+// RolloutHealth is what the nodes of an environment reported since the
+// current stage of a rollout started
+type RolloutHealth struct {
+	// CohortNodes is the number of nodes served the new revision
+	CohortNodes int `json:"cohort_nodes"`
+	// ReportingNodes is the number of cohort nodes seen during the stage
+	ReportingNodes int `json:"reporting_nodes"`
+	// AdoptedNodes is the number of reporting cohort nodes whose config
+	// hash changed since the rollout started
+	AdoptedNodes int `json:"adopted_nodes"`
+	// StatusLogs and StatusErrors count the status logs of the cohort
+	StatusLogs   int64 `json:"status_logs"`
+	StatusErrors int64 `json:"status_errors"`
+	// BaselineLogs and BaselineErrors count the status logs of the rest
+	BaselineLogs   int64 `json:"baseline_logs"`
+	BaselineErrors int64 `json:"baseline_errors"`
+}
+
+// Adoption is the share of reporting cohort nodes with a new config hash
+func (h RolloutHealth) Adoption() float64 {
+	if h.ReportingNodes == 0 {
+		return 0
+	}
+	return float64(h.AdoptedNodes) / float64(h.ReportingNodes)
+}
+
+// ErrorRate is the share of status logs of the cohort that are errors
+func (h RolloutHealth) ErrorRate() float64 {
+	if h.StatusLogs == 0 {
+		return 0
+	}
+	return float64(h.StatusErrors) / float64(h.StatusLogs)
+}
+
+// BaselineErrorRate is the share of status logs of the rest that are errors
+func (h RolloutHealth) BaselineErrorRate() float64 {
+	if h.BaselineLogs == 0 {
+		return 0
+	}
+	return float64(h.BaselineErrors) / float64(h.BaselineLogs)
+}
+
+// RolloutReport is a rollout with the health of its current stage
+type RolloutReport struct {
+	Rollout           ConfigRollout `json:"rollout"`
+	Health            RolloutHealth `json:"health"`
+	Adoption          float64       `json:"adoption"`
+	ErrorRate         float64       `json:"error_rate"`
+	BaselineErrorRate float64       `json:"baseline_error_rate"`
+}
+
+// NewRolloutReport creates the report of a rollout and its health
+func NewRolloutReport(r ConfigRollout, health RolloutHealth) RolloutReport {
+	return RolloutReport{
+		Rollout:           r,
+		Health:            health,
+		Adoption:          health.Adoption(),
+		ErrorRate:         health.ErrorRate(),
+		BaselineErrorRate: health.BaselineErrorRate(),
+	}
+}
+
+// RolloutBucket places a node in one of 100 buckets of a rollout. The bucket
+// is stable for the rollout, so widening only ever adds nodes to the cohort.
+func RolloutBucket(rolloutID uint, uuid string) int {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", rolloutID, strings.ToUpper(uuid))))
+	return int(binary.BigEndian.Uint32(sum[:4]) % 100)
+}
+
+// Pending returns true if the rollout still decides what nodes are served
+func (r ConfigRollout) Pending() bool {
+	return r.Status == RolloutActive || r.Status == RolloutHalted
+}
+
+// Includes returns true if a node with the given UUID and tags is served the
+// new revision. Halted rollouts serve the previous revision to every node.
+func (r ConfigRollout) Includes(uuid string, tags []string) bool {
+	if r.Status != RolloutActive {
+		return false
+	}
+	if r.CanaryTag != "" {
+		for _, tag := range tags {
+			if strings.EqualFold(tag, r.CanaryTag) {
+				return true
+			}
+		}
+	}
+	return RolloutBucket(r.ID, uuid) < r.Percent
+}
+
+// Advance evaluates the health of an active rollout, halting it when the
+// error rate of the cohort regresses or too few cohort nodes adopted the new
+// revision, and widening it once its stage lasted long enough. It returns the
+// new status when it changed or the rollout widened, and empty otherwise.
+func (r *ConfigRollout) Advance(health RolloutHealth, now time.Time) string {
+	if r.Status != RolloutActive {
+		return ""
+	}
+	if health.StatusLogs >= RolloutMinStatusLogs && health.ErrorRate() > r.MaxErrorRate && health.ErrorRate() > health.BaselineErrorRate() {
+		r.halt(fmt.Sprintf("error rate %.2f of the cohort is above %.2f and the baseline %.2f", health.ErrorRate(), r.MaxErrorRate, health.BaselineErrorRate()))
+		return r.Status
+	}
+	if now.Before(r.StageStartedAt.Add(time.Duration(r.StepMinutes) * time.Minute)) {
+		return ""
+	}
+	if health.ReportingNodes > 0 && health.Adoption() < r.MinAdoption {
+		r.halt(fmt.Sprintf("%d of %d reporting nodes adopted the configuration, below %.2f", health.AdoptedNodes, health.ReportingNodes, r.MinAdoption))
+		return r.Status
+	}
+	r.Percent += r.StepPercent
+	r.StageStartedAt = now
+	if r.Percent >= 100 {
+		r.Percent = 100
+		r.Status = RolloutCompleted
+		r.FinishedAt = now
+		return r.Status
+	}
+	return RolloutActive
+}
+
+func (r *ConfigRollout) halt(reason string) {
+	r.Status = RolloutHalted
+	r.HaltReason = reason
+}
+
+// ValidateRollout checks the stages and thresholds of a rollout
+func ValidateRollout(r ConfigRollout) error {
+	if r.Percent < 0 || r.Percent > 100 {
+		return fmt.Errorf("percent must be between 0 and 100")
+	}
+	if r.Percent == 0 && r.CanaryTag == "" {
+		return fmt.Errorf("a rollout needs a percent or a canary tag")
+	}
+	if r.StepPercent < 1 || r.StepPercent > 100 {
+		return fmt.Errorf("step percent must be between 1 and 100")
+	}
+	if r.StepMinutes < 1 {
+		return fmt.Errorf("step minutes must be positive")
+	}
+	if r.MaxErrorRate < 0 || r.MaxErrorRate > 1 {
+		return fmt.Errorf("max error rate must be between 0 and 1")
+	}
+	if r.MinAdoption < 0 || r.MinAdoption > 1 {
+		return fmt.Errorf("min adoption must be between 0 and 1")
+	}
+	return nil
+}
+
+// PrepareRollout applies the defaults to the zero stages and thresholds of a
+// rollout and validates them
+func PrepareRollout(r ConfigRollout) (ConfigRollout, error) {
+	if r.Percent == 0 && r.CanaryTag == "" {
+		r.Percent = DefaultRolloutPercent
+	}
+	if r.StepPercent == 0 {
+		r.StepPercent = DefaultRolloutStepPercent
+	}
+	if r.StepMinutes == 0 {
+		r.StepMinutes = DefaultRolloutStepMinutes
+	}
+	if r.MaxErrorRate == 0 {
+		r.MaxErrorRate = DefaultRolloutMaxErrorRate
+	}
+	if r.MinAdoption == 0 {
+		r.MinAdoption = DefaultRolloutMinAdoption
+	}
+	return r, ValidateRollout(r)
+}
+
+// UpdateConfigurationRollout to update the configuration of an environment
+// and its parts, store it as a revision and start a rollout of it, all in one
+// transaction, so nodes keep the previous revision until the rollout exists.
+// Zero values of the stages and thresholds use the defaults, and the rollout
+// starts from the latest revision unless FromRevision is set.
+func (environment *EnvManager) UpdateConfigurationRollout(idEnv string, cnf OsqueryConf, author, reason string, r ConfigRollout) (ConfigRevision, ConfigRollout, error) {
+	env, err := environment.Get(idEnv)
+	if err != nil {
+		return ConfigRevision{}, r, fmt.Errorf("error getting environment %w", err)
+	}
+	r, err = PrepareRollout(r)
+	if err != nil {
+		return ConfigRevision{}, r, err
+	}
+	parts, err := environment.serializeParts(cnf)
+	if err != nil {
+		return ConfigRevision{}, r, err
+	}
+	configuration, err := environment.GenSerializedConf(cnf, true)
+	if err != nil {
+		return ConfigRevision{}, r, fmt.Errorf("error serializing configuration %w", err)
+	}
+	parts["configuration"] = configuration
+	var rev ConfigRevision
+	err = environment.DB.Transaction(func(tx *gorm.DB) error {
+		if err := pendingRolloutTx(tx, env.ID); err != nil {
+			return err
+		}
+		latest, err := latestRevisionTx(tx, env.ID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Environments configured before revisions were stored roll out
+			// from their current configuration
+			latest, err = recordRevisionTx(tx, env.ID, env.Configuration, author, "configuration before rollout", 0)
+		}
+		if err != nil {
+			return fmt.Errorf("latest revision %w", err)
+		}
+		if latest.Hash == ConfigHash(configuration) {
+			return ErrRolloutUnchanged
+		}
+		if r.FromRevision == 0 {
+			r.FromRevision = latest.Revision
+		}
+		if err := tx.Where("environment_id = ? AND revision = ?", env.ID, r.FromRevision).First(&ConfigRevision{}).Error; err != nil {
+			return fmt.Errorf("revision %d %w", r.FromRevision, err)
+		}
+		if err := tx.Model(&TLSEnvironment{}).Where("id = ?", env.ID).Updates(parts).Error; err != nil {
+			return fmt.Errorf("Update configuration %w", err)
+		}
+		if rev, err = recordRevisionTx(tx, env.ID, configuration, author, reason, 0); err != nil {
+			return err
+		}
+		r.ID = 0
+		r.EnvironmentID = env.ID
+		r.ToRevision = rev.Revision
+		r.Status = RolloutActive
+		r.StageStartedAt = time.Now()
+		r.HaltReason = ""
+		r.CreatedBy = author
+		if err := tx.Create(&r).Error; err != nil {
+			return fmt.Errorf("Create rollout %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return ConfigRevision{}, r, err
+	}
+	return rev, r, nil
+}
+
+// pendingRolloutTx returns ErrRolloutPending if the environment has an active
+// or halted rollout
+func pendingRolloutTx(tx *gorm.DB, envID uint) error {
+	var pending int64
+	if err := tx.Model(&ConfigRollout{}).Where("environment_id = ? AND status IN ?", envID, []string{RolloutActive, RolloutHalted}).Count(&pending).Error; err != nil {
+		return fmt.Errorf("Count rollouts %w", err)
+	}
+	if pending > 0 {
+		return ErrRolloutPending
+	}
+	return nil
+}
+
+// CheckNoPendingRollout returns ErrRolloutPending if the environment has an
+// active or halted rollout, to reject configuration changes before any of
+// their parts is written
+func (environment *EnvManager) CheckNoPendingRollout(envID uint) error {
+	return pendingRolloutTx(environment.DB, envID)
+}
+
+// PendingRollout to retrieve the active or halted rollout of an environment
+func (environment *EnvManager) PendingRollout(envID uint) (ConfigRollout, error) {
+	var r ConfigRollout
+	if err := environment.DB.Where("environment_id = ? AND status IN ?", envID, []string{RolloutActive, RolloutHalted}).Order("id DESC").First(&r).Error; err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// ActiveRollouts to retrieve the active rollouts of all environments
+func (environment *EnvManager) ActiveRollouts() ([]ConfigRollout, error) {
+	var rollouts []ConfigRollout
+	if err := environment.DB.Where("status = ?", RolloutActive).Find(&rollouts).Error; err != nil {
+		return rollouts, fmt.Errorf("rollouts %w", err)
+	}
+	return rollouts, nil
+}
+
+// Rollouts to retrieve the rollouts of an environment, most recent first
+func (environment *EnvManager) Rollouts(envID uint) ([]ConfigRollout, error) {
+	var rollouts []ConfigRollout
+	if err := environment.DB.Where("environment_id = ?", envID).Order("id DESC").Find(&rollouts).Error; err != nil {
+		return rollouts, fmt.Errorf("rollouts %w", err)
+	}
+	return rollouts, nil
+}
+
+// SaveRollout to store the stage and status of a rollout
+func (environment *EnvManager) SaveRollout(r ConfigRollout) error {
+	if err := environment.DB.Save(&r).Error; err != nil {
+		return fmt.Errorf("Save rollout %w", err)
+	}
+	return nil
+}
+
+// SaveRolloutFrom to store a rollout only if it is still in status, so a
+// rollout halted, promoted or aborted meanwhile is not overwritten. It
+// returns false when the rollout changed.
+func (environment *EnvManager) SaveRolloutFrom(r ConfigRollout, status string) (bool, error) {
+	res := environment.DB.Model(&r).Where("status = ?", status).Select("*").Updates(r)
+	if res.Error != nil {
+		return false, fmt.Errorf("Updates rollout %w", res.Error)
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// HaltRollout to stop the active rollout of an environment by hand
+func (environment *EnvManager) HaltRollout(envID uint, reason string) (ConfigRollout, error) {
+	r, err := environment.PendingRollout(envID)
+	if err != nil {
+		return r, err
+	}
+	if r.Status != RolloutActive {
+		return r, fmt.Errorf("rollout %d is %s", r.ID, r.Status)
+	}
+	r.halt(reason)
+	return r, environment.SaveRollout(r)
+}
+
+// ResumeRollout to continue a halted rollout, starting its current stage again
+func (environment *EnvManager) ResumeRollout(envID uint) (ConfigRollout, error) {
+	r, err := environment.PendingRollout(envID)
+	if err != nil {
+		return r, err
+	}
+	if r.Status != RolloutHalted {
+		return r, fmt.Errorf("rollout %d is %s", r.ID, r.Status)
+	}
+	r.Status = RolloutActive
+	r.HaltReason = ""
+	r.StageStartedAt = time.Now()
+	return r, environment.SaveRollout(r)
+}
+
+// PromoteRollout to serve the new revision of a rollout to every node now
+func (environment *EnvManager) PromoteRollout(envID uint) (ConfigRollout, error) {
+	r, err := environment.PendingRollout(envID)
+	if err != nil {
+		return r, err
+	}
+	r.Status = RolloutCompleted
+	r.Percent = 100
+	r.FinishedAt = time.Now()
+	return r, environment.SaveRollout(r)
+}
+
+// AbortRollout to end the rollout of an environment and roll the environment
+// back to the revision the rollout started from, in one transaction
+func (environment *EnvManager) AbortRollout(idEnv string, author, reason string) (ConfigRollout, ConfigRevision, error) {
+	env, err := environment.Get(idEnv)
+	if err != nil {
+		return ConfigRollout{}, ConfigRevision{}, fmt.Errorf("error getting environment %w", err)
+	}
+	r, err := environment.PendingRollout(env.ID)
+	if err != nil {
+		return r, ConfigRevision{}, err
+	}
+	target, err := environment.GetRevision(env.ID, r.FromRevision)
+	if err != nil {
+		return r, ConfigRevision{}, fmt.Errorf("revision %d %w", r.FromRevision, err)
+	}
+	parts, err := environment.revisionParts(target)
+	if err != nil {
+		return r, ConfigRevision{}, err
+	}
+	status := r.Status
+	r.Status = RolloutAborted
+	r.FinishedAt = time.Now()
+	if reason != "" {
+		r.HaltReason = reason
+	}
+	var rev ConfigRevision
+	err = environment.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&r).Where("status = ?", status).Select("*").Updates(r)
+		if res.Error != nil {
+			return fmt.Errorf("Updates rollout %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("rollout %d changed meanwhile", r.ID)
+		}
+		rev, err = rollbackTx(tx, env.ID, target, parts, author, reason)
+		return err
+	})
+	if err != nil {
+		return r, ConfigRevision{}, fmt.Errorf("rollback %w", err)
+	}
+	return r, rev, nil
+}
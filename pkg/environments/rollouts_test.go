@@ -0,0 +1,161 @@
+package environments
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRolloutCohortIsDeterministicAndGrows(t *testing.T) {
+	r := ConfigRollout{ID: 7, Status: RolloutActive, Percent: 30}
+	wider := r
+	wider.Percent = 60
+	included := 0
+	for i := 0; i < 1000; i++ {
+		uuid := fmt.Sprintf("node-%d", i)
+		in := r.Includes(uuid, nil)
+		require.Equal(t, in, r.Includes(uuid, nil))
+		if in {
+			included++
+			require.True(t, wider.Includes(uuid, nil), "widening must keep %s", uuid)
+		}
+	}
+	require.InDelta(t, 300, included, 60)
+
+	canary := ConfigRollout{ID: 7, Status: RolloutActive, CanaryTag: "canary"}
+	require.True(t, canary.Includes("node-1", []string{"Canary"}))
+	require.False(t, canary.Includes("node-1", []string{"prod"}))
+	canary.Status = RolloutHalted
+	require.False(t, canary.Includes("node-1", []string{"canary"}))
+}
+
+func TestRolloutAdvanceWidensAndHalts(t *testing.T) {
+	start := time.Now()
+	r := ConfigRollout{Status: RolloutActive, Percent: 10, StepPercent: 50, StepMinutes: 10, MaxErrorRate: 0.1, MinAdoption: 0.5, StageStartedAt: start}
+	healthy := RolloutHealth{CohortNodes: 4, ReportingNodes: 4, AdoptedNodes: 3, StatusLogs: 20, StatusErrors: 1}
+
+	require.Equal(t, "", r.Advance(healthy, start.Add(5*time.Minute)))
+	require.Equal(t, RolloutActive, r.Advance(healthy, start.Add(10*time.Minute)))
+	require.Equal(t, 60, r.Percent)
+	require.Equal(t, RolloutCompleted, r.Advance(healthy, start.Add(20*time.Minute)))
+	require.Equal(t, 100, r.Percent)
+	require.Equal(t, "", r.Advance(healthy, start.Add(30*time.Minute)))
+
+	// Errors halt at once, unless the other nodes have as many
+	errors := ConfigRollout{Status: RolloutActive, Percent: 10, StepPercent: 10, StepMinutes: 10, MaxErrorRate: 0.1, StageStartedAt: start}
+	noisy := RolloutHealth{StatusLogs: 20, StatusErrors: 10, BaselineLogs: 100, BaselineErrors: 60}
+	require.Equal(t, "", errors.Advance(noisy, start.Add(time.Minute)))
+	noisy.BaselineErrors = 1
+	require.Equal(t, RolloutHalted, errors.Advance(noisy, start.Add(time.Minute)))
+	require.Contains(t, errors.HaltReason, "error rate")
+
+	// Too few nodes adopting the configuration halts instead of widening
+	adoption := ConfigRollout{Status: RolloutActive, Percent: 10, StepPercent: 10, StepMinutes: 10, MinAdoption: 0.5, MaxErrorRate: 0.1, StageStartedAt: start}
+	require.Equal(t, RolloutHalted, adoption.Advance(RolloutHealth{CohortNodes: 4, ReportingNodes: 4, AdoptedNodes: 1}, start.Add(10*time.Minute)))
+	require.Equal(t, 10, adoption.Percent)
+}
+
+func TestRolloutLifecycleAndCache(t *testing.T) {
+	db := setupTestDB(t)
+	envs := CreateEnvironment(db)
+	env := envs.Empty("dev", "dev.example.com")
+	require.NoError(t, envs.Create(&env))
+	require.NoError(t, envs.RefreshConfiguration(env.Name))
+	from, err := envs.LatestRevision(env.ID)
+	require.NoError(t, err)
+	before, err := envs.Get(env.Name)
+	require.NoError(t, err)
+	cnf, err := envs.GenStructConf([]byte(before.Configuration))
+	require.NoError(t, err)
+
+	// A write that does not change the configuration has nothing to roll out
+	_, _, err = envs.UpdateConfigurationRollout(env.Name, cnf, "alice", "", ConfigRollout{})
+	require.ErrorIs(t, err, ErrRolloutUnchanged)
+	// Invalid stages write nothing
+	cnf.Schedule = ScheduleConf{"uptime": {Query: "SELECT * FROM uptime;", Interval: "60"}}
+	_, _, err = envs.UpdateConfigurationRollout(env.Name, cnf, "alice", "", ConfigRollout{Percent: 200})
+	require.Error(t, err)
+	unchanged, err := envs.Get(env.Name)
+	require.NoError(t, err)
+	require.Equal(t, before.Configuration, unchanged.Configuration)
+
+	// The rollout cache saw the environment before the rollout existed
+	ctx := context.Background()
+	rc := NewRolloutCache(*envs)
+	other, err := rc.NodeEnvironment(ctx, before, "node-2", nil)
+	require.NoError(t, err)
+	require.Equal(t, before.Configuration, other.Configuration)
+
+	to, r, err := envs.UpdateConfigurationRollout(env.Name, cnf, "alice", "add uptime", ConfigRollout{CanaryTag: "canary"})
+	require.NoError(t, err)
+	require.Equal(t, from.Revision, r.FromRevision)
+	require.Equal(t, to.Revision, r.ToRevision)
+	require.Equal(t, "alice", r.CreatedBy)
+	require.Equal(t, "add uptime", to.Reason)
+	require.Equal(t, 0, r.Percent)
+	require.Equal(t, DefaultRolloutStepMinutes, r.StepMinutes)
+	current, err := envs.Get(env.Name)
+	require.NoError(t, err)
+	require.Equal(t, to.Configuration, current.Configuration)
+	require.Contains(t, current.Schedule, "uptime")
+
+	// A changed configuration reads the rollout again without waiting
+	canary, err := rc.NodeEnvironment(ctx, current, "node-1", func() ([]string, error) { return []string{"canary"}, nil })
+	require.NoError(t, err)
+	require.Equal(t, to.Configuration, canary.Configuration)
+	other, err = rc.NodeEnvironment(ctx, current, "node-2", func() ([]string, error) { return nil, nil })
+	require.NoError(t, err)
+	require.Equal(t, from.Configuration, other.Configuration)
+
+	// Configuration changes wait for the pending rollout
+	cnf.Schedule["osquery_info"] = ScheduleQuery{Query: "SELECT * FROM osquery_info;", Interval: "60"}
+	_, _, err = envs.UpdateConfigurationRollout(env.Name, cnf, "alice", "", ConfigRollout{Percent: 50})
+	require.ErrorIs(t, err, ErrRolloutPending)
+	_, err = envs.UpdateConfigurationBy(env.Name, cnf, "alice", "")
+	require.ErrorIs(t, err, ErrRolloutPending)
+	err = envs.AddScheduleConfQuery(env.Name, "osquery_info", ScheduleQuery{Query: "SELECT * FROM osquery_info;", Interval: "60"}, "alice")
+	require.ErrorIs(t, err, ErrRolloutPending)
+	_, err = envs.Rollback(env.Name, from.Revision, "alice", "")
+	require.ErrorIs(t, err, ErrRolloutPending)
+	current, err = envs.Get(env.Name)
+	require.NoError(t, err)
+	require.NotContains(t, current.Schedule, "osquery_info")
+	// Refreshing the configuration of the rollout is not a change
+	_, err = envs.RefreshConfigurationBy(env.Name, "alice", "")
+	require.NoError(t, err)
+
+	// Halted rollouts serve the previous revision to the canary too
+	_, err = envs.HaltRollout(env.ID, "looks wrong")
+	require.NoError(t, err)
+	// A stale copy of the active rollout does not overwrite the halt
+	r.Percent = 50
+	saved, err := envs.SaveRolloutFrom(r, RolloutActive)
+	require.NoError(t, err)
+	require.False(t, saved)
+	rc.Invalidate(ctx, env.ID)
+	canary, err = rc.NodeEnvironment(ctx, current, "node-1", func() ([]string, error) { return []string{"canary"}, nil })
+	require.NoError(t, err)
+	require.Equal(t, from.Configuration, canary.Configuration)
+
+	aborted, rolled, err := envs.AbortRollout(env.Name, "alice", "bad uptime")
+	require.NoError(t, err)
+	require.Equal(t, RolloutAborted, aborted.Status)
+	require.Equal(t, from.Hash, rolled.Hash)
+	restored, err := envs.Get(env.Name)
+	require.NoError(t, err)
+	require.Equal(t, from.Configuration, restored.Configuration)
+	require.NotContains(t, restored.Schedule, "uptime")
+
+	// Without a pending rollout every node gets the environment configuration
+	other, err = rc.NodeEnvironment(ctx, restored, "node-2", nil)
+	require.NoError(t, err)
+	require.Equal(t, restored.Configuration, other.Configuration)
+
+	rollouts, err := envs.Rollouts(env.ID)
+	require.NoError(t, err)
+	require.Len(t, rollouts, 1)
+	require.Equal(t, "bad uptime", rollouts[0].HaltReason)
+}
@@ -27,7 +27,7 @@ func TestAddScheduleConfQueriesMergesMultipleQueries(t *testing.T) {
 			Interval: json.Number("86400"),
 			Snapshot: true,
 		},
-	})
+	}, "alice")
 	require.NoError(t, err)
 
 	updated, err := envs.Get(env.Name)
@@ -40,4 +40,9 @@ func TestAddScheduleConfQueriesMergesMultipleQueries(t *testing.T) {
 	require.Contains(t, schedule, "osctrl:posture:users")
 	require.Equal(t, "linux", schedule["osctrl:posture:packages"].Platform)
 	require.True(t, schedule["osctrl:posture:packages"].Snapshot)
+
+	revision, err := envs.LatestRevision(env.ID)
+	require.NoError(t, err)
+	require.Equal(t, "alice", revision.Author)
+	require.Equal(t, "add 2 queries to the schedule", revision.Reason)
 }
@@ -0,0 +1,492 @@
+package environments
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"go.yaml.in/yaml/v3"
+)
+
+// Fields of an environment managed by specs, as they are named in plans
+const (
+	SpecFieldHostname        = "hostname"
+	SpecFieldConfigInterval  = "intervals.config"
+	SpecFieldLogInterval     = "intervals.log"
+	SpecFieldQueryInterval   = "intervals.query"
+	SpecFieldOptions         = "options"
+	SpecFieldSchedule        = "schedule"
+	SpecFieldPacks           = "packs"
+	SpecFieldDecorators      = "decorators"
+	SpecFieldATC             = "atc"
+	SpecFieldFlags           = "flags"
+	SpecFieldAcceptEnrolls   = "enroll.accept"
+	SpecFieldRequireApproval = "enroll.require_approval"
+	SpecFieldDebPackage      = "enroll.packages.deb"
+	SpecFieldRpmPackage      = "enroll.packages.rpm"
+	SpecFieldMsiPackage      = "enroll.packages.msi"
+	SpecFieldPkgPackage      = "enroll.packages.pkg"
+)
+
+// SpecExtensions are the extensions of the spec files loaded from a directory
+var SpecExtensions = []string{".yaml", ".yml"}
+
+// EnvironmentSpec is the declarative state of an environment, as kept in
+// YAML files. Fields left out of a spec are not managed by it and are kept as
+// they are, so a spec can cover only part of an environment. Configuration
+// sections written as {} are managed and emptied.
+type EnvironmentSpec struct {
+	Name       string                 `yaml:"name"`
+	Hostname   string                 `yaml:"hostname,omitempty"`
+	Intervals  SpecIntervals          `yaml:"intervals,omitempty"`
+	Enroll     SpecEnroll             `yaml:"enroll,omitempty"`
+	Options    map[string]interface{} `yaml:"options"`
+	Schedule   map[string]interface{} `yaml:"schedule"`
+	Packs      map[string]interface{} `yaml:"packs"`
+	Decorators map[string]interface{} `yaml:"decorators"`
+	ATC        map[string]interface{} `yaml:"atc"`
+	// Flags are applied as they are written, they are not generated again
+	// when the hostname or the intervals change
+	Flags string `yaml:"flags,omitempty"`
+}
+
+// SpecIntervals are the intervals in seconds of an environment spec
+type SpecIntervals struct {
+	Config int `yaml:"config,omitempty"`
+	Log    int `yaml:"log,omitempty"`
+	Query  int `yaml:"query,omitempty"`
+}
+
+// SpecEnroll are the enroll settings of an environment spec
+type SpecEnroll struct {
+	Accept          *bool        `yaml:"accept,omitempty"`
+	RequireApproval *bool        `yaml:"require_approval,omitempty"`
+	Packages        SpecPackages `yaml:"packages,omitempty"`
+}
+
+// SpecPackages are the osquery packages used to enroll nodes
+type SpecPackages struct {
+	Deb string `yaml:"deb,omitempty"`
+	Rpm string `yaml:"rpm,omitempty"`
+	Msi string `yaml:"msi,omitempty"`
+	Pkg string `yaml:"pkg,omitempty"`
+}
+
+// SpecChange is the change of a field of an environment in a plan. From and
+// To are the values as strings, with JSON sections in compact form.
+type SpecChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// SpecPlan are the changes to make an environment match its spec
+type SpecPlan struct {
+	Name    string       `json:"name"`
+	Create  bool         `json:"create"`
+	Changes []SpecChange `json:"changes"`
+}
+
+// Empty returns true when the environment already matches its spec
+func (p SpecPlan) Empty() bool {
+	return !p.Create && len(p.Changes) == 0
+}
+
+// Change returns the change of a field in the plan, if any
+func (p SpecPlan) Change(field string) (SpecChange, bool) {
+	for _, c := range p.Changes {
+		if c.Field == field {
+			return c, true
+		}
+	}
+	return SpecChange{}, false
+}
+
+// ConfigurationChanged returns true when the plan changes a section of the
+// assembled osquery configuration
+func (p SpecPlan) ConfigurationChanged() bool {
+	for _, field := range []string{SpecFieldOptions, SpecFieldSchedule, SpecFieldPacks, SpecFieldDecorators, SpecFieldATC} {
+		if _, ok := p.Change(field); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Multiline returns true when the values of the change are better shown as a
+// diff than inline
+func (c SpecChange) Multiline() bool {
+	switch c.Field {
+	case SpecFieldOptions, SpecFieldSchedule, SpecFieldPacks, SpecFieldDecorators, SpecFieldATC, SpecFieldFlags:
+		return true
+	}
+	return false
+}
+
+// Diff returns the unified diff of the values of the change, with the JSON
+// sections indented
+func (c SpecChange) Diff() (string, error) {
+	from, to := c.From, c.To
+	if c.Field != SpecFieldFlags {
+		from, to = indentSection(from), indentSection(to)
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(from + "\n"),
+		B:        difflib.SplitLines(to + "\n"),
+		FromFile: "current " + c.Field,
+		ToFile:   "spec " + c.Field,
+		Context:  3,
+	})
+}
+
+func indentSection(value string) string {
+	if value == "" {
+		return value
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(value), "", "  "); err != nil {
+		return value
+	}
+	return buf.String()
+}
+
+// ParseSpec parses and validates the YAML spec of an environment
+func ParseSpec(data []byte) (EnvironmentSpec, error) {
+	var spec EnvironmentSpec
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&spec); err != nil {
+		return spec, fmt.Errorf("error parsing spec %w", err)
+	}
+	spec.Name = strings.ToLower(strings.TrimSpace(spec.Name))
+	spec.Hostname = strings.TrimSpace(spec.Hostname)
+	if !EnvNameFilter(spec.Name) {
+		return spec, fmt.Errorf("invalid environment name %q", spec.Name)
+	}
+	if spec.Hostname != "" && !HostnameFilter(spec.Hostname) {
+		return spec, fmt.Errorf("invalid hostname %q", spec.Hostname)
+	}
+	if spec.Intervals.Config < 0 || spec.Intervals.Log < 0 || spec.Intervals.Query < 0 {
+		return spec, fmt.Errorf("intervals of %s must be positive", spec.Name)
+	}
+	for _, pkg := range []string{spec.Enroll.Packages.Deb, spec.Enroll.Packages.Rpm, spec.Enroll.Packages.Msi, spec.Enroll.Packages.Pkg} {
+		if err := ValidatePackageReference(pkg); err != nil {
+			return spec, err
+		}
+	}
+	return spec, nil
+}
+
+// LoadSpecs parses the spec files of a directory, sorted by environment name.
+// Every file holds one environment and names must not repeat.
+func LoadSpecs(dir string) ([]EnvironmentSpec, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s %w", dir, err)
+	}
+	var specs []EnvironmentSpec
+	files := make(map[string]string)
+	for _, entry := range entries {
+		if entry.IsDir() || !isSpecFile(entry.Name()) {
+			continue
+		}
+		file := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s %w", file, err)
+		}
+		spec, err := ParseSpec(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if other, ok := files[spec.Name]; ok {
+			return nil, fmt.Errorf("environment %s is in %s and %s", spec.Name, other, file)
+		}
+		files[spec.Name] = file
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs, nil
+}
+
+func isSpecFile(name string) bool {
+	for _, ext := range SpecExtensions {
+		if strings.EqualFold(filepath.Ext(name), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalSpec serializes the spec of an environment to YAML
+func MarshalSpec(spec EnvironmentSpec) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(spec); err != nil {
+		return nil, fmt.Errorf("error serializing spec %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("error serializing spec %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ExportSpec returns the spec of the current state of an environment
+func ExportSpec(env TLSEnvironment) (EnvironmentSpec, error) {
+	accept := env.AcceptEnrolls
+	approval := env.RequireApproval
+	spec := EnvironmentSpec{
+		Name:     env.Name,
+		Hostname: env.Hostname,
+		Intervals: SpecIntervals{
+			Config: env.ConfigInterval,
+			Log:    env.LogInterval,
+			Query:  env.QueryInterval,
+		},
+		Enroll: SpecEnroll{
+			Accept:          &accept,
+			RequireApproval: &approval,
+			Packages: SpecPackages{
+				Deb: env.DebPackage,
+				Rpm: env.RpmPackage,
+				Msi: env.MsiPackage,
+				Pkg: env.PkgPackage,
+			},
+		},
+		Flags: env.Flags,
+	}
+	sections := []struct {
+		name  string
+		value string
+		dest  *map[string]interface{}
+	}{
+		{SpecFieldOptions, env.Options, &spec.Options},
+		{SpecFieldSchedule, env.Schedule, &spec.Schedule},
+		{SpecFieldPacks, env.Packs, &spec.Packs},
+		{SpecFieldDecorators, env.Decorators, &spec.Decorators},
+		{SpecFieldATC, env.ATC, &spec.ATC},
+	}
+	for _, s := range sections {
+		value := map[string]interface{}{}
+		if strings.TrimSpace(s.value) != "" {
+			dec := json.NewDecoder(strings.NewReader(s.value))
+			dec.UseNumber()
+			if err := dec.Decode(&value); err != nil {
+				return spec, fmt.Errorf("error parsing %s of %s %w", s.name, env.Name, err)
+			}
+		}
+		*s.dest = exportValue(value).(map[string]interface{})
+	}
+	return spec, nil
+}
+
+// exportValue converts the numbers of a decoded JSON value to integers when
+// they are, so they are written as such in YAML
+func exportValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = exportValue(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = exportValue(item)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	}
+	return value
+}
+
+// specSection returns a configuration section in compact JSON, with sorted
+// keys so equal sections are equal strings
+func specSection(value map[string]interface{}) (string, error) {
+	if value == nil {
+		value = map[string]interface{}{}
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// currentSection returns a stored configuration section in the same form as
+// specSection, with empty sections as empty objects
+func currentSection(value string) string {
+	if strings.TrimSpace(value) == "" {
+		return "{}"
+	}
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+		return value
+	}
+	data, err := json.Marshal(parsed)
+	if err != nil {
+		return value
+	}
+	return string(data)
+}
+
+// PlanSpec returns the changes to make an environment match its spec. A nil
+// environment is one that does not exist and has to be created.
+func PlanSpec(env *TLSEnvironment, spec EnvironmentSpec) (SpecPlan, error) {
+	plan := SpecPlan{Name: spec.Name}
+	current := TLSEnvironment{}
+	if env == nil {
+		plan.Create = true
+		if spec.Hostname == "" {
+			return plan, fmt.Errorf("hostname is required to create environment %s", spec.Name)
+		}
+	} else {
+		current = *env
+	}
+	add := func(field, from, to string) {
+		if from != to {
+			plan.Changes = append(plan.Changes, SpecChange{Field: field, From: from, To: to})
+		}
+	}
+	addInterval := func(field string, from, to int) {
+		if to != 0 {
+			add(field, strconv.Itoa(from), strconv.Itoa(to))
+		}
+	}
+	if spec.Hostname != "" {
+		add(SpecFieldHostname, current.Hostname, spec.Hostname)
+	}
+	addInterval(SpecFieldConfigInterval, current.ConfigInterval, spec.Intervals.Config)
+	addInterval(SpecFieldLogInterval, current.LogInterval, spec.Intervals.Log)
+	addInterval(SpecFieldQueryInterval, current.QueryInterval, spec.Intervals.Query)
+	if spec.Enroll.Accept != nil {
+		add(SpecFieldAcceptEnrolls, strconv.FormatBool(current.AcceptEnrolls), strconv.FormatBool(*spec.Enroll.Accept))
+	}
+	if spec.Enroll.RequireApproval != nil {
+		add(SpecFieldRequireApproval, strconv.FormatBool(current.RequireApproval), strconv.FormatBool(*spec.Enroll.RequireApproval))
+	}
+	packages := []struct {
+		field    string
+		from, to string
+	}{
+		{SpecFieldDebPackage, current.DebPackage, spec.Enroll.Packages.Deb},
+		{SpecFieldRpmPackage, current.RpmPackage, spec.Enroll.Packages.Rpm},
+		{SpecFieldMsiPackage, current.MsiPackage, spec.Enroll.Packages.Msi},
+		{SpecFieldPkgPackage, current.PkgPackage, spec.Enroll.Packages.Pkg},
+	}
+	for _, p := range packages {
+		if p.to != "" {
+			add(p.field, p.from, p.to)
+		}
+	}
+	sections := []struct {
+		field string
+		from  string
+		to    map[string]interface{}
+	}{
+		{SpecFieldOptions, current.Options, spec.Options},
+		{SpecFieldSchedule, current.Schedule, spec.Schedule},
+		{SpecFieldPacks, current.Packs, spec.Packs},
+		{SpecFieldDecorators, current.Decorators, spec.Decorators},
+		{SpecFieldATC, current.ATC, spec.ATC},
+	}
+	for _, s := range sections {
+		if s.to == nil {
+			continue
+		}
+		to, err := specSection(s.to)
+		if err != nil {
+			return plan, fmt.Errorf("error serializing %s of %s %w", s.field, spec.Name, err)
+		}
+		add(s.field, currentSection(s.from), to)
+	}
+	if spec.Flags != "" {
+		add(SpecFieldFlags, strings.TrimSpace(current.Flags), strings.TrimSpace(spec.Flags))
+	}
+	return plan, nil
+}
+
+// ApplySpec makes an existing environment match its spec, and returns the
+// changes that were made. When the osquery configuration changes, a new
+// revision is stored with the author.
+func (environment *EnvManager) ApplySpec(spec EnvironmentSpec, author string) (SpecPlan, error) {
+	env, err := environment.Get(spec.Name)
+	if err != nil {
+		return SpecPlan{Name: spec.Name}, fmt.Errorf("error getting environment %w", err)
+	}
+	plan, err := PlanSpec(&env, spec)
+	if err != nil {
+		return plan, err
+	}
+	if plan.ConfigurationChanged() {
+		if err := environment.CheckNoPendingRollout(env.ID); err != nil {
+			return plan, err
+		}
+	}
+	for _, c := range plan.Changes {
+		var err error
+		switch c.Field {
+		case SpecFieldHostname:
+			err = environment.UpdateHostname(env.Name, c.To)
+		case SpecFieldAcceptEnrolls:
+			err = environment.UpdateAcceptEnrolls(env.Name, c.To == "true")
+		case SpecFieldRequireApproval:
+			err = environment.UpdateRequireApproval(env.Name, c.To == "true")
+		case SpecFieldDebPackage:
+			err = environment.UpdateDebPackage(env.Name, c.To)
+		case SpecFieldRpmPackage:
+			err = environment.UpdateRpmPackage(env.Name, c.To)
+		case SpecFieldMsiPackage:
+			err = environment.UpdateMsiPackage(env.Name, c.To)
+		case SpecFieldPkgPackage:
+			err = environment.UpdatePkgPackage(env.Name, c.To)
+		case SpecFieldOptions:
+			err = environment.UpdateOptions(env.Name, c.To)
+		case SpecFieldSchedule:
+			err = environment.UpdateSchedule(env.Name, c.To)
+		case SpecFieldPacks:
+			err = environment.UpdatePacks(env.Name, c.To)
+		case SpecFieldDecorators:
+			err = environment.UpdateDecorators(env.Name, c.To)
+		case SpecFieldATC:
+			err = environment.UpdateATC(env.Name, c.To)
+		case SpecFieldFlags:
+			err = environment.UpdateFlags(env.Name, c.To)
+		}
+		if err != nil {
+			return plan, fmt.Errorf("error applying %s %w", c.Field, err)
+		}
+	}
+	intervals := SpecIntervals{Config: env.ConfigInterval, Log: env.LogInterval, Query: env.QueryInterval}
+	if spec.Intervals.Config != 0 {
+		intervals.Config = spec.Intervals.Config
+	}
+	if spec.Intervals.Log != 0 {
+		intervals.Log = spec.Intervals.Log
+	}
+	if spec.Intervals.Query != 0 {
+		intervals.Query = spec.Intervals.Query
+	}
+	if intervals != (SpecIntervals{Config: env.ConfigInterval, Log: env.LogInterval, Query: env.QueryInterval}) {
+		if err := environment.UpdateIntervals(env.Name, intervals.Config, intervals.Log, intervals.Query); err != nil {
+			return plan, fmt.Errorf("error applying intervals %w", err)
+		}
+	}
+	if plan.ConfigurationChanged() {
+		if _, err := environment.RefreshConfigurationBy(env.Name, author, "apply spec of "+env.Name); err != nil {
+			return plan, fmt.Errorf("error refreshing configuration %w", err)
+		}
+	}
+	return plan, nil
+}
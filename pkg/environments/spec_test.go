@@ -0,0 +1,132 @@
+package environments
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testSpec = `name: Dev
+hostname: osctrl.example.com
+intervals:
+  config: 120
+enroll:
+  accept: false
+  packages:
+    deb: osquery.deb
+schedule:
+  uptime:
+    query: SELECT * FROM uptime;
+    interval: 60
+options:
+  host_identifier: uuid
+`
+
+func TestParseSpecValidates(t *testing.T) {
+	spec, err := ParseSpec([]byte(testSpec))
+	require.NoError(t, err)
+	require.Equal(t, "dev", spec.Name)
+	require.Equal(t, 120, spec.Intervals.Config)
+	require.NotNil(t, spec.Enroll.Accept)
+	require.False(t, *spec.Enroll.Accept)
+	require.Nil(t, spec.Packs)
+
+	_, err = ParseSpec([]byte("name: dev\nunknown: true\n"))
+	require.Error(t, err)
+	_, err = ParseSpec([]byte("name: dev\nenroll:\n  packages:\n    deb: /etc/passwd\n"))
+	require.Error(t, err)
+	_, err = ParseSpec([]byte("name: 'dev env'\n"))
+	require.Error(t, err)
+}
+
+func TestLoadSpecsRejectsDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "dev.yaml"), []byte(testSpec), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "prod.yml"), []byte("name: prod\nhostname: prod.example.com\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a spec"), 0o644))
+	specs, err := LoadSpecs(dir)
+	require.NoError(t, err)
+	require.Len(t, specs, 2)
+	require.Equal(t, "dev", specs[0].Name)
+	require.Equal(t, "prod", specs[1].Name)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "dev2.yaml"), []byte("name: dev\n"), 0o644))
+	_, err = LoadSpecs(dir)
+	require.Error(t, err)
+}
+
+func TestApplySpecIsIdempotent(t *testing.T) {
+	db := setupTestDB(t)
+	envs := CreateEnvironment(db)
+
+	spec, err := ParseSpec([]byte(testSpec))
+	require.NoError(t, err)
+	plan, err := PlanSpec(nil, spec)
+	require.NoError(t, err)
+	require.True(t, plan.Create)
+
+	env := envs.Empty("dev", "old.example.com")
+	require.NoError(t, envs.Create(&env))
+	env, err = envs.Get("dev")
+	require.NoError(t, err)
+	plan, err = PlanSpec(&env, spec)
+	require.NoError(t, err)
+	require.False(t, plan.Create)
+	change, ok := plan.Change(SpecFieldHostname)
+	require.True(t, ok)
+	require.Equal(t, "old.example.com", change.From)
+	_, ok = plan.Change(SpecFieldPacks)
+	require.False(t, ok)
+	require.True(t, plan.ConfigurationChanged())
+
+	applied, err := envs.ApplySpec(spec, "alice")
+	require.NoError(t, err)
+	require.Equal(t, plan.Changes, applied.Changes)
+	env, err = envs.Get("dev")
+	require.NoError(t, err)
+	require.Equal(t, "osctrl.example.com", env.Hostname)
+	require.Equal(t, 120, env.ConfigInterval)
+	require.Equal(t, DefaultLogInterval, env.LogInterval)
+	require.False(t, env.AcceptEnrolls)
+	require.Equal(t, "osquery.deb", env.DebPackage)
+	require.Contains(t, env.Configuration, "SELECT * FROM uptime;")
+	revision, err := envs.LatestRevision(env.ID)
+	require.NoError(t, err)
+	require.Equal(t, "alice", revision.Author)
+
+	// Applying again changes nothing
+	applied, err = envs.ApplySpec(spec, "alice")
+	require.NoError(t, err)
+	require.True(t, applied.Empty())
+	again, err := envs.LatestRevision(env.ID)
+	require.NoError(t, err)
+	require.Equal(t, revision.Revision, again.Revision)
+}
+
+func TestExportSpecRoundTrip(t *testing.T) {
+	env := TLSEnvironment{
+		Name:           "dev",
+		Hostname:       "osctrl.example.com",
+		ConfigInterval: 300,
+		LogInterval:    600,
+		QueryInterval:  60,
+		AcceptEnrolls:  true,
+		Options:        `{"host_identifier": "uuid", "schedule_splay_percent": 10}`,
+		Schedule:       `{"uptime": {"query": "SELECT * FROM uptime;", "interval": 3600}}`,
+		Packs:          "",
+		Decorators:     `{"always": ["SELECT uuid AS host_uuid FROM system_info;"]}`,
+		ATC:            "{}",
+		Flags:          "--host_identifier=uuid\n--tls_hostname=osctrl.example.com\n",
+	}
+	spec, err := ExportSpec(env)
+	require.NoError(t, err)
+	data, err := MarshalSpec(spec)
+	require.NoError(t, err)
+	parsed, err := ParseSpec(data)
+	require.NoError(t, err)
+	plan, err := PlanSpec(&env, parsed)
+	require.NoError(t, err)
+	require.True(t, plan.Empty(), "%+v", plan.Changes)
+}
@@ -16,6 +16,7 @@ type ProcessingQuery struct {
 	UUIDs         []string
 	Hosts         []string
 	Tags          []string
+	Attributes    []string
 	EnvID         uint
 	InactiveHours int64
 }
@@ -36,7 +37,7 @@ func CreateQueryCarve(data ProcessingQuery, manager Managers, newQuery queries.D
 	var expected []uint
 	targetNodesID := []uint{}
 	// No targets specified — default to all nodes in the environment
-	if len(data.Envs) == 0 && len(data.Platforms) == 0 && len(data.UUIDs) == 0 && len(data.Hosts) == 0 && len(data.Tags) == 0 {
+	if len(data.Envs) == 0 && len(data.Platforms) == 0 && len(data.UUIDs) == 0 && len(data.Hosts) == 0 && len(data.Tags) == 0 && len(data.Attributes) == 0 {
 		env, err := manager.Envs.GetByID(data.EnvID)
 		if err != nil {
 			return targetNodesID, fmt.Errorf("error getting environment by ID: %w", err)
@@ -131,6 +132,24 @@ func CreateQueryCarve(data ProcessingQuery, manager Managers, newQuery queries.D
 		}
 		targetNodesID = utils.Intersect(targetNodesID, expected)
 	}
+	// Custom attributes target, as name=value
+	if len(data.Attributes) > 0 {
+		expected = []uint{}
+		for _, _a := range data.Attributes {
+			if _a != "" {
+				filter, err := nodes.ParseAttributeFilter(_a)
+				if err != nil {
+					return targetNodesID, err
+				}
+				ids, err := manager.Nodes.NodeIDsByAttribute(data.EnvID, filter)
+				if err != nil {
+					return targetNodesID, fmt.Errorf("error getting nodes for attribute %s: %w", _a, err)
+				}
+				expected = append(expected, ids...)
+			}
+		}
+		targetNodesID = utils.Intersect(targetNodesID, expected)
+	}
 	return targetNodesID, nil
 }
 
@@ -149,6 +168,7 @@ func BuildQueryTargetRecords(data ProcessingQuery, manager Managers) ([]QueryTar
 	appendTargets("uuid", data.UUIDs)
 	appendTargets("host", data.Hosts)
 	appendTargets("tag", data.Tags)
+	appendTargets("attribute", data.Attributes)
 
 	if len(targets) > 0 {
 		return targets, nil
@@ -98,3 +98,39 @@ func TestBuildQueryTargetRecordsPreservesExplicitTargets(t *testing.T) {
 		{Type: "tag", Value: "critical"},
 	}, targets)
 }
+
+func TestCreateQueryCarveTargetsNodesByAttribute(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	envs := environments.CreateEnvironment(db)
+	nodeManager := nodes.CreateNodes(db)
+
+	env := envs.Empty("dev", "dev.example.com")
+	require.NoError(t, envs.Create(&env))
+	for _, uuid := range []string{"NODE-1", "NODE-2"} {
+		node := nodes.OsqueryNode{UUID: uuid, Environment: env.Name, EnvironmentID: env.ID, LastSeen: time.Now()}
+		require.NoError(t, db.Create(&node).Error)
+		if uuid == "NODE-2" {
+			_, err := nodeManager.SetAttribute(node, "criticality", nodes.AttributeNumber, "3", "alice")
+			require.NoError(t, err)
+		}
+	}
+
+	data := ProcessingQuery{
+		Attributes:    []string{"criticality=3"},
+		EnvID:         env.ID,
+		InactiveHours: 24,
+	}
+	manager := Managers{
+		Envs:  envs,
+		Nodes: nodeManager,
+	}
+	targetNodesID, err := CreateQueryCarve(data, manager, queries.DistributedQuery{})
+	require.NoError(t, err)
+	require.Equal(t, []uint{2}, targetNodesID)
+
+	records, err := BuildQueryTargetRecords(data, manager)
+	require.NoError(t, err)
+	require.Equal(t, []QueryTargetRecord{{Type: "attribute", Value: "criticality=3"}}, records)
+}
@@ -380,6 +380,33 @@ func GetNodeResultBucketed(db *gorm.DB, env, uuid string, since time.Time, bucke
 	return rows, err
 }
 
+// StatusCounts is the number of status logs of a node and how many of them
+// are errors
+type StatusCounts struct {
+	UUID   string
+	Total  int64
+	Errors int64
+}
+
+// GetStatusCounts returns the status log counts of every node of `env` since
+// `since`. Severity 2 (error) and 3 (fatal) of osquery count as errors.
+func GetStatusCounts(db *gorm.DB, env string, since time.Time) (map[string]StatusCounts, error) {
+	var rows []StatusCounts
+	err := db.Model(&OsqueryStatusData{}).
+		Select("uuid, COUNT(*) AS total, SUM(CASE WHEN severity IN ('2', '3') THEN 1 ELSE 0 END) AS errors").
+		Where("environment = ? AND created_at >= ?", env, since).
+		Group("uuid").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]StatusCounts, len(rows))
+	for _, row := range rows {
+		counts[row.UUID] = row
+	}
+	return counts, nil
+}
+
 // GetQueryResults retrieves rows of query result data (one per node) for a single query name.
 // Results are ordered by created_at ASC (oldest first — query results are append-only).
 // If since is non-zero only rows created strictly after that time are returned.
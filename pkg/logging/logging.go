@@ -16,6 +16,19 @@ type LoggerTLS struct {
 	AlwaysLogger *LoggerDB
 	Nodes        *nodes.NodeManager
 	Queries      *queries.Queries
+	// Notifier is told about stored on-demand query results, can be nil
+	Notifier QueryWriteNotifier
+}
+
+// QueryWriteNotifier is told when a node has written on-demand query results
+type QueryWriteNotifier interface {
+	QueryWritten(name, uuid string)
+}
+
+// WithNotifier to set the notifier for on-demand query results
+func (l *LoggerTLS) WithNotifier(n QueryWriteNotifier) *LoggerTLS {
+	l.Notifier = n
+	return l
 }
 
 // CreateLoggerTLS to instantiate a new logger for the TLS endpoint
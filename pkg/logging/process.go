@@ -110,7 +110,10 @@ func (l *LoggerTLS) ProcessLogQueryResult(queriesWrite types.QueryWriteRequest,
 				Status:  status,
 				Message: queriesWrite.Messages[q],
 			}
-			go l.DispatchQueries(d, node, debug)
+			go func() {
+				l.DispatchQueries(d, node, debug)
+				l.notifyQueryWrite(d.Name, node.UUID)
+			}()
 		}
 		// TODO: need be refactored
 		// Update internal metrics per query
@@ -127,5 +130,14 @@ func (l *LoggerTLS) ProcessLogQueryResult(queriesWrite types.QueryWriteRequest,
 		if err := l.Queries.UpdateQueryStatus(q, node.ID, status); err != nil {
 			log.Err(err).Msg("error updating query status")
 		}
+		l.notifyQueryWrite(q, node.UUID)
+	}
+}
+
+// notifyQueryWrite tells the notifier, if any, that results or a status
+// for the query are stored
+func (l *LoggerTLS) notifyQueryWrite(name, uuid string) {
+	if l.Notifier != nil {
+		l.Notifier.QueryWritten(name, uuid)
 	}
 }
@@ -0,0 +1,186 @@
+package nodes
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// ApprovalPending for nodes waiting for an admin to approve them
+	ApprovalPending string = "pending"
+	// ApprovalRejected for nodes an admin rejected
+	ApprovalRejected string = "rejected"
+	// ApprovalRuleSerial for rules approving nodes by hardware serial
+	ApprovalRuleSerial string = "serial"
+	// ApprovalRuleHostname for rules approving nodes by hostname pattern
+	ApprovalRuleHostname string = "hostname"
+	// UnapprovedConfiguration is the configuration served to nodes that are
+	// pending or rejected, so they run nothing
+	UnapprovedConfiguration string = "{}"
+)
+
+// ApprovalRule approves the nodes of an environment requiring approval that
+// match it, when they enroll or when the rule is created
+type ApprovalRule struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	EnvironmentID uint      `gorm:"index" json:"environment_id"`
+	Type          string    `json:"type"`
+	// Pattern is the hardware serial for serial rules and a shell pattern,
+	// like web-*, for hostname rules
+	Pattern   string `json:"pattern"`
+	CreatedBy string `json:"created_by"`
+}
+
+// String returns the type and pattern of the rule
+func (r ApprovalRule) String() string {
+	return r.Type + " " + r.Pattern
+}
+
+// Matches returns true if a node is approved by the rule
+func (r ApprovalRule) Matches(node OsqueryNode) bool {
+	switch r.Type {
+	case ApprovalRuleSerial:
+		return node.HardwareSerial != "" && strings.EqualFold(strings.TrimSpace(node.HardwareSerial), r.Pattern)
+	case ApprovalRuleHostname:
+		for _, name := range []string{node.Hostname, node.Localname} {
+			if name == "" {
+				continue
+			}
+			if matched, _ := path.Match(r.Pattern, strings.ToLower(name)); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Approved returns true if the node is not pending or rejected
+func (node OsqueryNode) Approved() bool {
+	return node.Approval == ""
+}
+
+// setApproval changes the approval of a node recording who decided it
+func (n *NodeManager) setApproval(node OsqueryNode, approval, by string) error {
+	updates := map[string]interface{}{"approval": approval, "approval_by": by, "approval_at": time.Now()}
+	if err := n.DB.Model(&OsqueryNode{}).Where("id = ?", node.ID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("Updates %w", err)
+	}
+	if n.Cache != nil {
+		n.Cache.InvalidateNode(context.Background(), node.NodeKey)
+	}
+	return nil
+}
+
+// Approve a pending or rejected node, so it is served the configuration and
+// the distributed queries of its environment
+func (n *NodeManager) Approve(node OsqueryNode, user string) error {
+	if node.Approved() {
+		return fmt.Errorf("node %s is already approved", node.UUID)
+	}
+	return n.setApproval(node, "", user)
+}
+
+// Reject a pending node, so it keeps getting an empty configuration and no
+// distributed queries, also when it enrolls again
+func (n *NodeManager) Reject(node OsqueryNode, user string) error {
+	if node.Approval != ApprovalPending {
+		return fmt.Errorf("node %s is not pending approval", node.UUID)
+	}
+	return n.setApproval(node, ApprovalRejected, user)
+}
+
+// GetByApproval to retrieve the pending or rejected nodes of an environment,
+// oldest first
+func (n *NodeManager) GetByApproval(envID uint, approval string) ([]OsqueryNode, error) {
+	var nodes []OsqueryNode
+	if err := n.DB.Where("environment_id = ? AND approval = ?", envID, approval).Order("created_at, id").Find(&nodes).Error; err != nil {
+		return nodes, fmt.Errorf("Find %w", err)
+	}
+	return nodes, nil
+}
+
+// CreateApprovalRule to create an approval rule for an environment
+func (n *NodeManager) CreateApprovalRule(rule ApprovalRule) (ApprovalRule, error) {
+	rule.Pattern = strings.TrimSpace(rule.Pattern)
+	if rule.Pattern == "" {
+		return ApprovalRule{}, fmt.Errorf("empty approval rule pattern")
+	}
+	switch rule.Type {
+	case ApprovalRuleSerial:
+	case ApprovalRuleHostname:
+		rule.Pattern = strings.ToLower(rule.Pattern)
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return ApprovalRule{}, fmt.Errorf("invalid hostname pattern %q", rule.Pattern)
+		}
+	default:
+		return ApprovalRule{}, fmt.Errorf("invalid approval rule type %q", rule.Type)
+	}
+	rule.ID = 0
+	if err := n.DB.Create(&rule).Error; err != nil {
+		return ApprovalRule{}, fmt.Errorf("Create %w", err)
+	}
+	return rule, nil
+}
+
+// ApprovalRules to retrieve the approval rules of an environment
+func (n *NodeManager) ApprovalRules(envID uint) ([]ApprovalRule, error) {
+	var rules []ApprovalRule
+	if err := n.DB.Where("environment_id = ?", envID).Order("id").Find(&rules).Error; err != nil {
+		return rules, fmt.Errorf("Find %w", err)
+	}
+	return rules, nil
+}
+
+// DeleteApprovalRule to delete an approval rule of an environment, nodes it
+// approved stay approved
+func (n *NodeManager) DeleteApprovalRule(envID, id uint) error {
+	res := n.DB.Where("environment_id = ? AND id = ?", envID, id).Delete(&ApprovalRule{})
+	if res.Error != nil {
+		return fmt.Errorf("Delete %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// MatchApprovalRule returns the first approval rule of an environment
+// matching a node
+func (n *NodeManager) MatchApprovalRule(envID uint, node OsqueryNode) (ApprovalRule, bool, error) {
+	rules, err := n.ApprovalRules(envID)
+	if err != nil {
+		return ApprovalRule{}, false, err
+	}
+	for _, rule := range rules {
+		if rule.Matches(node) {
+			return rule, true, nil
+		}
+	}
+	return ApprovalRule{}, false, nil
+}
+
+// ApproveByRule approves the pending nodes of the environment of a rule that
+// match it, returning them
+func (n *NodeManager) ApproveByRule(rule ApprovalRule, user string) ([]OsqueryNode, error) {
+	pending, err := n.GetByApproval(rule.EnvironmentID, ApprovalPending)
+	if err != nil {
+		return nil, err
+	}
+	approved := []OsqueryNode{}
+	for _, node := range pending {
+		if !rule.Matches(node) {
+			continue
+		}
+		if err := n.setApproval(node, "", user+" (rule "+rule.String()+")"); err != nil {
+			return approved, err
+		}
+		approved = append(approved, node)
+	}
+	return approved, nil
+}
@@ -0,0 +1,89 @@
+package nodes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestApprovalRulesApprovePendingNodes(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	manager := CreateNodes(db)
+	web := OsqueryNode{UUID: "WEB", NodeKey: "web-key", Hostname: "Web-01.corp", EnvironmentID: 1, Approval: ApprovalPending}
+	laptop := OsqueryNode{UUID: "LAPTOP", NodeKey: "laptop-key", Hostname: "laptop", HardwareSerial: "C02XYZ", EnvironmentID: 1, Approval: ApprovalPending}
+	other := OsqueryNode{UUID: "OTHER", NodeKey: "other-key", Hostname: "web-02", EnvironmentID: 2, Approval: ApprovalPending}
+	for _, n := range []*OsqueryNode{&web, &laptop, &other} {
+		require.NoError(t, manager.Create(n))
+	}
+
+	_, err = manager.CreateApprovalRule(ApprovalRule{EnvironmentID: 1, Type: "mac", Pattern: "x"})
+	require.Error(t, err)
+	_, err = manager.CreateApprovalRule(ApprovalRule{EnvironmentID: 1, Type: ApprovalRuleHostname, Pattern: "web-["})
+	require.Error(t, err)
+	_, err = manager.CreateApprovalRule(ApprovalRule{EnvironmentID: 1, Type: ApprovalRuleSerial, Pattern: " "})
+	require.Error(t, err)
+
+	rule, err := manager.CreateApprovalRule(ApprovalRule{EnvironmentID: 1, Type: ApprovalRuleHostname, Pattern: "WEB-*", CreatedBy: "alice"})
+	require.NoError(t, err)
+	require.Equal(t, "web-*", rule.Pattern)
+	approved, err := manager.ApproveByRule(rule, "alice")
+	require.NoError(t, err)
+	require.Len(t, approved, 1)
+	require.Equal(t, "WEB", approved[0].UUID)
+	node, err := manager.GetByUUID("WEB")
+	require.NoError(t, err)
+	require.True(t, node.Approved())
+	require.Equal(t, "alice (rule hostname web-*)", node.ApprovalBy)
+	// Rules only approve nodes of their environment
+	node, err = manager.GetByUUID("OTHER")
+	require.NoError(t, err)
+	require.False(t, node.Approved())
+
+	serial, err := manager.CreateApprovalRule(ApprovalRule{EnvironmentID: 1, Type: ApprovalRuleSerial, Pattern: "c02xyz"})
+	require.NoError(t, err)
+	matched, ok, err := manager.MatchApprovalRule(1, laptop)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, serial.ID, matched.ID)
+	_, ok, err = manager.MatchApprovalRule(1, OsqueryNode{Hostname: "db-01"})
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	rules, err := manager.ApprovalRules(1)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	require.NoError(t, manager.DeleteApprovalRule(1, serial.ID))
+	require.ErrorIs(t, manager.DeleteApprovalRule(2, rule.ID), gorm.ErrRecordNotFound)
+}
+
+func TestApproveAndRejectNodes(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	manager := CreateNodes(db)
+	node := OsqueryNode{UUID: "PENDING", NodeKey: "pending-key", EnvironmentID: 1, Approval: ApprovalPending}
+	require.NoError(t, manager.Create(&node))
+	_, err = manager.GetByKey("pending-key")
+	require.NoError(t, err)
+
+	pending, err := manager.GetByApproval(1, ApprovalPending)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.NoError(t, manager.Reject(node, "alice"))
+	cached, err := manager.GetByKey("pending-key")
+	require.NoError(t, err)
+	require.Equal(t, ApprovalRejected, cached.Approval)
+	require.Error(t, manager.Reject(cached, "alice"))
+	rejected, err := manager.GetByApproval(1, ApprovalRejected)
+	require.NoError(t, err)
+	require.Len(t, rejected, 1)
+
+	require.NoError(t, manager.Approve(cached, "bob"))
+	cached, err = manager.GetByKey("pending-key")
+	require.NoError(t, err)
+	require.True(t, cached.Approved())
+	require.Equal(t, "bob", cached.ApprovalBy)
+	require.Error(t, manager.Approve(cached, "bob"))
+}
@@ -0,0 +1,378 @@
+package nodes
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Types of custom node attributes
+const (
+	AttributeString = "string"
+	AttributeNumber = "number"
+	AttributeBool   = "bool"
+)
+
+// Columns of imported attributes used to match nodes
+const (
+	AttributeKeyUUID     = "uuid"
+	AttributeKeyHostname = "hostname"
+	AttributeKeySerial   = "serial"
+)
+
+// NodeAttribute is a typed custom attribute of a node, like its owner,
+// cost center, criticality or location
+type NodeAttribute struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	NodeID        uint      `gorm:"uniqueIndex:idx_node_attribute" json:"node_id"`
+	EnvironmentID uint      `gorm:"index" json:"environment_id"`
+	Name          string    `gorm:"uniqueIndex:idx_node_attribute;index" json:"name"`
+	Type          string    `json:"type"`
+	Value         string    `json:"value"`
+	SetBy         string    `json:"set_by"`
+}
+
+// AttributeValue is the type and value of an imported attribute. In JSON it
+// is either a string, number or boolean, or an object with type and value.
+type AttributeValue struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// UnmarshalJSON infers the type of an attribute from its JSON value
+func (v *AttributeValue) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	switch value := raw.(type) {
+	case string:
+		*v = AttributeValue{Type: AttributeString, Value: value}
+	case float64:
+		*v = AttributeValue{Type: AttributeNumber, Value: strconv.FormatFloat(value, 'f', -1, 64)}
+	case bool:
+		*v = AttributeValue{Type: AttributeBool, Value: strconv.FormatBool(value)}
+	case map[string]interface{}:
+		type plain AttributeValue
+		var p plain
+		if err := json.Unmarshal(data, &p); err != nil {
+			return err
+		}
+		*v = AttributeValue(p)
+	default:
+		return fmt.Errorf("unsupported attribute value %s", string(data))
+	}
+	return nil
+}
+
+// AttributeRecord is a set of attributes for the nodes matching one of its
+// UUID, hostname or hardware serial, checked in that order
+type AttributeRecord struct {
+	UUID       string                    `json:"uuid,omitempty"`
+	Hostname   string                    `json:"hostname,omitempty"`
+	Serial     string                    `json:"serial,omitempty"`
+	Attributes map[string]AttributeValue `json:"attributes"`
+}
+
+// key returns the column and value used to match nodes
+func (r AttributeRecord) key() (string, string) {
+	switch {
+	case r.UUID != "":
+		return AttributeKeyUUID, r.UUID
+	case r.Hostname != "":
+		return AttributeKeyHostname, r.Hostname
+	default:
+		return AttributeKeySerial, r.Serial
+	}
+}
+
+// AttributeImportResult is the outcome of importing attributes
+type AttributeImportResult struct {
+	Records   int      `json:"records"`
+	Nodes     int      `json:"nodes"`
+	Updated   int      `json:"updated"`
+	Unmatched []string `json:"unmatched"`
+}
+
+// AttributeFilter matches nodes with a custom attribute set to a value
+type AttributeFilter struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+var attributeName = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+// NormalizeAttribute validates the name, type and value of an attribute and
+// returns its canonical value, so numbers and booleans compare equal however
+// they were written. An empty type is a string.
+func NormalizeAttribute(name, attrType, value string) (string, string, error) {
+	if !attributeName.MatchString(name) {
+		return "", "", fmt.Errorf("invalid attribute name %q", name)
+	}
+	switch attrType {
+	case "", AttributeString:
+		return AttributeString, value, nil
+	case AttributeNumber:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return "", "", fmt.Errorf("attribute %s is not a number: %q", name, value)
+		}
+		return AttributeNumber, strconv.FormatFloat(f, 'f', -1, 64), nil
+	case AttributeBool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return "", "", fmt.Errorf("attribute %s is not a boolean: %q", name, value)
+		}
+		return AttributeBool, strconv.FormatBool(b), nil
+	}
+	return "", "", fmt.Errorf("invalid attribute type %q", attrType)
+}
+
+// ParseAttributeFilter parses a name=value attribute filter
+func ParseAttributeFilter(filter string) (AttributeFilter, error) {
+	name, value, ok := strings.Cut(filter, "=")
+	if !ok || !attributeName.MatchString(name) {
+		return AttributeFilter{}, fmt.Errorf("invalid attribute filter %q, expected name=value", filter)
+	}
+	return AttributeFilter{Name: name, Value: value}, nil
+}
+
+// SetAttribute creates or replaces a custom attribute of a node
+func (n *NodeManager) SetAttribute(node OsqueryNode, name, attrType, value, user string) (NodeAttribute, error) {
+	attrType, value, err := NormalizeAttribute(name, attrType, value)
+	if err != nil {
+		return NodeAttribute{}, err
+	}
+	var attr NodeAttribute
+	err = n.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		attr, err = setAttributeTx(tx, node, name, attrType, value, user)
+		return err
+	})
+	return attr, err
+}
+
+// setAttributeTx writes an already normalized attribute of a node
+func setAttributeTx(tx *gorm.DB, node OsqueryNode, name, attrType, value, user string) (NodeAttribute, error) {
+	var attr NodeAttribute
+	if err := tx.Where("node_id = ? AND name = ?", node.ID, name).Limit(1).Find(&attr).Error; err != nil {
+		return attr, err
+	}
+	attr.NodeID = node.ID
+	attr.EnvironmentID = node.EnvironmentID
+	attr.Name = name
+	attr.Type = attrType
+	attr.Value = value
+	attr.SetBy = user
+	return attr, tx.Save(&attr).Error
+}
+
+// Attributes to retrieve the custom attributes of a node, sorted by name
+func (n *NodeManager) Attributes(nodeID uint) ([]NodeAttribute, error) {
+	var attrs []NodeAttribute
+	if err := n.DB.Where("node_id = ?", nodeID).Order("name").Find(&attrs).Error; err != nil {
+		return attrs, fmt.Errorf("attributes %w", err)
+	}
+	return attrs, nil
+}
+
+// DeleteAttribute removes a custom attribute of a node
+func (n *NodeManager) DeleteAttribute(nodeID uint, name string) error {
+	result := n.DB.Where("node_id = ? AND name = ?", nodeID, name).Delete(&NodeAttribute{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// NodeIDsByAttribute returns the IDs of the nodes of an environment with a
+// custom attribute set to a value
+func (n *NodeManager) NodeIDsByAttribute(envID uint, filter AttributeFilter) ([]uint, error) {
+	var ids []uint
+	if err := n.DB.Model(&NodeAttribute{}).Where("environment_id = ? AND name = ? AND value = ?", envID, filter.Name, filter.Value).
+		Pluck("node_id", &ids).Error; err != nil {
+		return ids, fmt.Errorf("nodes by attribute %w", err)
+	}
+	return ids, nil
+}
+
+// applyAttributeFilters keeps the nodes of a query matching every filter
+func (n *NodeManager) applyAttributeFilters(query *gorm.DB, filters []AttributeFilter) *gorm.DB {
+	for _, f := range filters {
+		query = query.Where("id IN (?)", n.DB.Model(&NodeAttribute{}).Select("node_id").Where("name = ? AND value = ?", f.Name, f.Value))
+	}
+	return query
+}
+
+// moveAttributesTx moves the attributes of a node to another node, keeping
+// the values the other node already has
+func moveAttributesTx(tx *gorm.DB, from, to OsqueryNode) error {
+	var attrs []NodeAttribute
+	if err := tx.Where("node_id = ?", from.ID).Find(&attrs).Error; err != nil {
+		return err
+	}
+	for _, attr := range attrs {
+		var existing int64
+		if err := tx.Model(&NodeAttribute{}).Where("node_id = ? AND name = ?", to.ID, attr.Name).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			if err := tx.Delete(&attr).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		if err := tx.Model(&attr).Updates(map[string]interface{}{"node_id": to.ID, "environment_id": to.EnvironmentID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportAttributes sets the attributes of every record on the nodes of an
+// environment matching its key. Nothing is written if any attribute is invalid.
+func (n *NodeManager) ImportAttributes(envID uint, records []AttributeRecord, user string) (AttributeImportResult, error) {
+	result := AttributeImportResult{Records: len(records), Unmatched: []string{}}
+	normalized := make([]AttributeRecord, len(records))
+	for i, record := range records {
+		if _, key := record.key(); key == "" {
+			return result, fmt.Errorf("record %d has no uuid, hostname or serial", i+1)
+		}
+		normalized[i] = record
+		normalized[i].Attributes = make(map[string]AttributeValue, len(record.Attributes))
+		for name, value := range record.Attributes {
+			attrType, attrValue, err := NormalizeAttribute(name, value.Type, value.Value)
+			if err != nil {
+				return result, fmt.Errorf("record %d: %w", i+1, err)
+			}
+			normalized[i].Attributes[name] = AttributeValue{Type: attrType, Value: attrValue}
+		}
+	}
+	err := n.DB.Transaction(func(tx *gorm.DB) error {
+		for _, record := range normalized {
+			column, key := record.key()
+			query := tx.Where("environment_id = ?", envID)
+			switch column {
+			case AttributeKeyUUID:
+				query = query.Where("UPPER(uuid) = ?", strings.ToUpper(key))
+			case AttributeKeyHostname:
+				query = query.Where("LOWER(hostname) = ?", strings.ToLower(key))
+			default:
+				query = query.Where("hardware_serial = ?", key)
+			}
+			var matched []OsqueryNode
+			if err := query.Find(&matched).Error; err != nil {
+				return err
+			}
+			if len(matched) == 0 {
+				result.Unmatched = append(result.Unmatched, column+"="+key)
+				continue
+			}
+			names := make([]string, 0, len(record.Attributes))
+			for name := range record.Attributes {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, node := range matched {
+				for _, name := range names {
+					value := record.Attributes[name]
+					if _, err := setAttributeTx(tx, node, name, value.Type, value.Value, user); err != nil {
+						return err
+					}
+					result.Updated++
+				}
+			}
+			result.Nodes += len(matched)
+		}
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("import attributes %w", err)
+	}
+	return result, nil
+}
+
+// ParseAttributeJSON reads attribute records from a JSON array
+func ParseAttributeJSON(r io.Reader) ([]AttributeRecord, error) {
+	var records []AttributeRecord
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return nil, fmt.Errorf("parse JSON %w", err)
+	}
+	return records, nil
+}
+
+// ParseAttributeCSV reads attribute records from CSV. The header has a uuid,
+// hostname or serial column to match nodes and one column per attribute,
+// named name or name:type. Empty cells are skipped.
+func ParseAttributeCSV(r io.Reader) ([]AttributeRecord, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("parse CSV header %w", err)
+	}
+	keyColumn := -1
+	type column struct {
+		name     string
+		attrType string
+	}
+	columns := make([]column, len(header))
+	for i, h := range header {
+		h = strings.TrimSpace(h)
+		switch strings.ToLower(h) {
+		case AttributeKeyUUID, AttributeKeyHostname, AttributeKeySerial:
+			if keyColumn >= 0 {
+				return nil, errors.New("CSV has more than one uuid, hostname or serial column")
+			}
+			keyColumn = i
+			columns[i] = column{name: strings.ToLower(h)}
+			continue
+		}
+		name, attrType, _ := strings.Cut(h, ":")
+		columns[i] = column{name: name, attrType: attrType}
+	}
+	if keyColumn < 0 {
+		return nil, errors.New("CSV has no uuid, hostname or serial column")
+	}
+	var records []AttributeRecord
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse CSV %w", err)
+		}
+		record := AttributeRecord{Attributes: map[string]AttributeValue{}}
+		switch columns[keyColumn].name {
+		case AttributeKeyUUID:
+			record.UUID = row[keyColumn]
+		case AttributeKeyHostname:
+			record.Hostname = row[keyColumn]
+		default:
+			record.Serial = row[keyColumn]
+		}
+		for i, value := range row {
+			if i == keyColumn || value == "" {
+				continue
+			}
+			record.Attributes[columns[i].name] = AttributeValue{Type: columns[i].attrType, Value: value}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
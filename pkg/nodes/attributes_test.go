@@ -0,0 +1,84 @@
+package nodes
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestNormalizeAttribute(t *testing.T) {
+	attrType, value, err := NormalizeAttribute("criticality", AttributeNumber, " 3.0 ")
+	require.NoError(t, err)
+	require.Equal(t, AttributeNumber, attrType)
+	require.Equal(t, "3", value)
+	_, value, err = NormalizeAttribute("pci", AttributeBool, "1")
+	require.NoError(t, err)
+	require.Equal(t, "true", value)
+	attrType, _, err = NormalizeAttribute("owner", "", "alice")
+	require.NoError(t, err)
+	require.Equal(t, AttributeString, attrType)
+
+	_, _, err = NormalizeAttribute("criticality", AttributeNumber, "high")
+	require.Error(t, err)
+	_, _, err = NormalizeAttribute("Owner Name", AttributeString, "alice")
+	require.Error(t, err)
+	_, _, err = NormalizeAttribute("owner", "date", "alice")
+	require.Error(t, err)
+}
+
+func TestImportAttributesFromCSVAndFilterNodes(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	manager := CreateNodes(db)
+	for _, node := range []OsqueryNode{
+		{UUID: "NODE-1", Hostname: "web1", HardwareSerial: "SN-1", Environment: "dev", EnvironmentID: 1},
+		{UUID: "NODE-2", Hostname: "web2", HardwareSerial: "SN-2", Environment: "dev", EnvironmentID: 1},
+		{UUID: "NODE-3", Hostname: "web1", HardwareSerial: "SN-3", Environment: "prod", EnvironmentID: 2},
+	} {
+		require.NoError(t, manager.Create(&node))
+	}
+
+	records, err := ParseAttributeCSV(strings.NewReader("hostname,owner,criticality:number,pci:bool\nWEB1,alice,3,yes\nweb9,bob,1,\n"))
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	_, err = manager.ImportAttributes(1, records, "alice")
+	require.Error(t, err, "yes is not a boolean")
+
+	records, err = ParseAttributeCSV(strings.NewReader("hostname,owner,criticality:number,pci:bool\nWEB1,alice,3.0,true\nweb9,bob,1,\n"))
+	require.NoError(t, err)
+	result, err := manager.ImportAttributes(1, records, "alice")
+	require.NoError(t, err)
+	require.Equal(t, 1, result.Nodes)
+	require.Equal(t, 3, result.Updated)
+	require.Equal(t, []string{"hostname=web9"}, result.Unmatched)
+
+	records, err = ParseAttributeJSON(strings.NewReader(`[{"serial":"SN-2","attributes":{"owner":"bob","criticality":3}}]`))
+	require.NoError(t, err)
+	_, err = manager.ImportAttributes(1, records, "alice")
+	require.NoError(t, err)
+
+	attrs, err := manager.Attributes(1)
+	require.NoError(t, err)
+	require.Len(t, attrs, 3)
+	require.Equal(t, "criticality", attrs[0].Name)
+	require.Equal(t, AttributeNumber, attrs[0].Type)
+	require.Equal(t, "3", attrs[0].Value)
+	require.Equal(t, "alice", attrs[0].SetBy)
+	noAttrs, err := manager.Attributes(3)
+	require.NoError(t, err)
+	require.Empty(t, noAttrs, "nodes of other environments are not matched")
+
+	ids, err := manager.NodeIDsByAttribute(1, AttributeFilter{Name: "criticality", Value: "3"})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []uint{1, 2}, ids)
+	page, err := manager.GetByEnvPaged("dev", AllNodes, 24, "", 1, 50, "", false, "", []AttributeFilter{{Name: "criticality", Value: "3"}, {Name: "owner", Value: "bob"}})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	require.Equal(t, "NODE-2", page.Items[0].UUID)
+
+	require.NoError(t, manager.DeleteAttribute(2, "owner"))
+	require.ErrorIs(t, manager.DeleteAttribute(2, "owner"), gorm.ErrRecordNotFound)
+}
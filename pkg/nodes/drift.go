@@ -0,0 +1,74 @@
+package nodes
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DriftReport lists the nodes of an environment whose reported config hash
+// disagrees with the configuration served to them for too long
+type DriftReport struct {
+	// Intervals is how many config intervals a disagreement lasts before
+	// the node counts as drifted
+	Intervals int64 `json:"intervals"`
+	// ConfigInterval is the config interval of the environment in seconds
+	ConfigInterval int `json:"config_interval"`
+	// Cutoff is when the configuration served to drifted nodes changed at
+	// the latest
+	Cutoff  time.Time     `json:"cutoff"`
+	Count   int           `json:"count"`
+	Drifted []OsqueryNode `json:"drifted"`
+}
+
+// DriftCutoff returns the time before which the configuration served to a
+// node must have changed for a disagreeing config hash to count as drift
+func DriftCutoff(configInterval int, intervals int64, now time.Time) time.Time {
+	return now.Add(-time.Duration(int64(configInterval)*intervals) * time.Second)
+}
+
+// driftedQuery selects the nodes of an environment that checked in after the
+// cutoff and still report a config hash other than the one they were served
+// before the cutoff. Nodes that do not report hashes are never drifted.
+func (n *NodeManager) driftedQuery(envID uint, cutoff time.Time) *gorm.DB {
+	return n.DB.Model(&OsqueryNode{}).
+		Where("environment_id = ? AND expected_config_hash <> '' AND config_hash <> ''", envID).
+		Where("config_hash <> expected_config_hash AND expected_config_at <= ? AND last_seen > ?", cutoff, cutoff)
+}
+
+// Drifted to retrieve the drifted nodes of an environment
+func (n *NodeManager) Drifted(envID uint, cutoff time.Time) ([]OsqueryNode, error) {
+	var nodes []OsqueryNode
+	if err := n.driftedQuery(envID, cutoff).Order("expected_config_at").Find(&nodes).Error; err != nil {
+		return nodes, fmt.Errorf("drifted %w", err)
+	}
+	return nodes, nil
+}
+
+// CountDrifted to count the drifted nodes of an environment
+func (n *NodeManager) CountDrifted(envID uint, cutoff time.Time) (int64, error) {
+	var count int64
+	if err := n.driftedQuery(envID, cutoff).Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("count drifted %w", err)
+	}
+	return count, nil
+}
+
+// UpdateExpectedConfig to record the config hash of the configuration served
+// to a node. The time is only updated when the hash changes, so it is when
+// the node was first served that configuration.
+func (n *NodeManager) UpdateExpectedConfig(nodeID uint, hash string, servedAt time.Time) error {
+	return n.UpdateExpectedConfigBatch([]uint{nodeID}, hash, servedAt)
+}
+
+// UpdateExpectedConfigBatch to record the config hash of the configuration
+// served to many nodes in one update, like UpdateExpectedConfig
+func (n *NodeManager) UpdateExpectedConfigBatch(nodeIDs []uint, hash string, servedAt time.Time) error {
+	return n.DB.Model(&OsqueryNode{}).
+		Where("id IN ? AND (expected_config_hash IS NULL OR expected_config_hash <> ?)", nodeIDs, hash).
+		UpdateColumns(map[string]interface{}{
+			"expected_config_hash": hash,
+			"expected_config_at":   servedAt,
+		}).Error
+}
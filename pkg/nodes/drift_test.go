@@ -0,0 +1,69 @@
+package nodes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestDriftedNodesDisagreeLongEnough(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	manager := CreateNodes(db)
+	now := time.Now()
+	served := now.Add(-time.Hour)
+	create := func(uuid, reported string, lastSeen time.Time) OsqueryNode {
+		node := OsqueryNode{UUID: uuid, EnvironmentID: 1, ConfigHash: reported, LastSeen: lastSeen}
+		require.NoError(t, manager.Create(&node))
+		require.NoError(t, manager.UpdateExpectedConfig(node.ID, "expected", served))
+		return node
+	}
+	create("IN-SYNC", "expected", now)
+	drifted := create("OVERRIDE", "local", now)
+	create("OFFLINE", "local", now.Add(-2*time.Hour))
+	create("SILENT", "", now)
+
+	// Serving the same configuration again keeps when it was first served
+	require.NoError(t, manager.UpdateExpectedConfig(drifted.ID, "expected", now))
+	cutoff := DriftCutoff(300, 3, now)
+	found, err := manager.Drifted(1, cutoff)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	require.Equal(t, "OVERRIDE", found[0].UUID)
+	require.WithinDuration(t, served, found[0].ExpectedConfigAt, time.Second)
+
+	// A new configuration gives the node its config intervals again
+	require.NoError(t, manager.UpdateExpectedConfig(drifted.ID, "new", now))
+	count, err := manager.CountDrifted(1, cutoff)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
+
+func TestUpdateExpectedConfigBatch(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	manager := CreateNodes(db)
+	now := time.Now()
+	served := now.Add(-time.Hour)
+	var ids []uint
+	for _, uuid := range []string{"NODE-1", "NODE-2", "NODE-3"} {
+		node := OsqueryNode{UUID: uuid, EnvironmentID: 1}
+		require.NoError(t, manager.Create(&node))
+		ids = append(ids, node.ID)
+	}
+	require.NoError(t, manager.UpdateExpectedConfig(ids[0], "expected", served))
+
+	// Nodes already expected to have the hash keep when they were served it
+	require.NoError(t, manager.UpdateExpectedConfigBatch(ids, "expected", now))
+	var found []OsqueryNode
+	require.NoError(t, db.Order("id").Find(&found).Error)
+	require.Len(t, found, 3)
+	require.WithinDuration(t, served, found[0].ExpectedConfigAt, time.Second)
+	for _, node := range found[1:] {
+		require.Equal(t, "expected", node.ExpectedConfigHash)
+		require.WithinDuration(t, now, node.ExpectedConfigAt, time.Second)
+	}
+}
@@ -0,0 +1,180 @@
+package nodes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Reasons for nodes to be duplicates
+const (
+	DuplicateSerial   = "hardware_serial"
+	DuplicateUUID     = "uuid"
+	DuplicateHostname = "hostname_platform"
+)
+
+// ArchiveTriggerMerged is the archive trigger of nodes merged into a duplicate
+const ArchiveTriggerMerged = "merged"
+
+// ignoredSerials are placeholder serials reported by many unrelated machines
+var ignoredSerials = map[string]bool{
+	"":                       true,
+	"0":                      true,
+	"none":                   true,
+	"unknown":                true,
+	"not specified":          true,
+	"default string":         true,
+	"system serial number":   true,
+	"to be filled by o.e.m.": true,
+	"0123456789":             true,
+}
+
+// DuplicateGroup is a set of nodes that look like the same machine, newest first
+type DuplicateGroup struct {
+	Reason string        `json:"reason"`
+	Value  string        `json:"value"`
+	Nodes  []OsqueryNode `json:"nodes"`
+}
+
+// NodeMover moves what belongs to a node to another one inside a transaction
+type NodeMover func(tx *gorm.DB, from, to OsqueryNode) error
+
+// Duplicates finds the nodes of an environment that share a hardware serial,
+// or a hostname and platform, and the nodes sharing a UUID with a node of the
+// environment in any environment, since enrolling only checks the UUID within
+// the environment.
+func (n *NodeManager) Duplicates(envID uint) ([]DuplicateGroup, error) {
+	var groups []DuplicateGroup
+	var serials []string
+	if err := n.DB.Model(&OsqueryNode{}).Select("hardware_serial").
+		Where("environment_id = ? AND hardware_serial <> ''", envID).
+		Group("hardware_serial").Having("COUNT(*) > 1").Pluck("hardware_serial", &serials).Error; err != nil {
+		return nil, fmt.Errorf("duplicate serials %w", err)
+	}
+	for _, serial := range serials {
+		if ignoredSerials[strings.ToLower(strings.TrimSpace(serial))] {
+			continue
+		}
+		var found []OsqueryNode
+		if err := n.DB.Where("environment_id = ? AND hardware_serial = ?", envID, serial).Find(&found).Error; err != nil {
+			return nil, fmt.Errorf("nodes by serial %w", err)
+		}
+		groups = append(groups, newDuplicateGroup(DuplicateSerial, serial, found))
+	}
+	var uuids []string
+	inEnv := n.DB.Model(&OsqueryNode{}).Select("UPPER(uuid)").Where("environment_id = ?", envID)
+	if err := n.DB.Model(&OsqueryNode{}).Select("UPPER(uuid) AS duplicate").
+		Where("UPPER(uuid) IN (?)", inEnv).
+		Group("UPPER(uuid)").Having("COUNT(*) > 1").Pluck("duplicate", &uuids).Error; err != nil {
+		return nil, fmt.Errorf("duplicate uuids %w", err)
+	}
+	for _, uuid := range uuids {
+		var found []OsqueryNode
+		if err := n.DB.Where("UPPER(uuid) = ?", uuid).Find(&found).Error; err != nil {
+			return nil, fmt.Errorf("nodes by uuid %w", err)
+		}
+		groups = append(groups, newDuplicateGroup(DuplicateUUID, uuid, found))
+	}
+	type hostPlatform struct {
+		Hostname string
+		Platform string
+	}
+	var hosts []hostPlatform
+	if err := n.DB.Model(&OsqueryNode{}).Select("LOWER(hostname) AS hostname, platform").
+		Where("environment_id = ? AND hostname <> ''", envID).
+		Group("LOWER(hostname), platform").Having("COUNT(*) > 1").Scan(&hosts).Error; err != nil {
+		return nil, fmt.Errorf("duplicate hostnames %w", err)
+	}
+	for _, host := range hosts {
+		var found []OsqueryNode
+		if err := n.DB.Where("environment_id = ? AND LOWER(hostname) = ? AND platform = ?", envID, host.Hostname, host.Platform).Find(&found).Error; err != nil {
+			return nil, fmt.Errorf("nodes by hostname %w", err)
+		}
+		groups = append(groups, newDuplicateGroup(DuplicateHostname, host.Hostname+"/"+host.Platform, found))
+	}
+	return groups, nil
+}
+
+// GetByIDs to retrieve nodes by their IDs
+func (n *NodeManager) GetByIDs(ids []uint) ([]OsqueryNode, error) {
+	var found []OsqueryNode
+	if err := n.DB.Where("id IN ?", ids).Find(&found).Error; err != nil {
+		return found, err
+	}
+	return found, nil
+}
+
+func newDuplicateGroup(reason, value string, found []OsqueryNode) DuplicateGroup {
+	sortNewestFirst(found)
+	return DuplicateGroup{Reason: reason, Value: value, Nodes: found}
+}
+
+// sortNewestFirst orders nodes by enrollment, the most recent first
+func sortNewestFirst(found []OsqueryNode) {
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].CreatedAt.Equal(found[j].CreatedAt) {
+			return found[i].ID > found[j].ID
+		}
+		return found[i].CreatedAt.After(found[j].CreatedAt)
+	})
+}
+
+// DuplicateReason returns why two nodes look like the same machine, or an
+// empty string when they do not
+func DuplicateReason(a, b OsqueryNode) string {
+	switch {
+	case strings.EqualFold(a.UUID, b.UUID):
+		return DuplicateUUID
+	case a.EnvironmentID != b.EnvironmentID:
+		return ""
+	case a.HardwareSerial == b.HardwareSerial && !ignoredSerials[strings.ToLower(strings.TrimSpace(a.HardwareSerial))]:
+		return DuplicateSerial
+	case a.Hostname != "" && strings.EqualFold(a.Hostname, b.Hostname) && a.Platform == b.Platform:
+		return DuplicateHostname
+	}
+	return ""
+}
+
+// Merge keeps the newest of duplicate nodes, moves to it the attributes of the
+// others and what else belongs to them with movers, and archives the others with trigger merged, all in one
+// transaction. It returns the kept node and the archived ones.
+func (n *NodeManager) Merge(duplicates []OsqueryNode, movers ...NodeMover) (OsqueryNode, []OsqueryNode, error) {
+	if len(duplicates) < 2 {
+		return OsqueryNode{}, nil, fmt.Errorf("at least two nodes are needed to merge")
+	}
+	merged := append([]OsqueryNode{}, duplicates...)
+	sortNewestFirst(merged)
+	keep, others := merged[0], merged[1:]
+	seen := map[uint]bool{keep.ID: true}
+	for _, other := range others {
+		if seen[other.ID] {
+			return OsqueryNode{}, nil, fmt.Errorf("node %s is repeated", other.UUID)
+		}
+		seen[other.ID] = true
+		if DuplicateReason(keep, other) == "" {
+			return OsqueryNode{}, nil, fmt.Errorf("node %s is not a duplicate of %s", other.UUID, keep.UUID)
+		}
+	}
+	err := n.DB.Transaction(func(tx *gorm.DB) error {
+		for _, other := range others {
+			if err := moveAttributesTx(tx, other, keep); err != nil {
+				return err
+			}
+			for _, move := range movers {
+				if err := move(tx, other, keep); err != nil {
+					return err
+				}
+			}
+			if err := archiveDeleteTx(tx, other, ArchiveTriggerMerged); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return OsqueryNode{}, nil, fmt.Errorf("merge %w", err)
+	}
+	return keep, others, nil
+}
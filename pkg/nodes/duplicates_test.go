@@ -0,0 +1,81 @@
+package nodes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestDuplicatesBySerialUUIDAndHostname(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	manager := CreateNodes(db)
+	now := time.Now()
+	for i, node := range []OsqueryNode{
+		{UUID: "REIMAGED-OLD", HardwareSerial: "SN-1", Hostname: "a", Platform: "darwin", EnvironmentID: 1},
+		{UUID: "REIMAGED-NEW", HardwareSerial: "SN-1", Hostname: "b", Platform: "darwin", EnvironmentID: 1},
+		{UUID: "vm-template", HardwareSerial: "VM-1", Hostname: "c", Platform: "linux", EnvironmentID: 1},
+		{UUID: "VM-TEMPLATE", HardwareSerial: "VM-2", Hostname: "d", Platform: "linux", EnvironmentID: 2},
+		{UUID: "PLACEHOLDER-1", HardwareSerial: "To Be Filled By O.E.M.", Hostname: "e", Platform: "windows", EnvironmentID: 1},
+		{UUID: "PLACEHOLDER-2", HardwareSerial: "To Be Filled By O.E.M.", Hostname: "f", Platform: "windows", EnvironmentID: 1},
+		{UUID: "HOST-1", HardwareSerial: "SN-2", Hostname: "Web", Platform: "ubuntu", EnvironmentID: 1},
+		{UUID: "HOST-2", HardwareSerial: "SN-3", Hostname: "web", Platform: "ubuntu", EnvironmentID: 1},
+		{UUID: "OTHER-ENV", HardwareSerial: "SN-1", Hostname: "g", Platform: "darwin", EnvironmentID: 2},
+	} {
+		node.CreatedAt = now.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, manager.Create(&node))
+	}
+
+	groups, err := manager.Duplicates(1)
+	require.NoError(t, err)
+	require.Len(t, groups, 3)
+	byReason := map[string]DuplicateGroup{}
+	for _, g := range groups {
+		byReason[g.Reason] = g
+	}
+	require.Equal(t, "SN-1", byReason[DuplicateSerial].Value)
+	require.Len(t, byReason[DuplicateSerial].Nodes, 2)
+	require.Equal(t, "REIMAGED-NEW", byReason[DuplicateSerial].Nodes[0].UUID)
+	require.Len(t, byReason[DuplicateUUID].Nodes, 2)
+	require.Equal(t, "VM-TEMPLATE", byReason[DuplicateUUID].Nodes[0].UUID)
+	require.Equal(t, "web/ubuntu", byReason[DuplicateHostname].Value)
+	require.Equal(t, "HOST-2", byReason[DuplicateHostname].Nodes[0].UUID)
+}
+
+func TestMergeKeepsNewestAndArchivesOthers(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	manager := CreateNodes(db)
+	now := time.Now()
+	old := OsqueryNode{UUID: "OLD", HardwareSerial: "SN-1", EnvironmentID: 1, CreatedAt: now.Add(-time.Hour)}
+	newest := OsqueryNode{UUID: "NEW", HardwareSerial: "SN-1", EnvironmentID: 1, CreatedAt: now}
+	unrelated := OsqueryNode{UUID: "UNRELATED", HardwareSerial: "SN-2", EnvironmentID: 1, CreatedAt: now}
+	require.NoError(t, manager.Create(&old))
+	require.NoError(t, manager.Create(&newest))
+	require.NoError(t, manager.Create(&unrelated))
+
+	_, _, err = manager.Merge([]OsqueryNode{old, unrelated})
+	require.Error(t, err)
+	_, _, err = manager.Merge([]OsqueryNode{newest, newest})
+	require.Error(t, err)
+
+	var moved []string
+	mover := func(tx *gorm.DB, from, to OsqueryNode) error {
+		moved = append(moved, from.UUID+">"+to.UUID)
+		return nil
+	}
+	keep, archived, err := manager.Merge([]OsqueryNode{old, newest}, mover)
+	require.NoError(t, err)
+	require.Equal(t, "NEW", keep.UUID)
+	require.Len(t, archived, 1)
+	require.Equal(t, []string{"OLD>NEW"}, moved)
+	require.False(t, manager.CheckByUUID("OLD"))
+	require.True(t, manager.CheckByUUID("NEW"))
+	archives, err := manager.GetArchives("OLD")
+	require.NoError(t, err)
+	require.Len(t, archives, 1)
+	require.Equal(t, ArchiveTriggerMerged, archives[0].Trigger)
+}
@@ -0,0 +1,127 @@
+package nodes
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// NodeHistoryEntry records one change of a metadata field of a node
+type NodeHistoryEntry struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time `gorm:"index" json:"created_at"`
+	NodeID        uint      `gorm:"index" json:"node_id"`
+	UUID          string    `gorm:"index" json:"uuid"`
+	EnvironmentID uint      `json:"environment_id"`
+	Field         string    `json:"field"`
+	OldValue      string    `json:"old_value"`
+	NewValue      string    `json:"new_value"`
+}
+
+// TableName to use node_history for NodeHistoryEntry
+func (NodeHistoryEntry) TableName() string {
+	return "node_history"
+}
+
+// trackedFields are the metadata columns whose changes are kept as history
+var trackedFields = map[string]func(OsqueryNode) string{
+	"hostname":         func(n OsqueryNode) string { return n.Hostname },
+	"localname":        func(n OsqueryNode) string { return n.Localname },
+	"ip_address":       func(n OsqueryNode) string { return n.IPAddress },
+	"username":         func(n OsqueryNode) string { return n.Username },
+	"osquery_user":     func(n OsqueryNode) string { return n.OsqueryUser },
+	"osquery_version":  func(n OsqueryNode) string { return n.OsqueryVersion },
+	"config_hash":      func(n OsqueryNode) string { return n.ConfigHash },
+	"daemon_hash":      func(n OsqueryNode) string { return n.DaemonHash },
+	"platform":         func(n OsqueryNode) string { return n.Platform },
+	"platform_version": func(n OsqueryNode) string { return n.PlatformVersion },
+	"cpu":              func(n OsqueryNode) string { return n.CPU },
+	"memory":           func(n OsqueryNode) string { return n.Memory },
+	"hardware_serial":  func(n OsqueryNode) string { return n.HardwareSerial },
+	"enroll_token":     func(n OsqueryNode) string { return n.EnrollToken },
+}
+
+// historyFromUpdates returns the history entries for the tracked columns
+// that updates changes, sorted by field so they are stored predictably
+func historyFromUpdates(node OsqueryNode, updates map[string]interface{}) []NodeHistoryEntry {
+	var entries []NodeHistoryEntry
+	for field, value := range updates {
+		current, ok := trackedFields[field]
+		if !ok {
+			continue
+		}
+		newValue := fmt.Sprint(value)
+		if oldValue := current(node); oldValue != newValue {
+			entries = append(entries, NodeHistoryEntry{
+				NodeID:        node.ID,
+				UUID:          node.UUID,
+				EnvironmentID: node.EnvironmentID,
+				Field:         field,
+				OldValue:      oldValue,
+				NewValue:      newValue,
+			})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Field < entries[j].Field })
+	return entries
+}
+
+// historyFromNode returns the history entries between a node and the data
+// replacing it, ignoring empty values in data like gorm does for updates
+func historyFromNode(node, data OsqueryNode) []NodeHistoryEntry {
+	updates := make(map[string]interface{})
+	for field, value := range trackedFields {
+		if v := value(data); v != "" {
+			updates[field] = v
+		}
+	}
+	return historyFromUpdates(node, updates)
+}
+
+// updateWithHistory applies updates to a node and records the changes of
+// tracked fields in the same transaction
+func (n *NodeManager) updateWithHistory(node OsqueryNode, updates interface{}, entries []NodeHistoryEntry) error {
+	return n.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&node).Updates(updates).Error; err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		return tx.Create(&entries).Error
+	})
+}
+
+// History to retrieve the metadata changes of a node, oldest first
+func (n *NodeManager) History(nodeID uint) ([]NodeHistoryEntry, error) {
+	var entries []NodeHistoryEntry
+	if err := n.DB.Where("node_id = ?", nodeID).Order("created_at, id").Find(&entries).Error; err != nil {
+		return entries, fmt.Errorf("history %w", err)
+	}
+	return entries, nil
+}
+
+// GetArchives to retrieve the archived records of a node by UUID, oldest first
+func (n *NodeManager) GetArchives(uuid string) ([]ArchiveOsqueryNode, error) {
+	var archived []ArchiveOsqueryNode
+	if err := n.DB.Where("uuid = ?", uuid).Order("created_at, id").Find(&archived).Error; err != nil {
+		return archived, fmt.Errorf("archives %w", err)
+	}
+	return archived, nil
+}
+
+// ChangedSince to retrieve the UUIDs of the nodes of an environment whose
+// field changed since the given time
+func (n *NodeManager) ChangedSince(envID uint, field string, since time.Time) (map[string]bool, error) {
+	var uuids []string
+	if err := n.DB.Model(&NodeHistoryEntry{}).Distinct("uuid").Where("environment_id = ? AND field = ? AND created_at >= ?", envID, field, since).Pluck("uuid", &uuids).Error; err != nil {
+		return nil, fmt.Errorf("changed since %w", err)
+	}
+	changed := make(map[string]bool, len(uuids))
+	for _, uuid := range uuids {
+		changed[uuid] = true
+	}
+	return changed, nil
+}
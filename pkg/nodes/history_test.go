@@ -0,0 +1,77 @@
+package nodes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupHistoryNode(t *testing.T) (*NodeManager, OsqueryNode) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	manager := CreateNodes(db)
+	node := OsqueryNode{UUID: "NODE-1", Hostname: "alpha", IPAddress: "10.0.0.1", OsqueryVersion: "5.10.2", EnvironmentID: 3}
+	require.NoError(t, manager.Create(&node))
+	return manager, node
+}
+
+func historyChanges(entries []NodeHistoryEntry) [][3]string {
+	changes := [][3]string{}
+	for _, entry := range entries {
+		changes = append(changes, [3]string{entry.Field, entry.OldValue, entry.NewValue})
+	}
+	return changes
+}
+
+func TestMetadataRefreshRecordsChangedFields(t *testing.T) {
+	manager, node := setupHistoryNode(t)
+
+	require.NoError(t, manager.UpdateMetadataByUUID(node.UUID, NodeMetadata{
+		Hostname:       "bravo",
+		IPAddress:      "10.0.0.1",
+		OsqueryVersion: "5.11.0",
+		BytesReceived:  100,
+	}))
+	entries, err := manager.History(node.ID)
+	require.NoError(t, err)
+	require.Equal(t, [][3]string{
+		{"hostname", "alpha", "bravo"},
+		{"osquery_version", "5.10.2", "5.11.0"},
+	}, historyChanges(entries))
+	require.Equal(t, node.UUID, entries[0].UUID)
+	require.Equal(t, uint(3), entries[0].EnvironmentID)
+
+	updated, err := manager.GetByUUID(node.UUID)
+	require.NoError(t, err)
+	require.Equal(t, "bravo", updated.Hostname)
+	require.Equal(t, 100, updated.BytesReceived)
+}
+
+func TestUpdateIPRecordsOnlyChanges(t *testing.T) {
+	manager, node := setupHistoryNode(t)
+
+	require.NoError(t, manager.UpdateIP(node.ID, "10.0.0.1"))
+	require.NoError(t, manager.UpdateIP(node.ID, "10.0.0.2"))
+	require.NoError(t, manager.UpdateIP(node.ID+100, "10.0.0.3"))
+	entries, err := manager.History(node.ID)
+	require.NoError(t, err)
+	require.Equal(t, [][3]string{{"ip_address", "10.0.0.1", "10.0.0.2"}}, historyChanges(entries))
+}
+
+func TestUpdateByUUIDRecordsReenrollChanges(t *testing.T) {
+	manager, node := setupHistoryNode(t)
+
+	require.NoError(t, manager.Archive(node.UUID, "exists"))
+	require.NoError(t, manager.UpdateByUUID(OsqueryNode{Hostname: "charlie", IPAddress: "10.0.0.1"}, node.UUID))
+	entries, err := manager.History(node.ID)
+	require.NoError(t, err)
+	require.Equal(t, [][3]string{{"hostname", "alpha", "charlie"}}, historyChanges(entries))
+
+	archives, err := manager.GetArchives(node.UUID)
+	require.NoError(t, err)
+	require.Len(t, archives, 1)
+	require.Equal(t, "alpha", archives[0].Hostname)
+}
@@ -34,6 +34,22 @@ type OsqueryNode struct {
 	UserID          uint           `json:"user_id"`
 	EnvironmentID   uint           `json:"environment_id"`
 	ExtraData       string         `json:"extra_data"`
+	Quarantined     bool           `gorm:"index" json:"quarantined"`
+	// ExpectedConfigHash is the config hash osquery reports for the last
+	// configuration served to the node, changed at ExpectedConfigAt
+	ExpectedConfigHash string    `json:"expected_config_hash"`
+	ExpectedConfigAt   time.Time `json:"expected_config_at"`
+	// EnrollToken is the name of the enroll token used by the node, empty
+	// when it enrolled with the secret of the environment
+	EnrollToken string `json:"enroll_token"`
+	// Approval is pending or rejected for nodes of environments requiring
+	// approval until an admin approves them, empty for approved nodes
+	Approval   string    `gorm:"index" json:"approval"`
+	ApprovalBy string    `json:"approval_by"`
+	ApprovalAt time.Time `json:"approval_at"`
+	// ClientCertSerial is the serial of the client certificate the node
+	// enrolled with, in environments requiring client certificates
+	ClientCertSerial string `json:"client_cert_serial"`
 }
 
 // ArchiveOsqueryNode as abstraction of an archived node
@@ -65,6 +81,7 @@ type ArchiveOsqueryNode struct {
 	UserID          uint           `json:"user_id"`
 	EnvironmentID   uint           `json:"environment_id"`
 	ExtraData       string         `json:"extra_data"`
+	EnrollToken     string         `json:"enroll_token"`
 }
 
 // NodeMetadata to hold metadata for a node
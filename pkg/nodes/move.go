@@ -0,0 +1,84 @@
+package nodes
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// NodeMove records a node reassigned to another environment
+type NodeMove struct {
+	ID                uint      `gorm:"primarykey" json:"id"`
+	CreatedAt         time.Time `gorm:"index" json:"created_at"`
+	NodeID            uint      `gorm:"index" json:"node_id"`
+	UUID              string    `json:"uuid"`
+	FromEnvironmentID uint      `json:"from_environment_id"`
+	FromEnvironment   string    `json:"from_environment"`
+	ToEnvironmentID   uint      `json:"to_environment_id"`
+	ToEnvironment     string    `json:"to_environment"`
+	MovedBy           string    `json:"moved_by"`
+}
+
+// MoveToEnvironment reassigns nodes to another environment without enrolling
+// them again, moving their custom attributes along and recording the moves.
+// Nodes already in the environment are skipped. Cached nodes are invalidated
+// so they are read again with their new environment.
+func (n *NodeManager) MoveToEnvironment(moving []OsqueryNode, envID uint, envName, user string) ([]NodeMove, error) {
+	moves := []NodeMove{}
+	err := n.DB.Transaction(func(tx *gorm.DB) error {
+		for _, node := range moving {
+			if node.EnvironmentID == envID {
+				continue
+			}
+			move := NodeMove{
+				NodeID:            node.ID,
+				UUID:              node.UUID,
+				FromEnvironmentID: node.EnvironmentID,
+				FromEnvironment:   node.Environment,
+				ToEnvironmentID:   envID,
+				ToEnvironment:     envName,
+				MovedBy:           user,
+			}
+			updates := map[string]interface{}{"environment": envName, "environment_id": envID}
+			if err := tx.Model(&node).Updates(updates).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&NodeAttribute{}).Where("node_id = ?", node.ID).Update("environment_id", envID).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&move).Error; err != nil {
+				return err
+			}
+			moves = append(moves, move)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("move %w", err)
+	}
+	if n.Cache != nil {
+		for _, node := range moving {
+			n.Cache.InvalidateNode(context.Background(), node.NodeKey)
+		}
+	}
+	return moves, nil
+}
+
+// Moves to retrieve the environment changes of a node, oldest first
+func (n *NodeManager) Moves(nodeID uint) ([]NodeMove, error) {
+	var moves []NodeMove
+	if err := n.DB.Where("node_id = ?", nodeID).Order("created_at, id").Find(&moves).Error; err != nil {
+		return moves, fmt.Errorf("moves %w", err)
+	}
+	return moves, nil
+}
+
+// MovedFrom checks if a node was moved out of an environment, so requests
+// reaching the old environment can be sent to the current one
+func (n *NodeManager) MovedFrom(nodeID, envID uint) bool {
+	var results int64
+	n.DB.Model(&NodeMove{}).Where("node_id = ? AND from_environment_id = ?", nodeID, envID).Count(&results)
+	return results > 0
+}
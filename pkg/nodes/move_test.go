@@ -0,0 +1,84 @@
+package nodes
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestMoveToEnvironment(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	manager := CreateNodes(db)
+	node := OsqueryNode{UUID: "MOVING", NodeKey: "key-moving", Environment: "dev", EnvironmentID: 1}
+	staying := OsqueryNode{UUID: "STAYING", NodeKey: "key-staying", Environment: "prod", EnvironmentID: 2}
+	require.NoError(t, manager.Create(&node))
+	require.NoError(t, manager.Create(&staying))
+	_, err = manager.SetAttribute(node, "owner", AttributeString, "alice", "admin")
+	require.NoError(t, err)
+	cached, err := manager.Cache.GetByKey(context.Background(), "key-moving")
+	require.NoError(t, err)
+	require.Equal(t, uint(1), cached.EnvironmentID)
+
+	moves, err := manager.MoveToEnvironment([]OsqueryNode{node, staying}, 2, "prod", "admin")
+	require.NoError(t, err)
+	require.Len(t, moves, 1)
+	require.Equal(t, "MOVING", moves[0].UUID)
+	require.Equal(t, "dev", moves[0].FromEnvironment)
+	require.Equal(t, "prod", moves[0].ToEnvironment)
+
+	moved, err := manager.GetByUUID("MOVING")
+	require.NoError(t, err)
+	require.Equal(t, uint(2), moved.EnvironmentID)
+	require.Equal(t, "prod", moved.Environment)
+	cached, err = manager.Cache.GetByKey(context.Background(), "key-moving")
+	require.NoError(t, err)
+	require.Equal(t, uint(2), cached.EnvironmentID)
+	ids, err := manager.NodeIDsByAttribute(2, AttributeFilter{Name: "owner", Value: "alice"})
+	require.NoError(t, err)
+	require.Equal(t, []uint{node.ID}, ids)
+
+	require.True(t, manager.MovedFrom(node.ID, 1))
+	require.False(t, manager.MovedFrom(node.ID, 2))
+	require.False(t, manager.MovedFrom(staying.ID, 2))
+	history, err := manager.Moves(node.ID)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+}
+
+func TestNodeCacheInvalidationVersion(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	// Two replicas of osctrl-tls reading the same invalidation key
+	first := CreateNodes(db)
+	second := CreateNodes(db)
+	version := ""
+	for _, manager := range []*NodeManager{first, second} {
+		manager.Cache.SetInvalidationVersion(func(ctx context.Context, nodeKey string) string { return version })
+	}
+	node := OsqueryNode{UUID: "MOVING", NodeKey: "key-moving", Environment: "dev", EnvironmentID: 1}
+	require.NoError(t, first.Create(&node))
+	ctx := context.Background()
+	for _, manager := range []*NodeManager{first, second} {
+		cached, err := manager.Cache.GetByKey(ctx, "key-moving")
+		require.NoError(t, err)
+		require.Equal(t, uint(1), cached.EnvironmentID)
+	}
+
+	require.NoError(t, db.Model(&OsqueryNode{}).Where("uuid = ?", "MOVING").Update("environment_id", 2).Error)
+	version = NodeInvalidationVersion()
+	for _, manager := range []*NodeManager{first, second, first} {
+		cached, err := manager.Cache.GetByKey(ctx, "key-moving")
+		require.NoError(t, err)
+		require.Equal(t, uint(2), cached.EnvironmentID)
+	}
+
+	// The same version is applied only once by each replica
+	require.NoError(t, db.Model(&OsqueryNode{}).Where("uuid = ?", "MOVING").Update("environment_id", 3).Error)
+	cached, err := first.Cache.GetByKey(ctx, "key-moving")
+	require.NoError(t, err)
+	require.Equal(t, uint(2), cached.EnvironmentID)
+}
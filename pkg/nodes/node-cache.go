@@ -2,6 +2,7 @@ package nodes
 
 import (
 	"context"
+	"strconv"
 	"time"
 
 	"github.com/jmpsec/osctrl/pkg/cache"
@@ -13,8 +14,22 @@ const (
 	defaultTTL = 60 * time.Minute
 	// Default cleanup interval for the cache
 	defaultCleanupInterval = 30 * time.Minute
+	// RedisNodeInvalidatePrefix is the Redis key prefix, followed by the
+	// node_key, that other processes set to a new version to invalidate a
+	// cached node. The key is never deleted by readers, so every replica of
+	// osctrl-tls sees the same version.
+	RedisNodeInvalidatePrefix = "nodecache:invalidate:"
+	// NodeInvalidateTTL is how long an invalidation signal is kept, the
+	// longest a node can sit in the cache
+	NodeInvalidateTTL = defaultTTL
 )
 
+// NodeInvalidationVersion returns a new version for the invalidation key of
+// a node, different from any version set before
+func NodeInvalidationVersion() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 10)
+}
+
 // NodeCache provides cached access to OsqueryNode objects
 type NodeCache struct {
 	// The cache itself, storing OsqueryNode objects
@@ -22,6 +37,14 @@ type NodeCache struct {
 
 	// Reference to the node manager for cache misses
 	nodes *NodeManager
+
+	// invalidationVersion is called on each GetByKey. When it returns a
+	// version this process did not apply yet, the cached node is stale and
+	// it is fetched again from the database.
+	invalidationVersion func(ctx context.Context, nodeKey string) string
+
+	// applied keeps the last invalidation version applied for each node
+	applied *cache.MemoryCache[string]
 }
 
 // NewNodeCache creates a new node cache
@@ -35,11 +58,31 @@ func NewNodeCache(nodes *NodeManager) *NodeCache {
 	return &NodeCache{
 		cache: nodeCache,
 		nodes: nodes,
+		applied: cache.NewMemoryCache(
+			cache.WithCleanupInterval[string](defaultCleanupInterval),
+			cache.WithName[string](cacheName+"-invalidations"),
+		),
 	}
 }
 
+// SetInvalidationVersion wires a callback that is called on each GetByKey,
+// to receive invalidations from other processes, like nodes moved to another
+// environment by osctrl-api. It returns the current invalidation version of
+// the node, or an empty string if there is none.
+func (nc *NodeCache) SetInvalidationVersion(fn func(ctx context.Context, nodeKey string) string) {
+	nc.invalidationVersion = fn
+}
+
 // GetByKey retrieves a node by node_key, using cache when available
 func (nc *NodeCache) GetByKey(ctx context.Context, nodeKey string) (OsqueryNode, error) {
+	if nc.invalidationVersion != nil {
+		if version := nc.invalidationVersion(ctx, nodeKey); version != "" {
+			if applied, _ := nc.applied.Get(ctx, nodeKey); applied != version {
+				nc.InvalidateNode(ctx, nodeKey)
+				nc.applied.Set(ctx, nodeKey, version, NodeInvalidateTTL)
+			}
+		}
+	}
 	// Try to get from cache first
 	if node, found := nc.cache.Get(ctx, nodeKey); found {
 		return node, nil
@@ -77,4 +120,7 @@ func (nc *NodeCache) Close() {
 	if nc.cache != nil {
 		nc.cache.Stop()
 	}
+	if nc.applied != nil {
+		nc.applied.Stop()
+	}
 }
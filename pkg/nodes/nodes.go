@@ -49,6 +49,30 @@ func CreateNodes(backend *gorm.DB) *NodeManager {
 	if err := backend.AutoMigrate(&ArchiveOsqueryNode{}); err != nil {
 		log.Fatal().Msgf("Failed to AutoMigrate table (archive_osquery_nodes): %v", err)
 	}
+	// table node_history
+	if err := backend.AutoMigrate(&NodeHistoryEntry{}); err != nil {
+		log.Fatal().Msgf("Failed to AutoMigrate table (node_history): %v", err)
+	}
+	// table node_attributes
+	if err := backend.AutoMigrate(&NodeAttribute{}); err != nil {
+		log.Fatal().Msgf("Failed to AutoMigrate table (node_attributes): %v", err)
+	}
+	// table node_moves
+	if err := backend.AutoMigrate(&NodeMove{}); err != nil {
+		log.Fatal().Msgf("Failed to AutoMigrate table (node_moves): %v", err)
+	}
+	// table node_key_revocations
+	if err := backend.AutoMigrate(&NodeKeyRevocation{}); err != nil {
+		log.Fatal().Msgf("Failed to AutoMigrate table (node_key_revocations): %v", err)
+	}
+	// table node_quarantines
+	if err := backend.AutoMigrate(&NodeQuarantine{}); err != nil {
+		log.Fatal().Msgf("Failed to AutoMigrate table (node_quarantines): %v", err)
+	}
+	// table approval_rules
+	if err := backend.AutoMigrate(&ApprovalRule{}); err != nil {
+		log.Fatal().Msgf("Failed to AutoMigrate table (approval_rules): %v", err)
+	}
 	// Create and initialize the cache
 	n.Cache = NewNodeCache(n)
 	return n
@@ -352,6 +376,11 @@ func (n *NodeManager) GetOsqueryVersionCounts() ([]OsqueryVersionCount, error) {
 	return GetOsqueryVersionCounts(n.DB)
 }
 
+// GetEnvOsqueryVersions wrapper.
+func (n *NodeManager) GetEnvOsqueryVersions(envID uint) ([]string, error) {
+	return GetEnvOsqueryVersions(n.DB, envID)
+}
+
 // UpdateMetadataByUUID to update node metadata by UUID
 func (n *NodeManager) UpdateMetadataByUUID(uuid string, metadata NodeMetadata) error {
 	// Retrieve node
@@ -406,8 +435,8 @@ func (n *NodeManager) Create(node *OsqueryNode) error {
 	return nil
 }
 
-// NewHistoryEntry to insert new entry for the history of Hostnames
-func (n *NodeManager) NewHistoryEntry(entry interface{}) error {
+// NewHistoryEntry to insert new entry for the metadata history of a node
+func (n *NodeManager) NewHistoryEntry(entry NodeHistoryEntry) error {
 	if err := n.DB.Create(&entry).Error; err != nil {
 		return fmt.Errorf("create newNodeHistoryEntry %w", err)
 	}
@@ -433,7 +462,7 @@ func (n *NodeManager) UpdateByUUID(data OsqueryNode, uuid string) error {
 	if err != nil {
 		return fmt.Errorf("getNodeByUUID %w", err)
 	}
-	if err := n.DB.Model(&node).Updates(data).Error; err != nil {
+	if err := n.updateWithHistory(node, data, historyFromNode(node, data)); err != nil {
 		return fmt.Errorf("in UpdateByUUID %w", err)
 	}
 	return nil
@@ -445,12 +474,8 @@ func (n *NodeManager) ArchiveDeleteByUUID(uuid string) error {
 	if err != nil {
 		return fmt.Errorf("getNodeByUUID %w", err)
 	}
-	archivedNode := nodeArchiveFromNode(node, "delete")
-	if err := n.DB.Create(&archivedNode).Error; err != nil {
-		return fmt.Errorf("create %w", err)
-	}
-	if err := n.DB.Unscoped().Delete(&node).Error; err != nil {
-		return fmt.Errorf("delete %w", err)
+	if err := n.archiveDelete(node, "delete"); err != nil {
+		return fmt.Errorf("archive delete %w", err)
 	}
 	return nil
 }
@@ -481,6 +506,7 @@ func nodeArchiveFromNode(node OsqueryNode, trigger string) ArchiveOsqueryNode {
 		UserID:          node.UserID,
 		EnvironmentID:   node.EnvironmentID,
 		ExtraData:       node.ExtraData,
+		EnrollToken:     node.EnrollToken,
 	}
 }
 
@@ -501,15 +527,35 @@ func (n *NodeManager) UpdateLastSeen(nodeID uint, seenAt time.Time) error {
 	return n.DB.Model(&OsqueryNode{}).Where("id = ?", nodeID).UpdateColumn("last_seen", seenAt).Error
 }
 
+// UpdateIP to update the IP address of a node, recording it when it changes
 func (n *NodeManager) UpdateIP(nodeID uint, ip string) error {
-	// Update the IP address in the database
-	return n.DB.Model(&OsqueryNode{}).Where("id = ?", nodeID).UpdateColumn("ip_address", ip).Error
-
-}
-
-// MetadataRefresh to perform all needed update operations per node to keep metadata refreshed
+	var node OsqueryNode
+	if err := n.DB.Select("id", "uuid", "environment_id", "ip_address").Where("id = ?", nodeID).Limit(1).Find(&node).Error; err != nil {
+		return err
+	}
+	if node.ID == 0 || node.IPAddress == ip {
+		return nil
+	}
+	return n.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&OsqueryNode{}).Where("id = ?", nodeID).UpdateColumn("ip_address", ip).Error; err != nil {
+			return err
+		}
+		entry := NodeHistoryEntry{
+			NodeID:        node.ID,
+			UUID:          node.UUID,
+			EnvironmentID: node.EnvironmentID,
+			Field:         "ip_address",
+			OldValue:      node.IPAddress,
+			NewValue:      ip,
+		}
+		return tx.Create(&entry).Error
+	})
+}
+
+// MetadataRefresh to perform all needed update operations per node to keep metadata refreshed,
+// keeping the previous values of changed metadata as history
 func (n *NodeManager) MetadataRefresh(node OsqueryNode, updates map[string]interface{}) error {
-	return n.DB.Model(&node).Updates(updates).Error
+	return n.updateWithHistory(node, updates, historyFromUpdates(node, updates))
 }
 
 // SortableColumns is the closed set of columns that may be ordered by external
@@ -535,7 +581,8 @@ type NodesPage struct {
 
 // GetByEnvPaged returns a page of nodes for an environment, applying the target
 // filter (all / active / inactive), optional search, optional sort, and the
-// optional platform bucket filter ("linux" / "darwin" / "windows" / "other").
+// optional platform bucket filter ("linux" / "darwin" / "windows" / "other")
+// and the optional custom attribute filters, all of which must match.
 // The sort column is validated against SortableColumns; unknown columns fall
 // back to last_seen DESC. This is the single canonical paginated reader.
 //
@@ -543,7 +590,7 @@ type NodesPage struct {
 // platformBucket is one of the buckets normalizePlatformBucket recognises; an
 // empty string disables the filter. Unknown buckets also disable it (so the
 // caller can pass user input directly without input-validation boilerplate).
-func (n *NodeManager) GetByEnvPaged(env, target string, hours int64, search string, page, pageSize int, sortColumn string, desc bool, platformBucket string) (NodesPage, error) {
+func (n *NodeManager) GetByEnvPaged(env, target string, hours int64, search string, page, pageSize int, sortColumn string, desc bool, platformBucket string, attributes []AttributeFilter) (NodesPage, error) {
 	if pageSize <= 0 {
 		pageSize = 50
 	}
@@ -573,6 +620,7 @@ func (n *NodeManager) GetByEnvPaged(env, target string, hours int64, search stri
 	query := n.DB.Model(&OsqueryNode{}).Where("environment = ?", env)
 	query = ApplyNodeTarget(query, target, hours)
 	query = applyPlatformBucket(query, platformBucket)
+	query = n.applyAttributeFilters(query, attributes)
 	if search != "" {
 		like := "%" + search + "%"
 		query = query.Where(
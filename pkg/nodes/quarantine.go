@@ -0,0 +1,126 @@
+package nodes
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// NodeQuarantine records a node put in quarantine, released when ReleasedAt is set
+type NodeQuarantine struct {
+	ID            uint       `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time  `gorm:"index" json:"created_at"`
+	NodeID        uint       `gorm:"index" json:"node_id"`
+	UUID          string     `json:"uuid"`
+	EnvironmentID uint       `gorm:"index" json:"environment_id"`
+	Reason        string     `json:"reason"`
+	QuarantinedBy string     `json:"quarantined_by"`
+	ReleasedAt    *time.Time `json:"released_at"`
+	ReleasedBy    string     `json:"released_by"`
+}
+
+// QuarantinedNode is a node in quarantine with the record of its quarantine
+type QuarantinedNode struct {
+	Node       OsqueryNode    `json:"node"`
+	Quarantine NodeQuarantine `json:"quarantine"`
+}
+
+// Quarantine puts a node in quarantine, so it is only served the quarantine
+// configuration and no distributed queries until it is released
+func (n *NodeManager) Quarantine(node OsqueryNode, reason, user string) (NodeQuarantine, error) {
+	if node.Quarantined {
+		return NodeQuarantine{}, fmt.Errorf("node %s is already in quarantine", node.UUID)
+	}
+	quarantine := NodeQuarantine{
+		NodeID:        node.ID,
+		UUID:          node.UUID,
+		EnvironmentID: node.EnvironmentID,
+		Reason:        reason,
+		QuarantinedBy: user,
+	}
+	err := n.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&OsqueryNode{}).Where("id = ?", node.ID).Update("quarantined", true).Error; err != nil {
+			return err
+		}
+		return tx.Create(&quarantine).Error
+	})
+	if err != nil {
+		return NodeQuarantine{}, fmt.Errorf("quarantine %w", err)
+	}
+	if n.Cache != nil {
+		n.Cache.InvalidateNode(context.Background(), node.NodeKey)
+	}
+	return quarantine, nil
+}
+
+// Release takes a node out of quarantine, closing its quarantine record
+func (n *NodeManager) Release(node OsqueryNode, user string) (NodeQuarantine, error) {
+	if !node.Quarantined {
+		return NodeQuarantine{}, fmt.Errorf("node %s is not in quarantine", node.UUID)
+	}
+	var quarantine NodeQuarantine
+	err := n.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&OsqueryNode{}).Where("id = ?", node.ID).Update("quarantined", false).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("node_id = ? AND released_at IS NULL", node.ID).Order("id DESC").First(&quarantine).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		quarantine.ReleasedAt = &now
+		quarantine.ReleasedBy = user
+		return tx.Save(&quarantine).Error
+	})
+	if err != nil {
+		return NodeQuarantine{}, fmt.Errorf("release %w", err)
+	}
+	if n.Cache != nil {
+		n.Cache.InvalidateNode(context.Background(), node.NodeKey)
+	}
+	return quarantine, nil
+}
+
+// Quarantines to retrieve the quarantines of a node, oldest first
+func (n *NodeManager) Quarantines(nodeID uint) ([]NodeQuarantine, error) {
+	var quarantines []NodeQuarantine
+	if err := n.DB.Where("node_id = ?", nodeID).Order("created_at, id").Find(&quarantines).Error; err != nil {
+		return quarantines, fmt.Errorf("quarantines %w", err)
+	}
+	return quarantines, nil
+}
+
+// QuarantinedByEnv to retrieve the nodes in quarantine of an environment, most
+// recently quarantined first
+func (n *NodeManager) QuarantinedByEnv(envID uint) ([]QuarantinedNode, error) {
+	var quarantined []OsqueryNode
+	if err := n.DB.Where("environment_id = ? AND quarantined = ?", envID, true).Find(&quarantined).Error; err != nil {
+		return nil, fmt.Errorf("quarantined nodes %w", err)
+	}
+	result := []QuarantinedNode{}
+	if len(quarantined) == 0 {
+		return result, nil
+	}
+	ids := make([]uint, 0, len(quarantined))
+	for _, node := range quarantined {
+		ids = append(ids, node.ID)
+	}
+	var open []NodeQuarantine
+	if err := n.DB.Where("node_id IN ? AND released_at IS NULL", ids).Order("created_at DESC, id DESC").Find(&open).Error; err != nil {
+		return nil, fmt.Errorf("quarantines %w", err)
+	}
+	byNode := make(map[uint]OsqueryNode, len(quarantined))
+	for _, node := range quarantined {
+		byNode[node.ID] = node
+	}
+	for _, q := range open {
+		node, ok := byNode[q.NodeID]
+		if !ok {
+			continue
+		}
+		result = append(result, QuarantinedNode{Node: node, Quarantine: q})
+		delete(byNode, q.NodeID)
+	}
+	return result, nil
+}
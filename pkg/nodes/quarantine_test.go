@@ -0,0 +1,53 @@
+package nodes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestQuarantineAndRelease(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	manager := CreateNodes(db)
+	node := OsqueryNode{UUID: "INCIDENT", NodeKey: "incident-key", EnvironmentID: 1}
+	other := OsqueryNode{UUID: "OTHER", NodeKey: "other-key", EnvironmentID: 2}
+	require.NoError(t, manager.Create(&node))
+	require.NoError(t, manager.Create(&other))
+	_, err = manager.GetByKey("incident-key")
+	require.NoError(t, err)
+
+	_, err = manager.Quarantine(node, "beaconing", "alice")
+	require.NoError(t, err)
+	_, err = manager.Quarantine(other, "", "alice")
+	require.NoError(t, err)
+	cached, err := manager.GetByKey("incident-key")
+	require.NoError(t, err)
+	require.True(t, cached.Quarantined)
+	_, err = manager.Quarantine(cached, "again", "alice")
+	require.Error(t, err)
+
+	quarantined, err := manager.QuarantinedByEnv(1)
+	require.NoError(t, err)
+	require.Len(t, quarantined, 1)
+	require.Equal(t, "INCIDENT", quarantined[0].Node.UUID)
+	require.Equal(t, "beaconing", quarantined[0].Quarantine.Reason)
+
+	released, err := manager.Release(cached, "bob")
+	require.NoError(t, err)
+	require.NotNil(t, released.ReleasedAt)
+	require.Equal(t, "bob", released.ReleasedBy)
+	cached, err = manager.GetByKey("incident-key")
+	require.NoError(t, err)
+	require.False(t, cached.Quarantined)
+	_, err = manager.Release(cached, "bob")
+	require.Error(t, err)
+	quarantined, err = manager.QuarantinedByEnv(1)
+	require.NoError(t, err)
+	require.Empty(t, quarantined)
+	history, err := manager.Quarantines(node.ID)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+}
@@ -0,0 +1,102 @@
+package nodes
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// NodeKeyRevocation records a node_key revoked, keeping only a hash of the
+// key so a leaked key can be matched without storing it
+type NodeKeyRevocation struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time `gorm:"index" json:"created_at"`
+	NodeID        uint      `gorm:"index" json:"node_id"`
+	UUID          string    `json:"uuid"`
+	Hostname      string    `json:"hostname"`
+	EnvironmentID uint      `gorm:"index" json:"environment_id"`
+	KeyHash       string    `gorm:"index" json:"key_hash"`
+	Reason        string    `json:"reason"`
+	RevokedBy     string    `json:"revoked_by"`
+}
+
+// NodeKeyHash is the hash of a node_key kept in revocations
+func NodeKeyHash(nodeKey string) string {
+	sum := sha256.Sum256([]byte(nodeKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// unusableNodeKey generates a random node_key that is never handed to a node,
+// so requests with the revoked key fail and osquery enrolls again
+func unusableNodeKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "revoked-" + hex.EncodeToString(b), nil
+}
+
+// RevokeKeys replaces the node_key of nodes with one no node knows and records
+// the revocations, all in one transaction. The next request of the nodes gets
+// node_invalid and osquery enrolls again with the enroll secret. Cached nodes
+// are invalidated by their revoked keys.
+func (n *NodeManager) RevokeKeys(revoking []OsqueryNode, reason, user string) ([]NodeKeyRevocation, error) {
+	revocations := []NodeKeyRevocation{}
+	err := n.DB.Transaction(func(tx *gorm.DB) error {
+		for _, node := range revoking {
+			revocation := NodeKeyRevocation{
+				NodeID:        node.ID,
+				UUID:          node.UUID,
+				Hostname:      node.Hostname,
+				EnvironmentID: node.EnvironmentID,
+				KeyHash:       NodeKeyHash(node.NodeKey),
+				Reason:        reason,
+				RevokedBy:     user,
+			}
+			key, err := unusableNodeKey()
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&OsqueryNode{}).Where("id = ?", node.ID).Update("node_key", key).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&revocation).Error; err != nil {
+				return err
+			}
+			revocations = append(revocations, revocation)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("revoke %w", err)
+	}
+	if n.Cache != nil {
+		for _, node := range revoking {
+			n.Cache.InvalidateNode(context.Background(), node.NodeKey)
+		}
+	}
+	return revocations, nil
+}
+
+// Revocations to retrieve the node_key revocations of a node, oldest first
+func (n *NodeManager) Revocations(nodeID uint) ([]NodeKeyRevocation, error) {
+	var revocations []NodeKeyRevocation
+	if err := n.DB.Where("node_id = ?", nodeID).Order("created_at, id").Find(&revocations).Error; err != nil {
+		return revocations, fmt.Errorf("revocations %w", err)
+	}
+	return revocations, nil
+}
+
+// RevocationsByEnv to retrieve the node_key revocations of an environment, newest first
+func (n *NodeManager) RevocationsByEnv(envID uint) ([]NodeKeyRevocation, error) {
+	var revocations []NodeKeyRevocation
+	if err := n.DB.Where("environment_id = ?", envID).Order("created_at DESC, id DESC").Find(&revocations).Error; err != nil {
+		return revocations, fmt.Errorf("revocations %w", err)
+	}
+	return revocations, nil
+}
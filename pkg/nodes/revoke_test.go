@@ -0,0 +1,45 @@
+package nodes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestRevokeKeys(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	manager := CreateNodes(db)
+	node := OsqueryNode{UUID: "LEAKED", Hostname: "leaked", NodeKey: "leaked-key", EnvironmentID: 1}
+	other := OsqueryNode{UUID: "OTHER", NodeKey: "other-key", EnvironmentID: 1}
+	require.NoError(t, manager.Create(&node))
+	require.NoError(t, manager.Create(&other))
+	_, err = manager.GetByKey("leaked-key")
+	require.NoError(t, err)
+
+	revocations, err := manager.RevokeKeys([]OsqueryNode{node}, "key leaked in a ticket", "alice")
+	require.NoError(t, err)
+	require.Len(t, revocations, 1)
+	require.Equal(t, NodeKeyHash("leaked-key"), revocations[0].KeyHash)
+	require.NotContains(t, revocations[0].KeyHash, "leaked-key")
+
+	// The revoked key is not served from the cache either
+	_, err = manager.GetByKey("leaked-key")
+	require.Error(t, err)
+	revoked, err := manager.GetByUUID("LEAKED")
+	require.NoError(t, err)
+	require.NotEmpty(t, revoked.NodeKey)
+	require.NotEqual(t, "leaked-key", revoked.NodeKey)
+	_, err = manager.GetByKey("other-key")
+	require.NoError(t, err)
+
+	history, err := manager.Revocations(node.ID)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	require.Equal(t, "key leaked in a ticket", history[0].Reason)
+	byEnv, err := manager.RevocationsByEnv(1)
+	require.NoError(t, err)
+	require.Len(t, byEnv, 1)
+}
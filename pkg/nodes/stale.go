@@ -0,0 +1,128 @@
+package nodes
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ArchiveTriggerStale is the archive trigger of nodes archived for not checking in
+const ArchiveTriggerStale = "stale"
+
+// StalePolicy is how an environment handles nodes that stopped checking in.
+// Nodes are inactive after InactiveHours and archived after ArchiveDays, and
+// archived nodes are deleted after PurgeDays. Zero days disable a step.
+type StalePolicy struct {
+	InactiveHours int64 `json:"inactive_hours"`
+	ArchiveDays   int64 `json:"archive_days"`
+	PurgeDays     int64 `json:"purge_days"`
+}
+
+// StaleNode is a node archived, or to be archived, by a stale policy
+type StaleNode struct {
+	UUID     string    `json:"uuid"`
+	Hostname string    `json:"hostname"`
+	Platform string    `json:"platform"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// PurgedArchive is an archived node deleted, or to be deleted, by a stale policy
+type PurgedArchive struct {
+	ID         uint      `json:"id"`
+	UUID       string    `json:"uuid"`
+	Hostname   string    `json:"hostname"`
+	Trigger    string    `json:"trigger"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// StaleReport is the outcome of applying a stale policy to an environment
+type StaleReport struct {
+	EnvironmentID uint            `json:"environment_id"`
+	Policy        StalePolicy     `json:"policy"`
+	DryRun        bool            `json:"dry_run"`
+	RanAt         time.Time       `json:"ran_at"`
+	Inactive      int64           `json:"inactive"`
+	Archived      []StaleNode     `json:"archived"`
+	Purged        []PurgedArchive `json:"purged"`
+}
+
+// ApplyStalePolicy archives with trigger stale the nodes of an environment
+// that did not check in for the policy days and deletes the archives older
+// than its purge days. A dry run only reports what would be done.
+func (n *NodeManager) ApplyStalePolicy(envID uint, policy StalePolicy, now time.Time, dryRun bool) (StaleReport, error) {
+	report := StaleReport{
+		EnvironmentID: envID,
+		Policy:        policy,
+		DryRun:        dryRun,
+		RanAt:         now,
+		Archived:      []StaleNode{},
+		Purged:        []PurgedArchive{},
+	}
+	if policy.InactiveHours > 0 {
+		inactiveSince := now.Add(-time.Duration(policy.InactiveHours) * time.Hour)
+		if err := n.DB.Model(&OsqueryNode{}).Where("environment_id = ? AND last_seen < ?", envID, inactiveSince).Count(&report.Inactive).Error; err != nil {
+			return report, fmt.Errorf("count inactive %w", err)
+		}
+	}
+	if policy.ArchiveDays > 0 {
+		var stale []OsqueryNode
+		if err := n.DB.Where("environment_id = ? AND last_seen < ?", envID, now.AddDate(0, 0, -int(policy.ArchiveDays))).
+			Order("last_seen").Find(&stale).Error; err != nil {
+			return report, fmt.Errorf("find stale %w", err)
+		}
+		for _, node := range stale {
+			if !dryRun {
+				if err := n.archiveDelete(node, ArchiveTriggerStale); err != nil {
+					return report, fmt.Errorf("archive %s %w", node.UUID, err)
+				}
+			}
+			report.Archived = append(report.Archived, StaleNode{
+				UUID:     node.UUID,
+				Hostname: node.Hostname,
+				Platform: node.Platform,
+				LastSeen: node.LastSeen,
+			})
+		}
+	}
+	if policy.PurgeDays > 0 {
+		var archived []ArchiveOsqueryNode
+		if err := n.DB.Where("environment_id = ? AND created_at < ?", envID, now.AddDate(0, 0, -int(policy.PurgeDays))).
+			Order("created_at").Find(&archived).Error; err != nil {
+			return report, fmt.Errorf("find archives %w", err)
+		}
+		for _, a := range archived {
+			report.Purged = append(report.Purged, PurgedArchive{
+				ID:         a.ID,
+				UUID:       a.UUID,
+				Hostname:   a.Hostname,
+				Trigger:    a.Trigger,
+				ArchivedAt: a.CreatedAt,
+			})
+		}
+		if !dryRun && len(archived) > 0 {
+			if err := n.DB.Unscoped().Delete(&archived).Error; err != nil {
+				return report, fmt.Errorf("purge archives %w", err)
+			}
+		}
+	}
+	return report, nil
+}
+
+// archiveDelete archives a node with a trigger and deletes it in one transaction
+func (n *NodeManager) archiveDelete(node OsqueryNode, trigger string) error {
+	return n.DB.Transaction(func(tx *gorm.DB) error {
+		return archiveDeleteTx(tx, node, trigger)
+	})
+}
+
+func archiveDeleteTx(tx *gorm.DB, node OsqueryNode, trigger string) error {
+	archivedNode := nodeArchiveFromNode(node, trigger)
+	if err := tx.Create(&archivedNode).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("node_id = ?", node.ID).Delete(&NodeAttribute{}).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Delete(&node).Error
+}
@@ -0,0 +1,71 @@
+package nodes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestApplyStalePolicyArchivesAndPurges(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	manager := CreateNodes(db)
+	now := time.Now()
+	for _, node := range []OsqueryNode{
+		{UUID: "FRESH", EnvironmentID: 1, LastSeen: now.Add(-time.Hour)},
+		{UUID: "INACTIVE", EnvironmentID: 1, LastSeen: now.Add(-5 * 24 * time.Hour)},
+		{UUID: "STALE", Hostname: "old", EnvironmentID: 1, LastSeen: now.Add(-40 * 24 * time.Hour)},
+		{UUID: "OTHER-ENV", EnvironmentID: 2, LastSeen: now.Add(-40 * 24 * time.Hour)},
+	} {
+		require.NoError(t, manager.Create(&node))
+	}
+	old := ArchiveOsqueryNode{UUID: "GONE", Trigger: "delete", EnvironmentID: 1, CreatedAt: now.AddDate(0, 0, -100)}
+	recent := ArchiveOsqueryNode{UUID: "RECENT", Trigger: "delete", EnvironmentID: 1, CreatedAt: now.AddDate(0, 0, -10)}
+	require.NoError(t, db.Create(&old).Error)
+	require.NoError(t, db.Create(&recent).Error)
+	policy := StalePolicy{InactiveHours: 72, ArchiveDays: 30, PurgeDays: 90}
+
+	report, err := manager.ApplyStalePolicy(1, policy, now, true)
+	require.NoError(t, err)
+	require.True(t, report.DryRun)
+	require.Equal(t, int64(2), report.Inactive)
+	require.Len(t, report.Archived, 1)
+	require.Equal(t, "STALE", report.Archived[0].UUID)
+	require.Len(t, report.Purged, 1)
+	require.Equal(t, "GONE", report.Purged[0].UUID)
+	require.True(t, manager.CheckByUUID("STALE"))
+
+	report, err = manager.ApplyStalePolicy(1, policy, now, false)
+	require.NoError(t, err)
+	require.Len(t, report.Archived, 1)
+	require.False(t, manager.CheckByUUID("STALE"))
+	require.True(t, manager.CheckByUUID("OTHER-ENV"))
+	archives, err := manager.GetArchives("STALE")
+	require.NoError(t, err)
+	require.Len(t, archives, 1)
+	require.Equal(t, ArchiveTriggerStale, archives[0].Trigger)
+	require.Equal(t, "old", archives[0].Hostname)
+	var remaining []ArchiveOsqueryNode
+	require.NoError(t, db.Unscoped().Order("uuid").Find(&remaining).Error)
+	require.Len(t, remaining, 2)
+	require.Equal(t, "RECENT", remaining[0].UUID)
+	require.Equal(t, "STALE", remaining[1].UUID)
+}
+
+func TestApplyStalePolicyDisabledByDefault(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	manager := CreateNodes(db)
+	node := OsqueryNode{UUID: "STALE", EnvironmentID: 1, LastSeen: time.Now().AddDate(-1, 0, 0)}
+	require.NoError(t, manager.Create(&node))
+
+	report, err := manager.ApplyStalePolicy(1, StalePolicy{InactiveHours: 72}, time.Now(), false)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), report.Inactive)
+	require.Empty(t, report.Archived)
+	require.Empty(t, report.Purged)
+	require.True(t, manager.CheckByUUID("STALE"))
+}
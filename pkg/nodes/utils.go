@@ -109,6 +109,21 @@ func GetOsqueryVersionCounts(db *gorm.DB) ([]OsqueryVersionCount, error) {
 	return rows, nil
 }
 
+// GetEnvOsqueryVersions returns the distinct osquery versions reported by
+// the nodes of one environment, used to check which osquery options they
+// support.
+func GetEnvOsqueryVersions(db *gorm.DB, envID uint) ([]string, error) {
+	var versions []string
+	err := db.Model(&OsqueryNode{}).
+		Where("environment_id = ? AND osquery_version <> ''", envID).
+		Distinct().
+		Pluck("osquery_version", &versions).Error
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
 // GetPlatformCountsByEnv returns the per-platform node counts for one env.
 // One GROUP BY `platform` query, then we bucket the rows in Go because
 // osquery agents report `kali`, `ubuntu`, `centos`, etc. — all of which
@@ -200,6 +215,12 @@ func NormalizePlatformBucket(p string) string {
 	return "other"
 }
 
+// PlatformNames returns the platform reported by osquery and its bucket, the
+// values configuration overlays with a platform target are matched against
+func PlatformNames(p string) []string {
+	return []string{p, NormalizePlatformBucket(p)}
+}
+
 func normalizePlatformBucket(p string) string {
 	return NormalizePlatformBucket(p)
 }
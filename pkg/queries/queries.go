@@ -342,6 +342,37 @@ func (q *Queries) GetNodeQueryTimestamps(nodeID uint, since time.Time) ([]time.T
 	return ts, err
 }
 
+// NodeQueryHistory is a query that targeted a node, with its status on that node
+type NodeQueryHistory struct {
+	Name      string    `json:"name"`
+	Creator   string    `json:"creator"`
+	Query     string    `json:"query"`
+	Type      string    `json:"type"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GetNodeQueryHistory returns the visible queries and carves that targeted a
+// node, oldest first. Hidden queries, like the ones of console sessions, are
+// left out since they are reported by their own feature.
+func (q *Queries) GetNodeQueryHistory(nodeID uint) ([]NodeQueryHistory, error) {
+	var history []NodeQueryHistory
+	err := q.DB.Model(&NodeQuery{}).
+		Select("distributed_queries.name, distributed_queries.creator, distributed_queries.query, distributed_queries.type, node_queries.status, node_queries.created_at, node_queries.updated_at").
+		Joins("JOIN distributed_queries ON distributed_queries.id = node_queries.query_id").
+		Where("node_queries.node_id = ? AND distributed_queries.hidden = ?", nodeID, false).
+		Order("node_queries.created_at, node_queries.id").
+		Scan(&history).Error
+	return history, err
+}
+
+// MoveNodeQueries moves the query history of a node to another node inside
+// a transaction. It is a nodes.NodeMover for merging duplicate nodes.
+func (q *Queries) MoveNodeQueries(tx *gorm.DB, from, to nodes.OsqueryNode) error {
+	return tx.Model(&NodeQuery{}).Where("node_id = ?", from.ID).Update("node_id", to.ID).Error
+}
+
 // GetNodeQueryBucketed returns per-bucket row counts for node_queries
 // targeting `nodeID`, since `since`. Same bucketing semantics as the
 // logging-package variants — see pkg/dbutil.BucketExpr for the dialect
@@ -158,6 +158,38 @@ func (q *Queries) CreateSaved(name, query, creator string, envid uint) error {
 	return nil
 }
 
+// CopySavedTx copies the saved queries of an environment to another one
+// within a transaction, keeping their creators. Queries already saved with
+// the same name in the destination are skipped. Returns how many were copied.
+func (q *Queries) CopySavedTx(tx *gorm.DB, fromEnvID, toEnvID uint) (int, error) {
+	var saved []SavedQuery
+	if err := tx.Where("environment_id = ?", fromEnvID).Order("name").Find(&saved).Error; err != nil {
+		return 0, fmt.Errorf("error getting saved queries %w", err)
+	}
+	copied := 0
+	for _, s := range saved {
+		var existing int64
+		if err := tx.Model(&SavedQuery{}).Where("name = ? AND environment_id = ?", s.Name, toEnvID).Count(&existing).Error; err != nil {
+			return copied, fmt.Errorf("error getting saved queries %w", err)
+		}
+		if existing > 0 {
+			continue
+		}
+		dup := SavedQuery{
+			Name:          s.Name,
+			Creator:       s.Creator,
+			Query:         s.Query,
+			EnvironmentID: toEnvID,
+			ExtraData:     s.ExtraData,
+		}
+		if err := tx.Create(&dup).Error; err != nil {
+			return copied, fmt.Errorf("error copying saved query %s %w", s.Name, err)
+		}
+		copied++
+	}
+	return copied, nil
+}
+
 // UpdateSaved updates the SQL body of an existing saved query identified by
 // (name, env). The creator field is not modified — original ownership stays.
 // Returns gorm.ErrRecordNotFound when the row does not exist.
@@ -84,6 +84,9 @@ const (
 	AcceleratedSeconds string = "accelerated_seconds"
 	NodeDashboard      string = "node_dashboard"
 	OnelinerExpiration string = "oneliner_expiration"
+	StaleArchiveDays   string = "stale_archive_days"
+	ArchivePurgeDays   string = "archive_purge_days"
+	DriftIntervals     string = "drift_intervals"
 )
 
 // Names for the values that are read from the JSON config file
@@ -109,6 +112,10 @@ const (
 // missing.
 const DefaultInactiveHours int64 = 72
 
+// DefaultDriftIntervals is how many config intervals the config hash of a
+// node may disagree with its served configuration before it is drifted
+const DefaultDriftIntervals int64 = 3
+
 // SettingValue to hold each value for settings
 type SettingValue struct {
 	gorm.Model
@@ -573,9 +580,9 @@ func (conf *Settings) CleanupExpired() int64 {
 // InactiveHours gets the value in hours for a node to be inactive by service.
 // Returns DefaultInactiveHours when the setting is absent or invalid so that
 // callers never receive a zero threshold (which would make every node appear
-// inactive).
+// inactive). An environment without its own value uses the global one.
 func (conf *Settings) InactiveHours(envID uint) int64 {
-	value, err := conf.RetrieveValue(config.ServiceAdmin, InactiveHours, envID)
+	value, err := conf.retrieveEnvOrGlobal(config.ServiceAdmin, InactiveHours, envID)
 	if err != nil {
 		return DefaultInactiveHours
 	}
@@ -585,6 +592,56 @@ func (conf *Settings) InactiveHours(envID uint) int64 {
 	return value.Integer
 }
 
+// StaleArchiveDays gets the days without checking in after which nodes of an
+// environment are archived. Zero, the default, disables archiving.
+func (conf *Settings) StaleArchiveDays(envID uint) int64 {
+	value, err := conf.retrieveEnvOrGlobal(config.ServiceAdmin, StaleArchiveDays, envID)
+	if err != nil || value.Integer < 0 {
+		return 0
+	}
+	return value.Integer
+}
+
+// DriftIntervals gets how many config intervals the config hash reported by
+// a node of an environment may disagree with the served configuration before
+// the node is drifted. Returns DefaultDriftIntervals when absent or invalid.
+func (conf *Settings) DriftIntervals(envID uint) int64 {
+	value, err := conf.retrieveEnvOrGlobal(config.ServiceAdmin, DriftIntervals, envID)
+	if err != nil || value.Integer <= 0 {
+		return DefaultDriftIntervals
+	}
+	return value.Integer
+}
+
+// ArchivePurgeDays gets the days after which archived nodes of an environment
+// are deleted. Zero, the default, keeps archives forever.
+func (conf *Settings) ArchivePurgeDays(envID uint) int64 {
+	value, err := conf.retrieveEnvOrGlobal(config.ServiceAdmin, ArchivePurgeDays, envID)
+	if err != nil || value.Integer < 0 {
+		return 0
+	}
+	return value.Integer
+}
+
+// SetEnvInteger sets a numeric value for an environment, creating it if missing
+func (conf *Settings) SetEnvInteger(intValue int64, service, name string, envID uint) error {
+	if !conf.IsValue(service, name, envID) {
+		return conf.NewIntegerValue(service, name, intValue, envID)
+	}
+	return conf.SetInteger(intValue, service, name, envID)
+}
+
+// retrieveEnvOrGlobal retrieves the value of an environment, or the global
+// value when the environment does not have one
+func (conf *Settings) retrieveEnvOrGlobal(service, name string, envID uint) (SettingValue, error) {
+	if envID != NoEnvironmentID {
+		if value, err := conf.RetrieveValue(service, name, envID); err == nil {
+			return value, nil
+		}
+	}
+	return conf.RetrieveValue(service, name, NoEnvironmentID)
+}
+
 // NodeDashboard checks if display dashboard per node is enabled
 func (conf *Settings) NodeDashboard(envID uint) bool {
 	value, err := conf.RetrieveValue(config.ServiceAdmin, NodeDashboard, envID)
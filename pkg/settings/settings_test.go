@@ -66,3 +66,22 @@ func TestInactiveHours_ReturnsConfigured(t *testing.T) {
 	assert.Equal(t, configured, got,
 		"InactiveHours should return the stored positive value")
 }
+
+// Environment values override the global ones, which apply to environments
+// without their own value.
+func TestStalePolicy_EnvironmentOverridesGlobal(t *testing.T) {
+	db := setupSettingsTestDB(t)
+	conf := &Settings{DB: db}
+	const envID = uint(5)
+	assert.Equal(t, int64(0), conf.StaleArchiveDays(envID), "archiving is disabled by default")
+	assert.Equal(t, int64(0), conf.ArchivePurgeDays(envID), "purging is disabled by default")
+
+	require.NoError(t, conf.NewIntegerValue(config.ServiceAdmin, InactiveHours, 48, NoEnvironmentID))
+	require.NoError(t, conf.SetEnvInteger(30, config.ServiceAdmin, StaleArchiveDays, NoEnvironmentID))
+	require.NoError(t, conf.SetEnvInteger(90, config.ServiceAdmin, ArchivePurgeDays, envID))
+	require.NoError(t, conf.SetEnvInteger(120, config.ServiceAdmin, ArchivePurgeDays, envID))
+	assert.Equal(t, int64(48), conf.InactiveHours(envID))
+	assert.Equal(t, int64(30), conf.StaleArchiveDays(envID))
+	assert.Equal(t, int64(120), conf.ArchivePurgeDays(envID))
+	assert.Equal(t, int64(0), conf.ArchivePurgeDays(NoEnvironmentID))
+}
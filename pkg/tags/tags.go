@@ -1,6 +1,7 @@
 package tags
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -228,6 +229,58 @@ func (m *TagManager) GetByEnv(envID uint) ([]AdminTag, error) {
 	return tags, nil
 }
 
+// NewTagTx creates a new tag like NewTag, within a transaction
+func (m *TagManager) NewTagTx(tx *gorm.DB, name, description, color, icon, user string, envID uint, auto bool, tagType uint, custom string) error {
+	tag, err := m.New(name, description, color, icon, user, envID, auto, tagType, custom)
+	if err != nil {
+		return err
+	}
+	if err := tx.Create(&tag).Error; err != nil {
+		return fmt.Errorf("Create AdminTag %w", err)
+	}
+	return nil
+}
+
+// CopyTagsTx copies the tag definitions of an environment to another one
+// within a transaction. The tag of the environment itself is not copied, nor
+// tags already defined in the destination. Returns how many were copied.
+func (m *TagManager) CopyTagsTx(tx *gorm.DB, fromEnvID, toEnvID uint, user string) (int, error) {
+	var tags []AdminTag
+	if err := tx.Where("environment_id = ?", fromEnvID).Find(&tags).Error; err != nil {
+		return 0, fmt.Errorf("error getting tags %w", err)
+	}
+	copied := 0
+	for _, t := range tags {
+		if t.TagType == TagTypeEnv {
+			continue
+		}
+		var existing int64
+		if err := tx.Model(&AdminTag{}).Where("name = ? AND environment_id = ?", t.Name, toEnvID).Count(&existing).Error; err != nil {
+			return copied, fmt.Errorf("error getting tags %w", err)
+		}
+		if existing > 0 {
+			continue
+		}
+		dup := AdminTag{
+			Name:          t.Name,
+			Description:   t.Description,
+			Color:         t.Color,
+			Icon:          t.Icon,
+			CreatedBy:     user,
+			CustomTag:     t.CustomTag,
+			AutoTag:       t.AutoTag,
+			EnvironmentID: toEnvID,
+			TagType:       t.TagType,
+			Cohort:        t.Cohort,
+		}
+		if err := tx.Create(&dup).Error; err != nil {
+			return copied, fmt.Errorf("Create AdminTag %w", err)
+		}
+		copied++
+	}
+	return copied, nil
+}
+
 // DeleteGet tag by name
 func (m *TagManager) DeleteGet(name string, envID uint) error {
 	tag, err := m.Get(name, envID)
@@ -398,6 +451,15 @@ func (m *TagManager) AutoTagNode(env string, node nodes.OsqueryNode, user string
 	return m.TagNodeMulti(l, node, user, true, "")
 }
 
+// ReapplyAutoTags removes the automatic tags of a node and tags it again for
+// its environment, after the node was moved to another environment
+func (m *TagManager) ReapplyAutoTags(env string, node nodes.OsqueryNode, user string) error {
+	if err := m.DB.Where("node_id = ? AND auto_tag = ?", node.ID, true).Delete(&TaggedNode{}).Error; err != nil {
+		return fmt.Errorf("Delete %w", err)
+	}
+	return m.AutoTagNode(env, node, user)
+}
+
 // TagNodeMulti to tag a node with multiple tags
 // TODO use the correct user_id
 func (m *TagManager) TagNodeMulti(tags []string, node nodes.OsqueryNode, user string, auto bool, custom string) error {
@@ -465,7 +527,7 @@ func (m *TagManager) IsTaggedID(name string, nodeID uint) bool {
 	return (results > 0)
 }
 
-// UntagNode to untag a node
+// UntagNode to untag a node, keeping the tagging soft deleted for the node history
 func (m *TagManager) UntagNode(name string, node nodes.OsqueryNode) error {
 	if !m.Exists(name) {
 		return fmt.Errorf("tag does not exist")
@@ -474,12 +536,64 @@ func (m *TagManager) UntagNode(name string, node nodes.OsqueryNode) error {
 	if err := m.DB.Where("tag = ? AND node_id = ?", name, node.ID).First(&tagged).Error; err != nil {
 		return fmt.Errorf("TaggedNode %w", err)
 	}
-	if err := m.DB.Unscoped().Delete(&tagged).Error; err != nil {
+	if err := m.DB.Delete(&tagged).Error; err != nil {
 		return fmt.Errorf("Delete %w", err)
 	}
 	return nil
 }
 
+// GetTagHistory to retrieve every tagging of a node, including the removed ones
+func (m *TagManager) GetTagHistory(nodeID uint) ([]TaggedNode, error) {
+	var tagged []TaggedNode
+	if err := m.DB.Unscoped().Where("node_id = ?", nodeID).Order("created_at, id").Find(&tagged).Error; err != nil {
+		return tagged, err
+	}
+	return tagged, nil
+}
+
+// MoveNodeTags moves the taggings of a node to another node inside a
+// transaction, dropping the tags the other node already has. Taggings of a
+// node in another environment are moved to the tag with the same name in the
+// environment of the other node, and left with the archived node when there
+// is none. It is a nodes.NodeMover for merging duplicate nodes.
+func (m *TagManager) MoveNodeTags(tx *gorm.DB, from, to nodes.OsqueryNode) error {
+	var tagged []TaggedNode
+	if err := tx.Unscoped().Where("node_id = ?", from.ID).Find(&tagged).Error; err != nil {
+		return fmt.Errorf("TaggedNode %w", err)
+	}
+	for _, t := range tagged {
+		if from.EnvironmentID != to.EnvironmentID {
+			var tag AdminTag
+			if err := tx.Where("name = ? AND environment_id = ?", t.Tag, to.EnvironmentID).First(&tag).Error; err != nil {
+				if !errors.Is(err, gorm.ErrRecordNotFound) {
+					return fmt.Errorf("AdminTag %w", err)
+				}
+				log.Warn().Msgf("tag %s of node %s does not exist in the environment of node %s, not moved", t.Tag, from.UUID, to.UUID)
+				continue
+			}
+			if err := tx.Unscoped().Model(&t).Update("admin_tag_id", tag.ID).Error; err != nil {
+				return fmt.Errorf("Update %w", err)
+			}
+		}
+		var existing int64
+		if !t.DeletedAt.Valid {
+			if err := tx.Model(&TaggedNode{}).Where("tag = ? AND node_id = ?", t.Tag, to.ID).Count(&existing).Error; err != nil {
+				return fmt.Errorf("TaggedNode %w", err)
+			}
+		}
+		if existing > 0 {
+			if err := tx.Unscoped().Delete(&t).Error; err != nil {
+				return fmt.Errorf("Delete %w", err)
+			}
+			continue
+		}
+		if err := tx.Unscoped().Model(&t).Update("node_id", to.ID).Error; err != nil {
+			return fmt.Errorf("Update %w", err)
+		}
+	}
+	return nil
+}
+
 // GetTags to retrieve the tags of a given node
 func (m *TagManager) GetTags(node nodes.OsqueryNode) ([]AdminTag, error) {
 	var tags []AdminTag
@@ -500,6 +614,15 @@ func (m *TagManager) GetTags(node nodes.OsqueryNode) ([]AdminTag, error) {
 	return tags, nil
 }
 
+// GetTagNames to retrieve the names of the tags of a given node
+func (m *TagManager) GetTagNames(node nodes.OsqueryNode) ([]string, error) {
+	var names []string
+	if err := m.DB.Model(&TaggedNode{}).Where("node_id = ? AND tag <> ''", node.ID).Pluck("tag", &names).Error; err != nil {
+		return names, err
+	}
+	return names, nil
+}
+
 // GetTagsByTypeEnv to retrieve the tags of a given type and environment
 func (m *TagManager) GetTagsByTypeEnv(tagType []uint, envID uint) ([]AdminTag, error) {
 	var tags []AdminTag
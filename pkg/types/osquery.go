@@ -225,6 +225,8 @@ type QueryCarveScheduled struct {
 	CarveGUID string `json:"carve_guid"`
 	RequestID string `json:"request_id"`
 	Carve     string `json:"carve"`
+	// SkipReason is set on rows of directory carves for files left out
+	SkipReason string `json:"skip_reason"`
 }
 
 // QueryWriteResponse for on-demand queries results from nodes
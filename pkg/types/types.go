@@ -1,19 +1,31 @@
 package types
 
 import (
+	"encoding/json"
 	"time"
 
+	"github.com/jmpsec/osctrl/pkg/environments"
+	"github.com/jmpsec/osctrl/pkg/nodes"
 	"github.com/jmpsec/osctrl/pkg/queries"
 )
 
 // OsqueryTable to show tables to query
 type OsqueryTable struct {
-	Name      string   `json:"name"`
-	URL       string   `json:"url"`
-	Platforms []string `json:"platforms"`
+	Name      string          `json:"name"`
+	URL       string          `json:"url"`
+	Platforms []string        `json:"platforms"`
+	Columns   []OsqueryColumn `json:"columns,omitempty"`
 	Filter    string
 }
 
+// OsqueryColumn is one column of an osquery table
+type OsqueryColumn struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	Hidden      bool   `json:"hidden"`
+}
+
 // BuildMetadata to show build metadata
 type BuildMetadata struct {
 	Version string
@@ -62,10 +74,25 @@ type ApiDistributedQueryRequest struct {
 	Environments []string `json:"environment_list"`
 	Hosts        []string `json:"host_list"`
 	Tags         []string `json:"tag_list"`
+	Attributes   []string `json:"attribute_list"`
 	Query        string   `json:"query"`
 	Path         string   `json:"path"`
 	Hidden       bool     `json:"hidden"`
 	ExpHours     int      `json:"exp_hours"`
+	// Directory turns a carve request into a recursive directory carve
+	Directory *CarveDirectoryRequest `json:"directory,omitempty"`
+}
+
+// CarveDirectoryRequest to describe a recursive carve of a directory tree.
+// Zero values for the limits mean no limit; include and exclude globs match
+// the file name, or the full path when the glob contains a path separator.
+type CarveDirectoryRequest struct {
+	Root         string   `json:"root"`
+	MaxDepth     int      `json:"max_depth"`
+	Include      []string `json:"include"`
+	Exclude      []string `json:"exclude"`
+	MaxFileSize  int64    `json:"max_file_size"`
+	MaxTotalSize int64    `json:"max_total_size"`
 }
 
 // ApiNodeGenericRequest to receive generic node requests
@@ -293,8 +320,18 @@ type DistributedQueryView struct {
 // GET /api/v1/carves/{env}/{name}. It pairs the carve QUERY metadata with
 // the per-node CarvedFile rows produced by the carve.
 type CarveDetailResponse struct {
-	Query DistributedQueryView `json:"query"`
-	Files []CarveFileView      `json:"files"`
+	Query   DistributedQueryView `json:"query"`
+	Files   []CarveFileView      `json:"files"`
+	Skipped []CarveSkippedView   `json:"skipped"`
+}
+
+// CarveSkippedView is a file left out of a directory carve by one of its
+// filters or size limits, as reported by the node.
+type CarveSkippedView struct {
+	UUID   string `json:"uuid"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Reason string `json:"reason"`
 }
 
 // EnvAccessView mirrors users.EnvAccess but lives in the types package so
@@ -405,16 +442,39 @@ type EnvCreateRequest struct {
 	Icon     string `json:"icon,omitempty"`
 }
 
+// EnvCloneRequest is the body for POST /api/v1/environments/clone/{env}.
+// The hostname of the template is used when empty. User permissions are
+// always copied, tags and saved queries only when requested.
+type EnvCloneRequest struct {
+	Name         string `json:"name"`
+	Hostname     string `json:"hostname,omitempty"`
+	Tags         bool   `json:"tags,omitempty"`
+	SavedQueries bool   `json:"saved_queries,omitempty"`
+}
+
+// EnvCloneResponse is the POST /api/v1/environments/clone/{env} payload, with
+// how many users, tags and saved queries were copied from the template
+type EnvCloneResponse struct {
+	Name         string `json:"name"`
+	UUID         string `json:"uuid"`
+	Template     string `json:"template"`
+	Users        int    `json:"users"`
+	Tags         int    `json:"tags"`
+	SavedQueries int    `json:"saved_queries"`
+}
+
 // EnvUpdateRequest is the body for PATCH /api/v1/environments/{env}.
 // Pointer fields distinguish "unset" from "set to empty"; only supplied
 // fields are written.
 type EnvUpdateRequest struct {
-	Name          *string `json:"name,omitempty"`
-	Hostname      *string `json:"hostname,omitempty"`
-	Type          *string `json:"type,omitempty"`
-	Icon          *string `json:"icon,omitempty"`
-	DebugHTTP     *bool   `json:"debug_http,omitempty"`
-	AcceptEnrolls *bool   `json:"accept_enrolls,omitempty"`
+	Name            *string `json:"name,omitempty"`
+	Hostname        *string `json:"hostname,omitempty"`
+	Type            *string `json:"type,omitempty"`
+	Icon            *string `json:"icon,omitempty"`
+	DebugHTTP       *bool   `json:"debug_http,omitempty"`
+	AcceptEnrolls   *bool   `json:"accept_enrolls,omitempty"`
+	RequireApproval *bool   `json:"require_approval,omitempty"`
+	ClientCertAuth  *bool   `json:"client_cert_auth,omitempty"`
 }
 
 // EnvConfigResponse is the GET /api/v1/environments/config/{env} payload —
@@ -427,6 +487,12 @@ type EnvConfigResponse struct {
 	Decorators string `json:"decorators"`
 	ATC        string `json:"atc"`
 	Flags      string `json:"flags"`
+	// Revision of the assembled configuration, when it changed
+	Revision uint `json:"revision,omitempty"`
+	// RolloutID of the rollout started with the revision
+	RolloutID uint `json:"rollout_id,omitempty"`
+	// Warnings of the validation of options, packs and ATC
+	Warnings []string `json:"warnings,omitempty"`
 }
 
 // EnvConfigPatchRequest is the body for PATCH /api/v1/environments/config/{env}.
@@ -440,6 +506,86 @@ type EnvConfigPatchRequest struct {
 	Decorators *string `json:"decorators,omitempty"`
 	ATC        *string `json:"atc,omitempty"`
 	Flags      *string `json:"flags,omitempty"`
+	// Reason stored with the configuration revision
+	Reason string `json:"reason,omitempty"`
+	// Force writes options, packs and ATC that fail validation
+	Force bool `json:"force,omitempty"`
+	// Rollout stages the new revision to a share of the nodes. The rollout
+	// is created in the same transaction as the configuration, so nodes keep
+	// the previous revision until it exists.
+	Rollout *EnvRolloutStages `json:"rollout,omitempty"`
+}
+
+// EnvRolloutStages are the stages and thresholds of a rollout started with a
+// configuration patch, zero values use the defaults
+type EnvRolloutStages struct {
+	FromRevision uint    `json:"from_revision"`
+	CanaryTag    string  `json:"canary_tag"`
+	Percent      int     `json:"percent"`
+	StepPercent  int     `json:"step_percent"`
+	StepMinutes  int     `json:"step_minutes"`
+	MaxErrorRate float64 `json:"max_error_rate"`
+	MinAdoption  float64 `json:"min_adoption"`
+}
+
+// EnvRollbackRequest is the body for POST /api/v1/environments/rollback/{env}
+type EnvRollbackRequest struct {
+	Revision uint   `json:"revision"`
+	Reason   string `json:"reason"`
+}
+
+// EnvRolloutRequest is the body for POST /api/v1/environments/rollout/{env}.
+// Action is one of halt, resume, promote or abort. Rollouts are started by
+// the rollout of EnvConfigPatchRequest.
+type EnvRolloutRequest struct {
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+}
+
+// EnvEnrollTokenRequest is the body for POST /api/v1/environments/tokens/{env}.
+// Zero expiration hours and maximum uses mean the token never expires and
+// can enroll any number of nodes.
+type EnvEnrollTokenRequest struct {
+	Name        string   `json:"name"`
+	ExpireHours int      `json:"expire_hours"`
+	MaxUses     int      `json:"max_uses"`
+	CIDRs       []string `json:"cidrs"`
+	Tags        []string `json:"tags"`
+}
+
+// EnvEnrollTokenResponse is the response for POST /api/v1/environments/tokens/{env},
+// the only time the secret of the token is returned
+type EnvEnrollTokenResponse struct {
+	Name      string    `json:"name"`
+	Secret    string    `json:"secret"`
+	ExpiresAt time.Time `json:"expires_at"`
+	MaxUses   int       `json:"max_uses"`
+}
+
+// EnvClientCertRequest is the body for POST /api/v1/environments/certs/{env}.
+// The common name is the host identifier of the node using the certificate,
+// zero validity days issue it for a year.
+type EnvClientCertRequest struct {
+	CommonName   string `json:"common_name"`
+	ValidityDays int    `json:"validity_days"`
+}
+
+// EnvClientCertResponse is the response for POST /api/v1/environments/certs/{env},
+// the only time the key of the certificate is returned
+type EnvClientCertResponse struct {
+	Serial      string    `json:"serial"`
+	CommonName  string    `json:"common_name"`
+	NotAfter    time.Time `json:"not_after"`
+	Certificate string    `json:"certificate"`
+	Key         string    `json:"key"`
+	CA          string    `json:"ca"`
+}
+
+// EnvRevisionDiffResponse is the response for GET /api/v1/environments/diff/{env}
+type EnvRevisionDiffResponse struct {
+	From uint   `json:"from"`
+	To   uint   `json:"to"`
+	Diff string `json:"diff"`
 }
 
 // EnvIntervalsPatchRequest is the body for PATCH /api/v1/environments/intervals/{env}.
@@ -510,25 +656,27 @@ type SettingPatchRequest struct {
 // embed the secret. The full storage struct is admin-only via
 // EnvironmentAdminHandler.
 type TLSEnvironmentView struct {
-	ID             uint      `json:"id"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
-	UUID           string    `json:"uuid"`
-	Name           string    `json:"name"`
-	Hostname       string    `json:"hostname"`
-	Type           string    `json:"type"`
-	Icon           string    `json:"icon"`
-	DebugHTTP      bool      `json:"debug_http"`
-	ConfigTLS      bool      `json:"config_tls"`
-	ConfigInterval int       `json:"config_interval"`
-	LoggingTLS     bool      `json:"logging_tls"`
-	LogInterval    int       `json:"log_interval"`
-	QueryTLS       bool      `json:"query_tls"`
-	QueryInterval  int       `json:"query_interval"`
-	CarvesTLS      bool      `json:"carves_tls"`
-	AcceptEnrolls  bool      `json:"accept_enrolls"`
-	EnrollExpire   time.Time `json:"enroll_expire"`
-	RemoveExpire   time.Time `json:"remove_expire"`
+	ID              uint      `json:"id"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	UUID            string    `json:"uuid"`
+	Name            string    `json:"name"`
+	Hostname        string    `json:"hostname"`
+	Type            string    `json:"type"`
+	Icon            string    `json:"icon"`
+	DebugHTTP       bool      `json:"debug_http"`
+	ConfigTLS       bool      `json:"config_tls"`
+	ConfigInterval  int       `json:"config_interval"`
+	LoggingTLS      bool      `json:"logging_tls"`
+	LogInterval     int       `json:"log_interval"`
+	QueryTLS        bool      `json:"query_tls"`
+	QueryInterval   int       `json:"query_interval"`
+	CarvesTLS       bool      `json:"carves_tls"`
+	AcceptEnrolls   bool      `json:"accept_enrolls"`
+	RequireApproval bool      `json:"require_approval"`
+	ClientCertAuth  bool      `json:"client_cert_auth"`
+	EnrollExpire    time.Time `json:"enroll_expire"`
+	RemoveExpire    time.Time `json:"remove_expire"`
 }
 
 // AdminUserView is the PII-minimized projection of an AdminUser for
@@ -560,3 +708,120 @@ type AdminUserView struct {
 	// badge alongside the existing admin/service labels.
 	AuthSource string `json:"auth_source"`
 }
+
+// Types of the events in a node timeline
+const (
+	TimelineEnroll     = "enroll"
+	TimelineReenroll   = "reenroll"
+	TimelineArchive    = "archive"
+	TimelineMetadata   = "metadata"
+	TimelineQuery      = "query"
+	TimelineCarve      = "carve"
+	TimelineConsole    = "console"
+	TimelineTag        = "tag"
+	TimelineUntag      = "untag"
+	TimelineMove       = "move"
+	TimelineRevoke     = "revoke"
+	TimelineQuarantine = "quarantine"
+	TimelineRelease    = "release"
+)
+
+// NodeTimelineEvent is one entry of the timeline returned by
+// GET /api/v1/nodes/{env}/node/{node}/timeline, oldest first.
+type NodeTimelineEvent struct {
+	Time    time.Time         `json:"time"`
+	Type    string            `json:"type"`
+	Summary string            `json:"summary"`
+	Actor   string            `json:"actor,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// StalePolicyRequest is the body for PUT /api/v1/nodes/{env}/stale/policy.
+// Omitted fields keep their current value and zero disables the step.
+type StalePolicyRequest struct {
+	ArchiveDays *int64 `json:"archive_days,omitempty"`
+	PurgeDays   *int64 `json:"purge_days,omitempty"`
+}
+
+// ApiNodeMergeRequest is the body for POST /api/v1/nodes/{env}/duplicates/merge
+type ApiNodeMergeRequest struct {
+	NodeIDs []uint `json:"node_ids"`
+}
+
+// ApiNodeMergeResponse is the outcome of merging duplicate nodes
+type ApiNodeMergeResponse struct {
+	Kept     string   `json:"kept"`
+	Archived []string `json:"archived"`
+}
+
+// ApiNodeAttributeRequest is the body for PUT /api/v1/nodes/{env}/node/{node}/attributes
+type ApiNodeAttributeRequest struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// ApiNodeMoveRequest is the body for POST /api/v1/nodes/{env}/move
+type ApiNodeMoveRequest struct {
+	UUIDs       []string `json:"uuid_list"`
+	Environment string   `json:"environment"`
+}
+
+// ApiNodeRevokeRequest is the body for POST /api/v1/nodes/{env}/revoke. Nodes
+// are picked by UUIDs, by tag or all the nodes of the environment.
+type ApiNodeRevokeRequest struct {
+	UUIDs        []string `json:"uuid_list"`
+	Tag          string   `json:"tag"`
+	All          bool     `json:"all"`
+	Reason       string   `json:"reason"`
+	RotateSecret bool     `json:"rotate_secret"`
+}
+
+// ApiNodeRevokeResponse is the response for POST /api/v1/nodes/{env}/revoke
+type ApiNodeRevokeResponse struct {
+	Revoked       []string `json:"revoked"`
+	SecretRotated bool     `json:"secret_rotated"`
+}
+
+// ApiNodeQuarantineRequest is the body for POST /api/v1/nodes/{env}/node/{node}/quarantine
+type ApiNodeQuarantineRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ApiApprovalRuleRequest is the body for POST /api/v1/nodes/{env}/approvals/rules
+type ApiApprovalRuleRequest struct {
+	Type    string `json:"type"`
+	Pattern string `json:"pattern"`
+}
+
+// ApiApprovalRuleResponse is the response for POST /api/v1/nodes/{env}/approvals/rules
+// with the UUIDs of the pending nodes approved by the new rule
+type ApiApprovalRuleResponse struct {
+	Rule     nodes.ApprovalRule `json:"rule"`
+	Approved []string           `json:"approved"`
+}
+
+// ApiConfigOverlayRequest is the body for PUT /api/v1/nodes/{env}/overlays/{name}
+type ApiConfigOverlayRequest struct {
+	TargetType    string          `json:"target_type"`
+	Target        string          `json:"target"`
+	Priority      int             `json:"priority"`
+	Configuration json.RawMessage `json:"configuration"`
+	// Force writes options, packs and ATC that fail validation
+	Force bool `json:"force,omitempty"`
+}
+
+// ApiConfigOverlayResponse is the response for PUT /api/v1/nodes/{env}/overlays/{name}
+type ApiConfigOverlayResponse struct {
+	environments.ConfigOverlay
+	// Warnings of the validation of options, packs and ATC
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// ApiNodeConfigResponse is the response for GET /api/v1/nodes/{env}/node/{node}/config
+type ApiNodeConfigResponse struct {
+	Overlays      []string        `json:"overlays"`
+	Quarantined   bool            `json:"quarantined"`
+	Approval      string          `json:"approval,omitempty"`
+	Configuration json.RawMessage `json:"configuration"`
+}
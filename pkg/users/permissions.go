@@ -388,6 +388,51 @@ func (m *UserManager) GetAllPermissions(username string) ([]UserPermission, erro
 	return perms, nil
 }
 
+// CopyEnvPermissionsTx to grant the permissions of every user in an
+// environment in another one within a transaction, replacing those they had
+// there. Returns how many users got permissions.
+func (m *UserManager) CopyEnvPermissionsTx(tx *gorm.DB, from, to environments.TLSEnvironment, granted string) (int, error) {
+	var perms []UserPermission
+	if err := tx.Where("environment = ?", from.UUID).Order("username, access_type").Find(&perms).Error; err != nil {
+		return 0, fmt.Errorf("error getting permissions %w", err)
+	}
+	usernames := make(map[string]bool)
+	for _, p := range perms {
+		if !usernames[p.Username] {
+			if err := tx.Unscoped().Where("username = ? AND environment = ?", p.Username, to.UUID).Delete(&UserPermission{}).Error; err != nil {
+				return 0, fmt.Errorf("error copying permissions %w", err)
+			}
+			usernames[p.Username] = true
+		}
+		dup := m.GenUserPermission(p.Username, granted, to.UUID, p.AccessType, p.AccessValue)
+		dup.EnvironmentID = to.ID
+		if err := tx.Create(&dup).Error; err != nil {
+			return 0, fmt.Errorf("error copying permissions %w", err)
+		}
+	}
+	return len(usernames), nil
+}
+
+// GrantEnvAccessTx to give a user full access to an environment within a
+// transaction, unless the user already has permissions in it
+func (m *UserManager) GrantEnvAccessTx(tx *gorm.DB, username string, env environments.TLSEnvironment, granted string) error {
+	var count int64
+	if err := tx.Model(&UserPermission{}).Where("username = ? AND environment = ?", username, env.UUID).Count(&count).Error; err != nil {
+		return fmt.Errorf("error getting permissions %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+	access := m.GenEnvUserAccess([]string{env.UUID}, true, true, true, true)
+	for _, p := range m.GenPermissions(username, granted, access) {
+		p.EnvironmentID = env.ID
+		if err := tx.Create(&p).Error; err != nil {
+			return fmt.Errorf("Create UserPermission %w", err)
+		}
+	}
+	return nil
+}
+
 // DeleteEnvPermissions to delete all permissions by username and environment
 func (m *UserManager) DeleteEnvPermissions(username, environment string) error {
 	if !m.Exists(username) {
@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"net"
@@ -81,6 +82,10 @@ const XRealIP string = "X-Real-IP"
 
 const XForwardedFor string = "X-Forwarded-For"
 
+// XClientCert for header key, with the URL escaped PEM client certificate
+// forwarded by proxies terminating TLS
+const XClientCert string = "X-Client-Cert"
+
 // Authorization for header key
 const Authorization string = "Authorization"
 
@@ -315,6 +320,37 @@ func GetIP(r *http.Request) string {
 	return remoteIP(r)
 }
 
+// ClientCertificate returns the certificate presented by the client, from
+// the TLS connection or, when the peer is a trusted proxy terminating TLS,
+// from the X-Client-Cert header (like nginx $ssl_client_escaped_cert).
+// It returns nil when there is no certificate. The certificate is not
+// verified, callers must verify it against the CA they trust.
+func ClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0]
+	}
+	if !isFromTrustedProxy(r) {
+		return nil
+	}
+	header := r.Header.Get(XClientCert)
+	if header == "" {
+		return nil
+	}
+	certPEM, err := url.PathUnescape(header)
+	if err != nil {
+		return nil
+	}
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil
+	}
+	return cert
+}
+
 // HTTPResponse - Helper to send HTTP response
 func HTTPResponse(w http.ResponseWriter, cType string, code int, data interface{}) {
 	if cType != "" {
@@ -1,8 +1,16 @@
 package utils
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 
@@ -212,6 +220,29 @@ func TestSetTrustedProxiesIgnoresInvalid(t *testing.T) {
 	}
 }
 
+// TestClientCertificateFromTrustedProxy — the forwarded client certificate
+// header is only honored from trusted proxies.
+func TestClientCertificateFromTrustedProxy(t *testing.T) {
+	t.Cleanup(func() { SetTrustedProxies(nil) })
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(42), Subject: pkix.Name{CommonName: "node"}}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.5:12345"
+	req.Header.Set(XClientCert, url.PathEscape(string(certPEM)))
+	assert.Nil(t, ClientCertificate(req))
+	SetTrustedProxies([]string{"10.0.0.0/8"})
+	cert := ClientCertificate(req)
+	if assert.NotNil(t, cert) {
+		assert.Equal(t, "node", cert.Subject.CommonName)
+	}
+	req.Header.Set(XClientCert, "garbage")
+	assert.Nil(t, ClientCertificate(req))
+}
+
 // TestDebugHTTPDumpWithBody verifies the bytes-based dump helper used on
 // the per-host-filtered debug path. It must serialize a request whose body
 // has already been read into a []byte, include the body when showBody is